@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"flow-control/internal/analysis"
+
+	"github.com/spf13/cobra"
+)
+
+// auditReport is the result of audit, as printed with -o json
+type auditReport struct {
+	Module   string            `json:"module"`
+	Packages int               `json:"packages"`
+	Cycles   [][]string        `json:"cycles"`
+	Unused   []analysis.Unused `json:"unused"`
+}
+
+func newAuditCmd() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "audit [dir]",
+		Short: "Find unused code and import cycles in a Go module",
+		Long: "Read the Go module in dir, such as a project with custom nodes, and list\n" +
+			"its import cycles and the functions, types, variables, constants and\n" +
+			"packages that nothing in the module uses. Uses from tests count. The\n" +
+			"module is read without type checking, so names that only match an unused\n" +
+			"declaration by chance hide it. Exits with 1 if anything was found.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != outputText && output != outputJSON {
+				return fmt.Errorf("invalid output format %q: must be %s or %s", output, outputText, outputJSON)
+			}
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			return audit(cmd.OutOrStdout(), dir, output)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", outputText, "Output format (text, json)")
+	return cmd
+}
+
+// audit prints the import cycles and unused code of the module in dir
+func audit(out io.Writer, dir, output string) error {
+	g, err := analysis.BuildGraph(dir)
+	if err != nil {
+		return err
+	}
+	report := auditReport{
+		Module:   g.Module,
+		Packages: len(g.Packages),
+		Cycles:   analysis.Cycles(g),
+		Unused:   analysis.FindUnused(g),
+	}
+	if report.Cycles == nil {
+		report.Cycles = [][]string{}
+	}
+	if report.Unused == nil {
+		report.Unused = []analysis.Unused{}
+	}
+
+	if output == outputJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		for _, cycle := range report.Cycles {
+			fmt.Fprintf(out, "import cycle: %s\n", strings.Join(cycle, ", "))
+		}
+		for _, unused := range report.Unused {
+			if unused.Kind == analysis.KindPackage {
+				fmt.Fprintf(out, "%s: unused package %s\n", unused.File, unused.Package)
+				continue
+			}
+			fmt.Fprintf(out, "%s:%d: unused %s %s\n", unused.File, unused.Line, unused.Kind, unused.Name)
+		}
+		fmt.Fprintf(out, "%d packages in %s, %d import cycles, %d unused\n",
+			report.Packages, report.Module, len(report.Cycles), len(report.Unused))
+	}
+
+	if len(report.Cycles) > 0 || len(report.Unused) > 0 {
+		return errProblems
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"flow-control/internal/metrics"
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/secrets"
+	"flow-control/internal/types"
+
+	"github.com/spf13/cobra"
+)
+
+// benchOptions holds the flags of the bench command
+type benchOptions struct {
+	flow     string
+	input    string
+	output   string
+	stub     bool
+	rate     float64
+	duration time.Duration
+	messages int
+	queue    int
+	faults   string
+	params   map[string]string
+	limits   benchLimits
+	// lookupURLs and lookupDBs are the endpoints Enrich nodes may reach
+	lookupURLs []string
+	lookupDBs  map[string]string
+}
+
+// benchLimits are the thresholds that make a benchmark fail, each disabled
+// when zero or negative
+type benchLimits struct {
+	minThroughput float64
+	maxP50        time.Duration
+	maxP99        time.Duration
+	maxErrorRate  float64
+}
+
+// benchResult is the outcome of a benchmark, as printed with -o json.
+// Durations are in milliseconds.
+type benchResult struct {
+	Flow     string  `json:"flow"`
+	Messages int     `json:"messages"`
+	Failed   int     `json:"failed"`
+	Outputs  int     `json:"outputs"`
+	Seconds  float64 `json:"seconds"`
+	// Throughput is the number of messages processed per second
+	Throughput float64      `json:"throughput"`
+	Latency    benchLatency `json:"latency_ms"`
+	Queue      benchQueue   `json:"queue"`
+	Nodes      []benchNode  `json:"nodes"`
+	// Violations lists the thresholds the run exceeded
+	Violations []string `json:"violations"`
+}
+
+// benchLatency summarizes the time from generating messages until the flow
+// processed them, including the time they waited in the queue
+type benchLatency struct {
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+	Mean float64 `json:"mean"`
+}
+
+// benchQueue summarizes the depth of the queue between the generator and
+// the flow, sampled whenever a message was queued
+type benchQueue struct {
+	Capacity int     `json:"capacity"`
+	Max      int     `json:"max"`
+	Mean     float64 `json:"mean"`
+}
+
+// benchNode summarizes the work of one node
+type benchNode struct {
+	ID       string  `json:"id"`
+	Messages int     `json:"messages"`
+	Errors   int     `json:"errors"`
+	P50      float64 `json:"p50_ms"`
+	P99      float64 `json:"p99_ms"`
+	Mean     float64 `json:"mean_ms"`
+}
+
+func newBenchCmd() *cobra.Command {
+	var opts benchOptions
+	cmd := &cobra.Command{
+		Use:   "bench <file>",
+		Short: "Measure the throughput and latency of a flow",
+		Long: "Run a flow in process on synthetic messages, generated at --rate messages per\n" +
+			"second or as fast as the flow takes them, for --duration or until --messages\n" +
+			"were sent, and report its throughput, latency percentiles, queue depth and the\n" +
+			"work of each node. Messages are {\"seq\": N} unless --input names a sample file,\n" +
+			"whose lines are sent in turn, and --faults injects failures into the flow.\n" +
+			"The --min-throughput, --max-p50, --max-p99 and\n" +
+			"--max-error-rate thresholds make it exit with 1 when exceeded, to catch\n" +
+			"regressions in CI.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.output != outputText && opts.output != outputJSON {
+				return fmt.Errorf("invalid output format %q: must be %s or %s", opts.output, outputText, outputJSON)
+			}
+			if opts.duration <= 0 && opts.messages <= 0 {
+				return fmt.Errorf("--duration or --messages must be positive")
+			}
+			if opts.queue < 1 {
+				return fmt.Errorf("invalid queue size %d: must be at least 1", opts.queue)
+			}
+			if !cmd.Flags().Changed("max-error-rate") {
+				opts.limits.maxErrorRate = -1
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer stop()
+			return benchFlow(ctx, cmd.OutOrStdout(), cmd.ErrOrStderr(), args[0], opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.flow, "flow", "", "Flow to run when the file defines several")
+	cmd.Flags().StringVarP(&opts.input, "input", "i", "", "Sample file with one input message per line")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", outputText, "Output format (text, json)")
+	cmd.Flags().BoolVar(&opts.stub, "stub", false, "Replace nodes of unknown types with passthrough nodes")
+	cmd.Flags().Float64Var(&opts.rate, "rate", 0, "Messages generated per second, 0 for as many as the flow takes")
+	cmd.Flags().DurationVar(&opts.duration, "duration", 10*time.Second, "How long to generate messages, 0 for no limit")
+	cmd.Flags().IntVar(&opts.messages, "messages", 0, "Number of messages to generate, 0 for no limit")
+	cmd.Flags().IntVar(&opts.queue, "queue", 1000, "Capacity of the queue between the generator and the flow")
+	cmd.Flags().StringVar(&opts.faults, "faults", "", faultsUsage)
+	cmd.Flags().StringToStringVar(&opts.params, "param", nil, paramUsage)
+	cmd.Flags().StringArrayVar(&opts.lookupURLs, "lookup-url", nil, lookupURLUsage)
+	cmd.Flags().StringToStringVar(&opts.lookupDBs, "lookup-db", nil, lookupDBUsage)
+	cmd.Flags().Float64Var(&opts.limits.minThroughput, "min-throughput", 0, "Fail below this many messages per second")
+	cmd.Flags().DurationVar(&opts.limits.maxP50, "max-p50", 0, "Fail when the median latency exceeds this")
+	cmd.Flags().DurationVar(&opts.limits.maxP99, "max-p99", 0, "Fail when the 99th percentile latency exceeds this")
+	cmd.Flags().Float64Var(&opts.limits.maxErrorRate, "max-error-rate", 0, "Fail when more than this fraction of messages fail, such as 0.01")
+	return cmd
+}
+
+// benchFlow loads the flow in file, runs the benchmark and prints the
+// result
+func benchFlow(ctx context.Context, out, errOut io.Writer, file string, opts benchOptions) error {
+	log := newLogger(errOut)
+	graph, flows, err := loadFlow(errOut, file, opts.flow, log)
+	if err != nil {
+		return err
+	}
+
+	faults, err := engine.ParseFaults(opts.faults)
+	if err != nil {
+		return err
+	}
+	lookups, err := parseLookups(opts.lookupURLs, opts.lookupDBs)
+	if err != nil {
+		return err
+	}
+	var samples []json.RawMessage
+	if opts.input != "" {
+		if samples, err = readSamples(opts.input); err != nil {
+			return err
+		}
+	}
+
+	recorder := &nodeRecorder{Registry: metrics.NewRegistry(), latencies: map[string][]time.Duration{}}
+	outputs := 0
+	e, err := engine.New(graph, engine.NewRegistry(), log, engine.Options{
+		Metrics:     recorder,
+		StubUnknown: opts.stub,
+		Params:      opts.params,
+		Secrets:     secrets.NewEnvProvider("SECRET_", os.LookupEnv),
+		Faults:      faults,
+		Flows:       flows,
+		Lookups:     lookups,
+		OnOutput:    func(string, types.Message) { outputs++ },
+	})
+	if err != nil {
+		fmt.Fprintf(errOut, "%s: %v\n", file, err)
+		return errProblems
+	}
+
+	result, err := runBench(ctx, e, samples, opts)
+	if err != nil {
+		return err
+	}
+	result.Flow = graph.FlowID
+	result.Outputs = outputs
+	result.Nodes = recorder.nodeStats(graph)
+	result.Violations = opts.limits.check(result)
+
+	if opts.output == outputJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	} else {
+		printBench(out, result)
+	}
+	if len(result.Violations) > 0 {
+		return errProblems
+	}
+	return nil
+}
+
+// runBench feeds generated messages to e through a queue and measures how
+// long each took to get through. Interrupting ctx ends the run early with
+// the results so far.
+func runBench(ctx context.Context, e *engine.Engine, samples []json.RawMessage, opts benchOptions) (*benchResult, error) {
+	genCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	if opts.duration > 0 {
+		genCtx, stop = context.WithTimeout(genCtx, opts.duration)
+		defer stop()
+	}
+
+	if err := e.Start(context.Background()); err != nil {
+		return nil, err
+	}
+
+	queue := make(chan types.Message, opts.queue)
+	var depthMax, depthSamples, depthTotal int
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(queue)
+		start := time.Now()
+		for n := 1; opts.messages <= 0 || n <= opts.messages; n++ {
+			if opts.rate > 0 {
+				due := start.Add(time.Duration(float64(n-1) / opts.rate * float64(time.Second)))
+				select {
+				case <-time.After(time.Until(due)):
+				case <-genCtx.Done():
+					return
+				}
+			}
+			msg := types.Message{
+				ID:       strconv.Itoa(n),
+				Data:     syntheticData(samples, n),
+				Metadata: types.MessageMetadata{Timestamp: time.Now(), Source: "bench"},
+			}
+			select {
+			case queue <- msg:
+			case <-genCtx.Done():
+				return
+			}
+			depth := len(queue)
+			depthMax = max(depthMax, depth)
+			depthTotal += depth
+			depthSamples++
+		}
+	}()
+
+	result := &benchResult{Queue: benchQueue{Capacity: opts.queue}}
+	var latencies []time.Duration
+	start := time.Now()
+	for msg := range queue {
+		if err := e.Process(context.Background(), msg); err != nil {
+			result.Failed++
+		}
+		latencies = append(latencies, time.Since(msg.Metadata.Timestamp))
+	}
+	elapsed := time.Since(start)
+	wg.Wait()
+
+	if err := e.Stop(context.Background()); err != nil {
+		return nil, err
+	}
+
+	result.Messages = len(latencies)
+	result.Seconds = elapsed.Seconds()
+	if elapsed > 0 {
+		result.Throughput = float64(result.Messages) / elapsed.Seconds()
+	}
+	result.Latency = summarizeLatency(latencies)
+	result.Queue.Max = depthMax
+	if depthSamples > 0 {
+		result.Queue.Mean = float64(depthTotal) / float64(depthSamples)
+	}
+	return result, nil
+}
+
+// syntheticData returns the data of the nth generated message: the samples
+// in turn, or {"seq": n} without samples
+func syntheticData(samples []json.RawMessage, n int) json.RawMessage {
+	if len(samples) > 0 {
+		return samples[(n-1)%len(samples)]
+	}
+	return json.RawMessage(`{"seq":` + strconv.Itoa(n) + `}`)
+}
+
+// readSamples reads the non-empty lines of the sample file at path
+func readSamples(path string) ([]json.RawMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input: %w", err)
+	}
+	defer f.Close()
+
+	var samples []json.RawMessage
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			samples = append(samples, lineData(line))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no messages in %s", path)
+	}
+	return samples, nil
+}
+
+// summarizeLatency returns the percentiles of latencies in milliseconds
+func summarizeLatency(latencies []time.Duration) benchLatency {
+	if len(latencies) == 0 {
+		return benchLatency{}
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(q float64) float64 {
+		i := int(math.Ceil(q*float64(len(sorted)))) - 1
+		return milliseconds(sorted[max(i, 0)])
+	}
+	var total time.Duration
+	for _, latency := range sorted {
+		total += latency
+	}
+	return benchLatency{
+		P50:  percentile(0.5),
+		P90:  percentile(0.9),
+		P99:  percentile(0.99),
+		Max:  milliseconds(sorted[len(sorted)-1]),
+		Mean: milliseconds(total / time.Duration(len(sorted))),
+	}
+}
+
+// nodeRecorder records the metrics of the engine, keeping the exact
+// latencies of each node, which histogram buckets are too coarse for
+type nodeRecorder struct {
+	*metrics.Registry
+	latencies map[string][]time.Duration
+}
+
+// Observe records value and, for node latencies, keeps it by node
+func (r *nodeRecorder) Observe(name string, value float64, labels map[string]string) {
+	r.Registry.Observe(name, value, labels)
+	if id, ok := labels["node_id"]; ok && name == metrics.FlowLatencyMetric {
+		r.latencies[id] = append(r.latencies[id], time.Duration(value*float64(time.Second)))
+	}
+}
+
+// nodeStats returns the work of each node of graph
+func (r *nodeRecorder) nodeStats(graph *engine.Graph) []benchNode {
+	nodes := make([]benchNode, 0, len(graph.Nodes))
+	for _, gn := range graph.Nodes {
+		labels := map[string]string{"node_id": gn.Config.ID}
+		messages, _ := r.Value(metrics.FlowMessagesMetric, labels)
+		failures, _ := r.Value(metrics.FlowErrorsMetric, labels)
+		latency := summarizeLatency(r.latencies[gn.Config.ID])
+		nodes = append(nodes, benchNode{
+			ID:       gn.Config.ID,
+			Messages: int(messages),
+			Errors:   int(failures),
+			P50:      latency.P50,
+			P99:      latency.P99,
+			Mean:     latency.Mean,
+		})
+	}
+	return nodes
+}
+
+// check returns the thresholds that result exceeds
+func (l benchLimits) check(result *benchResult) []string {
+	violations := []string{}
+	if l.minThroughput > 0 && result.Throughput < l.minThroughput {
+		violations = append(violations, fmt.Sprintf("throughput %.1f msg/s is below %.1f", result.Throughput, l.minThroughput))
+	}
+	if l.maxP50 > 0 && result.Latency.P50 > milliseconds(l.maxP50) {
+		violations = append(violations, fmt.Sprintf("p50 latency %.3fms exceeds %s", result.Latency.P50, l.maxP50))
+	}
+	if l.maxP99 > 0 && result.Latency.P99 > milliseconds(l.maxP99) {
+		violations = append(violations, fmt.Sprintf("p99 latency %.3fms exceeds %s", result.Latency.P99, l.maxP99))
+	}
+	if l.maxErrorRate >= 0 && result.Messages > 0 {
+		if rate := float64(result.Failed) / float64(result.Messages); rate > l.maxErrorRate {
+			violations = append(violations, fmt.Sprintf("error rate %.4f exceeds %.4f", rate, l.maxErrorRate))
+		}
+	}
+	return violations
+}
+
+// printBench writes result as text
+func printBench(w io.Writer, result *benchResult) {
+	fmt.Fprintf(w, "%s: %d messages in %.2fs, %.1f msg/s, %d failed, %d outputs\n",
+		result.Flow, result.Messages, result.Seconds, result.Throughput, result.Failed, result.Outputs)
+	fmt.Fprintf(w, "latency  p50 %.3fms  p90 %.3fms  p99 %.3fms  max %.3fms  mean %.3fms\n",
+		result.Latency.P50, result.Latency.P90, result.Latency.P99, result.Latency.Max, result.Latency.Mean)
+	fmt.Fprintf(w, "queue    max %d of %d, mean %.1f\n", result.Queue.Max, result.Queue.Capacity, result.Queue.Mean)
+	for _, node := range result.Nodes {
+		fmt.Fprintf(w, "node %-12s %d messages, %d errors, p50 %.3fms, p99 %.3fms, mean %.3fms\n",
+			node.ID, node.Messages, node.Errors, node.P50, node.P99, node.Mean)
+	}
+	for _, violation := range result.Violations {
+		fmt.Fprintf(w, "FAIL: %s\n", violation)
+	}
+}
+
+// milliseconds returns d in fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/runtime/flowdiff"
+
+	"github.com/spf13/cobra"
+)
+
+func newDiffCmd() *cobra.Command {
+	var flow, output string
+	cmd := &cobra.Command{
+		Use:   "diff <old> <new>",
+		Short: "Compare the structure of two flow files",
+		Long: "Compare two versions of a flow and list the nodes, connections and\n" +
+			"settings that were added, removed or changed. The exit code is 1 when\n" +
+			"the flows differ.",
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != outputText && output != outputJSON {
+				return fmt.Errorf("invalid output format %q: must be %s or %s", output, outputText, outputJSON)
+			}
+			errOut := cmd.ErrOrStderr()
+			log := newLogger(errOut)
+
+			// Like diff, exit code 1 means the inputs differ, so invalid
+			// files are failures
+			var graphs [2]*engine.Graph
+			for i, file := range args {
+				graph, err := loadGraph(file, flow, log, errOut)
+				if errors.Is(err, errProblems) {
+					return fmt.Errorf("%s is not a valid flow file", file)
+				}
+				if err != nil {
+					return err
+				}
+				graphs[i] = graph
+			}
+
+			diff := flowdiff.Compare(graphs[0], graphs[1])
+			w := cmd.OutOrStdout()
+			var err error
+			if output == outputJSON {
+				enc := json.NewEncoder(w)
+				enc.SetIndent("", "  ")
+				err = enc.Encode(diff)
+			} else {
+				err = diff.WriteText(w)
+			}
+			if err != nil {
+				return err
+			}
+			if !diff.Empty() {
+				return errProblems
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&flow, "flow", "", "Flow to compare when the files define several")
+	cmd.Flags().StringVarP(&output, "output", "o", outputText, "Output format (text, json)")
+	return cmd
+}
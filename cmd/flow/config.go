@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"flow-control/internal/config"
+	"flow-control/internal/types"
+
+	"github.com/spf13/cobra"
+)
+
+// configCheck is the result of config check, as printed with -o json
+type configCheck struct {
+	File     string           `json:"file"`
+	Profile  string           `json:"profile,omitempty"`
+	Config   *config.Config   `json:"config"`
+	Settings []config.Setting `json:"settings"`
+}
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Work with Flow Control server configuration files",
+	}
+	cmd.AddCommand(newConfigCheckCmd())
+	return cmd
+}
+
+func newConfigCheckCmd() *cobra.Command {
+	var output, profile string
+	var ignoreEnv bool
+	cmd := &cobra.Command{
+		Use:   "check [file|dir]",
+		Short: "Validate a server config file and print the merged configuration",
+		Long: "Load a JSON, YAML or TOML server config file the way flowcontrol does, on top\n" +
+			"of the defaults and with the environment variables applied, and print every\n" +
+			"setting with the file line, environment variable or default it came from.\n" +
+			"The file defaults to $CONFIG_FILE and the profile applied with --env to\n" +
+			"$CONFIG_ENV. Secrets are redacted. Exits with 1 if the configuration is invalid.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != outputText && output != outputJSON {
+				return fmt.Errorf("invalid output format %q: must be %s or %s", output, outputText, outputJSON)
+			}
+			file := os.Getenv("CONFIG_FILE")
+			if len(args) > 0 {
+				file = args[0]
+			}
+			var overrides []config.Override
+			if !ignoreEnv {
+				overrides = append(overrides, config.FromEnv(os.LookupEnv))
+			}
+			return checkConfig(cmd.OutOrStdout(), cmd.ErrOrStderr(), file, profile, output, overrides)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", outputText, "Output format (text, json)")
+	cmd.Flags().StringVar(&profile, "env", os.Getenv("CONFIG_ENV"), "Config profile to apply, such as staging or prod")
+	cmd.Flags().BoolVar(&ignoreEnv, "ignore-env", false, "Ignore the environment variables that override settings")
+	return cmd
+}
+
+// checkConfig loads file with profile and overrides and prints the
+// settings, or the problems that make it invalid
+func checkConfig(out, errOut io.Writer, file, profile, output string, overrides []config.Override) error {
+	cfg, err := config.LoadProfile(file, profile, &warningLogger{w: errOut}, overrides...)
+	var invalid *config.ValidationError
+	if errors.As(err, &invalid) {
+		fmt.Fprintf(errOut, "%s: %v\n", file, invalid)
+		return errProblems
+	}
+	if err != nil {
+		return err
+	}
+
+	settings := cfg.Settings()
+	if output == outputJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(configCheck{File: file, Profile: profile, Config: cfg.Redacted(), Settings: settings})
+	}
+	for _, setting := range settings {
+		value, err := json.Marshal(setting.Value)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", setting.Field, err)
+		}
+		fmt.Fprintf(out, "%s = %s  (%s)\n", setting.Field, value, setting.Source)
+	}
+	return nil
+}
+
+// warningLogger prints the warnings of loading a configuration, such as
+// unknown settings, and drops other entries
+type warningLogger struct {
+	w io.Writer
+}
+
+func (l *warningLogger) Debug(msg string, fields types.Fields)            {}
+func (l *warningLogger) Info(msg string, fields types.Fields)             {}
+func (l *warningLogger) Error(msg string, err error, fields types.Fields) {}
+
+// Warn prints msg with its fields, other than the logging function
+func (l *warningLogger) Warn(msg string, fields types.Fields) {
+	var details []string
+	for key, value := range fields {
+		if key != "function" {
+			details = append(details, fmt.Sprintf("%s=%v", key, value))
+		}
+	}
+	sort.Strings(details)
+	fmt.Fprintf(l.w, "warning: %s %s\n", msg, strings.Join(details, " "))
+}
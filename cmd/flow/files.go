@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"flow-control/internal/logger"
+	"flow-control/internal/types"
+)
+
+// flowExt is the extension of Flow language files
+const flowExt = ".flow"
+
+// collectFiles expands paths into the .flow files they name. Directories
+// are searched recursively, skipping hidden ones; files are taken as given
+// whatever their extension. No paths means the current directory.
+func collectFiles(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if p != path && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if filepath.Ext(p) == flowExt {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to search %s: %w", path, err)
+		}
+	}
+	return files, nil
+}
+
+// newLogger creates the logger handed to the parser, which only reports
+// errors on errOut and writes no log file
+func newLogger(errOut io.Writer) types.Logger {
+	return logger.New(
+		logger.WithFile(""),
+		logger.WithLevel("error"),
+		logger.WithOutput(errOut),
+	)
+}
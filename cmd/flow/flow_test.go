@@ -0,0 +1,589 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"flow-control/internal/config"
+	"flow-control/internal/logger"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(src), 0o600))
+		return path
+	}
+	valid := write("valid.flow", `flow "orders" { node "reader" { type: "FileReader" } }`)
+	write("nested/empty.flow", `flow "empty" {}`)
+	write("notes.txt", `not a flow`)
+
+	run := func(args ...string) (int, string) {
+		var out bytes.Buffer
+		code := run(args, nil, &out, &out)
+		return code, out.String()
+	}
+
+	// Valid files pass; warnings only fail in strict mode
+	code, out := run("validate", valid)
+	require.Equal(t, 0, code)
+	require.Empty(t, out)
+	code, out = run("validate", dir)
+	require.Equal(t, 0, code)
+	require.Contains(t, out, `empty.flow:1:1: warning: flow "empty" has no nodes`)
+	code, _ = run("validate", "--strict", dir)
+	require.Equal(t, exitProblems, code)
+
+	// Errors fail and are available as JSON
+	broken := write("broken.flow", "flow \"orders\" {\n  node \"reader\" {}\n}")
+	code, out = run("validate", "-o", "json", broken)
+	require.Equal(t, exitProblems, code)
+	var diagnostics []fileDiagnostic
+	require.NoError(t, json.Unmarshal([]byte(out), &diagnostics))
+	require.Equal(t, []fileDiagnostic{{
+		File: broken, Line: 2, Column: 4, Severity: "error", Message: `node "reader" has no type`,
+	}}, diagnostics)
+
+	code, out = run("validate", write("syntax.flow", `flow "orders" {`))
+	require.Equal(t, exitProblems, code)
+	require.Contains(t, out, "unclosed")
+
+	// Missing files cannot be checked
+	code, _ = run("validate", filepath.Join(dir, "missing.flow"))
+	require.Equal(t, exitFailure, code)
+}
+
+func TestFmt(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "orders.flow")
+	src := "flow \"orders\" {\nnode \"reader\" { type: \"FileReader\" }\n}\n"
+	formatted := "flow \"orders\" {\n  node \"reader\" {\n    type: \"FileReader\"\n  }\n}\n"
+	require.NoError(t, os.WriteFile(path, []byte(src), 0o600))
+
+	run := func(args ...string) (int, string) {
+		var out bytes.Buffer
+		code := run(args, nil, &out, &out)
+		return code, out.String()
+	}
+
+	// Without flags the result is printed
+	code, out := run("fmt", path)
+	require.Equal(t, 0, code)
+	require.Equal(t, formatted, out)
+
+	// -l and -d report unformatted files and fail
+	code, out = run("fmt", "-l", dir)
+	require.Equal(t, exitProblems, code)
+	require.Equal(t, path+"\n", out)
+
+	code, out = run("fmt", "-d", path)
+	require.Equal(t, exitProblems, code)
+	require.True(t, strings.HasPrefix(out, "--- "+path+".orig\n+++ "+path+"\n@@ -1,3 +1,5 @@\n"), out)
+	require.Contains(t, out, "-node \"reader\" { type: \"FileReader\" }\n+  node \"reader\" {\n")
+
+	// -w fixes the files, after which they pass
+	code, _ = run("fmt", "-w", "-l", path)
+	require.Equal(t, 0, code)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, formatted, string(data))
+	code, out = run("fmt", "-l", "-d", dir)
+	require.Equal(t, 0, code)
+	require.Empty(t, out)
+
+	// Files that do not parse fail
+	require.NoError(t, os.WriteFile(path, []byte(`flow "orders" {`), 0o600))
+	code, out = run("fmt", "-l", path)
+	require.Equal(t, exitProblems, code)
+	require.Contains(t, out, path+":1:")
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(src), 0o600))
+		return path
+	}
+	flow := write("orders.flow", `flow "orders" {
+  node "paid" { type: "Filter" field: "status" equals: "paid" }
+  node "tag" { type: "Transform" set: { stage: "billing" } }
+}`)
+
+	run := func(stdin string, args ...string) (int, string, string) {
+		var out, errOut bytes.Buffer
+		code := run(args, strings.NewReader(stdin), &out, &errOut)
+		return code, out.String(), errOut.String()
+	}
+
+	// Messages from stdin pass through the flow
+	code, out, _ := run("{\"status\":\"paid\"}\n{\"status\":\"open\"}\n\n", "run", flow)
+	require.Equal(t, 0, code)
+	require.Equal(t, "tag: {\"stage\":\"billing\",\"status\":\"paid\"}\n", out)
+
+	// Sample files work too, with JSON output and events
+	input := write("input.ndjson", "{\"status\":\"open\"}\n{\"status\":\"paid\",\"id\":7}\n")
+	code, out, errOut := run("", "run", "-i", input, "-o", "json", "--events", flow)
+	require.Equal(t, 0, code)
+	require.Equal(t, `{"node":"tag","id":"2","data":{"id":7,"stage":"billing","status":"paid"}}`+"\n", out)
+	require.Contains(t, errOut, "flow.started")
+	require.Contains(t, errOut, "node.dropped")
+
+	// Nodes of server-only types run as passthroughs with --stub
+	sink := write("sink.flow", `flow "f" { node "k" { type: "KafkaSink" } }`)
+	code, _, errOut = run("hello\n", "run", sink)
+	require.Equal(t, exitProblems, code)
+	require.Contains(t, errOut, "KafkaSink")
+	code, out, _ = run("hello\n", "run", "--stub", sink)
+	require.Equal(t, 0, code)
+	require.Equal(t, "k: \"hello\"\n", out)
+
+	// Secret references in settings are resolved from --secrets-dir
+	secretFlow := write("secret.flow", `flow "s" { node "t" { type: "Transform" set: { token: "${secret.API_TOKEN}" } } }`)
+	secretsDir := filepath.Join(dir, "secrets")
+	require.NoError(t, os.Mkdir(secretsDir, 0o700))
+	code, _, errOut = run("{}\n", "run", "--secrets-dir", secretsDir, secretFlow)
+	require.Equal(t, exitProblems, code)
+	require.Contains(t, errOut, "API_TOKEN")
+	require.NoError(t, os.WriteFile(filepath.Join(secretsDir, "API_TOKEN"), []byte("s3cret\n"), 0o600))
+	code, out, _ = run("{}\n", "run", "--secrets-dir", secretsDir, secretFlow)
+	require.Equal(t, 0, code)
+	require.Equal(t, "t: {\"token\":\"s3cret\"}\n", out)
+
+	// Call nodes run the other flows of the file
+	calls := write("calls.flow", `flow "orders" { node "bill" { type: "Call" target: "billing" } }
+flow "billing" { node "tag" { type: "Transform" set: { billed: 1 } } }`)
+	code, out, _ = run("{}\n", "run", "--flow", "orders", calls)
+	require.Equal(t, 0, code)
+	require.Equal(t, "bill: {\"billed\":1}\n", out)
+
+	// Injected faults fail nodes, and bad fault settings fail the command
+	code, _, errOut = run("{\"status\":\"paid\"}\n", "run", "--events", "--faults", "error=1,nodes=tag", flow)
+	require.Equal(t, exitProblems, code)
+	require.Contains(t, errOut, "fault.injected")
+	code, _, errOut = run("", "run", "--faults", "error=2", flow)
+	require.Equal(t, exitFailure, code)
+	require.Contains(t, errOut, "error_rate")
+
+	// Large payloads are spilled while they wait, and cleaned up
+	spillDir := filepath.Join(dir, "spill")
+	require.NoError(t, os.Mkdir(spillDir, 0o700))
+	code, out, _ = run("{\"status\":\"paid\"}\n", "run", "--max-payload", "4", "--spill-dir", spillDir, "--compress", "zstd", flow)
+	require.Equal(t, 0, code)
+	require.Equal(t, "tag: {\"stage\":\"billing\",\"status\":\"paid\"}\n", out)
+	entries, err := os.ReadDir(spillDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// Dedup nodes remember the messages of earlier runs with --state-dir
+	dedup := write("dedup.flow", `flow "f" { node "unique" { type: "Dedup" fields: ["id"] } }`)
+	stateDir := filepath.Join(dir, "state")
+	code, out, _ = run("{\"id\":1}\n{\"id\":1}\n", "run", "--state-dir", stateDir, dedup)
+	require.Equal(t, 0, code)
+	require.Equal(t, "unique: {\"id\":1}\n", out)
+	code, out, _ = run("{\"id\":1}\n{\"id\":2}\n", "run", "--state-dir", stateDir, dedup)
+	require.Equal(t, 0, code)
+	require.Equal(t, "unique: {\"id\":2}\n", out)
+
+	// Generate and Replay nodes feed the flow with --simulate, replaying
+	// the files of --data-dir
+	write("rows.csv", "status,id\npaid,1\nopen,2\n")
+	simulated := write("simulated.flow", `flow "f" {
+		node "rows" { type: "Replay" path: "rows.csv" }
+		node "paid" { type: "Filter" field: "status" equals: "paid" }
+	}`)
+	code, out, _ = run("", "run", "--simulate", "--data-dir", dir, simulated)
+	require.Equal(t, 0, code)
+	require.Equal(t, "paid: {\"id\":1,\"status\":\"paid\"}\n", out)
+	escaping := write("escaping.flow", `flow "f" { node "rows" { type: "Replay" path: "../rows.csv" } }`)
+	code, _, errOut = run("", "run", "--simulate", "--data-dir", stateDir, escaping)
+	require.Equal(t, exitProblems, code)
+	require.Contains(t, errOut, "outside the data directory")
+
+	// Parameters take the values given, and the flow fails without
+	// required ones
+	params := write("params.flow", `flow "f" {
+		params: { region: { type: "string" } }
+		node "tag" { type: "Transform" set: { region: "${param.region}" } }
+	}`)
+	code, out, _ = run("{}\n", "run", "--param", "region=eu", params)
+	require.Equal(t, 0, code)
+	require.Equal(t, "tag: {\"region\":\"eu\"}\n", out)
+	code, _, errOut = run("{}\n", "run", params)
+	require.Equal(t, exitProblems, code)
+	require.Contains(t, errOut, "region is required")
+
+	// Invalid flows and failing nodes fail the run
+	code, _, errOut = run("", "run", write("broken.flow", `flow "f" { node "n" {} }`))
+	require.Equal(t, exitProblems, code)
+	require.Contains(t, errOut, "has no type")
+	tag := write("tag.flow", `flow "f" { node "tag" { type: "Transform" set: { a: 1 } } }`)
+	code, _, errOut = run("[1]\n", "run", "--events", tag)
+	require.Equal(t, exitProblems, code)
+	require.Contains(t, errOut, "node.failed")
+}
+
+func TestBench(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(src), 0o600))
+		return path
+	}
+	flow := write("orders.flow", `flow "orders" {
+  node "paid" { type: "Filter" field: "status" equals: "paid" }
+  node "tag" { type: "Transform" set: { stage: "billing" } }
+}`)
+	input := write("input.ndjson", "{\"status\":\"paid\"}\n{\"status\":\"open\"}\n")
+
+	run := func(args ...string) (int, string, string) {
+		var out, errOut bytes.Buffer
+		code := run(args, strings.NewReader(""), &out, &errOut)
+		return code, out.String(), errOut.String()
+	}
+
+	// Samples are sent in turn, and every node is reported
+	code, out, _ := run("bench", "--messages", "100", "-i", input, "-o", "json", flow)
+	require.Equal(t, 0, code)
+	var result struct {
+		Flow     string `json:"flow"`
+		Messages int    `json:"messages"`
+		Outputs  int    `json:"outputs"`
+		Latency  struct {
+			P50 float64 `json:"p50"`
+			P99 float64 `json:"p99"`
+		} `json:"latency_ms"`
+		Queue struct {
+			Capacity int `json:"capacity"`
+		} `json:"queue"`
+		Nodes []struct {
+			ID       string `json:"id"`
+			Messages int    `json:"messages"`
+		} `json:"nodes"`
+		Violations []string `json:"violations"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(out), &result))
+	require.Equal(t, "orders", result.Flow)
+	require.Equal(t, 100, result.Messages)
+	require.Equal(t, 50, result.Outputs)
+	require.Positive(t, result.Latency.P50)
+	require.GreaterOrEqual(t, result.Latency.P99, result.Latency.P50)
+	require.Equal(t, 1000, result.Queue.Capacity)
+	require.Len(t, result.Nodes, 2)
+	require.Equal(t, 100, result.Nodes[0].Messages)
+	require.Equal(t, 50, result.Nodes[1].Messages)
+	require.Empty(t, result.Violations)
+
+	// Rate limited runs end after the duration
+	code, out, _ = run("bench", "--rate", "1000", "--duration", "50ms", flow)
+	require.Equal(t, 0, code)
+	require.Contains(t, out, "orders: ")
+	require.Contains(t, out, "node paid")
+
+	// Exceeded thresholds fail the run
+	code, out, _ = run("bench", "--messages", "10", "--max-p99", "1ns", "--min-throughput", "1e12", flow)
+	require.Equal(t, exitProblems, code)
+	require.Contains(t, out, "FAIL: throughput")
+	require.Contains(t, out, "FAIL: p99 latency")
+
+	code, _, errOut := run("bench", "--duration", "0", flow)
+	require.Equal(t, exitFailure, code)
+	require.Contains(t, errOut, "--messages")
+}
+
+func TestInit(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "shop")
+	run := func(args ...string) (int, string) {
+		var out bytes.Buffer
+		code := run(args, strings.NewReader(""), &out, &out)
+		return code, out.String()
+	}
+
+	code, out := run("init", "--node", "Upper", dir)
+	require.Equal(t, 0, code, out)
+	for _, name := range []string{
+		"flows/shop.flow", "samples/input.ndjson", "flow-control.json", "Makefile", ".gitignore",
+		"nodes/upper/go.mod", "nodes/upper/node.go", "nodes/upper/node_test.go",
+	} {
+		require.Contains(t, out, "created "+filepath.Join(dir, name)+"\n")
+	}
+
+	// The example flow is valid, formatted and runs on the sample input
+	flow := filepath.Join(dir, "flows", "shop.flow")
+	code, out = run("validate", flow)
+	require.Equal(t, 0, code, out)
+	code, out = run("fmt", "-l", flow)
+	require.Equal(t, 0, code, out)
+	code, out = run("run", "-i", filepath.Join(dir, "samples", "input.ndjson"), flow)
+	require.Equal(t, 0, code, out)
+	require.Equal(t, 2, strings.Count(out, "billing: "))
+
+	// The config is accepted by the server
+	_, err := config.Load(filepath.Join(dir, "flow-control.json"), logger.New(logger.WithFile("")))
+	require.NoError(t, err)
+
+	// The node module is wired to the SDK
+	gomod, err := os.ReadFile(filepath.Join(dir, "nodes", "upper", "go.mod"))
+	require.NoError(t, err)
+	require.Contains(t, string(gomod), "module shop/nodes/upper\n")
+	require.Contains(t, string(gomod), "replace flow-control => ../../../flow-control\n")
+	makefile, err := os.ReadFile(filepath.Join(dir, "Makefile"))
+	require.NoError(t, err)
+	require.Contains(t, string(makefile), "\tcd nodes/upper && go mod tidy && go test ./...\n")
+
+	// Existing files are kept unless forced
+	code, out = run("init", dir)
+	require.Equal(t, exitFailure, code)
+	require.Contains(t, out, "already exists")
+	code, _ = run("init", "--force", dir)
+	require.Equal(t, 0, code)
+
+	// Names must be usable in flows and Go code
+	code, _ = run("init", "--name", "my shop", t.TempDir())
+	require.Equal(t, exitFailure, code)
+	code, _ = run("init", "--node", "up-per", t.TempDir())
+	require.Equal(t, exitFailure, code)
+}
+
+func TestRepl(t *testing.T) {
+	samples := filepath.Join(t.TempDir(), "samples.ndjson")
+	require.NoError(t, os.WriteFile(samples, []byte(
+		`{"id": 1, "status": "paid", "items": [{"sku": "a", "qty": 2}]}`+"\n"+
+			`{"id": 2, "status": "open", "items": [], "note": "x"}`+"\n"), 0o600))
+
+	session := func(lines ...string) string {
+		var out bytes.Buffer
+		code := run([]string{"repl", "-i", samples}, strings.NewReader(strings.Join(lines, "\n")), &out, &out)
+		require.Equal(t, 0, code, out.String())
+		return out.String()
+	}
+
+	// Expressions are evaluated against the selected sample
+	require.Equal(t, "\"PAID\" : string\n2.5 : float\n\"x\" : string\n", session(
+		"upper(status)", "len(items) * 2.5", ":use 2", "note"))
+	require.Equal(t, "1  false\n2  true\n", session(":each id > 1"))
+
+	// Schemas are inferred from the samples or from results
+	require.Equal(t, "object\n  id: int\n  items: array<object>\n    qty: int\n    sku: string\n"+
+		"  note?: string\n  status: string\n", session(":schema"))
+	require.Equal(t, "object\n  n: int\n  s: string\n", session(":schema {s: status, n: len(items)}"))
+
+	// Payloads can be replaced and the session ends on :quit
+	require.Equal(t, "2 : int\n", session(`:payload {"a": [1]}`, "a[0] + 1", ":quit", "a[0]"))
+
+	// Mistakes are reported without ending the session
+	require.Equal(t, "error: col 4: expected a value, got end of expression\n"+
+		"error: no sample \"9\", there are 2\n"+
+		"error: unknown command :bogus, enter :help for help\n"+
+		"\"paid\" : string\n", session("1 +", ":use 9", ":bogus", "status"))
+}
+
+func TestGraph(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(src), 0o600))
+		return path
+	}
+	flow := write("orders.flow", `flow "orders" {
+  node "read" { type: "Passthrough" }
+  node "paid" { type: "Filter" field: "status" qos: "exactly-once" }
+}
+flow "other" {
+  node "only" { type: "Passthrough" }
+}`)
+
+	run := func(args ...string) (int, string) {
+		var out bytes.Buffer
+		code := run(args, nil, &out, &out)
+		return code, out.String()
+	}
+
+	// DOT is the default format
+	code, out := run("graph", "--flow", "orders", flow)
+	require.Equal(t, 0, code, out)
+	require.True(t, strings.HasPrefix(out, `digraph "orders" {`), out)
+	require.Contains(t, out, `"read" -> "paid" [label="exactly-once"];`)
+
+	code, out = run("graph", "--flow", "other", "-o", "mermaid", flow)
+	require.Equal(t, 0, code, out)
+	require.Equal(t, "flowchart LR\n  n0[\"<b>only</b><br/>Passthrough\"]\n", out)
+
+	code, out = run("graph", "--flow", "orders", "-o", "svg", flow)
+	require.Equal(t, 0, code, out)
+	require.True(t, strings.HasPrefix(out, "<svg "), out)
+
+	// Ambiguous or invalid flows and unknown formats fail
+	code, out = run("graph", flow)
+	require.Equal(t, exitProblems, code)
+	require.Contains(t, out, "several flows")
+	code, out = run("graph", write("broken.flow", `flow "f" { node "n" {} }`))
+	require.Equal(t, exitProblems, code)
+	require.Contains(t, out, "has no type")
+	code, _ = run("graph", "--flow", "orders", "-o", "png", flow)
+	require.Equal(t, exitFailure, code)
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(src), 0o600))
+		return path
+	}
+	before := write("old.flow", `flow "orders" {
+  node "read" { type: "Passthrough" }
+  node "paid" { type: "Filter" field: "status" equals: "paid" }
+  node "audit" { type: "Passthrough" from: "read" }
+}`)
+	after := write("new.flow", `flow "orders" {
+  node "read" { type: "Passthrough" }
+  node "paid" { type: "Filter" field: "state" equals: "paid" qos: "exactly-once" }
+  node "notify" { type: "Passthrough" }
+}`)
+
+	run := func(args ...string) (int, string) {
+		var out bytes.Buffer
+		code := run(args, nil, &out, &out)
+		return code, out.String()
+	}
+
+	code, out := run("diff", before, after)
+	require.Equal(t, exitProblems, code, out)
+	require.Equal(t, `~ node paid (Filter)
+    ~ field: "status" -> "state"
+    + qos: "exactly-once"
++ node notify (Passthrough)
+- node audit (Passthrough)
++ connection paid -> notify
+- connection read -> audit
+`, out)
+
+	code, out = run("diff", "-o", "json", before, after)
+	require.Equal(t, exitProblems, code, out)
+	var diff struct {
+		Nodes []struct {
+			ID     string `json:"id"`
+			Change string `json:"change"`
+		} `json:"nodes"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(out), &diff))
+	require.Len(t, diff.Nodes, 3)
+	require.Equal(t, "changed", diff.Nodes[0].Change)
+
+	// Identical flows have no differences
+	code, out = run("diff", before, before)
+	require.Equal(t, 0, code, out)
+	require.Empty(t, out)
+
+	// Invalid files are failures rather than differences
+	code, out = run("diff", before, write("broken.flow", `flow "f" { node "n" {} }`))
+	require.Equal(t, exitFailure, code)
+	require.Contains(t, out, "is not a valid flow file")
+}
+
+func TestConfigCheck(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(src), 0o600))
+		return path
+	}
+	run := func(args ...string) (int, string, string) {
+		var out, errOut bytes.Buffer
+		code := run(args, strings.NewReader(""), &out, &errOut)
+		return code, out.String(), errOut.String()
+	}
+
+	// Every setting is printed with its source, and secrets are redacted
+	file := write("config.yaml", "server:\n  port: 9090\n  admin_token: s3cret\nlogging:\n  levle: debug\n")
+	t.Setenv("SERVER_HOST", "127.0.0.1")
+	code, out, errOut := run("config", "check", file)
+	require.Equal(t, 0, code)
+	require.Contains(t, out, "server.port = 9090  ("+file+":2)\n")
+	require.Contains(t, out, "server.host = \"127.0.0.1\"  (environment variable SERVER_HOST)\n")
+	require.Contains(t, out, "server.admin_token = \"REDACTED\"  ("+file+":3)\n")
+	require.Contains(t, out, "logging.level = \"info\"  (default)\n")
+	require.NotContains(t, out, "s3cret")
+	require.Contains(t, errOut, "logging.levle")
+
+	code, out, _ = run("config", "check", "--ignore-env", "-o", "json", file)
+	require.Equal(t, 0, code)
+	var check struct {
+		Config struct {
+			Server struct {
+				Host string `json:"host"`
+				Port int    `json:"port"`
+			} `json:"server"`
+		} `json:"config"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(out), &check))
+	require.Equal(t, "0.0.0.0", check.Config.Server.Host)
+	require.Equal(t, 9090, check.Config.Server.Port)
+
+	// Profiles are applied with --env
+	profiles := write("profiles.yaml", "server:\n  port: 8080\nprofiles:\n  prod:\n    server:\n      port: 443\n")
+	code, out, _ = run("config", "check", "--ignore-env", "--env", "prod", profiles)
+	require.Equal(t, 0, code)
+	require.Contains(t, out, "server.port = 443  ("+profiles+":6)\n")
+	code, _, errOut = run("config", "check", "--env", "qa", profiles)
+	require.Equal(t, exitFailure, code)
+	require.Contains(t, errOut, `unknown config profile "qa"`)
+
+	// Invalid configurations list their problems
+	bad := write("bad.toml", "[server]\nport = 70000\n")
+	code, _, errOut = run("config", "check", bad)
+	require.Equal(t, exitProblems, code)
+	require.Contains(t, errOut, "server.port: invalid port number: 70000 ("+bad+":2)")
+}
+
+func TestAudit(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(src), 0o600))
+	}
+	run := func(args ...string) (int, string, string) {
+		var out, errOut bytes.Buffer
+		code := run(args, strings.NewReader(""), &out, &errOut)
+		return code, out.String(), errOut.String()
+	}
+
+	write("go.mod", "module example\n\ngo 1.22\n")
+	write("main.go", "package main\n\nimport \"example/internal/nodes\"\n\nfunc main() { nodes.Register() }\n")
+	write("internal/nodes/nodes.go", "package nodes\n\nfunc Register() {}\n")
+	code, out, _ := run("audit", dir)
+	require.Equal(t, 0, code)
+	require.Equal(t, "2 packages in example, 0 import cycles, 0 unused\n", out)
+
+	write("internal/nodes/old.go", "package nodes\n\nfunc legacy() {}\n")
+	code, out, _ = run("audit", dir)
+	require.Equal(t, exitProblems, code)
+	require.Contains(t, out, "internal/nodes/old.go:3: unused func legacy\n")
+
+	code, out, _ = run("audit", "-o", "json", dir)
+	require.Equal(t, exitProblems, code)
+	var report struct {
+		Cycles [][]string `json:"cycles"`
+		Unused []struct {
+			Name string `json:"name"`
+		} `json:"unused"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+	require.Empty(t, report.Cycles)
+	require.Len(t, report.Unused, 1)
+	require.Equal(t, "legacy", report.Unused[0].Name)
+
+	code, _, errOut := run("audit", filepath.Join(dir, "missing"))
+	require.Equal(t, exitFailure, code)
+	require.Contains(t, errOut, "go.mod")
+}
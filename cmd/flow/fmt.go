@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"flow-control/internal/parser/format"
+
+	"github.com/spf13/cobra"
+)
+
+// fmtOptions holds the flags of the fmt command
+type fmtOptions struct {
+	list  bool
+	write bool
+	diff  bool
+}
+
+func newFmtCmd() *cobra.Command {
+	var opts fmtOptions
+	cmd := &cobra.Command{
+		Use:   "fmt [paths...]",
+		Short: "Format Flow files in the canonical layout",
+		Long: "Format Flow files, printing the result unless -l, -w or -d is given.\n" +
+			"Exits with 1 if a file does not parse, or if -l or -d find an unformatted\n" +
+			"file and -w is not set, so that CI can check formatting.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			files, err := collectFiles(args)
+			if err != nil {
+				return err
+			}
+
+			log := newLogger(cmd.ErrOrStderr())
+			out := cmd.OutOrStdout()
+			problems := false
+			for _, file := range files {
+				src, err := os.ReadFile(file)
+				if err != nil {
+					return fmt.Errorf("failed to read %s: %w", file, err)
+				}
+				formatted, diagnostics, err := format.Source(src, log)
+				if err != nil {
+					for _, d := range diagnostics {
+						fmt.Fprintf(cmd.ErrOrStderr(), "%s:%s\n", file, d)
+					}
+					problems = true
+					continue
+				}
+
+				if !opts.list && !opts.write && !opts.diff {
+					if _, err := out.Write(formatted); err != nil {
+						return err
+					}
+					continue
+				}
+				if bytes.Equal(src, formatted) {
+					continue
+				}
+				if opts.list {
+					fmt.Fprintln(out, file)
+				}
+				if opts.diff {
+					fmt.Fprint(out, unifiedDiff(file, src, formatted))
+				}
+				if opts.write {
+					if err := writeFile(file, formatted); err != nil {
+						return err
+					}
+				} else {
+					problems = true
+				}
+			}
+
+			if problems {
+				return errProblems
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVarP(&opts.list, "list", "l", false, "List files whose formatting differs")
+	cmd.Flags().BoolVarP(&opts.write, "write", "w", false, "Write the result to the files")
+	cmd.Flags().BoolVarP(&opts.diff, "diff", "d", false, "Print diffs of the formatting changes")
+	return cmd
+}
+
+// writeFile replaces the content of a file, keeping its permissions
+func writeFile(file string, data []byte) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	if err := os.WriteFile(file, data, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"flow-control/internal/parser/analyzer"
+	"flow-control/internal/runtime/diagram"
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/types"
+
+	"github.com/spf13/cobra"
+)
+
+func newGraphCmd() *cobra.Command {
+	var flow, format string
+	cmd := &cobra.Command{
+		Use:   "graph <file>",
+		Short: "Render a flow as a diagram",
+		Long: "Render the nodes and connections of a flow as a diagram on stdout, in\n" +
+			"Graphviz DOT, Mermaid or SVG format.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file := args[0]
+			errOut := cmd.ErrOrStderr()
+			log := newLogger(errOut)
+
+			graph, err := loadGraph(file, flow, log, errOut)
+			if err != nil {
+				return err
+			}
+			out, err := diagram.Render(graph, format)
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(out)
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&flow, "flow", "", "Flow to render when the file defines several")
+	cmd.Flags().StringVarP(&format, "output", "o", diagram.FormatDOT,
+		"Diagram format ("+strings.Join(diagram.Formats(), ", ")+")")
+	return cmd
+}
+
+// loadGraph validates the flow file and builds the graph of one of its
+// flows. Problems with the file are printed to errOut and reported as
+// errProblems.
+func loadGraph(file, flow string, log types.Logger, errOut io.Writer) (*engine.Graph, error) {
+	diagnostics, err := validateFile(file, log)
+	if err != nil {
+		return nil, err
+	}
+	if analyzer.HasErrors(diagnostics) {
+		for _, d := range diagnostics {
+			fmt.Fprintf(errOut, "%s:%s\n", file, d)
+		}
+		return nil, errProblems
+	}
+
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	graph, err := engine.LoadSource(string(src), flow, log)
+	if err != nil {
+		fmt.Fprintf(errOut, "%s: %v\n", file, err)
+		return nil, errProblems
+	}
+	return graph, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+)
+
+//go:embed templates/init
+var initTemplates embed.FS
+
+var (
+	// projectNamePattern matches names usable as flow names, file names and
+	// module path elements
+	projectNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+	// nodeTypePattern matches node types usable as Go identifiers
+	nodeTypePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`)
+)
+
+// initOptions holds the flags of the init command
+type initOptions struct {
+	name    string
+	node    string
+	sdkPath string
+	force   bool
+}
+
+// projectData is the data the project templates are rendered with
+type projectData struct {
+	// Name names the project, its example flow and its database
+	Name string
+	// Node describes the custom node skeleton, when one is generated
+	Node *nodeData
+}
+
+// nodeData describes a custom node skeleton
+type nodeData struct {
+	Type    string
+	Package string
+	Module  string
+	SDKPath string
+}
+
+// scaffoldFile is a file of a generated project
+type scaffoldFile struct {
+	path     string
+	template string
+}
+
+func newInitCmd() *cobra.Command {
+	var opts initOptions
+	cmd := &cobra.Command{
+		Use:   "init [dir]",
+		Short: "Create a starter project",
+		Long: "Create a starter project in dir, or the current directory: an example flow\n" +
+			"with sample input, a server config file and a Makefile. With --node, also a\n" +
+			"Go module skeleton for a custom node type built on the flow-control/pkg/node\n" +
+			"SDK. Existing files are kept unless --force is given.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) == 1 {
+				dir = args[0]
+			}
+			return initProject(cmd, dir, opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.name, "name", "", "Project name (default: the directory name)")
+	cmd.Flags().StringVar(&opts.node, "node", "", "Generate a custom node module for this node type")
+	cmd.Flags().StringVar(&opts.sdkPath, "sdk-path", "../../../flow-control",
+		"Path of the flow-control checkout, relative to the node module")
+	cmd.Flags().BoolVar(&opts.force, "force", false, "Overwrite existing files")
+	return cmd
+}
+
+// initProject generates a project in dir
+func initProject(cmd *cobra.Command, dir string, opts initOptions) error {
+	data, err := newProjectData(dir, opts)
+	if err != nil {
+		return err
+	}
+
+	files := []scaffoldFile{
+		{filepath.Join("flows", data.Name+flowExt), "project/example.flow.tmpl"},
+		{filepath.Join("samples", "input.ndjson"), "project/input.ndjson.tmpl"},
+		{"flow-control.json", "project/flow-control.json.tmpl"},
+		{"Makefile", "project/Makefile.tmpl"},
+		{".gitignore", "project/gitignore.tmpl"},
+	}
+	if data.Node != nil {
+		nodeDir := filepath.Join("nodes", data.Node.Package)
+		files = append(files,
+			scaffoldFile{filepath.Join(nodeDir, "go.mod"), "node/go.mod.tmpl"},
+			scaffoldFile{filepath.Join(nodeDir, "node.go"), "node/node.go.tmpl"},
+			scaffoldFile{filepath.Join(nodeDir, "node_test.go"), "node/node_test.go.tmpl"},
+		)
+	}
+
+	// Check every file before writing any, so that a refused init leaves
+	// nothing behind
+	if !opts.force {
+		for _, f := range files {
+			path := filepath.Join(dir, f.path)
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+			}
+		}
+	}
+
+	out := cmd.OutOrStdout()
+	for _, f := range files {
+		content, err := renderTemplate(f.template, data)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, f.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", path, err)
+		}
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		fmt.Fprintf(out, "created %s\n", path)
+	}
+	return nil
+}
+
+// newProjectData checks the options and derives the template data from them
+func newProjectData(dir string, opts initOptions) (projectData, error) {
+	name := opts.name
+	if name == "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return projectData{}, fmt.Errorf("failed to resolve %s: %w", dir, err)
+		}
+		name = filepath.Base(abs)
+	}
+	if !projectNamePattern.MatchString(name) {
+		return projectData{}, fmt.Errorf("invalid project name %q: use letters, digits, - and _, or set --name", name)
+	}
+
+	data := projectData{Name: name}
+	if opts.node != "" {
+		if !nodeTypePattern.MatchString(opts.node) {
+			return projectData{}, fmt.Errorf("invalid node type %q: use letters and digits", opts.node)
+		}
+		pkg := strings.ToLower(opts.node)
+		data.Node = &nodeData{
+			Type:    opts.node,
+			Package: pkg,
+			Module:  name + "/nodes/" + pkg,
+			SDKPath: filepath.ToSlash(opts.sdkPath),
+		}
+	}
+	return data, nil
+}
+
+// renderTemplate executes the init template called name, formatting the
+// result when it is Go source
+func renderTemplate(name string, data projectData) ([]byte, error) {
+	tmpl, err := template.ParseFS(initTemplates, "templates/init/"+name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template %s: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render template %s: %w", name, err)
+	}
+	if !strings.HasSuffix(name, ".go.tmpl") {
+		return buf.Bytes(), nil
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format template %s: %w", name, err)
+	}
+	return src, nil
+}
@@ -0,0 +1,82 @@
+/*
+Package main implements flow, the developer tool for Flow language files.
+It works on local .flow files, server config files and the Go code of
+custom nodes, and does not need a server.
+
+Usage:
+
+	flow validate [paths...]  Check files for syntax and semantic errors
+	flow fmt [paths...]       Format files in the canonical layout
+	flow run <file>           Run a flow locally on messages read from stdin
+	flow bench <file>         Measure the throughput and latency of a flow
+	flow graph <file>         Render a flow as a DOT, Mermaid or SVG diagram
+	flow diff <old> <new>     Compare the nodes, connections and settings of two flows
+	flow init [dir]           Create a starter project
+	flow repl                 Evaluate transform expressions interactively
+	flow config check [file]  Validate a server config file and show its settings
+	flow audit [dir]          Find unused code and import cycles in a Go module
+
+Paths may be files or directories, which are searched recursively for .flow
+files. The exit code is 0 on success, 1 when problems were found and 2 when
+the command could not run.
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes
+const (
+	exitProblems = 1
+	exitFailure  = 2
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// errProblems is returned by commands that reported problems in the files
+// they checked. The problems have already been printed.
+var errProblems = errors.New("problems found")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the process exit code
+func run(args []string, stdin io.Reader, out, errOut io.Writer) int {
+	root := newRootCmd()
+	root.SetArgs(args)
+	root.SetIn(stdin)
+	root.SetOut(out)
+	root.SetErr(errOut)
+
+	err := root.Execute()
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errProblems):
+		return exitProblems
+	default:
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+		return exitFailure
+	}
+}
+
+// newRootCmd creates the flow command tree
+func newRootCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:           "flow",
+		Short:         "Work with Flow language files",
+		Version:       version,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	root.AddCommand(newValidateCmd(), newFmtCmd(), newRunCmd(), newGraphCmd(), newDiffCmd(), newInitCmd(), newReplCmd(), newConfigCmd(), newAuditCmd(), newBenchCmd())
+	return root
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"flow-control/internal/runtime/expr"
+	"flow-control/internal/runtime/schema"
+	"flow-control/internal/types"
+
+	"github.com/spf13/cobra"
+)
+
+// replHelp describes the REPL commands
+const replHelp = `Enter an expression to evaluate it against the current sample.
+Commands:
+  :load <file>      Load samples, JSON values one after another
+  :payload <json>   Use a single sample
+  :samples          List the samples
+  :use <n>          Select sample n
+  :each <expr>      Evaluate an expression against every sample
+  :schema [expr]    Infer the schema of the samples, or of an expression's results
+  :functions        List the built-in functions
+  :help             Show this help
+  :quit             Leave
+`
+
+func newReplCmd() *cobra.Command {
+	var input string
+	cmd := &cobra.Command{
+		Use:   "repl",
+		Short: "Evaluate transform expressions interactively",
+		Long: "Evaluate transform expressions against sample payloads, one per line, and\n" +
+			"show the type of each result. Enter :help for the commands.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			r := &repl{out: cmd.OutOrStdout()}
+			if input != "" {
+				if err := r.load(input); err != nil {
+					return err
+				}
+			}
+			return r.run(cmd.InOrStdin())
+		},
+	}
+	cmd.Flags().StringVarP(&input, "input", "i", "", "Sample file with JSON payloads")
+	return cmd
+}
+
+// repl is the state of an expression REPL session
+type repl struct {
+	out     io.Writer
+	samples []interface{}
+	current int
+}
+
+// run reads and handles lines from in until it ends or :quit is entered.
+// A prompt is shown when in is a terminal.
+func (r *repl) run(in io.Reader) error {
+	prompt := isTerminal(in)
+	if prompt {
+		fmt.Fprintf(r.out, "Flow expression REPL, %d samples loaded. Enter :help for help.\n", len(r.samples))
+	}
+	scanner := bufio.NewScanner(in)
+	for {
+		if prompt {
+			fmt.Fprint(r.out, "> ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == ":quit" || line == ":q" {
+			return nil
+		}
+		if err := r.handle(line); err != nil {
+			fmt.Fprintf(r.out, "error: %v\n", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+	return nil
+}
+
+// handle runs a command or evaluates an expression
+func (r *repl) handle(line string) error {
+	if line == "" {
+		return nil
+	}
+	if !strings.HasPrefix(line, ":") {
+		return r.eval(line)
+	}
+
+	command, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch command {
+	case ":help":
+		fmt.Fprint(r.out, replHelp)
+	case ":load":
+		if err := r.load(arg); err != nil {
+			return err
+		}
+		fmt.Fprintf(r.out, "loaded %d samples\n", len(r.samples))
+	case ":payload":
+		var v interface{}
+		if err := json.Unmarshal([]byte(arg), &v); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		r.samples, r.current = []interface{}{v}, 0
+	case ":samples":
+		for i, sample := range r.samples {
+			mark := " "
+			if i == r.current {
+				mark = "*"
+			}
+			fmt.Fprintf(r.out, "%s %d  %s\n", mark, i+1, encodeValue(sample))
+		}
+	case ":use":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(r.samples) {
+			return fmt.Errorf("no sample %q, there are %d", arg, len(r.samples))
+		}
+		r.current = n - 1
+	case ":each":
+		e, err := expr.Compile(arg)
+		if err != nil {
+			return err
+		}
+		for i, sample := range r.samples {
+			v, err := e.Eval(sample)
+			if err != nil {
+				fmt.Fprintf(r.out, "%d  error: %v\n", i+1, err)
+				continue
+			}
+			fmt.Fprintf(r.out, "%d  %s\n", i+1, encodeValue(v))
+		}
+	case ":schema":
+		return r.schema(arg)
+	case ":functions":
+		fmt.Fprintln(r.out, strings.Join(expr.Functions(), " "))
+	default:
+		return fmt.Errorf("unknown command %s, enter :help for help", command)
+	}
+	return nil
+}
+
+// eval evaluates an expression against the current sample and prints the
+// result with its type
+func (r *repl) eval(src string) error {
+	var payload interface{}
+	if len(r.samples) > 0 {
+		payload = r.samples[r.current]
+	}
+	v, err := expr.Eval(src, payload)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(r.out, "%s : %s\n", encodeValue(v), schema.Infer(v).GetType())
+	return nil
+}
+
+// schema prints the schema inferred from the samples, or from the results
+// of evaluating src against them
+func (r *repl) schema(src string) error {
+	values := r.samples
+	if src != "" {
+		e, err := expr.Compile(src)
+		if err != nil {
+			return err
+		}
+		values = make([]interface{}, 0, len(r.samples))
+		for i, sample := range r.samples {
+			v, err := e.Eval(sample)
+			if err != nil {
+				return fmt.Errorf("sample %d: %w", i+1, err)
+			}
+			values = append(values, v)
+		}
+	}
+	s := schema.Infer(values...)
+	fmt.Fprintln(r.out, s.GetType())
+	describeFields(r.out, s, "  ")
+	return nil
+}
+
+// load replaces the samples with the JSON values in file
+func (r *repl) load(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to open samples: %w", err)
+	}
+	defer f.Close()
+
+	var samples []interface{}
+	decoder := json.NewDecoder(f)
+	for decoder.More() {
+		var v interface{}
+		if err := decoder.Decode(&v); err != nil {
+			return fmt.Errorf("failed to read sample %d of %s: %w", len(samples)+1, file, err)
+		}
+		samples = append(samples, v)
+	}
+	r.samples, r.current = samples, 0
+	return nil
+}
+
+// describeFields prints the fields of an object schema, or of the objects
+// inside a nullable or array schema, marking optional ones with ?
+func describeFields(w io.Writer, s types.Schema, indent string) {
+	for {
+		switch inner := s.(type) {
+		case *schema.NullableSchema:
+			s = inner.Inner()
+			continue
+		case *schema.ArraySchema:
+			s = inner.Elements()
+			continue
+		}
+		break
+	}
+	obj, ok := s.(*schema.ObjectSchema)
+	if !ok {
+		return
+	}
+
+	required := map[string]bool{}
+	for _, name := range obj.Required() {
+		required[name] = true
+	}
+	names := make([]string, 0, len(obj.Properties()))
+	for name := range obj.Properties() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prop := obj.Properties()[name]
+		mark := "?"
+		if required[name] {
+			mark = ""
+		}
+		fmt.Fprintf(w, "%s%s%s: %s\n", indent, name, mark, prop.GetType())
+		describeFields(w, prop, indent+"  ")
+	}
+}
+
+// encodeValue formats a value as compact JSON
+func encodeValue(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// isTerminal reports whether r is an interactive terminal
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"flow-control/internal/parser/analyzer"
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/runtime/schema"
+	"flow-control/internal/secrets"
+	"flow-control/internal/types"
+
+	"github.com/spf13/cobra"
+)
+
+// runOptions holds the flags of the run command
+type runOptions struct {
+	flow   string
+	input  string
+	output string
+	events bool
+	stub   bool
+	// secretsDir, when set, holds the files of the secrets node settings
+	// refer to instead of SECRET_ environment variables
+	secretsDir string
+	faults     string
+	params     map[string]string
+	// maxPayload is the largest payload kept in memory while it waits for
+	// nodes; larger ones are spilled to files in spillDir
+	maxPayload int
+	spillDir   string
+	// compress is the codec compressing spilled payloads
+	compress string
+	// stateDir, when set, keeps the state of stateful nodes such as Dedup
+	// between runs
+	stateDir string
+	// simulate runs the Generate and Replay nodes of the flow instead of
+	// reading input
+	simulate bool
+	// dataDir holds the files of Replay nodes
+	dataDir string
+	// lookupURLs and lookupDBs are the endpoints Enrich nodes may reach
+	lookupURLs []string
+	lookupDBs  map[string]string
+}
+
+// runOutput is a message leaving the flow, as printed with -o json
+type runOutput struct {
+	Node string          `json:"node"`
+	ID   string          `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
+
+func newRunCmd() *cobra.Command {
+	var opts runOptions
+	cmd := &cobra.Command{
+		Use:   "run <file>",
+		Short: "Run a flow locally",
+		Long: "Run a flow in process, feeding it one message per line of input and printing\n" +
+			"the messages that leave the flow. Lines that are not JSON are sent as JSON\n" +
+			"strings. Input is read from stdin unless --input names a sample file.\n" +
+			"References to ${secret.NAME} in node settings are read from the SECRET_NAME\n" +
+			"environment variable, or from the file NAME in --secrets-dir.\n" +
+			"--param sets the parameters the flow declares, such as --param region=eu.\n" +
+			"--faults injects failures, such as error=0.1,latency=0.5,latency_ms=20.\n" +
+			"Payloads over --max-payload bytes wait for nodes in temporary files.\n" +
+			"Stateful nodes, such as Dedup, keep their state between runs in --state-dir.\n" +
+			"Enrich nodes only reach the URLs of --lookup-url and databases of --lookup-db.\n" +
+			"--simulate feeds the flow the synthetic data of its Generate and Replay nodes,\n" +
+			"which replay files in --data-dir.\n" +
+			"Exits with 1 if the flow is invalid or a node failed.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.output != outputText && opts.output != outputJSON {
+				return fmt.Errorf("invalid output format %q: must be %s or %s", opts.output, outputText, outputJSON)
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer stop()
+			return runFlow(ctx, cmd, args[0], opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.flow, "flow", "", "Flow to run when the file defines several")
+	cmd.Flags().StringVarP(&opts.input, "input", "i", "", "Sample file with one input message per line")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", outputText, "Output format (text, json)")
+	cmd.Flags().BoolVar(&opts.events, "events", false, "Print flow events to stderr")
+	cmd.Flags().BoolVar(&opts.stub, "stub", false, "Replace nodes of unknown types with passthrough nodes")
+	cmd.Flags().StringVar(&opts.secretsDir, "secrets-dir", "", "Directory with one file per secret referenced by the flow")
+	cmd.Flags().StringVar(&opts.faults, "faults", "", faultsUsage)
+	cmd.Flags().StringToStringVar(&opts.params, "param", nil, paramUsage)
+	cmd.Flags().IntVar(&opts.maxPayload, "max-payload", 0, "Largest payload in bytes kept in memory while it waits for nodes; 0 keeps all in memory")
+	cmd.Flags().StringVar(&opts.spillDir, "spill-dir", "", "Directory of the temporary files of spilled payloads")
+	cmd.Flags().StringVar(&opts.compress, "compress", "", "Codec compressing spilled payloads (none, gzip, zstd)")
+	cmd.Flags().StringVar(&opts.stateDir, "state-dir", "", "Directory keeping the state of stateful nodes between runs")
+	cmd.Flags().BoolVar(&opts.simulate, "simulate", false, "Run the Generate and Replay nodes of the flow instead of reading input")
+	cmd.Flags().StringVar(&opts.dataDir, "data-dir", ".", "Directory of the files Replay nodes send")
+	cmd.Flags().StringArrayVar(&opts.lookupURLs, "lookup-url", nil, lookupURLUsage)
+	cmd.Flags().StringToStringVar(&opts.lookupDBs, "lookup-db", nil, lookupDBUsage)
+	return cmd
+}
+
+// runFlow loads the flow in file and runs it on the input
+func runFlow(ctx context.Context, cmd *cobra.Command, file string, opts runOptions) error {
+	out, errOut := cmd.OutOrStdout(), cmd.ErrOrStderr()
+	log := newLogger(errOut)
+
+	graph, flows, err := loadFlow(errOut, file, opts.flow, log)
+	if err != nil {
+		return err
+	}
+
+	faults, err := engine.ParseFaults(opts.faults)
+	if err != nil {
+		return err
+	}
+
+	input := cmd.InOrStdin()
+	if opts.input != "" {
+		f, err := os.Open(opts.input)
+		if err != nil {
+			return fmt.Errorf("failed to open input: %w", err)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	var provider secrets.Provider = secrets.NewEnvProvider("SECRET_", os.LookupEnv)
+	if opts.secretsDir != "" {
+		provider = secrets.NewFileProvider(opts.secretsDir)
+	}
+
+	lookups, err := parseLookups(opts.lookupURLs, opts.lookupDBs)
+	if err != nil {
+		return err
+	}
+
+	var states engine.StateStore
+	if opts.stateDir != "" {
+		if states, err = engine.NewDirStateStore(opts.stateDir); err != nil {
+			return err
+		}
+	}
+
+	failed := false
+	e, err := engine.New(graph, engine.NewRegistry(), log, engine.Options{
+		StubUnknown: opts.stub,
+		Params:      opts.params,
+		Secrets:     provider,
+		Faults:      faults,
+		Spill:       engine.Spill{MaxInMemory: opts.maxPayload, Dir: opts.spillDir, Compression: opts.compress},
+		State:       states,
+		Schemas:     schema.NewRegistry(),
+		Flows:       flows,
+		DataDir:     opts.dataDir,
+		Lookups:     lookups,
+		OnEvent: func(event types.FlowEvent) {
+			if event.Type == engine.TypeNodeFailed {
+				failed = true
+			}
+			if opts.events {
+				fmt.Fprintf(errOut, "%s  %-16s %-12s %s\n",
+					event.Timestamp.Format(time.RFC3339), event.Type, event.NodeID, event.Message)
+			}
+		},
+		OnOutput: func(nodeID string, msg types.Message) {
+			printOutput(out, opts.output, nodeID, msg)
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(errOut, "%s: %v\n", file, err)
+		return errProblems
+	}
+
+	if opts.simulate {
+		if err := simulateFlow(ctx, e); err != nil && ctx.Err() == nil {
+			return err
+		}
+		if failed {
+			return errProblems
+		}
+		return nil
+	}
+
+	// Cancelling stops the reader if the flow ends before the input does
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	messages := make(chan types.Message)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(messages)
+		readErr <- readMessages(readCtx, input, messages)
+	}()
+	if err := e.Run(ctx, messages); err != nil && ctx.Err() == nil {
+		return err
+	}
+	if err := <-readErr; err != nil && ctx.Err() == nil {
+		return err
+	}
+
+	if failed {
+		return errProblems
+	}
+	return nil
+}
+
+// simulateFlow starts the flow of e, runs its sources until they are done
+// and stops it
+func simulateFlow(ctx context.Context, e *engine.Engine) error {
+	if err := e.Start(ctx); err != nil {
+		return err
+	}
+	err := e.Simulate(ctx, nil)
+	if stopErr := e.Stop(context.Background()); stopErr != nil && err == nil {
+		err = stopErr
+	}
+	return err
+}
+
+// faultsUsage describes the --faults flag of the commands running flows
+const faultsUsage = "Faults to inject as comma-separated settings: error, drop, corrupt and latency rates, latency_ms, nodes (joined with +) and seed"
+
+// paramUsage describes the --param flag of the commands running flows
+const paramUsage = "Value of a parameter the flow declares, as name=value"
+
+// lookupURLUsage and lookupDBUsage describe the --lookup-url and
+// --lookup-db flags of the commands running flows
+const (
+	lookupURLUsage = "Prefix of the URLs the http sources of Enrich nodes may request, such as https://crm.internal/customers/"
+	lookupDBUsage  = "Database the sql sources of Enrich nodes may query by name, as name=driver:dsn with driver postgres or sqlite"
+)
+
+// parseLookups returns the endpoints of the --lookup-url and --lookup-db
+// flags
+func parseLookups(urls []string, databases map[string]string) (engine.Lookups, error) {
+	lookups := engine.Lookups{URLs: urls, Databases: make(map[string]engine.LookupDatabase, len(databases))}
+	for name, value := range databases {
+		driver, dsn, ok := strings.Cut(value, ":")
+		if !ok || dsn == "" {
+			return engine.Lookups{}, fmt.Errorf("invalid lookup database %s: must be driver:dsn", name)
+		}
+		lookups.Databases[name] = engine.LookupDatabase{Driver: driver, DSN: dsn}
+	}
+	return lookups, nil
+}
+
+// loadFlow validates file and builds the graph of flow, or of its only
+// flow, printing the problems found to errOut. Call nodes invoke the other
+// flows of the file, which the returned loader finds.
+func loadFlow(errOut io.Writer, file, flow string, log types.Logger) (*engine.Graph, engine.FlowLoader, error) {
+	diagnostics, err := validateFile(file, log)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, d := range diagnostics {
+		fmt.Fprintf(errOut, "%s:%s\n", file, d)
+	}
+	if analyzer.HasErrors(diagnostics) {
+		return nil, nil, errProblems
+	}
+
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	graph, err := engine.LoadSource(string(src), flow, log)
+	if err != nil {
+		fmt.Fprintf(errOut, "%s: %v\n", file, err)
+		return nil, nil, errProblems
+	}
+	return graph, engine.SourceFlows(string(src), log), nil
+}
+
+// readMessages sends one message per non-empty line of r until r ends or
+// ctx is done
+func readMessages(ctx context.Context, r io.Reader, messages chan<- types.Message) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	n := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		n++
+		msg := types.Message{
+			ID:   strconv.Itoa(n),
+			Data: lineData(line),
+			Metadata: types.MessageMetadata{
+				Timestamp: time.Now(),
+				Source:    "input",
+			},
+		}
+		select {
+		case messages <- msg:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+	return nil
+}
+
+// lineData returns a line of input as message data, encoding lines that
+// are not JSON as JSON strings
+func lineData(line string) json.RawMessage {
+	data := json.RawMessage(line)
+	if !json.Valid(data) {
+		data, _ = json.Marshal(line)
+	}
+	return data
+}
+
+// printOutput writes a message leaving the flow as text or as a JSON line
+func printOutput(w io.Writer, output, nodeID string, msg types.Message) {
+	if output == outputJSON {
+		_ = json.NewEncoder(w).Encode(runOutput{Node: nodeID, ID: msg.ID, Data: msg.Data})
+		return
+	}
+	fmt.Fprintf(w, "%s: %s\n", nodeID, msg.Data)
+}
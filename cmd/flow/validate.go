@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"flow-control/internal/parser"
+	"flow-control/internal/parser/analyzer"
+	"flow-control/internal/types"
+
+	"github.com/spf13/cobra"
+)
+
+// Output formats of validate
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// fileDiagnostic is a diagnostic located in a file, as printed by validate
+type fileDiagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (d fileDiagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.File, d.Line, d.Column, d.Severity, d.Message)
+}
+
+func newValidateCmd() *cobra.Command {
+	var output string
+	var strict bool
+	cmd := &cobra.Command{
+		Use:   "validate [paths...]",
+		Short: "Check Flow files for syntax and semantic errors",
+		Long: "Parse and analyze Flow files, printing every problem found.\n" +
+			"Exits with 1 if any file has errors, or warnings when --strict is set.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != outputText && output != outputJSON {
+				return fmt.Errorf("invalid output format %q: must be %s or %s", output, outputText, outputJSON)
+			}
+			files, err := collectFiles(args)
+			if err != nil {
+				return err
+			}
+
+			log := newLogger(cmd.ErrOrStderr())
+			found := []fileDiagnostic{}
+			failed := false
+			for _, file := range files {
+				diagnostics, err := validateFile(file, log)
+				if err != nil {
+					return err
+				}
+				for _, d := range diagnostics {
+					found = append(found, fileDiagnostic{
+						File:     file,
+						Line:     d.Pos.Line,
+						Column:   d.Pos.Column,
+						Severity: d.Severity,
+						Message:  d.Message,
+					})
+					if d.Severity == parser.SeverityError || strict {
+						failed = true
+					}
+				}
+			}
+
+			if err := printDiagnostics(cmd.OutOrStdout(), output, found); err != nil {
+				return err
+			}
+			if failed {
+				return errProblems
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", outputText, "Output format (text, json)")
+	cmd.Flags().BoolVar(&strict, "strict", false, "Fail on warnings as well as errors")
+	return cmd
+}
+
+// validateFile returns the syntax errors of a file or, if it parses, the
+// problems found by the analyzer
+func validateFile(file string, log types.Logger) ([]parser.Diagnostic, error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	program, diagnostics := parser.Parse(string(src), log)
+	if len(diagnostics) > 0 {
+		return diagnostics, nil
+	}
+	return analyzer.Analyze(program), nil
+}
+
+// printDiagnostics writes diagnostics one per line, or as a JSON array
+func printDiagnostics(w io.Writer, output string, diagnostics []fileDiagnostic) error {
+	if output == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diagnostics)
+	}
+	for _, d := range diagnostics {
+		if _, err := fmt.Fprintln(w, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
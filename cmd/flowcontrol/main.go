@@ -1,89 +1,244 @@
 /*
 Package main is the entry point for the Flow Control application.
 It initializes the configuration, logger, store, and server components.
+
+Usage:
+
+	flowcontrol [command] [flags]
+
+Commands:
+
+	serve    Run the server (the default)
+	config   Print the effective configuration as JSON
+	version  Print the version
+
+Settings are taken from, in increasing order of precedence, the built-in
+defaults, the config file with the profile selected by -env, environment
+variables and flags. The server
+reloads the config file on SIGHUP and when the file changes.
 */
 package main
 
 import (
-	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
-	"net/http"
+	"io"
 	"os"
-	"os/signal"
-	"syscall"
+	"strings"
 	"time"
 
 	"flow-control/internal/config"
-	"flow-control/internal/docserver"
 	"flow-control/internal/logger"
-	"flow-control/internal/server"
-	"flow-control/internal/store"
+	"flow-control/internal/secrets"
 )
 
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// options holds the flags shared by every command that loads the
+// configuration
+type options struct {
+	configFile string
+	profile    string
+	host       string
+	port       int
+	logLevel   string
+	logFormat  string
+	dbDriver   string
+	dbPath     string
+	dbDSN      string
+}
+
 func main() {
+	args := os.Args[1:]
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		reloader, secretCache, log := setup(command, args)
+		serve(reloader, secretCache, log)
+	case "config":
+		reloader, _, log := setup(command, args)
+		printConfig(os.Stdout, reloader.Config())
+		if err := log.Close(); err != nil {
+			fmt.Printf("Failed to close logger: %v\n", err)
+		}
+	case "version":
+		fmt.Println(version)
+	case "help", "-h", "--help":
+		usage(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", command)
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+}
+
+// usage prints the available commands
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: flowcontrol [command] [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	fmt.Fprintln(w, "  serve    Run the server (the default)")
+	fmt.Fprintln(w, "  config   Print the effective configuration as JSON")
+	fmt.Fprintln(w, "  version  Print the version")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'flowcontrol <command> -h' for the flags of a command.")
+	fmt.Fprintf(w, "Environment variables: CONFIG_FILE, CONFIG_ENV, DOCS_TEMPLATE_DIR, %s\n", strings.Join(config.EnvVars(), ", "))
+}
+
+// setup parses the flags of a command, loads the configuration and creates
+// the logger and secrets provider it describes. The configuration is
+// returned in a reloader that applies the same flags, environment and
+// secrets when the file is reloaded. It exits on invalid flags or
+// configuration.
+func setup(command string, args []string) (*config.Reloader, *secrets.Cache, *logger.Logger) {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	var opts options
+	fs.StringVar(&opts.configFile, "config", os.Getenv("CONFIG_FILE"), "Path to the config file (JSON, YAML or TOML) or directory")
+	fs.StringVar(&opts.profile, "env", os.Getenv("CONFIG_ENV"), "Config profile to apply, such as staging or prod")
+	fs.StringVar(&opts.host, "host", "", "Address to listen on")
+	fs.IntVar(&opts.port, "port", 0, "Port to listen on")
+	fs.StringVar(&opts.logLevel, "log-level", "", "Minimum log level (debug, info, warn, error)")
+	fs.StringVar(&opts.logFormat, "log-format", "", "Console log format (console, json, auto)")
+	fs.StringVar(&opts.dbDriver, "db-driver", "", "Database driver (sqlite, postgres)")
+	fs.StringVar(&opts.dbPath, "db-path", "", "SQLite database path")
+	fs.StringVar(&opts.dbDSN, "db-dsn", "", "Postgres connection string")
+	if err := fs.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		os.Exit(2)
+	}
+
 	// Create logger
 	log := logger.New()
 
 	// Load configuration
-	cfg, err := config.Load("", log)
+	overrides := []config.Override{
+		config.FromEnv(os.LookupEnv),
+		opts.override(fs),
+	}
+	cfg, err := config.LoadProfile(opts.configFile, opts.profile, log, overrides...)
 	if err != nil {
+		// The logger only writes to its file until it is configured, so
+		// the problems are printed as well
 		log.Error("Failed to load configuration", err, nil)
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
 		os.Exit(1)
 	}
-
-	// Create store
-	db, err := store.New(cfg.Database.Path, log)
-	if err != nil {
-		log.Error("Failed to create store", err, nil)
+	if err := cfg.PrepareDirs(); err != nil {
+		log.Error("Failed to prepare data directories", err, nil)
 		os.Exit(1)
 	}
 
-	// Create server
-	srv := server.New(db, log)
-
-	// Create documentation server
-	docs := docserver.New(log)
-	srv.Mount("/", docs.Routes())
-
-	// Create HTTP server
-	httpServer := &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
-		Handler: srv,
+	// Recreate the logger now that its configuration is known
+	if err := log.Close(); err != nil {
+		fmt.Printf("Failed to close bootstrap logger: %v\n", err)
 	}
-
-	// Handle graceful shutdown
-	done := make(chan bool)
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-
-	go func() {
-		<-quit
-		log.Info("Server is shutting down...", nil)
-
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		if err := httpServer.Shutdown(ctx); err != nil {
-			log.Error("Failed to gracefully shutdown server", err, nil)
+	rotation := cfg.Logging.Rotation
+	log = logger.New(
+		logger.WithFile(cfg.Logging.File),
+		logger.WithRotation(rotation.MaxSizeMB, rotation.MaxBackups, rotation.MaxAgeDays, rotation.Compress),
+		logger.WithLevel(cfg.Logging.Level),
+		logger.WithFormat(cfg.Logging.Format),
+		logger.WithSampling(samplingRules(cfg)),
+	)
+
+	// Resolve the secrets that sensitive settings refer to
+	secretCache, err := secretsProvider(cfg, log)
+	if err == nil {
+		resolve := config.ResolveSecrets(secretCache)
+		overrides = append(overrides, resolve)
+		if err = resolve(cfg); err == nil {
+			err = cfg.Validate()
 		}
+	}
+	if err != nil {
+		log.Error("Failed to resolve secrets", err, nil)
+		fmt.Fprintf(os.Stderr, "Failed to resolve secrets: %v\n", err)
+		os.Exit(1)
+	}
+	return config.NewReloader(opts.configFile, cfg, log, overrides...), secretCache, log
+}
 
-		if err := db.Close(); err != nil {
-			log.Error("Failed to close database", err, nil)
+// secretsProvider creates the configured secrets provider, caching its
+// secrets
+func secretsProvider(cfg *config.Config, log *logger.Logger) (*secrets.Cache, error) {
+	var provider secrets.Provider
+	switch cfg.Secrets.Provider {
+	case "file":
+		provider = secrets.NewFileProvider(cfg.Secrets.Dir)
+	case "vault":
+		vault, err := secrets.NewVaultProvider(secrets.VaultConfig(cfg.Secrets.Vault))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create vault provider: %w", err)
 		}
+		provider = vault
+	default:
+		provider = secrets.NewEnvProvider(cfg.Secrets.EnvPrefix, os.LookupEnv)
+	}
+	return secrets.NewCache(provider, time.Duration(cfg.Secrets.CacheSeconds)*time.Second, log), nil
+}
 
-		close(done)
-	}()
+// samplingRules converts the configured log sampling for the logger
+func samplingRules(cfg *config.Config) map[string]logger.SamplingRule {
+	rules := make(map[string]logger.SamplingRule, len(cfg.Logging.Sampling))
+	for component, rule := range cfg.Logging.Sampling {
+		rules[component] = logger.SamplingRule(rule)
+	}
+	return rules
+}
 
-	// Start server
-	log.Info("Server is starting...", nil)
-	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
-		log.Error("Failed to start server", err, nil)
-		if err := db.Close(); err != nil {
-			log.Error("Failed to close database", err, nil)
-		}
-		os.Exit(1)
+// flagFields maps flags to the dotted paths of the settings they override
+var flagFields = map[string]string{
+	"host":       "server.host",
+	"port":       "server.port",
+	"log-level":  "logging.level",
+	"log-format": "logging.format",
+	"db-driver":  "database.driver",
+	"db-path":    "database.path",
+	"db-dsn":     "database.dsn",
+}
+
+// override applies the flags that were set on the command line
+func (o *options) override(fs *flag.FlagSet) config.Override {
+	return func(c *config.Config) error {
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "host":
+				c.Server.Host = o.host
+			case "port":
+				c.Server.Port = o.port
+			case "log-level":
+				c.Logging.Level = o.logLevel
+			case "log-format":
+				c.Logging.Format = o.logFormat
+			case "db-driver":
+				c.Database.Driver = o.dbDriver
+			case "db-path":
+				c.Database.Path = o.dbPath
+			case "db-dsn":
+				c.Database.DSN = o.dbDSN
+			}
+			if field, ok := flagFields[f.Name]; ok {
+				c.SetSource(field, "flag -"+f.Name)
+			}
+		})
+		return nil
 	}
+}
 
-	<-done
-	log.Info("Server stopped", nil)
+// printConfig writes the configuration as indented JSON, hiding secrets
+func printConfig(w io.Writer, cfg *config.Config) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(cfg.Redacted()); err != nil {
+		fmt.Printf("Failed to encode configuration: %v\n", err)
+	}
 }
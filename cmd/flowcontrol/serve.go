@@ -0,0 +1,631 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"flow-control/internal/alerting"
+	"flow-control/internal/cluster"
+	"flow-control/internal/compression"
+	"flow-control/internal/config"
+	"flow-control/internal/docserver"
+	"flow-control/internal/events"
+	"flow-control/internal/logger"
+	"flow-control/internal/metrics"
+	"flow-control/internal/restart"
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/runtime/schema"
+	"flow-control/internal/secrets"
+	"flow-control/internal/server"
+	"flow-control/internal/store"
+	"flow-control/internal/tracing"
+	"flow-control/internal/types"
+)
+
+// serve runs the server until it receives SIGINT or SIGTERM. SIGHUP reloads
+// the configuration and SIGUSR2 restarts the server on its binary.
+func serve(reloader *config.Reloader, secretCache *secrets.Cache, log *logger.Logger) {
+	cfg := reloader.Config()
+
+	// Ship logs to Loki
+	var loki *logger.LokiHook
+	var err error
+	if sink := cfg.Logging.Loki; sink.URL != "" {
+		levels := make([]types.LogLevel, 0, len(sink.Levels))
+		for _, level := range sink.Levels {
+			levels = append(levels, logger.ParseLevel(level))
+		}
+		loki, err = logger.NewLokiHook(logger.LokiConfig{
+			URL:           sink.URL,
+			TenantID:      sink.TenantID,
+			Labels:        sink.Labels,
+			Levels:        levels,
+			BatchSize:     sink.BatchSize,
+			FlushInterval: time.Duration(sink.FlushIntervalMs) * time.Millisecond,
+			QueueSize:     sink.QueueSize,
+			MaxRetries:    sink.MaxRetries,
+		})
+		if err != nil {
+			log.Error("Failed to create loki log sink", err, nil)
+			os.Exit(1)
+		}
+		log.AddHook(loki)
+	}
+
+	// Create tracer
+	tracer, err := tracing.New(context.Background(), tracing.Config{
+		Enabled:     cfg.Tracing.Enabled,
+		ServiceName: cfg.Tracing.ServiceName,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		Headers:     cfg.Tracing.Headers,
+	})
+	if err != nil {
+		log.Error("Failed to create tracer", err, nil)
+		os.Exit(1)
+	}
+
+	// Create metrics registry
+	metricsRegistry := metrics.NewRegistry()
+
+	// Create store
+	dataSource := cfg.Database.Path
+	if strings.EqualFold(cfg.Database.Driver, "postgres") {
+		dataSource = cfg.Database.DSN
+	}
+	storeOpts := store.DefaultOptions()
+	storeOpts.JournalMode = cfg.Database.JournalMode
+	storeOpts.BusyTimeout = time.Duration(cfg.Database.BusyTimeout) * time.Millisecond
+	storeOpts.ForeignKeys = cfg.Database.ForeignKeys
+	storeOpts.MaxOpenConns = cfg.Database.MaxOpenConns
+	storeOpts.MaxIdleConns = cfg.Database.MaxIdleConns
+	storeOpts.SlowQueryThreshold = time.Duration(cfg.Database.SlowQueryMs) * time.Millisecond
+	storeOpts.Metrics = metricsRegistry
+	db, err := store.Open(cfg.Database.Driver, dataSource, log, storeOpts)
+	if err != nil {
+		log.Error("Failed to create store", err, nil)
+		os.Exit(1)
+	}
+
+	// Resume the flows a restarting server checkpointed
+	resumed, err := restart.ResumeFromEnv(db, log)
+	if err != nil {
+		log.Error("Failed to resume checkpointed flows", err, nil)
+	}
+	if resumed > 0 {
+		log.Info("Resumed checkpointed flows", types.Fields{
+			"flows": resumed,
+		})
+	}
+
+	// Sample the process and its connection pool
+	var pool metrics.PoolStatter
+	if statter, ok := db.(metrics.PoolStatter); ok {
+		pool = statter
+	}
+	process := metrics.NewProcessCollector(pool)
+	if err := metricsRegistry.Register(process); err != nil {
+		log.Error("Failed to register process metrics", err, nil)
+		os.Exit(1)
+	}
+
+	// Create event bus
+	var bus events.Bus
+	switch cfg.Events.Backend {
+	case "nats":
+		bus, err = events.NewNATSBus(events.NATSConfig{
+			URL:     cfg.Events.NATS.URL,
+			Subject: cfg.Events.NATS.Subject,
+			Buffer:  cfg.Events.BufferSize,
+		}, log)
+	case "redis":
+		bus, err = events.NewRedisBus(events.RedisConfig{
+			URL:     cfg.Events.Redis.URL,
+			Channel: cfg.Events.Redis.Channel,
+			Buffer:  cfg.Events.BufferSize,
+		}, log)
+	default:
+		bus = events.NewMemoryBus(cfg.Events.BufferSize)
+	}
+	if err != nil {
+		log.Error("Failed to create event bus", err, nil)
+		os.Exit(1)
+	}
+	events.RecordMetrics(bus, metricsRegistry)
+
+	// Load custom schemas saved by earlier runs
+	registry, err := schema.NewPersistentRegistry(db)
+	if err != nil {
+		log.Error("Failed to load schema registry", err, nil)
+		os.Exit(1)
+	}
+	log.Info("Schema registry loaded", types.Fields{
+		"types": len(registry.ListTypes()),
+	})
+
+	// Background jobs run until shutdown
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+
+	// Jobs run by a single instance: the leader in cluster mode
+	var schedulers []func(ctx context.Context)
+
+	// Schedule backups
+	if cfg.Backup.IntervalMinutes > 0 {
+		backupStore, ok := db.(*store.SQLiteStore)
+		if !ok {
+			log.Error("Scheduled backups require the sqlite driver", nil, nil)
+			os.Exit(1)
+		}
+		schedule := store.BackupSchedule{
+			Dir:      cfg.Backup.Dir,
+			Interval: time.Duration(cfg.Backup.IntervalMinutes) * time.Minute,
+			Retain:   cfg.Backup.Retain,
+		}
+		schedulers = append(schedulers, func(ctx context.Context) {
+			if err := backupStore.ScheduleBackups(ctx, schedule); err != nil {
+				log.Error("Failed to schedule backups", err, nil)
+			}
+		})
+	}
+
+	// Create retention janitor
+	var janitor *store.Janitor
+	if cfg.Retention.IntervalMinutes > 0 {
+		janitor, err = store.NewJanitor(db, store.JanitorOptions{
+			Interval:  time.Duration(cfg.Retention.IntervalMinutes) * time.Minute,
+			BatchSize: cfg.Retention.BatchSize,
+			Policies:  retentionPolicies(cfg),
+		})
+		if err != nil {
+			log.Error("Failed to create retention janitor", err, nil)
+			os.Exit(1)
+		}
+		if err := metricsRegistry.Register(janitor); err != nil {
+			log.Error("Failed to register retention metrics", err, nil)
+			os.Exit(1)
+		}
+		schedulers = append(schedulers, func(ctx context.Context) {
+			if err := janitor.Run(ctx); err != nil {
+				log.Error("Retention janitor stopped", err, nil)
+			}
+		})
+	}
+
+	// Create alert evaluator
+	notifiers := []alerting.Notifier{alerting.NewBusNotifier(bus)}
+	if cfg.Alerting.WebhookURL != "" {
+		notifiers = append(notifiers, alerting.NewWebhookNotifier(cfg.Alerting.WebhookURL))
+	}
+	evaluator := alerting.New(db, metricsRegistry, log, alerting.Options{
+		Interval:  time.Duration(cfg.Alerting.IntervalSeconds) * time.Second,
+		Notifiers: notifiers,
+	})
+	if cfg.Alerting.IntervalSeconds > 0 {
+		schedulers = append(schedulers, func(ctx context.Context) {
+			if err := evaluator.Run(ctx); err != nil {
+				log.Error("Alert evaluator stopped", err, nil)
+			}
+		})
+	}
+
+	// Run the schedulers, on this instance or on the elected leader. The
+	// jobs holding leases give them up before the database closes.
+	var elector *cluster.Elector
+	var leaseHolders sync.WaitGroup
+	clusterOpts := cluster.Options{
+		Instance:      cfg.Cluster.InstanceID,
+		LeaseDuration: time.Duration(cfg.Cluster.LeaseSeconds) * time.Second,
+	}
+	if cfg.Cluster.Enabled {
+		elector = cluster.NewElector(db, log, clusterOpts)
+		log.Info("Cluster mode is enabled", types.Fields{
+			"instance": elector.Instance(),
+		})
+		leaseHolders.Add(1)
+		go func() {
+			defer leaseHolders.Done()
+			if err := elector.Run(bgCtx, runSchedulers(schedulers)); err != nil {
+				log.Error("Leader election stopped", err, nil)
+			}
+		}()
+	} else {
+		leaseHolders.Add(1)
+		go func() {
+			defer leaseHolders.Done()
+			runSchedulers(schedulers)(bgCtx)
+		}()
+	}
+
+	// Renew the leases of secrets, such as Vault database credentials
+	go secretCache.Run(bgCtx)
+
+	// Apply reloaded settings and record what changed
+	reloader.OnReload(func(cfg *config.Config, changes []config.Change) {
+		if config.Changed(changes, "logging.level") {
+			log.SetLevel(logger.ParseLevel(cfg.Logging.Level))
+		}
+		if config.Changed(changes, "logging.sampling") {
+			log.SetSampling(samplingRules(cfg))
+		}
+		if janitor != nil && config.Changed(changes, "retention") {
+			if err := janitor.SetPolicies(cfg.Retention.BatchSize, retentionPolicies(cfg)); err != nil {
+				log.Error("Failed to apply retention policies", err, nil)
+			}
+		}
+		err := bus.Publish(bgCtx, types.FlowEvent{
+			Type:    config.TypeConfigReloaded,
+			Message: fmt.Sprintf("Configuration reloaded with %d changed settings", len(changes)),
+			Data:    map[string]interface{}{"changes": changes},
+		})
+		if err != nil {
+			log.Error("Failed to publish configuration change", err, nil)
+		}
+	})
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-bgCtx.Done():
+				return
+			case <-hup:
+				// Rejected reloads are logged by the reloader
+				_, _ = reloader.Reload()
+			}
+		}
+	}()
+	if cfg.Reload.WatchSeconds > 0 {
+		go func() {
+			if err := reloader.Watch(bgCtx, time.Duration(cfg.Reload.WatchSeconds)*time.Second); err != nil {
+				log.Error("Config file watcher stopped", err, nil)
+			}
+		}()
+	}
+
+	// Restart on SIGUSR2 or when the admin API asks to
+	trigger := restart.NewTrigger()
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+	go func() {
+		for {
+			select {
+			case <-bgCtx.Done():
+				return
+			case <-usr2:
+				if err := trigger.Request(); err != nil {
+					log.Warn("Ignored restart signal", types.Fields{"reason": err.Error()})
+				}
+			}
+		}
+	}()
+
+	// Create server
+	serverOpts := []server.Option{
+		server.WithSchemaRegistry(registry),
+		server.WithTracer(tracer),
+		server.WithMetrics(metricsRegistry, process),
+		server.WithEventBus(bus),
+		server.WithAlerting(evaluator),
+		server.WithAdminToken(cfg.Server.AdminToken),
+		server.WithConfig(reloader.Config),
+		server.WithRestart(trigger.Request),
+		server.WithAPIKeys(cfg.Server.APIKeys...),
+		server.WithCompression(compression.Policy{
+			Default: cfg.Runtime.Compression.Default,
+			Flows:   cfg.Runtime.Compression.Flows,
+		}),
+		server.WithLimits(server.Limits{
+			DryRunMessages:  cfg.Runtime.Limits.DryRunMessages,
+			DryRunTimeout:   time.Duration(cfg.Runtime.Limits.DryRunTimeoutMs) * time.Millisecond,
+			MaxCallDepth:    cfg.Runtime.Limits.MaxCallDepth,
+			MaxPayloadBytes: cfg.Runtime.Limits.MaxPayloadBytes,
+			SpillDir:        cfg.Runtime.Limits.SpillDir,
+		}),
+		server.WithDataDir(cfg.Runtime.DataDir),
+		server.WithLookups(lookups(cfg)),
+		server.WithSecrets(secretCache),
+	}
+	if cfg.Runtime.Faults.Enabled {
+		log.Warn("Fault injection is enabled", types.Fields{
+			"flows": len(cfg.Runtime.Faults.Flows),
+		})
+		serverOpts = append(serverOpts, server.WithFaults(flowFaults(cfg)))
+	}
+	serverOpts = append(serverOpts, server.WithQuotas(projectQuotas(cfg)))
+	if elector != nil {
+		serverOpts = append(serverOpts, server.WithCluster(elector))
+	}
+	srv := server.New(db, log, serverOpts...)
+
+	// Share flow runs between the instances of a cluster, running the
+	// engines of the flows this instance claimed
+	if cfg.Cluster.Enabled {
+		clusterOpts.Admit = srv.AdmitRun
+		worker := cluster.NewWorker(db, log, clusterOpts, srv.RunFlow)
+		leaseHolders.Add(1)
+		go func() {
+			defer leaseHolders.Done()
+			if err := worker.Run(bgCtx); err != nil {
+				log.Error("Flow run sharing stopped", err, nil)
+			}
+		}()
+	}
+
+	// Create documentation server
+	var docsOpts []docserver.Option
+	if dir := os.Getenv("DOCS_TEMPLATE_DIR"); dir != "" {
+		docsOpts = append(docsOpts, docserver.WithTemplateDir(dir))
+	}
+	docs, err := docserver.New(log, docsOpts...)
+	if err != nil {
+		log.Error("Failed to create documentation server", err, nil)
+		os.Exit(1)
+	}
+	srv.Mount("/", docs.Routes())
+
+	// Create HTTP server
+	timeouts := cfg.Server.Timeouts
+	httpServer := &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
+		Handler:           srv,
+		ReadHeaderTimeout: time.Duration(timeouts.ReadHeaderSeconds) * time.Second,
+		ReadTimeout:       time.Duration(timeouts.ReadSeconds) * time.Second,
+		WriteTimeout:      time.Duration(timeouts.WriteSeconds) * time.Second,
+		IdleTimeout:       time.Duration(timeouts.IdleSeconds) * time.Second,
+	}
+	tlsEnabled := cfg.Server.TLS.CertFile != "" && cfg.Server.TLS.KeyFile != ""
+	if tlsEnabled {
+		httpServer.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.Server.TLS.MinVersion == "1.3" {
+			httpServer.TLSConfig.MinVersion = tls.VersionTLS13
+		}
+	}
+
+	// Closing the bus and the log streams ends open event and log streams,
+	// which would otherwise hold up shutdown
+	httpServer.RegisterOnShutdown(func() {
+		if err := bus.Close(); err != nil {
+			log.Error("Failed to close event bus", err, nil)
+		}
+		log.CloseStreams()
+	})
+
+	// Listen on the socket a restarting server passed on, if any
+	ln, err := restart.Listen(httpServer.Addr)
+	if err != nil {
+		log.Error("Failed to listen", err, nil)
+		stopBackground()
+		leaseHolders.Wait()
+		if err := db.Close(); err != nil {
+			log.Error("Failed to close database", err, nil)
+		}
+		os.Exit(1)
+	}
+
+	// Handle graceful shutdown and restarts
+	done := make(chan bool)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		drainTimeout := time.Duration(timeouts.DrainSeconds) * time.Second
+		listener := awaitStop(quit, trigger, ln, srv, drainTimeout, log)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeouts.ShutdownSeconds)*time.Second)
+		defer cancel()
+
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Error("Failed to gracefully shutdown server", err, nil)
+		}
+
+		stopBackground()
+		leaseHolders.Wait()
+
+		if listener != nil {
+			restartServer(db, cfg.Runtime.DataDir, listener, log)
+		}
+
+		if err := tracer.Shutdown(ctx); err != nil {
+			log.Error("Failed to flush traces", err, nil)
+		}
+
+		if loki != nil {
+			if err := loki.Close(ctx); err != nil {
+				log.Error("Failed to flush logs to loki", err, nil)
+			}
+		}
+
+		if err := db.Close(); err != nil {
+			log.Error("Failed to close database", err, nil)
+		}
+
+		close(done)
+	}()
+
+	// Start server
+	log.Info("Server is starting...", types.Fields{
+		"addr": httpServer.Addr,
+		"tls":  tlsEnabled,
+	})
+	if tlsEnabled {
+		err = httpServer.ServeTLS(ln, cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+	} else {
+		err = httpServer.Serve(ln)
+	}
+	if err != http.ErrServerClosed {
+		log.Error("Failed to start server", err, nil)
+		stopBackground()
+		leaseHolders.Wait()
+		if err := db.Close(); err != nil {
+			log.Error("Failed to close database", err, nil)
+		}
+		os.Exit(1)
+	}
+
+	<-done
+	log.Info("Server stopped", nil)
+}
+
+// runSchedulers returns a function running schedulers until its context is
+// cancelled
+func runSchedulers(schedulers []func(ctx context.Context)) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		var wg sync.WaitGroup
+		for _, run := range schedulers {
+			wg.Add(1)
+			go func(run func(ctx context.Context)) {
+				defer wg.Done()
+				run(ctx)
+			}(run)
+		}
+		wg.Wait()
+	}
+}
+
+// awaitStop blocks until the server is asked to shut down or restart. For
+// restarts it returns a duplicate of the listening socket, which keeps
+// queueing connections for the new server after ln is closed, and nil for
+// shutdowns. Restarts first wait up to drainTimeout for the backfills and
+// simulations of srv to end, as their state lives in memory; restarts that
+// cannot get the socket or drain the server are abandoned.
+func awaitStop(quit <-chan os.Signal, trigger *restart.Trigger, ln net.Listener, srv *server.Server, drainTimeout time.Duration, log *logger.Logger) *os.File {
+	for {
+		select {
+		case <-quit:
+			log.Info("Server is shutting down...", nil)
+			return nil
+		case <-trigger.C():
+			listener, err := restart.File(ln)
+			if err != nil {
+				log.Error("Failed to restart server", err, nil)
+				trigger.Reset()
+				continue
+			}
+			log.Info("Server is draining before restarting...", nil)
+			if err := drain(srv, drainTimeout); err != nil {
+				log.Error("Failed to restart server", err, nil)
+				_ = listener.Close()
+				trigger.Reset()
+				continue
+			}
+			log.Info("Server is restarting...", nil)
+			return listener
+		}
+	}
+}
+
+// drain waits up to timeout for the backfills and simulations of srv to
+// end
+func drain(srv *server.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return srv.Drain(ctx)
+}
+
+// restartServer checkpoints the running flows into dataDir and starts the
+// binary again on listener to resume them. It runs once the server stopped
+// taking requests, so no flow starts or stops between the checkpoint and
+// the resume.
+func restartServer(db store.Store, dataDir string, listener *os.File, log *logger.Logger) {
+	cp, err := restart.Take(db)
+	if err != nil {
+		log.Error("Failed to checkpoint flows", err, nil)
+		_ = listener.Close()
+		return
+	}
+	// Without a data directory, the checkpoint goes to a directory of its
+	// own that only the server can read
+	if dataDir == "" {
+		if dataDir, err = os.MkdirTemp("", "flowcontrol-restart-"); err != nil {
+			log.Error("Failed to checkpoint flows", err, nil)
+			_ = listener.Close()
+			return
+		}
+	}
+	path := filepath.Join(dataDir, fmt.Sprintf("flowcontrol-%d.checkpoint", os.Getpid()))
+	if err := cp.Write(path); err != nil {
+		log.Error("Failed to checkpoint flows", err, nil)
+		_ = listener.Close()
+		return
+	}
+
+	proc, err := restart.Exec(listener, path)
+	if err != nil {
+		log.Error("Failed to start new server", err, nil)
+		_ = os.Remove(path)
+		return
+	}
+	log.Info("Started new server", types.Fields{
+		"pid":   proc.Pid,
+		"flows": len(cp.Flows),
+	})
+}
+
+// retentionPolicies converts the configured retention for the janitor
+func retentionPolicies(cfg *config.Config) []store.RetentionPolicy {
+	policies := make([]store.RetentionPolicy, 0, len(cfg.Retention.MaxAgeDays))
+	for table, days := range cfg.Retention.MaxAgeDays {
+		policies = append(policies, store.RetentionPolicy{
+			Table:  table,
+			MaxAge: time.Duration(days) * 24 * time.Hour,
+		})
+	}
+	return policies
+}
+
+// projectQuotas converts the configured quotas of each project, and the
+// default quota of the others, for the server
+func projectQuotas(cfg *config.Config) map[string]server.Quota {
+	quotas := make(map[string]server.Quota, len(cfg.Runtime.Quotas.Projects)+1)
+	quotas[config.DefaultProject] = projectQuota(cfg.Runtime.Quotas.Default)
+	for name, q := range cfg.Runtime.Quotas.Projects {
+		quotas[name] = projectQuota(q)
+	}
+	return quotas
+}
+
+// projectQuota converts a configured quota for the server
+func projectQuota(q config.ProjectQuota) server.Quota {
+	return server.Quota{
+		MaxRuns:              q.MaxRuns,
+		MaxMessagesPerSecond: q.MaxMessagesPerSecond,
+		MaxStorageBytes:      int64(q.MaxStorageMB) << 20,
+	}
+}
+
+// flowFaults converts the configured faults of each flow for the server
+func flowFaults(cfg *config.Config) map[string]engine.Faults {
+	faults := make(map[string]engine.Faults, len(cfg.Runtime.Faults.Flows))
+	for id, f := range cfg.Runtime.Faults.Flows {
+		faults[id] = engine.Faults{
+			Nodes:       f.Nodes,
+			ErrorRate:   f.ErrorRate,
+			DropRate:    f.DropRate,
+			CorruptRate: f.CorruptRate,
+			LatencyRate: f.LatencyRate,
+			LatencyMs:   f.LatencyMs,
+			Seed:        f.Seed,
+		}
+	}
+	return faults
+}
+
+// lookups converts the configured endpoints of Enrich nodes for the server
+func lookups(cfg *config.Config) engine.Lookups {
+	databases := make(map[string]engine.LookupDatabase, len(cfg.Runtime.Lookups.Databases))
+	for name, db := range cfg.Runtime.Lookups.Databases {
+		databases[name] = engine.LookupDatabase{Driver: db.Driver, DSN: db.DSN}
+	}
+	return engine.Lookups{URLs: cfg.Runtime.Lookups.URLs, Databases: databases}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/types"
+
+	"github.com/spf13/cobra"
+)
+
+// newApprovalsCmd creates the approvals command and its subcommands
+func newApprovalsCmd(g *globals) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "approvals",
+		Short: "Decide the messages Approval nodes hold",
+	}
+	cmd.AddCommand(
+		newApprovalsListCmd(g),
+		newApprovalsDecideCmd(g, "approve", "Approve a message, passing it on"),
+		newApprovalsDecideCmd(g, "reject", "Reject a message, sending it on the rejected port"),
+		newApprovalsAuditCmd(g),
+	)
+	return cmd
+}
+
+func newApprovalsListCmd(g *globals) *cobra.Command {
+	var flowID string
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List pending approvals, the oldest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := "/approvals"
+			if flowID != "" {
+				path += "?" + url.Values{"flow": {flowID}}.Encode()
+			}
+			var approvals []engine.Approval
+			if err := g.client().do(cmd.Context(), http.MethodGet, path, nil, &approvals); err != nil {
+				return fmt.Errorf("failed to list approvals: %w", err)
+			}
+			return g.printApprovals(cmd.OutOrStdout(), approvals)
+		},
+	}
+	cmd.Flags().StringVar(&flowID, "flow", "", "Only list the approvals of this flow")
+	return cmd
+}
+
+// newApprovalsDecideCmd creates the approve or reject command, which posts
+// to the endpoint of the same name
+func newApprovalsDecideCmd(g *globals, action, short string) *cobra.Command {
+	var by, comment string
+	cmd := &cobra.Command{
+		Use:   action + " <id>",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if by == "" {
+				return fmt.Errorf("failed to %s approval %s: --by is required", action, args[0])
+			}
+			in := map[string]string{"by": by, "comment": comment}
+			var approval engine.Approval
+			if err := g.client().do(cmd.Context(), http.MethodPost, approvalPath(args[0], action), in, &approval); err != nil {
+				return fmt.Errorf("failed to %s approval %s: %w", action, args[0], err)
+			}
+			return g.printApprovals(cmd.OutOrStdout(), []engine.Approval{approval})
+		},
+	}
+	cmd.Flags().StringVar(&by, "by", os.Getenv("USER"), "Who decides, recorded in the audit trail")
+	cmd.Flags().StringVar(&comment, "comment", "", "Why, recorded in the audit trail")
+	return cmd
+}
+
+func newApprovalsAuditCmd(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "audit <id>",
+		Short: "Show who requested, escalated and decided an approval",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var records []types.AuditRecord
+			if err := g.client().do(cmd.Context(), http.MethodGet, approvalPath(args[0], "audit"), nil, &records); err != nil {
+				return fmt.Errorf("failed to get audit trail of approval %s: %w", args[0], err)
+			}
+			if g.output == outputJSON {
+				return printJSON(cmd.OutOrStdout(), records)
+			}
+			tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "TIME\tACTION\tBY\tCOMMENT")
+			for _, record := range records {
+				by, _ := record.Details["by"].(string)
+				comment, _ := record.Details["comment"].(string)
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", record.CreatedAt.Format(time.RFC3339), record.Action, by, comment)
+			}
+			return tw.Flush()
+		},
+	}
+}
+
+// printApprovals writes approvals as a table or as a JSON array
+func (g *globals) printApprovals(w io.Writer, approvals []engine.Approval) error {
+	if g.output == outputJSON {
+		if approvals == nil {
+			approvals = []engine.Approval{}
+		}
+		return printJSON(w, approvals)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tFLOW\tNODE\tTITLE\tSTATUS\tREQUESTED")
+	for _, approval := range approvals {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			approval.ID, approval.FlowID, approval.NodeID, approval.Title,
+			approval.Status, approval.RequestedAt.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+// approvalPath returns the API path of an approval, escaping its ID
+func approvalPath(id string, parts ...string) string {
+	path := "/approvals/" + url.PathEscape(id)
+	for _, part := range parts {
+		path += "/" + part
+	}
+	return path
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"flow-control/internal/types"
+
+	"github.com/spf13/cobra"
+)
+
+// newEventsCmd creates the events command and its subcommands
+func newEventsCmd(g *globals) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "events",
+		Short: "Watch flow events",
+	}
+	cmd.AddCommand(newEventsTailCmd(g))
+	return cmd
+}
+
+func newEventsTailCmd(g *globals) *cobra.Command {
+	var flowID string
+	var eventTypes []string
+	cmd := &cobra.Command{
+		Use:   "tail",
+		Short: "Print events as they are published, until interrupted",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := url.Values{}
+			if flowID != "" {
+				query.Set("flow_id", flowID)
+			}
+			for _, t := range eventTypes {
+				query.Add("type", t)
+			}
+			path := "/events"
+			if len(query) > 0 {
+				path += "?" + query.Encode()
+			}
+
+			body, err := g.client().open(cmd.Context(), path)
+			if err != nil {
+				return fmt.Errorf("failed to stream events: %w", err)
+			}
+			defer body.Close()
+
+			err = readEvents(body, func(event types.FlowEvent) error {
+				return g.printEvent(cmd.OutOrStdout(), event)
+			})
+			if err != nil && cmd.Context().Err() == nil {
+				return fmt.Errorf("failed to stream events: %w", err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&flowID, "flow", "", "Only print events of this flow")
+	cmd.Flags().StringSliceVar(&eventTypes, "type", nil, "Only print events of these types")
+	return cmd
+}
+
+// readEvents decodes the data of each server-sent event in r and passes it
+// to fn, until r ends or fn fails
+func readEvents(r io.Reader, fn func(types.FlowEvent) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event types.FlowEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// printEvent writes an event as one line of text or of JSON
+func (g *globals) printEvent(w io.Writer, event types.FlowEvent) error {
+	if g.output == outputJSON {
+		return json.NewEncoder(w).Encode(event)
+	}
+	_, err := fmt.Fprintf(w, "%s  %-16s %-20s %s\n",
+		event.Timestamp.Format(time.RFC3339), event.Type, event.FlowID, event.Message)
+	return err
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"flow-control/internal/events"
+	"flow-control/internal/logger"
+	"flow-control/internal/server"
+	"flow-control/internal/store"
+	"flow-control/internal/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestFlowctl(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "flowctl.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+	bus := events.NewMemoryBus(0)
+	defer func() { _ = bus.Close() }()
+
+	ts := httptest.NewServer(server.New(st, log, server.WithEventBus(bus), server.WithAPIKeys("secret")))
+	defer ts.Close()
+
+	run := func(ctx context.Context, args ...string) (string, error) {
+		var out bytes.Buffer
+		cmd := newRootCmd(&out, &out)
+		cmd.SetArgs(append([]string{"--server", ts.URL, "--api-key", "secret"}, args...))
+		err := cmd.ExecuteContext(ctx)
+		return out.String(), err
+	}
+	ctx := context.Background()
+
+	// Requests without the API key are rejected
+	cmd := newRootCmd(&bytes.Buffer{}, &bytes.Buffer{})
+	cmd.SetArgs([]string{"--server", ts.URL, "flows", "list"})
+	require.ErrorContains(t, cmd.Execute(), "401")
+
+	// Apply creates flows, then replaces them whatever their revision
+	path := filepath.Join(t.TempDir(), "flows.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"id": "orders", "name": "Orders", "config": "{}"},
+		{"id": "billing", "name": "Billing", "config": "{}"}
+	]`), 0o600))
+	_, err = run(ctx, "flows", "apply", "-f", path)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"id": "orders", "name": "Orders v2", "config": "{}"}`), 0o600))
+	out, err := run(ctx, "flows", "apply", "-f", path, "-o", "json")
+	require.NoError(t, err)
+	var applied []types.RuntimeFlow
+	require.NoError(t, json.Unmarshal([]byte(out), &applied))
+	require.Len(t, applied, 1)
+	require.Equal(t, 2, applied[0].Revision)
+
+	// Flows are listed as a table or as JSON
+	out, err = run(ctx, "flows", "list")
+	require.NoError(t, err)
+	require.Contains(t, out, "ID")
+	require.Contains(t, out, "Orders v2")
+	require.Contains(t, out, "billing")
+
+	out, err = run(ctx, "flows", "get", "orders", "-o", "json")
+	require.NoError(t, err)
+	var flow types.RuntimeFlow
+	require.NoError(t, json.Unmarshal([]byte(out), &flow))
+	require.Equal(t, "Orders v2", flow.Name)
+
+	_, err = run(ctx, "flows", "get", "missing")
+	require.ErrorContains(t, err, "404")
+	_, err = run(ctx, "flows", "list", "-o", "yaml")
+	require.ErrorContains(t, err, "invalid output format")
+
+	// Events are tailed until the context ends
+	tailCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	tailed := &syncBuffer{}
+	done := make(chan error, 1)
+	go func() {
+		cmd := newRootCmd(tailed, tailed)
+		cmd.SetArgs([]string{"--server", ts.URL, "--api-key", "secret", "events", "tail", "--flow", "orders", "-o", "json"})
+		done <- cmd.ExecuteContext(tailCtx)
+	}()
+	require.Eventually(t, func() bool {
+		// Keep publishing until the stream has subscribed
+		_ = bus.Publish(ctx, types.FlowEvent{FlowID: "orders", Type: "probe"})
+		return strings.Contains(tailed.String(), "probe")
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// Start and stop change the status and publish events
+	out, err = run(ctx, "flows", "start", "orders", "-o", "json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal([]byte(out), &flow))
+	require.Equal(t, "running", flow.Status)
+	_, err = run(ctx, "flows", "start", "orders", "--param", "region=eu")
+	require.ErrorContains(t, err, "unknown parameters region")
+	_, err = run(ctx, "flows", "stop", "orders")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(tailed.String(), events.TypeFlowStopped)
+	}, 5*time.Second, 50*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+
+	// Approvals are listed and decided by ID
+	out, err = run(ctx, "approvals", "list", "-o", "json")
+	require.NoError(t, err)
+	require.JSONEq(t, "[]", out)
+	_, err = run(ctx, "approvals", "approve", "missing", "--by", "alice")
+	require.ErrorContains(t, err, "404")
+	_, err = run(ctx, "approvals", "reject", "missing", "--by", "")
+	require.ErrorContains(t, err, "--by is required")
+
+	_, err = run(ctx, "flows", "delete", "orders", "billing")
+	require.NoError(t, err)
+	out, err = run(ctx, "flows", "list", "-o", "json")
+	require.NoError(t, err)
+	require.JSONEq(t, "[]", out)
+}
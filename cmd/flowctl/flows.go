@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"flow-control/internal/types"
+
+	"github.com/spf13/cobra"
+)
+
+// newFlowsCmd creates the flows command and its subcommands
+func newFlowsCmd(g *globals) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "flows",
+		Short: "Manage flows",
+	}
+	cmd.AddCommand(
+		newFlowsListCmd(g),
+		newFlowsGetCmd(g),
+		newFlowsApplyCmd(g),
+		newFlowsDeleteCmd(g),
+		newFlowsStatusCmd(g, "start", "Start a flow"),
+		newFlowsStatusCmd(g, "stop", "Stop a flow"),
+	)
+	return cmd
+}
+
+func newFlowsListCmd(g *globals) *cobra.Command {
+	var tags []string
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List flows",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := "/flows"
+			if len(tags) > 0 {
+				path += "?" + url.Values{"tag": tags}.Encode()
+			}
+			var flows []*types.RuntimeFlow
+			if err := g.client().do(cmd.Context(), http.MethodGet, path, nil, &flows); err != nil {
+				return fmt.Errorf("failed to list flows: %w", err)
+			}
+			return g.printFlows(cmd.OutOrStdout(), flows)
+		},
+	}
+	cmd.Flags().StringSliceVar(&tags, "tag", nil, "Only list flows carrying every given tag")
+	return cmd
+}
+
+func newFlowsGetCmd(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get <id>",
+		Short: "Show a flow",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var flow types.RuntimeFlow
+			if err := g.client().do(cmd.Context(), http.MethodGet, flowPath(args[0]), nil, &flow); err != nil {
+				return fmt.Errorf("failed to get flow %s: %w", args[0], err)
+			}
+			return g.printFlow(cmd.OutOrStdout(), &flow)
+		},
+	}
+}
+
+func newFlowsApplyCmd(g *globals) *cobra.Command {
+	var file string
+	cmd := &cobra.Command{
+		Use:   "apply -f <file>",
+		Short: "Create or replace flows from a JSON file",
+		Long: "Create or replace the flows in a JSON file holding one flow or an array of flows.\n" +
+			"Existing flows are overwritten, whatever their current revision. Use - to read stdin.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flows, err := readFlows(cmd.InOrStdin(), file)
+			if err != nil {
+				return err
+			}
+
+			c := g.client()
+			applied := make([]*types.RuntimeFlow, 0, len(flows))
+			for _, flow := range flows {
+				if flow.ID == "" {
+					return fmt.Errorf("failed to apply flow %q: missing id", flow.Name)
+				}
+				var current types.RuntimeFlow
+				err := c.do(cmd.Context(), http.MethodGet, flowPath(flow.ID), nil, &current)
+				switch {
+				case isNotFound(err):
+					err = c.do(cmd.Context(), http.MethodPost, "/flows", flow, flow)
+				case err == nil:
+					flow.Revision = current.Revision
+					err = c.do(cmd.Context(), http.MethodPut, flowPath(flow.ID), flow, flow)
+				}
+				if err != nil {
+					return fmt.Errorf("failed to apply flow %s: %w", flow.ID, err)
+				}
+				applied = append(applied, flow)
+			}
+			return g.printFlows(cmd.OutOrStdout(), applied)
+		},
+	}
+	cmd.Flags().StringVarP(&file, "file", "f", "", "JSON file with the flows to apply")
+	_ = cmd.MarkFlagRequired("file")
+	return cmd
+}
+
+func newFlowsDeleteCmd(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete <id>...",
+		Short: "Delete flows",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, id := range args {
+				if err := g.client().do(cmd.Context(), http.MethodDelete, flowPath(id), nil, nil); err != nil {
+					return fmt.Errorf("failed to delete flow %s: %w", id, err)
+				}
+				if g.output == outputTable {
+					fmt.Fprintf(cmd.OutOrStdout(), "flow %s deleted\n", id)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// newFlowsStatusCmd creates the start or stop command, which posts to the
+// endpoint of the same name
+func newFlowsStatusCmd(g *globals, action, short string) *cobra.Command {
+	var params map[string]string
+	cmd := &cobra.Command{
+		Use:   action + " <id>",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var in interface{}
+			if len(params) > 0 {
+				in = map[string]interface{}{"params": params}
+			}
+			var flow types.RuntimeFlow
+			if err := g.client().do(cmd.Context(), http.MethodPost, flowPath(args[0], action), in, &flow); err != nil {
+				return fmt.Errorf("failed to %s flow %s: %w", action, args[0], err)
+			}
+			return g.printFlow(cmd.OutOrStdout(), &flow)
+		},
+	}
+	if action == "start" {
+		cmd.Flags().StringToStringVar(&params, "param", nil, "Value of a parameter the flow declares, as name=value")
+	}
+	return cmd
+}
+
+// readFlows reads one flow or an array of flows from path, or from stdin
+// when path is -
+func readFlows(stdin io.Reader, path string) ([]*types.RuntimeFlow, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read flows: %w", err)
+	}
+
+	var flows []*types.RuntimeFlow
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		err = json.Unmarshal(data, &flows)
+	} else {
+		flow := &types.RuntimeFlow{}
+		err = json.Unmarshal(data, flow)
+		flows = append(flows, flow)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse flows: %w", err)
+	}
+	return flows, nil
+}
+
+// printFlow writes a flow as a table row or as a JSON object
+func (g *globals) printFlow(w io.Writer, flow *types.RuntimeFlow) error {
+	if g.output == outputJSON {
+		return printJSON(w, flow)
+	}
+	return g.printFlows(w, []*types.RuntimeFlow{flow})
+}
+
+// printFlows writes flows as a table or as a JSON array
+func (g *globals) printFlows(w io.Writer, flows []*types.RuntimeFlow) error {
+	if g.output == outputJSON {
+		if flows == nil {
+			flows = []*types.RuntimeFlow{}
+		}
+		return printJSON(w, flows)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSTATUS\tREVISION\tTAGS\tUPDATED")
+	for _, flow := range flows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n",
+			flow.ID, flow.Name, flow.Status, flow.Revision,
+			strings.Join(flow.Tags, ","), flow.UpdatedAt.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+// printJSON writes v as indented JSON
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,86 @@
+/*
+Package main implements flowctl, a command-line client for the Flow Control
+HTTP API.
+
+Usage:
+
+	flowctl flows list|get|apply|delete|start|stop [flags]
+	flowctl events tail [flags]
+	flowctl approvals list|approve|reject|audit [flags]
+
+The server and API key are taken from the --server and --api-key flags, or
+the FLOWCTL_SERVER and FLOWCTL_API_KEY environment variables. Results are
+printed as a table, or as JSON with --output json.
+*/
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// Output formats
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// defaultServer is the server used when neither the flag nor the
+// environment names one
+const defaultServer = "http://localhost:8080"
+
+// globals holds the flags shared by every command
+type globals struct {
+	server string
+	apiKey string
+	output string
+}
+
+func main() {
+	if err := newRootCmd(os.Stdout, os.Stderr).Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// newRootCmd creates the flowctl command tree writing to out and errOut
+func newRootCmd(out, errOut io.Writer) *cobra.Command {
+	g := &globals{}
+	root := &cobra.Command{
+		Use:           "flowctl",
+		Short:         "Manage Flow Control flows from the command line",
+		Version:       version,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if g.output != outputTable && g.output != outputJSON {
+				return fmt.Errorf("invalid output format %q: must be %s or %s", g.output, outputTable, outputJSON)
+			}
+			return nil
+		},
+	}
+	root.SetOut(out)
+	root.SetErr(errOut)
+
+	server := os.Getenv("FLOWCTL_SERVER")
+	if server == "" {
+		server = defaultServer
+	}
+	root.PersistentFlags().StringVar(&g.server, "server", server, "Flow Control server URL (FLOWCTL_SERVER)")
+	root.PersistentFlags().StringVar(&g.apiKey, "api-key", os.Getenv("FLOWCTL_API_KEY"), "API key to authenticate with (FLOWCTL_API_KEY)")
+	root.PersistentFlags().StringVarP(&g.output, "output", "o", outputTable, "Output format (table, json)")
+
+	root.AddCommand(newFlowsCmd(g), newEventsCmd(g), newApprovalsCmd(g))
+	return root
+}
+
+// client creates an API client from the global flags
+func (g *globals) client() *client {
+	return newClient(g.server, g.apiKey)
+}
@@ -0,0 +1,163 @@
+package alerting_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"flow-control/internal/alerting"
+	"flow-control/internal/events"
+	"flow-control/internal/logger"
+	"flow-control/internal/metrics"
+	"flow-control/internal/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memoryRules is a RuleStore keeping rules in a map
+type memoryRules map[string]*types.AlertRule
+
+func (m memoryRules) SaveAlertRule(rule *types.AlertRule) error {
+	m[rule.ID] = rule
+	return nil
+}
+
+func (m memoryRules) ListAlertRules() ([]*types.AlertRule, error) {
+	rules := make([]*types.AlertRule, 0, len(m))
+	for _, rule := range m {
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (m memoryRules) DeleteAlertRule(id string) error {
+	delete(m, id)
+	return nil
+}
+
+// recorder is a Notifier remembering every alert
+type recorder struct {
+	alerts []alerting.Alert
+}
+
+func (r *recorder) Notify(ctx context.Context, alert alerting.Alert) error {
+	r.alerts = append(r.alerts, alert)
+	return nil
+}
+
+func TestEvaluator(t *testing.T) {
+	ctx := context.Background()
+	registry := metrics.NewRegistry()
+	rules := memoryRules{}
+	notified := &recorder{}
+	evaluator := alerting.New(rules, registry, logger.New(), alerting.Options{
+		Notifiers: []alerting.Notifier{notified},
+	})
+
+	// Invalid rules are rejected
+	err := evaluator.SaveRule(&types.AlertRule{ID: "bad", FlowID: "orders", Condition: "cpu", WindowSeconds: 60})
+	require.ErrorIs(t, err, alerting.ErrInvalidRule)
+	err = evaluator.SaveRule(&types.AlertRule{ID: "bad", FlowID: "orders", Condition: types.AlertErrorRate, Threshold: 2, WindowSeconds: 60})
+	require.ErrorIs(t, err, alerting.ErrInvalidRule)
+
+	for _, rule := range []*types.AlertRule{
+		{ID: "errors", FlowID: "orders", Condition: types.AlertErrorRate, Threshold: 0.1, WindowSeconds: 60, Enabled: true},
+		{ID: "silence", FlowID: "orders", Condition: types.AlertNoMessages, WindowSeconds: 120, Enabled: true},
+		{ID: "latency", FlowID: "orders", Condition: types.AlertLatencyP99, Threshold: 1, WindowSeconds: 60, Enabled: true},
+	} {
+		require.NoError(t, evaluator.SaveRule(rule))
+	}
+
+	record := func(n int, status string, duration int64) {
+		for i := 0; i < n; i++ {
+			metrics.RecordFlow(registry, types.FlowMetrics{FlowID: "orders", NodeID: "n1", Status: status, Duration: duration})
+		}
+	}
+
+	start := time.Now()
+	record(10, "ok", 10)
+	require.NoError(t, evaluator.Evaluate(ctx, start))
+	require.Empty(t, evaluator.Active())
+
+	// Healthy traffic raises nothing
+	record(10, "ok", 10)
+	require.NoError(t, evaluator.Evaluate(ctx, start.Add(30*time.Second)))
+	require.Empty(t, evaluator.Active())
+
+	// Failures and slow messages within the window fire
+	record(5, "error", 10)
+	record(5, "ok", 3000)
+	require.NoError(t, evaluator.Evaluate(ctx, start.Add(60*time.Second)))
+	active := evaluator.Active()
+	require.Len(t, active, 2)
+	require.Len(t, notified.alerts, 2)
+	byRule := map[string]alerting.Alert{}
+	for _, alert := range active {
+		require.Equal(t, alerting.StateFiring, alert.State)
+		byRule[alert.Rule.ID] = alert
+	}
+	require.InDelta(t, 0.25, byRule["errors"].Value, 0.001)
+	require.Greater(t, byRule["latency"].Value, 1.0)
+
+	// Once the window moves past the bad messages, the alerts resolve
+	record(10, "ok", 10)
+	require.NoError(t, evaluator.Evaluate(ctx, start.Add(150*time.Second)))
+	require.Empty(t, evaluator.Active())
+	require.Len(t, notified.alerts, 4)
+	require.Equal(t, alerting.StateResolved, notified.alerts[2].State)
+	require.NotNil(t, notified.alerts[2].EndsAt)
+
+	// A silent flow fires once the window passes without messages
+	require.NoError(t, evaluator.Evaluate(ctx, start.Add(240*time.Second)))
+	require.Empty(t, evaluator.Active())
+	require.NoError(t, evaluator.Evaluate(ctx, start.Add(300*time.Second)))
+	active = evaluator.Active()
+	require.Len(t, active, 1)
+	require.Equal(t, "silence", active[0].Rule.ID)
+
+	// Deleting the rule resolves its alert
+	require.NoError(t, evaluator.DeleteRule("silence"))
+	require.NoError(t, evaluator.Evaluate(ctx, start.Add(310*time.Second)))
+	require.Empty(t, evaluator.Active())
+	last := notified.alerts[len(notified.alerts)-1]
+	require.Equal(t, "silence", last.Rule.ID)
+	require.Equal(t, alerting.StateResolved, last.State)
+}
+
+func TestNotifiers(t *testing.T) {
+	ctx := context.Background()
+	alert := alerting.Alert{
+		Rule:     types.AlertRule{ID: "errors", FlowID: "orders", Condition: types.AlertErrorRate, Threshold: 0.1},
+		State:    alerting.StateFiring,
+		Value:    0.5,
+		StartsAt: time.Now(),
+	}
+
+	// Webhooks receive the alert as JSON
+	received := make(chan alerting.Alert, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var got alerting.Alert
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		received <- got
+	}))
+	defer hook.Close()
+	require.NoError(t, alerting.NewWebhookNotifier(hook.URL).Notify(ctx, alert))
+	require.Equal(t, "errors", (<-received).Rule.ID)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	require.Error(t, alerting.NewWebhookNotifier(failing.URL).Notify(ctx, alert))
+
+	// The bus receives the alert as a flow event
+	bus := events.NewMemoryBus(0)
+	sub := bus.Subscribe(events.Filter{FlowID: "orders"})
+	require.NoError(t, alerting.NewBusNotifier(bus).Notify(ctx, alert))
+	event := <-sub.Events()
+	require.Equal(t, alerting.TypeAlertFiring, event.Type)
+	require.Equal(t, "errors", event.Data["rule_id"])
+}
@@ -0,0 +1,368 @@
+/*
+Package alerting evaluates per-flow alert rules against the flow metrics
+recorded in a metrics registry, and notifies when an alert starts or stops
+firing.
+
+Rules compare a condition over a sliding window with a threshold: the error
+rate, the time since the last message, or the 99th percentile latency. The
+window is measured between evaluations, so it is only as precise as the
+evaluation interval.
+*/
+package alerting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"flow-control/internal/metrics"
+	"flow-control/internal/types"
+)
+
+// Alert states
+const (
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// defaultInterval is the time between evaluations when Options.Interval is
+// not set
+const defaultInterval = 30 * time.Second
+
+// ErrInvalidRule is returned when saving a rule that cannot be evaluated
+var ErrInvalidRule = errors.New("invalid alert rule")
+
+// RuleStore persists alert rules. The SQL stores implement it.
+type RuleStore interface {
+	SaveAlertRule(rule *types.AlertRule) error
+	ListAlertRules() ([]*types.AlertRule, error)
+	DeleteAlertRule(id string) error
+}
+
+// Source provides the flow metrics rules are evaluated against.
+// metrics.Registry implements it.
+type Source interface {
+	Value(name string, labels map[string]string) (float64, bool)
+	Histogram(name string, labels map[string]string) (metrics.HistogramSnapshot, bool)
+}
+
+// Notifier delivers alerts when they fire and when they resolve
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// Alert is a rule whose condition holds, or held until it resolved
+type Alert struct {
+	// Rule is the rule that fired
+	Rule types.AlertRule `json:"rule"`
+	// State is StateFiring or StateResolved
+	State string `json:"state"`
+	// Value is the measured value of the rule's condition
+	Value float64 `json:"value"`
+	// Message describes the alert for humans
+	Message string `json:"message"`
+	// StartsAt is when the alert started firing
+	StartsAt time.Time `json:"starts_at"`
+	// EndsAt is when the alert resolved
+	EndsAt *time.Time `json:"ends_at,omitempty"`
+}
+
+// Options configures an Evaluator
+type Options struct {
+	// Interval is the time between evaluations
+	Interval time.Duration
+	// Notifiers are told about every alert that fires or resolves
+	Notifiers []Notifier
+}
+
+// Evaluator periodically evaluates the stored rules and notifies about
+// alerts as they change state
+type Evaluator struct {
+	store  RuleStore
+	source Source
+	log    types.Logger
+	opts   Options
+
+	mu     sync.Mutex
+	states map[string]*ruleState
+}
+
+// ruleState is what an evaluator remembers about a rule between evaluations
+type ruleState struct {
+	rule    types.AlertRule
+	samples []sample
+	// lastMessages and lastChange track when the message count last moved
+	lastMessages float64
+	lastChange   time.Time
+	active       *Alert
+}
+
+// sample is the state of a flow's metrics at one evaluation
+type sample struct {
+	at       time.Time
+	messages float64
+	errors   float64
+	latency  metrics.HistogramSnapshot
+}
+
+// New creates an evaluator for the rules in store
+func New(store RuleStore, source Source, log types.Logger, opts Options) *Evaluator {
+	if opts.Interval <= 0 {
+		opts.Interval = defaultInterval
+	}
+	return &Evaluator{
+		store:  store,
+		source: source,
+		log:    log,
+		opts:   opts,
+		states: make(map[string]*ruleState),
+	}
+}
+
+// ValidateRule checks that a rule can be evaluated
+func ValidateRule(rule *types.AlertRule) error {
+	if rule.FlowID == "" {
+		return fmt.Errorf("%w: flow id cannot be empty", ErrInvalidRule)
+	}
+	if rule.WindowSeconds <= 0 {
+		return fmt.Errorf("%w: window must be positive", ErrInvalidRule)
+	}
+	if rule.Threshold < 0 {
+		return fmt.Errorf("%w: threshold cannot be negative", ErrInvalidRule)
+	}
+	switch rule.Condition {
+	case types.AlertErrorRate:
+		if rule.Threshold > 1 {
+			return fmt.Errorf("%w: error rate threshold must be between 0 and 1", ErrInvalidRule)
+		}
+	case types.AlertNoMessages, types.AlertLatencyP99:
+	default:
+		return fmt.Errorf("%w: unknown condition %q", ErrInvalidRule, rule.Condition)
+	}
+	return nil
+}
+
+// Rules returns every stored rule
+func (e *Evaluator) Rules() ([]*types.AlertRule, error) {
+	return e.store.ListAlertRules()
+}
+
+// SaveRule validates and stores a rule. It takes effect at the next
+// evaluation.
+func (e *Evaluator) SaveRule(rule *types.AlertRule) error {
+	if err := ValidateRule(rule); err != nil {
+		return err
+	}
+	return e.store.SaveAlertRule(rule)
+}
+
+// DeleteRule removes a rule. An alert it raised resolves at the next
+// evaluation.
+func (e *Evaluator) DeleteRule(id string) error {
+	return e.store.DeleteAlertRule(id)
+}
+
+// Active returns the alerts currently firing, oldest first
+func (e *Evaluator) Active() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	alerts := []Alert{}
+	for _, state := range e.states {
+		if state.active != nil {
+			alerts = append(alerts, *state.active)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].StartsAt.Equal(alerts[j].StartsAt) {
+			return alerts[i].Rule.ID < alerts[j].Rule.ID
+		}
+		return alerts[i].StartsAt.Before(alerts[j].StartsAt)
+	})
+	return alerts
+}
+
+// Run evaluates the rules every Interval until ctx is done
+func (e *Evaluator) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			if err := e.Evaluate(ctx, now); err != nil && ctx.Err() == nil {
+				e.log.Error("Alert evaluation failed", err, types.Fields{
+					"function": "Run",
+				})
+			}
+		}
+	}
+}
+
+// Evaluate samples the metrics of every enabled rule as of now and notifies
+// about alerts that started firing or resolved since the last evaluation
+func (e *Evaluator) Evaluate(ctx context.Context, now time.Time) error {
+	rules, err := e.store.ListAlertRules()
+	if err != nil {
+		return fmt.Errorf("failed to load alert rules: %w", err)
+	}
+
+	var changed []Alert
+	e.mu.Lock()
+	seen := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		seen[rule.ID] = true
+		if alert := e.evaluateRule(*rule, now); alert != nil {
+			changed = append(changed, *alert)
+		}
+	}
+
+	// Alerts of rules that were deleted or disabled resolve
+	for id, state := range e.states {
+		if seen[id] {
+			continue
+		}
+		if state.active != nil {
+			changed = append(changed, resolve(state.active, now))
+		}
+		delete(e.states, id)
+	}
+	e.mu.Unlock()
+
+	for _, alert := range changed {
+		e.notify(ctx, alert)
+	}
+	return nil
+}
+
+// evaluateRule samples a rule's flow and updates its alert. It returns the
+// alert if its state changed. Must be called with e.mu held.
+func (e *Evaluator) evaluateRule(rule types.AlertRule, now time.Time) *Alert {
+	state, ok := e.states[rule.ID]
+	if !ok || state.rule.FlowID != rule.FlowID || state.rule.Condition != rule.Condition {
+		// A rule watching something else starts measuring afresh
+		fresh := &ruleState{lastChange: now}
+		if ok {
+			fresh.active = state.active
+		}
+		state = fresh
+		e.states[rule.ID] = state
+	}
+	state.rule = rule
+
+	window := time.Duration(rule.WindowSeconds) * time.Second
+	current := e.sample(rule.FlowID, now)
+	state.record(current, window)
+	if current.messages != state.lastMessages {
+		state.lastMessages = current.messages
+		state.lastChange = now
+	}
+
+	value, firing, message := evaluateCondition(rule, state, now)
+	switch {
+	case firing && state.active == nil:
+		state.active = &Alert{
+			Rule:     rule,
+			State:    StateFiring,
+			Value:    value,
+			Message:  message,
+			StartsAt: now,
+		}
+		alert := *state.active
+		return &alert
+	case firing:
+		state.active.Rule = rule
+		state.active.Value = value
+		state.active.Message = message
+	case state.active != nil:
+		state.active.Value = value
+		alert := resolve(state.active, now)
+		state.active = nil
+		return &alert
+	}
+	return nil
+}
+
+// sample reads the current metrics of a flow
+func (e *Evaluator) sample(flowID string, now time.Time) sample {
+	labels := map[string]string{"flow_id": flowID}
+	messages, _ := e.source.Value(metrics.FlowMessagesMetric, labels)
+	errs, _ := e.source.Value(metrics.FlowErrorsMetric, labels)
+	latency, _ := e.source.Histogram(metrics.FlowLatencyMetric, labels)
+	return sample{at: now, messages: messages, errors: errs, latency: latency}
+}
+
+// record adds a sample, keeping the newest sample older than the window as
+// the baseline the window is measured from
+func (s *ruleState) record(current sample, window time.Duration) {
+	s.samples = append(s.samples, current)
+	cutoff := current.at.Add(-window)
+	drop := 0
+	for drop+1 < len(s.samples) && !s.samples[drop+1].at.After(cutoff) {
+		drop++
+	}
+	s.samples = s.samples[drop:]
+}
+
+// evaluateCondition measures a rule's condition over its window
+func evaluateCondition(rule types.AlertRule, state *ruleState, now time.Time) (value float64, firing bool, message string) {
+	base, current := state.samples[0], state.samples[len(state.samples)-1]
+	window := time.Duration(rule.WindowSeconds) * time.Second
+
+	switch rule.Condition {
+	case types.AlertErrorRate:
+		messages := current.messages - base.messages
+		if messages <= 0 {
+			return 0, false, ""
+		}
+		value = (current.errors - base.errors) / messages
+		return value, value > rule.Threshold,
+			fmt.Sprintf("Flow %s error rate %.1f%% exceeds %.1f%% over %s", rule.FlowID, value*100, rule.Threshold*100, window)
+	case types.AlertNoMessages:
+		silent := now.Sub(state.lastChange)
+		return silent.Seconds(), silent >= window,
+			fmt.Sprintf("Flow %s has processed no messages for %s", rule.FlowID, silent.Truncate(time.Second))
+	case types.AlertLatencyP99:
+		latency := current.latency.Sub(base.latency)
+		if latency.Count == 0 {
+			return 0, false, ""
+		}
+		value = latency.Quantile(0.99)
+		return value, value > rule.Threshold,
+			fmt.Sprintf("Flow %s p99 latency %.3fs exceeds %.3fs over %s", rule.FlowID, value, rule.Threshold, window)
+	}
+	return 0, false, ""
+}
+
+// resolve returns a resolved copy of an active alert
+func resolve(active *Alert, now time.Time) Alert {
+	alert := *active
+	alert.State = StateResolved
+	alert.EndsAt = &now
+	return alert
+}
+
+// notify hands an alert to every notifier, logging failures
+func (e *Evaluator) notify(ctx context.Context, alert Alert) {
+	fields := types.Fields{
+		"function": "notify",
+		"rule_id":  alert.Rule.ID,
+		"flow_id":  alert.Rule.FlowID,
+		"state":    alert.State,
+	}
+	e.log.Info("Alert "+alert.State, fields)
+
+	for _, notifier := range e.opts.Notifiers {
+		if err := notifier.Notify(ctx, alert); err != nil {
+			e.log.Error("Failed to send alert notification", err, fields)
+		}
+	}
+}
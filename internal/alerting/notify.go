@@ -0,0 +1,87 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"flow-control/internal/events"
+	"flow-control/internal/types"
+)
+
+// Event types published for alerts
+const (
+	TypeAlertFiring   = "alert.firing"
+	TypeAlertResolved = "alert.resolved"
+)
+
+// WebhookNotifier posts alerts as JSON to a URL
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier creates a notifier posting to url
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Notify implements Notifier.Notify
+func (n *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post alert: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to post alert: webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// BusNotifier publishes alerts as flow events, so that event streams and
+// notification nodes subscribed to the bus receive them
+type BusNotifier struct {
+	bus events.Bus
+}
+
+// NewBusNotifier creates a notifier publishing to bus
+func NewBusNotifier(bus events.Bus) *BusNotifier {
+	return &BusNotifier{bus: bus}
+}
+
+// Notify implements Notifier.Notify
+func (n *BusNotifier) Notify(ctx context.Context, alert Alert) error {
+	eventType := TypeAlertFiring
+	if alert.State == StateResolved {
+		eventType = TypeAlertResolved
+	}
+	return n.bus.Publish(ctx, types.FlowEvent{
+		FlowID:  alert.Rule.FlowID,
+		Type:    eventType,
+		Message: alert.Message,
+		Data: map[string]interface{}{
+			"rule_id":   alert.Rule.ID,
+			"condition": alert.Rule.Condition,
+			"threshold": alert.Rule.Threshold,
+			"value":     alert.Value,
+		},
+	})
+}
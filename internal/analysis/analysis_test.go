@@ -0,0 +1,121 @@
+package analysis_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"flow-control/internal/analysis"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeModule writes files, by path, into a new module called example
+func writeModule(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	files["go.mod"] = "module example\n\ngo 1.22\n"
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(src), 0o600))
+	}
+	return dir
+}
+
+func TestBuildGraph(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"main.go":                     "package main\n\nimport (\n\t\"fmt\"\n\n\t\"example/internal/a\"\n)\n\nfunc main() { fmt.Println(a.A) }\n",
+		"internal/a/a.go":             "package a\n\nimport \"example/internal/b\"\n\nvar A = b.B\n",
+		"internal/a/a_test.go":        "package a_test\n\nimport \"example/internal/a\"\n\nvar _ = a.A\n",
+		"internal/b/b.go":             "package b\n\nconst B = 1\n",
+		"internal/b/testdata/skip.go": "package broken(\n",
+		"vendor/x/x.go":               "package broken(\n",
+	})
+
+	g, err := analysis.BuildGraph(dir)
+	require.NoError(t, err)
+	require.Equal(t, "example", g.Module)
+	require.Len(t, g.Packages, 3)
+	require.Equal(t, []string{"example/internal/a"}, g.Packages["example"].Imports)
+	// Test files do not add imports, so the external test does not import
+	// its own package
+	require.Equal(t, []string{"example/internal/b"}, g.Packages["example/internal/a"].Imports)
+	require.Equal(t, []string{"internal/a/a.go", "internal/a/a_test.go"}, g.Packages["example/internal/a"].Files)
+	require.Equal(t, []string{"example/internal/a"}, g.Importers("example/internal/b"))
+	require.Empty(t, analysis.Cycles(g))
+
+	_, err = analysis.BuildGraph(filepath.Join(dir, "internal"))
+	require.ErrorContains(t, err, "go.mod")
+}
+
+func TestCycles(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"a/a.go": "package a\n\nimport \"example/b\"\n\nvar A = b.B\n",
+		"b/b.go": "package b\n\nimport \"example/c\"\n\nvar B = c.C\n",
+		"c/c.go": "package c\n\nimport \"example/a\"\n\nvar C = a.A\n",
+		"d/d.go": "package d\n\nimport \"example/a\"\n\nvar D = a.A\n",
+	})
+
+	g, err := analysis.BuildGraph(dir)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"example/a", "example/b", "example/c"}}, analysis.Cycles(g))
+}
+
+func TestFindUnused(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"main.go": `package main
+
+import (
+	util "example/internal/util"
+	"example/pkg/api"
+)
+
+func main() {
+	util.Used()
+	_ = api.Client{}
+	helper()
+}
+
+func helper() {}
+
+func unusedHelper() {}
+`,
+		"internal/util/util.go": `package util
+
+// Limit is only used by tests
+const Limit = 10
+
+type options struct{ debug bool }
+
+type T struct{}
+
+// Method is never reported, and does not use T
+func (T) Method() {}
+
+var (
+	_        = options{}
+	Unused   = 1
+	internal = Unused
+)
+
+func init() {}
+
+func Used() {}
+`,
+		"internal/util/util_test.go": "package util_test\n\nimport \"example/internal/util\"\n\nvar _ = util.Limit\n",
+		"internal/util/gen.go":       "// Code generated by hand. DO NOT EDIT.\n\npackage util\n\nfunc Generated() {}\n",
+		"internal/orphan/orphan.go":  "package orphan\n\nfunc Orphan() {}\n",
+		"pkg/api/api.go":             "package api\n\ntype Client struct{}\n\nfunc New() *Client { return nil }\n\nfunc private() {}\n",
+	})
+
+	g, err := analysis.BuildGraph(dir)
+	require.NoError(t, err)
+	require.Equal(t, []analysis.Unused{
+		{Package: "example/internal/orphan", Kind: analysis.KindPackage, File: "internal/orphan"},
+		{Package: "example/internal/orphan", Name: "Orphan", Kind: analysis.KindFunc, File: "internal/orphan/orphan.go", Line: 3},
+		{Package: "example/internal/util", Name: "T", Kind: analysis.KindType, File: "internal/util/util.go", Line: 8},
+		{Package: "example/internal/util", Name: "internal", Kind: analysis.KindVar, File: "internal/util/util.go", Line: 16},
+		{Package: "example", Name: "unusedHelper", Kind: analysis.KindFunc, File: "main.go", Line: 16},
+		{Package: "example/pkg/api", Name: "private", Kind: analysis.KindFunc, File: "pkg/api/api.go", Line: 7},
+	}, analysis.FindUnused(g))
+}
@@ -0,0 +1,245 @@
+/*
+Package analysis builds the package dependency graph of a Go module from its
+sources and finds dead code and import cycles in it.
+
+It works on the syntax of the files alone, without type checking, so that
+it also runs on trees that do not build. FindUnused is therefore
+conservative: a declaration counts as used when its name appears anywhere
+in its package or is selected from it by an importer, even if the name
+refers to something else, such as a field or a shadowing variable. Methods
+are never reported, because they may satisfy interfaces.
+*/
+package analysis
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Package is a package of the module
+type Package struct {
+	// Path is the import path of the package
+	Path string `json:"path"`
+	Name string `json:"name"`
+	// Dir is the directory of the package, relative to the module root
+	Dir   string   `json:"dir"`
+	Files []string `json:"files"`
+	// Imports lists the packages of the module that the package's non-test
+	// files import, sorted
+	Imports []string `json:"imports"`
+
+	files     []*ast.File
+	testFiles []*ast.File
+}
+
+// Graph is the import graph of the packages of a module
+type Graph struct {
+	Module string `json:"module"`
+	// Root is the directory of the module
+	Root     string              `json:"root"`
+	Packages map[string]*Package `json:"packages"`
+
+	fset *token.FileSet
+}
+
+// BuildGraph parses the Go files of the module at root, skipping vendor
+// and testdata directories, hidden directories and nested modules
+func BuildGraph(root string) (*Graph, error) {
+	module, err := modulePath(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	g := &Graph{
+		Module:   module,
+		Root:     root,
+		Packages: map[string]*Package{},
+		fset:     token.NewFileSet(),
+	}
+
+	err = filepath.WalkDir(root, func(dir string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if dir != root {
+			name := d.Name()
+			if name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+		}
+		return g.addDir(dir)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read module %s: %w", module, err)
+	}
+
+	for _, pkg := range g.Packages {
+		imports := map[string]bool{}
+		for _, file := range pkg.files {
+			for _, spec := range file.Imports {
+				imported, _ := strconv.Unquote(spec.Path.Value)
+				if _, ok := g.Packages[imported]; ok {
+					imports[imported] = true
+				}
+			}
+		}
+		pkg.Imports = sortedKeys(imports)
+	}
+	return g, nil
+}
+
+// addDir parses the Go files in dir as a package of g
+func (g *Graph) addDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(g.Root, dir)
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+
+	pkg := &Package{Path: path.Join(g.Module, rel), Dir: rel}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		file, err := parser.ParseFile(g.fset, filepath.Join(dir, name), nil, parser.ParseComments|parser.SkipObjectResolution)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", filepath.Join(rel, name), err)
+		}
+		pkg.Files = append(pkg.Files, path.Join(rel, name))
+		if strings.HasSuffix(name, "_test.go") {
+			pkg.testFiles = append(pkg.testFiles, file)
+			continue
+		}
+		pkg.files = append(pkg.files, file)
+		if pkg.Name == "" {
+			pkg.Name = file.Name.Name
+		}
+	}
+	if len(pkg.files) > 0 {
+		g.Packages[pkg.Path] = pkg
+	}
+	return nil
+}
+
+// Importers returns the packages of the module that import the package at
+// path, sorted
+func (g *Graph) Importers(path string) []string {
+	var importers []string
+	for _, pkg := range g.Packages {
+		for _, imported := range pkg.Imports {
+			if imported == path {
+				importers = append(importers, pkg.Path)
+			}
+		}
+	}
+	sort.Strings(importers)
+	return importers
+}
+
+// Cycles returns the import cycles of g, each as the sorted paths of the
+// packages that import each other. Go rejects such cycles, so they only
+// appear in trees that do not build.
+func Cycles(g *Graph) [][]string {
+	// Tarjan's algorithm finds the strongly connected components
+	index := map[string]int{}
+	low := map[string]int{}
+	onStack := map[string]bool{}
+	var stack []string
+	var cycles [][]string
+
+	var visit func(path string)
+	visit = func(path string) {
+		index[path] = len(index)
+		low[path] = index[path]
+		stack = append(stack, path)
+		onStack[path] = true
+
+		selfImport := false
+		for _, imported := range g.Packages[path].Imports {
+			if imported == path {
+				selfImport = true
+			}
+			if _, seen := index[imported]; !seen {
+				visit(imported)
+				low[path] = min(low[path], low[imported])
+			} else if onStack[imported] {
+				low[path] = min(low[path], index[imported])
+			}
+		}
+
+		if low[path] == index[path] {
+			var component []string
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				component = append(component, top)
+				if top == path {
+					break
+				}
+			}
+			if len(component) > 1 || selfImport {
+				sort.Strings(component)
+				cycles = append(cycles, component)
+			}
+		}
+	}
+	for _, path := range sortedKeys(g.Packages) {
+		if _, seen := index[path]; !seen {
+			visit(path)
+		}
+	}
+
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+	return cycles
+}
+
+// modulePath reads the module path from the go.mod file at path
+func modulePath(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read go.mod: %w", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if module, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "module "); ok {
+			module = strings.TrimSpace(module)
+			if unquoted, err := strconv.Unquote(module); err == nil {
+				module = unquoted
+			}
+			return module, nil
+		}
+	}
+	return "", fmt.Errorf("no module directive in %s", path)
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,254 @@
+package analysis
+
+import (
+	"go/ast"
+	"go/token"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of unused code
+const (
+	KindFunc    = "func"
+	KindType    = "type"
+	KindVar     = "var"
+	KindConst   = "const"
+	KindPackage = "package"
+)
+
+// Unused is a declaration, or a whole package, that nothing uses
+type Unused struct {
+	// Package is the import path of the package of the declaration
+	Package string `json:"package"`
+	// Name is the name of the declaration, empty for a package
+	Name string `json:"name,omitempty"`
+	Kind string `json:"kind"`
+	// File is the file of the declaration relative to the module root, or
+	// the directory of a package
+	File string `json:"file"`
+	Line int    `json:"line,omitempty"`
+}
+
+// declaration is a top-level name declared in a package
+type declaration struct {
+	name  string
+	kind  string
+	ident *ast.Ident
+}
+
+// FindUnused returns the top-level functions, types, variables and
+// constants of g that no code of the module refers to, and the internal
+// packages that no package imports. References from tests count as uses.
+// Packages outside internal directories may be imported by other modules,
+// so they and their exported names are never reported, nor are
+// declarations in generated files, init and main functions and methods.
+func FindUnused(g *Graph) []Unused {
+	// uses holds the names selected from each package by other packages
+	uses := map[string]map[string]bool{}
+	imported := map[string]bool{}
+	for _, pkg := range g.Packages {
+		for _, file := range pkg.allFiles() {
+			g.collectUses(file, uses, imported)
+		}
+	}
+
+	var unused []Unused
+	for _, pkgPath := range sortedKeys(g.Packages) {
+		pkg := g.Packages[pkgPath]
+		public := pkg.public()
+		if !public && pkg.Name != "main" && !imported[pkgPath] {
+			unused = append(unused, Unused{Package: pkgPath, Kind: KindPackage, File: pkg.Dir})
+		}
+
+		local := localUses(pkg)
+		for _, file := range pkg.files {
+			if ast.IsGenerated(file) {
+				continue
+			}
+			for _, decl := range declarations(file) {
+				if local[decl.name] {
+					continue
+				}
+				if ast.IsExported(decl.name) && (public || uses[pkgPath][decl.name]) {
+					continue
+				}
+				if pkg.Name == "main" && decl.name == "main" {
+					continue
+				}
+				pos := g.fset.Position(decl.ident.Pos())
+				unused = append(unused, Unused{
+					Package: pkgPath,
+					Name:    decl.name,
+					Kind:    decl.kind,
+					File:    g.relative(pos.Filename),
+					Line:    pos.Line,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(unused, func(i, j int) bool {
+		a, b := unused[i], unused[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return unused
+}
+
+// collectUses records the names that file selects from the packages of the
+// module it imports, and the packages it imports
+func (g *Graph) collectUses(file *ast.File, uses map[string]map[string]bool, imported map[string]bool) {
+	names := map[string]string{}
+	for _, spec := range file.Imports {
+		importPath, _ := strconv.Unquote(spec.Path.Value)
+		pkg, ok := g.Packages[importPath]
+		if !ok {
+			continue
+		}
+		imported[importPath] = true
+		if uses[importPath] == nil {
+			uses[importPath] = map[string]bool{}
+		}
+
+		name := pkg.Name
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		switch name {
+		case "_":
+		case ".":
+			// Dot imports make every exported name of the package usable
+			// unqualified, so all of them count as used
+			for _, f := range pkg.files {
+				for _, decl := range declarations(f) {
+					uses[importPath][decl.name] = true
+				}
+			}
+		default:
+			names[name] = importPath
+		}
+	}
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if x, ok := sel.X.(*ast.Ident); ok {
+			if importPath, ok := names[x.Name]; ok {
+				uses[importPath][sel.Sel.Name] = true
+			}
+		}
+		return true
+	})
+}
+
+// localUses returns the names that the files of pkg, including its tests,
+// refer to, other than where they are declared
+func localUses(pkg *Package) map[string]bool {
+	used := map[string]bool{}
+	for _, file := range pkg.allFiles() {
+		declared := map[*ast.Ident]bool{}
+		for _, decl := range declarations(file) {
+			declared[decl.ident] = true
+		}
+		for _, decl := range file.Decls {
+			// Types only named by the receivers of their methods are never
+			// created, so the receivers do not count as uses
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv != nil {
+				ast.Inspect(fn.Recv, func(n ast.Node) bool {
+					if ident, ok := n.(*ast.Ident); ok {
+						declared[ident] = true
+					}
+					return true
+				})
+			}
+		}
+		for _, decl := range file.Decls {
+			ast.Inspect(decl, func(n ast.Node) bool { return addUse(n, declared, used) })
+		}
+	}
+	return used
+}
+
+// addUse records n as a use if it is an identifier other than a declared
+// name. Names selected from other values are fields or methods, and do not
+// count.
+func addUse(n ast.Node, declared map[*ast.Ident]bool, used map[string]bool) bool {
+	switch n := n.(type) {
+	case *ast.SelectorExpr:
+		ast.Inspect(n.X, func(n ast.Node) bool { return addUse(n, declared, used) })
+		return false
+	case *ast.Ident:
+		if !declared[n] {
+			used[n.Name] = true
+		}
+	}
+	return true
+}
+
+// declarations returns the top-level functions, types, variables and
+// constants of file that can be reported as unused
+func declarations(file *ast.File) []declaration {
+	var decls []declaration
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv != nil || decl.Name.Name == "init" {
+				continue
+			}
+			decls = append(decls, declaration{decl.Name.Name, KindFunc, decl.Name})
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					decls = append(decls, declaration{spec.Name.Name, KindType, spec.Name})
+				case *ast.ValueSpec:
+					kind := KindVar
+					if decl.Tok == token.CONST {
+						kind = KindConst
+					}
+					for _, name := range spec.Names {
+						if name.Name != "_" {
+							decls = append(decls, declaration{name.Name, kind, name})
+						}
+					}
+				}
+			}
+		}
+	}
+	return decls
+}
+
+// public reports whether other modules can import pkg, because it is
+// neither a command nor under an internal directory
+func (pkg *Package) public() bool {
+	if pkg.Name == "main" {
+		return false
+	}
+	for _, elem := range strings.Split(pkg.Dir, "/") {
+		if elem == "internal" {
+			return false
+		}
+	}
+	return true
+}
+
+// allFiles returns the files of pkg and its tests
+func (pkg *Package) allFiles() []*ast.File {
+	return append(append([]*ast.File{}, pkg.files...), pkg.testFiles...)
+}
+
+// relative returns filename relative to the module root
+func (g *Graph) relative(filename string) string {
+	rel, err := filepath.Rel(g.Root, filename)
+	if err != nil {
+		return filename
+	}
+	return path.Clean(filepath.ToSlash(rel))
+}
@@ -0,0 +1,207 @@
+// Package cluster coordinates server instances that share a database.
+//
+// Every instance serves the API, but background jobs such as the retention
+// janitor, the alert evaluator and scheduled backups must run on exactly one
+// of them. The instances elect that leader through a lease in the store: the
+// leader renews it every third of its duration, and when the leader stops
+// renewing it another instance takes over once it expired.
+//
+//	elector := cluster.NewElector(store, log, cluster.Options{})
+//	go elector.Run(ctx, func(ctx context.Context) {
+//	    // run the schedulers until ctx is cancelled
+//	})
+//
+// The runs of the running flows are shared between all instances instead.
+// A Worker claims runs through a lease per flow, up to its fair share, and
+// takes over the runs of instances whose claims expired:
+//
+//	worker := cluster.NewWorker(store, log, cluster.Options{}, func(ctx context.Context, flow *types.RuntimeFlow) {
+//	    // run the flow until ctx is cancelled
+//	})
+//	go worker.Run(ctx)
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"flow-control/internal/store"
+	"flow-control/internal/types"
+)
+
+// LeaderLease names the lease held by the leader
+const LeaderLease = "leader"
+
+// DefaultLeaseDuration is the lease duration of electors created without one
+const DefaultLeaseDuration = 15 * time.Second
+
+// LeaseStore is the part of the store leader election needs
+type LeaseStore interface {
+	AcquireLease(name, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(name, holder string) error
+	GetLease(name string) (*types.Lease, error)
+}
+
+// Options configures an Elector
+type Options struct {
+	// Instance identifies this instance; DefaultInstanceID by default. Every
+	// instance of a cluster needs its own.
+	Instance string
+	// LeaseDuration is how long leadership lasts without being renewed,
+	// and so how long the cluster goes without a leader when the leader
+	// dies; DefaultLeaseDuration by default
+	LeaseDuration time.Duration
+	// Admit, when set, is asked before a Worker claims the run of a flow;
+	// runs it fails for, such as those beyond a quota, are left unclaimed
+	// until a later heartbeat admits them
+	Admit func(flow *types.RuntimeFlow) error
+}
+
+// DefaultInstanceID identifies the instance by its host name and process ID
+func DefaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// Status describes the leadership of a cluster as an instance sees it
+type Status struct {
+	// Instance identifies the instance reporting the status
+	Instance string `json:"instance"`
+	// IsLeader is set when the reporting instance is the leader
+	IsLeader bool `json:"is_leader"`
+	// Leader identifies the leader, or is empty while there is none
+	Leader string `json:"leader,omitempty"`
+	// LeaderSince is when the leader was elected
+	LeaderSince *time.Time `json:"leader_since,omitempty"`
+	// LeaseExpires is when the leader's lease lapses unless renewed
+	LeaseExpires *time.Time `json:"lease_expires,omitempty"`
+}
+
+// Elector campaigns for the leadership of a cluster
+type Elector struct {
+	store  LeaseStore
+	log    types.Logger
+	opts   Options
+	leader atomic.Bool
+}
+
+// NewElector creates an elector electing leaders through the leases of s
+func NewElector(s LeaseStore, log types.Logger, opts Options) *Elector {
+	if opts.Instance == "" {
+		opts.Instance = DefaultInstanceID()
+	}
+	if opts.LeaseDuration <= 0 {
+		opts.LeaseDuration = DefaultLeaseDuration
+	}
+	return &Elector{store: s, log: log, opts: opts}
+}
+
+// Instance identifies the instance the elector campaigns for
+func (e *Elector) Instance() string {
+	return e.opts.Instance
+}
+
+// IsLeader reports whether the instance currently leads the cluster
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Status returns the current leader of the cluster
+func (e *Elector) Status() (Status, error) {
+	status := Status{Instance: e.opts.Instance, IsLeader: e.IsLeader()}
+	lease, err := e.store.GetLease(LeaderLease)
+	if errors.Is(err, store.ErrLeaseNotFound) {
+		return status, nil
+	}
+	if err != nil {
+		return status, fmt.Errorf("failed to get leader: %w", err)
+	}
+	status.Leader = lease.Holder
+	status.LeaderSince = &lease.AcquiredAt
+	status.LeaseExpires = &lease.ExpiresAt
+	return status, nil
+}
+
+// Run campaigns for leadership until ctx is done. While the instance leads,
+// lead runs with a context that is cancelled when leadership is lost; lead
+// must return soon after. Leadership is given up when Run returns, so that
+// another instance takes over at once.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context)) error {
+	interval := e.opts.LeaseDuration / 3
+	if interval <= 0 {
+		return fmt.Errorf("lease duration too short: %s", e.opts.LeaseDuration)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var (
+		stop    context.CancelFunc
+		done    chan struct{}
+		renewed time.Time
+	)
+	stepDown := func(reason string) {
+		if stop == nil {
+			return
+		}
+		stop()
+		<-done
+		stop = nil
+		e.leader.Store(false)
+		e.log.Warn("Stepped down as cluster leader", types.Fields{
+			"function": "Run",
+			"instance": e.opts.Instance,
+			"reason":   reason,
+		})
+	}
+
+	for {
+		held, err := e.store.AcquireLease(LeaderLease, e.opts.Instance, e.opts.LeaseDuration)
+		switch {
+		case err == nil && held:
+			renewed = time.Now()
+			if stop == nil {
+				leadCtx, cancel := context.WithCancel(ctx)
+				stop, done = cancel, make(chan struct{})
+				e.leader.Store(true)
+				e.log.Info("Elected cluster leader", types.Fields{
+					"function": "Run",
+					"instance": e.opts.Instance,
+				})
+				go func(done chan struct{}) {
+					defer close(done)
+					lead(leadCtx)
+				}(done)
+			}
+		case err == nil:
+			stepDown("lease held by another instance")
+		default:
+			// The lease may still be held; give it up before it could
+			// lapse and another instance take over
+			if time.Since(renewed) >= e.opts.LeaseDuration-interval {
+				stepDown("lease could not be renewed")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if stop != nil {
+				stepDown("shutting down")
+				if err := e.store.ReleaseLease(LeaderLease, e.opts.Instance); err != nil {
+					e.log.Error("Failed to release leadership", err, types.Fields{
+						"function": "Run",
+						"instance": e.opts.Instance,
+					})
+				}
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package cluster_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"flow-control/internal/cluster"
+	"flow-control/internal/logger"
+	"flow-control/internal/store"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestElector(t *testing.T) {
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "cluster.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	// Two instances share the store and count how many lead at once
+	var leading, most atomic.Int32
+	lead := func(ctx context.Context) {
+		n := leading.Add(1)
+		if n > most.Load() {
+			most.Store(n)
+		}
+		<-ctx.Done()
+		leading.Add(-1)
+	}
+	opts := func(instance string) cluster.Options {
+		return cluster.Options{Instance: instance, LeaseDuration: 150 * time.Millisecond}
+	}
+	a := cluster.NewElector(st, log, opts("node-a"))
+	b := cluster.NewElector(st, log, opts("node-b"))
+
+	status, err := a.Status()
+	require.NoError(t, err)
+	require.Equal(t, cluster.Status{Instance: "node-a"}, status)
+
+	ctxA, stopA := context.WithCancel(context.Background())
+	ctxB, stopB := context.WithCancel(context.Background())
+	defer stopB()
+	var wg sync.WaitGroup
+	for _, run := range []struct {
+		elector *cluster.Elector
+		ctx     context.Context
+	}{{a, ctxA}, {b, ctxB}} {
+		wg.Add(1)
+		go func(e *cluster.Elector, ctx context.Context) {
+			defer wg.Done()
+			require.NoError(t, e.Run(ctx, lead))
+		}(run.elector, run.ctx)
+		// Let the first instance win the election
+		require.Eventually(t, func() bool { return leading.Load() == 1 }, time.Second, 5*time.Millisecond)
+	}
+
+	// Exactly one instance leads across several renewals
+	time.Sleep(400 * time.Millisecond)
+	require.EqualValues(t, 1, leading.Load())
+	require.EqualValues(t, 1, most.Load())
+	require.True(t, a.IsLeader())
+	require.False(t, b.IsLeader())
+
+	status, err = b.Status()
+	require.NoError(t, err)
+	require.Equal(t, "node-b", status.Instance)
+	require.False(t, status.IsLeader)
+	require.Equal(t, "node-a", status.Leader)
+	require.NotNil(t, status.LeaseExpires)
+
+	// The other instance takes over when the leader shuts down
+	stopA()
+	require.Eventually(t, b.IsLeader, time.Second, 5*time.Millisecond)
+	require.False(t, a.IsLeader())
+	require.EqualValues(t, 1, most.Load())
+
+	stopB()
+	wg.Wait()
+	require.EqualValues(t, 0, leading.Load())
+	status, err = b.Status()
+	require.NoError(t, err)
+	require.Empty(t, status.Leader)
+}
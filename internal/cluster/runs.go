@@ -0,0 +1,370 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"flow-control/internal/store"
+	"flow-control/internal/types"
+)
+
+// Prefixes of the lease names of run sharing
+const (
+	// InstanceLeasePrefix prefixes the heartbeat lease of each instance
+	InstanceLeasePrefix = "instance:"
+	// RunLeasePrefix prefixes the lease claiming the run of each flow
+	RunLeasePrefix = "run:"
+)
+
+// RunStore is the part of the store run sharing needs
+type RunStore interface {
+	LeaseStore
+	ListLeases(prefix string) ([]*types.Lease, error)
+	ListFlows(filters ...store.FlowFilter) ([]*types.RuntimeFlow, error)
+}
+
+// Placement shows which instance runs each running flow
+type Placement struct {
+	// Instances lists the live instances of the cluster
+	Instances []string `json:"instances"`
+	// Runs lists the running flows, ordered by flow ID
+	Runs []Run `json:"runs"`
+}
+
+// Run is a running flow and the instance that claimed it
+type Run struct {
+	FlowID string `json:"flow_id"`
+	// Instance identifies the instance running the flow, or is empty
+	// until one claims it
+	Instance string `json:"instance,omitempty"`
+	// ClaimedAt is when the instance claimed the run
+	ClaimedAt *time.Time `json:"claimed_at,omitempty"`
+	// ExpiresAt is when the claim lapses unless the instance renews it
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Placements returns the live instances of the cluster sharing runs through
+// s and the placement of the running flows
+func Placements(s RunStore) (*Placement, error) {
+	instances, err := s.ListLeases(InstanceLeasePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list instances: %w", err)
+	}
+	claims, err := s.ListLeases(RunLeasePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs: %w", err)
+	}
+	flows, err := runningFlows(s)
+	if err != nil {
+		return nil, err
+	}
+
+	placement := &Placement{Instances: []string{}, Runs: []Run{}}
+	for _, lease := range instances {
+		placement.Instances = append(placement.Instances, lease.Holder)
+	}
+	claimed := make(map[string]*types.Lease, len(claims))
+	for _, lease := range claims {
+		claimed[strings.TrimPrefix(lease.Name, RunLeasePrefix)] = lease
+	}
+	for _, flow := range flows {
+		run := Run{FlowID: flow.ID}
+		if lease, ok := claimed[flow.ID]; ok {
+			run.Instance = lease.Holder
+			run.ClaimedAt = &lease.AcquiredAt
+			run.ExpiresAt = &lease.ExpiresAt
+		}
+		placement.Runs = append(placement.Runs, run)
+	}
+	return placement, nil
+}
+
+// runningFlows returns the running flows of s, ordered by ID
+func runningFlows(s RunStore) ([]*types.RuntimeFlow, error) {
+	flows, err := s.ListFlows()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list flows: %w", err)
+	}
+	running := make([]*types.RuntimeFlow, 0, len(flows))
+	for _, flow := range flows {
+		if flow.Status == types.FlowStatusRunning {
+			running = append(running, flow)
+		}
+	}
+	sort.Slice(running, func(i, j int) bool { return running[i].ID < running[j].ID })
+	return running, nil
+}
+
+// claim is a run the worker holds
+type claim struct {
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// Worker shares the runs of the running flows with the other instances of
+// a cluster. Each instance sends heartbeats through a lease of its own and
+// claims runs through a lease per flow, up to its fair share of the running
+// flows. Claims are renewed with every heartbeat; when an instance stops
+// renewing them, the others take its runs over once the claims expired.
+type Worker struct {
+	store RunStore
+	log   types.Logger
+	opts  Options
+	run   func(ctx context.Context, flow *types.RuntimeFlow) error
+
+	mu     sync.Mutex
+	claims map[string]*claim
+}
+
+// NewWorker creates a worker sharing runs through the leases of s. While
+// the worker holds the run of a flow, run runs it with a context that is
+// cancelled when the claim is lost or given up; run must return soon after.
+// Runs that end on their own give up their claim, so that the flow is not
+// counted against the worker's share; flows that still run are claimed
+// again with a later heartbeat.
+func NewWorker(s RunStore, log types.Logger, opts Options, run func(ctx context.Context, flow *types.RuntimeFlow) error) *Worker {
+	if opts.Instance == "" {
+		opts.Instance = DefaultInstanceID()
+	}
+	if opts.LeaseDuration <= 0 {
+		opts.LeaseDuration = DefaultLeaseDuration
+	}
+	return &Worker{store: s, log: log, opts: opts, run: run, claims: make(map[string]*claim)}
+}
+
+// Claims returns the IDs of the flows the worker runs, in order
+func (w *Worker) Claims() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ids := make([]string, 0, len(w.claims))
+	for id := range w.claims {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Run sends heartbeats and balances runs every third of the lease duration
+// until ctx is done. It then stops its runs and gives up their claims, so
+// that other instances take them over at once.
+func (w *Worker) Run(ctx context.Context) error {
+	interval := w.opts.LeaseDuration / 3
+	if interval <= 0 {
+		return fmt.Errorf("lease duration too short: %s", w.opts.LeaseDuration)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var renewed time.Time
+	for {
+		if err := w.Heartbeat(ctx); err != nil {
+			w.log.Error("Failed to share flow runs", err, types.Fields{
+				"function": "Run",
+				"instance": w.opts.Instance,
+			})
+			// The claims may still be held; give them up before they could
+			// lapse and another instance take them over
+			if time.Since(renewed) >= w.opts.LeaseDuration-interval {
+				w.stopAll(false)
+			}
+		} else {
+			renewed = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			w.stopAll(true)
+			if err := w.store.ReleaseLease(InstanceLeasePrefix+w.opts.Instance, w.opts.Instance); err != nil {
+				w.log.Error("Failed to release instance lease", err, types.Fields{
+					"function": "Run",
+					"instance": w.opts.Instance,
+				})
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Heartbeat renews the worker's leases and balances runs once: it gives up
+// the runs of flows that stopped and runs beyond its share, and claims
+// unclaimed runs that Admit admits up to its share. The share is the number of running flows
+// divided by the number of live instances, rounded up.
+func (w *Worker) Heartbeat(ctx context.Context) error {
+	instanceLease := InstanceLeasePrefix + w.opts.Instance
+	if _, err := w.store.AcquireLease(instanceLease, w.opts.Instance, w.opts.LeaseDuration); err != nil {
+		return fmt.Errorf("failed to send heartbeat: %w", err)
+	}
+	instances, err := w.store.ListLeases(InstanceLeasePrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list instances: %w", err)
+	}
+	flows, err := runningFlows(w.store)
+	if err != nil {
+		return err
+	}
+	share := (len(flows) + len(instances) - 1) / max(len(instances), 1)
+
+	running := make(map[string]bool, len(flows))
+	for _, flow := range flows {
+		running[flow.ID] = true
+	}
+
+	// Renew the claims on flows that still run, keeping the first ones in
+	// flow ID order up to the share
+	kept := 0
+	for _, id := range w.Claims() {
+		switch {
+		case !running[id]:
+			w.release(id, "flow stopped")
+		case kept >= share:
+			w.release(id, "rebalancing")
+		default:
+			held, err := w.store.AcquireLease(RunLeasePrefix+id, w.opts.Instance, w.opts.LeaseDuration)
+			if err != nil {
+				return fmt.Errorf("failed to renew claim on %s: %w", id, err)
+			}
+			if !held {
+				w.stop(id, "claim taken over")
+				continue
+			}
+			kept++
+		}
+	}
+
+	// Claim unclaimed runs up to the share
+	for _, flow := range flows {
+		if kept >= share || ctx.Err() != nil {
+			break
+		}
+		if w.holds(flow.ID) {
+			continue
+		}
+		if w.opts.Admit != nil {
+			if err := w.opts.Admit(flow); err != nil {
+				w.log.Warn("Flow run not admitted", types.Fields{
+					"function": "Heartbeat",
+					"instance": w.opts.Instance,
+					"flow_id":  flow.ID,
+					"reason":   err.Error(),
+				})
+				continue
+			}
+		}
+		held, err := w.store.AcquireLease(RunLeasePrefix+flow.ID, w.opts.Instance, w.opts.LeaseDuration)
+		if err != nil {
+			return fmt.Errorf("failed to claim %s: %w", flow.ID, err)
+		}
+		if held {
+			w.start(ctx, flow)
+			kept++
+		}
+	}
+	return nil
+}
+
+// holds reports whether the worker runs the flow with id
+func (w *Worker) holds(id string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.claims[id]
+	return ok
+}
+
+// start runs a flow the worker claimed
+func (w *Worker) start(ctx context.Context, flow *types.RuntimeFlow) {
+	runCtx, cancel := context.WithCancel(ctx)
+	c := &claim{stop: cancel, done: make(chan struct{})}
+	w.mu.Lock()
+	w.claims[flow.ID] = c
+	w.mu.Unlock()
+
+	w.log.Info("Claimed flow run", types.Fields{
+		"function": "start",
+		"instance": w.opts.Instance,
+		"flow_id":  flow.ID,
+	})
+	go func() {
+		err := w.run(runCtx, flow)
+		close(c.done)
+		if runCtx.Err() == nil {
+			w.ended(flow.ID, c, err)
+		}
+	}()
+}
+
+// ended gives up the claim c of a run that ended before the worker stopped
+// it
+func (w *Worker) ended(id string, c *claim, err error) {
+	w.mu.Lock()
+	current := w.claims[id] == c
+	if current {
+		delete(w.claims, id)
+	}
+	w.mu.Unlock()
+	c.stop()
+	if !current {
+		return
+	}
+
+	fields := types.Fields{
+		"function": "ended",
+		"instance": w.opts.Instance,
+		"flow_id":  id,
+	}
+	if err != nil {
+		w.log.Error("Flow run failed", err, fields)
+	} else {
+		w.log.Info("Flow run ended", fields)
+	}
+	if err := w.store.ReleaseLease(RunLeasePrefix+id, w.opts.Instance); err != nil {
+		w.log.Error("Failed to release flow run", err, fields)
+	}
+}
+
+// stop stops running the flow with id without giving up its claim
+func (w *Worker) stop(id, reason string) {
+	w.mu.Lock()
+	c, ok := w.claims[id]
+	delete(w.claims, id)
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	c.stop()
+	<-c.done
+	w.log.Info("Stopped flow run", types.Fields{
+		"function": "stop",
+		"instance": w.opts.Instance,
+		"flow_id":  id,
+		"reason":   reason,
+	})
+}
+
+// release stops running the flow with id and gives up its claim
+func (w *Worker) release(id, reason string) {
+	w.stop(id, reason)
+	if err := w.store.ReleaseLease(RunLeasePrefix+id, w.opts.Instance); err != nil {
+		w.log.Error("Failed to release flow run", err, types.Fields{
+			"function": "release",
+			"instance": w.opts.Instance,
+			"flow_id":  id,
+		})
+	}
+}
+
+// stopAll stops every run, giving up the claims when release is set
+func (w *Worker) stopAll(release bool) {
+	for _, id := range w.Claims() {
+		if release {
+			w.release(id, "shutting down")
+		} else {
+			w.stop(id, "claims could not be renewed")
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package cluster_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"flow-control/internal/cluster"
+	"flow-control/internal/logger"
+	"flow-control/internal/store"
+	"flow-control/internal/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorker(t *testing.T) {
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "runs.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	for i := 1; i <= 4; i++ {
+		id := fmt.Sprintf("flow-%d", i)
+		require.NoError(t, st.CreateFlow(&types.RuntimeFlow{ID: id, Name: id, Config: "{}", Status: "stopped"}))
+		require.NoError(t, st.StartFlow(id, nil))
+	}
+	require.NoError(t, st.CreateFlow(&types.RuntimeFlow{ID: "idle", Name: "idle", Config: "{}", Status: "stopped"}))
+
+	// Record the flows each instance runs
+	var mu sync.Mutex
+	runs := map[string]int{}
+	worker := func(instance string) *cluster.Worker {
+		opts := cluster.Options{Instance: instance, LeaseDuration: 300 * time.Millisecond}
+		return cluster.NewWorker(st, log, opts, func(ctx context.Context, flow *types.RuntimeFlow) error {
+			key := instance + "/" + flow.ID
+			mu.Lock()
+			runs[key]++
+			mu.Unlock()
+			<-ctx.Done()
+			mu.Lock()
+			runs[key]--
+			if runs[key] == 0 {
+				delete(runs, key)
+			}
+			mu.Unlock()
+			return nil
+		})
+	}
+	running := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		keys := make([]string, 0, len(runs))
+		for key := range runs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a, b := worker("node-a"), worker("node-b")
+
+	// A lone instance claims every running flow
+	require.NoError(t, a.Heartbeat(ctx))
+	require.Equal(t, []string{"flow-1", "flow-2", "flow-3", "flow-4"}, a.Claims())
+
+	// Runs are split once another instance joins
+	require.NoError(t, b.Heartbeat(ctx))
+	require.Empty(t, b.Claims())
+	require.NoError(t, a.Heartbeat(ctx))
+	require.Equal(t, []string{"flow-1", "flow-2"}, a.Claims())
+	require.NoError(t, b.Heartbeat(ctx))
+	require.Equal(t, []string{"flow-3", "flow-4"}, b.Claims())
+	require.Eventually(t, func() bool {
+		return slices.Equal(running(), []string{"node-a/flow-1", "node-a/flow-2", "node-b/flow-3", "node-b/flow-4"})
+	}, time.Second, 5*time.Millisecond)
+
+	placement, err := cluster.Placements(st)
+	require.NoError(t, err)
+	require.Equal(t, []string{"node-a", "node-b"}, placement.Instances)
+	require.Len(t, placement.Runs, 4)
+	require.Equal(t, "flow-1", placement.Runs[0].FlowID)
+	require.Equal(t, "node-a", placement.Runs[0].Instance)
+	require.Equal(t, "node-b", placement.Runs[3].Instance)
+	require.NotNil(t, placement.Runs[3].ExpiresAt)
+
+	// Stopped flows are given up
+	require.NoError(t, st.UpdateFlowStatus("flow-1", "stopped"))
+	require.NoError(t, a.Heartbeat(ctx))
+	require.Equal(t, []string{"flow-2"}, a.Claims())
+	require.NotContains(t, running(), "node-a/flow-1")
+
+	// The runs of an instance that stops sending heartbeats are taken over
+	// once its claims expire
+	time.Sleep(350 * time.Millisecond)
+	require.NoError(t, a.Heartbeat(ctx))
+	require.Equal(t, []string{"flow-2", "flow-3", "flow-4"}, a.Claims())
+
+	// The other instance stops the runs it lost when it comes back
+	require.NoError(t, b.Heartbeat(ctx))
+	require.Empty(t, b.Claims())
+	require.Eventually(t, func() bool {
+		return slices.Equal(running(), []string{"node-a/flow-2", "node-a/flow-3", "node-a/flow-4"})
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestWorkerRun(t *testing.T) {
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "runs.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	require.NoError(t, st.CreateFlow(&types.RuntimeFlow{ID: "orders", Name: "orders", Config: "{}", Status: "stopped"}))
+	require.NoError(t, st.StartFlow("orders", nil))
+
+	started := make(chan string, 1)
+	w := cluster.NewWorker(st, log, cluster.Options{Instance: "node-a", LeaseDuration: 150 * time.Millisecond},
+		func(ctx context.Context, flow *types.RuntimeFlow) error {
+			started <- flow.ID
+			<-ctx.Done()
+			return nil
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+	require.Equal(t, "orders", <-started)
+
+	// Shutting down gives up the claims and the instance at once
+	cancel()
+	require.NoError(t, <-done)
+	require.Empty(t, w.Claims())
+	placement, err := cluster.Placements(st)
+	require.NoError(t, err)
+	require.Empty(t, placement.Instances)
+	require.Equal(t, []cluster.Run{{FlowID: "orders"}}, placement.Runs)
+}
+
+func TestWorkerRunEnds(t *testing.T) {
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "runs.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	require.NoError(t, st.CreateFlow(&types.RuntimeFlow{ID: "orders", Name: "orders", Config: "{}", Status: "stopped"}))
+	require.NoError(t, st.StartFlow("orders", nil))
+
+	// The first run fails at once, the next one runs until stopped
+	var attempts int
+	var mu sync.Mutex
+	w := cluster.NewWorker(st, log, cluster.Options{Instance: "node-a", LeaseDuration: time.Minute},
+		func(ctx context.Context, flow *types.RuntimeFlow) error {
+			mu.Lock()
+			attempts++
+			first := attempts == 1
+			mu.Unlock()
+			if first {
+				return errors.New("broken flow")
+			}
+			<-ctx.Done()
+			return nil
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, w.Heartbeat(ctx))
+
+	// A run that ends gives up its claim
+	require.Eventually(t, func() bool {
+		placement, err := cluster.Placements(st)
+		require.NoError(t, err)
+		return len(w.Claims()) == 0 && placement.Runs[0].Instance == ""
+	}, time.Second, 5*time.Millisecond)
+
+	// The flow still runs, so it is claimed again
+	require.NoError(t, w.Heartbeat(ctx))
+	require.Equal(t, []string{"orders"}, w.Claims())
+	placement, err := cluster.Placements(st)
+	require.NoError(t, err)
+	require.Equal(t, "node-a", placement.Runs[0].Instance)
+}
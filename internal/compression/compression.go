@@ -0,0 +1,159 @@
+/*
+Package compression compresses message payloads where they leave memory:
+the payloads the engine spills to disk and the run artifacts, such as
+dead-lettered messages, the store keeps. Verbose JSON payloads shrink
+several times over, keeping the database and spill directories small.
+
+Codecs are chosen per flow with a Policy. Payloads record the codec they
+were written with, so that changing the policy never breaks reading older
+ones.
+*/
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"slices"
+
+	"flow-control/internal/metrics"
+	"flow-control/internal/types"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codecs, named as they are configured and recorded with payloads
+const (
+	// None stores payloads as they are
+	None = ""
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// Codecs lists the supported codecs other than None
+var Codecs = []string{Gzip, Zstd}
+
+// Validate checks that codec is supported; "none" is accepted for None
+func Validate(codec string) error {
+	if codec == None || codec == "none" || slices.Contains(Codecs, codec) {
+		return nil
+	}
+	return fmt.Errorf("unsupported compression codec %q: must be none, gzip or zstd", codec)
+}
+
+// Policy chooses the codec of the payloads of each flow
+type Policy struct {
+	// Default is the codec of flows missing from Flows
+	Default string
+	// Flows maps flow IDs to their codecs
+	Flows map[string]string
+}
+
+// Validate checks the codecs of the policy
+func (p Policy) Validate() error {
+	if err := Validate(p.Default); err != nil {
+		return err
+	}
+	for id, codec := range p.Flows {
+		if err := Validate(codec); err != nil {
+			return fmt.Errorf("flow %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// For returns the codec of the payloads of the flow with id
+func (p Policy) For(id string) string {
+	codec, ok := p.Flows[id]
+	if !ok {
+		codec = p.Default
+	}
+	if codec == "none" {
+		return None
+	}
+	return codec
+}
+
+// NewWriter returns a writer compressing what is written to it into w with
+// codec. Closing it flushes the compressed stream but does not close w.
+func NewWriter(codec string, w io.Writer) (io.WriteCloser, error) {
+	switch codec {
+	case None, "none":
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nil, Validate(codec)
+}
+
+// NewReader returns a reader decompressing what it reads from r with codec.
+// Closing it does not close r.
+func NewReader(codec string, r io.Reader) (io.ReadCloser, error) {
+	switch codec {
+	case None, "none":
+		return io.NopCloser(r), nil
+	case Gzip:
+		return gzip.NewReader(r)
+	case Zstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, Validate(codec)
+}
+
+// Encode compresses data with codec
+func Encode(codec string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := NewWriter(codec, &buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress payload: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress payload: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decompresses data compressed with codec
+func Decode(codec string, data []byte) ([]byte, error) {
+	r, err := NewReader(codec, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress payload: %w", err)
+	}
+	defer r.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress payload: %w", err)
+	}
+	return out, nil
+}
+
+// Record counts a payload of size bytes stored as stored bytes with codec
+// where it left memory, the boundary, such as "spill" or "artifact"
+func Record(port types.MetricsPort, flowID, boundary, codec string, size, stored int64) {
+	if port == nil {
+		return
+	}
+	if codec == None {
+		codec = "none"
+	}
+	labels := map[string]string{"flow_id": flowID, "boundary": boundary, "codec": codec}
+	port.Inc(metrics.PayloadBytesMetric, float64(size), labels)
+	port.Inc(metrics.PayloadStoredBytesMetric, float64(stored), labels)
+}
+
+// nopWriteCloser is a writer whose Close does nothing
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
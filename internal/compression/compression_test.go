@@ -0,0 +1,52 @@
+package compression_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"flow-control/internal/compression"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecs(t *testing.T) {
+	payload := []byte(strings.Repeat(`{"status":"paid","currency":"EUR"}`, 100))
+
+	for _, codec := range append([]string{compression.None}, compression.Codecs...) {
+		encoded, err := compression.Encode(codec, payload)
+		require.NoError(t, err, codec)
+		if codec != compression.None {
+			require.Less(t, len(encoded), len(payload)/10, codec)
+		}
+		decoded, err := compression.Decode(codec, encoded)
+		require.NoError(t, err, codec)
+		require.Equal(t, payload, decoded, codec)
+
+		// Streams decode what the one-shot encoder wrote
+		r, err := compression.NewReader(codec, bytes.NewReader(encoded))
+		require.NoError(t, err, codec)
+		streamed, err := io.ReadAll(r)
+		require.NoError(t, err, codec)
+		require.NoError(t, r.Close())
+		require.Equal(t, payload, streamed, codec)
+	}
+
+	_, err := compression.Encode("lz4", payload)
+	require.Error(t, err)
+	_, err = compression.Decode(compression.Gzip, payload)
+	require.Error(t, err)
+}
+
+func TestPolicy(t *testing.T) {
+	policy := compression.Policy{Default: compression.Zstd, Flows: map[string]string{"small": "none", "legacy": compression.Gzip}}
+	require.NoError(t, policy.Validate())
+	require.Equal(t, compression.Zstd, policy.For("orders"))
+	require.Equal(t, compression.Gzip, policy.For("legacy"))
+	require.Equal(t, compression.None, policy.For("small"))
+	require.Equal(t, compression.None, compression.Policy{}.For("orders"))
+
+	policy.Flows["broken"] = "brotli"
+	require.ErrorContains(t, policy.Validate(), "flow broken")
+}
@@ -3,154 +3,694 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 
 	"flow-control/internal/types"
 )
 
+// LogSampling limits the log entries of one component. Zero values disable
+// each limit.
+type LogSampling struct {
+	DebugEvery   int `json:"debug_every"`
+	InfoEvery    int `json:"info_every"`
+	MaxPerMinute int `json:"max_per_minute"`
+}
+
+// LokiSink ships log entries to a Grafana Loki server. It is disabled
+// while URL is empty; zero values select the sink's defaults.
+type LokiSink struct {
+	URL             string            `json:"url"`
+	TenantID        string            `json:"tenant_id"`
+	Labels          map[string]string `json:"labels"`
+	Levels          []string          `json:"levels"`
+	BatchSize       int               `json:"batch_size"`
+	FlushIntervalMs int               `json:"flush_interval_ms"`
+	QueueSize       int               `json:"queue_size"`
+	MaxRetries      int               `json:"max_retries"`
+}
+
+// EventsNATS relays events through a NATS server. Subject defaults to
+// the bus's subject prefix.
+type EventsNATS struct {
+	URL     string `json:"url"`
+	Subject string `json:"subject"`
+}
+
+// EventsRedis relays events through Redis pub/sub. URL is a redis:// or
+// rediss:// URL, carrying the password if the server needs one; Channel
+// defaults to the bus's subject prefix.
+type EventsRedis struct {
+	URL     string `json:"url"`
+	Channel string `json:"channel"`
+}
+
+// ServerTimeouts bounds how long the HTTP server waits on clients, in
+// seconds. Zero disables a timeout; the write timeout also cuts off event
+// streams, so it is disabled by default.
+type ServerTimeouts struct {
+	ReadHeaderSeconds int `json:"read_header_seconds"`
+	ReadSeconds       int `json:"read_seconds"`
+	WriteSeconds      int `json:"write_seconds"`
+	IdleSeconds       int `json:"idle_seconds"`
+	// ShutdownSeconds is how long shutdown waits for open requests
+	ShutdownSeconds int `json:"shutdown_seconds"`
+	// DrainSeconds is how long a restart waits for running backfills and
+	// simulations to end before it is abandoned
+	DrainSeconds int `json:"drain_seconds"`
+}
+
+// ServerTLS serves HTTPS while CertFile and KeyFile are set. MinVersion is
+// "1.2" or "1.3".
+type ServerTLS struct {
+	CertFile   string `json:"cert_file"`
+	KeyFile    string `json:"key_file"`
+	MinVersion string `json:"min_version"`
+}
+
+// LogRotation rotates the log file once it reaches MaxSizeMB, keeping
+// MaxBackups old files for MaxAgeDays. Zero MaxBackups or MaxAgeDays keep
+// old files regardless of their number or age.
+type LogRotation struct {
+	MaxSizeMB  int  `json:"max_size_mb"`
+	MaxBackups int  `json:"max_backups"`
+	MaxAgeDays int  `json:"max_age_days"`
+	Compress   bool `json:"compress"`
+}
+
+// RuntimeLimits bounds the flows run within API requests. Zero values
+// select the server's defaults. Payloads larger than MaxPayloadBytes wait
+// for nodes in temporary files under SpillDir rather than in memory; zero
+// keeps them all in memory.
+type RuntimeLimits struct {
+	DryRunMessages  int    `json:"dry_run_messages"`
+	DryRunTimeoutMs int    `json:"dry_run_timeout_ms"`
+	MaxCallDepth    int    `json:"max_call_depth"`
+	MaxPayloadBytes int    `json:"max_payload_bytes"`
+	SpillDir        string `json:"spill_dir"`
+}
+
+// FlowFaults are the failures injected into the dry runs of a flow. Rates
+// are probabilities from 0 to 1; see engine.Faults.
+type FlowFaults struct {
+	Nodes       []string `json:"nodes"`
+	ErrorRate   float64  `json:"error_rate"`
+	DropRate    float64  `json:"drop_rate"`
+	CorruptRate float64  `json:"corrupt_rate"`
+	LatencyRate float64  `json:"latency_rate"`
+	LatencyMs   int      `json:"latency_ms"`
+	Seed        int64    `json:"seed"`
+}
+
+// RuntimeFaults injects failures into flows, to check how they cope with
+// them. Flows maps flow IDs to their faults. Nothing is injected unless
+// Enabled, which the prod and production profiles reject.
+type RuntimeFaults struct {
+	Enabled bool                  `json:"enabled"`
+	Flows   map[string]FlowFaults `json:"flows"`
+}
+
+// RuntimeCompression compresses the payloads flows write out of memory,
+// such as spilled and dead-lettered messages. Default is the codec of flows
+// missing from Flows, which maps flow IDs to their codecs: "none", "gzip"
+// or "zstd". Payloads are stored as they are while both are empty.
+type RuntimeCompression struct {
+	Default string            `json:"default"`
+	Flows   map[string]string `json:"flows"`
+}
+
+// ProjectQuota bounds the flows of a project together: the flows and
+// backfills they run at once, the messages per second those backfills are
+// fed and the size of the run data kept for them, their node logs and
+// timelines. Zero leaves a resource unlimited.
+type ProjectQuota struct {
+	MaxRuns              int     `json:"max_runs"`
+	MaxMessagesPerSecond float64 `json:"max_messages_per_second"`
+	MaxStorageMB         int     `json:"max_storage_mb"`
+}
+
+// DefaultProject is the project of the flows without a "project:NAME" tag,
+// or of projects without a quota of their own
+const DefaultProject = "default"
+
+// RuntimeQuotas maps project names to their quotas. Flows belong to the
+// project named by their "project:NAME" tag; flows without one, or of
+// projects missing from Projects, share the Default quota.
+type RuntimeQuotas struct {
+	Default  ProjectQuota            `json:"default"`
+	Projects map[string]ProjectQuota `json:"projects"`
+}
+
+// LookupDatabase is a database the sql sources of Enrich nodes may query.
+// Driver is postgres or sqlite.
+type LookupDatabase struct {
+	Driver string `json:"driver"`
+	DSN    string `json:"dsn"`
+}
+
+// RuntimeLookups are the only endpoints the http and sql sources of Enrich
+// nodes may reach: the URL prefixes of URLs, such as
+// https://crm.internal/customers/, and the databases of Databases by the
+// name flows refer to them by.
+type RuntimeLookups struct {
+	URLs      []string                  `json:"urls"`
+	Databases map[string]LookupDatabase `json:"databases"`
+}
+
+// SecretsVault reads secrets from HashiCorp Vault, from the key named after
+// each secret in the secret at Path. Paths maps secret names to other
+// locations as "path#key".
+type SecretsVault struct {
+	Address string            `json:"address"`
+	Token   string            `json:"token"`
+	Path    string            `json:"path"`
+	Paths   map[string]string `json:"paths"`
+}
+
 // Config represents the application configuration
 type Config struct {
 	// Server configuration
 	Server struct {
-		Host string `json:"host"`
-		Port int    `json:"port"`
+		Host       string         `json:"host"`
+		Port       int            `json:"port"`
+		AdminToken string         `json:"admin_token"`
+		APIKeys    []string       `json:"api_keys"`
+		Timeouts   ServerTimeouts `json:"timeouts"`
+		TLS        ServerTLS      `json:"tls"`
 	} `json:"server"`
 
 	// Database configuration
 	Database struct {
-		Path string `json:"path"`
+		Driver       string `json:"driver"`
+		Path         string `json:"path"`
+		DSN          string `json:"dsn"`
+		JournalMode  string `json:"journal_mode"`
+		BusyTimeout  int    `json:"busy_timeout_ms"`
+		ForeignKeys  bool   `json:"foreign_keys"`
+		MaxOpenConns int    `json:"max_open_conns"`
+		MaxIdleConns int    `json:"max_idle_conns"`
+		SlowQueryMs  int    `json:"slow_query_ms"`
 	} `json:"database"`
 
+	// Backup configuration. Scheduled backups are disabled while
+	// IntervalMinutes is zero.
+	Backup struct {
+		Dir             string `json:"dir"`
+		IntervalMinutes int    `json:"interval_minutes"`
+		Retain          int    `json:"retain"`
+	} `json:"backup"`
+
+	// Retention configuration. MaxAgeDays maps table names to the number of
+	// days their rows are kept; the janitor is disabled while
+	// IntervalMinutes is zero.
+	Retention struct {
+		IntervalMinutes int            `json:"interval_minutes"`
+		BatchSize       int            `json:"batch_size"`
+		MaxAgeDays      map[string]int `json:"max_age_days"`
+	} `json:"retention"`
+
 	// Logging configuration
 	Logging struct {
 		Level  string `json:"level"`
 		Format string `json:"format"`
+		// File receives the JSON log, or nothing is written while it is
+		// empty
+		File     string                 `json:"file"`
+		Rotation LogRotation            `json:"rotation"`
+		Sampling map[string]LogSampling `json:"sampling"`
+		Loki     LokiSink               `json:"loki"`
 	} `json:"logging"`
+
+	// Tracing configuration. Spans are exported over OTLP/HTTP to Endpoint
+	// while Enabled is set; SampleRatio is the fraction of new traces kept.
+	Tracing struct {
+		Enabled     bool              `json:"enabled"`
+		ServiceName string            `json:"service_name"`
+		Endpoint    string            `json:"endpoint"`
+		Insecure    bool              `json:"insecure"`
+		SampleRatio float64           `json:"sample_ratio"`
+		Headers     map[string]string `json:"headers"`
+	} `json:"tracing"`
+
+	// Events configuration. Backend is "memory" to deliver flow events
+	// within the process, or "nats" or "redis" to share them between
+	// instances so that event streams of every instance see every event;
+	// BufferSize is the number of events queued per subscriber.
+	Events struct {
+		Backend    string      `json:"backend"`
+		BufferSize int         `json:"buffer_size"`
+		NATS       EventsNATS  `json:"nats"`
+		Redis      EventsRedis `json:"redis"`
+	} `json:"events"`
+
+	// Alerting configuration. Alert rules are evaluated every
+	// IntervalSeconds, or never while it is zero; alerts are posted to
+	// WebhookURL when it is set.
+	Alerting struct {
+		IntervalSeconds int    `json:"interval_seconds"`
+		WebhookURL      string `json:"webhook_url"`
+	} `json:"alerting"`
+
+	// Cluster configuration. While Enabled is set, the instances sharing
+	// the database elect a leader that alone runs backups, the retention
+	// janitor and the alert evaluator; InstanceID, the host name and process
+	// ID by default, must differ between instances. The leader's lease
+	// lasts LeaseSeconds without renewal.
+	Cluster struct {
+		Enabled      bool   `json:"enabled"`
+		InstanceID   string `json:"instance_id"`
+		LeaseSeconds int    `json:"lease_seconds"`
+	} `json:"cluster"`
+
+	// Runtime configuration. The Replay nodes of simulations send the CSV
+	// files in DataDir, and nothing while it is empty.
+	Runtime struct {
+		DataDir     string             `json:"data_dir"`
+		Limits      RuntimeLimits      `json:"limits"`
+		Faults      RuntimeFaults      `json:"faults"`
+		Compression RuntimeCompression `json:"compression"`
+		Quotas      RuntimeQuotas      `json:"quotas"`
+		Lookups     RuntimeLookups     `json:"lookups"`
+	} `json:"runtime"`
+
+	// Reload configuration. The config file is checked for changes every
+	// WatchSeconds, or only reloaded on SIGHUP while it is zero.
+	Reload struct {
+		WatchSeconds int `json:"watch_seconds"`
+	} `json:"reload"`
+
+	// Secrets configuration. Provider is "env" to read secrets from
+	// environment variables named EnvPrefix followed by the secret's name,
+	// "file" to read them from the files in Dir or "vault"; secrets without
+	// a lease are cached for CacheSeconds.
+	Secrets struct {
+		Provider     string       `json:"provider"`
+		EnvPrefix    string       `json:"env_prefix"`
+		Dir          string       `json:"dir"`
+		CacheSeconds int          `json:"cache_seconds"`
+		Vault        SecretsVault `json:"vault"`
+	} `json:"secrets"`
+
+	// sources maps the dotted paths of settings to where their values came
+	// from; see Source
+	sources map[string]string
+	// profile is the profile the configuration was loaded with
+	profile string
+	// files lists the config files the configuration was read from
+	files []string
 }
 
 var defaultConfig = Config{
 	Server: struct {
-		Host string `json:"host"`
-		Port int    `json:"port"`
+		Host       string         `json:"host"`
+		Port       int            `json:"port"`
+		AdminToken string         `json:"admin_token"`
+		APIKeys    []string       `json:"api_keys"`
+		Timeouts   ServerTimeouts `json:"timeouts"`
+		TLS        ServerTLS      `json:"tls"`
 	}{
 		Host: "0.0.0.0",
 		Port: 8080,
+		Timeouts: ServerTimeouts{
+			ReadHeaderSeconds: 10,
+			IdleSeconds:       120,
+			ShutdownSeconds:   30,
+			DrainSeconds:      300,
+		},
+		TLS: ServerTLS{
+			MinVersion: "1.2",
+		},
 	},
 	Database: struct {
-		Path string `json:"path"`
+		Driver       string `json:"driver"`
+		Path         string `json:"path"`
+		DSN          string `json:"dsn"`
+		JournalMode  string `json:"journal_mode"`
+		BusyTimeout  int    `json:"busy_timeout_ms"`
+		ForeignKeys  bool   `json:"foreign_keys"`
+		MaxOpenConns int    `json:"max_open_conns"`
+		MaxIdleConns int    `json:"max_idle_conns"`
+		SlowQueryMs  int    `json:"slow_query_ms"`
+	}{
+		Driver:       "sqlite",
+		Path:         "data/flows.db",
+		JournalMode:  "WAL",
+		BusyTimeout:  5000,
+		ForeignKeys:  true,
+		MaxOpenConns: 10,
+		MaxIdleConns: 5,
+		SlowQueryMs:  200,
+	},
+	Backup: struct {
+		Dir             string `json:"dir"`
+		IntervalMinutes int    `json:"interval_minutes"`
+		Retain          int    `json:"retain"`
 	}{
-		Path: "data/flows.db",
+		Dir:    "data/backups",
+		Retain: 7,
+	},
+	Retention: struct {
+		IntervalMinutes int            `json:"interval_minutes"`
+		BatchSize       int            `json:"batch_size"`
+		MaxAgeDays      map[string]int `json:"max_age_days"`
+	}{
+		IntervalMinutes: 60,
+		BatchSize:       500,
+		MaxAgeDays: map[string]int{
+			"audit_log": 90,
+		},
 	},
 	Logging: struct {
-		Level  string `json:"level"`
-		Format string `json:"format"`
+		Level    string                 `json:"level"`
+		Format   string                 `json:"format"`
+		File     string                 `json:"file"`
+		Rotation LogRotation            `json:"rotation"`
+		Sampling map[string]LogSampling `json:"sampling"`
+		Loki     LokiSink               `json:"loki"`
 	}{
 		Level:  "info",
 		Format: "console",
+		File:   "logs/flow-control.log",
+		Rotation: LogRotation{
+			MaxSizeMB:  100,
+			MaxBackups: 5,
+			MaxAgeDays: 30,
+			Compress:   true,
+		},
+	},
+	Tracing: struct {
+		Enabled     bool              `json:"enabled"`
+		ServiceName string            `json:"service_name"`
+		Endpoint    string            `json:"endpoint"`
+		Insecure    bool              `json:"insecure"`
+		SampleRatio float64           `json:"sample_ratio"`
+		Headers     map[string]string `json:"headers"`
+	}{
+		ServiceName: "flow-control",
+		Endpoint:    "localhost:4318",
+		Insecure:    true,
+		SampleRatio: 1,
+	},
+	Events: struct {
+		Backend    string      `json:"backend"`
+		BufferSize int         `json:"buffer_size"`
+		NATS       EventsNATS  `json:"nats"`
+		Redis      EventsRedis `json:"redis"`
+	}{
+		Backend:    "memory",
+		BufferSize: 256,
+	},
+	Alerting: struct {
+		IntervalSeconds int    `json:"interval_seconds"`
+		WebhookURL      string `json:"webhook_url"`
+	}{
+		IntervalSeconds: 30,
+	},
+	Cluster: struct {
+		Enabled      bool   `json:"enabled"`
+		InstanceID   string `json:"instance_id"`
+		LeaseSeconds int    `json:"lease_seconds"`
+	}{
+		LeaseSeconds: 15,
+	},
+	Runtime: struct {
+		DataDir     string             `json:"data_dir"`
+		Limits      RuntimeLimits      `json:"limits"`
+		Faults      RuntimeFaults      `json:"faults"`
+		Compression RuntimeCompression `json:"compression"`
+		Quotas      RuntimeQuotas      `json:"quotas"`
+		Lookups     RuntimeLookups     `json:"lookups"`
+	}{
+		Quotas: RuntimeQuotas{
+			Default: ProjectQuota{MaxRuns: 20},
+		},
+	},
+	Reload: struct {
+		WatchSeconds int `json:"watch_seconds"`
+	}{
+		WatchSeconds: 10,
+	},
+	Secrets: struct {
+		Provider     string       `json:"provider"`
+		EnvPrefix    string       `json:"env_prefix"`
+		Dir          string       `json:"dir"`
+		CacheSeconds int          `json:"cache_seconds"`
+		Vault        SecretsVault `json:"vault"`
+	}{
+		Provider:     "env",
+		EnvPrefix:    "SECRET_",
+		Dir:          "/run/secrets",
+		CacheSeconds: 300,
 	},
 }
 
-// Validate checks if the configuration is valid
-func (c *Config) Validate() error {
-	// Validate server configuration
-	if c.Server.Port < 1 || c.Server.Port > 65535 {
-		return fmt.Errorf("invalid port number: %d", c.Server.Port)
-	}
+// Profile returns the profile the configuration was loaded with, or "" for
+// the base settings
+func (c *Config) Profile() string {
+	return c.profile
+}
 
-	// Validate database configuration
-	if c.Database.Path == "" {
-		return fmt.Errorf("database path cannot be empty")
+// PrepareDirs creates the directory of a file-based database, so that it
+// can be opened
+func (c *Config) PrepareDirs() error {
+	if strings.EqualFold(c.Database.Driver, "postgres") {
+		return nil
 	}
-	if !strings.HasSuffix(c.Database.Path, ".db") {
-		return fmt.Errorf("database path must end with .db")
+	dbDir := filepath.Dir(c.Database.Path)
+	if err := os.MkdirAll(dbDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create database directory: %w", err)
 	}
+	return nil
+}
 
-	// Validate logging configuration
-	validLevels := map[string]bool{
-		"trace": true,
-		"debug": true,
-		"info":  true,
-		"warn":  true,
-		"error": true,
-	}
-	if !validLevels[strings.ToLower(c.Logging.Level)] {
-		return fmt.Errorf("invalid log level: %s", c.Logging.Level)
-	}
+// Override adjusts a loaded configuration before it is validated
+type Override func(*Config) error
 
-	validFormats := map[string]bool{
-		"console": true,
-		"json":    true,
+// envVar is an environment variable that overrides a setting
+type envVar struct {
+	// field is the dotted path of the setting
+	field string
+	set   func(c *Config, value string) error
+}
+
+// envVars maps environment variables to the settings they override
+var envVars = map[string]envVar{
+	"SERVER_HOST": {"server.host", func(c *Config, v string) error { c.Server.Host = v; return nil }},
+	"SERVER_PORT": {"server.port", func(c *Config, v string) error {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid port: %s", v)
+		}
+		c.Server.Port = port
+		return nil
+	}},
+	"ADMIN_TOKEN":         {"server.admin_token", func(c *Config, v string) error { c.Server.AdminToken = v; return nil }},
+	"API_KEYS":            {"server.api_keys", func(c *Config, v string) error { c.Server.APIKeys = strings.Split(v, ","); return nil }},
+	"CLUSTER_INSTANCE_ID": {"cluster.instance_id", func(c *Config, v string) error { c.Cluster.InstanceID = v; return nil }},
+	"DATABASE_DRIVER":     {"database.driver", func(c *Config, v string) error { c.Database.Driver = v; return nil }},
+	"DATABASE_PATH":       {"database.path", func(c *Config, v string) error { c.Database.Path = v; return nil }},
+	"DATABASE_DSN":        {"database.dsn", func(c *Config, v string) error { c.Database.DSN = v; return nil }},
+	"LOG_LEVEL":           {"logging.level", func(c *Config, v string) error { c.Logging.Level = v; return nil }},
+	"LOG_FORMAT":          {"logging.format", func(c *Config, v string) error { c.Logging.Format = v; return nil }},
+	"LOG_FILE":            {"logging.file", func(c *Config, v string) error { c.Logging.File = v; return nil }},
+	"TLS_CERT_FILE":       {"server.tls.cert_file", func(c *Config, v string) error { c.Server.TLS.CertFile = v; return nil }},
+	"TLS_KEY_FILE":        {"server.tls.key_file", func(c *Config, v string) error { c.Server.TLS.KeyFile = v; return nil }},
+	"VAULT_ADDR":          {"secrets.vault.address", func(c *Config, v string) error { c.Secrets.Vault.Address = v; return nil }},
+	"VAULT_TOKEN":         {"secrets.vault.token", func(c *Config, v string) error { c.Secrets.Vault.Token = v; return nil }},
+}
+
+// EnvVars returns the names of the environment variables FromEnv reads
+func EnvVars() []string {
+	names := make([]string, 0, len(envVars))
+	for name := range envVars {
+		names = append(names, name)
 	}
-	if !validFormats[strings.ToLower(c.Logging.Format)] {
-		return fmt.Errorf("invalid log format: %s", c.Logging.Format)
+	sort.Strings(names)
+	return names
+}
+
+// FromEnv overrides settings with the environment variables that lookup
+// finds, such as SERVER_PORT and LOG_LEVEL. Values that cannot be parsed
+// are reported together as a *ValidationError.
+func FromEnv(lookup func(string) (string, bool)) Override {
+	return func(c *Config) error {
+		var problems []Problem
+		for _, name := range EnvVars() {
+			value, ok := lookup(name)
+			if !ok || value == "" {
+				continue
+			}
+			env := envVars[name]
+			source := "environment variable " + name
+			if err := env.set(c, value); err != nil {
+				problems = append(problems, Problem{Field: env.field, Message: err.Error(), Source: source})
+				continue
+			}
+			c.SetSource(env.field, source)
+		}
+		if len(problems) > 0 {
+			return &ValidationError{Problems: problems}
+		}
+		return nil
 	}
+}
 
-	return nil
+// Load loads the configuration from a JSON, YAML or TOML file, whose
+// format is found by DetectFormat, or the defaults when path is empty.
+// Settings the file leaves out keep their defaults. Overrides, such as
+// FromEnv and command-line flags, are applied in order on top of the file,
+// so later ones take precedence, and the result is validated. Invalid
+// settings are reported together as a *ValidationError that tells where
+// each value came from; settings that Config does not know are logged as
+// warnings.
+func Load(path string, log types.Logger, overrides ...Override) (*Config, error) {
+	return LoadProfile(path, "", log, overrides...)
 }
 
-// Load loads the configuration from a file
-func Load(path string, log types.Logger) (*Config, error) {
+// LoadProfile loads the configuration like Load, with the settings of
+// profile, such as staging or prod, applied on top of the base settings.
+// Path is a config file, whose profiles section holds the profiles, or a
+// directory holding a base file, such as base.yaml, and a file per
+// profile, such as prod.yaml. Profiles inherit from the base settings, or
+// from the profile named by their extends setting. An empty profile
+// selects the base settings.
+func LoadProfile(path, profile string, log types.Logger, overrides ...Override) (*Config, error) {
 	log.Debug("Loading configuration", types.Fields{
 		"function": "Load",
 		"path":     path,
+		"profile":  profile,
 	})
 
-	// Start with default config
+	// Start with default config, copying maps so that loading a file never
+	// mutates the defaults
 	config := defaultConfig
+	config.Retention.MaxAgeDays = maps.Clone(defaultConfig.Retention.MaxAgeDays)
 
-	// If no path provided, use default
+	config.profile = profile
+	if path == "" && profile != "" {
+		return nil, fmt.Errorf("config profile %s needs a config file", profile)
+	}
 	if path == "" {
 		log.Info("No config file provided, using defaults", types.Fields{
 			"function": "Load",
 		})
-		return &config, nil
-	}
-
-	// Read config file
-	data, err := os.ReadFile(path)
-	if err != nil {
-		log.Error("Failed to read config file", err, types.Fields{
-			"function": "Load",
-			"path":     path,
-		})
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+	} else if err := config.loadFile(path, profile, log); err != nil {
+		return nil, err
 	}
 
-	// Parse config file
-	if err := json.Unmarshal(data, &config); err != nil {
-		log.Error("Failed to parse config file", err, types.Fields{
-			"function": "Load",
-			"path":     path,
-		})
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	// Apply overrides, collecting the values they reject with the problems
+	// found by validation
+	var problems []Problem
+	for _, override := range overrides {
+		err := override(&config)
+		var invalid *ValidationError
+		if errors.As(err, &invalid) {
+			problems = append(problems, invalid.Problems...)
+			continue
+		}
+		if err != nil {
+			log.Error("Invalid configuration override", err, types.Fields{
+				"function": "Load",
+			})
+			return nil, fmt.Errorf("invalid configuration override: %w", err)
+		}
 	}
 
 	// Validate configuration
-	if err := config.Validate(); err != nil {
+	var invalid *ValidationError
+	if err := config.Validate(); errors.As(err, &invalid) {
+		problems = append(problems, invalid.Problems...)
+	}
+	if len(problems) > 0 {
+		err := &ValidationError{Problems: problems}
 		log.Error("Invalid configuration", err, types.Fields{
 			"function": "Load",
 			"path":     path,
+			"problems": len(problems),
 		})
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
 
-	// Ensure database directory exists
-	dbDir := filepath.Dir(config.Database.Path)
-	if err := os.MkdirAll(dbDir, 0o755); err != nil {
-		log.Error("Failed to create database directory", err, types.Fields{
-			"function": "Load",
-			"path":     dbDir,
-		})
-		return nil, fmt.Errorf("failed to create database directory: %w", err)
-	}
-
 	log.Info("Configuration loaded successfully", types.Fields{
 		"function": "Load",
 		"path":     path,
+		"profile":  profile,
 	})
 
 	return &config, nil
 }
+
+// loadFile reads the configuration at path, with profile applied, into c
+// and records the file and line of each setting as its source
+func (c *Config) loadFile(path, profile string, log types.Logger) error {
+	layers, err := readLayers(path, profile, log)
+	if err != nil {
+		return err
+	}
+
+	// Merge the layers, later ones taking precedence
+	doc := map[string]interface{}{}
+	sources := map[string]string{}
+	c.files = nil
+	for _, l := range layers {
+		mergeDocument(doc, l.doc)
+		for key, line := range l.lines {
+			sources[key] = fmt.Sprintf("%s:%d", l.path, line)
+		}
+		if !slices.Contains(c.files, l.path) {
+			c.files = append(c.files, l.path)
+		}
+	}
+	data, err := json.Marshal(doc)
+	if err == nil {
+		err = json.Unmarshal(data, c)
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		// Point at the setting rather than at the merged JSON
+		field, _ := resolveField(typeErr.Field)
+		source, ok := sources[typeErr.Field]
+		if !ok {
+			source = path
+		}
+		err = &ValidationError{Problems: []Problem{{
+			Field:   field,
+			Message: fmt.Sprintf("expected %s, found %s", typeErr.Type, typeErr.Value),
+			Source:  source,
+		}}}
+	}
+	if err != nil {
+		log.Error("Failed to parse config file", err, types.Fields{
+			"function": "Load",
+			"path":     path,
+			"profile":  profile,
+		})
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	warned := map[string]bool{}
+	for _, key := range sortedKeys(sources) {
+		field, unknown := resolveField(key)
+		if unknown != "" {
+			if warned[unknown] {
+				continue
+			}
+			warned[unknown] = true
+			log.Warn("Ignoring unknown config setting", types.Fields{
+				"function": "Load",
+				"setting":  unknown,
+				"source":   sources[key],
+			})
+			continue
+		}
+		c.SetSource(field, sources[key])
+	}
+	return nil
+}
@@ -1,11 +1,15 @@
 package config_test
 
 import (
+	"context"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"flow-control/internal/config"
 	"flow-control/internal/logger"
+	"flow-control/internal/secrets"
 
 	"github.com/stretchr/testify/require"
 )
@@ -36,6 +40,9 @@ func TestConfig(t *testing.T) {
 			},
 			"database": {
 				"path": "test.db"
+			},
+			"retention": {
+				"max_age_days": {"flow_versions": 30}
 			}
 		}`
 		tmpfile, err := os.CreateTemp("", "config-*.json")
@@ -60,6 +67,12 @@ func TestConfig(t *testing.T) {
 		require.Equal(t, "127.0.0.1", cfg.Server.Host)
 		require.Equal(t, 9090, cfg.Server.Port)
 		require.Equal(t, "test.db", cfg.Database.Path)
+		require.Equal(t, map[string]int{"audit_log": 90, "flow_versions": 30}, cfg.Retention.MaxAgeDays)
+
+		// Loading a file must not change the defaults
+		defaults, err := config.Load("", log)
+		require.NoError(t, err)
+		require.Equal(t, map[string]int{"audit_log": 90}, defaults.Retention.MaxAgeDays)
 	})
 
 	// Test invalid config file
@@ -94,4 +107,607 @@ func TestConfig(t *testing.T) {
 		_, err := config.Load("nonexistent.json", log)
 		require.Error(t, err)
 	})
+
+	// Test postgres driver validation
+	t.Run("postgres driver", func(t *testing.T) {
+		cfg, err := config.Load("", log)
+		require.NoError(t, err)
+
+		cfg.Database.Driver = "postgres"
+		cfg.Database.DSN = ""
+		require.Error(t, cfg.Validate())
+
+		cfg.Database.DSN = "postgres://localhost/flowcontrol"
+		require.NoError(t, cfg.Validate())
+
+		cfg.Database.Driver = "oracle"
+		require.Error(t, cfg.Validate())
+	})
+
+	// Test backup validation
+	t.Run("backup schedule", func(t *testing.T) {
+		cfg, err := config.Load("", log)
+		require.NoError(t, err)
+
+		cfg.Backup.IntervalMinutes = 60
+		require.NoError(t, cfg.Validate())
+
+		cfg.Backup.Dir = ""
+		require.Error(t, cfg.Validate())
+
+		cfg.Backup.Dir = "data/backups"
+		cfg.Backup.Retain = -1
+		require.Error(t, cfg.Validate())
+
+		cfg.Backup.Retain = 7
+		cfg.Database.Driver = "postgres"
+		cfg.Database.DSN = "postgres://localhost/flowcontrol"
+		require.Error(t, cfg.Validate())
+	})
+
+	// Test override precedence
+	t.Run("overrides", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"server": {"port": 9090}, "logging": {"level": "warn"}}`), 0o600))
+
+		env := map[string]string{"SERVER_PORT": "9191", "LOG_LEVEL": "debug"}
+		lookup := func(name string) (string, bool) {
+			value, ok := env[name]
+			return value, ok
+		}
+		flags := func(c *config.Config) error {
+			c.Server.Port = 9292
+			return nil
+		}
+
+		// Environment variables override the file, and later overrides win
+		cfg, err := config.Load(path, log, config.FromEnv(lookup))
+		require.NoError(t, err)
+		require.Equal(t, 9191, cfg.Server.Port)
+		require.Equal(t, "debug", cfg.Logging.Level)
+
+		cfg, err = config.Load(path, log, config.FromEnv(lookup), flags)
+		require.NoError(t, err)
+		require.Equal(t, 9292, cfg.Server.Port)
+
+		// Overridden values are validated
+		env["SERVER_PORT"] = "http"
+		_, err = config.Load(path, log, config.FromEnv(lookup))
+		require.Error(t, err)
+		env["SERVER_PORT"] = "70000"
+		_, err = config.Load(path, log, config.FromEnv(lookup))
+		require.Error(t, err)
+		delete(env, "SERVER_PORT")
+
+		// API keys are given as a comma-separated list
+		env["API_KEYS"] = "ci,ops"
+		cfg, err = config.Load(path, log, config.FromEnv(lookup))
+		require.NoError(t, err)
+		require.Equal(t, []string{"ci", "ops"}, cfg.Server.APIKeys)
+		env["API_KEYS"] = "ci,"
+		_, err = config.Load(path, log, config.FromEnv(lookup))
+		require.Error(t, err)
+	})
+
+	// Test event bus validation
+	t.Run("event backend", func(t *testing.T) {
+		cfg, err := config.Load("", log)
+		require.NoError(t, err)
+		require.Equal(t, "memory", cfg.Events.Backend)
+
+		cfg.Events.Backend = "nats"
+		require.Error(t, cfg.Validate())
+
+		cfg.Events.NATS.URL = "nats://localhost:4222"
+		require.NoError(t, cfg.Validate())
+
+		cfg.Events.Backend = "redis"
+		require.Error(t, cfg.Validate())
+
+		cfg.Events.Redis.URL = "localhost:6379"
+		require.Error(t, cfg.Validate())
+
+		cfg.Events.Redis.URL = "redis://:secret@localhost:6379"
+		require.NoError(t, cfg.Validate())
+		require.NotContains(t, cfg.Redacted().Events.Redis.URL, "secret")
+
+		cfg.Events.Backend = "kafka"
+		require.Error(t, cfg.Validate())
+	})
+
+	// Test the endpoints Enrich nodes may reach
+	t.Run("lookups", func(t *testing.T) {
+		cfg, err := config.Load("", log)
+		require.NoError(t, err)
+
+		cfg.Runtime.Lookups.URLs = []string{"https://crm.internal/customers/"}
+		cfg.Runtime.Lookups.Databases = map[string]config.LookupDatabase{
+			"crm": {Driver: "postgres", DSN: "postgres://app:secret@db/crm"},
+		}
+		require.NoError(t, cfg.Validate())
+		require.Equal(t, "REDACTED", cfg.Redacted().Runtime.Lookups.Databases["crm"].DSN)
+		require.Contains(t, cfg.Runtime.Lookups.Databases["crm"].DSN, "secret")
+
+		cfg.Runtime.Lookups.URLs = []string{"crm.internal/customers/"}
+		require.Error(t, cfg.Validate())
+		cfg.Runtime.Lookups.URLs = nil
+		cfg.Runtime.Lookups.Databases["crm"] = config.LookupDatabase{Driver: "mysql"}
+		err = cfg.Validate()
+		require.ErrorContains(t, err, "runtime.lookups.databases.crm.driver")
+		require.ErrorContains(t, err, "runtime.lookups.databases.crm.dsn")
+	})
+
+	// Test the file formats
+	t.Run("file formats", func(t *testing.T) {
+		files := map[string]string{
+			"config.json": `{
+				"server": {"port": 9090, "api_keys": ["ci", "ops"]},
+				"logging": {"level": "warn", "loki": {"labels": {"env": "prod"}}},
+				"retention": {"max_age_days": {"flow_versions": 30}}
+			}`,
+			"config.yaml": `
+# Production settings
+server:
+  port: 9090
+  api_keys: [ci, ops]
+logging:
+  level: warn
+  loki:
+    labels:
+      env: prod
+retention:
+  max_age_days:
+    flow_versions: 30
+`,
+			"config.toml": `
+# Production settings
+server.port = 9090
+server.api_keys = [
+    "ci",  # continuous integration
+    'ops',
+]
+
+[logging]
+level = "warn"
+loki = { labels = { env = "prod" } }
+
+[retention.max_age_days]
+flow_versions = 3_0
+`,
+		}
+		for name, content := range files {
+			path := filepath.Join(t.TempDir(), name)
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+			cfg, err := config.Load(path, log)
+			require.NoError(t, err, name)
+			require.Equal(t, 9090, cfg.Server.Port, name)
+			require.Equal(t, []string{"ci", "ops"}, cfg.Server.APIKeys, name)
+			require.Equal(t, "warn", cfg.Logging.Level, name)
+			require.Equal(t, map[string]string{"env": "prod"}, cfg.Logging.Loki.Labels, name)
+			require.Equal(t, map[string]int{"audit_log": 90, "flow_versions": 30}, cfg.Retention.MaxAgeDays, name)
+			// Settings the file leaves out keep their defaults
+			require.Equal(t, "0.0.0.0", cfg.Server.Host, name)
+		}
+
+		// Files of any format are overridden by the environment
+		path := filepath.Join(t.TempDir(), "config.yml")
+		require.NoError(t, os.WriteFile(path, []byte("server:\n  port: 9090\n"), 0o600))
+		cfg, err := config.Load(path, log, config.FromEnv(func(name string) (string, bool) {
+			return map[string]string{"SERVER_PORT": "9191"}[name], name == "SERVER_PORT"
+		}))
+		require.NoError(t, err)
+		require.Equal(t, 9191, cfg.Server.Port)
+
+		// Empty YAML files select the defaults
+		require.NoError(t, os.WriteFile(path, []byte("# nothing yet\n"), 0o600))
+		cfg, err = config.Load(path, log)
+		require.NoError(t, err)
+		require.Equal(t, 8080, cfg.Server.Port)
+	})
+
+	// Test format detection
+	t.Run("format detection", func(t *testing.T) {
+		tests := []struct {
+			path    string
+			content string
+			format  string
+		}{
+			{"config.JSON", "server: {}", config.FormatJSON},
+			{"config.yml", "{}", config.FormatYAML},
+			{"flowcontrol.conf", "\n  {\"server\": {}}", config.FormatJSON},
+			{"flowcontrol.conf", "# comment\n[server]\nport = 1", config.FormatTOML},
+			{"flowcontrol.conf", "title = \"prod\"", config.FormatTOML},
+			{"flowcontrol.conf", "server:\n  port: 1", config.FormatYAML},
+			{"flowcontrol.conf", "", config.FormatYAML},
+		}
+		for _, tt := range tests {
+			require.Equal(t, tt.format, config.DetectFormat(tt.path, []byte(tt.content)), tt.content)
+		}
+	})
+
+	// Test TOML syntax
+	t.Run("toml", func(t *testing.T) {
+		load := func(content string) (*config.Config, error) {
+			path := filepath.Join(t.TempDir(), "config.toml")
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+			return config.Load(path, log)
+		}
+
+		cfg, err := load(`
+[server]
+"host" = "127.0.0.1"   # quoted key
+admin_token = """
+multi\
+   line"""
+port = 0x1F90
+
+[tracing]
+sample_ratio = 5e-1
+headers."x-team" = '\\flow'
+
+[logging.sampling.store]
+debug_every = 10
+
+[logging]
+level = "debug"
+format = "json"
+`)
+		require.NoError(t, err)
+		require.Equal(t, "127.0.0.1", cfg.Server.Host)
+		require.Equal(t, "multiline", cfg.Server.AdminToken)
+		require.Equal(t, 8080, cfg.Server.Port)
+		require.Equal(t, 0.5, cfg.Tracing.SampleRatio)
+		require.Equal(t, map[string]string{"x-team": `\\flow`}, cfg.Tracing.Headers)
+		require.Equal(t, 10, cfg.Logging.Sampling["store"].DebugEvery)
+		require.Equal(t, "json", cfg.Logging.Format)
+
+		errors := map[string]string{
+			"[server]\nport = 1\nport = 2":           "key port is already defined",
+			"[server]\n[server]":                     "table server already exists",
+			"[server\nport = 1":                      "line 1: expected character ]",
+			"[server]\nhost = \"open":                `line 2: basic string not terminated by "`,
+			"[backup]\ndir = \"a\" dir2 = \"b\"":     "line 2: expected newline",
+			"[server]\nhost = \"\\q\"":               "line 2: invalid escaped character U+0071 'q'",
+			"server = 1\n[server.port]":              "expected server to be a table, not a value",
+			"[server]\nports = [1, 2\n\nhost = \"\"": "line 4: array elements must be separated by commas",
+		}
+		for content, want := range errors {
+			_, err := load(content)
+			require.ErrorContains(t, err, want, content)
+		}
+	})
+
+	// Test problem reporting
+	t.Run("problems", func(t *testing.T) {
+		problems := func(err error) []string {
+			var invalid *config.ValidationError
+			require.ErrorAs(t, err, &invalid)
+			var lines []string
+			for _, p := range invalid.Problems {
+				lines = append(lines, p.String())
+			}
+			return lines
+		}
+
+		// Every problem is reported with the line that set it
+		files := map[string]string{
+			"config.json": "{\n  \"server\": {\"port\": 70000},\n  \"logging\": {\n\n    \"level\": \"loud\"\n  }\n}",
+			"config.yaml": "server:\n  port: 70000\nlogging:\n\n  level: loud\n",
+			"config.toml": "[server]\nport = 70000\n\n[logging]\nlevel = \"loud\"\n",
+		}
+		for name, content := range files {
+			path := filepath.Join(t.TempDir(), name)
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+			_, err := config.Load(path, log)
+			require.Equal(t, []string{
+				"server.port: invalid port number: 70000 (" + path + ":2)",
+				"logging.level: invalid log level: loud (" + path + ":5)",
+			}, problems(err), name)
+		}
+
+		// Environment variables and overrides name themselves
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		require.NoError(t, os.WriteFile(path, []byte("database:\n  dsn: postgres://localhost/flows\n"), 0o600))
+		env := map[string]string{"SERVER_PORT": "http", "LOG_FORMAT": "xml"}
+		lookup := func(name string) (string, bool) {
+			value, ok := env[name]
+			return value, ok
+		}
+		flags := func(c *config.Config) error {
+			c.Events.Backend = "kafka"
+			c.SetSource("events.backend", "flag -events")
+			return nil
+		}
+		_, err := config.Load(path, log, config.FromEnv(lookup), flags)
+		require.Equal(t, []string{
+			"server.port: invalid port: http (environment variable SERVER_PORT)",
+			"database.dsn: dsn is only used by the postgres driver; the sqlite driver uses the database path (" + path + ":2)",
+			"logging.format: invalid log format: xml (environment variable LOG_FORMAT)",
+			"events.backend: invalid event backend: kafka (flag -events)",
+		}, problems(err))
+
+		// Values of the wrong type point at their line
+		require.NoError(t, os.WriteFile(path, []byte("server:\n  host: 0.0.0.0\n  port: eighty\n"), 0o600))
+		_, err = config.Load(path, log)
+		require.Equal(t, []string{"server.port: expected int, found string (" + path + ":3)"}, problems(err))
+
+		// Unknown settings are ignored, and keys match fields regardless
+		// of case
+		require.NoError(t, os.WriteFile(path, []byte("Server:\n  Port: 9090\nlogging:\n  file: flow.log\nmetrics:\n  enabled: true\n"), 0o600))
+		cfg, err := config.Load(path, log)
+		require.NoError(t, err)
+		require.Equal(t, 9090, cfg.Server.Port)
+		require.Equal(t, path+":2", cfg.Source("server.port"))
+		require.Equal(t, config.SourceDefault, cfg.Source("server.host"))
+
+		// Validate reports problems without sources as defaults
+		cfg.Server.Port = 0
+		cfg.Database.Path = ""
+		require.Equal(t, []string{
+			"server.port: invalid port number: 0 (" + path + ":2)",
+			"database.path: database path cannot be empty (default)",
+		}, problems(cfg.Validate()))
+	})
+
+	// Test the server, logging and runtime sections
+	t.Run("server settings", func(t *testing.T) {
+		cfg, err := config.Load("", log)
+		require.NoError(t, err)
+		require.Equal(t, 10, cfg.Server.Timeouts.ReadHeaderSeconds)
+		require.Equal(t, 0, cfg.Server.Timeouts.WriteSeconds)
+		require.Equal(t, 30, cfg.Server.Timeouts.ShutdownSeconds)
+		require.Equal(t, 300, cfg.Server.Timeouts.DrainSeconds)
+		require.Equal(t, "logs/flow-control.log", cfg.Logging.File)
+		require.Equal(t, 100, cfg.Logging.Rotation.MaxSizeMB)
+		require.False(t, cfg.Cluster.Enabled)
+		require.Equal(t, 15, cfg.Cluster.LeaseSeconds)
+
+		cfg.Server.Timeouts.IdleSeconds = -1
+		cfg.Server.Timeouts.ShutdownSeconds = 0
+		cfg.Server.Timeouts.DrainSeconds = 0
+		cfg.Logging.Rotation.MaxBackups = -1
+		cfg.Cluster.Enabled = true
+		cfg.Cluster.LeaseSeconds = 1
+		cfg.Runtime.Limits.DryRunTimeoutMs = -1
+		cfg.Runtime.Limits.MaxCallDepth = -1
+		cfg.Runtime.Limits.MaxPayloadBytes = -1
+		cfg.Runtime.Compression.Flows = map[string]string{"orders": "zstd", "events": "lz4"}
+		cfg.Runtime.Faults.Flows = map[string]config.FlowFaults{"orders": {ErrorRate: 1.5, LatencyMs: -1}}
+		cfg.Runtime.Quotas.Default.MaxStorageMB = -1
+		cfg.Runtime.Quotas.Projects = map[string]config.ProjectQuota{"billing": {MaxRuns: -1, MaxMessagesPerSecond: 10}, "default": {}}
+		var invalid *config.ValidationError
+		require.ErrorAs(t, cfg.Validate(), &invalid)
+		var fields []string
+		for _, p := range invalid.Problems {
+			fields = append(fields, p.Field)
+		}
+		require.Equal(t, []string{
+			"server.timeouts.idle_seconds",
+			"server.timeouts.shutdown_seconds",
+			"server.timeouts.drain_seconds",
+			"logging.rotation.max_backups",
+			"cluster.lease_seconds",
+			"runtime.limits.dry_run_timeout_ms",
+			"runtime.limits.max_call_depth",
+			"runtime.limits.max_payload_bytes",
+			"runtime.faults.flows.orders.error_rate",
+			"runtime.faults.flows.orders.latency_ms",
+			"runtime.compression.flows.events",
+			"runtime.quotas.default.max_storage_mb",
+			"runtime.quotas.projects.billing.max_runs",
+			"runtime.quotas.projects.default",
+		}, fields)
+
+		// Fault injection is never enabled in production
+		faults := filepath.Join(t.TempDir(), "faults.yaml")
+		require.NoError(t, os.WriteFile(faults, []byte("runtime:\n  faults:\n    enabled: true\nprofiles:\n  prod: {}\n  staging: {}\n"), 0o600))
+		cfg, err = config.LoadProfile(faults, "staging", log)
+		require.NoError(t, err)
+		require.True(t, cfg.Runtime.Faults.Enabled)
+		_, err = config.LoadProfile(faults, "prod", log)
+		require.ErrorContains(t, err, "runtime.faults.enabled: fault injection cannot be enabled in the prod profile")
+
+		// TLS needs both files, and they must exist
+		dir := t.TempDir()
+		cert := filepath.Join(dir, "tls.crt")
+		require.NoError(t, os.WriteFile(cert, []byte("cert"), 0o600))
+		path := filepath.Join(dir, "config.toml")
+		require.NoError(t, os.WriteFile(path, []byte("[server.tls]\ncert_file = '"+cert+"'\n"), 0o600))
+		_, err = config.Load(path, log)
+		require.ErrorContains(t, err, "server.tls.key_file: tls needs both a cert file and a key file (default)")
+
+		_, err = config.Load(path, log, config.FromEnv(func(name string) (string, bool) {
+			return filepath.Join(dir, "missing.key"), name == "TLS_KEY_FILE"
+		}))
+		require.ErrorContains(t, err, "server.tls.key_file: cannot read tls file")
+		require.ErrorContains(t, err, "(environment variable TLS_KEY_FILE)")
+
+		cfg, err = config.Load(path, log, config.FromEnv(func(name string) (string, bool) {
+			return cert, name == "TLS_KEY_FILE"
+		}))
+		require.NoError(t, err)
+		require.Equal(t, cert, cfg.Server.TLS.KeyFile)
+
+		cfg.Server.TLS.MinVersion = "1.0"
+		require.Error(t, cfg.Validate())
+	})
+
+	t.Run("secrets", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "config.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(`server:
+  admin_token: ${secret.ADMIN_TOKEN}
+  api_keys: [plain, "${secret.API_KEY}"]
+database:
+  driver: postgres
+  dsn: postgres://app:${secret.DB_PASSWORD}@db/flows
+`), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "ADMIN_TOKEN"), []byte("admin\n"), 0o600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "API_KEY"), []byte("key"), 0o600))
+		provider := secrets.NewFileProvider(dir)
+
+		// Secrets that cannot be resolved are reported with their setting
+		_, err := config.Load(path, log, config.ResolveSecrets(provider))
+		var invalid *config.ValidationError
+		require.ErrorAs(t, err, &invalid)
+		require.Len(t, invalid.Problems, 1)
+		require.Equal(t, "database.dsn", invalid.Problems[0].Field)
+		require.Contains(t, invalid.Problems[0].Message, "DB_PASSWORD")
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "DB_PASSWORD"), []byte("pa55"), 0o600))
+		cfg, err := config.Load(path, log, config.ResolveSecrets(provider))
+		require.NoError(t, err)
+		require.Equal(t, "admin", cfg.Server.AdminToken)
+		require.Equal(t, []string{"plain", "key"}, cfg.Server.APIKeys)
+		require.Equal(t, "postgres://app:pa55@db/flows", cfg.Database.DSN)
+
+		// The provider must be usable
+		cfg.Secrets.Provider = "vault"
+		require.ErrorContains(t, cfg.Validate(), "secrets.vault.address")
+	})
+}
+
+func TestReloader(t *testing.T) {
+	log := logger.New()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	write("server:\n  port: 9090\nlogging:\n  level: info\n")
+	cfg, err := config.Load(path, log)
+	require.NoError(t, err)
+	r := config.NewReloader(path, cfg, log)
+
+	var reloads [][]config.Change
+	r.OnReload(func(cfg *config.Config, changes []config.Change) {
+		reloads = append(reloads, changes)
+	})
+
+	// Reloadable settings are applied, others wait for a restart
+	write("server:\n  port: 9191\n  admin_token: secret\nlogging:\n  level: debug\nretention:\n  max_age_days:\n    audit_log: 7\n")
+	changes, err := r.Reload()
+	require.NoError(t, err)
+	require.Equal(t, []config.Change{
+		{Field: "logging.level", Old: "info", New: "debug", Applied: true},
+		{Field: "retention.max_age_days.audit_log", Old: float64(90), New: float64(7), Applied: true},
+		{Field: "server.admin_token", Old: "REDACTED", New: "REDACTED"},
+		{Field: "server.port", Old: float64(9090), New: float64(9191)},
+	}, changes)
+	require.Equal(t, [][]config.Change{changes}, reloads)
+	require.Equal(t, "debug", r.Config().Logging.Level)
+	require.Equal(t, 7, r.Config().Retention.MaxAgeDays["audit_log"])
+	require.Equal(t, 9090, r.Config().Server.Port)
+	require.Empty(t, r.Config().Server.AdminToken)
+	require.Equal(t, path+":5", r.Config().Source("logging.level"))
+	require.True(t, config.Changed(changes, "retention"))
+	require.False(t, config.Changed(changes, "tracing"))
+
+	// Invalid files are rejected before anything is applied
+	write("logging:\n  level: loud\n")
+	_, err = r.Reload()
+	require.ErrorContains(t, err, "logging.level: invalid log level: loud")
+	require.Equal(t, "debug", r.Config().Logging.Level)
+	require.Len(t, reloads, 1)
+
+	// Reloading an unchanged file changes nothing
+	write("server:\n  port: 9191\n  admin_token: secret\nlogging:\n  level: debug\nretention:\n  max_age_days:\n    audit_log: 7\n")
+	changes, err = r.Reload()
+	require.NoError(t, err)
+	require.Empty(t, changes)
+	require.Len(t, reloads, 1)
+
+	// The watcher reloads the file when it changes
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = r.Watch(ctx, 10*time.Millisecond)
+	}()
+	write("logging:\n  level: warn\n")
+	require.Eventually(t, func() bool {
+		return r.Config().Logging.Level == "warn"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestProfiles(t *testing.T) {
+	log := logger.New()
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	path := write("config.yaml", `server:
+  port: 8080
+logging:
+  level: debug
+retention:
+  max_age_days:
+    audit_log: 90
+profiles:
+  staging:
+    logging:
+      level: info
+    retention:
+      max_age_days:
+        flow_versions: 30
+  prod:
+    extends: staging
+    server:
+      port: 443
+`)
+
+	// Without a profile only the base settings apply
+	cfg, err := config.Load(path, log)
+	require.NoError(t, err)
+	require.Equal(t, "debug", cfg.Logging.Level)
+	require.Empty(t, cfg.Profile())
+
+	// Profiles inherit from the base settings and from the profile they
+	// extend, merging maps
+	cfg, err = config.LoadProfile(path, "prod", log)
+	require.NoError(t, err)
+	require.Equal(t, "prod", cfg.Profile())
+	require.Equal(t, 443, cfg.Server.Port)
+	require.Equal(t, "info", cfg.Logging.Level)
+	require.Equal(t, map[string]int{"audit_log": 90, "flow_versions": 30}, cfg.Retention.MaxAgeDays)
+	require.Equal(t, path+":18", cfg.Source("server.port"))
+	require.Equal(t, path+":11", cfg.Source("logging.level"))
+	require.Equal(t, path+":7", cfg.Source("retention.max_age_days.audit_log"))
+
+	_, err = config.LoadProfile(path, "qa", log)
+	require.ErrorContains(t, err, `unknown config profile "qa", expected one of: prod, staging`)
+
+	loop := write("loop.yaml", "profiles:\n  a:\n    extends: b\n  b:\n    extends: a\n")
+	_, err = config.LoadProfile(loop, "a", log)
+	require.ErrorContains(t, err, "extends itself")
+
+	// A directory holds a base file and a file per profile, in any format
+	confDir := filepath.Join(dir, "conf")
+	write("conf/base.toml", "[server]\nport = 8080\n\n[logging]\nlevel = \"debug\"\n")
+	prod := write("conf/prod.json", "{\n  \"logging\": {\"level\": \"warn\"}\n}\n")
+	cfg, err = config.LoadProfile(confDir, "prod", log)
+	require.NoError(t, err)
+	require.Equal(t, 8080, cfg.Server.Port)
+	require.Equal(t, "warn", cfg.Logging.Level)
+	require.Equal(t, prod+":2", cfg.Source("logging.level"))
+	_, err = config.LoadProfile(confDir, "staging", log)
+	require.ErrorContains(t, err, "expected one of: prod")
+
+	// Reloads keep the profile and notice changes to its file
+	r := config.NewReloader(confDir, cfg, log)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = r.Watch(ctx, 10*time.Millisecond)
+	}()
+	write("conf/prod.json", "{\n  \"logging\": {\"level\": \"error\"}\n}\n")
+	require.Eventually(t, func() bool {
+		return r.Config().Logging.Level == "error"
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "prod", r.Config().Profile())
 }
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"maps"
+	"slices"
+)
+
+// redacted replaces the values of secret settings
+const redacted = "REDACTED"
+
+// secretSettings lists the settings whose values are never shown, by
+// dotted path, with the function hiding their values in a configuration.
+// Unset values are left alone, so that it still shows they are unset.
+var secretSettings = []struct {
+	field  string
+	redact func(c *Config)
+}{
+	{"server.admin_token", func(c *Config) { redactString(&c.Server.AdminToken) }},
+	{"server.api_keys", func(c *Config) {
+		keys := slices.Clone(c.Server.APIKeys)
+		for i := range keys {
+			redactString(&keys[i])
+		}
+		c.Server.APIKeys = keys
+	}},
+	{"database.dsn", func(c *Config) { redactString(&c.Database.DSN) }},
+	{"events.redis.url", func(c *Config) { redactString(&c.Events.Redis.URL) }},
+	{"alerting.webhook_url", func(c *Config) { redactString(&c.Alerting.WebhookURL) }},
+	{"tracing.headers", func(c *Config) {
+		headers := maps.Clone(c.Tracing.Headers)
+		for name := range headers {
+			headers[name] = redacted
+		}
+		c.Tracing.Headers = headers
+	}},
+	{"secrets.vault.token", func(c *Config) { redactString(&c.Secrets.Vault.Token) }},
+	{"runtime.lookups.databases", func(c *Config) {
+		databases := maps.Clone(c.Runtime.Lookups.Databases)
+		for name, database := range databases {
+			redactString(&database.DSN)
+			databases[name] = database
+		}
+		c.Runtime.Lookups.Databases = databases
+	}},
+}
+
+// redactString hides the value of s unless it is empty
+func redactString(s *string) {
+	if *s != "" {
+		*s = redacted
+	}
+}
+
+// isSecret reports whether field is a secret setting or a setting under one
+func isSecret(field string) bool {
+	for _, secret := range secretSettings {
+		if within(field, secret.field) {
+			return true
+		}
+	}
+	return false
+}
+
+// Setting is the value of one setting and where it came from
+type Setting struct {
+	// Field is the dotted path of the setting, such as server.port
+	Field string      `json:"field"`
+	Value interface{} `json:"value"`
+	// Source is the file line, environment variable or flag that set the
+	// value, or "default"
+	Source string `json:"source"`
+}
+
+// Redacted returns a copy of c with the values of secret settings, such as
+// server.admin_token and database.dsn, replaced by REDACTED
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.sources = maps.Clone(c.sources)
+	for _, secret := range secretSettings {
+		secret.redact(&r)
+	}
+	return &r
+}
+
+// Settings lists every set value of c in order of their paths, with their
+// sources. Values of secret settings are redacted.
+func (c *Config) Settings() []Setting {
+	values := flatten(c.Redacted())
+	settings := make([]Setting, 0, len(values))
+	for _, field := range sortedKeys(values) {
+		settings = append(settings, Setting{
+			Field:  field,
+			Value:  values[field],
+			Source: c.Source(field),
+		})
+	}
+	return settings
+}
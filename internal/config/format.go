@@ -0,0 +1,206 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of config files
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// formatExtensions maps config file extensions to their formats
+var formatExtensions = map[string]string{
+	".json": FormatJSON,
+	".yaml": FormatYAML,
+	".yml":  FormatYAML,
+	".toml": FormatTOML,
+}
+
+// tomlLine matches the lines that start a TOML document: a table header or
+// a key/value pair
+var tomlLine = regexp.MustCompile(`^(\[|[A-Za-z0-9_."'-]+\s*=)`)
+
+// DetectFormat returns the format of a config file, from its extension or,
+// when the extension is not known, from its content: JSON starts with a
+// brace, TOML with a table header or a key = value pair, and anything else
+// is read as YAML.
+func DetectFormat(path string, data []byte) string {
+	if format, ok := formatExtensions[strings.ToLower(filepath.Ext(path))]; ok {
+		return format
+	}
+
+	// The first line that is not blank or a comment decides
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "{"):
+			return FormatJSON
+		case tomlLine.MatchString(line):
+			return FormatTOML
+		}
+		return FormatYAML
+	}
+	return FormatYAML
+}
+
+// parseDocument reads a config file of the given format as a JSON object,
+// so that every format uses the json field names of Config. It returns the
+// line of each setting of the file that is not an object, by dotted key
+// such as server.port.
+func parseDocument(format string, data []byte) (map[string]interface{}, map[string]int, error) {
+	var doc interface{}
+	var lines map[string]int
+	switch format {
+	case FormatJSON:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			return nil, nil, err
+		}
+		lines = jsonLines(data)
+	case FormatYAML:
+		var root yaml.Node
+		if err := yaml.Unmarshal(data, &root); err != nil {
+			return nil, nil, err
+		}
+		if root.Kind == 0 {
+			// The file is empty or only holds comments
+			return map[string]interface{}{}, map[string]int{}, nil
+		}
+		if err := root.Decode(&doc); err != nil {
+			return nil, nil, err
+		}
+		doc = jsonValue(doc)
+		lines = map[string]int{}
+		yamlLines(&root, "", lines)
+	case FormatTOML:
+		table, tomlLines, err := parseTOML(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		doc, lines = table, tomlLines
+	default:
+		return nil, nil, fmt.Errorf("unknown config format: %s", format)
+	}
+
+	if doc == nil {
+		return map[string]interface{}{}, lines, nil
+	}
+	object, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("expected an object of settings, found %T", doc)
+	}
+	return object, lines, nil
+}
+
+// jsonLines returns the lines of the settings of a JSON document. Syntax
+// errors end the walk early; json.Unmarshal reports them.
+func jsonLines(data []byte) map[string]int {
+	lines := map[string]int{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var walk func(key string, line int) error
+	walk = func(key string, line int) error {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'):
+			for dec.More() {
+				name, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				// The offset is just past the name
+				at := 1 + bytes.Count(data[:dec.InputOffset()], []byte("\n"))
+				if err := walk(joinKey(key, fmt.Sprint(name)), at); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+			return err
+		case json.Delim('['):
+			for dec.More() {
+				var element json.RawMessage
+				if err := dec.Decode(&element); err != nil {
+					return err
+				}
+			}
+			if _, err := dec.Token(); err != nil {
+				return err
+			}
+		}
+		if key != "" {
+			lines[key] = line
+		}
+		return nil
+	}
+	_ = walk("", 0)
+	return lines
+}
+
+// yamlLines records the lines of the settings under a YAML node
+func yamlLines(node *yaml.Node, key string, lines map[string]int) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			yamlLines(child, key, lines)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			name, value := node.Content[i], node.Content[i+1]
+			field := joinKey(key, name.Value)
+			if value.Kind == yaml.MappingNode {
+				yamlLines(value, field, lines)
+			} else {
+				lines[field] = name.Line
+			}
+		}
+	}
+}
+
+// joinKey appends a name to a dotted key
+func joinKey(key, name string) string {
+	if key == "" {
+		return name
+	}
+	return key + "." + name
+}
+
+// jsonValue converts a decoded YAML value for encoding as JSON, turning
+// the keys of maps into strings
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = jsonValue(value)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonValue(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = jsonValue(value)
+		}
+		return v
+	}
+	return v
+}
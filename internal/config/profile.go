@@ -0,0 +1,230 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"flow-control/internal/types"
+)
+
+const (
+	// profilesKey holds the profiles of a config file, by name
+	profilesKey = "profiles"
+	// extendsKey names the profile that a profile inherits from
+	extendsKey = "extends"
+	// baseName is the name of the base config file of a config directory
+	baseName = "base"
+)
+
+// profileExtensions lists the extensions of config files in the order they
+// are looked for in a config directory
+var profileExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+// layer is the settings of one config file or profile, applied on top of
+// the layers before it
+type layer struct {
+	path  string
+	doc   map[string]interface{}
+	lines map[string]int
+}
+
+// readLayer reads the config file at path
+func readLayer(path string, log types.Logger) (layer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Error("Failed to read config file", err, types.Fields{
+			"function": "Load",
+			"path":     path,
+		})
+		return layer{}, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	format := DetectFormat(path, data)
+	doc, lines, err := parseDocument(format, data)
+	if err != nil {
+		log.Error("Failed to parse config file", err, types.Fields{
+			"function": "Load",
+			"path":     path,
+			"format":   format,
+		})
+		return layer{}, fmt.Errorf("failed to parse %s config file %s: %w", format, path, err)
+	}
+	return layer{path: path, doc: doc, lines: lines}, nil
+}
+
+// readLayers reads the layers of the configuration at path with profile
+// applied. Path is a config file, whose profiles section holds the
+// profiles, or a directory holding a base file, such as base.yaml, and a
+// file per profile, such as staging.yaml. Without a profile only the base
+// settings are read. Profiles inherit from the base settings, or from the
+// profile named by their extends setting.
+func readLayers(path, profile string, log types.Logger) ([]layer, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Error("Failed to read config file", err, types.Fields{
+			"function": "Load",
+			"path":     path,
+		})
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	basePath, dir := path, ""
+	if info.IsDir() {
+		dir = path
+		found, ok := profileFile(dir, baseName)
+		if !ok {
+			return nil, fmt.Errorf("no %s config file in %s", baseName, dir)
+		}
+		basePath = found
+	}
+	base, err := readLayer(basePath, log)
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := base.splitProfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	layers := []layer{base}
+	seen := map[string]bool{}
+	var chain []layer
+	for name := profile; name != ""; {
+		if seen[name] {
+			return nil, fmt.Errorf("config profile %s extends itself", name)
+		}
+		seen[name] = true
+
+		l, ok := profiles[name]
+		if dir != "" {
+			if file, found := profileFile(dir, name); found && name != baseName {
+				if l, err = readLayer(file, log); err != nil {
+					return nil, err
+				}
+				ok = true
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown config profile %q, expected one of: %s",
+				name, strings.Join(profileNames(dir, profiles), ", "))
+		}
+		parent, err := l.extends()
+		if err != nil {
+			return nil, fmt.Errorf("config profile %s: %w", name, err)
+		}
+		chain = append([]layer{l}, chain...)
+		name = parent
+	}
+	return append(layers, chain...), nil
+}
+
+// splitProfiles removes the profiles section from l, returning its
+// profiles as layers of the same file
+func (l *layer) splitProfiles() (map[string]layer, error) {
+	section, ok := l.doc[profilesKey]
+	delete(l.doc, profilesKey)
+	prefix := profilesKey + "."
+	lines := map[string]map[string]int{}
+	for key, line := range l.lines {
+		if rest, found := strings.CutPrefix(key, prefix); found {
+			delete(l.lines, key)
+			if name, field, found := strings.Cut(rest, "."); found {
+				if lines[name] == nil {
+					lines[name] = map[string]int{}
+				}
+				lines[name][field] = line
+			}
+		}
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	docs, ok := section.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: profiles must map names to settings", l.path)
+	}
+	profiles := make(map[string]layer, len(docs))
+	for name, doc := range docs {
+		settings, ok := doc.(map[string]interface{})
+		if doc == nil {
+			settings, ok = map[string]interface{}{}, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s: profile %s must hold settings", l.path, name)
+		}
+		profiles[name] = layer{path: l.path, doc: settings, lines: lines[name]}
+	}
+	return profiles, nil
+}
+
+// extends removes the extends setting from l, returning the profile it
+// names
+func (l *layer) extends() (string, error) {
+	value, ok := l.doc[extendsKey]
+	if !ok {
+		return "", nil
+	}
+	delete(l.doc, extendsKey)
+	delete(l.lines, extendsKey)
+	name, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must name a profile", extendsKey)
+	}
+	return name, nil
+}
+
+// profileFile returns the config file of the profile called name in dir
+func profileFile(dir, name string) (string, bool) {
+	for _, ext := range profileExtensions {
+		path := filepath.Join(dir, name+ext)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// profileNames lists the profiles of a config file or directory
+func profileNames(dir string, profiles map[string]layer) []string {
+	names := map[string]bool{}
+	for name := range profiles {
+		names[name] = true
+	}
+	if dir != "" {
+		// Unreadable directories were reported when the base was read
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			ext := filepath.Ext(entry.Name())
+			name := strings.TrimSuffix(entry.Name(), ext)
+			if formatExtensions[strings.ToLower(ext)] != "" && name != baseName && !entry.IsDir() {
+				names[name] = true
+			}
+		}
+	}
+	if len(names) == 0 {
+		return []string{"(none defined)"}
+	}
+	return sortedKeys(names)
+}
+
+// mergeDocument copies the settings of src into dst, merging objects and
+// replacing other values. Objects are copied, so src is never modified by
+// later merges.
+func mergeDocument(dst, src map[string]interface{}) {
+	for key, value := range src {
+		from, ok := value.(map[string]interface{})
+		if !ok {
+			dst[key] = value
+			continue
+		}
+		into, ok := dst[key].(map[string]interface{})
+		if !ok {
+			into = map[string]interface{}{}
+			dst[key] = into
+		}
+		mergeDocument(into, from)
+	}
+}
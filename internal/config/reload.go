@@ -0,0 +1,312 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// TypeConfigReloaded is the type of the event published when a reload
+// changes the configuration
+const TypeConfigReloaded = "config.reloaded"
+
+// reloadable lists the settings that take effect without a restart, by
+// dotted path, with the function copying them between configurations
+var reloadable = []struct {
+	field string
+	apply func(dst, src *Config)
+}{
+	{"logging.level", func(dst, src *Config) { dst.Logging.Level = src.Logging.Level }},
+	{"logging.sampling", func(dst, src *Config) { dst.Logging.Sampling = maps.Clone(src.Logging.Sampling) }},
+	{"retention.batch_size", func(dst, src *Config) { dst.Retention.BatchSize = src.Retention.BatchSize }},
+	{"retention.max_age_days", func(dst, src *Config) { dst.Retention.MaxAgeDays = maps.Clone(src.Retention.MaxAgeDays) }},
+}
+
+// Change is a setting that differs between two configurations
+type Change struct {
+	// Field is the dotted path of the setting, such as logging.level
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+	// Applied is false for settings that only take effect after a restart
+	Applied bool `json:"applied"`
+}
+
+// Changed reports whether changes include field or a setting under it
+func Changed(changes []Change, field string) bool {
+	for _, change := range changes {
+		if within(change.Field, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff lists the settings that differ between old and new, in order of
+// their paths. Values of secret settings are redacted.
+func Diff(old, new *Config) []Change {
+	before, after := flatten(old), flatten(new)
+	fields := make(map[string]bool, len(before))
+	for field := range before {
+		fields[field] = true
+	}
+	for field := range after {
+		fields[field] = true
+	}
+
+	var changes []Change
+	for _, field := range sortedKeys(fields) {
+		if reflect.DeepEqual(before[field], after[field]) {
+			continue
+		}
+		change := Change{Field: field, Old: before[field], New: after[field], Applied: isReloadable(field)}
+		if isSecret(field) {
+			change.Old, change.New = redacted, redacted
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// flatten maps the dotted paths of the settings of c to their JSON values.
+// Unset values and empty maps are left out.
+func flatten(c *Config) map[string]interface{} {
+	settings := map[string]interface{}{}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return settings
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return settings
+	}
+
+	var walk func(key string, value interface{})
+	walk = func(key string, value interface{}) {
+		switch value := value.(type) {
+		case nil:
+		case map[string]interface{}:
+			for name, child := range value {
+				walk(joinKey(key, name), child)
+			}
+		default:
+			settings[key] = value
+		}
+	}
+	walk("", doc)
+	return settings
+}
+
+// isReloadable reports whether a setting takes effect without a restart
+func isReloadable(field string) bool {
+	for _, r := range reloadable {
+		if within(field, r.field) {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether field is parent or a setting under it
+func within(field, parent string) bool {
+	return field == parent || strings.HasPrefix(field, parent+".")
+}
+
+// Reloader reloads the configuration from its file, on request or when the
+// file changes. Reloads are validated before they are applied, and only the
+// reloadable settings, such as the log level and retention policies, change;
+// other settings are reported as needing a restart.
+type Reloader struct {
+	path      string
+	profile   string
+	overrides []Override
+	log       types.Logger
+
+	// reloading serializes reloads, including their handlers
+	reloading sync.Mutex
+
+	mu      sync.Mutex
+	current *Config
+	// loaded is the configuration last read, including the settings that
+	// wait for a restart, so that each change is reported once
+	loaded   *Config
+	handlers []func(*Config, []Change)
+	stamp    fileStamp
+}
+
+// fileStamp identifies a version of the config files: the latest time one
+// was modified and their total size
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewReloader creates a reloader for current, which was loaded from path
+// with overrides and its profile. The overrides are applied again on every
+// reload.
+func NewReloader(path string, current *Config, log types.Logger, overrides ...Override) *Reloader {
+	r := &Reloader{
+		path:      path,
+		profile:   current.profile,
+		overrides: overrides,
+		log:       log,
+		current:   current,
+		loaded:    current,
+	}
+	r.stamp, _ = statFiles(current.files)
+	return r
+}
+
+// Config returns the current configuration
+func (r *Reloader) Config() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// OnReload registers fn to be called with the new configuration and the
+// changes of every reload that changes a setting
+func (r *Reloader) OnReload(fn func(*Config, []Change)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers = append(r.handlers, fn)
+}
+
+// Reload loads the config file again and applies its reloadable settings.
+// An invalid file leaves the configuration unchanged and returns the
+// error. It returns the settings that changed since the file was last
+// read, including those that need a restart.
+func (r *Reloader) Reload() ([]Change, error) {
+	r.reloading.Lock()
+	defer r.reloading.Unlock()
+
+	r.mu.Lock()
+	files := r.loaded.files
+	r.mu.Unlock()
+	stamp, _ := statFiles(files)
+	loaded, err := LoadProfile(r.path, r.profile, r.log, r.overrides...)
+	if err != nil {
+		r.log.Error("Configuration reload rejected", err, types.Fields{
+			"function": "Reload",
+			"path":     r.path,
+		})
+		r.setStamp(stamp)
+		return nil, err
+	}
+
+	r.mu.Lock()
+	current, previous := r.current, r.loaded
+	r.mu.Unlock()
+	changes := Diff(previous, loaded)
+	next := *current
+	next.sources = maps.Clone(current.sources)
+	for _, change := range changes {
+		if !change.Applied {
+			r.log.Warn("Configuration change needs a restart", types.Fields{
+				"function": "Reload",
+				"setting":  change.Field,
+				"source":   loaded.Source(change.Field),
+			})
+			continue
+		}
+		next.SetSource(change.Field, loaded.Source(change.Field))
+	}
+	for _, setting := range reloadable {
+		setting.apply(&next, loaded)
+	}
+	if err := next.Validate(); err != nil {
+		r.log.Error("Configuration reload rejected", err, types.Fields{
+			"function": "Reload",
+			"path":     r.path,
+		})
+		r.setStamp(stamp)
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	r.log.Info("Configuration reloaded", types.Fields{
+		"function": "Reload",
+		"path":     r.path,
+		"changes":  len(changes),
+	})
+	r.mu.Lock()
+	r.stamp = stamp
+	r.loaded = loaded
+	if len(changes) > 0 {
+		r.current = &next
+	}
+	handlers := r.handlers
+	r.mu.Unlock()
+
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	for _, fn := range handlers {
+		fn(&next, changes)
+	}
+	return changes, nil
+}
+
+// setStamp records the version of the file that was last read
+func (r *Reloader) setStamp(stamp fileStamp) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stamp = stamp
+}
+
+// Watch reloads the configuration whenever the config file changes,
+// checking every interval until ctx is done. Without a config file there
+// is nothing to watch.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) error {
+	if r.path == "" {
+		return nil
+	}
+	if interval <= 0 {
+		return fmt.Errorf("watch interval must be positive: %s", interval)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			r.mu.Lock()
+			files := r.loaded.files
+			r.mu.Unlock()
+			stamp, err := statFiles(files)
+			r.mu.Lock()
+			changed := err == nil && (!stamp.modTime.Equal(r.stamp.modTime) || stamp.size != r.stamp.size)
+			r.mu.Unlock()
+			if changed {
+				// Errors are logged by Reload, and the next change of the
+				// file is tried again
+				_, _ = r.Reload()
+			}
+		}
+	}
+}
+
+// statFiles returns the stamp of the files at paths
+func statFiles(paths []string) (fileStamp, error) {
+	var stamp fileStamp
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fileStamp{}, err
+		}
+		if info.ModTime().After(stamp.modTime) {
+			stamp.modTime = info.ModTime()
+		}
+		stamp.size += info.Size()
+	}
+	return stamp, nil
+}
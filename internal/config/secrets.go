@@ -0,0 +1,96 @@
+package config
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"flow-control/internal/secrets"
+)
+
+// resolveTimeout bounds how long resolving the secrets of a configuration
+// may take
+const resolveTimeout = 30 * time.Second
+
+// sensitive lists the settings whose values may refer to secrets as
+// ${secret.NAME}, by dotted path, with the function resolving them
+var sensitive = []struct {
+	field   string
+	resolve func(c *Config, resolve func(string) (string, error)) error
+}{
+	{"server.admin_token", func(c *Config, resolve func(string) (string, error)) error {
+		return resolveString(&c.Server.AdminToken, resolve)
+	}},
+	{"server.api_keys", func(c *Config, resolve func(string) (string, error)) error {
+		keys := slices.Clone(c.Server.APIKeys)
+		for i := range keys {
+			if err := resolveString(&keys[i], resolve); err != nil {
+				return err
+			}
+		}
+		c.Server.APIKeys = keys
+		return nil
+	}},
+	{"database.dsn", func(c *Config, resolve func(string) (string, error)) error {
+		return resolveString(&c.Database.DSN, resolve)
+	}},
+	{"alerting.webhook_url", func(c *Config, resolve func(string) (string, error)) error {
+		return resolveString(&c.Alerting.WebhookURL, resolve)
+	}},
+	{"tracing.headers", func(c *Config, resolve func(string) (string, error)) error {
+		headers := maps.Clone(c.Tracing.Headers)
+		for name, value := range headers {
+			if err := resolveString(&value, resolve); err != nil {
+				return err
+			}
+			headers[name] = value
+		}
+		c.Tracing.Headers = headers
+		return nil
+	}},
+	{"runtime.lookups.databases", func(c *Config, resolve func(string) (string, error)) error {
+		databases := maps.Clone(c.Runtime.Lookups.Databases)
+		for name, database := range databases {
+			if err := resolveString(&database.DSN, resolve); err != nil {
+				return err
+			}
+			databases[name] = database
+		}
+		c.Runtime.Lookups.Databases = databases
+		return nil
+	}},
+}
+
+// resolveString resolves the secret references in *s, leaving it unchanged
+// if they cannot be resolved
+func resolveString(s *string, resolve func(string) (string, error)) error {
+	resolved, err := resolve(*s)
+	if err != nil {
+		return err
+	}
+	*s = resolved
+	return nil
+}
+
+// ResolveSecrets replaces the secret references in sensitive settings,
+// such as server.admin_token and database.dsn, with the values of the
+// secrets from p. Secrets that cannot be resolved are reported together
+// as a *ValidationError.
+func ResolveSecrets(p secrets.Provider) Override {
+	return func(c *Config) error {
+		ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+		defer cancel()
+		resolve := func(s string) (string, error) {
+			return secrets.Resolve(ctx, p, s)
+		}
+
+		v := &validator{config: c}
+		for _, setting := range sensitive {
+			if err := setting.resolve(c, resolve); err != nil {
+				v.add(setting.field, "%v", err)
+			}
+		}
+		return v.err()
+	}
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+)
+
+// parseTOML decodes a TOML document into maps, slices, strings, int64s,
+// float64s and bools, dates and times being time values. It also returns
+// the line of each value that is not a table, by dotted key.
+func parseTOML(data []byte) (map[string]interface{}, map[string]int, error) {
+	var doc map[string]interface{}
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		var decodeErr *toml.DecodeError
+		if errors.As(err, &decodeErr) {
+			line, _ := decodeErr.Position()
+			return nil, nil, fmt.Errorf("line %d: %s", line, strings.TrimPrefix(decodeErr.Error(), "toml: "))
+		}
+		return nil, nil, err
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	return doc, tomlLines(data), nil
+}
+
+// tomlLines returns the lines of the values of a TOML document that are
+// not tables, by dotted key. The keys of arrays of tables leave out the
+// index of the table.
+func tomlLines(data []byte) map[string]int {
+	lines := map[string]int{}
+	p := &unstable.Parser{}
+	p.Reset(data)
+	var table []string
+	for p.NextExpression() {
+		expr := p.Expression()
+		switch expr.Kind {
+		case unstable.Table, unstable.ArrayTable:
+			table, _ = tomlKey(p, expr.Key())
+		case unstable.KeyValue:
+			tomlKeyValueLines(p, table, expr, lines)
+		}
+	}
+	return lines
+}
+
+// tomlKeyValueLines records the line of a key/value pair of the table at
+// path, or of the values of an inline table
+func tomlKeyValueLines(p *unstable.Parser, path []string, kv *unstable.Node, lines map[string]int) {
+	keys, line := tomlKey(p, kv.Key())
+	key := append(append([]string{}, path...), keys...)
+	value := kv.Value()
+	if value.Kind != unstable.InlineTable {
+		lines[strings.Join(key, ".")] = line
+		return
+	}
+	fields := value.Children()
+	for fields.Next() {
+		tomlKeyValueLines(p, key, fields.Node(), lines)
+	}
+}
+
+// tomlKey returns the parts of a dotted key and the line it starts on
+func tomlKey(p *unstable.Parser, it unstable.Iterator) ([]string, int) {
+	var keys []string
+	line := 0
+	for it.Next() {
+		if line == 0 {
+			line = p.Shape(it.Node().Raw).Start.Line
+		}
+		keys = append(keys, string(it.Node().Data))
+	}
+	return keys, line
+}
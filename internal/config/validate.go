@@ -0,0 +1,496 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"flow-control/internal/compression"
+)
+
+// SourceDefault is the source of settings that keep their default value
+const SourceDefault = "default"
+
+// Problem is an invalid setting found while loading the configuration
+type Problem struct {
+	// Field is the dotted path of the setting, such as server.port
+	Field   string
+	Message string
+	// Source tells where the value came from: a file and line, an
+	// environment variable, a flag or the defaults
+	Source string
+}
+
+// String formats the problem as field: message (source)
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s (%s)", p.Field, p.Message, p.Source)
+}
+
+// ValidationError lists every problem of a configuration
+type ValidationError struct {
+	Problems []Problem
+}
+
+// Error lists the problems, one per line when there are several
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return e.Problems[0].String()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d problems:", len(e.Problems))
+	for _, p := range e.Problems {
+		b.WriteString("\n  " + p.String())
+	}
+	return b.String()
+}
+
+// SetSource records where the value of a setting, given by its dotted
+// path, came from, such as "flag -port". Overrides call it for the settings
+// they change so that problems point at them.
+func (c *Config) SetSource(field, source string) {
+	if c.sources == nil {
+		c.sources = map[string]string{}
+	}
+	c.sources[field] = source
+}
+
+// Source returns where the value of a setting came from, or SourceDefault
+func (c *Config) Source(field string) string {
+	if source, ok := c.sources[field]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// validator collects the problems of a configuration
+type validator struct {
+	config   *Config
+	problems []Problem
+}
+
+// add records a problem with field
+func (v *validator) add(field, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+		Source:  v.config.Source(field),
+	})
+}
+
+// quota records the problems of the project quota at field
+func (v *validator) quota(field string, quota ProjectQuota) {
+	if quota.MaxRuns < 0 {
+		v.add(field+".max_runs", "run quota cannot be negative: %d", quota.MaxRuns)
+	}
+	if quota.MaxMessagesPerSecond < 0 {
+		v.add(field+".max_messages_per_second", "message rate quota cannot be negative: %v", quota.MaxMessagesPerSecond)
+	}
+	if quota.MaxStorageMB < 0 {
+		v.add(field+".max_storage_mb", "storage quota cannot be negative: %d", quota.MaxStorageMB)
+	}
+}
+
+// err returns the problems as a *ValidationError, or nil if there are none
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: v.problems}
+}
+
+// Validate checks if the configuration is valid. It reports every problem
+// at once, as a *ValidationError.
+func (c *Config) Validate() error {
+	v := &validator{config: c}
+
+	// Validate server configuration
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		v.add("server.port", "invalid port number: %d", c.Server.Port)
+	}
+	for _, key := range c.Server.APIKeys {
+		if strings.TrimSpace(key) == "" {
+			v.add("server.api_keys", "api keys cannot be empty")
+			break
+		}
+	}
+	timeouts := map[string]int{
+		"server.timeouts.read_header_seconds": c.Server.Timeouts.ReadHeaderSeconds,
+		"server.timeouts.read_seconds":        c.Server.Timeouts.ReadSeconds,
+		"server.timeouts.write_seconds":       c.Server.Timeouts.WriteSeconds,
+		"server.timeouts.idle_seconds":        c.Server.Timeouts.IdleSeconds,
+	}
+	for _, field := range sortedKeys(timeouts) {
+		if timeouts[field] < 0 {
+			v.add(field, "timeout cannot be negative: %d", timeouts[field])
+		}
+	}
+	if c.Server.Timeouts.ShutdownSeconds < 1 {
+		v.add("server.timeouts.shutdown_seconds", "shutdown timeout must be at least 1 second: %d", c.Server.Timeouts.ShutdownSeconds)
+	}
+	if c.Server.Timeouts.DrainSeconds < 1 {
+		v.add("server.timeouts.drain_seconds", "drain timeout must be at least 1 second: %d", c.Server.Timeouts.DrainSeconds)
+	}
+
+	// Validate TLS configuration
+	tlsFiles := map[string]string{
+		"server.tls.cert_file": c.Server.TLS.CertFile,
+		"server.tls.key_file":  c.Server.TLS.KeyFile,
+	}
+	if c.Server.TLS.CertFile != "" || c.Server.TLS.KeyFile != "" {
+		for _, field := range sortedKeys(tlsFiles) {
+			if tlsFiles[field] == "" {
+				v.add(field, "tls needs both a cert file and a key file")
+			} else if _, err := os.Stat(tlsFiles[field]); err != nil {
+				v.add(field, "cannot read tls file: %v", err)
+			}
+		}
+	}
+	switch c.Server.TLS.MinVersion {
+	case "", "1.2", "1.3":
+	default:
+		v.add("server.tls.min_version", "invalid tls version: %s", c.Server.TLS.MinVersion)
+	}
+
+	// Validate database configuration
+	switch strings.ToLower(c.Database.Driver) {
+	case "", "sqlite":
+		if c.Database.Path == "" {
+			v.add("database.path", "database path cannot be empty")
+		} else if !strings.HasSuffix(c.Database.Path, ".db") {
+			v.add("database.path", "database path must end with .db")
+		}
+		if c.Database.DSN != "" {
+			v.add("database.dsn", "dsn is only used by the postgres driver; the sqlite driver uses the database path")
+		}
+	case "postgres":
+		if c.Database.DSN == "" {
+			v.add("database.dsn", "database dsn cannot be empty for postgres driver")
+		}
+	default:
+		v.add("database.driver", "invalid database driver: %s", c.Database.Driver)
+	}
+	validJournalModes := map[string]bool{
+		"":         true,
+		"delete":   true,
+		"truncate": true,
+		"persist":  true,
+		"memory":   true,
+		"wal":      true,
+		"off":      true,
+	}
+	if !validJournalModes[strings.ToLower(c.Database.JournalMode)] {
+		v.add("database.journal_mode", "invalid journal mode: %s", c.Database.JournalMode)
+	}
+	if c.Database.BusyTimeout < 0 {
+		v.add("database.busy_timeout_ms", "busy timeout cannot be negative: %d", c.Database.BusyTimeout)
+	}
+	if c.Database.SlowQueryMs < 0 {
+		v.add("database.slow_query_ms", "slow query threshold cannot be negative: %d", c.Database.SlowQueryMs)
+	}
+	if c.Database.MaxOpenConns < 0 {
+		v.add("database.max_open_conns", "connection pool sizes cannot be negative")
+	}
+	if c.Database.MaxIdleConns < 0 {
+		v.add("database.max_idle_conns", "connection pool sizes cannot be negative")
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		v.add("database.max_idle_conns", "max idle connections (%d) cannot exceed max open connections (%d)",
+			c.Database.MaxIdleConns, c.Database.MaxOpenConns)
+	}
+
+	// Validate backup configuration
+	if c.Backup.IntervalMinutes < 0 {
+		v.add("backup.interval_minutes", "backup interval cannot be negative: %d", c.Backup.IntervalMinutes)
+	}
+	if c.Backup.Retain < 0 {
+		v.add("backup.retain", "backup retention cannot be negative: %d", c.Backup.Retain)
+	}
+	if c.Backup.IntervalMinutes > 0 {
+		if c.Backup.Dir == "" {
+			v.add("backup.dir", "backup directory cannot be empty when scheduled backups are enabled")
+		}
+		if strings.EqualFold(c.Database.Driver, "postgres") {
+			v.add("backup.interval_minutes", "scheduled backups require the sqlite driver")
+		}
+	}
+
+	// Validate retention configuration
+	if c.Retention.IntervalMinutes < 0 {
+		v.add("retention.interval_minutes", "retention interval cannot be negative: %d", c.Retention.IntervalMinutes)
+	}
+	if c.Retention.BatchSize < 0 {
+		v.add("retention.batch_size", "retention batch size cannot be negative: %d", c.Retention.BatchSize)
+	}
+	for _, table := range sortedKeys(c.Retention.MaxAgeDays) {
+		if days := c.Retention.MaxAgeDays[table]; days < 0 {
+			v.add("retention.max_age_days."+table, "retention for %s cannot be negative: %d", table, days)
+		}
+	}
+
+	// Validate logging configuration
+	validLevels := map[string]bool{
+		"trace": true,
+		"debug": true,
+		"info":  true,
+		"warn":  true,
+		"error": true,
+	}
+	if !validLevels[strings.ToLower(c.Logging.Level)] {
+		v.add("logging.level", "invalid log level: %s", c.Logging.Level)
+	}
+
+	validFormats := map[string]bool{
+		"console": true,
+		"json":    true,
+		"auto":    true,
+	}
+	if !validFormats[strings.ToLower(c.Logging.Format)] {
+		v.add("logging.format", "invalid log format: %s", c.Logging.Format)
+	}
+	rotation := map[string]int{
+		"logging.rotation.max_size_mb":  c.Logging.Rotation.MaxSizeMB,
+		"logging.rotation.max_backups":  c.Logging.Rotation.MaxBackups,
+		"logging.rotation.max_age_days": c.Logging.Rotation.MaxAgeDays,
+	}
+	for _, field := range sortedKeys(rotation) {
+		if rotation[field] < 0 {
+			v.add(field, "log rotation cannot be negative: %d", rotation[field])
+		}
+	}
+	for _, component := range sortedKeys(c.Logging.Sampling) {
+		rule := c.Logging.Sampling[component]
+		field := "logging.sampling." + component
+		if rule.DebugEvery < 0 {
+			v.add(field+".debug_every", "log sampling for %s cannot be negative", component)
+		}
+		if rule.InfoEvery < 0 {
+			v.add(field+".info_every", "log sampling for %s cannot be negative", component)
+		}
+		if rule.MaxPerMinute < 0 {
+			v.add(field+".max_per_minute", "log sampling for %s cannot be negative", component)
+		}
+	}
+	if c.Logging.Loki.URL != "" {
+		for _, level := range c.Logging.Loki.Levels {
+			if !validLevels[strings.ToLower(level)] {
+				v.add("logging.loki.levels", "invalid loki log level: %s", level)
+			}
+		}
+		if c.Logging.Loki.BatchSize < 0 {
+			v.add("logging.loki.batch_size", "loki batch size cannot be negative")
+		}
+		if c.Logging.Loki.FlushIntervalMs < 0 {
+			v.add("logging.loki.flush_interval_ms", "loki flush interval cannot be negative")
+		}
+		if c.Logging.Loki.QueueSize < 0 {
+			v.add("logging.loki.queue_size", "loki queue size cannot be negative")
+		}
+	}
+
+	// Validate tracing configuration
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		v.add("tracing.sample_ratio", "trace sample ratio must be between 0 and 1: %g", c.Tracing.SampleRatio)
+	}
+	if c.Tracing.Enabled {
+		if c.Tracing.Endpoint == "" {
+			v.add("tracing.endpoint", "tracing endpoint cannot be empty when tracing is enabled")
+		}
+		if c.Tracing.ServiceName == "" {
+			v.add("tracing.service_name", "tracing service name cannot be empty when tracing is enabled")
+		}
+	}
+
+	// Validate events configuration
+	switch c.Events.Backend {
+	case "memory":
+	case "nats":
+		if c.Events.NATS.URL == "" {
+			v.add("events.nats.url", "nats url cannot be empty when the nats event backend is used")
+		}
+	case "redis":
+		if c.Events.Redis.URL == "" {
+			v.add("events.redis.url", "redis url cannot be empty when the redis event backend is used")
+		} else if u, err := url.Parse(c.Events.Redis.URL); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") {
+			v.add("events.redis.url", "invalid redis url: must be a redis:// or rediss:// url")
+		}
+	default:
+		v.add("events.backend", "invalid event backend: %s", c.Events.Backend)
+	}
+	if c.Events.BufferSize < 0 {
+		v.add("events.buffer_size", "event buffer size cannot be negative")
+	}
+
+	// Validate alerting configuration
+	if c.Alerting.IntervalSeconds < 0 {
+		v.add("alerting.interval_seconds", "alerting interval cannot be negative")
+	}
+
+	// Validate cluster configuration
+	if c.Cluster.Enabled && c.Cluster.LeaseSeconds < 3 {
+		v.add("cluster.lease_seconds", "cluster lease must last at least 3 seconds: %d", c.Cluster.LeaseSeconds)
+	}
+
+	// Validate runtime configuration
+	if c.Runtime.Limits.DryRunMessages < 0 {
+		v.add("runtime.limits.dry_run_messages", "dry run message limit cannot be negative: %d", c.Runtime.Limits.DryRunMessages)
+	}
+	if c.Runtime.Limits.DryRunTimeoutMs < 0 {
+		v.add("runtime.limits.dry_run_timeout_ms", "dry run timeout cannot be negative: %d", c.Runtime.Limits.DryRunTimeoutMs)
+	}
+	if c.Runtime.Limits.MaxCallDepth < 0 {
+		v.add("runtime.limits.max_call_depth", "call depth limit cannot be negative: %d", c.Runtime.Limits.MaxCallDepth)
+	}
+	if c.Runtime.Limits.MaxPayloadBytes < 0 {
+		v.add("runtime.limits.max_payload_bytes", "payload size limit cannot be negative: %d", c.Runtime.Limits.MaxPayloadBytes)
+	}
+	if c.Runtime.Faults.Enabled && (c.profile == "prod" || c.profile == "production") {
+		v.add("runtime.faults.enabled", "fault injection cannot be enabled in the %s profile", c.profile)
+	}
+	for _, id := range sortedKeys(c.Runtime.Faults.Flows) {
+		faults := c.Runtime.Faults.Flows[id]
+		field := "runtime.faults.flows." + id
+		rates := map[string]float64{
+			field + ".error_rate":   faults.ErrorRate,
+			field + ".drop_rate":    faults.DropRate,
+			field + ".corrupt_rate": faults.CorruptRate,
+			field + ".latency_rate": faults.LatencyRate,
+		}
+		for _, name := range sortedKeys(rates) {
+			if rates[name] < 0 || rates[name] > 1 {
+				v.add(name, "fault rate must be between 0 and 1: %v", rates[name])
+			}
+		}
+		if faults.LatencyMs < 0 {
+			v.add(field+".latency_ms", "fault latency cannot be negative: %d", faults.LatencyMs)
+		}
+	}
+
+	if err := compression.Validate(c.Runtime.Compression.Default); err != nil {
+		v.add("runtime.compression.default", "%v", err)
+	}
+	for _, id := range sortedKeys(c.Runtime.Compression.Flows) {
+		if err := compression.Validate(c.Runtime.Compression.Flows[id]); err != nil {
+			v.add("runtime.compression.flows."+id, "%v", err)
+		}
+	}
+
+	v.quota("runtime.quotas.default", c.Runtime.Quotas.Default)
+	for _, project := range sortedKeys(c.Runtime.Quotas.Projects) {
+		field := "runtime.quotas.projects." + project
+		if project == DefaultProject {
+			v.add(field, "project %s is reserved for flows without a project; set runtime.quotas.default instead", project)
+		}
+		v.quota(field, c.Runtime.Quotas.Projects[project])
+	}
+
+	for _, raw := range c.Runtime.Lookups.URLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.add("runtime.lookups.urls", "lookup URL must be an http or https URL: %s", raw)
+		}
+	}
+	for _, name := range sortedKeys(c.Runtime.Lookups.Databases) {
+		database := c.Runtime.Lookups.Databases[name]
+		field := "runtime.lookups.databases." + name
+		if database.Driver != "postgres" && database.Driver != "sqlite" {
+			v.add(field+".driver", "lookup database driver must be postgres or sqlite: %q", database.Driver)
+		}
+		if database.DSN == "" {
+			v.add(field+".dsn", "lookup database dsn cannot be empty")
+		}
+	}
+
+	// Validate reload configuration
+	if c.Reload.WatchSeconds < 0 {
+		v.add("reload.watch_seconds", "watch interval cannot be negative: %d", c.Reload.WatchSeconds)
+	}
+
+	// Validate secrets configuration
+	switch c.Secrets.Provider {
+	case "env":
+	case "file":
+		if c.Secrets.Dir == "" {
+			v.add("secrets.dir", "secrets directory cannot be empty when the file provider is used")
+		}
+	case "vault":
+		if c.Secrets.Vault.Address == "" {
+			v.add("secrets.vault.address", "vault address cannot be empty when the vault provider is used")
+		}
+		if c.Secrets.Vault.Token == "" {
+			v.add("secrets.vault.token", "vault token cannot be empty when the vault provider is used")
+		}
+	default:
+		v.add("secrets.provider", "invalid secrets provider: %s", c.Secrets.Provider)
+	}
+	if c.Secrets.CacheSeconds < 0 {
+		v.add("secrets.cache_seconds", "secret cache duration cannot be negative: %d", c.Secrets.CacheSeconds)
+	}
+
+	return v.err()
+}
+
+// sortedKeys returns the keys of m in order, so that problems are reported
+// in the same order every time
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// resolveField matches the dotted key of a config file setting against the
+// fields of Config, ignoring case as encoding/json does. It returns the key
+// with the json names of the fields and, if part of the key names no field,
+// the dotted prefix up to that part.
+func resolveField(key string) (field, unknown string) {
+	names := strings.Split(key, ".")
+	t := reflect.TypeOf(Config{})
+	for i, name := range names {
+		for t.Kind() == reflect.Slice || t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			field, ok := jsonField(t, name)
+			if !ok {
+				return key, strings.Join(names[:i+1], ".")
+			}
+			names[i], t = field.name, field.typ
+		case reflect.Map:
+			t = t.Elem()
+		default:
+			return key, strings.Join(names[:i+1], ".")
+		}
+	}
+	return strings.Join(names, "."), ""
+}
+
+// structField is a field of a struct type as encoding/json sees it
+type structField struct {
+	name string
+	typ  reflect.Type
+}
+
+// jsonField returns the field of a struct type that encoding/json decodes
+// name into
+func jsonField(t reflect.Type, name string) (structField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		if strings.EqualFold(tag, name) {
+			return structField{name: tag, typ: f.Type}, true
+		}
+	}
+	return structField{}, false
+}
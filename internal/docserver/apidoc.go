@@ -0,0 +1,251 @@
+package docserver
+
+import (
+	"go/ast"
+	"go/doc"
+	"go/token"
+	"path"
+	"strings"
+)
+
+// PackageJSON is the documentation of a Go package as served by the JSON
+// API. Declarations are plain Go source and doc comments plain text, for
+// tools and terminals rather than browsers.
+type PackageJSON struct {
+	Name       string `json:"name"`
+	ImportPath string `json:"import_path"`
+	// Path is the package's directory relative to internal/
+	Path     string      `json:"path"`
+	URL      string      `json:"url"`
+	Synopsis string      `json:"synopsis"`
+	Doc      string      `json:"doc"`
+	Consts   []ValueJSON `json:"consts"`
+	Vars     []ValueJSON `json:"vars"`
+	Funcs    []FuncJSON  `json:"funcs"`
+	Types    []TypeJSON  `json:"types"`
+	// Subpackages are the paths of the packages in subdirectories
+	Subpackages []string `json:"subpackages"`
+}
+
+// ValueJSON documents a const or var declaration, which may declare
+// several names
+type ValueJSON struct {
+	Names []string `json:"names"`
+	Decl  string   `json:"decl"`
+	Doc   string   `json:"doc"`
+}
+
+// FuncJSON documents a function or method
+type FuncJSON struct {
+	Name string `json:"name"`
+	// Recv is the receiver type of methods, such as *Type
+	Recv string `json:"recv,omitempty"`
+	Decl string `json:"decl"`
+	Doc  string `json:"doc"`
+	URL  string `json:"url"`
+}
+
+// TypeJSON documents a type with its associated values, constructors and
+// methods
+type TypeJSON struct {
+	Name    string      `json:"name"`
+	Decl    string      `json:"decl"`
+	Doc     string      `json:"doc"`
+	URL     string      `json:"url"`
+	Consts  []ValueJSON `json:"consts"`
+	Vars    []ValueJSON `json:"vars"`
+	Funcs   []FuncJSON  `json:"funcs"`
+	Methods []FuncJSON  `json:"methods"`
+}
+
+// SymbolJSON is the documentation of one name of a package. Kind tells
+// which of Type, Func and Value is set.
+type SymbolJSON struct {
+	Kind       string     `json:"kind"`
+	ImportPath string     `json:"import_path"`
+	Type       *TypeJSON  `json:"type,omitempty"`
+	Func       *FuncJSON  `json:"func,omitempty"`
+	Value      *ValueJSON `json:"value,omitempty"`
+}
+
+// PackageEntryJSON is an entry of the JSON package list
+type PackageEntryJSON struct {
+	Path       string `json:"path"`
+	ImportPath string `json:"import_path"`
+	Synopsis   string `json:"synopsis"`
+	URL        string `json:"url"`
+}
+
+// loadPackageJSON extracts the documentation of the exported API of the
+// package in dir, whose path relative to internal/ is rel. It returns nil
+// if dir holds no Go files.
+func loadPackageJSON(dir, rel string) (*PackageJSON, error) {
+	importPath := modulePath + "/internal/" + rel
+	fset := token.NewFileSet()
+	pkg, _, err := readPackage(fset, dir, importPath)
+	if err != nil || pkg == nil {
+		return nil, err
+	}
+
+	r := &docRenderer{pkg: pkg, fset: fset}
+	d := &PackageJSON{
+		Name:        pkg.Name,
+		ImportPath:  importPath,
+		Path:        rel,
+		URL:         docURL(importPath, ""),
+		Synopsis:    pkg.Synopsis(pkg.Doc),
+		Doc:         r.text(pkg.Doc),
+		Consts:      r.valuesJSON(pkg.Consts),
+		Vars:        r.valuesJSON(pkg.Vars),
+		Funcs:       r.funcsJSON(pkg.Funcs, ""),
+		Types:       make([]TypeJSON, 0, len(pkg.Types)),
+		Subpackages: []string{},
+	}
+	for _, t := range pkg.Types {
+		d.Types = append(d.Types, TypeJSON{
+			Name:    t.Name,
+			Decl:    r.declJSON(t.Decl),
+			Doc:     r.text(t.Doc),
+			URL:     docURL(importPath, t.Name),
+			Consts:  r.valuesJSON(t.Consts),
+			Vars:    r.valuesJSON(t.Vars),
+			Funcs:   r.funcsJSON(t.Funcs, ""),
+			Methods: r.funcsJSON(t.Methods, t.Name),
+		})
+	}
+	return d, nil
+}
+
+// Symbol returns the documentation of name, which is a const, var, func or
+// type of the package, or a method written as Type.Method. It returns nil
+// if the package has no such name.
+func (p *PackageJSON) Symbol(name string) *SymbolJSON {
+	symbol := &SymbolJSON{ImportPath: p.ImportPath}
+	if typeName, method, ok := strings.Cut(name, "."); ok {
+		for _, t := range p.Types {
+			if t.Name != typeName {
+				continue
+			}
+			if symbol.Func = findFunc(t.Methods, method); symbol.Func != nil {
+				symbol.Kind = KindMethod
+				return symbol
+			}
+		}
+		return nil
+	}
+
+	if symbol.Func = findFunc(p.Funcs, name); symbol.Func != nil {
+		symbol.Kind = KindFunc
+		return symbol
+	}
+	if symbol.Value = findValue(p.Consts, name); symbol.Value != nil {
+		symbol.Kind = KindConst
+		return symbol
+	}
+	if symbol.Value = findValue(p.Vars, name); symbol.Value != nil {
+		symbol.Kind = KindVar
+		return symbol
+	}
+	// Constructors and typed values are grouped with their type
+	for i := range p.Types {
+		t := &p.Types[i]
+		switch {
+		case t.Name == name:
+			symbol.Kind, symbol.Type = KindType, t
+		case findFunc(t.Funcs, name) != nil:
+			symbol.Kind, symbol.Func = KindFunc, findFunc(t.Funcs, name)
+		case findValue(t.Consts, name) != nil:
+			symbol.Kind, symbol.Value = KindConst, findValue(t.Consts, name)
+		case findValue(t.Vars, name) != nil:
+			symbol.Kind, symbol.Value = KindVar, findValue(t.Vars, name)
+		default:
+			continue
+		}
+		return symbol
+	}
+	return nil
+}
+
+// findFunc returns the function of funcs called name, or nil
+func findFunc(funcs []FuncJSON, name string) *FuncJSON {
+	for i := range funcs {
+		if funcs[i].Name == name {
+			return &funcs[i]
+		}
+	}
+	return nil
+}
+
+// findValue returns the declaration of values declaring name, or nil
+func findValue(values []ValueJSON, name string) *ValueJSON {
+	for i := range values {
+		if contains(values[i].Names, name) {
+			return &values[i]
+		}
+	}
+	return nil
+}
+
+// packageEntries flattens a package tree into the JSON package list
+func packageEntries(packages []PackageInfo) []PackageEntryJSON {
+	entries := []PackageEntryJSON{}
+	for _, p := range packages {
+		importPath := path.Join(modulePath, "internal", p.Path)
+		entries = append(entries, PackageEntryJSON{
+			Path:       p.Path,
+			ImportPath: importPath,
+			Synopsis:   p.Description,
+			URL:        docURL(importPath, ""),
+		})
+		entries = append(entries, packageEntries(p.Children)...)
+	}
+	return entries
+}
+
+// text renders a doc comment as plain text wrapped at 80 columns
+func (r *docRenderer) text(comment string) string {
+	if comment == "" {
+		return ""
+	}
+	return string(r.pkg.Printer().Text(r.pkg.Parser().Parse(comment)))
+}
+
+// declJSON prints a declaration, or the error that stopped it
+func (r *docRenderer) declJSON(node ast.Decl) string {
+	src, err := r.declText(node)
+	if err != nil {
+		return err.Error()
+	}
+	return src
+}
+
+// valuesJSON documents const or var declarations
+func (r *docRenderer) valuesJSON(values []*doc.Value) []ValueJSON {
+	docs := make([]ValueJSON, 0, len(values))
+	for _, v := range values {
+		docs = append(docs, ValueJSON{Names: v.Names, Decl: r.declJSON(v.Decl), Doc: r.text(v.Doc)})
+	}
+	return docs
+}
+
+// funcsJSON documents functions, or the methods of recv
+func (r *docRenderer) funcsJSON(funcs []*doc.Func, recv string) []FuncJSON {
+	docs := make([]FuncJSON, 0, len(funcs))
+	for _, f := range funcs {
+		id := f.Name
+		if recv != "" {
+			id = recv + "." + f.Name
+		}
+		// Only the signature is shown
+		decl := *f.Decl
+		decl.Body = nil
+		docs = append(docs, FuncJSON{
+			Name: f.Name,
+			Recv: f.Recv,
+			Decl: r.declJSON(&decl),
+			Doc:  r.text(f.Doc),
+			URL:  docURL(r.pkg.ImportPath, id),
+		})
+	}
+	return docs
+}
@@ -2,27 +2,35 @@
 //
 // The docserver package provides a web-based documentation system that includes:
 //
-// - Package documentation with source code viewing
+// - Package documentation generated from doc comments, like pkg.go.dev
+// - Package documentation as JSON for tools and scripts
 // - API documentation via Swagger UI
-// - Flow language guide and examples
+// - Flow language reference generated from the parser's grammar
+// - Markdown guides, tutorials and decision records from the docs directory
+// - A playground where guide examples are edited, validated and dry-run
 // - Search functionality
 // - Hot reloading during development
 //
 // The documentation server uses HTML templates and Tailwind CSS for styling,
-// providing a modern and responsive user interface. It integrates with the
+// providing a modern and responsive user interface. The templates are
+// embedded in the binary; [WithTemplateDir] loads them from a directory
+// instead while they are being worked on. It integrates with the
 // main server to provide a seamless documentation experience.
 //
 // Example usage:
 //
 //	// Create documentation server
-//	docServer := docserver.New(logger)
+//	docServer, err := docserver.New(logger)
+//	if err != nil {
+//		return err
+//	}
 //
 //	// Mount documentation routes
 //	router.Mount("/docs", docServer.Routes())
 //
 // The documentation server supports:
 //
-// - Package listing and documentation
+// - Package listing and documentation with linked cross-references
 // - Source code viewing with syntax highlighting
 // - API documentation with interactive examples
 // - Full-text search across all documentation
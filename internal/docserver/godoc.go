@@ -0,0 +1,415 @@
+package docserver
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/doc"
+	"go/doc/comment"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// modulePath is the import path of the Flow Control module. Packages below
+// its internal directory are documented by the server; other packages link
+// to pkg.go.dev.
+const modulePath = "flow-control"
+
+// PackageDoc is the documentation of a Go package, as shown on its page
+type PackageDoc struct {
+	Name       string
+	ImportPath string
+	Synopsis   string
+	Doc        template.HTML
+	Consts     []ValueDoc
+	Vars       []ValueDoc
+	Funcs      []FuncDoc
+	Types      []TypeDoc
+}
+
+// ValueDoc documents a const or var declaration, which may declare several
+// names
+type ValueDoc struct {
+	Names []string
+	Decl  template.HTML
+	Doc   template.HTML
+}
+
+// FuncDoc documents a function or method
+type FuncDoc struct {
+	// ID is the anchor of the function: its name, or Type.Method for methods
+	ID   string
+	Name string
+	// Recv is the receiver type of methods, such as *Type
+	Recv string
+	Decl template.HTML
+	Doc  template.HTML
+}
+
+// TypeDoc documents a type with its associated values, constructors and
+// methods
+type TypeDoc struct {
+	Name    string
+	Decl    template.HTML
+	Doc     template.HTML
+	Consts  []ValueDoc
+	Vars    []ValueDoc
+	Funcs   []FuncDoc
+	Methods []FuncDoc
+}
+
+// loadPackageDoc parses the Go files of the package in dir, skipping tests
+// and files excluded by build constraints, and extracts the documentation of
+// its exported API. It returns nil if dir holds no Go files. Files that do
+// not parse are skipped.
+func loadPackageDoc(dir, importPath string) (*PackageDoc, error) {
+	fset := token.NewFileSet()
+	pkg, files, err := readPackage(fset, dir, importPath)
+	if err != nil || pkg == nil {
+		return nil, err
+	}
+
+	r := &docRenderer{
+		pkg:      pkg,
+		fset:     fset,
+		imports:  importNames(files),
+		exported: map[string]bool{},
+	}
+	r.export(pkg.Consts, pkg.Vars, pkg.Funcs)
+	for _, t := range pkg.Types {
+		r.exported[t.Name] = true
+		r.export(t.Consts, t.Vars, t.Funcs)
+	}
+
+	d := &PackageDoc{
+		Name:       pkg.Name,
+		ImportPath: importPath,
+		Synopsis:   pkg.Synopsis(pkg.Doc),
+		Doc:        r.comment(pkg.Doc),
+		Consts:     r.values(pkg.Consts),
+		Vars:       r.values(pkg.Vars),
+		Funcs:      r.funcs(pkg.Funcs, ""),
+	}
+	for _, t := range pkg.Types {
+		d.Types = append(d.Types, TypeDoc{
+			Name:    t.Name,
+			Decl:    r.decl(t.Decl),
+			Doc:     r.comment(t.Doc),
+			Consts:  r.values(t.Consts),
+			Vars:    r.values(t.Vars),
+			Funcs:   r.funcs(t.Funcs, ""),
+			Methods: r.funcs(t.Methods, t.Name),
+		})
+	}
+	return d, nil
+}
+
+// readPackage parses the package in dir and extracts its documentation,
+// returning the parsed files along with it. It returns a nil package if dir
+// holds no Go files.
+func readPackage(fset *token.FileSet, dir, importPath string) (*doc.Package, []*ast.File, error) {
+	files, err := parsePackage(fset, dir, parser.ParseComments)
+	if err != nil || len(files) == 0 {
+		return nil, nil, err
+	}
+	pkg, err := doc.NewFromFiles(fset, files, importPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read package documentation: %w", err)
+	}
+	return pkg, files, nil
+}
+
+// packageSynopsis returns the first sentence of the package comment of the
+// package in dir, or an empty string if it has none
+func packageSynopsis(dir string) string {
+	fset := token.NewFileSet()
+	files, err := parsePackage(fset, dir, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return ""
+	}
+	var p doc.Package
+	for _, f := range files {
+		if f.Doc != nil {
+			return p.Synopsis(f.Doc.Text())
+		}
+	}
+	return ""
+}
+
+// parsePackage parses the non-test Go files in dir that match the build
+// context, skipping files with syntax errors
+func parsePackage(fset *token.FileSet, dir string, mode parser.Mode) ([]*ast.File, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package directory: %w", err)
+	}
+	var files []*ast.File
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if ok, err := build.Default.MatchFile(dir, name); err != nil || !ok {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, mode)
+		if err != nil {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// majorVersion matches the major version suffix of module paths
+var majorVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+// importNames maps the names files refer to imported packages by to their
+// import paths. Packages imported without a name are assumed to be named
+// after the last element of their path, ignoring major version suffixes.
+func importNames(files []*ast.File) map[string]string {
+	names := map[string]string{}
+	for _, f := range files {
+		for _, imp := range f.Imports {
+			importPath := strings.Trim(imp.Path.Value, `"`)
+			var name string
+			if imp.Name != nil {
+				name = imp.Name.Name
+			} else {
+				elems := strings.Split(importPath, "/")
+				name = elems[len(elems)-1]
+				if majorVersion.MatchString(name) && len(elems) > 1 {
+					name = elems[len(elems)-2]
+				}
+				name = strings.TrimPrefix(name, "go-")
+				if i := strings.Index(name, ".v"); i > 0 {
+					name = name[:i]
+				}
+			}
+			if name != "_" && name != "." {
+				names[name] = importPath
+			}
+		}
+	}
+	return names
+}
+
+// docURL returns the URL documenting name in the package with importPath,
+// or the package itself if name is empty. Names in the current package,
+// whose import path is empty, link to anchors on the same page.
+func docURL(importPath, name string) string {
+	anchor := ""
+	if name != "" {
+		anchor = "#" + name
+	}
+	switch {
+	case importPath == "":
+		return anchor
+	case strings.HasPrefix(importPath, modulePath+"/internal/"):
+		return "/docs/pkg/" + strings.TrimPrefix(importPath, modulePath+"/internal/") + anchor
+	}
+	return "https://pkg.go.dev/" + importPath + anchor
+}
+
+// docRenderer renders the comments and declarations of a package as HTML
+type docRenderer struct {
+	pkg  *doc.Package
+	fset *token.FileSet
+	// imports maps package names to import paths
+	imports map[string]string
+	// exported holds the names of the package's exported types and funcs
+	exported map[string]bool
+}
+
+// export records the names of documented values and functions
+func (r *docRenderer) export(consts, vars []*doc.Value, funcs []*doc.Func) {
+	for _, values := range [][]*doc.Value{consts, vars} {
+		for _, v := range values {
+			for _, name := range v.Names {
+				r.exported[name] = true
+			}
+		}
+	}
+	for _, f := range funcs {
+		r.exported[f.Name] = true
+	}
+}
+
+// comment renders a doc comment, linking doc links to their documentation
+func (r *docRenderer) comment(text string) template.HTML {
+	if text == "" {
+		return ""
+	}
+	p := r.pkg.Printer()
+	p.HeadingLevel = 4
+	p.DocLinkURL = func(link *comment.DocLink) string {
+		name := link.Name
+		if link.Recv != "" {
+			name = link.Recv + "." + name
+		}
+		if link.ImportPath == r.pkg.ImportPath {
+			return docURL("", name)
+		}
+		return docURL(link.ImportPath, name)
+	}
+	return template.HTML(p.HTML(r.pkg.Parser().Parse(text)))
+}
+
+// values documents const or var declarations
+func (r *docRenderer) values(values []*doc.Value) []ValueDoc {
+	docs := make([]ValueDoc, 0, len(values))
+	for _, v := range values {
+		docs = append(docs, ValueDoc{Names: v.Names, Decl: r.decl(v.Decl), Doc: r.comment(v.Doc)})
+	}
+	return docs
+}
+
+// funcs documents functions, or the methods of recv
+func (r *docRenderer) funcs(funcs []*doc.Func, recv string) []FuncDoc {
+	docs := make([]FuncDoc, 0, len(funcs))
+	for _, f := range funcs {
+		id := f.Name
+		if recv != "" {
+			id = recv + "." + f.Name
+		}
+		// Only the signature is shown
+		decl := *f.Decl
+		decl.Body = nil
+		docs = append(docs, FuncDoc{ID: id, Name: f.Name, Recv: f.Recv, Decl: r.decl(&decl), Doc: r.comment(f.Doc)})
+	}
+	return docs
+}
+
+// decl renders a declaration without its doc comment. Identifiers referring
+// to exported names of the package or to imported packages link to their
+// documentation.
+func (r *docRenderer) decl(node ast.Decl) template.HTML {
+	src, err := r.declText(node)
+	if err != nil {
+		return template.HTML(template.HTMLEscapeString(err.Error()))
+	}
+	return template.HTML(linkSource(src, r.links(src)))
+}
+
+// declText prints a declaration without its doc comment
+func (r *docRenderer) declText(node ast.Decl) (string, error) {
+	switch d := node.(type) {
+	case *ast.GenDecl:
+		copied := *d
+		copied.Doc = nil
+		node = &copied
+	case *ast.FuncDecl:
+		copied := *d
+		copied.Doc = nil
+		node = &copied
+	}
+
+	var buf bytes.Buffer
+	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 4}
+	if err := cfg.Fprint(&buf, r.fset, node); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// link is a span of declaration source linking to url
+type link struct {
+	start, end int
+	url        string
+}
+
+// links finds the identifiers of a printed declaration that refer to
+// documented names. The declaration is parsed again on its own so that the
+// offsets match the printed source.
+func (r *docRenderer) links(src string) []link {
+	const prefix = "package p\n"
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", prefix+src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+	offset := func(pos token.Pos) int {
+		return fset.Position(pos).Offset - len(prefix)
+	}
+
+	var links []link
+	var visit func(n ast.Node)
+	visit = func(n ast.Node) {
+		if n == nil {
+			return
+		}
+		ast.Inspect(n, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.SelectorExpr:
+				if x, ok := n.X.(*ast.Ident); ok {
+					if importPath, ok := r.imports[x.Name]; ok {
+						links = append(links, link{offset(n.Pos()), offset(n.End()), docURL(importPath, n.Sel.Name)})
+						return false
+					}
+				}
+			case *ast.Ident:
+				if r.exported[n.Name] {
+					links = append(links, link{offset(n.Pos()), offset(n.End()), docURL("", n.Name)})
+				}
+			// Declared names are not references, so only types and
+			// values are searched
+			case *ast.FuncDecl:
+				if n.Recv != nil {
+					visit(n.Recv)
+				}
+				visit(n.Type)
+				return false
+			case *ast.TypeSpec:
+				if n.TypeParams != nil {
+					visit(n.TypeParams)
+				}
+				visit(n.Type)
+				return false
+			case *ast.ValueSpec:
+				if n.Type != nil {
+					visit(n.Type)
+				}
+				for _, v := range n.Values {
+					visit(v)
+				}
+				return false
+			case *ast.Field:
+				visit(n.Type)
+				return false
+			case *ast.KeyValueExpr:
+				visit(n.Value)
+				return false
+			}
+			return true
+		})
+	}
+	for _, d := range f.Decls {
+		visit(d)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].start < links[j].start })
+	return links
+}
+
+// linkSource escapes src as HTML and wraps the spans of links in anchors
+func linkSource(src string, links []link) string {
+	var b strings.Builder
+	last := 0
+	for _, l := range links {
+		if l.start < last || l.end > len(src) {
+			continue
+		}
+		b.WriteString(template.HTMLEscapeString(src[last:l.start]))
+		fmt.Fprintf(&b, `<a href="%s">%s</a>`, template.HTMLEscapeString(l.url), template.HTMLEscapeString(src[l.start:l.end]))
+		last = l.end
+	}
+	b.WriteString(template.HTMLEscapeString(src[last:]))
+	return b.String()
+}
@@ -0,0 +1,113 @@
+package docserver
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"flow-control/internal/docserver/markdown"
+)
+
+// guideDir is the directory of the Markdown guides, tutorials and decision
+// records, relative to the root directory
+const guideDir = "docs"
+
+// guideAssets are the extensions of files that guides may link to, which
+// are served from the guide directory as they are
+var guideAssets = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+	".svg":  true,
+	".webp": true,
+}
+
+// GuideInfo is an entry of a guide listing
+type GuideInfo struct {
+	Title string
+	// Path is the guide's path below the guide directory, without the .md
+	// extension
+	Path string
+	// Dir is set for directories of guides
+	Dir bool
+}
+
+// guideLink returns a function resolving the links of the guide at
+// guidePath. Relative links to Markdown files point to their guide pages
+// and other relative links to files below the guide directory.
+func guideLink(guidePath string) func(string) string {
+	return func(dest string) string {
+		u, err := url.Parse(dest)
+		if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+			return dest
+		}
+		target := path.Join(path.Dir(guidePath), u.Path)
+		link := "/docs/guide/" + strings.TrimSuffix(target, ".md")
+		if u.Fragment != "" {
+			link += "#" + u.Fragment
+		}
+		return link
+	}
+}
+
+// readGuides lists the guides in dir, whose path below the guide directory
+// is rel, along with the subdirectories holding guides
+func readGuides(dir, rel string) ([]GuideInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read guide directory: %w", err)
+	}
+
+	var guides []GuideInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		full := filepath.Join(dir, name)
+		switch {
+		case entry.IsDir():
+			if hasGuides(full) {
+				guides = append(guides, GuideInfo{Title: name, Path: path.Join(rel, name), Dir: true})
+			}
+		case filepath.Ext(name) == ".md":
+			src, err := os.ReadFile(full)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read guide: %w", err)
+			}
+			p := path.Join(rel, strings.TrimSuffix(name, ".md"))
+			title := markdown.Title(string(src))
+			if title == "" {
+				title = strings.TrimSuffix(name, ".md")
+			}
+			guides = append(guides, GuideInfo{Title: title, Path: p})
+		}
+	}
+
+	// Directories are listed after the guides
+	sort.SliceStable(guides, func(i, j int) bool {
+		if guides[i].Dir != guides[j].Dir {
+			return !guides[i].Dir
+		}
+		return guides[i].Path < guides[j].Path
+	})
+	return guides, nil
+}
+
+// hasGuides reports whether there are Markdown files below dir
+func hasGuides(dir string) bool {
+	found := false
+	_ = filepath.WalkDir(dir, func(p string, entry os.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() && filepath.Ext(p) == ".md" {
+			found = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found
+}
@@ -0,0 +1,207 @@
+/*
+Package highlight renders source code as syntax-highlighted HTML on the
+server. Go files are tokenized with go/scanner and Flow files with the Flow
+language lexer; other files are rendered as plain text.
+
+Source is split into lines so that pages can number them and give each an
+anchor. Tokens are wrapped in spans whose class names their kind: keyword,
+string, number, comment or builtin.
+*/
+package highlight
+
+import (
+	"fmt"
+	"go/scanner"
+	gotoken "go/token"
+	"html/template"
+	"path/filepath"
+	"strings"
+
+	"flow-control/internal/parser/lexer"
+	"flow-control/internal/parser/token"
+)
+
+// Token classes
+const (
+	ClassKeyword = "keyword"
+	ClassString  = "string"
+	ClassNumber  = "number"
+	ClassComment = "comment"
+	ClassBuiltin = "builtin"
+)
+
+// Line is one highlighted line of source
+type Line struct {
+	// Number is the line number, starting at 1
+	Number int
+	HTML   template.HTML
+}
+
+// Lines highlights src in the language of the file name, chosen by its
+// extension
+func Lines(name, src string) []Line {
+	switch filepath.Ext(name) {
+	case ".go":
+		return Go(src)
+	case ".flow":
+		return Flow(src)
+	}
+	return render(src, nil)
+}
+
+// span is a highlighted token of source
+type span struct {
+	start, end int
+	class      string
+}
+
+// predeclared holds Go's predeclared identifiers
+var predeclared = map[string]bool{
+	"any": true, "bool": true, "byte": true, "comparable": true, "complex64": true,
+	"complex128": true, "error": true, "float32": true, "float64": true, "int": true,
+	"int8": true, "int16": true, "int32": true, "int64": true, "rune": true,
+	"string": true, "uint": true, "uint8": true, "uint16": true, "uint32": true,
+	"uint64": true, "uintptr": true, "true": true, "false": true, "iota": true,
+	"nil": true, "append": true, "cap": true, "clear": true, "close": true,
+	"complex": true, "copy": true, "delete": true, "imag": true, "len": true,
+	"make": true, "max": true, "min": true, "new": true, "panic": true,
+	"print": true, "println": true, "real": true, "recover": true,
+}
+
+// Go highlights Go source
+func Go(src string) []Line {
+	fset := gotoken.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, []byte(src), nil, scanner.ScanComments)
+
+	var spans []span
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == gotoken.EOF {
+			break
+		}
+		start := file.Offset(pos)
+		var class string
+		switch {
+		case tok.IsKeyword():
+			class = ClassKeyword
+		case tok == gotoken.STRING || tok == gotoken.CHAR:
+			class = ClassString
+		case tok == gotoken.INT || tok == gotoken.FLOAT || tok == gotoken.IMAG:
+			class = ClassNumber
+		case tok == gotoken.COMMENT:
+			class = ClassComment
+		case tok == gotoken.IDENT && predeclared[lit]:
+			class = ClassBuiltin
+		default:
+			continue
+		}
+
+		// The scanner drops carriage returns from raw strings and block
+		// comments, so their ends are found in the source
+		end := start + len(lit)
+		switch {
+		case strings.HasPrefix(lit, "`"):
+			end = closing(src, start+1, "`")
+		case strings.HasPrefix(lit, "/*"):
+			end = closing(src, start+2, "*/")
+		}
+		spans = append(spans, span{start, min(end, len(src)), class})
+	}
+	return render(src, spans)
+}
+
+// closing returns the offset after the first occurrence of delim in src
+// at or after from, or the length of src
+func closing(src string, from int, delim string) int {
+	if i := strings.Index(src[from:], delim); i >= 0 {
+		return from + i + len(delim)
+	}
+	return len(src)
+}
+
+// Flow highlights Flow source
+func Flow(src string) []Line {
+	// The lexer does not report offsets, so tokens are found by following
+	// it through the source
+	l := lexer.New(src)
+	var spans []span
+	offset := 0
+	for {
+		tok := l.NextToken()
+		if tok.Type == token.EOF {
+			break
+		}
+		for offset < len(src) && strings.IndexByte(" \t\r\n", src[offset]) >= 0 {
+			offset++
+		}
+		start := offset
+		var class string
+		switch tok.Type {
+		case token.STRING:
+			// The literal is the source between the quotes
+			offset = min(start+len(tok.Literal)+2, len(src))
+			class = ClassString
+		case token.COMMENT:
+			// Comments run to the end of the line
+			offset = len(src)
+			if i := strings.IndexByte(src[start:], '\n'); i >= 0 {
+				offset = start + i
+			}
+			class = ClassComment
+		case token.NUMBER:
+			offset += len(tok.Literal)
+			class = ClassNumber
+		default:
+			offset += len(tok.Literal)
+			if _, ok := token.Keywords[tok.Literal]; ok && tok.Type != token.IDENT {
+				class = ClassKeyword
+			}
+		}
+		if class != "" {
+			spans = append(spans, span{start, offset, class})
+		}
+	}
+	return render(src, spans)
+}
+
+// render escapes src as HTML, wraps the spans in elements of their class
+// and splits the result into lines
+func render(src string, spans []span) []Line {
+	var lines []Line
+	var b strings.Builder
+	flush := func() {
+		lines = append(lines, Line{Number: len(lines) + 1, HTML: template.HTML(b.String())})
+		b.Reset()
+	}
+	write := func(text, class string) {
+		for i, part := range strings.Split(text, "\n") {
+			if i > 0 {
+				flush()
+			}
+			switch {
+			case part == "":
+			case class == "":
+				b.WriteString(template.HTMLEscapeString(part))
+			default:
+				fmt.Fprintf(&b, `<span class="%s">%s</span>`, class, template.HTMLEscapeString(part))
+			}
+		}
+	}
+
+	last := 0
+	for _, s := range spans {
+		if s.start < last {
+			continue
+		}
+		write(src[last:s.start], "")
+		write(src[s.start:s.end], s.class)
+		last = s.end
+	}
+	write(src[last:], "")
+	if b.Len() > 0 || !strings.HasSuffix(src, "\n") {
+		flush()
+	}
+	return lines
+}
@@ -0,0 +1,48 @@
+package highlight_test
+
+import (
+	"html/template"
+	"testing"
+
+	"flow-control/internal/docserver/highlight"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGo(t *testing.T) {
+	src := "package p\n\n// Max is <big>\nconst Max = 10\n\nvar s = `a\r\nb` /* c\nd */\n\nfunc f() error { return nil }\n"
+	lines := highlight.Go(src)
+	require.Len(t, lines, 10)
+	require.Equal(t, highlight.Line{Number: 1, HTML: `<span class="keyword">package</span> p`}, lines[0])
+	require.Equal(t, template.HTML(""), lines[1].HTML)
+	require.Equal(t, template.HTML(`<span class="comment">// Max is &lt;big&gt;</span>`), lines[2].HTML)
+	require.Equal(t, template.HTML(`<span class="keyword">const</span> Max = <span class="number">10</span>`), lines[3].HTML)
+
+	// Tokens spanning lines are split between them
+	require.Equal(t, template.HTML("<span class=\"keyword\">var</span> s = <span class=\"string\">`a\r</span>"), lines[5].HTML)
+	require.Equal(t, template.HTML("<span class=\"string\">b`</span> <span class=\"comment\">/* c</span>"), lines[6].HTML)
+	require.Equal(t, template.HTML(`<span class="comment">d */</span>`), lines[7].HTML)
+	require.Equal(t, template.HTML(`<span class="keyword">func</span> f() <span class="builtin">error</span> { <span class="keyword">return</span> <span class="builtin">nil</span> }`), lines[9].HTML)
+	require.Equal(t, 10, lines[9].Number)
+}
+
+func TestFlow(t *testing.T) {
+	src := "// Orders\nflow \"orders\" {\n  node \"a & b\" { type: \"Filter\" limit: 3 } // last\n  config -> x\n}"
+	lines := highlight.Flow(src)
+	require.Len(t, lines, 5)
+	require.Equal(t, template.HTML(`<span class="comment">// Orders</span>`), lines[0].HTML)
+	require.Equal(t, template.HTML(`<span class="keyword">flow</span> <span class="string">&#34;orders&#34;</span> {`), lines[1].HTML)
+	require.Equal(t, template.HTML(`  <span class="keyword">node</span> <span class="string">&#34;a &amp; b&#34;</span> { <span class="keyword">type</span>: <span class="string">&#34;Filter&#34;</span> limit: <span class="number">3</span> } <span class="comment">// last</span>`), lines[2].HTML)
+	require.Equal(t, template.HTML(`  <span class="keyword">config</span> -&gt; x`), lines[3].HTML)
+	require.Equal(t, template.HTML("}"), lines[4].HTML)
+}
+
+func TestLines(t *testing.T) {
+	require.Equal(t, []highlight.Line{
+		{Number: 1, HTML: "a &lt; b"},
+		{Number: 2, HTML: ""},
+		{Number: 3, HTML: "c"},
+	}, highlight.Lines("notes.txt", "a < b\n\nc\n"))
+	require.Equal(t, []highlight.Line{{Number: 1, HTML: `<span class="keyword">package</span> p`}}, highlight.Lines("p.go", "package p"))
+	require.Equal(t, []highlight.Line{{Number: 1, HTML: ""}}, highlight.Lines("empty.flow", ""))
+}
@@ -0,0 +1,132 @@
+package docserver
+
+import (
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+
+	"flow-control/internal/docserver/highlight"
+	"flow-control/internal/parser"
+	"flow-control/internal/parser/token"
+)
+
+// LanguageReference is the Flow language reference. It is generated from
+// the lexer's keywords and the parser's grammar, so that it cannot drift
+// from the implementation.
+type LanguageReference struct {
+	Keywords []KeywordDoc
+	Sections []GrammarSection
+}
+
+// KeywordDoc documents a keyword of the Flow language
+type KeywordDoc struct {
+	Keyword string
+	// Token is the name of the keyword's token type
+	Token string
+	// Rules are the names of the grammar rules using the keyword
+	Rules []string
+}
+
+// GrammarSection groups the grammar rules of a category
+type GrammarSection struct {
+	Title string
+	Rules []RuleDoc
+}
+
+// RuleDoc documents a grammar rule
+type RuleDoc struct {
+	Name string
+	// Syntax is the rule's production, with the names of other rules
+	// linking to them
+	Syntax  template.HTML
+	Doc     string
+	Example []highlight.Line
+}
+
+// languageReference builds the language reference from the grammar
+func languageReference() *LanguageReference {
+	ref := &LanguageReference{}
+
+	rules := map[string]bool{}
+	for _, rule := range parser.Grammar {
+		rules[rule.Name] = true
+	}
+
+	usedBy := map[token.TokenType][]string{}
+	sections := map[string]*GrammarSection{}
+	for _, rule := range parser.Grammar {
+		for _, keyword := range rule.Keywords {
+			usedBy[keyword] = append(usedBy[keyword], rule.Name)
+		}
+
+		section, ok := sections[rule.Category]
+		if !ok {
+			ref.Sections = append(ref.Sections, GrammarSection{Title: rule.Category})
+			section = &ref.Sections[len(ref.Sections)-1]
+			sections[rule.Category] = section
+		}
+		section.Rules = append(section.Rules, RuleDoc{
+			Name:    rule.Name,
+			Syntax:  template.HTML(linkRules(rule.Syntax, rule.Name, rules)),
+			Doc:     rule.Doc,
+			Example: highlight.Flow(rule.Example),
+		})
+	}
+
+	for keyword, tt := range token.Keywords {
+		ref.Keywords = append(ref.Keywords, KeywordDoc{
+			Keyword: keyword,
+			Token:   tt.String(),
+			Rules:   usedBy[tt],
+		})
+	}
+	sort.Slice(ref.Keywords, func(i, j int) bool {
+		return ref.Keywords[i].Keyword < ref.Keywords[j].Keyword
+	})
+	return ref
+}
+
+// linkRules escapes the EBNF production of rule as HTML, linking the names
+// of other rules outside quoted terminals to their anchors
+func linkRules(syntax, rule string, rules map[string]bool) string {
+	var b strings.Builder
+	for i := 0; i < len(syntax); {
+		c := syntax[i]
+		switch {
+		case c == '"' || c == '\'':
+			// Terminals run to the matching quote, skipping escaped quotes
+			end := i + 1
+			for end < len(syntax) && syntax[end] != c {
+				if syntax[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end = min(end+1, len(syntax))
+			b.WriteString(template.HTMLEscapeString(syntax[i:end]))
+			i = end
+		case isWordByte(c):
+			end := i
+			for end < len(syntax) && isWordByte(syntax[end]) {
+				end++
+			}
+			word := syntax[i:end]
+			if rules[word] && word != rule {
+				fmt.Fprintf(&b, `<a href="#rule-%s">%s</a>`, word, word)
+			} else {
+				b.WriteString(word)
+			}
+			i = end
+		default:
+			b.WriteString(template.HTMLEscapeString(syntax[i : i+1]))
+			i++
+		}
+	}
+	return b.String()
+}
+
+// isWordByte reports whether c can be part of a rule name
+func isWordByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_'
+}
@@ -0,0 +1,220 @@
+/*
+Package markdown renders Markdown documents as HTML for the documentation
+server's guides.
+
+Documents are parsed and rendered by goldmark, which implements CommonMark,
+with the GitHub Flavored Markdown table extension. Raw HTML is omitted
+rather than passed through, and script links are dropped. Fenced code
+blocks are highlighted by the highlight package when their language is
+known.
+
+Headings are given anchors, and the second and third level headings make up
+the document's table of contents.
+*/
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+
+	"flow-control/internal/docserver/highlight"
+)
+
+// Document is a rendered Markdown document
+type Document struct {
+	// Title is the text of the first level one heading
+	Title string
+	HTML  template.HTML
+	// TOC lists the second and third level headings
+	TOC []Heading
+	// Code lists the fenced code blocks, in the order they appear
+	Code []CodeBlock
+}
+
+// CodeBlock is the content of a fenced code block
+type CodeBlock struct {
+	// Lang is the language named by the block's info string, in lower case
+	Lang string
+	Text string
+}
+
+// Heading is an entry of a document's table of contents
+type Heading struct {
+	Level int
+	// ID is the heading's anchor
+	ID   string
+	Text string
+}
+
+// md renders documents, with fenced code blocks rendered by codeRenderer
+// in place of goldmark's own renderer
+var md = goldmark.New(
+	goldmark.WithExtensions(extension.Table),
+	goldmark.WithRendererOptions(renderer.WithNodeRenderers(util.Prioritized(codeRenderer{}, 100))),
+)
+
+// Render renders Markdown source. Link and image destinations are passed
+// through resolve, when it is not nil, so that callers can rewrite
+// relative links.
+func Render(src string, resolve func(url string) string) *Document {
+	source := []byte(src)
+	root := md.Parser().Parse(text.NewReader(source))
+
+	doc := &Document{}
+	ids := map[string]int{}
+	_ = ast.Walk(root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Heading:
+			plain := plainText(n, source)
+			id := slug(plain)
+			if count := ids[id]; count > 0 {
+				ids[id]++
+				id = fmt.Sprintf("%s-%d", id, count)
+			} else {
+				ids[id] = 1
+			}
+			n.SetAttributeString("id", []byte(id))
+
+			switch {
+			case n.Level == 1 && doc.Title == "":
+				doc.Title = plain
+			case n.Level == 2 || n.Level == 3:
+				doc.TOC = append(doc.TOC, Heading{Level: n.Level, ID: id, Text: plain})
+			}
+		case *ast.FencedCodeBlock:
+			doc.Code = append(doc.Code, CodeBlock{Lang: language(n, source), Text: code(n, source)})
+		case *ast.Link:
+			if resolve != nil {
+				n.Destination = []byte(resolve(string(n.Destination)))
+			}
+		case *ast.Image:
+			if resolve != nil {
+				n.Destination = []byte(resolve(string(n.Destination)))
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	var b bytes.Buffer
+	// Rendering to a buffer only fails if a node renderer does
+	_ = md.Renderer().Render(&b, source, root)
+	doc.HTML = template.HTML(b.String())
+	return doc
+}
+
+// Title returns the text of the first level one heading of src, or an
+// empty string if it has none
+func Title(src string) string {
+	source := []byte(src)
+	root := md.Parser().Parse(text.NewReader(source))
+
+	title := ""
+	_ = ast.Walk(root, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if h, ok := n.(*ast.Heading); ok && entering && h.Level == 1 {
+			title = plainText(h, source)
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return title
+}
+
+// codeRenderer renders fenced code blocks as highlighted source
+type codeRenderer struct{}
+
+// RegisterFuncs implements renderer.NodeRenderer
+func (codeRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, renderCode)
+}
+
+// renderCode renders a fenced code block, highlighting it by the language
+// of its info string
+func renderCode(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.FencedCodeBlock)
+	lang := language(n, source)
+
+	class := ""
+	if lang != "" {
+		class = fmt.Sprintf(` class="language-%s"`, template.HTMLEscapeString(lang))
+	}
+	_, _ = w.WriteString(`<pre class="source"><code` + class + ">")
+	for i, line := range highlight.Lines("code."+lang, code(n, source)) {
+		if i > 0 {
+			_ = w.WriteByte('\n')
+		}
+		_, _ = w.WriteString(string(line.HTML))
+	}
+	_, _ = w.WriteString("</code></pre>\n")
+	return ast.WalkContinue, nil
+}
+
+// language returns the lower case language of a fenced code block
+func language(n *ast.FencedCodeBlock, source []byte) string {
+	return strings.ToLower(string(n.Language(source)))
+}
+
+// code returns the content of a fenced code block, without its final line
+// break
+func code(n *ast.FencedCodeBlock, source []byte) string {
+	var b strings.Builder
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		b.Write(segment.Value(source))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// plainText returns the text of a node without its inline markup
+func plainText(n ast.Node, source []byte) string {
+	var b strings.Builder
+	_ = ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch c := c.(type) {
+		case *ast.Text:
+			b.Write(c.Segment.Value(source))
+			if c.SoftLineBreak() || c.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(c.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(b.String())
+}
+
+// slug turns heading text into an anchor: lowercase letters and digits,
+// with spaces and hyphens as hyphens
+func slug(text string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(text) {
+		switch {
+		case c == ' ' || c == '-':
+			b.WriteByte('-')
+		case c == '_' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c > 127:
+			b.WriteRune(c)
+		}
+	}
+	if b.Len() == 0 {
+		return "section"
+	}
+	return b.String()
+}
@@ -0,0 +1,138 @@
+package markdown_test
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+
+	"flow-control/internal/docserver/markdown"
+
+	"github.com/stretchr/testify/require"
+)
+
+func render(src string) string {
+	return string(markdown.Render(src, nil).HTML)
+}
+
+func TestBlocks(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "paragraphs",
+			src:  "First line\nsecond line\n\nNext <b>paragraph</b>",
+			want: "<p>First line\nsecond line</p>\n<p>Next <!-- raw HTML omitted -->paragraph<!-- raw HTML omitted --></p>\n",
+		},
+		{
+			name: "hard line break",
+			src:  "one  \ntwo",
+			want: "<p>one<br>\ntwo</p>\n",
+		},
+		{
+			name: "thematic break",
+			src:  "a\n\n---\n\nb",
+			want: "<p>a</p>\n<hr>\n<p>b</p>\n",
+		},
+		{
+			name: "block quote",
+			src:  "> quoted\nlazy\n> # Title",
+			want: "<blockquote>\n<p>quoted\nlazy</p>\n<h1 id=\"title\">Title</h1>\n</blockquote>\n",
+		},
+		{
+			name: "tight list",
+			src:  "- one\n- *two*\n  continued\n- three",
+			want: "<ul>\n<li>one</li>\n<li><em>two</em>\ncontinued</li>\n<li>three</li>\n</ul>\n",
+		},
+		{
+			name: "loose ordered list",
+			src:  "3. one\n\n4. two",
+			want: "<ol start=\"3\">\n<li>\n<p>one</p>\n</li>\n<li>\n<p>two</p>\n</li>\n</ol>\n",
+		},
+		{
+			name: "nested list",
+			src:  "- a\n  - b\n  - c\n- d",
+			want: "<ul>\n<li>a\n<ul>\n<li>b</li>\n<li>c</li>\n</ul>\n</li>\n<li>d</li>\n</ul>\n",
+		},
+		{
+			name: "table",
+			src:  "| Name | Value |\n|:-----|------:|\n| `a\\|b` | 1 |\n| c |",
+			want: "<table>\n<thead>\n<tr>\n<th style=\"text-align:left\">Name</th>\n<th style=\"text-align:right\">Value</th>\n</tr>\n</thead>\n" +
+				"<tbody>\n<tr>\n<td style=\"text-align:left\"><code>a|b</code></td>\n<td style=\"text-align:right\">1</td>\n</tr>\n" +
+				"<tr>\n<td style=\"text-align:left\">c</td>\n<td></td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		{
+			name: "plain code block",
+			src:  "```\nx < y\n\n```",
+			want: "<pre class=\"source\"><code>x &lt; y</code></pre>\n",
+		},
+		{
+			name: "highlighted code block",
+			src:  "~~~flow\nflow \"a\" {}\n~~~\nafter",
+			want: "<pre class=\"source\"><code class=\"language-flow\"><span class=\"keyword\">flow</span> <span class=\"string\">&#34;a&#34;</span> {}</code></pre>\n<p>after</p>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, render(tt.src))
+		})
+	}
+}
+
+func TestInline(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"emphasis", "*a* _b_ **c** __d__ ***e***", "<em>a</em> <em>b</em> <strong>c</strong> <strong>d</strong> <em><strong>e</strong></em>"},
+		{"nested emphasis", "*a **b** c*", "<em>a <strong>b</strong> c</em>"},
+		{"intraword underscores", "snake_case_name and 2 * 3 * 4", "snake_case_name and 2 * 3 * 4"},
+		{"code spans", "`a *b*` and `` c ` d ``", "<code>a *b*</code> and <code>c ` d</code>"},
+		{"unclosed code span", "`a", "`a"},
+		{"escapes", `\*not emphasis\* \[x]`, "*not emphasis* [x]"},
+		{"links", `[the *guide*](guide.md "Guide") and <https://example.com/?a=1&b=2>`,
+			`<a href="guide.md" title="Guide">the <em>guide</em></a> and <a href="https://example.com/?a=1&amp;b=2">https://example.com/?a=1&amp;b=2</a>`},
+		{"images", "![a *diagram*](img/flow.svg)", `<img src="img/flow.svg" alt="a diagram">`},
+		{"script links", "[x](javascript:alert(1))", `<a href="">x</a>`},
+		{"not a link", "[x] (y)", "[x] (y)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, "<p>"+tt.want+"</p>\n", render(tt.src))
+		})
+	}
+}
+
+func TestHeadings(t *testing.T) {
+	doc := markdown.Render("# Writing `flows`\n\n## Nodes ##\n### Nodes\n#### Deep\n## Nodes\n# Other", nil)
+	require.Equal(t, "Writing flows", doc.Title)
+	require.Equal(t, []markdown.Heading{
+		{Level: 2, ID: "nodes", Text: "Nodes"},
+		{Level: 3, ID: "nodes-1", Text: "Nodes"},
+		{Level: 2, ID: "nodes-2", Text: "Nodes"},
+	}, doc.TOC)
+	require.True(t, strings.HasPrefix(string(doc.HTML), `<h1 id="writing-flows">Writing <code>flows</code></h1>`))
+	require.Contains(t, string(doc.HTML), `<h4 id="deep">Deep</h4>`)
+
+	require.Equal(t, "Writing flows", markdown.Title("```sh\n# not a title\n```\n# Writing `flows`\n"))
+	require.Equal(t, "", markdown.Title("## Only a section"))
+}
+
+func TestResolve(t *testing.T) {
+	doc := markdown.Render("[next](next.md#nodes) ![img](a.png)", func(url string) string {
+		return "/guide/" + url
+	})
+	require.Equal(t, template.HTML(`<p><a href="/guide/next.md#nodes">next</a> <img src="/guide/a.png" alt="img"></p>`+"\n"), doc.HTML)
+}
+
+func TestCodeBlocks(t *testing.T) {
+	doc := markdown.Render("```Flow\nflow \"a\" {}\n```\n\n- item\n\n  ~~~\n  nested\n  ~~~\n", nil)
+	require.Equal(t, []markdown.CodeBlock{
+		{Lang: "flow", Text: `flow "a" {}`},
+		{Lang: "", Text: "nested"},
+	}, doc.Code)
+}
@@ -0,0 +1,78 @@
+package docserver
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"flow-control/internal/docserver/markdown"
+)
+
+// Languages of guide code blocks used by the playground. Flow blocks are
+// examples, and a jsonl or json block right after one holds the sample
+// messages to run it on.
+const (
+	langFlow  = "flow"
+	langJSONL = "jsonl"
+	langJSON  = "json"
+)
+
+// defaultExample is opened by the playground when no guide example is
+// selected
+var defaultExample = Example{
+	Title: "Playground",
+	Source: `flow "orders" {
+    node "paid" {
+        type: "Filter"
+        field: "status"
+        equals: "paid"
+    }
+    node "tag" {
+        type: "Transform"
+        set: { stage: "billing" }
+    }
+}`,
+	Messages: `{"status":"paid","total":12}
+{"status":"open","total":30}`,
+}
+
+// Example is a flow opened in the playground
+type Example struct {
+	Title  string
+	Source string
+	// Messages are the sample messages, one per line
+	Messages string
+	// Guide is the path of the guide the example comes from, if any
+	Guide string
+}
+
+// guideExample returns the flow example of the guide at name, a path
+// below the guide directory, whose code block has the given index
+func guideExample(root, name string, index int) (*Example, error) {
+	// Cleaning the rooted path keeps it inside the guide directory
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	src, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)) + ".md")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read guide: %w", err)
+	}
+
+	doc := markdown.Render(string(src), nil)
+	if index < 0 || index >= len(doc.Code) || doc.Code[index].Lang != langFlow {
+		return nil, fmt.Errorf("guide %s has no flow example %d", name, index)
+	}
+
+	example := &Example{
+		Title:  doc.Title,
+		Source: doc.Code[index].Text,
+		Guide:  name,
+	}
+	if example.Title == "" {
+		example.Title = path.Base(name)
+	}
+	if next := index + 1; next < len(doc.Code) && (doc.Code[next].Lang == langJSONL || doc.Code[next].Lang == langJSON) {
+		example.Messages = doc.Code[next].Text
+	}
+	return example, nil
+}
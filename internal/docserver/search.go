@@ -0,0 +1,500 @@
+package docserver
+
+import (
+	"errors"
+	"go/doc"
+	"go/token"
+	"hash/fnv"
+	"html/template"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"flow-control/internal/docserver/markdown"
+)
+
+// Kinds of documents in the search index
+const (
+	KindPackage = "package"
+	KindType    = "type"
+	KindFunc    = "func"
+	KindMethod  = "method"
+	KindConst   = "const"
+	KindVar     = "var"
+	KindFlow    = "flow"
+	KindGuide   = "guide"
+)
+
+// SearchKinds lists the kinds search results can be filtered by
+func SearchKinds() []string {
+	return []string{KindPackage, KindType, KindFunc, KindMethod, KindConst, KindVar, KindFlow, KindGuide}
+}
+
+// Search tuning
+const (
+	// nameWeight is how much more a term counts in a document's name than
+	// in its text
+	nameWeight = 5
+	// maxResults limits the number of search results
+	maxResults = 50
+	// snippetLength is the approximate length of result snippets
+	snippetLength = 180
+)
+
+// stopWords are not indexed
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "if": true, "in": true, "is": true,
+	"it": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"this": true, "to": true, "with": true,
+}
+
+// document is an entry of the search index: a package, an exported
+// identifier, a flow file or a guide
+type document struct {
+	kind  string
+	title string
+	url   string
+	// pkg is the path of the document's package, or of the guide below the
+	// guide directory
+	pkg         string
+	description string
+	// name is the document's identifier, whose terms count more than those
+	// of its text
+	name string
+	text string
+}
+
+// posting records that a document contains a term
+type posting struct {
+	doc    int
+	weight float64
+}
+
+// searchIndex is an inverted index over the documentation
+type searchIndex struct {
+	docs     []document
+	postings map[string][]posting
+}
+
+// newSearchIndex indexes the terms of docs
+func newSearchIndex(docs []document) *searchIndex {
+	idx := &searchIndex{docs: docs, postings: map[string][]posting{}}
+	for i, d := range docs {
+		weights := map[string]float64{}
+		for _, term := range terms(d.name) {
+			weights[term] += nameWeight
+		}
+		for _, term := range terms(d.text) {
+			weights[term]++
+		}
+		for term, weight := range weights {
+			idx.postings[term] = append(idx.postings[term], posting{doc: i, weight: weight})
+		}
+	}
+	return idx
+}
+
+// search returns the documents containing every term of query, best match
+// first. Results are limited to kinds when any are given.
+func (idx *searchIndex) search(query string, kinds []string) []SearchResult {
+	queryTerms := unique(terms(query))
+	if len(queryTerms) == 0 {
+		return nil
+	}
+
+	// Score documents by the frequency of the terms, weighted by their
+	// rarity across the index
+	var scores map[int]float64
+	for _, term := range queryTerms {
+		postings := idx.postings[term]
+		idf := math.Log(1 + float64(len(idx.docs))/float64(len(postings)+1))
+		matched := make(map[int]float64, len(postings))
+		for _, p := range postings {
+			score, ok := scores[p.doc]
+			if scores != nil && !ok {
+				continue
+			}
+			matched[p.doc] = score + (1+math.Log(p.weight))*idf
+		}
+		scores = matched
+	}
+
+	query = strings.TrimSpace(query)
+	results := make([]SearchResult, 0, len(scores))
+	for i, score := range scores {
+		d := &idx.docs[i]
+		if len(kinds) > 0 && !contains(kinds, d.kind) {
+			continue
+		}
+		if strings.EqualFold(d.name, query) {
+			score *= 2
+		}
+		results = append(results, SearchResult{
+			Title:       d.title,
+			Description: d.description,
+			URL:         d.url,
+			Path:        d.pkg,
+			Kind:        d.kind,
+			Snippet:     snippet(d.text, queryTerms),
+			Score:       score,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Title < results[j].Title
+	})
+	if len(results) > maxResults {
+		results = results[:maxResults]
+	}
+	return results
+}
+
+// terms splits text into lowercase search terms. Identifiers are indexed
+// whole and by their camel case parts, so that LoadSource is found by
+// "loadsource", "load" and "source".
+func terms(text string) []string {
+	var out []string
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		parts := camelParts(word)
+		if len(parts) > 1 {
+			parts = append(parts, word)
+		}
+		for _, part := range parts {
+			term := strings.ToLower(part)
+			if len(term) > 1 && !stopWords[term] {
+				out = append(out, term)
+			}
+		}
+	}
+	return out
+}
+
+// camelParts splits a camel case identifier into its words, keeping
+// acronyms together: HTTPServer is split into HTTP and Server
+func camelParts(word string) []string {
+	runes := []rune(word)
+	var parts []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prev, cur := runes[i-1], runes[i]
+		lowerToUpper := unicode.IsLower(prev) && unicode.IsUpper(cur)
+		acronymEnd := unicode.IsUpper(prev) && unicode.IsUpper(cur) &&
+			i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if lowerToUpper || acronymEnd {
+			parts = append(parts, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(parts, string(runes[start:]))
+}
+
+// unique removes repeated terms, keeping the first of each
+func unique(terms []string) []string {
+	seen := map[string]bool{}
+	out := terms[:0]
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			out = append(out, term)
+		}
+	}
+	return out
+}
+
+// contains reports whether values contains v
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// snippet returns the part of text around the first occurrence of a query
+// term, with the terms marked
+func snippet(text string, queryTerms []string) template.HTML {
+	text = strings.Join(strings.Fields(text), " ")
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) {
+		// Lowercasing changed byte offsets, so terms cannot be located
+		lower = text
+	}
+
+	first := -1
+	for _, term := range queryTerms {
+		if i := strings.Index(lower, term); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+
+	// Cut the text around the match on word boundaries
+	start, end := 0, len(text)
+	if first > snippetLength/3 {
+		start = first - snippetLength/3
+		if i := strings.IndexByte(text[start:], ' '); i >= 0 && start+i < first {
+			start += i + 1
+		}
+	}
+	if start+snippetLength < end {
+		end = start + snippetLength
+		if i := strings.LastIndexByte(text[start:end], ' '); i > 0 {
+			end = start + i
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("… ")
+	}
+	i := start
+	for i < end {
+		// Find the next, longest term occurrence within the snippet
+		next, length := -1, 0
+		for _, term := range queryTerms {
+			j := strings.Index(lower[i:end], term)
+			if j < 0 {
+				continue
+			}
+			if next < 0 || i+j < next || (i+j == next && len(term) > length) {
+				next, length = i+j, len(term)
+			}
+		}
+		if next < 0 || next+length > end {
+			b.WriteString(template.HTMLEscapeString(text[i:end]))
+			break
+		}
+		b.WriteString(template.HTMLEscapeString(text[i:next]))
+		b.WriteString("<mark>" + template.HTMLEscapeString(text[next:next+length]) + "</mark>")
+		i = next + length
+	}
+	if end < len(text) {
+		b.WriteString(" …")
+	}
+	return template.HTML(b.String())
+}
+
+// docSearch keeps a search index of the packages below a root directory
+// and of the guides in a guide directory, rebuilding it when files change
+type docSearch struct {
+	root   string
+	guides string
+	mu     sync.Mutex
+	stamp  uint64
+	index  *searchIndex
+}
+
+// current returns the index, rebuilding it first if a Go, Flow or
+// Markdown file was added, removed or modified since it was built
+func (s *docSearch) current() (*searchIndex, error) {
+	stamp, err := treeStamp(s.root, s.guides)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index == nil || stamp != s.stamp {
+		docs, err := collectDocuments(s.root)
+		if err != nil {
+			return nil, err
+		}
+		guides, err := collectGuides(s.guides)
+		if err != nil {
+			return nil, err
+		}
+		s.index, s.stamp = newSearchIndex(append(docs, guides...)), stamp
+	}
+	return s.index, nil
+}
+
+// indexed reports whether a file is included in the search index
+func indexed(name string) bool {
+	switch filepath.Ext(name) {
+	case ".flow", ".md":
+		return true
+	case ".go":
+		return !strings.HasSuffix(name, "_test.go")
+	}
+	return false
+}
+
+// walkDocs walks the directories of root that may hold documentation,
+// skipping hidden directories and test data. A missing root holds none.
+func walkDocs(root string, fn func(p string, entry fs.DirEntry) error) error {
+	return filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() && p != root {
+			if name := entry.Name(); strings.HasPrefix(name, ".") || name == "testdata" {
+				return filepath.SkipDir
+			}
+		}
+		return fn(p, entry)
+	})
+}
+
+// treeStamp fingerprints the names, sizes and modification times of the
+// indexed files below roots
+func treeStamp(roots ...string) (uint64, error) {
+	h := fnv.New64a()
+	for _, root := range roots {
+		err := walkDocs(root, func(p string, entry fs.DirEntry) error {
+			if entry.IsDir() || !indexed(entry.Name()) {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			_, _ = h.Write([]byte(p))
+			_, _ = h.Write([]byte(info.ModTime().String()))
+			_, _ = h.Write([]byte{byte(info.Size()), byte(info.Size() >> 8), byte(info.Size() >> 16), byte(info.Size() >> 24)})
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return h.Sum64(), nil
+}
+
+// collectDocuments reads the packages and flow files below root, a
+// directory of packages such as internal/
+func collectDocuments(root string) ([]document, error) {
+	var docs []document
+	err := walkDocs(root, func(p string, entry fs.DirEntry) error {
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case entry.IsDir() && p != root:
+			docs = append(docs, packageDocuments(p, rel)...)
+		case !entry.IsDir() && filepath.Ext(p) == ".flow":
+			src, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			docs = append(docs, document{
+				kind:  KindFlow,
+				title: rel,
+				url:   "/docs/src/" + rel,
+				pkg:   path.Dir(rel),
+				name:  strings.TrimSuffix(entry.Name(), ".flow"),
+				text:  string(src),
+			})
+		}
+		return nil
+	})
+	return docs, err
+}
+
+// collectGuides reads the Markdown guides below dir
+func collectGuides(dir string) ([]document, error) {
+	var docs []document
+	err := walkDocs(dir, func(p string, entry fs.DirEntry) error {
+		if entry.IsDir() || filepath.Ext(p) != ".md" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = strings.TrimSuffix(filepath.ToSlash(rel), ".md")
+		src, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		title := markdown.Title(string(src))
+		if title == "" {
+			title = rel
+		}
+		docs = append(docs, document{
+			kind:  KindGuide,
+			title: title,
+			url:   "/docs/guide/" + rel,
+			pkg:   rel,
+			name:  title,
+			text:  string(src),
+		})
+		return nil
+	})
+	return docs, err
+}
+
+// packageDocuments returns the documents of the package in dir: the
+// package itself and its exported identifiers
+func packageDocuments(dir, rel string) []document {
+	pkg, _, err := readPackage(token.NewFileSet(), dir, modulePath+"/internal/"+rel)
+	if err != nil || pkg == nil {
+		return nil
+	}
+
+	url := "/docs/pkg/" + rel
+	docs := []document{{
+		kind:        KindPackage,
+		title:       rel,
+		url:         url,
+		pkg:         rel,
+		description: pkg.Synopsis(pkg.Doc),
+		name:        rel,
+		text:        pkg.Doc,
+	}}
+	symbol := func(kind, name, anchor, text string) {
+		docs = append(docs, document{
+			kind:        kind,
+			title:       name,
+			url:         url + "#" + anchor,
+			pkg:         rel,
+			description: pkg.Synopsis(text),
+			name:        name,
+			text:        text,
+		})
+	}
+	values := func(kind string, values []*doc.Value) {
+		for _, v := range values {
+			for _, name := range v.Names {
+				symbol(kind, name, name, v.Doc)
+			}
+		}
+	}
+	funcs := func(funcs []*doc.Func) {
+		for _, f := range funcs {
+			symbol(KindFunc, f.Name, f.Name, f.Doc)
+		}
+	}
+
+	values(KindConst, pkg.Consts)
+	values(KindVar, pkg.Vars)
+	funcs(pkg.Funcs)
+	for _, t := range pkg.Types {
+		symbol(KindType, t.Name, t.Name, t.Doc)
+		values(KindConst, t.Consts)
+		values(KindVar, t.Vars)
+		funcs(t.Funcs)
+		for _, m := range t.Methods {
+			id := t.Name + "." + m.Name
+			symbol(KindMethod, id, id, m.Doc)
+		}
+	}
+	return docs
+}
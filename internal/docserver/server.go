@@ -1,14 +1,23 @@
 package docserver
 
 import (
+	"embed"
+	"encoding/json"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 
+	"flow-control/internal/docserver/highlight"
+	"flow-control/internal/docserver/markdown"
+	"flow-control/internal/runtime/diagram"
+	"flow-control/internal/runtime/engine"
 	"flow-control/internal/types"
 
 	"github.com/go-chi/chi/v5"
@@ -28,74 +37,124 @@ type Server struct {
 	log       types.Logger
 	templates map[string]*template.Template
 	rootDir   string
+	// templateDir overrides the embedded templates when set
+	templateDir string
+	search      *docSearch
 }
 
 // PackageInfo represents information about a package
 type PackageInfo struct {
-	Name        string
+	// Name is the last element of the package's path
+	Name string
+	// Path is the package's directory relative to internal/
 	Path        string
 	Description string
+	// Children are the packages in subdirectories of the package
+	Children []PackageInfo
 }
 
-// New creates a new documentation server
-func New(log types.Logger) *Server {
-	// Get the absolute path to the workspace root
-	rootDir, err := os.Getwd()
-	if err != nil {
-		log.Error("Failed to get working directory", err, types.Fields{
-			"component": "docserver",
-		})
-		os.Exit(1)
+// embeddedTemplates holds the page templates compiled into the binary
+//
+//go:embed templates/*.html
+var embeddedTemplates embed.FS
+
+// templateFiles are the pages of the documentation server, each parsed
+// together with base.html
+var templateFiles = []string{
+	"index.html",
+	"package_list.html",
+	"package.html",
+	"source_list.html",
+	"source.html",
+	"source_dir.html",
+	"search.html",
+	"package_content.html",
+	"language.html",
+	"guide.html",
+	"playground.html",
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithRootDir sets the directory whose internal packages are documented.
+// It defaults to the working directory.
+func WithRootDir(dir string) Option {
+	return func(s *Server) {
+		s.rootDir = dir
+	}
+}
+
+// WithTemplateDir loads the templates from dir instead of the copies
+// embedded in the binary, so they can be edited without rebuilding
+func WithTemplateDir(dir string) Option {
+	return func(s *Server) {
+		s.templateDir = dir
 	}
+}
 
+// New creates a new documentation server
+func New(log types.Logger, opts ...Option) (*Server, error) {
 	s := &Server{
 		router:    chi.NewRouter(),
 		log:       log,
-		rootDir:   rootDir,
 		templates: make(map[string]*template.Template),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
 
-	// Parse templates
-	templatePath := filepath.Join(rootDir, "internal", "docserver", "templates")
-	s.log.Debug("Loading templates from", types.Fields{
-		"component": "docserver",
-		"path":      templatePath,
-	})
+	if s.rootDir == "" {
+		rootDir, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get working directory: %w", err)
+		}
+		s.rootDir = rootDir
+	}
+	s.search = &docSearch{
+		root:   filepath.Join(s.rootDir, "internal"),
+		guides: filepath.Join(s.rootDir, guideDir),
+	}
 
-	// List of template files
-	templateFiles := []string{
-		"index.html",
-		"package_list.html",
-		"package.html",
-		"source_list.html",
-		"source.html",
-		"source_dir.html",
-		"search.html",
-		"package_content.html",
+	if err := s.loadTemplates(); err != nil {
+		return nil, err
+	}
+
+	s.setupRoutes()
+	return s, nil
+}
+
+// loadTemplates parses the page templates, from the template directory if
+// one is set and from the embedded copies otherwise
+func (s *Server) loadTemplates() error {
+	var files fs.FS
+	if s.templateDir != "" {
+		s.log.Debug("Loading templates from", types.Fields{
+			"component": "docserver",
+			"path":      s.templateDir,
+		})
+		files = os.DirFS(s.templateDir)
+	} else {
+		sub, err := fs.Sub(embeddedTemplates, "templates")
+		if err != nil {
+			return fmt.Errorf("failed to open embedded templates: %w", err)
+		}
+		files = sub
 	}
 
 	// Parse each template with base.html
-	for _, tmpl := range templateFiles {
-		t, err := template.ParseFiles(
-			filepath.Join(templatePath, "base.html"),
-			filepath.Join(templatePath, tmpl),
-		)
+	for _, name := range templateFiles {
+		t, err := template.ParseFS(files, "base.html", name)
 		if err != nil {
-			log.Error("Failed to parse template", err, types.Fields{
-				"component": "docserver",
-				"template":  tmpl,
-			})
-			os.Exit(1)
+			return fmt.Errorf("failed to parse template %s: %w", name, err)
 		}
-		s.templates[tmpl] = t
+		s.templates[name] = t
 		s.log.Debug("Loaded template", types.Fields{
 			"component": "docserver",
-			"template":  tmpl,
+			"template":  name,
 		})
 	}
-
-	s.setupRoutes()
-	return s
+	return nil
 }
 
 // Routes returns the router for mounting
@@ -112,12 +171,22 @@ func (s *Server) setupRoutes() {
 	s.router.Get("/docs/pkg", s.handlePackageList)
 	s.router.Get("/pkg/*", s.handlePackage)
 	s.router.Get("/docs/pkg/*", s.handlePackage)
+	s.router.Get("/docs/api/pkg", s.handleAPIPackageList)
+	s.router.Get("/docs/api/pkg/*", s.handleAPIPackage)
 	s.router.Get("/src", s.handleSourceList)
 	s.router.Get("/docs/src", s.handleSourceList)
 	s.router.Get("/src/*", s.handleSource)
 	s.router.Get("/docs/src/*", s.handleSource)
 	s.router.Get("/search", s.handleSearch)
 	s.router.Get("/docs/search", s.handleSearch)
+	s.router.Get("/language", s.handleLanguage)
+	s.router.Get("/docs/language", s.handleLanguage)
+	s.router.Get("/guide", s.handleGuide)
+	s.router.Get("/docs/guide", s.handleGuide)
+	s.router.Get("/guide/*", s.handleGuide)
+	s.router.Get("/docs/guide/*", s.handleGuide)
+	s.router.Get("/playground", s.handlePlayground)
+	s.router.Get("/docs/playground", s.handlePlayground)
 }
 
 // handleIndex serves the documentation index page
@@ -167,7 +236,8 @@ func (s *Server) handlePackage(w http.ResponseWriter, r *http.Request) {
 	})
 
 	// Construct the full package path relative to workspace root
-	pkgPath := filepath.Join("internal", path)
+	relPath := filepath.Join("internal", path)
+	pkgPath := filepath.Join(s.rootDir, relPath)
 	s.log.Debug("Looking for package in", types.Fields{
 		"component": "docserver",
 		"pkg_path":  pkgPath,
@@ -176,7 +246,8 @@ func (s *Server) handlePackage(w http.ResponseWriter, r *http.Request) {
 	// Check if the directory exists
 	if _, err := os.Stat(pkgPath); os.IsNotExist(err) {
 		// Try without the docs/pkg prefix
-		pkgPath = filepath.Join("internal", strings.TrimPrefix(path, "docs/pkg/"))
+		relPath = filepath.Join("internal", strings.TrimPrefix(path, "docs/pkg/"))
+		pkgPath = filepath.Join(s.rootDir, relPath)
 		if _, err := os.Stat(pkgPath); os.IsNotExist(err) {
 			s.log.Error("Package directory not found", err, types.Fields{
 				"component": "docserver",
@@ -186,15 +257,23 @@ func (s *Server) handlePackage(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	path = strings.TrimPrefix(filepath.ToSlash(relPath), "internal/")
+	if wantsJSON(r) {
+		s.writePackageJSON(w, r, pkgPath, path)
+		return
+	}
 
-	// Get package description from doc.go if it exists
-	var description string
-	docFile := filepath.Join(pkgPath, "doc.go")
-	if content, err := os.ReadFile(docFile); err == nil {
-		description = string(content)
+	pkg, err := loadPackageDoc(pkgPath, modulePath+"/"+filepath.ToSlash(relPath))
+	if err != nil {
+		s.log.Error("Failed to read package", err, types.Fields{
+			"component": "docserver",
+			"path":      pkgPath,
+		})
+		http.Error(w, "Failed to read package", http.StatusInternalServerError)
+		return
 	}
 
-	// Get list of Go files and their contents
+	// List the package's Go files for the source links
 	files, err := os.ReadDir(pkgPath)
 	if err != nil {
 		s.log.Error("Failed to read package directory", err, types.Fields{
@@ -205,21 +284,21 @@ func (s *Server) handlePackage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	subpackages, err := s.readPackages(pkgPath, path)
+	if err != nil {
+		s.log.Error("Failed to list subpackages", err, types.Fields{
+			"component": "docserver",
+			"path":      pkgPath,
+		})
+		http.Error(w, "Failed to read package", http.StatusInternalServerError)
+		return
+	}
+
 	var goFiles []map[string]interface{}
 	for _, file := range files {
 		if !file.IsDir() && strings.HasSuffix(file.Name(), ".go") {
-			filePath := filepath.Join(pkgPath, file.Name())
-			content, err := os.ReadFile(filePath)
-			if err != nil {
-				s.log.Error("Failed to read file", err, types.Fields{
-					"component": "docserver",
-					"file":      filePath,
-				})
-				continue
-			}
 			goFiles = append(goFiles, map[string]interface{}{
-				"Name":    file.Name(),
-				"Content": string(content),
+				"Name": file.Name(),
 			})
 		}
 	}
@@ -227,8 +306,9 @@ func (s *Server) handlePackage(w http.ResponseWriter, r *http.Request) {
 	data := map[string]interface{}{
 		"Title":       path,
 		"Path":        path,
-		"Description": description,
+		"Package":     pkg,
 		"Files":       goFiles,
+		"Subpackages": subpackages,
 	}
 
 	tmpl := s.templates["package.html"]
@@ -251,6 +331,102 @@ func (s *Server) handlePackage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleAPIPackageList serves the list of documented packages as JSON
+func (s *Server) handleAPIPackageList(w http.ResponseWriter, r *http.Request) {
+	packages, err := s.listPackages()
+	if err != nil {
+		s.log.Error("Failed to list packages", err, types.Fields{
+			"component": "docserver",
+			"handler":   "handleAPIPackageList",
+		})
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, "handleAPIPackageList", packageEntries(packages))
+}
+
+// handleAPIPackage serves the documentation of a package as JSON, or of
+// one of its names when the name parameter is set
+func (s *Server) handleAPIPackage(w http.ResponseWriter, r *http.Request) {
+	// Cleaning the rooted path keeps it inside the internal directory
+	rel := strings.TrimPrefix(path.Clean("/"+chi.URLParam(r, "*")), "/")
+	dir := filepath.Join(s.rootDir, "internal", filepath.FromSlash(rel))
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() || rel == "" {
+		http.Error(w, "Package not found", http.StatusNotFound)
+		return
+	}
+	s.writePackageJSON(w, r, dir, rel)
+}
+
+// writePackageJSON writes the documentation of the package in dir, whose
+// path relative to internal/ is rel, as JSON
+func (s *Server) writePackageJSON(w http.ResponseWriter, r *http.Request, dir, rel string) {
+	pkg, err := loadPackageJSON(dir, rel)
+	if err == nil && pkg == nil {
+		// Directories without Go files still list their subpackages
+		importPath := modulePath + "/internal/" + rel
+		pkg = &PackageJSON{
+			Name:       path.Base(rel),
+			ImportPath: importPath,
+			Path:       rel,
+			URL:        docURL(importPath, ""),
+			Consts:     []ValueJSON{},
+			Vars:       []ValueJSON{},
+			Funcs:      []FuncJSON{},
+			Types:      []TypeJSON{},
+		}
+	}
+	var subpackages []PackageInfo
+	if err == nil {
+		subpackages, err = s.readPackages(dir, rel)
+	}
+	if err != nil {
+		s.log.Error("Failed to read package", err, types.Fields{
+			"component": "docserver",
+			"handler":   "writePackageJSON",
+			"path":      dir,
+		})
+		http.Error(w, "Failed to read package", http.StatusInternalServerError)
+		return
+	}
+	pkg.Subpackages = []string{}
+	for _, sub := range subpackages {
+		pkg.Subpackages = append(pkg.Subpackages, sub.Path)
+	}
+
+	if name := r.URL.Query().Get("name"); name != "" {
+		symbol := pkg.Symbol(name)
+		if symbol == nil {
+			http.Error(w, "Name not found", http.StatusNotFound)
+			return
+		}
+		s.writeJSON(w, "writePackageJSON", symbol)
+		return
+	}
+	s.writeJSON(w, "writePackageJSON", pkg)
+}
+
+// writeJSON writes v as indented JSON
+func (s *Server) writeJSON(w http.ResponseWriter, handler string, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		s.log.Error("Failed to write JSON", err, types.Fields{
+			"component": "docserver",
+			"handler":   handler,
+		})
+	}
+}
+
+// wantsJSON reports whether a request asks for JSON rather than HTML, as
+// tools sending "Accept: application/json" do
+func wantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
 // handleSource serves source code files
 func (s *Server) handleSource(w http.ResponseWriter, r *http.Request) {
 	// Remove /src/ prefix and handle both with and without trailing slash
@@ -266,6 +442,7 @@ func (s *Server) handleSource(w http.ResponseWriter, r *http.Request) {
 	if !strings.HasPrefix(path, "internal/") {
 		filePath = filepath.Join("internal", path)
 	}
+	filePath = filepath.Join(s.rootDir, filePath)
 
 	s.log.Debug("Looking for source file", types.Fields{
 		"component": "docserver",
@@ -347,6 +524,21 @@ func (s *Server) handleSource(w http.ResponseWriter, r *http.Request) {
 		"Title":   filepath.Base(path),
 		"Path":    path,
 		"Content": string(content),
+		"Lines":   highlight.Lines(filePath, string(content)),
+	}
+
+	// Flow files are shown with a diagram of their graph
+	if filepath.Ext(filePath) == ".flow" {
+		if graph, err := engine.LoadSource(string(content), "", s.log); err == nil {
+			// The renderer escapes every text it draws
+			data["Graph"] = template.HTML(diagram.SVG(graph))
+		} else {
+			s.log.Debug("Not rendering flow graph", types.Fields{
+				"component": "docserver",
+				"file":      filePath,
+				"error":     err.Error(),
+			})
+		}
 	}
 
 	tmpl := s.templates["source.html"]
@@ -369,19 +561,22 @@ func (s *Server) handleSource(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleSearch serves the search page
+// handleSearch serves the search page. Results can be limited to kinds of
+// documents with one or more kind parameters.
 func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("q")
+	kinds := r.URL.Query()["kind"]
 	data := map[string]interface{}{
 		"Title": "Search Documentation",
 		"Query": query,
+		"Kinds": SearchKinds(),
+		"Kind":  r.URL.Query().Get("kind"),
 	}
 
 	if query != "" {
-		// Perform search
-		results, err := s.searchPackages(query)
+		index, err := s.search.current()
 		if err != nil {
-			s.log.Error("Failed to search packages", err, types.Fields{
+			s.log.Error("Failed to build search index", err, types.Fields{
 				"component": "docserver",
 				"handler":   "handleSearch",
 				"query":     query,
@@ -389,7 +584,7 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
-		data["Results"] = results
+		data["Results"] = index.search(query, kinds)
 	}
 
 	tmpl := s.templates["search.html"]
@@ -412,40 +607,165 @@ func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// SearchResult represents a search result
-type SearchResult struct {
-	Title       string
-	Description string
-	URL         string
-	Path        string
-}
+// handleLanguage serves the Flow language reference
+func (s *Server) handleLanguage(w http.ResponseWriter, r *http.Request) {
+	data := map[string]interface{}{
+		"Title":     "Flow Language Reference",
+		"Reference": languageReference(),
+	}
 
-// searchPackages searches for packages matching the query
-func (s *Server) searchPackages(query string) ([]SearchResult, error) {
-	packages, err := s.listPackages()
-	if err != nil {
-		return nil, err
+	tmpl := s.templates["language.html"]
+	if tmpl == nil {
+		s.log.Error("Template not found", fmt.Errorf("language.html not loaded"), types.Fields{
+			"component": "docserver",
+			"handler":   "handleLanguage",
+		})
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
 
-	var results []SearchResult
-	for i := range packages {
-		pkg := &packages[i]
-		if strings.Contains(strings.ToLower(pkg.Name), strings.ToLower(query)) ||
-			strings.Contains(strings.ToLower(pkg.Description), strings.ToLower(query)) {
-			results = append(results, SearchResult{
-				Title:       pkg.Name,
-				Description: pkg.Description,
-				URL:         fmt.Sprintf("/docs/pkg/%s", pkg.Path),
-				Path:        pkg.Path,
+	if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+		s.log.Error("Failed to render template", err, types.Fields{
+			"component": "docserver",
+			"handler":   "handleLanguage",
+			"template":  "language.html",
+		})
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// handleGuide serves the Markdown guides below the guide directory. A
+// directory lists its guides, and a path without extension renders the
+// .md file of that name.
+func (s *Server) handleGuide(w http.ResponseWriter, r *http.Request) {
+	// Cleaning the rooted path keeps it inside the guide directory
+	name := strings.TrimPrefix(path.Clean("/"+chi.URLParam(r, "*")), "/")
+	name = strings.TrimSuffix(name, ".md")
+	root := filepath.Join(s.rootDir, guideDir)
+	full := filepath.Join(root, filepath.FromSlash(name))
+
+	data := map[string]interface{}{
+		"Title": "Guides",
+		"Path":  name,
+	}
+	if info, err := os.Stat(full); err == nil && info.IsDir() {
+		guides, err := readGuides(full, name)
+		if err != nil {
+			s.log.Error("Failed to list guides", err, types.Fields{
+				"component": "docserver",
+				"handler":   "handleGuide",
+				"path":      full,
 			})
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if name != "" {
+			data["Title"] = name
+		}
+		data["Guides"] = guides
+	} else if err == nil && guideAssets[strings.ToLower(filepath.Ext(full))] {
+		http.ServeFile(w, r, full)
+		return
+	} else {
+		src, err := os.ReadFile(full + ".md")
+		if err != nil {
+			http.Error(w, "Guide not found", http.StatusNotFound)
+			return
+		}
+		guide := markdown.Render(string(src), guideLink(name))
+		if guide.Title != "" {
+			data["Title"] = guide.Title
+		} else {
+			data["Title"] = path.Base(name)
+		}
+		data["Guide"] = guide
+	}
+
+	tmpl := s.templates["guide.html"]
+	if tmpl == nil {
+		s.log.Error("Template not found", fmt.Errorf("guide.html not loaded"), types.Fields{
+			"component": "docserver",
+			"handler":   "handleGuide",
+		})
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+		s.log.Error("Failed to render template", err, types.Fields{
+			"component": "docserver",
+			"handler":   "handleGuide",
+			"template":  "guide.html",
+		})
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// handlePlayground serves the playground, where flows are edited,
+// validated and dry-run against sample messages through the API. The
+// guide and example parameters open a flow example of a guide, by the
+// index of its code block.
+func (s *Server) handlePlayground(w http.ResponseWriter, r *http.Request) {
+	example := &defaultExample
+	query := r.URL.Query()
+	if guide := query.Get("guide"); guide != "" {
+		index, err := strconv.Atoi(query.Get("example"))
+		if err != nil {
+			http.Error(w, "Invalid example", http.StatusBadRequest)
+			return
 		}
+		if example, err = guideExample(filepath.Join(s.rootDir, guideDir), guide, index); err != nil {
+			http.Error(w, "Example not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	data := map[string]interface{}{
+		"Title":   "Playground",
+		"Example": example,
+	}
+
+	tmpl := s.templates["playground.html"]
+	if tmpl == nil {
+		s.log.Error("Template not found", fmt.Errorf("playground.html not loaded"), types.Fields{
+			"component": "docserver",
+			"handler":   "handlePlayground",
+		})
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
 
-	return results, nil
+	if err := tmpl.ExecuteTemplate(w, "base", data); err != nil {
+		s.log.Error("Failed to render template", err, types.Fields{
+			"component": "docserver",
+			"handler":   "handlePlayground",
+			"template":  "playground.html",
+		})
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// SearchResult represents a search result
+type SearchResult struct {
+	Title       string
+	Description string
+	URL         string
+	// Path is the path of the package or guide the result belongs to
+	Path string
+	// Kind is the kind of document found, such as package or func
+	Kind string
+	// Snippet is the part of the document's text matching the query
+	Snippet template.HTML
+	Score   float64
 }
 
 // handlePackageList serves the package list page
 func (s *Server) handlePackageList(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		s.handleAPIPackageList(w, r)
+		return
+	}
+
 	packages, err := s.listPackages()
 	if err != nil {
 		s.log.Error("Failed to list packages", err, types.Fields{
@@ -518,34 +838,43 @@ func (s *Server) handleSourceList(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// listPackages returns a list of all packages in the codebase
+// listPackages returns the packages in the codebase as a tree: each
+// package lists the packages in its subdirectories as children. Directories
+// without Go files are included when they have packages below them.
 func (s *Server) listPackages() ([]PackageInfo, error) {
-	var packages []PackageInfo
+	return s.readPackages(filepath.Join(s.rootDir, "internal"), "")
+}
 
-	// Get list of packages in internal directory
-	internalPath := filepath.Join(s.rootDir, "internal")
-	entries, err := os.ReadDir(internalPath)
+// readPackages returns the packages below dir, whose path relative to the
+// internal directory is rel, sorted by name
+func (s *Server) readPackages(dir, rel string) ([]PackageInfo, error) {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read internal directory: %w", err)
+		return nil, fmt.Errorf("failed to read package directory: %w", err)
 	}
 
+	var packages []PackageInfo
 	for _, entry := range entries {
-		if !entry.IsDir() {
+		name := entry.Name()
+		if !entry.IsDir() || strings.HasPrefix(name, ".") || name == "testdata" {
 			continue
 		}
 
-		pkgPath := filepath.Join(internalPath, entry.Name())
-		docFile := filepath.Join(pkgPath, "doc.go")
-
-		var description string
-		if content, err := os.ReadFile(docFile); err == nil {
-			description = string(content)
+		pkgDir := filepath.Join(dir, name)
+		children, err := s.readPackages(pkgDir, path.Join(rel, name))
+		if err != nil {
+			return nil, err
+		}
+		goFiles, _ := filepath.Glob(filepath.Join(pkgDir, "*.go"))
+		if len(goFiles) == 0 && len(children) == 0 {
+			continue
 		}
 
 		packages = append(packages, PackageInfo{
-			Name:        entry.Name(),
-			Path:        entry.Name(),
-			Description: description,
+			Name:        name,
+			Path:        path.Join(rel, name),
+			Description: packageSynopsis(pkgDir),
+			Children:    children,
 		})
 	}
 
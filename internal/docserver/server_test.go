@@ -1,6 +1,7 @@
 package docserver_test
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -17,13 +18,16 @@ import (
 var templates = map[string]string{
 	"base.html":            `{{define "base"}}<!DOCTYPE html><html><body>{{template "content" .}}</body></html>{{end}}`,
 	"index.html":           `{{define "content"}}<h1>{{.Title}}</h1>{{range .Packages}}<div>{{.Name}}</div>{{end}}{{end}}`,
-	"package_list.html":    `{{define "content"}}<h1>Packages</h1>{{range .Packages}}<div>{{.Name}}</div>{{end}}{{end}}`,
-	"package.html":         `{{define "content"}}<h1>Package {{.Title}}</h1>{{range .Files}}<div>{{.Name}}</div>{{end}}{{end}}`,
+	"package_list.html":    `{{define "content"}}<h1>Packages</h1>{{range .Packages}}<div>{{.Name}}: {{.Description}}</div>{{range .Children}}<div>{{.Path}}: {{.Description}}</div>{{end}}{{end}}{{end}}`,
+	"package.html":         `{{define "content"}}<h1>Package {{.Title}}</h1>{{with .Package}}<p>{{.Synopsis}}</p>{{range .Types}}<h3 id="{{.Name}}">{{.Name}}</h3><pre>{{.Decl}}</pre>{{.Doc}}{{range .Funcs}}<pre>{{.Decl}}</pre>{{end}}{{range .Methods}}<pre id="{{.ID}}">{{.Decl}}</pre>{{end}}{{end}}{{end}}{{range .Files}}<div>{{.Name}}</div>{{end}}{{end}}`,
 	"source_list.html":     `{{define "content"}}<h1>Source Code</h1>{{range .Packages}}<div>{{.Name}}</div>{{end}}{{end}}`,
-	"source.html":          `{{define "content"}}<h1>{{.Title}}</h1><pre>{{.Content}}</pre>{{end}}`,
+	"source.html":          `{{define "content"}}<h1>{{.Title}}</h1>{{.Graph}}<pre>{{.Content}}</pre>{{range .Lines}}<span id="L{{.Number}}">{{.HTML}}</span>{{end}}{{end}}`,
 	"source_dir.html":      `{{define "content"}}<h1>{{.Title}}</h1>{{range .Files}}<div>{{.Name}}</div>{{end}}{{end}}`,
-	"search.html":          `{{define "content"}}<h1>Search Results</h1>{{if .Query}}{{range .Results}}<div>{{.Title}}</div>{{end}}{{end}}{{end}}`,
+	"search.html":          `{{define "content"}}<h1>Search Results</h1>{{if .Query}}{{range .Results}}<div>{{.Title}}</div><p>{{.Kind}}: {{.Snippet}}</p>{{end}}{{end}}{{end}}`,
 	"package_content.html": `{{define "content"}}<h1>Package {{.Title}}</h1>{{range .Files}}<div>{{.Name}}</div>{{end}}{{end}}`,
+	"guide.html":           `{{define "content"}}<h1>{{.Title}}</h1>{{with .Guide}}{{range .TOC}}<li>{{.Level}} {{.ID}} {{.Text}}</li>{{end}}{{.HTML}}{{end}}{{range .Guides}}<div>{{.Path}}: {{.Title}}</div>{{end}}{{end}}`,
+	"language.html":        `{{define "content"}}<h1>{{.Title}}</h1>{{with .Reference}}{{range .Keywords}}<div>{{.Keyword}} {{.Token}}{{range .Rules}} {{.}}{{end}}</div>{{end}}{{range .Sections}}<h2>{{.Title}}</h2>{{range .Rules}}<pre>{{.Syntax}}</pre>{{end}}{{end}}{{end}}{{end}}`,
+	"playground.html":      `{{define "content"}}<h1>{{.Example.Title}}</h1><div>{{.Example.Guide}}</div><pre>{{.Example.Source}}</pre><pre>{{.Example.Messages}}</pre>{{end}}`,
 }
 
 func setupTestTemplates(t *testing.T) string {
@@ -47,6 +51,15 @@ func setupTestTemplates(t *testing.T) string {
 	return tmpDir
 }
 
+// testOptions configures a server to document the packages below tmpDir
+// using the test templates
+func testOptions(tmpDir string) []docserver.Option {
+	return []docserver.Option{
+		docserver.WithRootDir(tmpDir),
+		docserver.WithTemplateDir(filepath.Join(tmpDir, "internal", "docserver", "templates")),
+	}
+}
+
 func TestTemplateRendering(t *testing.T) {
 	// Setup test templates
 	tmpDir := setupTestTemplates(t)
@@ -56,29 +69,59 @@ func TestTemplateRendering(t *testing.T) {
 		}
 	}()
 
-	// Change to the temp directory for the test
-	originalWd, err := os.Getwd()
+	// Create test package structure
+	err := os.MkdirAll(filepath.Join(tmpDir, "internal", "docserver"), 0o755)
 	require.NoError(t, err)
-	err = os.Chdir(tmpDir)
+	err = os.WriteFile(
+		filepath.Join(tmpDir, "internal", "docserver", "doc.go"),
+		[]byte("// Package docserver implements the documentation server.\npackage docserver\n"),
+		0o644,
+	)
 	require.NoError(t, err)
-	defer func() {
-		if err := os.Chdir(originalWd); err != nil {
-			t.Errorf("Failed to change back to original directory: %v", err)
-		}
-	}()
+	err = os.WriteFile(
+		filepath.Join(tmpDir, "internal", "docserver", "server.go"),
+		[]byte(`package docserver
 
-	// Create test package structure
-	err = os.MkdirAll(filepath.Join(tmpDir, "internal", "docserver"), 0o755)
+import "net/http"
+
+// Server serves the documentation. See [New] and [net/http.Handler].
+type Server struct {
+	// Mux routes requests
+	Mux     *http.ServeMux
+	private int
+}
+
+// New creates a Server
+func New() *Server { return &Server{} }
+
+// Handler returns the Server's handler
+func (s *Server) Handler() http.Handler { return s.Mux }
+
+func internal() {}
+`),
+		0o644,
+	)
+	require.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(tmpDir, "internal", "runtime", "engine"), 0o755)
 	require.NoError(t, err)
 	err = os.WriteFile(
-		filepath.Join(tmpDir, "internal", "docserver", "doc.go"),
-		[]byte("// Package docserver implements the documentation server."),
+		filepath.Join(tmpDir, "internal", "runtime", "engine", "engine.go"),
+		[]byte("// Package engine runs flows.\npackage engine\n"),
+		0o644,
+	)
+	require.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(tmpDir, "internal", "flows"), 0o755)
+	require.NoError(t, err)
+	err = os.WriteFile(
+		filepath.Join(tmpDir, "internal", "flows", "orders.flow"),
+		[]byte(`flow "orders" { node "read" { type: "Passthrough" } }`),
 		0o644,
 	)
 	require.NoError(t, err)
 
 	log := logger.New()
-	server := docserver.New(log)
+	server, err := docserver.New(log, testOptions(tmpDir)...)
+	require.NoError(t, err)
 
 	tests := []struct {
 		name           string
@@ -100,6 +143,9 @@ func TestTemplateRendering(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedBody: []string{
 				"Packages",
+				"<div>docserver: Package docserver implements the documentation server.</div>",
+				// Directories without Go files list the packages below them
+				"<div>runtime: </div><div>runtime/engine: Package engine runs flows.</div>",
 			},
 		},
 		{
@@ -108,6 +154,13 @@ func TestTemplateRendering(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedBody: []string{
 				"Package docserver",
+				"<p>Package docserver implements the documentation server.</p>",
+				`<h3 id="Server">Server</h3>`,
+				"// Mux routes requests\n\tMux *<a href=\"https://pkg.go.dev/net/http#ServeMux\">http.ServeMux</a>",
+				"// contains filtered or unexported fields",
+				`See <a href="#New">New</a> and <a href="https://pkg.go.dev/net/http#Handler">net/http.Handler</a>.`,
+				`func New() *<a href="#Server">Server</a>`,
+				`<pre id="Server.Handler">func (s *<a href="#Server">Server</a>) Handler() <a href="https://pkg.go.dev/net/http#Handler">http.Handler</a></pre>`,
 			},
 		},
 		{
@@ -118,6 +171,37 @@ func TestTemplateRendering(t *testing.T) {
 				"Source Code",
 			},
 		},
+		{
+			name:           "Flow Source",
+			path:           "/src/flows/orders.flow",
+			expectedStatus: http.StatusOK,
+			expectedBody: []string{
+				`<svg xmlns="http://www.w3.org/2000/svg"`,
+				`<g id="node-read">`,
+				"<pre>flow &#34;orders&#34;",
+				`<span id="L1"><span class="keyword">flow</span> <span class="string">&#34;orders&#34;</span>`,
+			},
+		},
+		{
+			name:           "Nested Package Search",
+			path:           "/search?q=runs",
+			expectedStatus: http.StatusOK,
+			expectedBody: []string{
+				"<div>runtime/engine</div>",
+			},
+		},
+		{
+			name:           "Language Reference",
+			path:           "/docs/language",
+			expectedStatus: http.StatusOK,
+			expectedBody: []string{
+				"<h1>Flow Language Reference</h1>",
+				"<div>flow FLOW flow</div>",
+				"<div>inputs INPUTS section assignment</div>",
+				"<h2>Literals</h2>",
+				`<pre>flow = &#34;flow&#34; <a href="#rule-string">string</a> <a href="#rule-block">block</a> .</pre>`,
+			},
+		},
 		{
 			name:           "Search Page",
 			path:           "/search?q=flow",
@@ -153,3 +237,470 @@ func TestTemplateRendering(t *testing.T) {
 		})
 	}
 }
+
+func TestSearch(t *testing.T) {
+	tmpDir := setupTestTemplates(t)
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temp dir: %v", err)
+		}
+	}()
+
+	engineDir := filepath.Join(tmpDir, "internal", "runtime", "engine")
+	require.NoError(t, os.MkdirAll(engineDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(engineDir, "engine.go"), []byte(`// Package engine runs flows.
+package engine
+
+// Engine executes the nodes of a flow graph.
+type Engine struct{}
+
+// LoadSource parses flow source into a graph.
+func LoadSource(src string) error { return nil }
+
+// Start starts the engine. Stopped engines cannot be restarted.
+func (e *Engine) Start() error { return nil }
+
+// MaxNodes limits the size of a graph.
+const MaxNodes = 100
+`), 0o644))
+	flowsDir := filepath.Join(tmpDir, "internal", "flows")
+	require.NoError(t, os.MkdirAll(flowsDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(flowsDir, "orders.flow"),
+		[]byte(`flow "orders" { node "read" { type: "Passthrough" } }`), 0o644))
+
+	server, err := docserver.New(logger.New(), testOptions(tmpDir)...)
+	require.NoError(t, err)
+	search := func(query string) string {
+		req := httptest.NewRequest("GET", "/search?"+query, http.NoBody)
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	t.Run("Identifiers", func(t *testing.T) {
+		body := search("q=LoadSource")
+		require.Contains(t, body, "<div>LoadSource</div><p>func: <mark>LoadSource</mark> parses flow <mark>source</mark> into a graph.</p>")
+	})
+
+	t.Run("Camel Case Parts", func(t *testing.T) {
+		body := search("q=load")
+		require.Contains(t, body, "<div>LoadSource</div>")
+	})
+
+	t.Run("Doc Comments", func(t *testing.T) {
+		body := search("q=stopped+engines")
+		require.Contains(t, body, "<div>Engine.Start</div><p>method: Start starts the engine. <mark>Stopped</mark> <mark>engines</mark> cannot be restarted.</p>")
+		require.NotContains(t, body, "<div>runtime/engine</div>")
+	})
+
+	t.Run("Ranking", func(t *testing.T) {
+		body := search("q=engine")
+		require.Less(t, strings.Index(body, "<div>Engine</div>"), strings.Index(body, "<div>Engine.Start</div>"))
+	})
+
+	t.Run("Kind Filter", func(t *testing.T) {
+		body := search("q=graph&kind=const")
+		require.Contains(t, body, "<div>MaxNodes</div>")
+		require.NotContains(t, body, "<div>Engine</div>")
+		require.NotContains(t, body, "<div>LoadSource</div>")
+
+		body = search("q=graph&kind=const&kind=type")
+		require.Contains(t, body, "<div>MaxNodes</div>")
+		require.Contains(t, body, "<div>Engine</div>")
+	})
+
+	t.Run("Flow Files", func(t *testing.T) {
+		body := search("q=passthrough")
+		require.Contains(t, body, "<div>flows/orders.flow</div><p>flow: ")
+	})
+
+	t.Run("Rebuilt On Change", func(t *testing.T) {
+		require.NotContains(t, search("q=returns"), "<div>flows/returns.flow</div>")
+		require.NoError(t, os.WriteFile(filepath.Join(flowsDir, "returns.flow"),
+			[]byte(`flow "returns" { node "read" { type: "Passthrough" } }`), 0o644))
+		require.Contains(t, search("q=returns"), "<div>flows/returns.flow</div>")
+	})
+}
+
+func TestEmbeddedTemplates(t *testing.T) {
+	tmpDir := t.TempDir()
+	engineDir := filepath.Join(tmpDir, "internal", "runtime", "engine")
+	require.NoError(t, os.MkdirAll(engineDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(engineDir, "engine.go"),
+		[]byte("// Package engine runs flows.\npackage engine\n"), 0o644))
+
+	server, err := docserver.New(logger.New(), docserver.WithRootDir(tmpDir))
+	require.NoError(t, err)
+
+	for _, path := range []string{"/docs", "/docs/pkg", "/docs/pkg/runtime/engine", "/docs/src", "/docs/search?q=engine"} {
+		req := httptest.NewRequest("GET", path, http.NoBody)
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, path)
+		require.Contains(t, w.Body.String(), "runtime/engine", path)
+	}
+
+	req := httptest.NewRequest("GET", "/docs/language", http.NoBody)
+	w := httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `<section id="rule-node"`)
+
+	req = httptest.NewRequest("GET", "/docs/playground", http.NoBody)
+	w = httptest.NewRecorder()
+	server.Routes().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `<textarea id="source"`)
+}
+
+func TestTemplateDirErrors(t *testing.T) {
+	_, err := docserver.New(logger.New(), docserver.WithTemplateDir(filepath.Join(t.TempDir(), "missing")))
+	require.ErrorContains(t, err, "failed to parse template")
+}
+
+func TestGuides(t *testing.T) {
+	tmpDir := setupTestTemplates(t)
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temp dir: %v", err)
+		}
+	}()
+
+	docsDir := filepath.Join(tmpDir, "docs")
+	require.NoError(t, os.MkdirAll(filepath.Join(docsDir, "adr"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(docsDir, "writing-flows.md"), []byte(`# Writing flows
+
+Flows are pipelines of nodes. See [the decision](adr/0001-pipelines.md#context).
+
+## Nodes
+
+`+"```flow\nnode \"read\" { type: \"Passthrough\" }\n```"+`
+
+### Connections
+
+![diagram](orders.svg)
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(docsDir, "adr", "0001-pipelines.md"),
+		[]byte("# Pipelines by default\n\n## Context\n\nNodes connect to [the previous node](../writing-flows.md).\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(docsDir, "orders.svg"), []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(docsDir, "docs.go"), []byte("package docs\n"), 0o644))
+
+	server, err := docserver.New(logger.New(), testOptions(tmpDir)...)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedBody   []string
+	}{
+		{
+			name:           "Guide List",
+			path:           "/docs/guide",
+			expectedStatus: http.StatusOK,
+			expectedBody: []string{
+				"<h1>Guides</h1><div>writing-flows: Writing flows</div><div>adr: adr</div>",
+			},
+		},
+		{
+			name:           "Guide Directory",
+			path:           "/docs/guide/adr/",
+			expectedStatus: http.StatusOK,
+			expectedBody: []string{
+				"<div>adr/0001-pipelines: Pipelines by default</div>",
+			},
+		},
+		{
+			name:           "Guide",
+			path:           "/docs/guide/writing-flows",
+			expectedStatus: http.StatusOK,
+			expectedBody: []string{
+				"<h1>Writing flows</h1>",
+				"<li>2 nodes Nodes</li><li>3 connections Connections</li>",
+				`<a href="/docs/guide/adr/0001-pipelines#context">the decision</a>`,
+				`<code class="language-flow"><span class="keyword">node</span>`,
+				`<img src="/docs/guide/orders.svg" alt="diagram">`,
+			},
+		},
+		{
+			name:           "Relative Parent Link",
+			path:           "/docs/guide/adr/0001-pipelines.md",
+			expectedStatus: http.StatusOK,
+			expectedBody: []string{
+				`<a href="/docs/guide/writing-flows">the previous node</a>`,
+			},
+		},
+		{
+			name:           "Guide Asset",
+			path:           "/docs/guide/orders.svg",
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{"<svg"},
+		},
+		{
+			name:           "Other Files",
+			path:           "/docs/guide/docs.go",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Missing Guide",
+			path:           "/docs/guide/missing",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Outside Guide Directory",
+			path:           "/docs/guide/../internal/docserver/templates/base",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Guide Search",
+			path:           "/docs/search?q=pipelines&kind=guide",
+			expectedStatus: http.StatusOK,
+			expectedBody: []string{
+				"<div>Pipelines by default</div><p>guide: ",
+				"<div>Writing flows</div><p>guide: ",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, http.NoBody)
+			w := httptest.NewRecorder()
+			server.Routes().ServeHTTP(w, req)
+
+			require.Equal(t, tt.expectedStatus, w.Code)
+			for _, expected := range tt.expectedBody {
+				require.Contains(t, w.Body.String(), expected)
+			}
+		})
+	}
+}
+
+func TestPlayground(t *testing.T) {
+	tmpDir := setupTestTemplates(t)
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temp dir: %v", err)
+		}
+	}()
+
+	docsDir := filepath.Join(tmpDir, "docs")
+	require.NoError(t, os.MkdirAll(docsDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(docsDir, "orders.md"), []byte("# Orders\n\n"+
+		"```flow\nflow \"orders\" {}\n```\n\n```jsonl\n{\"status\":\"paid\"}\n```\n\n"+
+		"```sh\nflow run orders.flow\n```\n\n```flow\nflow \"audit\" {}\n```\n"), 0o644))
+
+	server, err := docserver.New(logger.New(), testOptions(tmpDir)...)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedBody   []string
+	}{
+		{
+			name:           "Default Example",
+			path:           "/docs/playground",
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{"<h1>Playground</h1><div></div><pre>flow &#34;orders&#34; {"},
+		},
+		{
+			name:           "Guide Example With Messages",
+			path:           "/docs/playground?guide=orders&example=0",
+			expectedStatus: http.StatusOK,
+			expectedBody: []string{
+				"<h1>Orders</h1><div>orders</div><pre>flow &#34;orders&#34; {}</pre><pre>{&#34;status&#34;:&#34;paid&#34;}</pre>",
+			},
+		},
+		{
+			name:           "Guide Example Without Messages",
+			path:           "/playground?guide=orders&example=3",
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{"<pre>flow &#34;audit&#34; {}</pre><pre></pre>"},
+		},
+		{
+			name:           "Not A Flow Example",
+			path:           "/docs/playground?guide=orders&example=2",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Missing Example",
+			path:           "/docs/playground?guide=orders&example=4",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Missing Guide",
+			path:           "/docs/playground?guide=missing&example=0",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Outside Guide Directory",
+			path:           "/docs/playground?guide=../docs/orders&example=0",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Invalid Example",
+			path:           "/docs/playground?guide=orders&example=first",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, http.NoBody)
+			w := httptest.NewRecorder()
+			server.Routes().ServeHTTP(w, req)
+
+			require.Equal(t, tt.expectedStatus, w.Code)
+			for _, expected := range tt.expectedBody {
+				require.Contains(t, w.Body.String(), expected)
+			}
+		})
+	}
+}
+
+func TestPackageJSON(t *testing.T) {
+	tmpDir := setupTestTemplates(t)
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			t.Errorf("Failed to remove temp dir: %v", err)
+		}
+	}()
+
+	engineDir := filepath.Join(tmpDir, "internal", "runtime", "engine")
+	require.NoError(t, os.MkdirAll(filepath.Join(engineDir, "nodes"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(engineDir, "engine.go"), []byte(`// Package engine runs flows.
+package engine
+
+// Event types
+const (
+	TypeStarted = "started"
+	TypeStopped = "stopped"
+)
+
+// ErrStopped is returned by a stopped [Engine]
+var ErrStopped = error(nil)
+
+// Engine runs a flow
+type Engine struct{ nodes int }
+
+// Mode selects how an Engine runs
+type Mode int
+
+// ModeBatch processes messages in batches
+const ModeBatch Mode = 1
+
+// New creates an Engine
+func New() *Engine { return &Engine{} }
+
+// Run processes messages until input is closed
+func (e *Engine) Run(input <-chan string) error { return nil }
+
+// Version returns the engine version
+func Version() string { return "1" }
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(engineDir, "nodes", "nodes.go"),
+		[]byte("// Package nodes holds the built-in nodes.\npackage nodes\n"), 0o644))
+
+	server, err := docserver.New(logger.New(), testOptions(tmpDir)...)
+	require.NoError(t, err)
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, http.NoBody)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		server.Routes().ServeHTTP(w, req)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder, v interface{}) {
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+	}
+
+	// The package list is flat
+	var entries []docserver.PackageEntryJSON
+	decode(get("/docs/api/pkg", ""), &entries)
+	paths := []string{}
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	require.Equal(t, []string{"runtime", "runtime/engine", "runtime/engine/nodes"}, paths)
+	require.Equal(t, "flow-control/internal/runtime/engine", entries[1].ImportPath)
+	require.Equal(t, "Package engine runs flows.", entries[1].Synopsis)
+
+	var pkg docserver.PackageJSON
+	decode(get("/docs/api/pkg/runtime/engine", ""), &pkg)
+	require.Equal(t, "engine", pkg.Name)
+	require.Equal(t, "/docs/pkg/runtime/engine", pkg.URL)
+	require.Equal(t, "Package engine runs flows.\n", pkg.Doc)
+	require.Equal(t, []string{"runtime/engine/nodes"}, pkg.Subpackages)
+	require.Len(t, pkg.Consts, 1)
+	require.Equal(t, []string{"TypeStarted", "TypeStopped"}, pkg.Consts[0].Names)
+	require.Len(t, pkg.Funcs, 1)
+	require.Equal(t, "func Version() string", pkg.Funcs[0].Decl)
+	require.Len(t, pkg.Types, 2)
+	require.Equal(t, "Engine", pkg.Types[0].Name)
+	require.Equal(t, "type Engine struct {\n\t// contains filtered or unexported fields\n}", pkg.Types[0].Decl)
+	require.Equal(t, "New", pkg.Types[0].Funcs[0].Name)
+	require.Equal(t, docserver.FuncJSON{
+		Name: "Run",
+		Recv: "*Engine",
+		Decl: "func (e *Engine) Run(input <-chan string) error",
+		Doc:  "Run processes messages until input is closed\n",
+		URL:  "/docs/pkg/runtime/engine#Engine.Run",
+	}, pkg.Types[0].Methods[0])
+
+	// Single names, including methods and names grouped with their type
+	symbols := []struct {
+		name string
+		kind string
+	}{
+		{"Engine", "type"},
+		{"Engine.Run", "method"},
+		{"New", "func"},
+		{"Version", "func"},
+		{"TypeStopped", "const"},
+		{"ModeBatch", "const"},
+		{"ErrStopped", "var"},
+	}
+	for _, tt := range symbols {
+		var symbol docserver.SymbolJSON
+		decode(get("/docs/api/pkg/runtime/engine?name="+tt.name, ""), &symbol)
+		require.Equal(t, tt.kind, symbol.Kind, tt.name)
+		require.Equal(t, "flow-control/internal/runtime/engine", symbol.ImportPath)
+	}
+	// Doc links are printed as plain text
+	var symbol docserver.SymbolJSON
+	decode(get("/docs/api/pkg/runtime/engine?name=ErrStopped", ""), &symbol)
+	require.Equal(t, "ErrStopped is returned by a stopped Engine\n", symbol.Value.Doc)
+
+	// Directories without Go files list their subpackages
+	decode(get("/docs/api/pkg/runtime", ""), &pkg)
+	require.Equal(t, "runtime", pkg.Name)
+	require.Empty(t, pkg.Types)
+	require.Equal(t, []string{"runtime/engine"}, pkg.Subpackages)
+
+	// The HTML pages return the same documents to clients asking for JSON
+	decode(get("/docs/pkg/runtime/engine", "application/json"), &pkg)
+	require.Equal(t, "engine", pkg.Name)
+	decode(get("/docs/pkg", "application/json"), &entries)
+	require.Len(t, entries, 3)
+	w := get("/docs/pkg/runtime/engine", "text/html,application/xhtml+xml,application/json;q=0.9")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "<h1>Package runtime/engine</h1>")
+
+	for _, path := range []string{
+		"/docs/api/pkg/runtime/engine?name=Missing",
+		"/docs/api/pkg/runtime/engine?name=Engine.Missing",
+		"/docs/api/pkg/missing",
+		"/docs/api/pkg/../../docs",
+		"/docs/api/pkg/",
+	} {
+		require.Equal(t, http.StatusNotFound, get(path, "").Code, path)
+	}
+}
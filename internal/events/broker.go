@@ -0,0 +1,126 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// Broker carries encoded events between the instances of a cluster. Each
+// event is published to a subject of its own under a common prefix, and
+// every instance subscribed to the prefix receives it, including the one
+// that published it.
+type Broker interface {
+	// Publish sends data to the subscribers of subject
+	Publish(ctx context.Context, subject string, data []byte) error
+	// Subscribe calls deliver with the data published to every subject
+	// under prefix, one at a time
+	Subscribe(prefix string, deliver func(subject string, data []byte)) error
+	// Close flushes events already published and disconnects
+	Close() error
+}
+
+// BrokerBus is a Bus fanning events out through a Broker, so that the
+// subscribers of every instance sharing the broker see every event
+type BrokerBus struct {
+	broker  Broker
+	subject string
+	local   *MemoryBus
+	log     types.Logger
+}
+
+// NewBrokerBus relays events through broker under subject, delivering them
+// to local subscribers queueing up to buffer events each. Each event goes to
+// <subject>.<flow ID>.<type>.
+func NewBrokerBus(broker Broker, subject string, buffer int, log types.Logger) (*BrokerBus, error) {
+	if subject == "" {
+		subject = DefaultSubject
+	}
+	bus := &BrokerBus{
+		broker:  broker,
+		subject: subject,
+		local:   NewMemoryBus(buffer),
+		log:     log,
+	}
+	if err := broker.Subscribe(subject+".", bus.deliver); err != nil {
+		return nil, fmt.Errorf("failed to subscribe to events: %w", err)
+	}
+	return bus, nil
+}
+
+// Publish implements Bus.Publish. Events are stamped before they leave the
+// instance, so every instance sees the time they were published at.
+func (b *BrokerBus) Publish(ctx context.Context, event types.FlowEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if b.local.isClosed() {
+		return ErrClosed
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	subject := b.subject + "." + subjectToken(event.FlowID) + "." + subjectToken(event.Type)
+	if err := b.broker.Publish(ctx, subject, data); err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+	return nil
+}
+
+// Subscribe implements Bus.Subscribe
+func (b *BrokerBus) Subscribe(filter Filter) *Subscription {
+	return b.local.Subscribe(filter)
+}
+
+// Close implements Bus.Close. Events already published are flushed to the
+// broker first.
+func (b *BrokerBus) Close() error {
+	if b.local.isClosed() {
+		return nil
+	}
+	err := b.broker.Close()
+	b.local.Close()
+	return err
+}
+
+// deliver hands an event received from the broker to local subscribers
+func (b *BrokerBus) deliver(subject string, data []byte) {
+	var event types.FlowEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		b.log.Warn("Discarded malformed event", types.Fields{
+			"function": "deliver",
+			"subject":  subject,
+			"error":    err.Error(),
+		})
+		return
+	}
+	if err := b.local.Publish(context.Background(), event); err != nil && err != ErrClosed {
+		b.log.Error("Failed to deliver event", err, types.Fields{
+			"function": "deliver",
+			"flow_id":  event.FlowID,
+		})
+	}
+}
+
+// subjectToken makes s usable as one token of a subject
+func subjectToken(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', '?', '[', ']', '\\', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,79 @@
+/*
+Package events decouples the producers of FlowEvents from their consumers.
+Producers publish to a Bus; server-sent event streams, metrics aggregation
+and other consumers subscribe to it without knowing who publishes.
+
+MemoryBus delivers events within the process. BrokerBus relays them through
+a Broker, so every instance sharing the broker sees every event; NATSBroker
+and RedisBroker carry them over NATS subjects and Redis pub/sub channels.
+*/
+package events
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"flow-control/internal/types"
+)
+
+// Event types published for flow lifecycle changes
+const (
+	TypeFlowCreated   = "flow.created"
+	TypeFlowUpdated   = "flow.updated"
+	TypeFlowDeleted   = "flow.deleted"
+	TypeFlowStarted   = "flow.started"
+	TypeFlowStopped   = "flow.stopped"
+	TypeFlowCompleted = "flow.completed"
+	TypeFlowFailed    = "flow.failed"
+)
+
+// TypeQuotaExceeded is published when runs of a flow exceed a quota of its
+// project, and are refused or slowed down
+const TypeQuotaExceeded = "quota.exceeded"
+
+// DefaultBuffer is the number of events queued for a subscriber before
+// further events are dropped
+const DefaultBuffer = 256
+
+// ErrClosed is returned when publishing to a closed bus
+var ErrClosed = errors.New("event bus closed")
+
+// Bus distributes FlowEvents from producers to subscribers. Publish never
+// blocks on slow subscribers: events that do not fit in a subscriber's
+// buffer are dropped for that subscriber and counted.
+type Bus interface {
+	// Publish delivers event to every matching subscriber
+	Publish(ctx context.Context, event types.FlowEvent) error
+	// Subscribe registers a subscriber for the events matching filter
+	Subscribe(filter Filter) *Subscription
+	// Close ends every subscription and rejects further events
+	Close() error
+}
+
+// Filter selects events. Empty fields match everything.
+type Filter struct {
+	// FlowID only matches events of one flow
+	FlowID string
+	// Types only matches events of the listed types
+	Types []string
+}
+
+// Match reports whether event passes the filter
+func (f Filter) Match(event types.FlowEvent) bool {
+	if f.FlowID != "" && event.FlowID != f.FlowID {
+		return false
+	}
+	if len(f.Types) > 0 && !slices.Contains(f.Types, event.Type) {
+		return false
+	}
+	return true
+}
+
+var (
+	_ Bus = (*MemoryBus)(nil)
+	_ Bus = (*BrokerBus)(nil)
+
+	_ Broker = (*NATSBroker)(nil)
+	_ Broker = (*RedisBroker)(nil)
+)
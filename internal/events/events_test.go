@@ -0,0 +1,248 @@
+package events_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"flow-control/internal/events"
+	"flow-control/internal/logger"
+	"flow-control/internal/metrics"
+	"flow-control/internal/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBus(t *testing.T) {
+	ctx := context.Background()
+	bus := events.NewMemoryBus(2)
+
+	all := bus.Subscribe(events.Filter{})
+	flow := bus.Subscribe(events.Filter{FlowID: "flow-1", Types: []string{events.TypeFlowUpdated}})
+
+	require.NoError(t, bus.Publish(ctx, types.FlowEvent{FlowID: "flow-1", Type: events.TypeFlowCreated}))
+	require.NoError(t, bus.Publish(ctx, types.FlowEvent{FlowID: "flow-1", Type: events.TypeFlowUpdated}))
+	require.NoError(t, bus.Publish(ctx, types.FlowEvent{FlowID: "flow-2", Type: events.TypeFlowUpdated}))
+
+	// Subscribers only see matching events, and events are timestamped
+	event := <-flow.Events()
+	require.Equal(t, "flow-1", event.FlowID)
+	require.Equal(t, events.TypeFlowUpdated, event.Type)
+	require.False(t, event.Timestamp.IsZero())
+	require.Empty(t, flow.Events())
+	require.Zero(t, flow.Dropped())
+
+	// A full subscriber drops events instead of blocking the publisher
+	require.Len(t, all.Events(), 2)
+	require.Equal(t, uint64(1), all.Dropped())
+	require.Equal(t, events.TypeFlowCreated, (<-all.Events()).Type)
+
+	// Closed subscriptions stop receiving
+	flow.Close()
+	flow.Close()
+	_, ok := <-flow.Events()
+	require.False(t, ok)
+
+	// Closing the bus ends the remaining subscriptions
+	require.NoError(t, bus.Close())
+	require.ErrorIs(t, bus.Publish(ctx, types.FlowEvent{}), events.ErrClosed)
+	<-all.Events()
+	_, ok = <-all.Events()
+	require.False(t, ok)
+	_, ok = <-bus.Subscribe(events.Filter{}).Events()
+	require.False(t, ok)
+}
+
+func TestRecordMetrics(t *testing.T) {
+	bus := events.NewMemoryBus(0)
+	registry := metrics.NewRegistry()
+	stop := events.RecordMetrics(bus, registry)
+	defer stop()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, bus.Publish(context.Background(), types.FlowEvent{FlowID: "flow-1", Type: events.TypeFlowUpdated}))
+	}
+
+	require.Eventually(t, func() bool {
+		var out strings.Builder
+		require.NoError(t, registry.WritePrometheus(&out))
+		return strings.Contains(out.String(), `flow_events_total{flow_id="flow-1",type="flow.updated"} 3`)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRedisBus(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeRedis(t, "secret")
+
+	_, err := events.NewRedisBus(events.RedisConfig{URL: "redis://:wrong@" + server.addr}, logger.New())
+	require.ErrorContains(t, err, "WRONGPASS")
+
+	cfg := events.RedisConfig{URL: "redis://:secret@" + server.addr, Buffer: 8}
+	nodeA, err := events.NewRedisBus(cfg, logger.New())
+	require.NoError(t, err)
+	defer nodeA.Close()
+	nodeB, err := events.NewRedisBus(cfg, logger.New())
+	require.NoError(t, err)
+	defer nodeB.Close()
+
+	// Events published on one instance reach the subscribers of both,
+	// timestamped by the publisher
+	subA := nodeA.Subscribe(events.Filter{FlowID: "flow.1"})
+	subB := nodeB.Subscribe(events.Filter{})
+	require.NoError(t, nodeB.Publish(ctx, types.FlowEvent{FlowID: "flow.1", Type: events.TypeFlowStarted}))
+
+	var got [2]types.FlowEvent
+	for i, sub := range []*events.Subscription{subA, subB} {
+		select {
+		case got[i] = <-sub.Events():
+		case <-time.After(5 * time.Second):
+			t.Fatal("event not delivered")
+		}
+		require.Equal(t, "flow.1", got[i].FlowID)
+		require.Equal(t, events.TypeFlowStarted, got[i].Type)
+	}
+	require.False(t, got[0].Timestamp.IsZero())
+	require.True(t, got[0].Timestamp.Equal(got[1].Timestamp))
+
+	// Subscriptions survive the server dropping the connection
+	server.dropSubscribers()
+	require.Eventually(t, func() bool {
+		if err := nodeA.Publish(ctx, types.FlowEvent{FlowID: "flow.1", Type: events.TypeFlowStopped}); err != nil {
+			return false
+		}
+		select {
+		case event := <-subB.Events():
+			return event.Type == events.TypeFlowStopped
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}, 10*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, nodeA.Close())
+	require.ErrorIs(t, nodeA.Publish(ctx, types.FlowEvent{}), events.ErrClosed)
+}
+
+// fakeRedis serves the AUTH, PING, PUBLISH and PSUBSCRIBE commands of the
+// Redis protocol, refusing others as a server without them would
+type fakeRedis struct {
+	addr     string
+	password string
+
+	mu   sync.Mutex
+	subs map[net.Conn]string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	f := &fakeRedis{addr: ln.Addr().String(), password: password, subs: make(map[net.Conn]string)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer func() {
+		f.mu.Lock()
+		delete(f.subs, conn)
+		f.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		switch {
+		case strings.EqualFold(args[0], "AUTH"):
+			if args[len(args)-1] == f.password {
+				authed = true
+				fmt.Fprint(conn, "+OK\r\n")
+			} else {
+				fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+			}
+		case !authed:
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+		case strings.EqualFold(args[0], "PING"):
+			fmt.Fprint(conn, "+PONG\r\n")
+		case strings.EqualFold(args[0], "PSUBSCRIBE"):
+			f.subs[conn] = args[1]
+			fmt.Fprintf(conn, "*3\r\n$10\r\npsubscribe\r\n%s:1\r\n", bulk(args[1]))
+		case strings.EqualFold(args[0], "PUBLISH"):
+			n := 0
+			for sub, pattern := range f.subs {
+				if strings.HasPrefix(args[1], strings.TrimSuffix(pattern, "*")) {
+					fmt.Fprintf(sub, "*4\r\n$8\r\npmessage\r\n%s%s%s", bulk(pattern), bulk(args[1]), bulk(args[2]))
+					n++
+				}
+			}
+			fmt.Fprintf(conn, ":%d\r\n", n)
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		f.mu.Unlock()
+	}
+}
+
+// dropSubscribers closes the connections of the subscribers
+func (f *fakeRedis) dropSubscribers() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for conn := range f.subs {
+		conn.Close()
+		delete(f.subs, conn)
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("malformed command %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
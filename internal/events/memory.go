@@ -0,0 +1,139 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// Subscription receives the events matching its filter until it or its bus
+// is closed
+type Subscription struct {
+	filter  Filter
+	events  chan types.FlowEvent
+	dropped atomic.Uint64
+	bus     *MemoryBus
+}
+
+// Events returns the channel events are delivered on. It is closed when the
+// subscription ends.
+func (s *Subscription) Events() <-chan types.FlowEvent {
+	return s.events
+}
+
+// Dropped returns the number of events discarded because the subscriber
+// fell behind
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close ends the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// MemoryBus is a Bus delivering events within the process
+type MemoryBus struct {
+	buffer int
+
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// NewMemoryBus creates a bus queueing up to buffer events per subscriber.
+// A buffer of zero or less selects DefaultBuffer.
+func NewMemoryBus(buffer int) *MemoryBus {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	return &MemoryBus{
+		buffer: buffer,
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish implements Bus.Publish. Events without a timestamp are stamped
+// with the current time.
+func (b *MemoryBus) Publish(ctx context.Context, event types.FlowEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	// Sends happen under the read lock, so subscriptions cannot be closed
+	// while an event is being delivered to them
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return ErrClosed
+	}
+	for sub := range b.subs {
+		if !sub.filter.Match(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+	return nil
+}
+
+// Subscribe implements Bus.Subscribe. Subscribing to a closed bus returns a
+// subscription whose channel is already closed.
+func (b *MemoryBus) Subscribe(filter Filter) *Subscription {
+	sub := &Subscription{
+		filter: filter,
+		events: make(chan types.FlowEvent, b.buffer),
+		bus:    b,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(sub.events)
+		return sub
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Close implements Bus.Close
+func (b *MemoryBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for sub := range b.subs {
+		close(sub.events)
+	}
+	b.subs = nil
+	return nil
+}
+
+// unsubscribe removes sub and closes its channel
+func (b *MemoryBus) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	close(sub.events)
+}
+
+// isClosed reports whether the bus was closed
+func (b *MemoryBus) isClosed() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.closed
+}
@@ -0,0 +1,24 @@
+package events
+
+import (
+	"flow-control/internal/types"
+)
+
+// eventsMetric counts published events by flow and type
+const eventsMetric = "flow_events_total"
+
+// RecordMetrics subscribes to every event on bus and counts them in
+// metrics, labelled by flow and event type. It runs until the returned
+// function is called or the bus is closed.
+func RecordMetrics(bus Bus, metrics types.MetricsPort) (stop func()) {
+	sub := bus.Subscribe(Filter{})
+	go func() {
+		for event := range sub.Events() {
+			metrics.Inc(eventsMetric, 1, map[string]string{
+				"flow_id": event.FlowID,
+				"type":    event.Type,
+			})
+		}
+	}()
+	return sub.Close
+}
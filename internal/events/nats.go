@@ -0,0 +1,85 @@
+package events
+
+import (
+	"context"
+	"fmt"
+
+	"flow-control/internal/types"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultSubject is the subject prefix events are published under
+const DefaultSubject = "flowcontrol.events"
+
+// NATSConfig configures the NATS event bus
+type NATSConfig struct {
+	// URL of the NATS server, e.g. nats://localhost:4222
+	URL string
+	// Subject is the prefix events are published under, defaulting to
+	// DefaultSubject. Each event goes to <Subject>.<flow ID>.<type>.
+	Subject string
+	// Buffer is the number of events queued per local subscriber
+	Buffer int
+}
+
+// NATSBroker is a Broker relaying events through a NATS server
+type NATSBroker struct {
+	conn *nats.Conn
+}
+
+// NewNATSBroker connects to the NATS server at url
+func NewNATSBroker(url string) (*NATSBroker, error) {
+	if url == "" {
+		return nil, fmt.Errorf("nats url cannot be empty")
+	}
+	conn, err := nats.Connect(url, nats.Name("flow-control"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to nats: %w", err)
+	}
+	return &NATSBroker{conn: conn}, nil
+}
+
+// NewNATSBus creates a bus relaying events through the NATS server at
+// cfg.URL
+func NewNATSBus(cfg NATSConfig, log types.Logger) (*BrokerBus, error) {
+	broker, err := NewNATSBroker(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	bus, err := NewBrokerBus(broker, cfg.Subject, cfg.Buffer, log)
+	if err != nil {
+		broker.conn.Close()
+		return nil, err
+	}
+	return bus, nil
+}
+
+// Publish implements Broker.Publish
+func (b *NATSBroker) Publish(ctx context.Context, subject string, data []byte) error {
+	if b.conn.IsClosed() {
+		return ErrClosed
+	}
+	return b.conn.Publish(subject, data)
+}
+
+// Subscribe implements Broker.Subscribe
+func (b *NATSBroker) Subscribe(prefix string, deliver func(subject string, data []byte)) error {
+	_, err := b.conn.Subscribe(prefix+">", func(msg *nats.Msg) {
+		deliver(msg.Subject, msg.Data)
+	})
+	return err
+}
+
+// Close implements Broker.Close
+func (b *NATSBroker) Close() error {
+	if b.conn.IsClosed() {
+		return nil
+	}
+	var err error
+	if ferr := b.conn.Flush(); ferr != nil {
+		err = fmt.Errorf("failed to flush nats connection: %w", ferr)
+	}
+	b.conn.Close()
+	return err
+}
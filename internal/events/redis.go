@@ -0,0 +1,192 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"flow-control/internal/types"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisDialTimeout bounds connecting and authenticating to Redis
+const redisDialTimeout = 5 * time.Second
+
+// redisMaxBackoff caps the wait between attempts to receive from a broken
+// subscription
+const redisMaxBackoff = 5 * time.Second
+
+// RedisConfig configures the Redis event bus
+type RedisConfig struct {
+	// URL of the Redis server, e.g. redis://:password@localhost:6379.
+	// rediss:// connects over TLS.
+	URL string
+	// Channel is the prefix events are published under, defaulting to
+	// DefaultSubject. Each event goes to <Channel>.<flow ID>.<type>.
+	Channel string
+	// Buffer is the number of events queued per local subscriber
+	Buffer int
+}
+
+// RedisBroker is a Broker relaying events through Redis pub/sub. The
+// subscription reconnects on its own when the connection drops; events
+// published meanwhile are lost, as Redis pub/sub does not keep them.
+type RedisBroker struct {
+	client *redis.Client
+	log    types.Logger
+
+	// mu guards the subscription and closed
+	mu     sync.Mutex
+	pubsub *redis.PubSub
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewRedisBroker connects to the Redis server at rawURL
+func NewRedisBroker(rawURL string, log types.Logger) (*RedisBroker, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("redis url cannot be empty")
+	}
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	opts.DialTimeout = redisDialTimeout
+	client := redis.NewClient(opts)
+
+	// Connect at once so that a misconfigured server fails startup
+	ctx, cancel := context.WithTimeout(context.Background(), redisDialTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	return &RedisBroker{client: client, log: log, done: make(chan struct{})}, nil
+}
+
+// NewRedisBus creates a bus relaying events through the Redis server at
+// cfg.URL
+func NewRedisBus(cfg RedisConfig, log types.Logger) (*BrokerBus, error) {
+	broker, err := NewRedisBroker(cfg.URL, log)
+	if err != nil {
+		return nil, err
+	}
+	bus, err := NewBrokerBus(broker, cfg.Channel, cfg.Buffer, log)
+	if err != nil {
+		broker.Close()
+		return nil, err
+	}
+	return bus, nil
+}
+
+// Publish implements Broker.Publish
+func (b *RedisBroker) Publish(ctx context.Context, subject string, data []byte) error {
+	if err := b.client.Publish(ctx, subject, data).Err(); err != nil {
+		if errors.Is(err, redis.ErrClosed) {
+			return ErrClosed
+		}
+		return fmt.Errorf("failed to publish to redis: %w", err)
+	}
+	return nil
+}
+
+// Subscribe implements Broker.Subscribe. The broker supports a single
+// subscription.
+func (b *RedisBroker) Subscribe(prefix string, deliver func(subject string, data []byte)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	if b.pubsub != nil {
+		return fmt.Errorf("redis broker already subscribed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisDialTimeout)
+	defer cancel()
+	pubsub := b.client.PSubscribe(ctx, redisPatternEscape(prefix)+"*")
+	// Wait for the server to confirm the subscription, so that failures
+	// surface here
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("failed to subscribe to redis: %w", err)
+	}
+	b.pubsub = pubsub
+	b.wg.Add(1)
+	go b.receive(pubsub, deliver)
+	return nil
+}
+
+// Close implements Broker.Close. Publish waits for the server's reply, so
+// there is nothing left to flush.
+func (b *RedisBroker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	if b.pubsub != nil {
+		b.pubsub.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+	return b.client.Close()
+}
+
+// receive delivers the messages of pubsub until the broker is closed. The
+// client resubscribes when the connection drops; failures meanwhile are
+// logged and retried with backoff.
+func (b *RedisBroker) receive(pubsub *redis.PubSub, deliver func(subject string, data []byte)) {
+	defer b.wg.Done()
+	backoff := 100 * time.Millisecond
+	lost := false
+	for {
+		msg, err := pubsub.ReceiveMessage(context.Background())
+		select {
+		case <-b.done:
+			return
+		default:
+		}
+		if err == nil {
+			if lost {
+				lost = false
+				backoff = 100 * time.Millisecond
+				b.log.Info("Resubscribed to redis events", types.Fields{"function": "receive"})
+			}
+			deliver(msg.Channel, []byte(msg.Payload))
+			continue
+		}
+
+		lost = true
+		b.log.Warn("Lost redis event subscription", types.Fields{
+			"function": "receive",
+			"error":    err.Error(),
+		})
+		select {
+		case <-b.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, redisMaxBackoff)
+	}
+}
+
+// redisPatternEscape escapes the glob characters of s in a Redis pattern
+func redisPatternEscape(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -0,0 +1,167 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Console output formats
+const (
+	// FormatConsole writes human-readable lines with colored levels
+	FormatConsole = "console"
+	// FormatJSON writes the same JSON lines as the log file
+	FormatJSON = "json"
+	// FormatAuto writes console lines to terminals and JSON otherwise
+	FormatAuto = "auto"
+)
+
+// ANSI escape sequences of the level colors
+const (
+	colorReset  = "\x1b[0m"
+	colorGray   = "\x1b[90m"
+	colorBlue   = "\x1b[34m"
+	colorYellow = "\x1b[33m"
+	colorRed    = "\x1b[31m"
+)
+
+// levelColors maps upper-case level names to their colors
+var levelColors = map[string]string{
+	"DEBUG": colorGray,
+	"INFO":  colorBlue,
+	"WARN":  colorYellow,
+	"ERROR": colorRed,
+}
+
+// console writes log entries to a terminal or standard stream
+type console struct {
+	out    io.Writer
+	format string
+	color  bool
+}
+
+// newConsole resolves the console output of a configuration. It returns
+// nil when console output is off.
+func newConsole(cfg Config) *console {
+	if cfg.Format == "" {
+		return nil
+	}
+	out := cfg.Output
+	if out == nil {
+		out = os.Stdout
+	}
+
+	tty := isTerminal(out)
+	format := strings.ToLower(cfg.Format)
+	if format == FormatAuto {
+		format = FormatJSON
+		if tty {
+			format = FormatConsole
+		}
+	}
+	_, noColor := os.LookupEnv("NO_COLOR")
+	return &console{
+		out:    out,
+		format: format,
+		color:  tty && !noColor,
+	}
+}
+
+// encode formats an entry for the console. JSON entries are passed in
+// already encoded.
+func (c *console) encode(entry LogEntry, at time.Time, data []byte) []byte {
+	if c.format == FormatJSON {
+		return data
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(at.Local().Format("2006-01-02 15:04:05.000"))
+	buf.WriteByte(' ')
+	if color := levelColors[entry.Level]; c.color && color != "" {
+		buf.WriteString(color)
+		buf.WriteString(padLevel(entry.Level))
+		buf.WriteString(colorReset)
+	} else {
+		buf.WriteString(padLevel(entry.Level))
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(entry.Message)
+
+	keys := make([]string, 0, len(entry.Fields))
+	for k := range entry.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		c.writeField(&buf, k, formatValue(entry.Fields[k]))
+	}
+	if entry.Error != "" {
+		c.writeField(&buf, "error", entry.Error)
+	}
+	if entry.TraceID != "" {
+		c.writeField(&buf, "trace_id", entry.TraceID)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// writeField appends a key=value pair, dimming the key on color terminals
+func (c *console) writeField(buf *bytes.Buffer, key, value string) {
+	buf.WriteByte(' ')
+	if c.color {
+		buf.WriteString(colorGray)
+		buf.WriteString(key)
+		buf.WriteString("=")
+		buf.WriteString(colorReset)
+	} else {
+		buf.WriteString(key)
+		buf.WriteByte('=')
+	}
+	buf.WriteString(quoteValue(value))
+}
+
+// padLevel pads level names to a common width so messages line up
+func padLevel(level string) string {
+	return level + strings.Repeat(" ", 5-min(len(level), 5))
+}
+
+// formatValue formats a field value for display
+func formatValue(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case error:
+		return value.Error()
+	case nil:
+		return "<nil>"
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// quoteValue quotes values that would otherwise be ambiguous in key=value
+// output
+func quoteValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\t\n") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// isTerminal reports whether w is a character device such as a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,40 @@
+package logger
+
+import (
+	"context"
+
+	"flow-control/internal/types"
+)
+
+// Correlation fields carried in contexts
+const (
+	FieldRequestID = "request_id"
+	FieldFlowID    = "flow_id"
+	FieldNodeID    = "node_id"
+)
+
+// fieldsKey is the context key of correlation fields
+type fieldsKey struct{}
+
+// ContextWithFields returns a context carrying correlation fields, in
+// addition to any ctx already carries. Loggers from WithContext add them to
+// every entry, so code handling a request or message need not pass them
+// along by hand.
+func ContextWithFields(ctx context.Context, fields types.Fields) context.Context {
+	existing := FieldsFromContext(ctx)
+	merged := make(types.Fields, len(existing)+len(fields))
+	for k, v := range existing {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// FieldsFromContext returns the correlation fields of ctx. The result must
+// not be modified.
+func FieldsFromContext(ctx context.Context) types.Fields {
+	fields, _ := ctx.Value(fieldsKey{}).(types.Fields)
+	return fields
+}
@@ -3,22 +3,26 @@ package logger
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"flow-control/internal/types"
 
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 // Config holds logger configuration
 type Config struct {
-	// LogFile is the path to the log file
+	// LogFile is the path to the log file, or empty to write no file
 	LogFile string
 	// MaxSize is the maximum size in megabytes of the log file before it gets rotated
 	MaxSize int
@@ -30,6 +34,13 @@ type Config struct {
 	Compress bool
 	// Level is the minimum logging level
 	Level string
+	// Format selects the console output alongside the JSON log file:
+	// FormatConsole, FormatJSON, FormatAuto, or empty for no console output
+	Format string
+	// Output is where console output goes, standard output by default
+	Output io.Writer
+	// Sampling limits noisy components, keyed by their "component" field
+	Sampling map[string]SamplingRule
 }
 
 // DefaultConfig returns the default logger configuration
@@ -44,13 +55,76 @@ func DefaultConfig() Config {
 	}
 }
 
+// Option configures a logger created by New
+type Option func(*Config)
+
+// WithConfig replaces the whole configuration. Options after it adjust the
+// replacement.
+func WithConfig(config Config) Option {
+	return func(c *Config) {
+		*c = config
+	}
+}
+
+// WithLevel sets the minimum logging level
+func WithLevel(level string) Option {
+	return func(c *Config) {
+		c.Level = level
+	}
+}
+
+// WithFormat selects the console output, one of FormatConsole, FormatJSON
+// and FormatAuto
+func WithFormat(format string) Option {
+	return func(c *Config) {
+		c.Format = format
+	}
+}
+
+// WithFile writes the JSON log to path. An empty path writes no file, for
+// programs embedding Flow Control that manage their own logs.
+func WithFile(path string) Option {
+	return func(c *Config) {
+		c.LogFile = path
+	}
+}
+
+// WithRotation rotates the log file once it reaches maxSize megabytes,
+// keeping at most maxBackups old files for maxAge days. A zero maxSize
+// rotates at 100 megabytes; zero maxBackups or maxAge keep old files
+// regardless of their number or age.
+func WithRotation(maxSize, maxBackups, maxAge int, compress bool) Option {
+	return func(c *Config) {
+		c.MaxSize = maxSize
+		c.MaxBackups = maxBackups
+		c.MaxAge = maxAge
+		c.Compress = compress
+	}
+}
+
+// WithOutput sends console output to w instead of standard output
+func WithOutput(w io.Writer) Option {
+	return func(c *Config) {
+		c.Output = w
+	}
+}
+
+// WithSampling limits noisy components, keyed by their "component" field
+func WithSampling(rules map[string]SamplingRule) Option {
+	return func(c *Config) {
+		c.Sampling = rules
+	}
+}
+
 // LogEntry represents a single log entry
 type LogEntry struct {
-	Time    string                 `json:"time"`
-	Level   string                 `json:"level"`
-	Message string                 `json:"message"`
-	Error   string                 `json:"error,omitempty"`
-	Fields  map[string]interface{} `json:"fields,omitempty"`
+	Time    string       `json:"time"`
+	Level   string       `json:"level"`
+	Message string       `json:"message"`
+	Error   string       `json:"error,omitempty"`
+	Fields  types.Fields `json:"fields,omitempty"`
+	TraceID string       `json:"trace_id,omitempty"`
+	SpanID  string       `json:"span_id,omitempty"`
 }
 
 // LogQuery represents search criteria for log entries
@@ -62,92 +136,351 @@ type LogQuery struct {
 	Contains  string
 }
 
-// Logger implements structured logging with file output
+// levelRank orders log levels by severity
+var levelRank = map[types.LogLevel]int32{
+	types.LogLevelDebug: 0,
+	types.LogLevelInfo:  1,
+	types.LogLevelWarn:  2,
+	types.LogLevelError: 3,
+}
+
+// LookupLevel converts a level name to a LogLevel, reporting whether the
+// name is known. Trace maps to debug, the most verbose level.
+func LookupLevel(level string) (types.LogLevel, bool) {
+	switch strings.ToLower(level) {
+	case "trace", "debug":
+		return types.LogLevelDebug, true
+	case "info":
+		return types.LogLevelInfo, true
+	case "warn", "warning":
+		return types.LogLevelWarn, true
+	case "error":
+		return types.LogLevelError, true
+	default:
+		return "", false
+	}
+}
+
+// ParseLevel converts a configured level name to a LogLevel, mapping
+// unknown names to info
+func ParseLevel(level string) types.LogLevel {
+	if parsed, ok := LookupLevel(level); ok {
+		return parsed
+	}
+	return types.LogLevelInfo
+}
+
+// rankLevel returns the level of a rank
+func rankLevel(rank int32) types.LogLevel {
+	for level, r := range levelRank {
+		if r == rank {
+			return level
+		}
+	}
+	return types.LogLevelInfo
+}
+
+// Logger implements structured logging with file output. It satisfies
+// types.LogPort: child loggers from WithFields and WithContext share the
+// parent's output, level and hooks.
 type Logger struct {
-	config Config
-	writer *lumberjack.Logger
+	*output
+	fields  types.Fields
+	traceID string
+	spanID  string
+}
+
+// output is the state shared by a logger and its children
+type output struct {
+	config     Config
+	writer     *lumberjack.Logger
+	console    *console
+	level      atomic.Int32
+	components atomic.Pointer[map[string]int32]
+	sampler    atomic.Pointer[sampler]
+
+	mu      sync.Mutex // serializes writes
+	hooks   atomic.Pointer[[]types.LogHook]
+	streams streams
 }
 
-// New creates a new logger instance with the given configuration
-func New(config ...Config) types.Logger {
+var _ types.LogPort = (*Logger)(nil)
+
+// New creates a new logger instance. Without options it uses
+// DefaultConfig.
+func New(opts ...Option) *Logger {
 	cfg := DefaultConfig()
-	if len(config) > 0 {
-		cfg = config[0]
+	for _, opt := range opts {
+		opt(&cfg)
 	}
 
-	// Ensure log directory exists
-	if err := os.MkdirAll(filepath.Dir(cfg.LogFile), 0o755); err != nil {
-		fmt.Printf("Failed to create log directory: %v\n", err)
+	out := &output{
+		config:  cfg,
+		console: newConsole(cfg),
 	}
-
-	l := &Logger{
-		config: cfg,
-		writer: &lumberjack.Logger{
+	if cfg.LogFile != "" {
+		// Ensure log directory exists
+		if err := os.MkdirAll(filepath.Dir(cfg.LogFile), 0o755); err != nil {
+			fmt.Printf("Failed to create log directory: %v\n", err)
+		}
+		out.writer = &lumberjack.Logger{
 			Filename:   cfg.LogFile,
 			MaxSize:    cfg.MaxSize,
 			MaxBackups: cfg.MaxBackups,
 			MaxAge:     cfg.MaxAge,
 			Compress:   cfg.Compress,
-		},
+		}
 	}
+	out.level.Store(levelRank[ParseLevel(cfg.Level)])
+	out.sampler.Store(newSampler(cfg.Sampling))
 
-	return l
+	return &Logger{output: out}
 }
 
-// log writes a log entry to the file
-func (l *Logger) log(level, msg string, err error, fields types.Fields) {
-	entry := struct {
-		Time    string                 `json:"time"`
-		Level   string                 `json:"level"`
-		Message string                 `json:"message"`
-		Error   string                 `json:"error,omitempty"`
-		Fields  map[string]interface{} `json:"fields,omitempty"`
-	}{
-		Time:    time.Now().UTC().Format(time.RFC3339),
-		Level:   level,
-		Message: msg,
-		Fields:  fields,
+// enabled reports whether entries at level with fields pass the level
+// filter, using the override of their component if it has one
+func (l *Logger) enabled(level types.LogLevel, fields types.Fields) bool {
+	minimum := l.level.Load()
+	if overrides := l.components.Load(); overrides != nil {
+		if component, ok := fields["component"].(string); ok {
+			if rank, ok := (*overrides)[component]; ok {
+				minimum = rank
+			}
+		}
 	}
+	return levelRank[level] >= minimum
+}
 
-	if err != nil {
-		entry.Error = err.Error()
+// log writes a log entry to the file, streams and matching hooks
+func (l *Logger) log(level types.LogLevel, msg string, err error, fields types.Fields) {
+	if l.components.Load() == nil && levelRank[level] < l.level.Load() {
+		return // Filtered without merging fields
+	}
+
+	merged := l.merge(fields)
+	if !l.enabled(level, merged) {
+		return
 	}
 
-	data, err := json.Marshal(entry)
+	now := time.Now().UTC()
+	var errText string
 	if err != nil {
-		fmt.Printf("Failed to marshal log entry: %v\n", err)
+		errText = err.Error()
+	}
+	if s := l.sampler.Load(); s != nil {
+		component, _ := merged["component"].(string)
+		keep, suppressed := s.allow(component, level, msg, errText, now)
+		if !keep {
+			return
+		}
+		if suppressed > 0 {
+			counted := make(types.Fields, len(merged)+1)
+			for k, v := range merged {
+				counted[k] = v
+			}
+			counted["suppressed"] = suppressed
+			merged = counted
+		}
+	}
+
+	entry := LogEntry{
+		Time:    now.Format(time.RFC3339),
+		Level:   strings.ToUpper(string(level)),
+		Message: msg,
+		Fields:  merged,
+		TraceID: l.traceID,
+		SpanID:  l.spanID,
+		Error:   errText,
+	}
+
+	data, marshalErr := json.Marshal(entry)
+	if marshalErr != nil {
+		fmt.Printf("Failed to marshal log entry: %v\n", marshalErr)
+		return
+	}
+
+	data = append(data, '\n')
+	l.mu.Lock()
+	var writeErr error
+	if l.writer != nil {
+		_, writeErr = l.writer.Write(data)
+	}
+	if l.console != nil {
+		if _, err := l.console.out.Write(l.console.encode(entry, now, data)); err != nil && writeErr == nil {
+			writeErr = err
+		}
+	}
+	l.mu.Unlock()
+	if writeErr != nil {
+		fmt.Printf("Failed to write log entry: %v\n", writeErr)
+	}
+	l.streams.publish(entry)
+
+	l.fire(types.LogEntry{
+		Level:   level,
+		Message: msg,
+		Fields:  merged,
+		Time:    now,
+		Error:   err,
+		TraceID: l.traceID,
+		SpanID:  l.spanID,
+	})
+}
+
+// merge combines the logger's fields with those of a single entry, which
+// take precedence
+func (l *Logger) merge(fields types.Fields) types.Fields {
+	if len(l.fields) == 0 {
+		return fields
+	}
+	merged := make(types.Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return merged
+}
+
+// fire passes an entry to the hooks registered for its level
+func (l *Logger) fire(entry types.LogEntry) {
+	hooks := l.hooks.Load()
+	if hooks == nil {
 		return
 	}
+	for _, hook := range *hooks {
+		if !hookWants(hook, entry.Level) {
+			continue
+		}
+		if err := hook.Fire(entry); err != nil {
+			fmt.Printf("Failed to fire log hook: %v\n", err)
+		}
+	}
+}
 
-	if _, err := l.writer.Write(append(data, '\n')); err != nil {
-		fmt.Printf("Failed to write log entry: %v\n", err)
+// hookWants reports whether a hook handles a level. Hooks listing no levels
+// receive every entry.
+func hookWants(hook types.LogHook, level types.LogLevel) bool {
+	levels := hook.Levels()
+	if len(levels) == 0 {
+		return true
 	}
+	for _, l := range levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
 }
 
 // Debug logs a debug message with structured fields
 func (l *Logger) Debug(msg string, fields types.Fields) {
-	if l.config.Level == "debug" {
-		l.log("DEBUG", msg, nil, fields)
-	}
+	l.log(types.LogLevelDebug, msg, nil, fields)
 }
 
 // Info logs an info message with structured fields
 func (l *Logger) Info(msg string, fields types.Fields) {
-	if l.config.Level != "error" {
-		l.log("INFO", msg, nil, fields)
-	}
+	l.log(types.LogLevelInfo, msg, nil, fields)
 }
 
 // Error logs an error message with error and structured fields
 func (l *Logger) Error(msg string, err error, fields types.Fields) {
-	l.log("ERROR", msg, err, fields)
+	l.log(types.LogLevelError, msg, err, fields)
 }
 
 // Warn logs a warning message with structured fields
 func (l *Logger) Warn(msg string, fields types.Fields) {
-	if l.config.Level != "error" {
-		l.log("WARN", msg, nil, fields)
+	l.log(types.LogLevelWarn, msg, nil, fields)
+}
+
+// SetLevel changes the minimum level of the logger and all loggers derived
+// from the same New call
+func (l *Logger) SetLevel(level types.LogLevel) {
+	l.level.Store(levelRank[ParseLevel(string(level))])
+}
+
+// Level returns the current minimum level
+func (l *Logger) Level() types.LogLevel {
+	return rankLevel(l.level.Load())
+}
+
+// SetComponentLevels replaces the per-component minimum levels, which take
+// precedence over the logger level for entries whose "component" field
+// matches. Nil or empty levels remove all overrides.
+func (l *Logger) SetComponentLevels(levels map[string]types.LogLevel) {
+	if len(levels) == 0 {
+		l.components.Store(nil)
+		return
+	}
+	ranks := make(map[string]int32, len(levels))
+	for component, level := range levels {
+		ranks[component] = levelRank[ParseLevel(string(level))]
+	}
+	l.components.Store(&ranks)
+}
+
+// ComponentLevels returns the per-component minimum levels
+func (l *Logger) ComponentLevels() map[string]types.LogLevel {
+	levels := make(map[string]types.LogLevel)
+	if ranks := l.components.Load(); ranks != nil {
+		for component, rank := range *ranks {
+			levels[component] = rankLevel(rank)
+		}
+	}
+	return levels
+}
+
+// AddHook registers a hook that receives every entry at the levels it
+// lists, after level filtering
+func (l *Logger) AddHook(hook types.LogHook) {
+	for {
+		old := l.hooks.Load()
+		var hooks []types.LogHook
+		if old != nil {
+			hooks = append(hooks, *old...)
+		}
+		hooks = append(hooks, hook)
+		if l.hooks.CompareAndSwap(old, &hooks) {
+			return
+		}
+	}
+}
+
+// WithFields returns a child logger that adds fields to every entry
+func (l *Logger) WithFields(fields types.Fields) types.LogPort {
+	child := *l
+	child.fields = l.merge(fields)
+	return &child
+}
+
+// WithContext returns a child logger that tags every entry with the
+// correlation fields of ctx and the trace and span IDs of its span, if any
+func (l *Logger) WithContext(ctx context.Context) types.LogPort {
+	child := *l
+	if fields := FieldsFromContext(ctx); len(fields) > 0 {
+		child.fields = l.merge(fields)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		child.traceID = sc.TraceID().String()
+		child.spanID = sc.SpanID().String()
+	}
+	return &child
+}
+
+// Close closes the log file and ends the streams of the logger. Loggers
+// derived from the same New call must not be used afterwards.
+func (l *Logger) Close() error {
+	l.CloseStreams()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.writer == nil {
+		return nil
+	}
+	if err := l.writer.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
 	}
+	return nil
 }
 
 // WithComponent creates a logger with a component field
@@ -0,0 +1,299 @@
+package logger_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"flow-control/internal/logger"
+	"flow-control/internal/types"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestLogger(t *testing.T) {
+	newLogger := func(t *testing.T, level string) *logger.Logger {
+		return logger.New(
+			logger.WithFile(filepath.Join(t.TempDir(), "test.log")),
+			logger.WithLevel(level),
+		)
+	}
+	messages := func(t *testing.T, log *logger.Logger) []string {
+		entries, err := log.ReadLogs(&logger.LogQuery{})
+		require.NoError(t, err)
+		var result []string
+		for _, entry := range entries {
+			result = append(result, entry.Level+" "+entry.Message)
+		}
+		return result
+	}
+
+	t.Run("level filtering", func(t *testing.T) {
+		log := newLogger(t, "warn")
+		log.Debug("debug", nil)
+		log.Info("info", nil)
+		log.Warn("warn", nil)
+		log.Error("error", errors.New("boom"), nil)
+		require.Equal(t, []string{"WARN warn", "ERROR error"}, messages(t, log))
+
+		log.SetLevel(types.LogLevelDebug)
+		require.Equal(t, types.LogLevelDebug, log.Level())
+		log.Debug("debug again", nil)
+		require.Contains(t, messages(t, log), "DEBUG debug again")
+	})
+
+	t.Run("trace level", func(t *testing.T) {
+		require.Equal(t, types.LogLevelDebug, logger.ParseLevel("trace"))
+		require.Equal(t, types.LogLevelInfo, logger.ParseLevel("bogus"))
+	})
+
+	t.Run("fields", func(t *testing.T) {
+		log := newLogger(t, "info")
+		child := log.WithFields(types.Fields{"component": "engine", "flow_id": "f1"})
+		child.Info("started", types.Fields{"flow_id": "f2", "node_id": "n1"})
+
+		entries, err := log.ReadLogs(&logger.LogQuery{Component: "engine"})
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		require.Equal(t, types.Fields{
+			"component": "engine",
+			"flow_id":   "f2",
+			"node_id":   "n1",
+		}, entries[0].Fields)
+	})
+
+	t.Run("context", func(t *testing.T) {
+		log := newLogger(t, "info")
+		traceID := trace.TraceID{1, 2, 3}
+		spanID := trace.SpanID{4, 5, 6}
+		ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: traceID,
+			SpanID:  spanID,
+		}))
+		ctx = logger.ContextWithFields(ctx, types.Fields{logger.FieldRequestID: "req-1"})
+		ctx = logger.ContextWithFields(ctx, types.Fields{logger.FieldFlowID: "f1"})
+		log.WithContext(ctx).Info("traced", types.Fields{"function": "handle"})
+		log.WithContext(context.Background()).Info("untraced", nil)
+
+		entries, err := log.ReadLogs(&logger.LogQuery{})
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		require.Equal(t, traceID.String(), entries[0].TraceID)
+		require.Equal(t, spanID.String(), entries[0].SpanID)
+		require.Equal(t, types.Fields{
+			"request_id": "req-1",
+			"flow_id":    "f1",
+			"function":   "handle",
+		}, entries[0].Fields)
+		require.Empty(t, entries[1].TraceID)
+	})
+
+	t.Run("without file", func(t *testing.T) {
+		var out bytes.Buffer
+		log := logger.New(logger.WithFile(""), logger.WithFormat(logger.FormatJSON), logger.WithOutput(&out))
+		log.Info("embedded", types.Fields{"component": "host"})
+		require.Contains(t, out.String(), `"fields":{"component":"host"}`)
+		require.NoError(t, log.Close())
+
+		// There is no file to read back
+		_, err := log.ReadLogs(&logger.LogQuery{})
+		require.Error(t, err)
+	})
+
+	t.Run("console", func(t *testing.T) {
+		var out bytes.Buffer
+		file := filepath.Join(t.TempDir(), "test.log")
+		log := logger.New(logger.WithFile(file), logger.WithFormat(logger.FormatAuto), logger.WithOutput(&out))
+		log.Info("piped", nil)
+		require.Contains(t, out.String(), `"message":"piped"`)
+
+		out.Reset()
+		log = logger.New(logger.WithFile(file), logger.WithFormat(logger.FormatConsole), logger.WithOutput(&out))
+		log.Warn("flow stalled", types.Fields{"flow_id": "f1", "reason": "no input"})
+		line := out.String()
+		require.Regexp(t, `^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3} WARN  flow stalled flow_id=f1 reason="no input"\n$`, line)
+		require.NotContains(t, line, "\x1b[")
+
+		entries, err := log.ReadLogs(&logger.LogQuery{})
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+	})
+
+	t.Run("sampling", func(t *testing.T) {
+		log := newLogger(t, "debug")
+		log.SetSampling(map[string]logger.SamplingRule{
+			"engine":                {DebugEvery: 10, MaxPerMinute: 3},
+			logger.DefaultComponent: {InfoEvery: 2},
+		})
+		require.Len(t, log.Sampling(), 2)
+
+		engine := log.WithFields(types.Fields{"component": "engine"})
+		for i := 0; i < 100; i++ {
+			engine.Debug("tick", types.Fields{"i": i})
+			engine.Error("send failed", errors.New("connection refused"), nil)
+			log.Info("other", nil)
+		}
+		engine.Error("send failed", errors.New("timeout"), nil)
+		log.Warn("never sampled", nil)
+
+		counts := make(map[string]int)
+		for _, m := range messages(t, log) {
+			counts[m]++
+		}
+		require.Equal(t, map[string]int{
+			"DEBUG tick":         3, // Ticks 0, 10 and 20 before the rate limit
+			"ERROR send failed":  4, // Three refused and one timeout
+			"INFO other":         50,
+			"WARN never sampled": 1,
+		}, counts)
+
+		log.SetSampling(nil)
+		require.Empty(t, log.Sampling())
+		engine.Debug("tick", nil)
+		all := messages(t, log)
+		require.Equal(t, "DEBUG tick", all[len(all)-1])
+	})
+
+	t.Run("hooks", func(t *testing.T) {
+		log := newLogger(t, "info")
+		errorsOnly := &recordingHook{levels: []types.LogLevel{types.LogLevelError}}
+		everything := &recordingHook{}
+		log.AddHook(errorsOnly)
+		log.WithFields(types.Fields{"component": "store"}).AddHook(everything)
+
+		log.Debug("filtered", nil)
+		log.Info("info", nil)
+		log.Error("failed", errors.New("boom"), types.Fields{"table": "flows"})
+
+		require.Len(t, errorsOnly.entries, 1)
+		require.Equal(t, "failed", errorsOnly.entries[0].Message)
+		require.EqualError(t, errorsOnly.entries[0].Error, "boom")
+		require.Equal(t, types.Fields{"table": "flows"}, errorsOnly.entries[0].Fields)
+		require.Len(t, everything.entries, 2)
+	})
+
+	t.Run("streams", func(t *testing.T) {
+		log := newLogger(t, "debug")
+		warnings := log.Stream(logger.StreamFilter{Level: "warn", Component: "engine"}, 1)
+		child := log.WithFields(logger.WithComponent("engine"))
+		child.Info("info", nil)
+		log.Warn("other", nil)
+		child.Warn("first", nil)
+		child.Error("second", errors.New("boom"), nil)
+
+		// The second match overflows the buffer of one
+		entry := <-warnings.Entries()
+		require.Equal(t, "first", entry.Message)
+		require.Equal(t, uint64(1), warnings.Dropped())
+
+		warnings.Close()
+		warnings.Close()
+		_, open := <-warnings.Entries()
+		require.False(t, open)
+
+		// Closing the streams ends open and new ones
+		all := log.Stream(logger.StreamFilter{}, 0)
+		log.CloseStreams()
+		_, open = <-all.Entries()
+		require.False(t, open)
+		_, open = <-log.Stream(logger.StreamFilter{}, 0).Entries()
+		require.False(t, open)
+	})
+}
+
+// recordingHook captures the entries it receives
+type recordingHook struct {
+	levels  []types.LogLevel
+	mu      sync.Mutex
+	entries []types.LogEntry
+}
+
+func (h *recordingHook) Levels() []types.LogLevel {
+	return h.levels
+}
+
+func (h *recordingHook) Fire(entry types.LogEntry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, entry)
+	return nil
+}
+
+func TestLokiHook(t *testing.T) {
+	type push struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}
+
+	var (
+		mu       sync.Mutex
+		pushes   []push
+		failures = 1 // The first push fails and must be retried
+	)
+	loki := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/loki/api/v1/push", r.URL.Path)
+		require.Equal(t, "tenant-1", r.Header.Get("X-Scope-OrgID"))
+
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			http.Error(w, "ingester unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var p push
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&p))
+		pushes = append(pushes, p)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer loki.Close()
+
+	hook, err := logger.NewLokiHook(logger.LokiConfig{
+		URL:           loki.URL,
+		TenantID:      "tenant-1",
+		Labels:        map[string]string{"app": "flow-control"},
+		Levels:        []types.LogLevel{types.LogLevelInfo, types.LogLevelError},
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+		RetryBackoff:  time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	log := logger.New(logger.WithFile(filepath.Join(t.TempDir(), "loki.log")), logger.WithLevel("debug"))
+	log.AddHook(hook)
+
+	log.Debug("not shipped", nil)
+	log.Info("first", types.Fields{"flow_id": "f1"})
+	log.Error("second", errors.New("boom"), nil)
+	log.Info("third", nil)
+	require.NoError(t, hook.Close(context.Background()))
+
+	// Two full batches, one retried, and the remainder flushed on close
+	lines := make(map[string][]string)
+	for _, p := range pushes {
+		for _, stream := range p.Streams {
+			require.Equal(t, "flow-control", stream.Stream["app"])
+			for _, value := range stream.Values {
+				lines[stream.Stream["level"]] = append(lines[stream.Stream["level"]], value[1])
+			}
+		}
+	}
+	require.Len(t, pushes, 2)
+	require.Len(t, lines["info"], 2)
+	require.JSONEq(t, `{"message": "first", "fields": {"flow_id": "f1"}}`, lines["info"][0])
+	require.JSONEq(t, `{"message": "second", "error": "boom"}`, lines["error"][0])
+
+	// Entries after close are dropped and counted
+	log.Info("late", nil)
+	require.Equal(t, int64(1), hook.Dropped())
+}
@@ -0,0 +1,310 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// lokiPushPath is the path of Loki's push API
+const lokiPushPath = "/loki/api/v1/push"
+
+// LokiConfig configures a LokiHook. Zero values select the defaults.
+type LokiConfig struct {
+	// URL is the base URL of the Loki server, e.g. http://localhost:3100
+	URL string
+	// TenantID is sent as X-Scope-OrgID for multi-tenant Loki
+	TenantID string
+	// Labels are attached to every stream, next to a level label
+	Labels map[string]string
+	// Levels are the levels shipped; empty means all
+	Levels []types.LogLevel
+	// BatchSize is the most entries sent in one push, 500 by default
+	BatchSize int
+	// FlushInterval is the longest an entry waits for a batch to fill, one
+	// second by default
+	FlushInterval time.Duration
+	// QueueSize is the most entries buffered while pushes are slow, 10000
+	// by default. Entries arriving at a full queue are dropped and counted.
+	QueueSize int
+	// MaxRetries is how often a failed push is retried, 5 by default;
+	// negative values disable retries
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, doubling for each
+	// further one, 500ms by default
+	RetryBackoff time.Duration
+	// Client sends the pushes, a client with a 10s timeout by default
+	Client *http.Client
+}
+
+// LokiHook is a LogHook shipping entries to Grafana Loki. Fire never
+// blocks the logger: entries are queued and pushed in batches by a
+// background goroutine, failed pushes are retried with exponential backoff,
+// and entries are dropped rather than queued without bound when Loki falls
+// behind. The number dropped is reported to Loki once it catches up.
+type LokiHook struct {
+	config  LokiConfig
+	queue   chan types.LogEntry
+	dropped atomic.Int64
+
+	closeOnce sync.Once
+	closing   chan struct{}
+	done      chan struct{}
+}
+
+// NewLokiHook creates a hook pushing to Loki and starts its sender. Close
+// the hook to flush queued entries.
+func NewLokiHook(config LokiConfig) (*LokiHook, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("loki url cannot be empty")
+	}
+	config.URL = strings.TrimSuffix(config.URL, "/")
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 10000
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	} else if config.MaxRetries == 0 {
+		config.MaxRetries = 5
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = 500 * time.Millisecond
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	config.Labels = maps.Clone(config.Labels)
+
+	h := &LokiHook{
+		config:  config,
+		queue:   make(chan types.LogEntry, config.QueueSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go h.run()
+	return h, nil
+}
+
+// Levels implements LogHook.Levels
+func (h *LokiHook) Levels() []types.LogLevel {
+	return h.config.Levels
+}
+
+// Fire implements LogHook.Fire, queueing the entry without blocking
+func (h *LokiHook) Fire(entry types.LogEntry) error {
+	select {
+	case <-h.closing:
+		h.dropped.Add(1)
+		return nil
+	default:
+	}
+
+	select {
+	case h.queue <- entry:
+	default:
+		h.dropped.Add(1)
+	}
+	return nil
+}
+
+// Dropped returns the number of entries dropped and not yet reported to
+// Loki
+func (h *LokiHook) Dropped() int64 {
+	return h.dropped.Load()
+}
+
+// Close stops accepting entries and pushes those queued, waiting until ctx
+// is done at the latest
+func (h *LokiHook) Close(ctx context.Context) error {
+	h.closeOnce.Do(func() { close(h.closing) })
+	select {
+	case <-h.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to flush log entries to loki: %w", ctx.Err())
+	}
+}
+
+// run batches queued entries until the hook is closed
+func (h *LokiHook) run() {
+	defer close(h.done)
+
+	ticker := time.NewTicker(h.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]types.LogEntry, 0, h.config.BatchSize)
+	flush := func() {
+		if dropped := h.dropped.Swap(0); dropped > 0 {
+			batch = append(batch, types.LogEntry{
+				Level:   types.LogLevelWarn,
+				Message: "Dropped log entries while loki was behind",
+				Fields:  types.Fields{"dropped": dropped},
+				Time:    time.Now().UTC(),
+			})
+		}
+		if len(batch) == 0 {
+			return
+		}
+		h.push(batch)
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case entry := <-h.queue:
+			batch = append(batch, entry)
+			if len(batch) >= h.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-h.closing:
+			for {
+				select {
+				case entry := <-h.queue:
+					batch = append(batch, entry)
+					if len(batch) >= h.config.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// push sends a batch, retrying failures that may be temporary
+func (h *LokiHook) push(batch []types.LogEntry) {
+	body, err := h.encode(batch)
+	if err != nil {
+		fmt.Printf("Failed to encode log entries for loki: %v\n", err)
+		return
+	}
+
+	backoff := h.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := h.send(body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= h.config.MaxRetries {
+			fmt.Printf("Failed to push %d log entries to loki: %v\n", len(batch), err)
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-h.closing:
+			// Still retry while closing, but without waiting long
+			time.Sleep(min(backoff, 100*time.Millisecond))
+		}
+		backoff *= 2
+	}
+}
+
+// send performs one push, reporting whether a failure is worth retrying
+func (h *LokiHook) send(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, h.config.URL+lokiPushPath, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create push request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.config.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", h.config.TenantID)
+	}
+
+	resp, err := h.config.Client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to send push request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("loki responded %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+	default:
+		return false, fmt.Errorf("loki rejected push with %d: %s", resp.StatusCode, bytes.TrimSpace(message))
+	}
+}
+
+// lokiStream is a stream of Loki's push API
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// lokiLine is the JSON line of one entry
+type lokiLine struct {
+	Message string                 `json:"message"`
+	Error   string                 `json:"error,omitempty"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+	TraceID string                 `json:"trace_id,omitempty"`
+	SpanID  string                 `json:"span_id,omitempty"`
+}
+
+// encode builds a push request body with one stream per level
+func (h *LokiHook) encode(batch []types.LogEntry) ([]byte, error) {
+	streams := make(map[types.LogLevel]*lokiStream)
+	var order []types.LogLevel
+	for _, entry := range batch {
+		stream, ok := streams[entry.Level]
+		if !ok {
+			labels := maps.Clone(h.config.Labels)
+			if labels == nil {
+				labels = make(map[string]string, 1)
+			}
+			labels["level"] = string(entry.Level)
+			stream = &lokiStream{Stream: labels}
+			streams[entry.Level] = stream
+			order = append(order, entry.Level)
+		}
+
+		line := lokiLine{
+			Message: entry.Message,
+			Fields:  entry.Fields,
+			TraceID: entry.TraceID,
+			SpanID:  entry.SpanID,
+		}
+		if entry.Error != nil {
+			line.Error = entry.Error.Error()
+		}
+		data, err := json.Marshal(line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal log line: %w", err)
+		}
+		stream.Values = append(stream.Values, [2]string{
+			strconv.FormatInt(entry.Time.UnixNano(), 10),
+			string(data),
+		})
+	}
+
+	payload := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, level := range order {
+		payload.Streams = append(payload.Streams, streams[level])
+	}
+	return json.Marshal(payload)
+}
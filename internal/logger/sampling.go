@@ -0,0 +1,157 @@
+package logger
+
+import (
+	"maps"
+	"sync"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// DefaultComponent keys the sampling rule of components without their own
+const DefaultComponent = "*"
+
+// rateWindow is the period MaxPerMinute counts entries over
+const rateWindow = time.Minute
+
+// SamplingRule limits the entries a component logs, so a hot flow cannot
+// fill the disk. Zero values disable each limit.
+type SamplingRule struct {
+	// DebugEvery keeps one in every DebugEvery debug entries
+	DebugEvery int `json:"debug_every"`
+	// InfoEvery keeps one in every InfoEvery info entries
+	InfoEvery int `json:"info_every"`
+	// MaxPerMinute caps identical entries, those with the same level,
+	// message and error, per minute
+	MaxPerMinute int `json:"max_per_minute"`
+}
+
+// every returns the 1-in-N sampling rate of a level
+func (r SamplingRule) every(level types.LogLevel) int {
+	switch level {
+	case types.LogLevelDebug:
+		return r.DebugEvery
+	case types.LogLevelInfo:
+		return r.InfoEvery
+	default:
+		return 0
+	}
+}
+
+// counterKey identifies a 1-in-N sampling counter
+type counterKey struct {
+	component string
+	level     types.LogLevel
+}
+
+// windowKey identifies a set of identical entries
+type windowKey struct {
+	component string
+	level     types.LogLevel
+	message   string
+	err       string
+}
+
+// window counts identical entries within one rate window
+type window struct {
+	start   time.Time
+	count   int
+	dropped int
+}
+
+// sampler applies sampling rules to log entries
+type sampler struct {
+	rules map[string]SamplingRule
+
+	mu       sync.Mutex
+	counters map[counterKey]uint64
+	windows  map[windowKey]*window
+	swept    time.Time
+}
+
+// newSampler creates a sampler for rules, or returns nil if there are none
+func newSampler(rules map[string]SamplingRule) *sampler {
+	if len(rules) == 0 {
+		return nil
+	}
+	return &sampler{
+		rules:    maps.Clone(rules),
+		counters: make(map[counterKey]uint64),
+		windows:  make(map[windowKey]*window),
+	}
+}
+
+// allow reports whether an entry is kept. The first entry kept after a rate
+// window closes reports how many identical entries that window dropped.
+func (s *sampler) allow(component string, level types.LogLevel, msg, errText string, now time.Time) (bool, int) {
+	rule, ok := s.rules[component]
+	if !ok {
+		if rule, ok = s.rules[DefaultComponent]; !ok {
+			return true, 0
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if every := rule.every(level); every > 1 {
+		key := counterKey{component: component, level: level}
+		n := s.counters[key]
+		s.counters[key] = n + 1
+		if n%uint64(every) != 0 {
+			return false, 0
+		}
+	}
+
+	if rule.MaxPerMinute <= 0 {
+		return true, 0
+	}
+	s.sweep(now)
+	key := windowKey{component: component, level: level, message: msg, err: errText}
+	w := s.windows[key]
+	if w == nil || now.Sub(w.start) >= rateWindow {
+		suppressed := 0
+		if w != nil {
+			suppressed = w.dropped
+		}
+		s.windows[key] = &window{start: now, count: 1}
+		return true, suppressed
+	}
+	if w.count >= rule.MaxPerMinute {
+		w.dropped++
+		return false, 0
+	}
+	w.count++
+	return true, 0
+}
+
+// sweep forgets windows that closed without dropping entries, at most once
+// per rate window, so one-off messages do not accumulate
+func (s *sampler) sweep(now time.Time) {
+	if now.Sub(s.swept) < rateWindow {
+		return
+	}
+	s.swept = now
+	for key, w := range s.windows {
+		if now.Sub(w.start) >= rateWindow && w.dropped == 0 {
+			delete(s.windows, key)
+		}
+	}
+}
+
+// SetSampling replaces the sampling rules of the logger and all loggers
+// derived from the same New call. Rules are keyed by the entries'
+// "component" field; the DefaultComponent rule covers the rest. Counters
+// start over, and nil or empty rules turn sampling off.
+func (l *Logger) SetSampling(rules map[string]SamplingRule) {
+	l.sampler.Store(newSampler(rules))
+}
+
+// Sampling returns the current sampling rules
+func (l *Logger) Sampling() map[string]SamplingRule {
+	s := l.sampler.Load()
+	if s == nil {
+		return map[string]SamplingRule{}
+	}
+	return maps.Clone(s.rules)
+}
@@ -0,0 +1,141 @@
+package logger
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultStreamBuffer is the number of entries queued for a stream that
+// falls behind before new ones are dropped
+const DefaultStreamBuffer = 256
+
+// StreamFilter selects the entries delivered to a stream
+type StreamFilter struct {
+	// Level is the minimum level of entries; all levels when empty
+	Level string
+	// Component only matches entries of this component when set
+	Component string
+}
+
+// Match reports whether an entry passes the filter
+func (f StreamFilter) Match(entry LogEntry) bool {
+	if f.Level != "" && levelRank[ParseLevel(entry.Level)] < levelRank[ParseLevel(f.Level)] {
+		return false
+	}
+	if f.Component != "" {
+		component, ok := entry.Fields["component"].(string)
+		if !ok || !strings.EqualFold(component, f.Component) {
+			return false
+		}
+	}
+	return true
+}
+
+// Stream receives the entries written by a logger that match its filter,
+// until it or the logger is closed
+type Stream struct {
+	filter  StreamFilter
+	entries chan LogEntry
+	dropped atomic.Uint64
+	streams *streams
+}
+
+// Entries returns the channel entries are delivered on. It is closed when
+// the stream ends.
+func (s *Stream) Entries() <-chan LogEntry {
+	return s.entries
+}
+
+// Dropped returns the number of entries discarded because the reader fell
+// behind
+func (s *Stream) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close ends the stream. It is safe to call more than once.
+func (s *Stream) Close() {
+	s.streams.remove(s)
+}
+
+// streams are the open streams of a logger
+type streams struct {
+	mu     sync.RWMutex
+	open   map[*Stream]struct{}
+	closed bool
+}
+
+// Stream follows the entries the logger writes from now on that match
+// filter, after level filtering and sampling. Up to buffer entries are
+// queued for a slow reader; a buffer of zero or less selects
+// DefaultStreamBuffer. The stream of a closed logger is already closed.
+func (l *Logger) Stream(filter StreamFilter, buffer int) *Stream {
+	if buffer <= 0 {
+		buffer = DefaultStreamBuffer
+	}
+	s := &Stream{
+		filter:  filter,
+		entries: make(chan LogEntry, buffer),
+		streams: &l.streams,
+	}
+
+	l.streams.mu.Lock()
+	defer l.streams.mu.Unlock()
+	if l.streams.closed {
+		close(s.entries)
+		return s
+	}
+	if l.streams.open == nil {
+		l.streams.open = make(map[*Stream]struct{})
+	}
+	l.streams.open[s] = struct{}{}
+	return s
+}
+
+// CloseStreams ends the open streams of the logger and closes new ones
+// right away, so that a server can shut down while readers follow its log
+func (l *Logger) CloseStreams() {
+	l.streams.close()
+}
+
+// publish delivers an entry to the matching streams without waiting for
+// their readers
+func (s *streams) publish(entry LogEntry) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for stream := range s.open {
+		if !stream.filter.Match(entry) {
+			continue
+		}
+		select {
+		case stream.entries <- entry:
+		default:
+			stream.dropped.Add(1)
+		}
+	}
+}
+
+// remove closes a stream
+func (s *streams) remove(stream *Stream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.open[stream]; !ok {
+		return
+	}
+	delete(s.open, stream)
+	close(stream.entries)
+}
+
+// close ends all streams and refuses new ones
+func (s *streams) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for stream := range s.open {
+		close(stream.entries)
+	}
+	s.open = nil
+}
@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"math"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// Flow metric names, labelled by flow_id and node_id
+const (
+	FlowMessagesMetric = "flow_messages_total"
+	FlowErrorsMetric   = "flow_errors_total"
+	FlowLatencyMetric  = "flow_latency_seconds"
+)
+
+// Metric names of the payloads flows spill to disk, labelled by flow_id and
+// by the node_id of the node whose output was spilled
+const (
+	FlowSpilledMessagesMetric = "flow_spilled_messages_total"
+	FlowSpilledBytesMetric    = "flow_spilled_bytes_total"
+	// FlowSpillBytesMetric is a gauge of the spilled bytes still on disk
+	FlowSpillBytesMetric = "flow_spill_bytes"
+)
+
+// Metric names of the payloads written out of memory, labelled by flow_id,
+// by boundary, where they were written, and by the codec compressing them.
+// Their ratio is the size reduction compression achieves.
+const (
+	PayloadBytesMetric       = "payload_bytes_total"
+	PayloadStoredBytesMetric = "payload_stored_bytes_total"
+)
+
+// FlowValidationFailuresMetric counts the validation failures of the
+// messages Validate nodes quarantine, labelled by flow_id, node_id, schema
+// and path, the JSON pointer of the invalid value with array indexes
+// replaced by *
+const FlowValidationFailuresMetric = "flow_validation_failures_total"
+
+// RecordFlow records one execution of a flow or node: it counts the message,
+// counts an error when the execution failed and observes its latency
+func RecordFlow(port types.MetricsPort, m types.FlowMetrics) {
+	labels := map[string]string{"flow_id": m.FlowID}
+	if m.NodeID != "" {
+		labels["node_id"] = m.NodeID
+	}
+
+	port.Inc(FlowMessagesMetric, 1, labels)
+	if m.Error != "" || m.Status == "error" {
+		port.Inc(FlowErrorsMetric, 1, labels)
+	}
+
+	latency := time.Duration(m.Duration) * time.Millisecond
+	if latency == 0 && !m.StartTime.IsZero() && m.EndTime.After(m.StartTime) {
+		latency = m.EndTime.Sub(m.StartTime)
+	}
+	port.Observe(FlowLatencyMetric, latency.Seconds(), labels)
+}
+
+// HistogramSnapshot is the state of a histogram at one point in time
+type HistogramSnapshot struct {
+	// Buckets are the bucket upper bounds
+	Buckets []float64
+	// Counts are the cumulative observation counts of each bucket
+	Counts []uint64
+	// Count is the total number of observations
+	Count uint64
+	// Sum is the sum of all observations
+	Sum float64
+}
+
+// Sub returns the observations made between prev and s
+func (s HistogramSnapshot) Sub(prev HistogramSnapshot) HistogramSnapshot {
+	diff := HistogramSnapshot{
+		Buckets: s.Buckets,
+		Counts:  make([]uint64, len(s.Counts)),
+		Count:   s.Count - min(prev.Count, s.Count),
+		Sum:     s.Sum - prev.Sum,
+	}
+	for i, c := range s.Counts {
+		if i < len(prev.Counts) {
+			c -= min(prev.Counts[i], c)
+		}
+		diff.Counts[i] = c
+	}
+	return diff
+}
+
+// Quantile estimates the q-quantile of the observations by linear
+// interpolation within buckets, as Prometheus' histogram_quantile does.
+// It returns NaN without observations, and the highest bucket bound when
+// the quantile falls beyond it.
+func (s HistogramSnapshot) Quantile(q float64) float64 {
+	if s.Count == 0 || len(s.Buckets) == 0 {
+		return math.NaN()
+	}
+
+	rank := q * float64(s.Count)
+	lower, below := 0.0, uint64(0)
+	for i, bound := range s.Buckets {
+		if float64(s.Counts[i]) >= rank {
+			inBucket := s.Counts[i] - below
+			if inBucket == 0 {
+				return bound
+			}
+			return lower + (bound-lower)*(rank-float64(below))/float64(inBucket)
+		}
+		lower, below = bound, s.Counts[i]
+	}
+	return s.Buckets[len(s.Buckets)-1]
+}
+
+// Value returns the sum of the series of a counter or gauge whose labels
+// include every given label. It reports false if no series matches.
+func (r *Registry) Value(name string, labels map[string]string) (float64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, ok := r.families[name]
+	if !ok || f.typ == types.MetricTypeHistogram {
+		return 0, false
+	}
+	total, found := 0.0, false
+	for _, s := range f.series {
+		if matchLabels(s.labels, labels) {
+			total += s.value
+			found = true
+		}
+	}
+	return total, found
+}
+
+// Histogram returns the merged state of the series of a histogram whose
+// labels include every given label. It reports false if no series matches.
+func (r *Registry) Histogram(name string, labels map[string]string) (HistogramSnapshot, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshot := HistogramSnapshot{
+		Buckets: r.buckets,
+		Counts:  make([]uint64, len(r.buckets)),
+	}
+	f, ok := r.families[name]
+	if !ok || f.typ != types.MetricTypeHistogram {
+		return snapshot, false
+	}
+	found := false
+	for _, s := range f.series {
+		if !matchLabels(s.labels, labels) {
+			continue
+		}
+		for i, c := range s.counts {
+			snapshot.Counts[i] += c
+		}
+		snapshot.Count += s.count
+		snapshot.Sum += s.sum
+		found = true
+	}
+	return snapshot, found
+}
+
+// matchLabels reports whether labels include every label of want
+func matchLabels(labels, want map[string]string) bool {
+	for k, v := range want {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
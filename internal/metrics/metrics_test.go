@@ -0,0 +1,144 @@
+package metrics_test
+
+import (
+	"database/sql"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"flow-control/internal/metrics"
+	"flow-control/internal/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := metrics.NewRegistry()
+	var _ types.MetricsPort = registry
+
+	registry.Inc("requests_total", 1, map[string]string{"method": "GET"})
+	registry.Inc("requests_total", 2, map[string]string{"method": "GET"})
+	registry.Inc("requests_total", 1, map[string]string{"method": "POST"})
+	registry.Set("queue_depth", 7, nil)
+	registry.Inc("in_flight", 3, nil)
+	registry.Dec("in_flight", 1, nil)
+	registry.Observe("query_seconds", 0.02, map[string]string{"table": `fl"ows`})
+	registry.Observe("query_seconds", 3, map[string]string{"table": `fl"ows`})
+
+	collector := &staticCollector{metrics: []types.Metric{
+		{Name: "purged.rows", Type: types.MetricTypeCounter, Value: 42, Labels: map[string]string{"table": "audit_log"}},
+	}}
+	require.NoError(t, registry.Register(collector))
+	require.Error(t, registry.Register(collector))
+
+	var out strings.Builder
+	require.NoError(t, registry.WritePrometheus(&out))
+	require.Equal(t, `# TYPE in_flight gauge
+in_flight 2
+# TYPE query_seconds histogram
+query_seconds_bucket{le="0.005",table="fl\"ows"} 0
+query_seconds_bucket{le="0.01",table="fl\"ows"} 0
+query_seconds_bucket{le="0.025",table="fl\"ows"} 1
+query_seconds_bucket{le="0.05",table="fl\"ows"} 1
+query_seconds_bucket{le="0.1",table="fl\"ows"} 1
+query_seconds_bucket{le="0.25",table="fl\"ows"} 1
+query_seconds_bucket{le="0.5",table="fl\"ows"} 1
+query_seconds_bucket{le="1",table="fl\"ows"} 1
+query_seconds_bucket{le="2.5",table="fl\"ows"} 1
+query_seconds_bucket{le="5",table="fl\"ows"} 2
+query_seconds_bucket{le="10",table="fl\"ows"} 2
+query_seconds_bucket{le="+Inf",table="fl\"ows"} 2
+query_seconds_sum{table="fl\"ows"} 3.02
+query_seconds_count{table="fl\"ows"} 2
+# TYPE queue_depth gauge
+queue_depth 7
+# TYPE requests_total counter
+requests_total{method="GET"} 3
+requests_total{method="POST"} 1
+# HELP purged_rows Rows purged
+# TYPE purged_rows counter
+purged_rows{table="audit_log"} 42
+`, out.String())
+
+	require.NoError(t, registry.Unregister(collector))
+	require.Error(t, registry.Unregister(collector))
+}
+
+func TestProcessCollector(t *testing.T) {
+	collector := metrics.NewProcessCollector(staticPool{stats: sql.DBStats{OpenConnections: 4, InUse: 1, Idle: 3}})
+	time.Sleep(time.Millisecond)
+
+	sample := collector.Sample()
+	require.Positive(t, sample.MemoryHeap)
+	require.Positive(t, sample.MemoryUsage)
+	require.Positive(t, sample.Goroutines)
+	require.Positive(t, sample.Uptime)
+	require.GreaterOrEqual(t, sample.CPUUsage, 0.0)
+	require.Equal(t, 4, sample.DBConnections)
+	require.Equal(t, 1, sample.DBInUse)
+
+	registry := metrics.NewRegistry()
+	require.NoError(t, registry.Register(collector))
+	var out strings.Builder
+	require.NoError(t, registry.WritePrometheus(&out))
+	require.Contains(t, out.String(), "# TYPE process_goroutines gauge\n")
+	require.Contains(t, out.String(), "store_pool_idle_connections 3\n")
+	require.Contains(t, out.String(), "# TYPE store_pool_wait_total counter\n")
+}
+
+func TestFlowMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	for i := 0; i < 98; i++ {
+		metrics.RecordFlow(registry, types.FlowMetrics{FlowID: "orders", NodeID: "parse", Duration: 20})
+	}
+	metrics.RecordFlow(registry, types.FlowMetrics{FlowID: "orders", NodeID: "store", Status: "error", Duration: 4000})
+	metrics.RecordFlow(registry, types.FlowMetrics{FlowID: "billing", Status: "ok", Duration: 20})
+
+	// Values are summed over every series carrying the labels
+	messages, ok := registry.Value(metrics.FlowMessagesMetric, map[string]string{"flow_id": "orders"})
+	require.True(t, ok)
+	require.Equal(t, 99.0, messages)
+	errors, ok := registry.Value(metrics.FlowErrorsMetric, map[string]string{"flow_id": "orders"})
+	require.True(t, ok)
+	require.Equal(t, 1.0, errors)
+	_, ok = registry.Value(metrics.FlowErrorsMetric, map[string]string{"flow_id": "billing"})
+	require.False(t, ok)
+
+	// Quantiles are interpolated within buckets
+	latency, ok := registry.Histogram(metrics.FlowLatencyMetric, map[string]string{"flow_id": "orders"})
+	require.True(t, ok)
+	require.Equal(t, uint64(99), latency.Count)
+	require.InDelta(t, 0.0175, latency.Quantile(0.5), 0.001)
+	require.Equal(t, 5.0, latency.Quantile(1))
+
+	// Differences between snapshots cover the observations in between
+	metrics.RecordFlow(registry, types.FlowMetrics{FlowID: "orders", Duration: 200})
+	later, _ := registry.Histogram(metrics.FlowLatencyMetric, map[string]string{"flow_id": "orders"})
+	diff := later.Sub(latency)
+	require.Equal(t, uint64(1), diff.Count)
+	require.InDelta(t, 0.1+0.15*0.99, diff.Quantile(0.99), 0.001)
+	require.True(t, math.IsNaN(metrics.HistogramSnapshot{}.Quantile(0.99)))
+}
+
+// staticCollector reports fixed metrics
+type staticCollector struct {
+	metrics []types.Metric
+}
+
+func (c *staticCollector) Collect() ([]types.Metric, error) {
+	return c.metrics, nil
+}
+
+func (c *staticCollector) Describe() []types.MetricDesc {
+	return []types.MetricDesc{{Name: "purged.rows", Type: types.MetricTypeCounter, Description: "Rows purged"}}
+}
+
+// staticPool reports fixed pool statistics
+type staticPool struct {
+	stats sql.DBStats
+}
+
+func (p staticPool) PoolStats() sql.DBStats {
+	return p.stats
+}
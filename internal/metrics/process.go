@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// Process metric names
+const (
+	processCPUMetric        = "process_cpu_usage_percent"
+	processMemoryMetric     = "process_memory_bytes"
+	processRSSMetric        = "process_resident_memory_bytes"
+	processHeapMetric       = "process_heap_bytes"
+	processGCPauseMetric    = "process_gc_last_pause_seconds"
+	processGoroutinesMetric = "process_goroutines"
+	processUptimeMetric     = "process_uptime_seconds"
+	dbOpenMetric            = "store_pool_open_connections"
+	dbInUseMetric           = "store_pool_in_use_connections"
+	dbIdleMetric            = "store_pool_idle_connections"
+	dbWaitMetric            = "store_pool_wait_total"
+	dbWaitDurationMetric    = "store_pool_wait_seconds_total"
+)
+
+// PoolStatter reports database connection pool statistics. The SQL stores
+// implement it.
+type PoolStatter interface {
+	PoolStats() sql.DBStats
+}
+
+// ProcessCollector samples the resource usage of the server process. It is
+// a types.MetricsCollector, so registering it with a Registry exports the
+// samples to Prometheus.
+type ProcessCollector struct {
+	start time.Time
+	pool  PoolStatter
+
+	mu      sync.Mutex
+	lastAt  time.Time
+	lastCPU time.Duration
+}
+
+// NewProcessCollector creates a collector for the current process. pool may
+// be nil when there is no database.
+func NewProcessCollector(pool PoolStatter) *ProcessCollector {
+	now := time.Now()
+	return &ProcessCollector{
+		start:   now,
+		pool:    pool,
+		lastAt:  now,
+		lastCPU: processCPUTime(),
+	}
+}
+
+// Sample measures the process now. CPU usage is averaged since the previous
+// sample, as a percentage of one core.
+func (c *ProcessCollector) Sample() types.ResourceMetrics {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	now := time.Now()
+
+	metrics := types.ResourceMetrics{
+		CPUUsage:      c.cpuUsage(now),
+		MemoryUsage:   int64(mem.Sys),
+		MemoryRSS:     residentMemory(),
+		MemoryHeap:    int64(mem.HeapAlloc),
+		MemoryGCPause: time.Duration(mem.PauseNs[(mem.NumGC+255)%256]),
+		Goroutines:    runtime.NumGoroutine(),
+		Uptime:        now.Sub(c.start),
+		LastHeartbeat: now,
+	}
+	if c.pool != nil {
+		stats := c.pool.PoolStats()
+		metrics.DBConnections = stats.OpenConnections
+		metrics.DBInUse = stats.InUse
+	}
+	return metrics
+}
+
+// cpuUsage returns the CPU percentage since the previous call
+func (c *ProcessCollector) cpuUsage(now time.Time) float64 {
+	cpu := processCPUTime()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elapsed := now.Sub(c.lastAt)
+	used := cpu - c.lastCPU
+	c.lastAt, c.lastCPU = now, cpu
+	if elapsed <= 0 {
+		return 0
+	}
+	return 100 * used.Seconds() / elapsed.Seconds()
+}
+
+// Collect implements MetricsCollector.Collect
+func (c *ProcessCollector) Collect() ([]types.Metric, error) {
+	sample := c.Sample()
+	now := sample.LastHeartbeat
+	gauge := func(name string, value float64) types.Metric {
+		return types.Metric{Name: name, Type: types.MetricTypeGauge, Value: value, Time: now}
+	}
+
+	metrics := []types.Metric{
+		gauge(processCPUMetric, sample.CPUUsage),
+		gauge(processMemoryMetric, float64(sample.MemoryUsage)),
+		gauge(processRSSMetric, float64(sample.MemoryRSS)),
+		gauge(processHeapMetric, float64(sample.MemoryHeap)),
+		gauge(processGCPauseMetric, sample.MemoryGCPause.Seconds()),
+		gauge(processGoroutinesMetric, float64(sample.Goroutines)),
+		gauge(processUptimeMetric, sample.Uptime.Seconds()),
+	}
+	if c.pool != nil {
+		stats := c.pool.PoolStats()
+		metrics = append(metrics,
+			gauge(dbOpenMetric, float64(stats.OpenConnections)),
+			gauge(dbInUseMetric, float64(stats.InUse)),
+			gauge(dbIdleMetric, float64(stats.Idle)),
+			types.Metric{Name: dbWaitMetric, Type: types.MetricTypeCounter, Value: float64(stats.WaitCount), Time: now},
+			types.Metric{Name: dbWaitDurationMetric, Type: types.MetricTypeCounter, Value: stats.WaitDuration.Seconds(), Time: now},
+		)
+	}
+	return metrics, nil
+}
+
+// Describe implements MetricsCollector.Describe
+func (c *ProcessCollector) Describe() []types.MetricDesc {
+	descs := []types.MetricDesc{
+		{Name: processCPUMetric, Type: types.MetricTypeGauge, Description: "CPU usage since the previous scrape, in percent of one core"},
+		{Name: processMemoryMetric, Type: types.MetricTypeGauge, Description: "Memory obtained from the operating system by the Go runtime"},
+		{Name: processRSSMetric, Type: types.MetricTypeGauge, Description: "Resident set size of the process"},
+		{Name: processHeapMetric, Type: types.MetricTypeGauge, Description: "Bytes of allocated heap objects"},
+		{Name: processGCPauseMetric, Type: types.MetricTypeGauge, Description: "Duration of the most recent garbage collection pause"},
+		{Name: processGoroutinesMetric, Type: types.MetricTypeGauge, Description: "Number of goroutines"},
+		{Name: processUptimeMetric, Type: types.MetricTypeGauge, Description: "Time since the process started"},
+	}
+	if c.pool != nil {
+		descs = append(descs,
+			types.MetricDesc{Name: dbOpenMetric, Type: types.MetricTypeGauge, Description: "Open database connections"},
+			types.MetricDesc{Name: dbInUseMetric, Type: types.MetricTypeGauge, Description: "Database connections in use"},
+			types.MetricDesc{Name: dbIdleMetric, Type: types.MetricTypeGauge, Description: "Idle database connections"},
+			types.MetricDesc{Name: dbWaitMetric, Type: types.MetricTypeCounter, Description: "Connections waited for because the pool was exhausted"},
+			types.MetricDesc{Name: dbWaitDurationMetric, Type: types.MetricTypeCounter, Description: "Time spent waiting for connections"},
+		)
+	}
+	return descs
+}
+
+// residentMemory reads the resident set size from /proc, returning zero
+// where it is unavailable
+func residentMemory() int64 {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+	fields := bytes.Fields(data)
+	if len(fields) < 2 {
+		return 0
+	}
+	pages, err := strconv.ParseInt(string(fields[1]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return pages * int64(os.Getpagesize())
+}
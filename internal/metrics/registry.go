@@ -0,0 +1,342 @@
+/*
+Package metrics implements types.MetricsPort with an in-process registry
+that is scraped in the Prometheus text exposition format. It also samples
+the server process itself into types.ResourceMetrics.
+*/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"flow-control/internal/types"
+)
+
+// DefaultBuckets are the histogram bucket upper bounds, suited to durations
+// in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// family holds the series of one metric name
+type family struct {
+	typ    types.MetricType
+	series map[string]*series
+}
+
+// series is one labelled time series
+type series struct {
+	labels map[string]string
+	value  float64
+
+	// Histogram state
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Registry implements types.MetricsPort. Metrics recorded through Inc are
+// counters, through Dec and Set gauges, and through Observe histograms;
+// registered collectors are read on every scrape.
+type Registry struct {
+	mu         sync.Mutex
+	families   map[string]*family
+	collectors []types.MetricsCollector
+	buckets    []float64
+}
+
+// NewRegistry creates an empty registry using DefaultBuckets
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+		buckets:  DefaultBuckets,
+	}
+}
+
+// Inc implements MetricsPort.Inc
+func (r *Registry) Inc(name string, value float64, labels map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.series(name, types.MetricTypeCounter, labels).value += value
+}
+
+// Dec implements MetricsPort.Dec. Decrementing a counter turns it into a
+// gauge, as counters may only increase.
+func (r *Registry) Dec(name string, value float64, labels map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok && f.typ == types.MetricTypeCounter {
+		f.typ = types.MetricTypeGauge
+	}
+	r.series(name, types.MetricTypeGauge, labels).value -= value
+}
+
+// Set implements MetricsPort.Set
+func (r *Registry) Set(name string, value float64, labels map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.series(name, types.MetricTypeGauge, labels).value = value
+}
+
+// Observe implements MetricsPort.Observe
+func (r *Registry) Observe(name string, value float64, labels map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.series(name, types.MetricTypeHistogram, labels)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(r.buckets))
+	}
+	for i, bound := range r.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// Register implements MetricsPort.Register
+func (r *Registry) Register(collector types.MetricsCollector) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.collectors {
+		if c == collector {
+			return fmt.Errorf("collector already registered")
+		}
+	}
+	r.collectors = append(r.collectors, collector)
+	return nil
+}
+
+// Unregister implements MetricsPort.Unregister
+func (r *Registry) Unregister(collector types.MetricsCollector) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, c := range r.collectors {
+		if c == collector {
+			r.collectors = append(r.collectors[:i], r.collectors[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("collector not registered")
+}
+
+// series returns the series of a name and label set, creating it with typ
+// on first use. Must be called with r.mu held.
+func (r *Registry) series(name string, typ types.MetricType, labels map[string]string) *series {
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{typ: typ, series: make(map[string]*series)}
+		r.families[name] = f
+	}
+	key := labelKey(labels)
+	s, ok := f.series[key]
+	if !ok {
+		copied := make(map[string]string, len(labels))
+		for k, v := range labels {
+			copied[k] = v
+		}
+		s = &series{labels: copied}
+		f.series[key] = s
+	}
+	return s
+}
+
+// WritePrometheus writes every metric in the Prometheus text exposition
+// format, version 0.0.4
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]types.MetricsCollector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	// Collect outside the lock, since collectors may take their own
+	collected := make(map[string][]types.Metric)
+	help := make(map[string]string)
+	for _, c := range collectors {
+		metrics, err := c.Collect()
+		if err != nil {
+			return fmt.Errorf("failed to collect metrics: %w", err)
+		}
+		for _, m := range metrics {
+			collected[m.Name] = append(collected[m.Name], m)
+		}
+		for _, desc := range c.Describe() {
+			help[desc.Name] = desc.Description
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r.writeFamily(bw, name, r.families[name])
+	}
+	r.mu.Unlock()
+
+	names = names[:0]
+	for name := range collected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		metrics := collected[name]
+		sanitized := sanitizeName(name)
+		if text := help[name]; text != "" {
+			fmt.Fprintf(bw, "# HELP %s %s\n", sanitized, escapeHelp(text))
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", sanitized, promType(metrics[0].Type))
+		for _, m := range metrics {
+			writeSample(bw, sanitized, m.Labels, m.Value)
+		}
+	}
+	return bw.Flush()
+}
+
+// writeFamily writes the series of a recorded metric. Must be called with
+// r.mu held.
+func (r *Registry) writeFamily(w *bufio.Writer, name string, f *family) {
+	sanitized := sanitizeName(name)
+	fmt.Fprintf(w, "# TYPE %s %s\n", sanitized, promType(f.typ))
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.typ != types.MetricTypeHistogram {
+			writeSample(w, sanitized, s.labels, s.value)
+			continue
+		}
+		for i, bound := range r.buckets {
+			writeSample(w, sanitized+"_bucket", withLabel(s.labels, "le", formatFloat(bound)), float64(s.counts[i]))
+		}
+		writeSample(w, sanitized+"_bucket", withLabel(s.labels, "le", "+Inf"), float64(s.count))
+		writeSample(w, sanitized+"_sum", s.labels, s.sum)
+		writeSample(w, sanitized+"_count", s.labels, float64(s.count))
+	}
+}
+
+// Handler serves the metrics to Prometheus scrapers
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := r.WritePrometheus(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// writeSample writes one sample line
+func writeSample(w *bufio.Writer, name string, labels map[string]string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		keys := sortedLabelNames(labels)
+		w.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(sanitizeName(k))
+			w.WriteString(`="`)
+			w.WriteString(escapeLabel(labels[k]))
+			w.WriteByte('"')
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+// labelKey identifies a label set independently of map order
+func labelKey(labels map[string]string) string {
+	var b strings.Builder
+	for _, k := range sortedLabelNames(labels) {
+		b.WriteString(k)
+		b.WriteByte(0)
+		b.WriteString(labels[k])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// sortedLabelNames returns the names of a label set in order
+func sortedLabelNames(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// withLabel returns a copy of labels with one more label
+func withLabel(labels map[string]string, name, value string) map[string]string {
+	result := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		result[k] = v
+	}
+	result[name] = value
+	return result
+}
+
+// promType maps metric types to Prometheus types
+func promType(typ types.MetricType) string {
+	switch typ {
+	case types.MetricTypeCounter, types.MetricTypeGauge, types.MetricTypeHistogram:
+		return string(typ)
+	default:
+		return "untyped"
+	}
+}
+
+// sanitizeName replaces characters Prometheus does not allow in names
+func sanitizeName(name string) string {
+	var b strings.Builder
+	for i, c := range name {
+		switch {
+		case c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			b.WriteRune(c)
+		case c >= '0' && c <= '9' && i > 0:
+			b.WriteRune(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// escapeLabel escapes a label value
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// escapeHelp escapes a help text
+func escapeHelp(text string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(text)
+}
+
+// formatFloat formats a sample value
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,364 @@
+/*
+Package analyzer checks parsed Flow programs for mistakes the parser
+accepts, such as duplicate node names or nodes without a type.
+*/
+package analyzer
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"flow-control/internal/parser"
+	"flow-control/internal/parser/ast"
+	"flow-control/internal/parser/token"
+)
+
+// Analyze returns the problems found in program, ordered by position
+func Analyze(program *ast.Program) []parser.Diagnostic {
+	a := &analyzer{}
+	flows := map[string]token.Position{}
+	for _, stmt := range program.Statements {
+		switch n := stmt.(type) {
+		case *ast.Flow:
+			if prev, ok := flows[n.Name.Value]; ok {
+				a.errorf(n.Name.Token.Pos, "flow %q is already defined on line %d", n.Name.Value, prev.Line)
+			} else {
+				flows[n.Name.Value] = n.Name.Token.Pos
+			}
+			a.flow(n)
+		case *ast.FlowNode:
+			a.node(n)
+		case *ast.Comment:
+		default:
+			a.errorf(statementPos(stmt), "%s is not allowed outside a flow", describe(stmt))
+		}
+	}
+
+	sort.SliceStable(a.diagnostics, func(i, j int) bool {
+		pi, pj := a.diagnostics[i].Pos, a.diagnostics[j].Pos
+		return pi.Line < pj.Line || pi.Line == pj.Line && pi.Column < pj.Column
+	})
+	return a.diagnostics
+}
+
+// HasErrors reports whether any of diagnostics is an error
+func HasErrors(diagnostics []parser.Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == parser.SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// paramRef matches the references of node settings to the parameters of
+// their flow
+var paramRef = regexp.MustCompile(`\$\{param\.([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// analyzer collects the diagnostics of one program
+type analyzer struct {
+	diagnostics []parser.Diagnostic
+	// params are the parameters declared by the flow being checked, nil
+	// outside flows
+	params map[string]bool
+}
+
+func (a *analyzer) errorf(pos token.Position, format string, args ...interface{}) {
+	a.report(pos, parser.SeverityError, format, args...)
+}
+
+func (a *analyzer) warnf(pos token.Position, format string, args ...interface{}) {
+	a.report(pos, parser.SeverityWarning, format, args...)
+}
+
+func (a *analyzer) report(pos token.Position, severity, format string, args ...interface{}) {
+	a.diagnostics = append(a.diagnostics, parser.Diagnostic{
+		Pos:      pos,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// flow checks a flow definition and the nodes within it
+func (a *analyzer) flow(f *ast.Flow) {
+	if f.Name.Value == "" {
+		a.errorf(f.Name.Token.Pos, "flow name cannot be empty")
+	}
+
+	nodes := map[string]token.Position{}
+	var connections []*ast.Connection
+	a.fields(f.Body)
+	a.params = a.declaredParams(f)
+	defer func() { a.params = nil }()
+	for _, stmt := range f.Body.Statements {
+		switch n := stmt.(type) {
+		case *ast.FlowNode:
+			if prev, ok := nodes[n.Name.Value]; ok {
+				a.errorf(n.Name.Token.Pos, "node %q is already defined on line %d", n.Name.Value, prev.Line)
+			} else {
+				nodes[n.Name.Value] = n.Name.Token.Pos
+			}
+			a.node(n)
+		case *ast.Flow:
+			a.errorf(n.Token.Pos, "flow %q cannot be nested in flow %q", n.Name.Value, f.Name.Value)
+		case *ast.Section:
+			a.errorf(n.Token.Pos, "%s can only be declared by a node", n.Token.Literal)
+		case *ast.Config:
+			a.block(n.Body)
+		case *ast.Connection:
+			connections = append(connections, n)
+		}
+	}
+	if len(nodes) == 0 {
+		a.warnf(f.Token.Pos, "flow %q has no nodes", f.Name.Value)
+	}
+	// Connections may come before the nodes they connect
+	for _, c := range connections {
+		for _, end := range []*ast.StringLiteral{c.From, c.To} {
+			if !connects(end.Value, nodes) {
+				a.errorf(end.Token.Pos, "connection refers to unknown node %q", end.Value)
+			}
+		}
+	}
+}
+
+// connects reports whether the end of a connection names one of nodes,
+// either by its name or by its name followed by a dot and a port
+func connects(end string, nodes map[string]token.Position) bool {
+	if _, ok := nodes[end]; ok {
+		return true
+	}
+	if dot := strings.LastIndex(end, "."); dot > 0 {
+		_, ok := nodes[end[:dot]]
+		return ok
+	}
+	return false
+}
+
+// node checks a node definition
+func (a *analyzer) node(n *ast.FlowNode) {
+	if n.Name.Value == "" {
+		a.errorf(n.Name.Token.Pos, "node name cannot be empty")
+	}
+
+	a.fields(n.Body)
+	a.paramRefs(n, n.Body)
+	hasType := false
+	for _, stmt := range n.Body.Statements {
+		switch s := stmt.(type) {
+		case *ast.Assignment:
+			if s.Name.Value == "type" {
+				hasType = true
+				if v, ok := s.Value.(*ast.StringLiteral); !ok || v.Value == "" {
+					a.errorf(s.Token.Pos, "node %q must have a non-empty string type", n.Name.Value)
+				}
+			}
+		case *ast.FlowNode:
+			a.errorf(s.Token.Pos, "node %q cannot be nested in node %q", s.Name.Value, n.Name.Value)
+		case *ast.Flow:
+			a.errorf(s.Token.Pos, "flow %q cannot be nested in node %q", s.Name.Value, n.Name.Value)
+		case *ast.Connection:
+			a.errorf(s.Token.Pos, "connections can only be declared by a flow")
+		case *ast.Config, *ast.Section:
+			a.block(blockOf(s))
+		}
+	}
+	if !hasType {
+		a.errorf(n.Token.Pos, "node %q has no type", n.Name.Value)
+	}
+}
+
+// declaredParams checks the "params" setting of a flow, which maps
+// parameter names to their type, default and description, and returns the
+// names of the parameters
+func (a *analyzer) declaredParams(f *ast.Flow) map[string]bool {
+	params := map[string]bool{}
+	for _, stmt := range f.Body.Statements {
+		s, ok := stmt.(*ast.Assignment)
+		if !ok || s.Name.Value != "params" {
+			continue
+		}
+		decls, ok := s.Value.(*ast.ObjectLiteral)
+		if !ok {
+			a.errorf(s.Token.Pos, "params must map parameter names to declarations")
+			continue
+		}
+		for _, decl := range decls.Fields {
+			params[decl.Name.Value] = true
+			a.param(decl)
+		}
+	}
+	return params
+}
+
+// param checks the declaration of a flow parameter
+func (a *analyzer) param(decl *ast.Assignment) {
+	name := decl.Name.Value
+	settings, ok := decl.Value.(*ast.ObjectLiteral)
+	if !ok {
+		a.errorf(decl.Token.Pos, "parameter %q must be declared as an object", name)
+		return
+	}
+	paramType := "string"
+	var def *ast.Assignment
+	for _, setting := range settings.Fields {
+		switch setting.Name.Value {
+		case "type":
+			v, _ := setting.Value.(*ast.StringLiteral)
+			if v == nil || v.Value != "string" && v.Value != "number" && v.Value != "boolean" {
+				a.errorf(setting.Token.Pos, "parameter %q must have type string, number or boolean", name)
+				return
+			}
+			paramType = v.Value
+		case "default":
+			def = setting
+		case "description":
+		default:
+			a.errorf(setting.Token.Pos, "parameter %q has unknown setting %s", name, setting.Name.Value)
+		}
+	}
+	if def == nil {
+		return
+	}
+	var err error
+	switch v := ast.Value(def.Value).(type) {
+	case string:
+		switch paramType {
+		case "number":
+			_, err = strconv.ParseFloat(v, 64)
+		case "boolean":
+			_, err = strconv.ParseBool(v)
+		}
+	case float64:
+		if paramType == "boolean" {
+			err = fmt.Errorf("not a boolean")
+		}
+	default:
+		err = fmt.Errorf("not a value")
+	}
+	if err != nil {
+		a.errorf(def.Token.Pos, "default of parameter %q is not a %s", name, paramType)
+	}
+}
+
+// paramRefs reports the references of the settings in b, including those
+// of config blocks and sections, to parameters the flow does not declare
+func (a *analyzer) paramRefs(n *ast.FlowNode, b *ast.BlockStatement) {
+	if a.params == nil {
+		return
+	}
+	var walk func(expr ast.Expression)
+	walk = func(expr ast.Expression) {
+		switch v := expr.(type) {
+		case *ast.StringLiteral:
+			for _, match := range paramRef.FindAllStringSubmatch(v.Value, -1) {
+				if !a.params[match[1]] {
+					a.errorf(v.Token.Pos, "node %q refers to undeclared parameter %q", n.Name.Value, match[1])
+				}
+			}
+		case *ast.ObjectLiteral:
+			for _, field := range v.Fields {
+				walk(field.Value)
+			}
+		case *ast.ArrayLiteral:
+			for _, element := range v.Elements {
+				walk(element)
+			}
+		}
+	}
+	for _, stmt := range b.Statements {
+		switch s := stmt.(type) {
+		case *ast.Assignment:
+			walk(s.Value)
+		case *ast.Config, *ast.Section:
+			a.paramRefs(n, blockOf(s))
+		}
+	}
+}
+
+// block checks a config block or a section, which may only hold fields
+func (a *analyzer) block(b *ast.BlockStatement) {
+	a.fields(b)
+	for _, stmt := range b.Statements {
+		switch stmt.(type) {
+		case *ast.Assignment, *ast.Comment:
+		default:
+			a.errorf(statementPos(stmt), "%s is not allowed here", describe(stmt))
+		}
+	}
+}
+
+// fields reports fields, config blocks and sections declared more than once
+// in a block
+func (a *analyzer) fields(b *ast.BlockStatement) {
+	seen := map[string]token.Position{}
+	for _, stmt := range b.Statements {
+		var name string
+		switch s := stmt.(type) {
+		case *ast.Assignment:
+			name = s.Name.Value
+		case *ast.Config, *ast.Section:
+			name = stmt.TokenLiteral()
+		default:
+			continue
+		}
+		pos := statementPos(stmt)
+		if prev, ok := seen[name]; ok {
+			a.errorf(pos, "%s is already declared on line %d", name, prev.Line)
+			continue
+		}
+		seen[name] = pos
+	}
+}
+
+// blockOf returns the body of a config block or section
+func blockOf(stmt ast.Statement) *ast.BlockStatement {
+	switch s := stmt.(type) {
+	case *ast.Config:
+		return s.Body
+	case *ast.Section:
+		return s.Body
+	}
+	return &ast.BlockStatement{}
+}
+
+// statementPos returns the position of a statement's first token
+func statementPos(stmt ast.Statement) token.Position {
+	switch s := stmt.(type) {
+	case *ast.Flow:
+		return s.Token.Pos
+	case *ast.FlowNode:
+		return s.Token.Pos
+	case *ast.Config:
+		return s.Token.Pos
+	case *ast.Section:
+		return s.Token.Pos
+	case *ast.Assignment:
+		return s.Token.Pos
+	case *ast.Comment:
+		return s.Token.Pos
+	case *ast.Connection:
+		return s.Token.Pos
+	case *ast.BlockStatement:
+		return s.Token.Pos
+	}
+	return token.Position{}
+}
+
+// describe names a statement in diagnostics
+func describe(stmt ast.Statement) string {
+	switch s := stmt.(type) {
+	case *ast.Flow:
+		return fmt.Sprintf("flow %q", s.Name.Value)
+	case *ast.FlowNode:
+		return fmt.Sprintf("node %q", s.Name.Value)
+	case *ast.Assignment:
+		return fmt.Sprintf("field %s", s.Name.Value)
+	case *ast.Connection:
+		return fmt.Sprintf("connection %q -> %q", s.From.Value, s.To.Value)
+	}
+	return stmt.TokenLiteral()
+}
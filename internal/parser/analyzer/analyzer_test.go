@@ -0,0 +1,124 @@
+package analyzer_test
+
+import (
+	"testing"
+
+	"flow-control/internal/logger"
+	"flow-control/internal/parser"
+	"flow-control/internal/parser/analyzer"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyze(t *testing.T) {
+	log := logger.New()
+
+	tests := []struct {
+		name     string
+		input    string
+		want     []string
+		hasError bool
+	}{
+		{
+			name: "valid flow",
+			input: `flow "orders" {
+				node "reader" {
+					type: "FileReader"
+					config { retries: 3 }
+				}
+			}`,
+		},
+		{
+			name:     "empty flow",
+			input:    `flow "empty" {}`,
+			want:     []string{`1:1: warning: flow "empty" has no nodes`},
+			hasError: false,
+		},
+		{
+			name: "duplicates",
+			input: `flow "orders" {
+				node "reader" { type: "FileReader" }
+				node "reader" {
+					type: "FileReader"
+					type: "HTTP"
+				}
+			}
+			flow "orders" { node "n" { type: "X" } }`,
+			want: []string{
+				`3:12: error: node "reader" is already defined on line 2`,
+				`5:7: error: type is already declared on line 4`,
+				`8:11: error: flow "orders" is already defined on line 1`,
+			},
+			hasError: true,
+		},
+		{
+			name: "misplaced declarations",
+			input: `retries: 3
+			flow "orders" {
+				inputs { data: "text" }
+				node "reader" {
+					config { node "inner" { type: "X" } }
+				}
+			}`,
+			want: []string{
+				`1:1: error: field retries is not allowed outside a flow`,
+				`3:6: error: inputs can only be declared by a node`,
+				`4:6: error: node "reader" has no type`,
+				`5:16: error: node "inner" is not allowed here`,
+			},
+			hasError: true,
+		},
+		{
+			name: "parameters",
+			input: `flow "orders" {
+				params: {
+					endpoint: { type: "string", default: "https://api" }
+					retries: { type: "number", default: "many" }
+					debug: { type: "flag" }
+				}
+				node "read" {
+					type: "HTTPPoll"
+					url: "${param.endpoint}/orders"
+					config { token: "${param.token}" }
+				}
+			}`,
+			want: []string{
+				`4:34: error: default of parameter "retries" is not a number`,
+				`5:16: error: parameter "debug" must have type string, number or boolean`,
+				`10:24: error: node "read" refers to undeclared parameter "token"`,
+			},
+			hasError: true,
+		},
+		{
+			name: "connections",
+			input: `flow "orders" {
+				"read.out" -> "store"
+				"read" -> "missing.in"
+				node "read" { type: "X" }
+				node "store" {
+					type: "X"
+					"read" -> "store"
+				}
+			}`,
+			want: []string{
+				`3:17: error: connection refers to unknown node "missing.in"`,
+				`7:8: error: connections can only be declared by a flow`,
+			},
+			hasError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, diagnostics := parser.Parse(tt.input, log)
+			require.Empty(t, diagnostics)
+
+			var got []string
+			for _, d := range analyzer.Analyze(program) {
+				got = append(got, d.String())
+			}
+			require.Equal(t, tt.want, got)
+			require.Equal(t, tt.hasError, analyzer.HasErrors(analyzer.Analyze(program)))
+		})
+	}
+}
@@ -195,3 +195,146 @@ func (nl *NumberLiteral) TokenLiteral() string { return nl.Token.Literal }
 
 // String returns a string representation of the number literal
 func (nl *NumberLiteral) String() string { return fmt.Sprintf("%g", nl.Value) }
+
+// Section represents a named block of declarations, such as a node's
+// inputs or outputs
+type Section struct {
+	Token token.Token
+	Body  *BlockStatement
+}
+
+func (s *Section) statementNode() {}
+
+// TokenLiteral returns the literal value of the section's token
+func (s *Section) TokenLiteral() string { return s.Token.Literal }
+
+// String returns a string representation of the section
+func (s *Section) String() string {
+	return fmt.Sprintf("%s %s", s.Token.Literal, s.Body.String())
+}
+
+// Comment represents a line comment in the AST
+type Comment struct {
+	Token token.Token
+	Text  string
+}
+
+func (c *Comment) statementNode() {}
+
+// TokenLiteral returns the literal value of the comment's token
+func (c *Comment) TokenLiteral() string { return c.Token.Literal }
+
+// String returns a string representation of the comment
+func (c *Comment) String() string { return "// " + c.Text }
+
+// Connection represents a connection between two nodes, such as
+// `"read.out" -> "store.in" map { id: payload.id }`
+type Connection struct {
+	Token token.Token
+	From  *StringLiteral
+	To    *StringLiteral
+	// Map reshapes the messages crossing the connection; nil when they
+	// cross it unchanged
+	Map *ObjectLiteral
+}
+
+func (c *Connection) statementNode() {}
+
+// TokenLiteral returns the literal value of the connection's token
+func (c *Connection) TokenLiteral() string { return c.Token.Literal }
+
+// String returns a string representation of the connection
+func (c *Connection) String() string {
+	if c.Map == nil {
+		return fmt.Sprintf("%s -> %s", c.From.String(), c.To.String())
+	}
+	return fmt.Sprintf("%s -> %s map %s", c.From.String(), c.To.String(), c.Map.String())
+}
+
+// Path represents a dotted path into a message, such as `payload.user.id`
+type Path struct {
+	Token token.Token
+	Parts []string
+}
+
+func (p *Path) expressionNode() {}
+
+// TokenLiteral returns the literal value of the path's first token
+func (p *Path) TokenLiteral() string { return p.Token.Literal }
+
+// String returns a string representation of the path
+func (p *Path) String() string { return strings.Join(p.Parts, ".") }
+
+// ObjectLiteral represents an inline object value, such as
+// `{ type: "text" }`
+type ObjectLiteral struct {
+	Token  token.Token
+	Fields []*Assignment
+}
+
+func (ol *ObjectLiteral) expressionNode() {}
+
+// TokenLiteral returns the literal value of the object's token
+func (ol *ObjectLiteral) TokenLiteral() string { return ol.Token.Literal }
+
+// String returns a string representation of the object
+func (ol *ObjectLiteral) String() string {
+	if len(ol.Fields) == 0 {
+		return "{}"
+	}
+	fields := make([]string, len(ol.Fields))
+	for i, f := range ol.Fields {
+		fields[i] = f.String()
+	}
+	return "{ " + strings.Join(fields, ", ") + " }"
+}
+
+// ArrayLiteral represents a list value, such as `["a", "b"]`
+type ArrayLiteral struct {
+	Token    token.Token
+	Elements []Expression
+}
+
+func (al *ArrayLiteral) expressionNode() {}
+
+// TokenLiteral returns the literal value of the array's token
+func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+
+// String returns a string representation of the array
+func (al *ArrayLiteral) String() string {
+	elements := make([]string, len(al.Elements))
+	for i, e := range al.Elements {
+		elements[i] = e.String()
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// Value returns the Go value of a literal expression: a string, a float64,
+// a map for objects or a slice for arrays. Identifiers and paths yield their
+// name.
+func Value(expr Expression) interface{} {
+	switch v := expr.(type) {
+	case *StringLiteral:
+		return v.Value
+	case *NumberLiteral:
+		return v.Value
+	case *Identifier:
+		return v.Value
+	case *Path:
+		return v.String()
+	case *ObjectLiteral:
+		fields := make(map[string]interface{}, len(v.Fields))
+		for _, f := range v.Fields {
+			fields[f.Name.Value] = Value(f.Value)
+		}
+		return fields
+	case *ArrayLiteral:
+		elements := make([]interface{}, len(v.Elements))
+		for i, e := range v.Elements {
+			elements[i] = Value(e)
+		}
+		return elements
+	default:
+		return nil
+	}
+}
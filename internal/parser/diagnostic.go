@@ -0,0 +1,25 @@
+package parser
+
+import (
+	"fmt"
+
+	"flow-control/internal/parser/token"
+)
+
+// Severities of a Diagnostic
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Diagnostic is a problem found in Flow source
+type Diagnostic struct {
+	Pos      token.Position
+	Severity string
+	Message  string
+}
+
+// String formats the diagnostic as "line:column: severity: message"
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s", d.Pos.Line, d.Pos.Column, d.Severity, d.Message)
+}
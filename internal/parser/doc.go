@@ -6,6 +6,8 @@
 // - Lexer: Performs lexical analysis to convert source code into tokens
 // - Parser: Converts tokens into an AST
 // - AST: Defines the abstract syntax tree nodes
+// - Analyzer: Reports semantic problems in a parsed program
+// - Format: Prints a program in its canonical layout
 //
 // Example Flow language code:
 //
@@ -0,0 +1,138 @@
+/*
+Package format prints Flow programs in their canonical layout: two-space
+indentation, one declaration per line and blank lines around blocks.
+*/
+package format
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"flow-control/internal/parser"
+	"flow-control/internal/parser/ast"
+	"flow-control/internal/types"
+)
+
+// ErrSyntax is returned when source cannot be formatted because it does not
+// parse
+var ErrSyntax = errors.New("syntax error")
+
+// indent is the indentation of one nesting level
+const indent = "  "
+
+// Source formats Flow source. Source that does not parse is returned as
+// ErrSyntax along with the parser's diagnostics.
+func Source(src []byte, log types.Logger) ([]byte, []parser.Diagnostic, error) {
+	program, diagnostics := parser.Parse(string(src), log)
+	if len(diagnostics) > 0 {
+		return nil, diagnostics, fmt.Errorf("%w: %s", ErrSyntax, diagnostics[0])
+	}
+	return Program(program), nil, nil
+}
+
+// Program prints a program in the canonical layout
+func Program(program *ast.Program) []byte {
+	var buf bytes.Buffer
+	printStatements(&buf, program.Statements, 0)
+	return buf.Bytes()
+}
+
+// printStatements prints the statements of a block at the given depth,
+// separating blocks from their siblings with blank lines. Comments stay
+// attached to the statement that follows them.
+func printStatements(buf *bytes.Buffer, statements []ast.Statement, depth int) {
+	for i, stmt := range statements {
+		if i > 0 {
+			prev := statements[i-1]
+			_, afterComment := prev.(*ast.Comment)
+			if !afterComment && (isBlock(prev) || isBlock(stmt)) {
+				buf.WriteString("\n")
+			}
+		}
+		buf.WriteString(strings.Repeat(indent, depth))
+		printStatement(buf, stmt, depth)
+		buf.WriteString("\n")
+	}
+}
+
+func printStatement(buf *bytes.Buffer, stmt ast.Statement, depth int) {
+	switch s := stmt.(type) {
+	case *ast.Flow:
+		fmt.Fprintf(buf, "flow %s ", quote(s.Name.Value))
+		printBlock(buf, s.Body, depth)
+	case *ast.FlowNode:
+		fmt.Fprintf(buf, "node %s ", quote(s.Name.Value))
+		printBlock(buf, s.Body, depth)
+	case *ast.Config:
+		buf.WriteString("config ")
+		printBlock(buf, s.Body, depth)
+	case *ast.Section:
+		buf.WriteString(s.Token.Literal + " ")
+		printBlock(buf, s.Body, depth)
+	case *ast.Assignment:
+		fmt.Fprintf(buf, "%s: %s", s.Name.Value, expression(s.Value))
+	case *ast.Connection:
+		fmt.Fprintf(buf, "%s -> %s", quote(s.From.Value), quote(s.To.Value))
+		if s.Map != nil {
+			buf.WriteString(" map " + expression(s.Map))
+		}
+	case *ast.Comment:
+		buf.WriteString("// " + s.Text)
+	default:
+		buf.WriteString(stmt.String())
+	}
+}
+
+func printBlock(buf *bytes.Buffer, block *ast.BlockStatement, depth int) {
+	if len(block.Statements) == 0 {
+		buf.WriteString("{}")
+		return
+	}
+	buf.WriteString("{\n")
+	printStatements(buf, block.Statements, depth+1)
+	buf.WriteString(strings.Repeat(indent, depth) + "}")
+}
+
+// expression prints a value on one line
+func expression(expr ast.Expression) string {
+	switch e := expr.(type) {
+	case *ast.StringLiteral:
+		return quote(e.Value)
+	case *ast.NumberLiteral:
+		return e.Token.Literal
+	case *ast.ObjectLiteral:
+		if len(e.Fields) == 0 {
+			return "{}"
+		}
+		fields := make([]string, len(e.Fields))
+		for i, f := range e.Fields {
+			fields[i] = f.Name.Value + ": " + expression(f.Value)
+		}
+		return "{ " + strings.Join(fields, ", ") + " }"
+	case *ast.ArrayLiteral:
+		elements := make([]string, len(e.Elements))
+		for i, el := range e.Elements {
+			elements[i] = expression(el)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	default:
+		return expr.String()
+	}
+}
+
+// quote wraps a string literal in quotes. The lexer keeps escape sequences
+// in literals, so they are written back unchanged.
+func quote(s string) string {
+	return `"` + s + `"`
+}
+
+// isBlock reports whether a statement spans a block
+func isBlock(stmt ast.Statement) bool {
+	switch stmt.(type) {
+	case *ast.Flow, *ast.FlowNode, *ast.Config, *ast.Section:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package format_test
+
+import (
+	"testing"
+
+	"flow-control/internal/logger"
+	"flow-control/internal/parser/format"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSource(t *testing.T) {
+	log := logger.New()
+
+	src := `// Orders pipeline
+flow "orders" { config { retries: 3
+timeout: 1000 }
+    node "reader" {
+  type: "FileReader"
+        inputs { data: {type: "text",format: "csv"} }
+  tags: ["a","b"]
+}
+node "writer" { type: "FileWriter" }
+"reader"->"writer" map {id:payload.id,
+  tenant: headers.tenant}
+}`
+	want := `// Orders pipeline
+flow "orders" {
+  config {
+    retries: 3
+    timeout: 1000
+  }
+
+  node "reader" {
+    type: "FileReader"
+
+    inputs {
+      data: { type: "text", format: "csv" }
+    }
+
+    tags: ["a", "b"]
+  }
+
+  node "writer" {
+    type: "FileWriter"
+  }
+
+  "reader" -> "writer" map { id: payload.id, tenant: headers.tenant }
+}
+`
+	got, diagnostics, err := format.Source([]byte(src), log)
+	require.NoError(t, err)
+	require.Empty(t, diagnostics)
+	require.Equal(t, want, string(got))
+
+	// Formatting is idempotent
+	again, _, err := format.Source(got, log)
+	require.NoError(t, err)
+	require.Equal(t, want, string(again))
+
+	// Source that does not parse is not formatted
+	_, diagnostics, err = format.Source([]byte(`flow "broken" { node "a" {`), log)
+	require.ErrorIs(t, err, format.ErrSyntax)
+	require.NotEmpty(t, diagnostics)
+}
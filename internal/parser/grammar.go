@@ -0,0 +1,193 @@
+package parser
+
+import "flow-control/internal/parser/token"
+
+// Categories of grammar rules
+const (
+	CategoryBlock     = "Blocks"
+	CategoryStatement = "Statements"
+	CategoryLiteral   = "Literals"
+)
+
+// Rule documents a production of the Flow grammar. The language reference
+// is generated from the rules, so they are kept next to the parser they
+// describe.
+type Rule struct {
+	// Name is the nonterminal the rule defines
+	Name     string
+	Category string
+	// Syntax is the production in EBNF
+	Syntax string
+	Doc    string
+	// Example is Flow source using the construct, which parses without
+	// errors
+	Example string
+	// Keywords are the keywords the production uses
+	Keywords []token.TokenType
+}
+
+// Grammar lists the rules of the Flow language by category, in the order
+// the language reference presents them
+var Grammar = []Rule{
+	{
+		Name:     "program",
+		Category: CategoryBlock,
+		Syntax:   `program = { statement } .`,
+		Doc:      "A program is a sequence of statements, usually one or more flows.",
+		Example: `// Orders pipeline
+flow "orders" {
+    node "read" { type: "Passthrough" }
+}`,
+	},
+	{
+		Name:     "flow",
+		Category: CategoryBlock,
+		Syntax:   `flow = "flow" string block .`,
+		Doc: "A flow is a named graph of nodes. Its block holds the nodes and the " +
+			"settings of the flow.",
+		Example: `flow "orders" {
+    config {
+        retries: 3
+    }
+    node "read" { type: "Passthrough" }
+}`,
+		Keywords: []token.TokenType{token.FLOW},
+	},
+	{
+		Name:     "node",
+		Category: CategoryBlock,
+		Syntax:   `node = "node" string block .`,
+		Doc: "A node is a named step of a flow. Its type property is required. " +
+			"A node receives the output of the nodes named by its from property, " +
+			"or of the node declared before it when it has none.",
+		Example: `node "paid" {
+    type: "Filter"
+    from: "read"
+    field: "status"
+    equals: "paid"
+}`,
+		Keywords: []token.TokenType{token.NODE},
+	},
+	{
+		Name:     "config",
+		Category: CategoryBlock,
+		Syntax:   `config = "config" block .`,
+		Doc:      "A config block groups settings. In a node it becomes the node's config setting.",
+		Example: `config {
+    retries: 3
+    timeout: 1000
+}`,
+		Keywords: []token.TokenType{token.CONFIG},
+	},
+	{
+		Name:     "section",
+		Category: CategoryBlock,
+		Syntax:   `section = ( "inputs" | "outputs" ) block .`,
+		Doc: "A section declares the ports of a node. Followed by a colon instead " +
+			"of a block, inputs and outputs are ordinary properties.",
+		Example: `inputs {
+    data: { type: "text" }
+}`,
+		Keywords: []token.TokenType{token.INPUTS, token.OUTPUTS},
+	},
+	{
+		Name:     "block",
+		Category: CategoryBlock,
+		Syntax:   `block = "{" { statement [ "," ] } "}" .`,
+		Doc:      "A block holds statements, which may be separated by commas.",
+		Example:  `node "read" { type: "Passthrough", retries: 3 }`,
+	},
+	{
+		Name:     "statement",
+		Category: CategoryStatement,
+		Syntax:   `statement = flow | node | config | section | assignment | connection | comment .`,
+		Doc:      "Statements may appear at the top level of a program and in any block.",
+		Example: `retries: 3
+// Applies to every flow`,
+	},
+	{
+		Name:     "assignment",
+		Category: CategoryStatement,
+		Syntax: `assignment = name ":" value .
+name = identifier | "type" | "nodeType" | "from" | "to" | "inputs" | "outputs" .`,
+		Doc: "An assignment sets a property. Keywords other than flow, node and " +
+			"config double as property names.",
+		Example: `type: "Transform"
+from: ["read", "enrich"]`,
+		Keywords: []token.TokenType{token.TYPE, token.NODETYPE, token.FROM, token.TO, token.INPUTS, token.OUTPUTS},
+	},
+	{
+		Name:     "connection",
+		Category: CategoryStatement,
+		Syntax:   `connection = string "->" string [ "map" object ] .`,
+		Doc: "A connection sends the output of one node to another, like the " +
+			"from property of the receiving node. Either end names a node, " +
+			"optionally followed by a dot and a port: out is the default output " +
+			"of the sending node and in the input of the receiving one. The " +
+			"object after map reshapes every message crossing the connection; " +
+			"its values are paths into the message or literals.",
+		Example: `"read.out" -> "store.in" map { user_id: payload.id, source: "api" }`,
+	},
+	{
+		Name:     "comment",
+		Category: CategoryStatement,
+		Syntax:   `comment = "//" { character } newline .`,
+		Doc:      "A comment runs to the end of the line.",
+		Example:  `// Drops unpaid orders`,
+	},
+	{
+		Name:     "value",
+		Category: CategoryLiteral,
+		Syntax:   `value = string | number | identifier | path | object | array .`,
+		Doc:      "Properties take one of six forms of value.",
+		Example:  `enabled: true`,
+	},
+	{
+		Name:     "string",
+		Category: CategoryLiteral,
+		Syntax:   `string = '"' { character | '\"' } '"' .`,
+		Doc: `A string is text between double quotes. An escaped quote \" does ` +
+			"not end the string; backslashes are kept as written.",
+		Example: `message: "say \"hello\""`,
+	},
+	{
+		Name:     "number",
+		Category: CategoryLiteral,
+		Syntax:   `number = digit { digit } .`,
+		Doc:      "A number is a whole, non-negative decimal. Numbers are read as floating point values.",
+		Example:  `timeout: 1000`,
+	},
+	{
+		Name:     "identifier",
+		Category: CategoryLiteral,
+		Syntax:   `identifier = letter { letter | digit } .`,
+		Doc: "An identifier starts with a letter or underscore. As a value, such " +
+			"as true, it stands for its name as a string.",
+		Example: `mode: strict_v2`,
+	},
+	{
+		Name:     "path",
+		Category: CategoryLiteral,
+		Syntax: `path = identifier "." part { "." part } .
+part = identifier | keyword | number .`,
+		Doc: "A path names a field of a message in the map of a connection: " +
+			"payload.user.id is a field of the payload, payload.items.0 the " +
+			"first item of an array and headers.tenant a header. Elsewhere it " +
+			"stands for its text as a string.",
+		Example: `"read" -> "store" map { id: payload.user.id }`,
+	},
+	{
+		Name:     "object",
+		Category: CategoryLiteral,
+		Syntax:   `object = "{" { assignment [ "," ] } "}" .`,
+		Doc:      "An object holds named fields. Only assignments and comments may appear in it.",
+		Example:  `headers: { accept: "json", retries: 2 }`,
+	},
+	{
+		Name:     "array",
+		Category: CategoryLiteral,
+		Syntax:   `array = "[" { value [ "," ] } "]" .`,
+		Doc:      "An array holds values, which may be separated by commas.",
+		Example:  `from: ["read", "enrich"]`,
+	},
+}
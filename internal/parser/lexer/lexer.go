@@ -86,6 +86,15 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.COLON, l.ch)
 	case l.ch == ',':
 		tok = newToken(token.COMMA, l.ch)
+	case l.ch == '.':
+		tok = newToken(token.DOT, l.ch)
+	case l.ch == '-':
+		if l.peekChar() == '>' {
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: "->"}
+			break
+		}
+		tok = newToken(token.ILLEGAL, l.ch)
 	case l.ch == '/':
 		if l.peekChar() == '/' {
 			tok.Type = token.COMMENT
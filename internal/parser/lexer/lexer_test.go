@@ -156,6 +156,27 @@ func TestEdgeCases(t *testing.T) {
 				{token.EOF, ""},
 			},
 		},
+		{
+			name:  "connection",
+			input: `"a.out" -> "b" map { id: payload.id }`,
+			expected: []struct {
+				typ     token.TokenType
+				literal string
+			}{
+				{token.STRING, "a.out"},
+				{token.ARROW, "->"},
+				{token.STRING, "b"},
+				{token.IDENT, "map"},
+				{token.LBRACE, "{"},
+				{token.IDENT, "id"},
+				{token.COLON, ":"},
+				{token.IDENT, "payload"},
+				{token.DOT, "."},
+				{token.IDENT, "id"},
+				{token.RBRACE, "}"},
+				{token.EOF, ""},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -180,7 +201,7 @@ func TestEdgeCases(t *testing.T) {
 }
 
 func TestIllegalCharacters(t *testing.T) {
-	input := "@#$"
+	input := "@#$-"
 	l := lexer.New(input)
 
 	for _, expected := range []byte(input) {
@@ -12,9 +12,10 @@ import (
 
 // Parser represents a Flow language parser
 type Parser struct {
-	l      *lexer.Lexer
-	log    types.Logger
-	errors []string
+	l           *lexer.Lexer
+	log         types.Logger
+	errors      []string
+	diagnostics []Diagnostic
 
 	curToken  token.Token
 	peekToken token.Token
@@ -34,15 +35,34 @@ func New(l *lexer.Lexer, log types.Logger) *Parser {
 	return p
 }
 
+// Parse parses a complete Flow program from src, returning the program and
+// the syntax errors found in it
+func Parse(src string, log types.Logger) (*ast.Program, []Diagnostic) {
+	p := New(lexer.New(src), log)
+	program := p.ParseProgram()
+	return program, p.Diagnostics()
+}
+
 // Errors returns any parsing errors
 func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// Diagnostics returns the parsing errors along with their positions
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diagnostics
+}
+
+// addError records a parsing error at pos
+func (p *Parser) addError(pos token.Position, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	p.errors = append(p.errors, msg)
+	p.diagnostics = append(p.diagnostics, Diagnostic{Pos: pos, Severity: SeverityError, Message: msg})
+}
+
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
+	p.addError(p.peekToken.Pos, "expected next token to be %s, got %s instead",
 		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
 }
 
 func (p *Parser) nextToken() {
@@ -84,18 +104,56 @@ func (p *Parser) ParseProgram() *ast.Program {
 }
 
 func (p *Parser) parseStatement() ast.Statement {
+	// The parse functions return typed nil pointers on errors, which must
+	// not end up in the program as non-nil statements
 	switch p.curToken.Type {
 	case token.FLOW:
-		return p.parseFlow()
+		if flow := p.parseFlow(); flow != nil {
+			return flow
+		}
 	case token.NODE:
-		return p.parseFlowNode()
+		if node := p.parseFlowNode(); node != nil {
+			return node
+		}
 	case token.CONFIG:
-		return p.parseConfig()
+		if config := p.parseConfig(); config != nil {
+			return config
+		}
+	case token.COMMENT:
+		return &ast.Comment{Token: p.curToken, Text: p.curToken.Literal}
 	case token.IDENT:
-		return p.parseAssignment()
+		if assignment := p.parseAssignment(); assignment != nil {
+			return assignment
+		}
+	case token.INPUTS, token.OUTPUTS:
+		if p.peekTokenIs(token.LBRACE) {
+			section := &ast.Section{Token: p.curToken}
+			p.nextToken()
+			section.Body = p.parseBlockStatement()
+			return section
+		}
+		if assignment := p.parseAssignment(); assignment != nil {
+			return assignment
+		}
+	case token.TYPE, token.NODETYPE, token.FROM, token.TO:
+		// Keywords double as property names, e.g. `type: "Transform"`
+		if assignment := p.parseAssignment(); assignment != nil {
+			return assignment
+		}
+	case token.STRING:
+		if p.peekTokenIs(token.ARROW) {
+			if connection := p.parseConnection(); connection != nil {
+				return connection
+			}
+			break
+		}
+		p.addError(p.curToken.Pos, "unexpected %s %q", p.curToken.Type, p.curToken.Literal)
+	case token.COMMA:
+		// Commas may separate the fields of a block
 	default:
-		return nil
+		p.addError(p.curToken.Pos, "unexpected %s %q", p.curToken.Type, p.curToken.Literal)
 	}
+	return nil
 }
 
 func (p *Parser) parseFlow() *ast.Flow {
@@ -146,6 +204,30 @@ func (p *Parser) parseConfig() *ast.Config {
 	return stmt
 }
 
+// parseConnection parses a connection from the node named by the current
+// string, followed by the optional mapping of its messages
+func (p *Parser) parseConnection() *ast.Connection {
+	stmt := &ast.Connection{Token: p.curToken}
+	stmt.From = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	p.nextToken()
+
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	stmt.To = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+
+	// map is not a keyword, so that fields may still be called map
+	if p.peekTokenIs(token.IDENT) && p.peekToken.Literal == "map" {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		stmt.Map, _ = p.parseObjectLiteral().(*ast.ObjectLiteral)
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseAssignment() *ast.Assignment {
 	stmt := &ast.Assignment{Token: p.curToken}
 
@@ -155,9 +237,18 @@ func (p *Parser) parseAssignment() *ast.Assignment {
 		return nil
 	}
 
+	// Leave a token that cannot start a value, such as the closing brace of
+	// the block, to the caller
+	if !startsValue(p.peekToken.Type) {
+		p.addError(p.peekToken.Pos, "expected a value, got %s instead", p.peekToken.Type)
+		return nil
+	}
 	p.nextToken()
 
 	stmt.Value = p.parseExpression()
+	if stmt.Value == nil {
+		return nil
+	}
 
 	return stmt
 }
@@ -169,18 +260,88 @@ func (p *Parser) parseExpression() ast.Expression {
 	case token.NUMBER:
 		value, err := strconv.ParseFloat(p.curToken.Literal, 64)
 		if err != nil {
-			msg := fmt.Sprintf("could not parse %q as float", p.curToken.Literal)
-			p.errors = append(p.errors, msg)
+			p.addError(p.curToken.Pos, "could not parse %q as float", p.curToken.Literal)
 			return nil
 		}
 		return &ast.NumberLiteral{Token: p.curToken, Value: value}
 	case token.IDENT:
+		if p.peekTokenIs(token.DOT) {
+			return p.parsePath()
+		}
 		return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	case token.LBRACE:
+		return p.parseObjectLiteral()
+	case token.LBRACKET:
+		return p.parseArrayLiteral()
 	default:
+		p.addError(p.curToken.Pos, "expected a value, got %s instead", p.curToken.Type)
 		return nil
 	}
 }
 
+// parsePath parses a dotted path. Its parts after the first are names,
+// keywords included, or array indexes.
+func (p *Parser) parsePath() ast.Expression {
+	path := &ast.Path{Token: p.curToken, Parts: []string{p.curToken.Literal}}
+	for p.peekTokenIs(token.DOT) {
+		p.nextToken()
+		// Names and keywords are the tokens their literal looks up to
+		name := p.peekToken.Type == token.LookupIdent(p.peekToken.Literal)
+		if !name && !p.peekTokenIs(token.NUMBER) {
+			p.addError(p.peekToken.Pos, "expected a path part, got %s instead", p.peekToken.Type)
+			return nil
+		}
+		p.nextToken()
+		path.Parts = append(path.Parts, p.curToken.Literal)
+	}
+	return path
+}
+
+// startsValue reports whether a token of type t can start a value
+func startsValue(t token.TokenType) bool {
+	switch t {
+	case token.STRING, token.NUMBER, token.IDENT, token.LBRACE, token.LBRACKET:
+		return true
+	}
+	return false
+}
+
+func (p *Parser) parseObjectLiteral() ast.Expression {
+	object := &ast.ObjectLiteral{Token: p.curToken, Fields: []*ast.Assignment{}}
+	for _, stmt := range p.parseBlockStatement().Statements {
+		switch field := stmt.(type) {
+		case *ast.Assignment:
+			object.Fields = append(object.Fields, field)
+		case *ast.Comment:
+			// Comments inside inline objects are not kept
+		default:
+			p.addError(object.Token.Pos, "objects may only hold fields, got %q", stmt.TokenLiteral())
+		}
+	}
+	return object
+}
+
+func (p *Parser) parseArrayLiteral() ast.Expression {
+	array := &ast.ArrayLiteral{Token: p.curToken, Elements: []ast.Expression{}}
+	p.nextToken()
+	for !p.curTokenIs(token.RBRACKET) {
+		if p.curTokenIs(token.EOF) {
+			p.addError(p.curToken.Pos, "expected %s, got %s instead", token.RBRACKET, token.EOF)
+			return nil
+		}
+		element := p.parseExpression()
+		if element == nil {
+			return nil
+		}
+		array.Elements = append(array.Elements, element)
+		p.nextToken()
+		if p.curTokenIs(token.COMMA) {
+			p.nextToken()
+		}
+	}
+	return array
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
@@ -194,6 +355,9 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		}
 		p.nextToken()
 	}
+	if p.curTokenIs(token.EOF) {
+		p.addError(block.Token.Pos, "unclosed %s", token.LBRACE)
+	}
 
 	return block
 }
@@ -66,6 +66,40 @@ func TestParser(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "keyword property",
+			input: `node "transformer" {
+				type: "Transform"
+			}`,
+			want: &ast.Program{
+				Statements: []ast.Statement{
+					&ast.FlowNode{
+						Token: token.Token{Type: token.NODE, Literal: "node"},
+						Name: &ast.Identifier{
+							Token: token.Token{Type: token.STRING, Literal: "transformer"},
+							Value: "transformer",
+						},
+						Body: &ast.BlockStatement{
+							Token: token.Token{Type: token.LBRACE, Literal: "{"},
+							Statements: []ast.Statement{
+								&ast.Assignment{
+									Token: token.Token{Type: token.TYPE, Literal: "type"},
+									Name: &ast.Identifier{
+										Token: token.Token{Type: token.TYPE, Literal: "type"},
+										Value: "type",
+									},
+									Value: &ast.StringLiteral{
+										Token: token.Token{Type: token.STRING, Literal: "Transform"},
+										Value: "Transform",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
 		{
 			name: "invalid flow",
 			input: `flow "test" {
@@ -146,3 +180,74 @@ func compareAST(t *testing.T, want, got ast.Node) {
 		t.Errorf("Unknown AST node type: %T", want)
 	}
 }
+
+func TestParseDiagnostics(t *testing.T) {
+	log := logger.New()
+
+	// Sections, inline objects, arrays and comments parse
+	program, diagnostics := parser.Parse(`// Orders
+	node "reader" {
+		inputs { data: { type: "text", format: "csv" } }
+		tags: ["a", "b"]
+	}`, log)
+	require.Empty(t, diagnostics)
+	require.Len(t, program.Statements, 2)
+	require.IsType(t, &ast.Comment{}, program.Statements[0])
+	node := program.Statements[1].(*ast.FlowNode)
+	require.IsType(t, &ast.Section{}, node.Body.Statements[0])
+	require.Equal(t, `tags: ["a", "b"]`, node.Body.Statements[1].String())
+
+	// Errors carry the position of the offending token
+	_, diagnostics = parser.Parse(`flow "orders" { retries: }`, log)
+	require.Len(t, diagnostics, 1)
+	require.Equal(t, "1:26: error: expected a value, got RBRACE instead", diagnostics[0].String())
+
+	_, diagnostics = parser.Parse(`flow "orders" {`, log)
+	require.Len(t, diagnostics, 1)
+	require.Contains(t, diagnostics[0].Message, "unclosed")
+
+	// Connections name their ends and may map the messages crossing them
+	program, diagnostics = parser.Parse(`flow "orders" {
+		"read.out" -> "store.in" map { user_id: payload.user.id, first: payload.items.0, kind: payload.type, source: "api" }
+		"read" -> "audit"
+	}`, log)
+	require.Empty(t, diagnostics)
+	flow := program.Statements[0].(*ast.Flow)
+	conn := flow.Body.Statements[0].(*ast.Connection)
+	require.Equal(t, "read.out", conn.From.Value)
+	require.Equal(t, "store.in", conn.To.Value)
+	require.Equal(t, []string{"payload", "user", "id"}, conn.Map.Fields[0].Value.(*ast.Path).Parts)
+	require.Equal(t, "payload.items.0", conn.Map.Fields[1].Value.String())
+	require.Equal(t, "payload.type", conn.Map.Fields[2].Value.String())
+	require.Nil(t, flow.Body.Statements[1].(*ast.Connection).Map)
+
+	_, diagnostics = parser.Parse(`flow "orders" { "read" -> }`, log)
+	require.Len(t, diagnostics, 1)
+	require.Equal(t, "1:27: error: expected next token to be STRING, got RBRACE instead", diagnostics[0].String())
+	_, diagnostics = parser.Parse(`flow "orders" { "read" -> "store" map { id: payload. } }`, log)
+	require.NotEmpty(t, diagnostics)
+	require.Contains(t, diagnostics[0].Message, "expected a path part")
+}
+
+func TestGrammar(t *testing.T) {
+	log := logger.New()
+
+	documented := map[token.TokenType]bool{}
+	names := map[string]bool{}
+	for _, rule := range parser.Grammar {
+		require.False(t, names[rule.Name], "rule %s is defined twice", rule.Name)
+		names[rule.Name] = true
+		require.Contains(t, []string{parser.CategoryBlock, parser.CategoryStatement, parser.CategoryLiteral}, rule.Category)
+
+		_, diagnostics := parser.Parse(rule.Example, log)
+		require.Empty(t, diagnostics, "example of %s", rule.Name)
+		for _, keyword := range rule.Keywords {
+			documented[keyword] = true
+		}
+	}
+
+	// The reference must cover every keyword the lexer knows
+	for literal, keyword := range token.Keywords {
+		require.True(t, documented[keyword], "keyword %q is not used by any rule", literal)
+	}
+}
@@ -59,6 +59,10 @@ const (
 	LBRACKET
 	// RBRACKET represents a right bracket token
 	RBRACKET
+	// DOT represents a dot token, separating the parts of a path
+	DOT
+	// ARROW represents an arrow token, connecting two nodes
+	ARROW
 
 	// FLOW represents the 'flow' keyword token
 	FLOW
@@ -106,6 +110,8 @@ func (tt TokenType) String() string {
 		RBRACE:    "RBRACE",
 		LBRACKET:  "LBRACKET",
 		RBRACKET:  "RBRACKET",
+		DOT:       "DOT",
+		ARROW:     "ARROW",
 		FLOW:      "FLOW",
 		NODE:      "NODE",
 		CONFIG:    "CONFIG",
@@ -0,0 +1,286 @@
+// Package restart hands a running server over to a new binary without
+// losing running flows or refusing connections.
+//
+// A restart drains the old process of the runs whose state lives in its
+// memory, stops it from serving, checkpoints the flows that are running,
+// and starts the new binary with the listening socket and the checkpoint.
+// The new process resumes the checkpointed flows before it serves the
+// connections that queued on the socket in the meantime:
+//
+//	file, err := restart.File(ln)
+//	// ... drain backfills, shut down the HTTP server, stop changing flows ...
+//	cp, err := restart.Take(store)
+//	err = cp.Write(path)
+//	proc, err := restart.Exec(file, path)
+//
+// and in the new process:
+//
+//	resumed, err := restart.ResumeFromEnv(store, log)
+//	ln, err := restart.Listen(addr)
+package restart
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"flow-control/internal/store"
+	"flow-control/internal/types"
+)
+
+// Environment variables passing the restart state to the new process
+const (
+	// EnvListenerFD holds the descriptor of the inherited listening socket
+	EnvListenerFD = "FLOWCONTROL_LISTENER_FD"
+	// EnvCheckpoint holds the path of the checkpoint to resume
+	EnvCheckpoint = "FLOWCONTROL_CHECKPOINT"
+)
+
+// listenerFD is the descriptor of the listening socket in the new process,
+// the first of its extra files
+const listenerFD = 3
+
+// ErrInProgress is returned when a restart is requested while another one
+// is under way
+var ErrInProgress = errors.New("restart already in progress")
+
+// Trigger collects restart requests from signals and the admin API, so that
+// the server restarts once however many requests arrive
+type Trigger struct {
+	requested atomic.Bool
+	c         chan struct{}
+}
+
+// NewTrigger creates a trigger no restart was requested from
+func NewTrigger() *Trigger {
+	return &Trigger{c: make(chan struct{}, 1)}
+}
+
+// Request asks for a restart. It fails with ErrInProgress when a restart was
+// already requested.
+func (t *Trigger) Request() error {
+	if !t.requested.CompareAndSwap(false, true) {
+		return ErrInProgress
+	}
+	t.c <- struct{}{}
+	return nil
+}
+
+// C receives once a restart is requested
+func (t *Trigger) C() <-chan struct{} {
+	return t.c
+}
+
+// Reset allows another restart after a requested one was abandoned
+func (t *Trigger) Reset() {
+	t.requested.Store(false)
+}
+
+// Checkpoint records the flows that were running when a restart began
+type Checkpoint struct {
+	Taken time.Time `json:"taken"`
+	Flows []Flow    `json:"flows"`
+}
+
+// Flow is a running flow with the values of its parameters
+type Flow struct {
+	ID     string            `json:"id"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// Take checkpoints the running flows of s. Nothing may start or stop flows
+// until the checkpoint is resumed, or the new process would undo it. Only
+// the status and parameters of flows are kept, so the runs holding state
+// in memory, such as backfills, must have been drained first.
+func Take(s store.Store) (*Checkpoint, error) {
+	flows, err := s.ListFlows()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list flows: %w", err)
+	}
+
+	cp := &Checkpoint{Taken: time.Now().UTC(), Flows: []Flow{}}
+	for _, flow := range flows {
+		if flow.Status == types.FlowStatusRunning {
+			cp.Flows = append(cp.Flows, Flow{ID: flow.ID, Params: flow.Params})
+		}
+	}
+	return cp, nil
+}
+
+// Write saves the checkpoint to path, replacing the file at once so that a
+// reader never sees half of it. Only the owner may read the file, as the
+// parameters of flows may be sensitive.
+func (cp *Checkpoint) Write(path string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if err := tmp.Chmod(0o600); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// ReadCheckpoint loads the checkpoint saved at path
+func ReadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint: %w", err)
+	}
+	return &cp, nil
+}
+
+// Resume marks the checkpointed flows as running again with their
+// parameters and returns how many it resumed. Flows deleted since the
+// checkpoint are skipped.
+func (cp *Checkpoint) Resume(s store.Store, log types.Logger) (int, error) {
+	resumed := 0
+	for _, flow := range cp.Flows {
+		err := s.StartFlow(flow.ID, flow.Params)
+		if errors.Is(err, store.ErrFlowNotFound) {
+			log.Warn("Checkpointed flow no longer exists", types.Fields{
+				"function": "Resume",
+				"flow_id":  flow.ID,
+			})
+			continue
+		}
+		if err != nil {
+			return resumed, fmt.Errorf("failed to resume flow %s: %w", flow.ID, err)
+		}
+		resumed++
+	}
+	return resumed, nil
+}
+
+// ResumeFromEnv resumes the checkpoint a restarting process passed on and
+// removes it. It does nothing in processes that were not started by a
+// restart.
+func ResumeFromEnv(s store.Store, log types.Logger) (int, error) {
+	path := os.Getenv(EnvCheckpoint)
+	if path == "" {
+		return 0, nil
+	}
+	cp, err := ReadCheckpoint(path)
+	if err != nil {
+		return 0, err
+	}
+	resumed, err := cp.Resume(s, log)
+	if err != nil {
+		return resumed, err
+	}
+	if err := os.Remove(path); err != nil {
+		log.Error("Failed to remove checkpoint", err, types.Fields{
+			"function": "ResumeFromEnv",
+			"path":     path,
+		})
+	}
+	return resumed, nil
+}
+
+// Listen returns the listening socket a restarting process passed on, or
+// listens on addr in processes that were not started by a restart
+func Listen(addr string) (net.Listener, error) {
+	value := os.Getenv(EnvListenerFD)
+	if value == "" {
+		return net.Listen("tcp", addr)
+	}
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", EnvListenerFD, value, err)
+	}
+	file := os.NewFile(uintptr(fd), "listener")
+	if file == nil {
+		return nil, fmt.Errorf("invalid %s %q", EnvListenerFD, value)
+	}
+	defer func() { _ = file.Close() }()
+
+	ln, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inherit listener: %w", err)
+	}
+	return ln, nil
+}
+
+// File duplicates the socket of ln, which stays open after ln is closed so
+// that connections queue on it until the new process serves them
+func File(ln net.Listener) (*os.File, error) {
+	filer, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("listener %T cannot be passed on", ln)
+	}
+	file, err := filer.File()
+	if err != nil {
+		return nil, fmt.Errorf("failed to duplicate listener: %w", err)
+	}
+	return file, nil
+}
+
+// Exec starts the binary of the running process again with its arguments,
+// passing on the listening socket and the checkpoint at path. The file is
+// closed once the new process has it.
+func Exec(listener *os.File, checkpoint string) (*os.Process, error) {
+	defer func() { _ = listener.Close() }()
+
+	binary, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find executable: %w", err)
+	}
+
+	cmd := exec.Command(binary, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{listener}
+	cmd.Env = append(environ(),
+		fmt.Sprintf("%s=%d", EnvListenerFD, listenerFD),
+		fmt.Sprintf("%s=%s", EnvCheckpoint, checkpoint),
+	)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", binary, err)
+	}
+	return cmd.Process, nil
+}
+
+// environ returns the environment of the process without the restart state
+// it may have inherited itself
+func environ() []string {
+	env := os.Environ()
+	kept := env[:0]
+	for _, kv := range env {
+		if strings.HasPrefix(kv, EnvListenerFD+"=") || strings.HasPrefix(kv, EnvCheckpoint+"=") {
+			continue
+		}
+		kept = append(kept, kv)
+	}
+	return kept
+}
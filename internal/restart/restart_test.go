@@ -0,0 +1,107 @@
+package restart_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"flow-control/internal/logger"
+	"flow-control/internal/restart"
+	"flow-control/internal/store"
+	"flow-control/internal/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpoint(t *testing.T) {
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "restart.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	for _, id := range []string{"ingest", "report", "idle"} {
+		require.NoError(t, st.CreateFlow(&types.RuntimeFlow{ID: id, Name: id, Config: "{}", Status: "stopped"}))
+	}
+	require.NoError(t, st.StartFlow("ingest", map[string]string{"region": "eu"}))
+	require.NoError(t, st.StartFlow("report", nil))
+
+	// Only running flows are checkpointed, with their parameters
+	cp, err := restart.Take(st)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []restart.Flow{
+		{ID: "ingest", Params: map[string]string{"region": "eu"}},
+		{ID: "report"},
+	}, cp.Flows)
+
+	path := filepath.Join(t.TempDir(), "flows.checkpoint")
+	require.NoError(t, cp.Write(path))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	loaded, err := restart.ReadCheckpoint(path)
+	require.NoError(t, err)
+	require.ElementsMatch(t, cp.Flows, loaded.Flows)
+
+	// Resuming restores flows stopped since, skipping deleted ones
+	require.NoError(t, st.UpdateFlowStatus("ingest", "stopped"))
+	require.NoError(t, st.DeleteFlow("report"))
+
+	t.Setenv(restart.EnvCheckpoint, path)
+	resumed, err := restart.ResumeFromEnv(st, log)
+	require.NoError(t, err)
+	require.Equal(t, 1, resumed)
+
+	flow, err := st.GetFlow("ingest")
+	require.NoError(t, err)
+	require.Equal(t, "running", flow.Status)
+	require.Equal(t, map[string]string{"region": "eu"}, flow.Params)
+
+	// The checkpoint is resumed once
+	require.NoFileExists(t, path)
+}
+
+func TestTrigger(t *testing.T) {
+	trigger := restart.NewTrigger()
+	require.NoError(t, trigger.Request())
+	require.ErrorIs(t, trigger.Request(), restart.ErrInProgress)
+	<-trigger.C()
+
+	trigger.Reset()
+	require.NoError(t, trigger.Request())
+	<-trigger.C()
+}
+
+func TestListen(t *testing.T) {
+	// Without an inherited socket a new one is opened
+	t.Setenv(restart.EnvListenerFD, "")
+	ln, err := restart.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = ln.Close() }()
+
+	// The socket stays open after the listener is closed
+	file, err := restart.File(ln)
+	require.NoError(t, err)
+	defer func() { _ = file.Close() }()
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	t.Setenv(restart.EnvListenerFD, fmt.Sprint(file.Fd()))
+	inherited, err := restart.Listen("ignored:0")
+	require.NoError(t, err)
+	defer func() { _ = inherited.Close() }()
+	require.Equal(t, addr, inherited.Addr().String())
+
+	// Connections made to the socket are accepted by the inherited listener
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+	accepted, err := inherited.Accept()
+	require.NoError(t, err)
+	require.NoError(t, accepted.Close())
+
+	t.Setenv(restart.EnvListenerFD, "not-a-descriptor")
+	_, err = restart.Listen("127.0.0.1:0")
+	require.Error(t, err)
+}
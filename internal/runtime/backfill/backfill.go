@@ -0,0 +1,192 @@
+/*
+Package backfill runs flows over bounded historical input, such as the
+lines of files, the rows of a SQL query or the windows of a date range,
+until all of it went through, reporting the progress made on the way.
+*/
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"flow-control/internal/types"
+	"flow-control/pkg/api"
+)
+
+// DefaultStep is the length of the windows of ranges without a step
+const DefaultStep = 24 * time.Hour
+
+// Source is the Metadata.Source of the messages of backfills
+const Source = "backfill"
+
+// The input and progress of backfills are those of the HTTP API
+type (
+	// Input is the bounded input of a backfill
+	Input = api.BackfillInput
+	// Query is a SQL query of a database
+	Query = api.BackfillQuery
+	// Range is a time range split into windows
+	Range = api.BackfillRange
+	// Progress measures how far a backfill went
+	Progress = api.BackfillProgress
+)
+
+// ErrSourceDenied is returned for input outside the Sources of a run
+var ErrSourceDenied = errors.New("input not allowed")
+
+// Sources are the files and databases backfills may read. They are set by
+// operators rather than requests, so that requests cannot make the server
+// read any file or open any database it can.
+type Sources struct {
+	// DataDir holds the files backfills read. Their paths are relative to
+	// it and may not lead out of it, directly or through links. Without
+	// it, backfills cannot read files.
+	DataDir string
+	// Databases are the databases queries may run on, by the name their
+	// Database refers to them by
+	Databases map[string]Database
+}
+
+// Database is a database queries may run on
+type Database struct {
+	// Driver is postgres or sqlite
+	Driver string
+	// DSN is the data source name of the database
+	DSN string
+}
+
+// Processor is the flow a backfill sends its messages to, such as a
+// started engine.Engine
+type Processor interface {
+	Process(ctx context.Context, msg types.Message) error
+}
+
+// Run sends every message of input, which must be within sources, to flow
+// in order and returns once all of them went through, calling report, when not nil, with the progress
+// after each message. Messages the flow fails on do not stop the run;
+// errors reading the input and ctx being done do. The progress made is
+// returned either way.
+func Run(ctx context.Context, input Input, sources Sources, flow Processor, report func(Progress)) (Progress, error) {
+	if err := input.Validate(); err != nil {
+		return Progress{}, err
+	}
+	r := &run{ctx: ctx, sources: sources, flow: flow, report: report}
+
+	var err error
+	switch {
+	case len(input.Files) > 0:
+		err = r.files(input.Files)
+	case input.Range != nil:
+		err = r.windows(input.Range, input.Query)
+	default:
+		err = r.rows(input.Query)
+	}
+	if err != nil {
+		return r.progress, err
+	}
+	r.advance(r.progress.Total)
+	return r.progress, nil
+}
+
+// run is the state of a backfill
+type run struct {
+	ctx      context.Context
+	sources  Sources
+	flow     Processor
+	report   func(Progress)
+	progress Progress
+}
+
+// send passes a message holding data to the flow
+func (r *run) send(data json.RawMessage) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	r.progress.Messages++
+	msg := types.Message{
+		ID:   strconv.FormatInt(r.progress.Messages, 10),
+		Data: data,
+		Metadata: types.MessageMetadata{
+			Timestamp: time.Now(),
+			Source:    Source,
+		},
+	}
+	if err := r.flow.Process(r.ctx, msg); err != nil && r.ctx.Err() != nil {
+		return r.ctx.Err()
+	}
+	return nil
+}
+
+// start sets the total of the run and reports it
+func (r *run) start(total int64) {
+	r.progress.Total = total
+	r.advance(0)
+}
+
+// advance records done as the input read so far and reports the progress
+func (r *run) advance(done int64) {
+	if done > r.progress.Total {
+		done = r.progress.Total
+	}
+	r.progress.Done = done
+	r.progress.Percent = 100
+	if r.progress.Total > 0 {
+		r.progress.Percent = float64(done) * 100 / float64(r.progress.Total)
+	}
+	if r.report != nil {
+		r.report(r.progress)
+	}
+}
+
+// windows sends the windows of rng, or the rows query returns for each
+func (r *run) windows(rng *Range, query *Query) error {
+	step := DefaultStep
+	if rng.StepSeconds > 0 {
+		step = time.Duration(rng.StepSeconds) * time.Second
+	}
+	span := rng.To.Sub(rng.From)
+	count := int64(span / step)
+	if span%step != 0 {
+		count++
+	}
+
+	var db *database
+	if query != nil {
+		var err error
+		if db, err = r.openDatabase(query); err != nil {
+			return err
+		}
+		defer db.Close()
+	}
+
+	r.start(count)
+	for i := int64(0); i < count; i++ {
+		from := rng.From.Add(time.Duration(i) * step)
+		to := from.Add(step)
+		if to.After(rng.To) {
+			to = rng.To
+		}
+		if db != nil {
+			if err := db.each(r.ctx, query.SQL, r.send, db.timeArg(from), db.timeArg(to)); err != nil {
+				return err
+			}
+		} else {
+			data, err := json.Marshal(struct {
+				From time.Time `json:"from"`
+				To   time.Time `json:"to"`
+			}{from, to})
+			if err != nil {
+				return fmt.Errorf("failed to encode window: %w", err)
+			}
+			if err := r.send(data); err != nil {
+				return err
+			}
+		}
+		r.advance(i + 1)
+	}
+	return nil
+}
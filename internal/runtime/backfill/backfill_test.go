@@ -0,0 +1,160 @@
+package backfill_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"flow-control/internal/runtime/backfill"
+	"flow-control/internal/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a flow recording the payloads it is sent
+type recorder struct {
+	payloads []string
+	fail     string
+}
+
+func (r *recorder) Process(ctx context.Context, msg types.Message) error {
+	r.payloads = append(r.payloads, string(msg.Data))
+	if string(msg.Data) == r.fail {
+		return errors.New("node failed")
+	}
+	return nil
+}
+
+// run backfills input within sources into a recorder, returning the
+// progress reported
+func run(t *testing.T, input backfill.Input, sources backfill.Sources, flow *recorder) ([]backfill.Progress, error) {
+	t.Helper()
+	var reports []backfill.Progress
+	last, err := backfill.Run(context.Background(), input, sources, flow, func(p backfill.Progress) {
+		reports = append(reports, p)
+	})
+	if len(reports) > 0 {
+		require.Equal(t, reports[len(reports)-1], last)
+	}
+	for i := 1; i < len(reports); i++ {
+		require.GreaterOrEqual(t, reports[i].Done, reports[i-1].Done)
+	}
+	return reports, err
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.jsonl")
+	second := filepath.Join(dir, "second.jsonl")
+	require.NoError(t, os.WriteFile(first, []byte("{\"n\":1}\n\n{\"n\":2}\n"), 0o644))
+	require.NoError(t, os.WriteFile(second, []byte("plain text"), 0o644))
+
+	// Failures of the flow on a message do not stop the run
+	sources := backfill.Sources{DataDir: dir}
+	flow := &recorder{fail: `{"n":2}`}
+	reports, err := run(t, backfill.Input{Files: []string{"first.jsonl", "second.jsonl"}}, sources, flow)
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"n":1}`, `{"n":2}`, `"plain text"`}, flow.payloads)
+	require.Equal(t, int64(27), reports[0].Total)
+	require.Equal(t, 0.0, reports[0].Percent)
+	require.Equal(t, backfill.Progress{Done: 27, Total: 27, Percent: 100, Messages: 3}, reports[len(reports)-1])
+
+	_, err = run(t, backfill.Input{Files: []string{"missing.jsonl"}}, sources, &recorder{})
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// Files outside the data directory cannot be read, even through links
+	outside := filepath.Join(t.TempDir(), "secret.jsonl")
+	require.NoError(t, os.WriteFile(outside, []byte("{}\n"), 0o644))
+	require.NoError(t, os.Symlink(outside, filepath.Join(dir, "link.jsonl")))
+	flow = &recorder{}
+	_, err = run(t, backfill.Input{Files: []string{"link.jsonl"}}, sources, flow)
+	require.ErrorIs(t, err, backfill.ErrSourceDenied)
+	require.Empty(t, flow.payloads)
+	_, err = run(t, backfill.Input{Files: []string{"first.jsonl"}}, backfill.Sources{}, &recorder{})
+	require.ErrorIs(t, err, backfill.ErrSourceDenied)
+}
+
+func TestQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.db")
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE orders (id INTEGER, placed TEXT);
+		INSERT INTO orders VALUES (1, '2024-01-01T10:00:00Z'), (2, '2024-01-02T10:00:00Z'), (3, '2024-01-02T12:00:00Z'), (4, '2024-01-05T10:00:00Z');
+	`)
+	require.NoError(t, err)
+
+	sources := backfill.Sources{Databases: map[string]backfill.Database{"orders": {Driver: "sqlite", DSN: path}}}
+	query := &backfill.Query{Database: "orders", SQL: "SELECT id FROM orders WHERE id > 1 ORDER BY id;"}
+	flow := &recorder{}
+	reports, err := run(t, backfill.Input{Query: query}, sources, flow)
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"id":2}`, `{"id":3}`, `{"id":4}`}, flow.payloads)
+	require.Equal(t, int64(3), reports[0].Total)
+	require.Equal(t, 100.0/3, reports[1].Percent)
+	require.Equal(t, backfill.Progress{Done: 3, Total: 3, Percent: 100, Messages: 3}, reports[len(reports)-1])
+
+	// With a range, the query runs once per window
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	flow = &recorder{}
+	reports, err = run(t, backfill.Input{
+		Query: &backfill.Query{Database: "orders", SQL: "SELECT id FROM orders WHERE placed >= ? AND placed < ? ORDER BY id"},
+		Range: &backfill.Range{From: from, To: from.AddDate(0, 0, 3)},
+	}, sources, flow)
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"id":1}`, `{"id":2}`, `{"id":3}`}, flow.payloads)
+	require.Equal(t, backfill.Progress{Done: 3, Total: 3, Percent: 100, Messages: 3}, reports[len(reports)-1])
+
+	_, err = run(t, backfill.Input{Query: &backfill.Query{Database: "orders", SQL: "SELECT * FROM missing"}}, sources, &recorder{})
+	require.ErrorContains(t, err, "failed to count input rows")
+
+	// Queries only run on the databases of the sources
+	_, err = run(t, backfill.Input{Query: &backfill.Query{Database: "other", SQL: "SELECT 1"}}, sources, &recorder{})
+	require.ErrorIs(t, err, backfill.ErrSourceDenied)
+}
+
+func TestRange(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	flow := &recorder{}
+	reports, err := run(t, backfill.Input{Range: &backfill.Range{From: from, To: from.Add(5 * time.Hour), StepSeconds: 7200}}, backfill.Sources{}, flow)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		`{"from":"2024-01-01T00:00:00Z","to":"2024-01-01T02:00:00Z"}`,
+		`{"from":"2024-01-01T02:00:00Z","to":"2024-01-01T04:00:00Z"}`,
+		`{"from":"2024-01-01T04:00:00Z","to":"2024-01-01T05:00:00Z"}`,
+	}, flow.payloads)
+	require.Equal(t, backfill.Progress{Done: 3, Total: 3, Percent: 100, Messages: 3}, reports[len(reports)-1])
+
+	// Cancelled runs stop with the progress made
+	ctx, cancel := context.WithCancel(context.Background())
+	progress, err := backfill.Run(ctx, backfill.Input{Range: &backfill.Range{From: from, To: from.AddDate(1, 0, 0)}}, backfill.Sources{}, &recorder{}, func(p backfill.Progress) {
+		if p.Done == 10 {
+			cancel()
+		}
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, int64(10), progress.Done)
+	require.Equal(t, int64(366), progress.Total)
+}
+
+func TestValidate(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, input := range map[string]backfill.Input{
+		"empty":           {},
+		"empty path":      {Files: []string{""}},
+		"files and range": {Files: []string{"a"}, Range: &backfill.Range{From: from, To: from.Add(time.Hour)}},
+		"absolute path":   {Files: []string{"/etc/passwd"}},
+		"parent path":     {Files: []string{"../secret.jsonl"}},
+		"no database":     {Query: &backfill.Query{SQL: "SELECT 1"}},
+		"no sql":          {Query: &backfill.Query{Database: "orders"}},
+		"empty range":     {Range: &backfill.Range{From: from, To: from}},
+		"negative step":   {Range: &backfill.Range{From: from, To: from.Add(time.Hour), StepSeconds: -1}},
+	} {
+		require.Error(t, input.Validate(), name)
+	}
+}
@@ -0,0 +1,114 @@
+package backfill
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// files sends the lines of the files at paths, measuring progress in bytes
+func (r *run) files(names []string) error {
+	if r.sources.DataDir == "" {
+		return fmt.Errorf("%w: backfills of files need a data directory", ErrSourceDenied)
+	}
+	paths := make([]string, len(names))
+	for i, name := range names {
+		path, err := dataPath(r.sources.DataDir, name)
+		if err != nil {
+			return err
+		}
+		paths[i] = path
+	}
+
+	var total int64
+	sizes := make([]int64, len(paths))
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to read input file: %w", err)
+		}
+		sizes[i] = info.Size()
+		total += sizes[i]
+	}
+
+	r.start(total)
+	var base int64
+	for i, path := range paths {
+		if err := r.file(path, base); err != nil {
+			return err
+		}
+		base += sizes[i]
+		r.advance(base)
+	}
+	return nil
+}
+
+// file sends the lines of the file at path, after base bytes of input
+func (r *run) file(path string, base int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read input file: %w", err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReaderSize(f, 64*1024)
+	var read int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		read += int64(len(line))
+		if text := bytes.TrimSpace(line); len(text) > 0 {
+			if err := r.send(lineData(text)); err != nil {
+				return err
+			}
+			r.advance(base + read)
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+}
+
+// dataPath returns the path of the file name in dir once links are
+// followed, unless it leads out of dir
+func dataPath(dir, name string) (string, error) {
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("%w: file %s", ErrSourceDenied, name)
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to open data directory: %w", err)
+	}
+	if realDir, err = filepath.Abs(realDir); err != nil {
+		return "", fmt.Errorf("failed to open data directory: %w", err)
+	}
+	// Links may lead out of the directory, so the file is checked once
+	// they are followed
+	path, err := filepath.EvalSymlinks(filepath.Join(realDir, name))
+	if err != nil {
+		return "", fmt.Errorf("failed to read input file: %w", err)
+	}
+	rel, err := filepath.Rel(realDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: file %s", ErrSourceDenied, name)
+	}
+	return path, nil
+}
+
+// lineData returns a line of input as message data, encoding lines that
+// are not JSON as JSON strings
+func lineData(line []byte) json.RawMessage {
+	data := json.RawMessage(line)
+	if !json.Valid(data) {
+		data, _ = json.Marshal(string(line))
+	}
+	return data
+}
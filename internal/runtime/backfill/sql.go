@@ -0,0 +1,121 @@
+package backfill
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqlDrivers maps the drivers of queries to their database/sql names
+var sqlDrivers = map[string]string{
+	"postgres": "pgx",
+	"sqlite":   "sqlite3",
+}
+
+// database is the database a query runs on
+type database struct {
+	*sql.DB
+	driver string
+}
+
+// openDatabase opens the database of the sources of the run query names
+func (r *run) openDatabase(query *Query) (*database, error) {
+	source, ok := r.sources.Databases[query.Database]
+	if !ok {
+		return nil, fmt.Errorf("%w: database %s", ErrSourceDenied, query.Database)
+	}
+	driverName, ok := sqlDrivers[source.Driver]
+	if !ok {
+		return nil, fmt.Errorf("driver of database %s must be postgres or sqlite", query.Database)
+	}
+	db, err := sql.Open(driverName, source.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input database: %w", err)
+	}
+	return &database{DB: db, driver: source.Driver}, nil
+}
+
+// timeArg returns t as a query argument. SQLite has no time type, so it is
+// given times as RFC 3339 text, which sorts in time order.
+func (db *database) timeArg(t time.Time) interface{} {
+	if db.driver == "sqlite" {
+		return t.UTC().Format(time.RFC3339)
+	}
+	return t
+}
+
+// rows sends the rows of query, measuring progress in rows
+func (r *run) rows(query *Query) error {
+	db, err := r.openDatabase(query)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Queries are counted first, so progress can be told as they run
+	var total int64
+	count := "SELECT COUNT(*) FROM (" + strings.TrimRight(strings.TrimSpace(query.SQL), ";") + ") AS backfill"
+	if err := db.QueryRowContext(r.ctx, count).Scan(&total); err != nil {
+		return fmt.Errorf("failed to count input rows: %w", err)
+	}
+
+	r.start(total)
+	var done int64
+	return db.each(r.ctx, query.SQL, func(data json.RawMessage) error {
+		if err := r.send(data); err != nil {
+			return err
+		}
+		done++
+		r.advance(done)
+		return nil
+	})
+}
+
+// each calls send with each row query returns for args, as a JSON object
+// of its columns
+func (db *database) each(ctx context.Context, query string, send func(json.RawMessage) error, args ...interface{}) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query input: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("failed to query input: %w", err)
+	}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return fmt.Errorf("failed to read input row: %w", err)
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[column] = values[i]
+		}
+		data, err := json.Marshal(row)
+		if err != nil {
+			return fmt.Errorf("failed to encode input row: %w", err)
+		}
+		if err := send(data); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read input rows: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+/*
+Package diagram renders flow graphs as diagrams: Graphviz DOT, Mermaid
+flowcharts and standalone SVG images.
+
+Every node is drawn with its name, type and ports. Ports are the fields of
+the node's inputs and outputs sections, labelled with their type when the
+field's value is a type name:
+
+	node "enrich" {
+	  type: "Transform"
+	  qos: "at-least-once"
+	  inputs { orders: "json" }
+	  outputs { enriched: "json" }
+	}
+
+Connections are drawn from each node to the nodes receiving its output and
+are annotated with the receiving node's qos setting, when it has one.
+*/
+package diagram
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"flow-control/internal/runtime/engine"
+)
+
+// Diagram formats
+const (
+	FormatDOT     = "dot"
+	FormatMermaid = "mermaid"
+	FormatSVG     = "svg"
+)
+
+// ErrUnknownFormat is returned for unsupported diagram formats
+var ErrUnknownFormat = errors.New("unknown diagram format")
+
+// Formats lists the supported diagram formats
+func Formats() []string {
+	return []string{FormatDOT, FormatMermaid, FormatSVG}
+}
+
+// Render renders graph in format
+func Render(graph *engine.Graph, format string) ([]byte, error) {
+	switch format {
+	case FormatDOT:
+		return DOT(graph), nil
+	case FormatMermaid:
+		return Mermaid(graph), nil
+	case FormatSVG:
+		return SVG(graph), nil
+	}
+	return nil, fmt.Errorf("%w %q: must be one of %s", ErrUnknownFormat, format, strings.Join(Formats(), ", "))
+}
+
+// ContentType returns the media type of diagrams in format
+func ContentType(format string) string {
+	switch format {
+	case FormatDOT:
+		return "text/vnd.graphviz; charset=utf-8"
+	case FormatSVG:
+		return "image/svg+xml"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// box is a node as drawn in a diagram
+type box struct {
+	id      string
+	typ     string
+	inputs  []string
+	outputs []string
+}
+
+// lines returns the text lines of the box: name, type and ports
+func (b box) lines() []string {
+	lines := []string{b.id, b.typ}
+	if len(b.inputs) > 0 {
+		lines = append(lines, "in: "+strings.Join(b.inputs, ", "))
+	}
+	if len(b.outputs) > 0 {
+		lines = append(lines, "out: "+strings.Join(b.outputs, ", "))
+	}
+	return lines
+}
+
+// edge is a connection between two nodes
+type edge struct {
+	from, to string
+	qos      string
+}
+
+// layout extracts the boxes and edges of a graph, in graph order
+func layout(graph *engine.Graph) ([]box, []edge) {
+	boxes := make([]box, 0, len(graph.Nodes))
+	var edges []edge
+	for _, node := range graph.Nodes {
+		settings := node.Config.Settings
+		boxes = append(boxes, box{
+			id:      node.Config.ID,
+			typ:     node.Config.Type,
+			inputs:  ports(settings["inputs"]),
+			outputs: ports(settings["outputs"]),
+		})
+		qos, _ := settings["qos"].(string)
+		for _, from := range node.From {
+			edges = append(edges, edge{from: from, to: node.Config.ID, qos: qos})
+		}
+	}
+	return boxes, edges
+}
+
+// ports returns the labels of the ports declared in an inputs or outputs
+// section, in alphabetical order
+func ports(section interface{}) []string {
+	fields, ok := section.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	labels := make([]string, 0, len(fields))
+	for name, value := range fields {
+		label := name
+		if typ, ok := portType(value); ok {
+			label += ": " + typ
+		}
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// portType returns the type of a port, given as a string or as the type
+// field of an object
+func portType(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, v != ""
+	case map[string]interface{}:
+		typ, ok := v["type"].(string)
+		return typ, ok && typ != ""
+	}
+	return "", false
+}
@@ -0,0 +1,111 @@
+package diagram_test
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"flow-control/internal/logger"
+	"flow-control/internal/runtime/diagram"
+	"flow-control/internal/runtime/engine"
+
+	"github.com/stretchr/testify/require"
+)
+
+const orders = `flow "orders" {
+  node "read" {
+    type: "Passthrough"
+    outputs { orders: "json" }
+  }
+  node "paid" {
+    type: "Filter"
+    qos: "at-least-once"
+    inputs { orders: { type: "json" }, raw: 1 }
+  }
+  node "audit" {
+    type: "Passthrough"
+    from: "read"
+  }
+  node "bill <main> & co" {
+    type: "Transform"
+    from: ["paid", "audit"]
+  }
+}`
+
+func load(t *testing.T) *engine.Graph {
+	t.Helper()
+	graph, err := engine.LoadSource(orders, "", logger.New())
+	require.NoError(t, err)
+	return graph
+}
+
+func TestDOT(t *testing.T) {
+	require.Equal(t, `digraph "orders" {
+  rankdir=LR;
+  node [shape=box, style=rounded, fontname="Helvetica"];
+  edge [fontname="Helvetica", fontsize=10];
+  "read" [label="read\nPassthrough\nout: orders: json"];
+  "paid" [label="paid\nFilter\nin: orders: json, raw"];
+  "audit" [label="audit\nPassthrough"];
+  "bill <main> & co" [label="bill <main> & co\nTransform"];
+  "read" -> "paid" [label="at-least-once"];
+  "read" -> "audit";
+  "paid" -> "bill <main> & co";
+  "audit" -> "bill <main> & co";
+}
+`, string(diagram.DOT(load(t))))
+}
+
+func TestMermaid(t *testing.T) {
+	require.Equal(t, `flowchart LR
+  n0["<b>read</b><br/>Passthrough<br/>out: orders: json"]
+  n1["<b>paid</b><br/>Filter<br/>in: orders: json, raw"]
+  n2["<b>audit</b><br/>Passthrough"]
+  n3["<b>bill #lt;main#gt; & co</b><br/>Transform"]
+  n0 -->|"at-least-once"| n1
+  n0 --> n2
+  n1 --> n3
+  n2 --> n3
+`, string(diagram.Mermaid(load(t))))
+}
+
+func TestSVG(t *testing.T) {
+	svg := diagram.SVG(load(t))
+
+	// The image is well-formed XML
+	decoder := xml.NewDecoder(strings.NewReader(string(svg)))
+	for {
+		_, err := decoder.Token()
+		if err != nil {
+			require.Equal(t, "EOF", err.Error())
+			break
+		}
+	}
+
+	// Receivers are placed in columns after their senders
+	out := string(svg)
+	require.Contains(t, out, `<rect x="20" y="20" width="135"`)
+	require.Contains(t, out, `<g id="node-paid">`+"\n"+`    <rect x="235" y="20"`)
+	require.Contains(t, out, `<g id="node-audit">`+"\n"+`    <rect x="235" y="108"`)
+	require.Contains(t, out, `<g id="node-bill &lt;main&gt; &amp; co">`+"\n"+`    <rect x="478" y="20"`)
+	require.Contains(t, out, ">at-least-once</text>")
+	require.Equal(t, 4, strings.Count(out, "marker-end"))
+
+	// An empty flow still renders
+	graph, err := engine.LoadSource(`flow "empty" {}`, "", logger.New())
+	require.NoError(t, err)
+	require.Contains(t, string(diagram.SVG(graph)), `width="40" height="40"`)
+}
+
+func TestRender(t *testing.T) {
+	graph := load(t)
+	for _, format := range diagram.Formats() {
+		out, err := diagram.Render(graph, format)
+		require.NoError(t, err)
+		require.NotEmpty(t, out)
+		require.NotEmpty(t, diagram.ContentType(format))
+	}
+	_, err := diagram.Render(graph, "png")
+	require.ErrorIs(t, err, diagram.ErrUnknownFormat)
+	require.Equal(t, "image/svg+xml", diagram.ContentType(diagram.FormatSVG))
+}
@@ -0,0 +1,126 @@
+package diagram
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"unicode/utf8"
+
+	"flow-control/internal/runtime/engine"
+)
+
+// SVG layout metrics, in pixels. Text width is estimated from the number
+// of characters since no fonts are available to measure it.
+const (
+	svgMargin     = 20
+	svgCharWidth  = 7
+	svgLineHeight = 16
+	svgPadding    = 8
+	svgMinWidth   = 100
+	svgColumnGap  = 80
+	svgRowGap     = 24
+)
+
+// placed is a box with its position and size
+type placed struct {
+	box
+	x, y, w, h int
+}
+
+// SVG renders graph as a standalone SVG image. Nodes are laid out left to
+// right in columns, each node one column after the furthest node it
+// receives from.
+func SVG(graph *engine.Graph) []byte {
+	boxes, edges := layout(graph)
+
+	// Assign columns; graph order puts senders before receivers
+	column := make(map[string]int, len(boxes))
+	for _, node := range graph.Nodes {
+		for _, from := range node.From {
+			if c := column[from] + 1; c > column[node.Config.ID] {
+				column[node.Config.ID] = c
+			}
+		}
+	}
+
+	// Size the boxes and stack them in their columns
+	var columns [][]*placed
+	for _, b := range boxes {
+		p := &placed{box: b}
+		lines := b.lines()
+		p.w = svgMinWidth
+		for _, line := range lines {
+			if w := utf8.RuneCountInString(line)*svgCharWidth + 2*svgPadding; w > p.w {
+				p.w = w
+			}
+		}
+		p.h = len(lines)*svgLineHeight + 2*svgPadding
+		c := column[b.id]
+		for len(columns) <= c {
+			columns = append(columns, nil)
+		}
+		columns[c] = append(columns[c], p)
+	}
+
+	byID := make(map[string]*placed, len(boxes))
+	x, width, height := svgMargin, 0, 0
+	for _, col := range columns {
+		colWidth, y := 0, svgMargin
+		for _, p := range col {
+			p.x, p.y = x, y
+			y += p.h + svgRowGap
+			if p.w > colWidth {
+				colWidth = p.w
+			}
+			byID[p.id] = p
+		}
+		if y-svgRowGap+svgMargin > height {
+			height = y - svgRowGap + svgMargin
+		}
+		x += colWidth + svgColumnGap
+		width = x - svgColumnGap + svgMargin
+	}
+	if len(columns) == 0 {
+		width, height = 2*svgMargin, 2*svgMargin
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="Helvetica, Arial, sans-serif" font-size="12">`+"\n",
+		width, height, width, height)
+	fmt.Fprintf(&buf, "  <title>%s</title>\n", html.EscapeString(graph.FlowID))
+	buf.WriteString(`  <defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="8" markerHeight="8" orient="auto-start-reverse"><path d="M 0 0 L 10 5 L 0 10 z" fill="#555"/></marker></defs>` + "\n")
+
+	for _, e := range edges {
+		from, to := byID[e.from], byID[e.to]
+		x1, y1 := from.x+from.w, from.y+from.h/2
+		x2, y2 := to.x, to.y+to.h/2
+		fmt.Fprintf(&buf, `  <path d="M %d %d C %d %d, %d %d, %d %d" fill="none" stroke="#555" marker-end="url(#arrow)"/>`+"\n",
+			x1, y1, (x1+x2)/2, y1, (x1+x2)/2, y2, x2, y2)
+		if e.qos != "" {
+			fmt.Fprintf(&buf, `  <text x="%d" y="%d" text-anchor="middle" font-size="10" fill="#555">%s</text>`+"\n",
+				(x1+x2)/2, (y1+y2)/2-4, html.EscapeString(e.qos))
+		}
+	}
+
+	for _, col := range columns {
+		for _, p := range col {
+			fmt.Fprintf(&buf, `  <g id="node-%s">`+"\n", html.EscapeString(p.id))
+			fmt.Fprintf(&buf, `    <rect x="%d" y="%d" width="%d" height="%d" rx="6" fill="#f5f7fa" stroke="#334"/>`+"\n",
+				p.x, p.y, p.w, p.h)
+			for i, line := range p.lines() {
+				style := ""
+				switch i {
+				case 0:
+					style = ` font-weight="bold"`
+				case 1:
+					style = ` fill="#556"`
+				}
+				fmt.Fprintf(&buf, `    <text x="%d" y="%d"%s>%s</text>`+"\n",
+					p.x+svgPadding, p.y+svgPadding+(i+1)*svgLineHeight-4, style, html.EscapeString(line))
+			}
+			buf.WriteString("  </g>\n")
+		}
+	}
+	buf.WriteString("</svg>\n")
+	return buf.Bytes()
+}
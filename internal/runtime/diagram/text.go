@@ -0,0 +1,73 @@
+package diagram
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"flow-control/internal/runtime/engine"
+)
+
+// DOT renders graph in the Graphviz DOT language
+func DOT(graph *engine.Graph) []byte {
+	boxes, edges := layout(graph)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "digraph %s {\n", dotQuote(graph.FlowID))
+	buf.WriteString("  rankdir=LR;\n")
+	buf.WriteString("  node [shape=box, style=rounded, fontname=\"Helvetica\"];\n")
+	buf.WriteString("  edge [fontname=\"Helvetica\", fontsize=10];\n")
+	for _, b := range boxes {
+		fmt.Fprintf(&buf, "  %s [label=%s];\n", dotQuote(b.id), dotQuote(strings.Join(b.lines(), "\n")))
+	}
+	for _, e := range edges {
+		fmt.Fprintf(&buf, "  %s -> %s", dotQuote(e.from), dotQuote(e.to))
+		if e.qos != "" {
+			fmt.Fprintf(&buf, " [label=%s]", dotQuote(e.qos))
+		}
+		buf.WriteString(";\n")
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+// dotQuote quotes s as a DOT string, with newlines as line breaks
+func dotQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// Mermaid renders graph as a Mermaid flowchart
+func Mermaid(graph *engine.Graph) []byte {
+	boxes, edges := layout(graph)
+
+	// Mermaid IDs are restricted, so nodes are numbered and named in labels
+	ids := make(map[string]string, len(boxes))
+	var buf bytes.Buffer
+	buf.WriteString("flowchart LR\n")
+	for i, b := range boxes {
+		ids[b.id] = "n" + strconv.Itoa(i)
+		lines := b.lines()
+		for i, line := range lines {
+			lines[i] = mermaidEscape(line)
+		}
+		lines[0] = "<b>" + lines[0] + "</b>"
+		fmt.Fprintf(&buf, "  %s[\"%s\"]\n", ids[b.id], strings.Join(lines, "<br/>"))
+	}
+	for _, e := range edges {
+		if e.qos != "" {
+			fmt.Fprintf(&buf, "  %s -->|\"%s\"| %s\n", ids[e.from], mermaidEscape(e.qos), ids[e.to])
+		} else {
+			fmt.Fprintf(&buf, "  %s --> %s\n", ids[e.from], ids[e.to])
+		}
+	}
+	return buf.Bytes()
+}
+
+// mermaidEscape replaces the characters Mermaid labels cannot contain with
+// entity codes
+func mermaidEscape(s string) string {
+	r := strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;", "|", "#124;")
+	return r.Replace(s)
+}
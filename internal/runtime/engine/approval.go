@@ -0,0 +1,334 @@
+package engine
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// TypeApproval holds each message until a person approves or rejects it,
+// through Engine.Decide. Approved messages are passed on and rejected ones
+// are sent on the PortRejected output port. Its "title" setting describes
+// what is approved. After "escalate_after_seconds" the approval is
+// escalated to "escalate_to", and after "timeout_seconds" it is decided
+// by "on_timeout", ApprovalReject or ApprovalApprove.
+const TypeApproval = "Approval"
+
+// PortRejected is the output port of the messages Approval nodes reject
+const PortRejected = "rejected"
+
+// Values of the "on_timeout" setting of Approval nodes
+const (
+	ApprovalReject  = "reject"
+	ApprovalApprove = "approve"
+)
+
+// Statuses of an Approval
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+)
+
+// DecidedByTimeout is the Decision.By of approvals decided by their timeout
+const DecidedByTimeout = "timeout"
+
+// Event types reported for the approvals of Approval nodes. Their Data
+// holds the approval_id, along with the by and comment of decisions and
+// the escalate_to of escalations.
+const (
+	TypeApprovalRequested = "approval.requested"
+	TypeApprovalEscalated = "approval.escalated"
+	TypeApprovalApproved  = "approval.approved"
+	TypeApprovalRejected  = "approval.rejected"
+	// TypeApprovalCancelled reports approvals dropped when the flow stopped
+	TypeApprovalCancelled = "approval.cancelled"
+)
+
+// ErrApprovalNotFound is returned when deciding approvals that are not
+// pending
+var ErrApprovalNotFound = errors.New("approval not found")
+
+// Approval is a message an Approval node holds until it is decided
+type Approval struct {
+	ID     string `json:"id"`
+	FlowID string `json:"flow_id"`
+	NodeID string `json:"node_id"`
+	RunID  string `json:"run_id,omitempty"`
+	Title  string `json:"title,omitempty"`
+	// Message is the message awaiting approval
+	Message     types.Message `json:"message"`
+	Status      string        `json:"status"`
+	RequestedAt time.Time     `json:"requested_at"`
+	// Deadline is when the approval times out, if it does
+	Deadline *time.Time `json:"deadline,omitempty"`
+	// EscalateTo is who the approval is escalated to, and EscalatedAt when
+	// it was
+	EscalateTo  string     `json:"escalate_to,omitempty"`
+	EscalatedAt *time.Time `json:"escalated_at,omitempty"`
+	// DecidedBy, Comment and DecidedAt record the decision of approvals
+	// that are not pending
+	DecidedBy string     `json:"decided_by,omitempty"`
+	Comment   string     `json:"comment,omitempty"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+}
+
+// Decision approves or rejects an approval
+type Decision struct {
+	Approve bool
+	// By names who decided
+	By      string
+	Comment string
+}
+
+// approvals holds the pending approvals of an engine
+type approvals struct {
+	mu      sync.Mutex
+	pending map[string]*held
+}
+
+// held is a pending approval and the timers deciding and escalating it
+type held struct {
+	Approval
+	timers []*time.Timer
+}
+
+// approval holds messages until they are approved or rejected
+type approval struct {
+	BaseNode
+	title         string
+	timeout       time.Duration
+	approveOnTime bool
+	escalateAfter time.Duration
+	escalateTo    string
+	engine        *Engine
+}
+
+func newApproval(cfg types.NodeConfig) (types.Node, error) {
+	n := &approval{BaseNode: BaseNode{Config: cfg}}
+	n.title, _ = cfg.Settings["title"].(string)
+	n.escalateTo, _ = cfg.Settings["escalate_to"].(string)
+	timeout, err := numberSetting(cfg.Settings, "timeout_seconds", 0)
+	if err != nil {
+		return nil, err
+	}
+	escalateAfter, err := numberSetting(cfg.Settings, "escalate_after_seconds", 0)
+	if err != nil {
+		return nil, err
+	}
+	n.timeout, n.escalateAfter = seconds(timeout), seconds(escalateAfter)
+	if n.timeout > 0 && n.escalateAfter >= n.timeout {
+		return nil, fmt.Errorf("escalate_after_seconds must be less than timeout_seconds")
+	}
+	onTimeout, _ := cfg.Settings["on_timeout"].(string)
+	switch onTimeout {
+	case "", ApprovalReject:
+	case ApprovalApprove:
+		n.approveOnTime = true
+	default:
+		return nil, fmt.Errorf("on_timeout must be %s or %s", ApprovalReject, ApprovalApprove)
+	}
+	return n, nil
+}
+
+// bind gives the node the engine holding its approvals
+func (n *approval) bind(e *Engine) {
+	n.engine = e
+}
+
+// Ports implements PortNode.Ports
+func (n *approval) Ports() []string {
+	return []string{PortRejected}
+}
+
+// Process implements types.Node.Process, holding the message as a pending
+// approval
+func (n *approval) Process(ctx context.Context, input types.Message) (types.Message, error) {
+	id, err := newID("approval")
+	if err != nil {
+		return types.Message{}, err
+	}
+	now := time.Now()
+	h := &held{Approval: Approval{
+		ID:          id,
+		FlowID:      n.engine.graph.FlowID,
+		NodeID:      n.Config.ID,
+		RunID:       n.engine.opts.RunID,
+		Title:       n.title,
+		Message:     input,
+		Status:      ApprovalPending,
+		RequestedAt: now,
+		EscalateTo:  n.escalateTo,
+	}}
+	if n.timeout > 0 {
+		deadline := now.Add(n.timeout)
+		h.Deadline = &deadline
+	}
+	return types.Message{}, &awaiting{held: h, node: n}
+}
+
+// awaiting is returned by Approval nodes for the messages they hold
+type awaiting struct {
+	held *held
+	node *approval
+}
+
+func (a *awaiting) Error() string {
+	return "message awaits approval " + a.held.ID
+}
+
+// await registers the approval of a, starting its timers
+func (e *Engine) await(a *awaiting) {
+	h, n := a.held, a.node
+	e.approvals.mu.Lock()
+	defer e.approvals.mu.Unlock()
+	if e.approvals.pending == nil {
+		e.approvals.pending = map[string]*held{}
+	}
+	e.approvals.pending[h.ID] = h
+	e.holds.add()
+
+	if n.escalateAfter > 0 {
+		h.timers = append(h.timers, time.AfterFunc(n.escalateAfter, func() {
+			e.escalate(h.ID)
+		}))
+	}
+	if n.timeout > 0 {
+		h.timers = append(h.timers, time.AfterFunc(n.timeout, func() {
+			_, _ = e.Decide(context.Background(), h.ID, Decision{Approve: n.approveOnTime, By: DecidedByTimeout})
+		}))
+	}
+}
+
+// escalate marks a pending approval as escalated
+func (e *Engine) escalate(id string) {
+	e.approvals.mu.Lock()
+	h, ok := e.approvals.pending[id]
+	if !ok {
+		e.approvals.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	h.EscalatedAt = &now
+	nodeID, escalateTo := h.NodeID, h.EscalateTo
+	e.approvals.mu.Unlock()
+
+	e.emit(types.FlowEvent{
+		NodeID:  nodeID,
+		Type:    TypeApprovalEscalated,
+		Message: "Approval escalated",
+		Data:    map[string]interface{}{"approval_id": id, "escalate_to": escalateTo},
+	})
+}
+
+// Approvals returns the pending approvals of the flow, oldest first
+func (e *Engine) Approvals() []Approval {
+	e.approvals.mu.Lock()
+	defer e.approvals.mu.Unlock()
+	pending := make([]Approval, 0, len(e.approvals.pending))
+	for _, h := range e.approvals.pending {
+		pending = append(pending, h.Approval)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].RequestedAt.Before(pending[j].RequestedAt)
+	})
+	return pending
+}
+
+// Decide approves or rejects the pending approval with id, passing the
+// message on, or on the PortRejected port, through the nodes after the
+// Approval node before returning the decided approval. Like Process, the
+// error joins the failures of those nodes; the approval is decided then
+// too.
+func (e *Engine) Decide(ctx context.Context, id string, decision Decision) (*Approval, error) {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
+	e.approvals.mu.Lock()
+	h, ok := e.approvals.pending[id]
+	if !ok {
+		e.approvals.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrApprovalNotFound, id)
+	}
+	delete(e.approvals.pending, id)
+	e.approvals.mu.Unlock()
+	for _, timer := range h.timers {
+		timer.Stop()
+	}
+	// The message is held until it went through
+	defer e.holds.done()
+
+	now := time.Now()
+	decided := h.Approval
+	decided.DecidedBy = decision.By
+	decided.Comment = decision.Comment
+	decided.DecidedAt = &now
+	event := types.FlowEvent{
+		NodeID: decided.NodeID,
+		Data: map[string]interface{}{
+			"approval_id": id,
+			"message_id":  decided.Message.ID,
+			"by":          decision.By,
+			"comment":     decision.Comment,
+		},
+	}
+	out := decided.Message
+	headers := make(map[string]string, len(out.Metadata.Headers)+3)
+	for name, value := range out.Metadata.Headers {
+		headers[name] = value
+	}
+	port := output{node: decided.NodeID}
+	if decision.Approve {
+		decided.Status = ApprovalApproved
+		event.Type, event.Message = TypeApprovalApproved, "Message approved by "+decision.By
+	} else {
+		decided.Status = ApprovalRejected
+		event.Type, event.Message = TypeApprovalRejected, "Message rejected by "+decision.By
+		port.port = PortRejected
+	}
+	headers["approval_id"] = id
+	headers["approval_status"] = decided.Status
+	headers["approval_by"] = decision.By
+	out.Metadata.Headers = headers
+	e.emit(event)
+
+	inbox := map[string][]pending{}
+	err := e.forward(port, out, inbox)
+	return &decided, errors.Join(err, e.drain(ctx, inbox))
+}
+
+// dropApprovals drops the pending approvals when the flow stops
+func (e *Engine) dropApprovals() {
+	e.approvals.mu.Lock()
+	dropped := e.approvals.pending
+	e.approvals.pending = nil
+	e.approvals.mu.Unlock()
+	for id, h := range dropped {
+		for _, timer := range h.timers {
+			timer.Stop()
+		}
+		e.holds.done()
+		e.emit(types.FlowEvent{
+			NodeID:  h.NodeID,
+			Type:    TypeApprovalCancelled,
+			Message: "Approval cancelled as the flow stopped",
+			Data:    map[string]interface{}{"approval_id": id, "message_id": h.Message.ID},
+		})
+	}
+}
+
+// newID returns a random ID of an approval or a break, named by kind
+func newID(kind string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate %s ID: %w", kind, err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"flow-control/internal/types"
+)
+
+// Built-in node types
+const (
+	// TypePassthrough forwards messages unchanged
+	TypePassthrough = "Passthrough"
+	// TypeTransform sets and removes fields of JSON object messages
+	TypeTransform = "Transform"
+	// TypeFilter drops messages whose field does not match
+	TypeFilter = "Filter"
+)
+
+// passthrough forwards messages unchanged
+type passthrough struct {
+	BaseNode
+}
+
+func newPassthrough(cfg types.NodeConfig) (types.Node, error) {
+	return &passthrough{BaseNode{Config: cfg}}, nil
+}
+
+// Process implements types.Node.Process
+func (n *passthrough) Process(ctx context.Context, input types.Message) (types.Message, error) {
+	return input, nil
+}
+
+// transform sets the fields of its "set" setting on JSON object messages
+// and deletes those listed in its "remove" setting
+type transform struct {
+	BaseNode
+	set    map[string]interface{}
+	remove []string
+}
+
+func newTransform(cfg types.NodeConfig) (types.Node, error) {
+	n := &transform{BaseNode: BaseNode{Config: cfg}}
+	if set, ok := cfg.Settings["set"]; ok {
+		fields, ok := set.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("set must be an object")
+		}
+		n.set = fields
+	}
+	if remove, ok := cfg.Settings["remove"]; ok {
+		names, ok := remove.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("remove must be a list of field names")
+		}
+		for _, name := range names {
+			s, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("remove must be a list of field names")
+			}
+			n.remove = append(n.remove, s)
+		}
+	}
+	return n, nil
+}
+
+// Process implements types.Node.Process
+func (n *transform) Process(ctx context.Context, input types.Message) (types.Message, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(input.Data, &fields); err != nil || fields == nil {
+		return types.Message{}, fmt.Errorf("transform needs a JSON object message")
+	}
+	for name, value := range n.set {
+		fields[name] = value
+	}
+	for _, name := range n.remove {
+		delete(fields, name)
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return types.Message{}, fmt.Errorf("failed to encode message: %w", err)
+	}
+	input.Data = data
+	return input, nil
+}
+
+// filter keeps the messages whose "field" setting, a dotted path into a JSON
+// object, equals its "equals" setting, or exists when "equals" is not set
+type filter struct {
+	BaseNode
+	path   []string
+	equals interface{}
+	exists bool
+}
+
+func newFilter(cfg types.NodeConfig) (types.Node, error) {
+	field, _ := cfg.Settings["field"].(string)
+	if field == "" {
+		return nil, fmt.Errorf("field must be set")
+	}
+	equals, hasEquals := cfg.Settings["equals"]
+	return &filter{
+		BaseNode: BaseNode{Config: cfg},
+		path:     strings.Split(field, "."),
+		equals:   equals,
+		exists:   !hasEquals,
+	}, nil
+}
+
+// Process implements types.Node.Process
+func (n *filter) Process(ctx context.Context, input types.Message) (types.Message, error) {
+	var value interface{}
+	if err := json.Unmarshal(input.Data, &value); err != nil {
+		return types.Message{}, ErrDrop
+	}
+	for _, key := range n.path {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return types.Message{}, ErrDrop
+		}
+		if value, ok = fields[key]; !ok {
+			return types.Message{}, ErrDrop
+		}
+	}
+	if !n.exists && !reflect.DeepEqual(value, n.equals) {
+		return types.Message{}, ErrDrop
+	}
+	return input, nil
+}
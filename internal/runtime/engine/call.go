@@ -0,0 +1,264 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"flow-control/internal/types"
+)
+
+// TypeCall runs another flow on each message. Its "target" setting names
+// the flow, its "mode" setting is either CallSync, the default, or
+// CallAsync, and its "params" setting maps the parameters of the called
+// flow to their values.
+//
+// A synchronous call waits for the called flow and passes on the first
+// message leaving it, dropping the message if none does. An asynchronous
+// call passes on its input right away while the called flow runs in the
+// background; the engine waits for such calls when it stops.
+const TypeCall = "Call"
+
+// Modes of Call nodes
+const (
+	CallSync  = "sync"
+	CallAsync = "async"
+)
+
+// DefaultMaxCallDepth is the most nested calls a message goes through
+// unless Options.MaxCallDepth says otherwise
+const DefaultMaxCallDepth = 8
+
+// ErrCallDepth is returned by Call nodes when a message went through too
+// many nested calls, as happens when flows call each other in a loop
+var ErrCallDepth = errors.New("call depth exceeded")
+
+// FlowLoader returns the graph of the flow with id, for Call nodes to run
+type FlowLoader func(ctx context.Context, id string) (*Graph, error)
+
+// SourceFlows resolves calls to the flows defined in Flow source, such as a
+// file defining a flow along with the flows it calls
+func SourceFlows(src string, log types.Logger) FlowLoader {
+	return func(ctx context.Context, id string) (*Graph, error) {
+		return LoadSource(src, id, log)
+	}
+}
+
+// Calls returns the IDs of the flows called by the Call nodes of graph,
+// sorted and without duplicates
+func Calls(graph *Graph) []string {
+	seen := map[string]bool{}
+	var calls []string
+	for _, gn := range graph.Nodes {
+		if gn.Config.Type != TypeCall {
+			continue
+		}
+		flow, _ := gn.Config.Settings["target"].(string)
+		if flow != "" && !seen[flow] {
+			seen[flow] = true
+			calls = append(calls, flow)
+		}
+	}
+	sort.Strings(calls)
+	return calls
+}
+
+// CallCycle returns the flows through which the flow with id ends up
+// calling itself, starting and ending with id, or nil if it does not.
+// calls returns the IDs of the flows a flow calls.
+func CallCycle(id string, calls func(id string) []string) []string {
+	visited := map[string]bool{}
+	var path []string
+	var visit func(flow string) bool
+	visit = func(flow string) bool {
+		path = append(path, flow)
+		for _, called := range calls(flow) {
+			if called == id {
+				path = append(path, called)
+				return true
+			}
+			if !visited[called] {
+				visited[called] = true
+				if visit(called) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if visit(id) {
+		return path
+	}
+	return nil
+}
+
+// callDepthKey is the context key of the number of calls a message is in
+type callDepthKey struct{}
+
+// callDepth returns the number of calls the message processed with ctx is
+// in
+func callDepth(ctx context.Context) int {
+	depth, _ := ctx.Value(callDepthKey{}).(int)
+	return depth
+}
+
+// call runs the flow of its "target" setting on each message. The engine of
+// the called flow is created on the first message, so that flows calling
+// each other only create engines as deep as messages go.
+type call struct {
+	BaseNode
+	flow   string
+	async  bool
+	params map[string]string
+	caller *Engine
+
+	graph   *Graph
+	mu      sync.Mutex // serializes messages through the called flow
+	callee  *Engine
+	outputs []types.Message
+	pending sync.WaitGroup
+}
+
+// newCall creates a Call node of the flow run by caller
+func (e *Engine) newCall(cfg types.NodeConfig) (types.Node, error) {
+	if e.opts.Flows == nil {
+		return nil, fmt.Errorf("%w: %q nodes need flows to call", ErrUnknownNodeType, TypeCall)
+	}
+	n := &call{BaseNode: BaseNode{Config: cfg}, caller: e}
+	n.flow, _ = cfg.Settings["target"].(string)
+	if n.flow == "" {
+		return nil, fmt.Errorf("failed to create node %s: target must be set", cfg.ID)
+	}
+	mode, _ := cfg.Settings["mode"].(string)
+	switch mode {
+	case "", CallSync:
+	case CallAsync:
+		n.async = true
+	default:
+		return nil, fmt.Errorf("failed to create node %s: mode must be %s or %s", cfg.ID, CallSync, CallAsync)
+	}
+	if params, ok := cfg.Settings["params"]; ok {
+		values, ok := params.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("failed to create node %s: params must map parameters to values", cfg.ID)
+		}
+		n.params = make(map[string]string, len(values))
+		for name, v := range values {
+			if n.params[name], ok = paramString(v); !ok {
+				return nil, fmt.Errorf("failed to create node %s: parameter %s must be a string, number or boolean", cfg.ID, name)
+			}
+		}
+	}
+	return n, nil
+}
+
+// Init implements types.Node.Init, loading the called flow so that calls
+// of missing flows, or with invalid parameters, fail when the flow starts
+func (n *call) Init(ctx context.Context) error {
+	graph, err := n.caller.opts.Flows(ctx, n.flow)
+	if err != nil {
+		return fmt.Errorf("failed to load flow %s: %w", n.flow, err)
+	}
+	if _, err := ResolveParams(graph.Params, n.params); err != nil {
+		return fmt.Errorf("failed to call flow %s: %w", n.flow, err)
+	}
+	// The approvals of called flows could not be decided, as their engines
+	// are not reachable from outside
+	for _, gn := range graph.Nodes {
+		if gn.Config.Type == TypeApproval {
+			return fmt.Errorf("failed to call flow %s: node %s awaits approvals, which called flows cannot", n.flow, gn.Config.ID)
+		}
+	}
+	n.graph = graph
+	return nil
+}
+
+// Process implements types.Node.Process
+func (n *call) Process(ctx context.Context, input types.Message) (types.Message, error) {
+	depth := callDepth(ctx) + 1
+	if depth > n.caller.maxCallDepth() {
+		return types.Message{}, fmt.Errorf("%w: calling %s would nest %d calls", ErrCallDepth, n.flow, depth)
+	}
+	ctx = context.WithValue(ctx, callDepthKey{}, depth)
+
+	if !n.async {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		outputs, err := n.run(ctx, input)
+		if err != nil {
+			return types.Message{}, err
+		}
+		if len(outputs) == 0 {
+			return types.Message{}, ErrDrop
+		}
+		input.Data = outputs[0].Data
+		return input, nil
+	}
+
+	// The background call outlives the message, so it must not be
+	// cancelled with it
+	ctx = context.WithoutCancel(ctx)
+	n.pending.Add(1)
+	go func() {
+		defer n.pending.Done()
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if _, err := n.run(ctx, input); err != nil {
+			n.caller.log.Warn("Asynchronous call failed", types.Fields{
+				"function":   "Process",
+				"flow_id":    n.caller.graph.FlowID,
+				"node_id":    n.Config.ID,
+				"called":     n.flow,
+				"message_id": input.ID,
+				"error":      err.Error(),
+			})
+		}
+	}()
+	return input, nil
+}
+
+// run passes a message through the called flow, creating its engine first
+// if needed, and returns the messages that left it. n.mu must be held.
+func (n *call) run(ctx context.Context, input types.Message) ([]types.Message, error) {
+	if n.callee == nil {
+		opts := n.caller.opts
+		opts.Faults = Faults{}
+		opts.Params = n.params
+		opts.Breakpoints = nil
+		opts.OnOutput = func(nodeID string, msg types.Message) {
+			n.outputs = append(n.outputs, msg)
+		}
+		callee, err := New(n.graph, n.caller.registry, n.caller.log, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create flow %s: %w", n.flow, err)
+		}
+		callee.emitMu = n.caller.emitMu
+		if err := callee.Start(ctx); err != nil {
+			return nil, fmt.Errorf("failed to start flow %s: %w", n.flow, err)
+		}
+		n.callee = callee
+	}
+
+	n.outputs = nil
+	if err := n.callee.Process(ctx, input); err != nil {
+		return nil, fmt.Errorf("flow %s failed: %w", n.flow, err)
+	}
+	return n.outputs, nil
+}
+
+// Stop implements types.Node.Stop, waiting for asynchronous calls and
+// stopping the called flow
+func (n *call) Stop(ctx context.Context) error {
+	n.pending.Wait()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.callee == nil {
+		return nil
+	}
+	err := n.callee.Stop(ctx)
+	n.callee = nil
+	return err
+}
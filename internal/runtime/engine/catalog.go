@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// SettingsSchemaDialect is the JSON Schema draft of the settings schemas of
+// node types
+const SettingsSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Setting describes a setting of a node type, for editors to render config
+// forms and check settings before flows are saved
+type Setting struct {
+	Name        string
+	Description string
+	// Type is the JSON Schema type of the setting's values, such as string,
+	// number, array or object; any value is accepted when empty
+	Type string
+	// Items is the JSON Schema type of the elements of array settings
+	Items    string
+	Required bool
+	// Enum lists the only values accepted, if any
+	Enum []interface{}
+	// Default is the value used when the setting is left out, if any
+	Default interface{}
+}
+
+// NodeType describes a node type of the catalog
+type NodeType struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	// Schema is the JSON Schema document of the node type's settings.
+	// Types registered without a description accept any settings.
+	Schema json.RawMessage `json:"schema" swaggertype:"object"`
+}
+
+// description is the description of a registered node type
+type description struct {
+	text     string
+	settings []Setting
+}
+
+// settingsSchema is the subset of JSON Schema describing settings
+type settingsSchema struct {
+	Schema      string                     `json:"$schema,omitempty"`
+	Title       string                     `json:"title,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	Type        string                     `json:"type,omitempty"`
+	Items       *settingsSchema            `json:"items,omitempty"`
+	Enum        []interface{}              `json:"enum,omitempty"`
+	Default     interface{}                `json:"default,omitempty"`
+	Properties  map[string]*settingsSchema `json:"properties,omitempty"`
+	Required    []string                   `json:"required,omitempty"`
+}
+
+// callSettings are the settings of Call nodes, which the engine creates
+// itself rather than from the registry
+var callSettings = []Setting{
+	{Name: "target", Type: "string", Required: true, Description: "ID of the flow to call"},
+	{Name: "mode", Type: "string", Enum: []interface{}{CallSync, CallAsync}, Default: CallSync,
+		Description: "Wait for the called flow and pass on its output, or pass on the input while it runs"},
+}
+
+// Describe documents the settings of a node type for the catalog,
+// replacing any description given before. Flow nodes may hold settings
+// beyond those described, such as config sections, so the schemas of node
+// types do not reject other settings.
+func (r *Registry) Describe(nodeType, text string, settings ...Setting) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.descriptions[nodeType] = description{text: text, settings: settings}
+}
+
+// Catalog describes the registered node types and Call, ordered by type
+func (r *Registry) Catalog() ([]NodeType, error) {
+	names := r.Types()
+	if i := sort.SearchStrings(names, TypeCall); i == len(names) || names[i] != TypeCall {
+		names = append(names, TypeCall)
+		sort.Strings(names)
+	}
+	catalog := make([]NodeType, 0, len(names))
+	for _, name := range names {
+		nt, err := r.NodeType(name)
+		if err != nil {
+			return nil, err
+		}
+		catalog = append(catalog, *nt)
+	}
+	return catalog, nil
+}
+
+// NodeType describes a registered node type or Call. It returns
+// ErrUnknownNodeType for other types.
+func (r *Registry) NodeType(nodeType string) (*NodeType, error) {
+	r.mu.RLock()
+	_, registered := r.factories[nodeType]
+	desc, described := r.descriptions[nodeType]
+	r.mu.RUnlock()
+	if nodeType == TypeCall && !described {
+		desc = description{
+			text:     "Runs another flow on each message",
+			settings: callSettings,
+		}
+	} else if !registered && nodeType != TypeCall {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownNodeType, nodeType)
+	}
+
+	doc := &settingsSchema{
+		Schema:      SettingsSchemaDialect,
+		Title:       nodeType,
+		Description: desc.text,
+		Type:        "object",
+	}
+	if len(desc.settings) > 0 {
+		doc.Properties = make(map[string]*settingsSchema, len(desc.settings))
+	}
+	for _, s := range desc.settings {
+		prop := &settingsSchema{
+			Description: s.Description,
+			Type:        s.Type,
+			Enum:        s.Enum,
+			Default:     s.Default,
+		}
+		if s.Items != "" {
+			prop.Items = &settingsSchema{Type: s.Items}
+		}
+		doc.Properties[s.Name] = prop
+		if s.Required {
+			doc.Required = append(doc.Required, s.Name)
+		}
+	}
+
+	schema, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode settings schema of %s: %w", nodeType, err)
+	}
+	return &NodeType{Type: nodeType, Description: desc.text, Schema: schema}, nil
+}
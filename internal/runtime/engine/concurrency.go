@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"fmt"
+)
+
+// Overflow policies of flows, deciding what happens to the runs started
+// while a flow already runs as many times as it may
+const (
+	// OverflowDrop refuses the run
+	OverflowDrop = "drop"
+	// OverflowCoalesce merges the run into the one run waiting, which
+	// starts once a run ends
+	OverflowCoalesce = "coalesce"
+	// OverflowQueue makes the run wait, in order, for a run to end
+	OverflowQueue = "queue"
+)
+
+// DefaultQueueLimit is the number of runs a flow with OverflowQueue keeps
+// waiting when it does not set queue_limit
+const DefaultQueueLimit = 10
+
+// Concurrency limits the runs of a flow, such as the backfills of a slow
+// flow started faster than they end. Flows declare it in their
+// "concurrency" setting:
+//
+//	concurrency: { max_runs: 2 overflow: "queue" queue_limit: 5 }
+//
+// The zero value, and flows that do not declare it, run once at a time and
+// drop the runs started in the meantime.
+type Concurrency struct {
+	// MaxRuns is the number of runs of the flow at once, 1 when 0
+	MaxRuns int `json:"max_runs"`
+	// Overflow is the policy of the runs started beyond MaxRuns,
+	// OverflowDrop when empty
+	Overflow string `json:"overflow"`
+	// QueueLimit is the number of runs waiting with OverflowQueue, beyond
+	// which runs are dropped
+	QueueLimit int `json:"queue_limit,omitempty"`
+}
+
+// Limit returns the number of runs of the flow at once
+func (c Concurrency) Limit() int {
+	if c.MaxRuns < 1 {
+		return 1
+	}
+	return c.MaxRuns
+}
+
+// Waiting returns the number of runs that may wait for a run to end
+func (c Concurrency) Waiting() int {
+	switch c.Overflow {
+	case OverflowCoalesce:
+		return 1
+	case OverflowQueue:
+		if c.QueueLimit < 1 {
+			return DefaultQueueLimit
+		}
+		return c.QueueLimit
+	default:
+		return 0
+	}
+}
+
+// loadConcurrency converts the "concurrency" setting of a flow into its
+// limits
+func loadConcurrency(value interface{}) (Concurrency, error) {
+	settings, ok := value.(map[string]interface{})
+	if !ok {
+		return Concurrency{}, fmt.Errorf("concurrency must be an object")
+	}
+	c := Concurrency{MaxRuns: 1, Overflow: OverflowDrop}
+	for key, setting := range settings {
+		switch key {
+		case "max_runs":
+			n, ok := setting.(float64)
+			if !ok || n < 1 || n != float64(int(n)) {
+				return Concurrency{}, fmt.Errorf("concurrency: max_runs must be a whole number of at least 1")
+			}
+			c.MaxRuns = int(n)
+		case "overflow":
+			c.Overflow, _ = setting.(string)
+			if c.Overflow != OverflowDrop && c.Overflow != OverflowCoalesce && c.Overflow != OverflowQueue {
+				return Concurrency{}, fmt.Errorf("concurrency: overflow must be %s, %s or %s", OverflowDrop, OverflowCoalesce, OverflowQueue)
+			}
+		case "queue_limit":
+			n, ok := setting.(float64)
+			if !ok || n < 1 || n != float64(int(n)) {
+				return Concurrency{}, fmt.Errorf("concurrency: queue_limit must be a whole number of at least 1")
+			}
+			c.QueueLimit = int(n)
+		default:
+			return Concurrency{}, fmt.Errorf("concurrency: unknown setting %s", key)
+		}
+	}
+	if c.QueueLimit != 0 && c.Overflow != OverflowQueue {
+		return Concurrency{}, fmt.Errorf("concurrency: queue_limit only applies to overflow %s", OverflowQueue)
+	}
+	if c.Overflow == OverflowQueue && c.QueueLimit == 0 {
+		c.QueueLimit = DefaultQueueLimit
+	}
+	return c, nil
+}
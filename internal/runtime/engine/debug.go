@@ -0,0 +1,281 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// Event types reported while debugging flows. Their Data holds the
+// break_id and message_id of the message stopped at a breakpoint.
+const (
+	TypeBreakpointHit = "breakpoint.hit"
+	// TypeBreakpointStepped reports messages passed through the node they
+	// stopped at, to stop again at the nodes after it
+	TypeBreakpointStepped = "breakpoint.stepped"
+	// TypeBreakpointContinued reports messages passed on until they reach
+	// another breakpoint
+	TypeBreakpointContinued = "breakpoint.continued"
+)
+
+// ErrBreakNotFound is returned for messages that are not stopped at a
+// breakpoint
+var ErrBreakNotFound = errors.New("break not found")
+
+// Break is a message stopped before a node with a breakpoint, or before the
+// nodes after the one it was stepped through, until it is stepped or
+// continued
+type Break struct {
+	ID     string `json:"id"`
+	FlowID string `json:"flow_id"`
+	// NodeID is the node the message waits for
+	NodeID string `json:"node_id"`
+	RunID  string `json:"run_id,omitempty"`
+	// Message is the message the node receives once the break is stepped
+	// or continued, as edited in the meantime
+	Message types.Message `json:"message"`
+	HitAt   time.Time     `json:"hit_at"`
+	// Edited is set once the message was edited
+	Edited bool `json:"edited,omitempty"`
+}
+
+// BreakEdit replaces the payload or headers of a message stopped at a
+// breakpoint. Fields left empty are kept.
+type BreakEdit struct {
+	Data    json.RawMessage   `json:"data,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// breaks holds the breakpoints of an engine and the messages stopped at
+// them
+type breaks struct {
+	mu      sync.Mutex
+	nodes   map[string]bool
+	stopped map[string]*stopped
+	// seq numbers the stopped messages, telling apart those a step or a
+	// continue stopped
+	seq int
+}
+
+// stopped is a message stopped at a breakpoint
+type stopped struct {
+	Break
+	seq int
+}
+
+func newBreaks(nodes []string) *breaks {
+	b := &breaks{nodes: map[string]bool{}, stopped: map[string]*stopped{}}
+	for _, id := range nodes {
+		b.nodes[id] = true
+	}
+	return b
+}
+
+// stops tells whether p stops before the node with id, as the node has a
+// breakpoint or p is being stepped
+func (b *breaks) stops(id string, p pending) bool {
+	if p.step {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.nodes[id]
+}
+
+// SetBreakpoint makes the messages reaching the node with id stop before
+// it, until they are stepped or continued. Like messages awaiting
+// approval, stopped messages keep WaitIdle waiting.
+func (e *Engine) SetBreakpoint(id string) error {
+	if _, ok := e.nodes[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownNode, id)
+	}
+	e.breaks.mu.Lock()
+	defer e.breaks.mu.Unlock()
+	e.breaks.nodes[id] = true
+	return nil
+}
+
+// ClearBreakpoint removes the breakpoint of the node with id. Messages
+// already stopped before it stay stopped.
+func (e *Engine) ClearBreakpoint(id string) error {
+	if _, ok := e.nodes[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownNode, id)
+	}
+	e.breaks.mu.Lock()
+	defer e.breaks.mu.Unlock()
+	delete(e.breaks.nodes, id)
+	return nil
+}
+
+// Breakpoints returns the nodes with breakpoints, in the order of the graph
+func (e *Engine) Breakpoints() []string {
+	e.breaks.mu.Lock()
+	defer e.breaks.mu.Unlock()
+	nodes := []string{}
+	for _, gn := range e.graph.Nodes {
+		if e.breaks.nodes[gn.Config.ID] {
+			nodes = append(nodes, gn.Config.ID)
+		}
+	}
+	return nodes
+}
+
+// Breaks returns the messages stopped at breakpoints, the oldest first
+func (e *Engine) Breaks() []Break {
+	return e.breaksAfter(0)
+}
+
+// breaksAfter returns the messages stopped after the one numbered seq, the
+// oldest first
+func (e *Engine) breaksAfter(seq int) []Break {
+	e.breaks.mu.Lock()
+	defer e.breaks.mu.Unlock()
+	var found []*stopped
+	for _, s := range e.breaks.stopped {
+		if s.seq > seq {
+			found = append(found, s)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].seq < found[j].seq })
+	breaks := make([]Break, len(found))
+	for i, s := range found {
+		breaks[i] = s.Break
+	}
+	return breaks
+}
+
+// suspend stops in, waiting for the node with id, at a breakpoint
+func (e *Engine) suspend(id string, in types.Message) error {
+	breakID, err := newID("break")
+	if err != nil {
+		return err
+	}
+	e.breaks.mu.Lock()
+	e.breaks.seq++
+	e.breaks.stopped[breakID] = &stopped{
+		Break: Break{
+			ID:      breakID,
+			FlowID:  e.graph.FlowID,
+			NodeID:  id,
+			RunID:   e.opts.RunID,
+			Message: in,
+			HitAt:   time.Now(),
+		},
+		seq: e.breaks.seq,
+	}
+	e.breaks.mu.Unlock()
+	e.holds.add()
+
+	e.emit(types.FlowEvent{
+		NodeID:  id,
+		Type:    TypeBreakpointHit,
+		Message: "Message stopped at breakpoint",
+		Data:    map[string]interface{}{"break_id": breakID, "message_id": in.ID},
+	})
+	return nil
+}
+
+// EditBreak replaces the payload or headers of the message stopped at the
+// break with id, returning the break as edited
+func (e *Engine) EditBreak(id string, edit BreakEdit) (*Break, error) {
+	e.breaks.mu.Lock()
+	defer e.breaks.mu.Unlock()
+	s, ok := e.breaks.stopped[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBreakNotFound, id)
+	}
+	if edit.Data != nil {
+		s.Message.Data = append(json.RawMessage(nil), edit.Data...)
+	}
+	if edit.Headers != nil {
+		s.Message.Metadata.Headers = make(map[string]string, len(edit.Headers))
+		for name, value := range edit.Headers {
+			s.Message.Metadata.Headers[name] = value
+		}
+	}
+	s.Edited = true
+	edited := s.Break
+	return &edited, nil
+}
+
+// Step passes the message stopped at the break with id through the node it
+// waits for, stopping the messages the node produced before the nodes
+// after it. It returns those new breaks; messages that leave the flow are
+// sent to Options.OnOutput instead. Like Process, the error joins the
+// failures of the node.
+func (e *Engine) Step(ctx context.Context, id string) ([]Break, error) {
+	return e.release(ctx, id, true)
+}
+
+// Continue passes the message stopped at the break with id on through the
+// flow until it leaves it or reaches other breakpoints, returning the
+// breaks it stopped at. Like Process, the error joins the failures of
+// nodes.
+func (e *Engine) Continue(ctx context.Context, id string) ([]Break, error) {
+	return e.release(ctx, id, false)
+}
+
+// release passes the message stopped at the break with id through the node
+// it waits for, stepping it when step is set
+func (e *Engine) release(ctx context.Context, id string, step bool) ([]Break, error) {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
+	e.breaks.mu.Lock()
+	s, ok := e.breaks.stopped[id]
+	if !ok {
+		e.breaks.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrBreakNotFound, id)
+	}
+	delete(e.breaks.stopped, id)
+	seq := e.breaks.seq
+	e.breaks.mu.Unlock()
+	// The message is held until it went through, or stopped again
+	defer e.holds.done()
+
+	event := types.FlowEvent{
+		NodeID:  s.NodeID,
+		Type:    TypeBreakpointContinued,
+		Message: "Message continued",
+		Data:    map[string]interface{}{"break_id": id, "message_id": s.Message.ID},
+	}
+	if step {
+		event.Type, event.Message = TypeBreakpointStepped, "Message stepped"
+	}
+	e.emit(event)
+
+	inbox := map[string][]pending{}
+	err := e.pass(ctx, s.NodeID, pending{msg: s.Message}, inbox)
+	if step {
+		for _, waiting := range inbox {
+			for i := range waiting {
+				waiting[i].step = true
+			}
+		}
+	}
+	err = errors.Join(err, e.drain(ctx, inbox))
+	return e.breaksAfter(seq), err
+}
+
+// dropBreaks drops the messages stopped at breakpoints when the flow stops
+func (e *Engine) dropBreaks() {
+	e.breaks.mu.Lock()
+	dropped := e.breaks.stopped
+	e.breaks.stopped = map[string]*stopped{}
+	e.breaks.mu.Unlock()
+	for _, s := range dropped {
+		e.log.Warn("Dropping message stopped at breakpoint", types.Fields{
+			"function":   "Stop",
+			"flow_id":    e.graph.FlowID,
+			"node_id":    s.NodeID,
+			"message_id": s.Message.ID,
+		})
+		e.holds.done()
+	}
+}
@@ -0,0 +1,188 @@
+package engine
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// TypeDedup drops messages whose content was seen within a window
+const TypeDedup = "Dedup"
+
+// DefaultDedupWindow is the number of messages a Dedup node remembers when
+// neither window is set
+const DefaultDedupWindow = 10000
+
+// dedup drops the messages whose key was seen before within its window. The
+// key hashes the values of the fields listed in its "fields" setting, or
+// the whole payload without them. A key is remembered for window_seconds
+// after it was first seen, and the window_count most recent keys are
+// remembered, whichever is shorter.
+type dedup struct {
+	BaseNode
+	paths  [][]string
+	window time.Duration
+	count  int
+	now    func() time.Time
+
+	mu sync.Mutex
+	// seen maps keys to when they were first seen; order lists them from
+	// the oldest
+	seen  map[string]time.Time
+	order []dedupEntry
+}
+
+// dedupEntry is a key a Dedup node remembers, as saved in its state
+type dedupEntry struct {
+	Key  string    `json:"key"`
+	Seen time.Time `json:"seen"`
+}
+
+// dedupState is the saved state of a Dedup node
+type dedupState struct {
+	Entries []dedupEntry `json:"entries"`
+}
+
+func newDedup(cfg types.NodeConfig) (types.Node, error) {
+	n := &dedup{
+		BaseNode: BaseNode{Config: cfg},
+		now:      time.Now,
+		seen:     make(map[string]time.Time),
+	}
+	if fields, ok := cfg.Settings["fields"]; ok {
+		names, ok := fields.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("fields must be a list of field names")
+		}
+		for _, name := range names {
+			s, ok := name.(string)
+			if !ok || s == "" {
+				return nil, fmt.Errorf("fields must be a list of field names")
+			}
+			n.paths = append(n.paths, strings.Split(s, "."))
+		}
+	}
+
+	window, err := numberSetting(cfg.Settings, "window_seconds", 0)
+	if err != nil {
+		return nil, err
+	}
+	n.window = seconds(window)
+	count, err := numberSetting(cfg.Settings, "window_count", 0)
+	if err != nil {
+		return nil, err
+	}
+	n.count = int(count)
+	if n.window == 0 && n.count == 0 {
+		n.count = DefaultDedupWindow
+	}
+	return n, nil
+}
+
+// Process implements types.Node.Process
+func (n *dedup) Process(ctx context.Context, input types.Message) (types.Message, error) {
+	key, err := n.key(input.Data)
+	if err != nil {
+		return types.Message{}, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := n.now()
+	n.expire(now)
+	if _, dup := n.seen[key]; dup {
+		return types.Message{}, ErrDrop
+	}
+	n.seen[key] = now
+	n.order = append(n.order, dedupEntry{Key: key, Seen: now})
+	n.expire(now)
+	return input, nil
+}
+
+// key hashes the fields of data the node compares messages on
+func (n *dedup) key(data json.RawMessage) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		// Payloads that are not JSON are compared byte for byte
+		if len(n.paths) > 0 {
+			return "", fmt.Errorf("dedup on fields needs a JSON object message")
+		}
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:]), nil
+	}
+
+	if len(n.paths) > 0 {
+		values := make([]interface{}, len(n.paths))
+		for i, path := range n.paths {
+			values[i] = lookup(value, path)
+		}
+		value = values
+	}
+	// Encoding sorts object keys, so that the key does not depend on how
+	// the payload was formatted
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode message: %w", err)
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// lookup returns the value at path in value, or nil if there is none
+func lookup(value interface{}, path []string) interface{} {
+	for _, key := range path {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = fields[key]
+	}
+	return value
+}
+
+// expire forgets the keys beyond the windows. n.mu must be held.
+func (n *dedup) expire(now time.Time) {
+	drop := 0
+	for drop < len(n.order) {
+		entry := n.order[drop]
+		overCount := n.count > 0 && len(n.order)-drop > n.count
+		expired := n.window > 0 && now.Sub(entry.Seen) >= n.window
+		if !overCount && !expired {
+			break
+		}
+		delete(n.seen, entry.Key)
+		drop++
+	}
+	n.order = n.order[drop:]
+}
+
+// RestoreState implements Stateful.RestoreState
+func (n *dedup) RestoreState(state []byte) error {
+	var saved dedupState
+	if err := json.Unmarshal(state, &saved); err != nil {
+		return fmt.Errorf("invalid dedup state: %w", err)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seen = make(map[string]time.Time, len(saved.Entries))
+	n.order = saved.Entries
+	for _, entry := range saved.Entries {
+		n.seen[entry.Key] = entry.Seen
+	}
+	n.expire(n.now())
+	return nil
+}
+
+// SaveState implements Stateful.SaveState
+func (n *dedup) SaveState() ([]byte, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return json.Marshal(dedupState{Entries: n.order})
+}
@@ -0,0 +1,554 @@
+/*
+Package engine executes flows in process. It builds a graph of nodes from a
+parsed flow, creates the nodes from a registry of node types and passes
+messages through them, reporting what happens as flow events.
+*/
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"flow-control/internal/events"
+	"flow-control/internal/metrics"
+	"flow-control/internal/secrets"
+	"flow-control/internal/types"
+)
+
+// Event types reported while messages pass through a flow
+const (
+	TypeNodeProcessed = "node.processed"
+	TypeNodeDropped   = "node.dropped"
+	TypeNodeFailed    = "node.failed"
+	// TypeNodeRouted reports a message a node sent on a named output port
+	TypeNodeRouted = "node.routed"
+)
+
+// Options configures an Engine
+type Options struct {
+	// Metrics, when set, records the messages, errors and latency of every
+	// node with metrics.RecordFlow
+	Metrics types.MetricsPort
+	// OnEvent, when set, is called with every flow event
+	OnEvent func(types.FlowEvent)
+	// OnOutput, when set, is called with the messages leaving the flow,
+	// that is the output of nodes no other node receives from. Messages
+	// sent on a named output port nothing receives from are passed with
+	// the node ID followed by a dot and the port, such as
+	// "check.quarantine".
+	OnOutput func(nodeID string, msg types.Message)
+	// StubUnknown replaces nodes of unregistered types with passthrough
+	// nodes instead of failing, to run flows written for node types that
+	// are only available on a server
+	StubUnknown bool
+	// Params are the values of the parameters the flow declares, which
+	// replace the ${param.NAME} references in node settings before secrets
+	// are resolved. Parameters left out take their defaults.
+	Params map[string]string
+	// Secrets, when set, resolves the ${secret.NAME} references in node
+	// settings before the nodes are created
+	Secrets secrets.Provider
+	// Faults injects errors, latency, drops and corrupted data into the
+	// flow, each reported as a fault.injected event
+	Faults Faults
+	// Spill moves large payloads to disk while they wait for nodes
+	Spill Spill
+	// State, when set, persists the state of Stateful nodes such as Dedup,
+	// so that it survives restarts
+	State StateStore
+	// Schemas provides the schemas Validate nodes check messages against
+	// and Generate nodes sample. Without it, Validate nodes and Generate
+	// nodes naming a schema are treated as nodes of an unknown type.
+	Schemas SchemaSource
+	// Flows loads the flows Call nodes invoke. Without it, Call nodes are
+	// treated as nodes of an unknown type.
+	Flows FlowLoader
+	// Lookups are the URLs and databases the http and sql sources of
+	// Enrich nodes may reach; nodes reaching others fail to be created
+	Lookups Lookups
+	// DataDir is the directory holding the files Replay nodes send. Their
+	// paths are relative to it and may not lead out of it. Without it,
+	// Replay nodes send nothing when the flow is simulated.
+	DataDir string
+	// MaxCallDepth is the most nested calls a message may go through;
+	// DefaultMaxCallDepth when zero
+	MaxCallDepth int
+	// RunID identifies the run in the entries nodes log
+	RunID string
+	// OnLog, when set, is called with every entry the nodes log through
+	// their GetLogs method, including the nodes of the flows they call
+	OnLog func(types.NodeLog)
+	// OnHop, when set, is called with every message going through a node,
+	// including the nodes of the flows they call, with snapshots of what
+	// the node saw and produced. Recording hops copies every payload, so
+	// it is opt-in.
+	OnHop func(types.MessageHop)
+	// Breakpoints are the nodes that messages stop before, starting the
+	// flow in debug mode; see SetBreakpoint
+	Breakpoints []string
+}
+
+// Engine runs the nodes of one flow graph
+type Engine struct {
+	graph    *Graph
+	nodes    map[string]types.Node
+	children map[output][]string
+	// mappings are the mappings of the connections from an output, by the
+	// node receiving from it
+	mappings map[output]map[string]*Mapping
+	log      types.Logger
+	registry *Registry
+	opts     Options
+	faults   *injector
+	// emitMu serializes the events and node logs of the engine and the
+	// engines of the flows it calls, which asynchronous calls emit
+	// concurrently
+	emitMu *sync.Mutex
+	// runMu serializes the messages passing through the nodes, from
+	// Process and Resume
+	runMu     sync.Mutex
+	holds     *holds
+	paused    *pauses
+	approvals *approvals
+	breaks    *breaks
+}
+
+// New creates the nodes of graph from registry
+func New(graph *Graph, registry *Registry, log types.Logger, opts Options) (*Engine, error) {
+	if err := opts.Faults.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid faults: %w", err)
+	}
+	if err := opts.Spill.Validate(); err != nil {
+		return nil, err
+	}
+	params, err := ResolveParams(graph.Params, opts.Params)
+	if err != nil {
+		return nil, err
+	}
+	e := &Engine{
+		graph:     graph,
+		nodes:     make(map[string]types.Node, len(graph.Nodes)),
+		children:  make(map[output][]string, len(graph.Nodes)),
+		mappings:  map[output]map[string]*Mapping{},
+		log:       log,
+		registry:  registry,
+		opts:      opts,
+		faults:    newInjector(opts.Faults),
+		emitMu:    &sync.Mutex{},
+		holds:     newHolds(),
+		paused:    newPauses(),
+		approvals: &approvals{},
+		breaks:    newBreaks(opts.Breakpoints),
+	}
+	stubbed := map[string]bool{}
+	for _, gn := range graph.Nodes {
+		cfg := gn.Config
+		if len(params) > 0 {
+			cfg.Settings, _ = bindParams(cfg.Settings, graph.Params, params).(map[string]interface{})
+		}
+		if opts.Secrets != nil {
+			settings, err := secrets.ResolveSettings(context.Background(), opts.Secrets, cfg.Settings)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve settings of node %s: %w", cfg.ID, err)
+			}
+			cfg.Settings = settings
+		}
+		var node types.Node
+		var err error
+		if cfg.Type == TypeCall {
+			node, err = e.newCall(cfg)
+		} else {
+			node, err = registry.Create(cfg)
+		}
+		if v, ok := node.(*validate); ok {
+			err = v.bind(e)
+		}
+		if g, ok := node.(*generate); ok {
+			err = g.bind(e)
+		}
+		if r, ok := node.(*replay); ok {
+			err = r.bind(e)
+		}
+		if en, ok := node.(*enrich); ok {
+			err = en.bind(e)
+		}
+		if a, ok := node.(*approval); ok {
+			a.bind(e)
+		}
+		if errors.Is(err, ErrUnknownNodeType) && opts.StubUnknown {
+			log.Warn("Stubbing node of unknown type", types.Fields{
+				"function": "New",
+				"flow_id":  graph.FlowID,
+				"node_id":  gn.Config.ID,
+				"type":     gn.Config.Type,
+			})
+			node, err = newPassthrough(cfg)
+			stubbed[cfg.ID] = true
+		}
+		if err != nil {
+			return nil, err
+		}
+		if l, ok := node.(logged); ok {
+			l.bindLogs(e.nodeLog(cfg.ID))
+		}
+		e.nodes[gn.Config.ID] = node
+		e.children[output{node: gn.Config.ID}] = graph.Children(gn.Config.ID)
+	}
+	for _, gn := range graph.Nodes {
+		for from, port := range gn.FromPorts {
+			// Stubs pass every message on, so nothing is sent on the ports
+			// of the nodes they replace
+			if !stubbed[from] && !hasPort(e.nodes[from], port) {
+				return nil, fmt.Errorf("%w: node %q receives from unknown port %q of node %q", ErrInvalidGraph, gn.Config.ID, port, from)
+			}
+			e.children[output{node: from, port: port}] = graph.PortChildren(from, port)
+		}
+		for from, mapping := range gn.FromMaps {
+			port := output{node: from, port: gn.FromPorts[from]}
+			if e.mappings[port] == nil {
+				e.mappings[port] = map[string]*Mapping{}
+			}
+			e.mappings[port][gn.Config.ID] = mapping
+		}
+	}
+	for _, id := range opts.Breakpoints {
+		if _, ok := e.nodes[id]; !ok {
+			return nil, fmt.Errorf("%w: breakpoint at %s", ErrUnknownNode, id)
+		}
+	}
+	return e, nil
+}
+
+// output is an output port of a node, port being "" for its default output
+type output struct {
+	node string
+	port string
+}
+
+// Graph returns the graph the engine runs
+func (e *Engine) Graph() *Graph {
+	return e.graph
+}
+
+// Start initializes and starts every node
+func (e *Engine) Start(ctx context.Context) error {
+	for _, gn := range e.graph.Nodes {
+		node := e.nodes[gn.Config.ID]
+		if err := e.restoreState(gn.Config.ID, node); err != nil {
+			return err
+		}
+		if err := node.Init(ctx); err != nil {
+			return fmt.Errorf("failed to initialize node %s: %w", gn.Config.ID, err)
+		}
+		if err := node.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start node %s: %w", gn.Config.ID, err)
+		}
+	}
+	e.emit(types.FlowEvent{Type: events.TypeFlowStarted, Message: "Flow started"})
+	return nil
+}
+
+// Stop stops every node, in reverse order, returning the errors of all
+// that failed. Messages still buffered for paused nodes, awaiting approval
+// or stopped at breakpoints are dropped.
+func (e *Engine) Stop(ctx context.Context) error {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+	e.dropPaused()
+	e.dropApprovals()
+	e.dropBreaks()
+
+	var errs []error
+	for i := len(e.graph.Nodes) - 1; i >= 0; i-- {
+		id := e.graph.Nodes[i].Config.ID
+		if err := e.nodes[id].Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop node %s: %w", id, err))
+		}
+	}
+	e.emit(types.FlowEvent{Type: events.TypeFlowStopped, Message: "Flow stopped"})
+	return errors.Join(errs...)
+}
+
+// Process passes one input message through the flow. Nodes that fail are
+// reported and do not stop the message from reaching other branches; the
+// returned error joins their failures. Paused nodes buffer the message
+// instead of processing it.
+func (e *Engine) Process(ctx context.Context, msg types.Message) error {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+	if msg.Metadata.Timestamp.IsZero() {
+		msg.Metadata.Timestamp = time.Now()
+	}
+
+	var entries []string
+	for _, gn := range e.graph.Nodes {
+		if len(gn.From) == 0 {
+			entries = append(entries, gn.Config.ID)
+		}
+	}
+	// The caller holds on to the input anyway, so only the output of nodes
+	// is spilled
+	inbox := map[string][]pending{}
+	for _, id := range entries {
+		inbox[id] = append(inbox[id], pending{msg: msg})
+	}
+	return e.drain(ctx, inbox)
+}
+
+// drain passes the messages of inbox, waiting for the nodes they map to,
+// through those nodes and the nodes after them. Messages reaching paused
+// nodes are buffered instead, and those reaching breakpoints stopped.
+func (e *Engine) drain(ctx context.Context, inbox map[string][]pending) error {
+	// Messages left waiting when the flow is cancelled are done with too
+	defer func() {
+		for _, waiting := range inbox {
+			for _, p := range waiting {
+				e.ack(p)
+			}
+		}
+	}()
+
+	var errs []error
+	for _, gn := range e.graph.Nodes {
+		id := gn.Config.ID
+		if e.paused.hold(id, inbox[id]) {
+			delete(inbox, id)
+			continue
+		}
+		for len(inbox[id]) > 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			p := inbox[id][0]
+			inbox[id] = inbox[id][1:]
+			if e.breaks.stops(id, p) {
+				// Stopped messages are kept in memory until they go on
+				in, err := e.receive(p)
+				if err == nil {
+					err = e.suspend(id, in)
+				}
+				e.ack(p)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("node %s failed on message %s: %w", id, p.msg.ID, err))
+				}
+				continue
+			}
+			if err := e.pass(ctx, id, p, inbox); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		delete(inbox, id)
+
+		// Every message the input led to reached the node by now
+		if flusher, ok := e.nodes[id].(Flusher); ok {
+			outs, err := flusher.Flush(ctx)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("node %s failed to flush: %w", id, err))
+			}
+			for _, out := range outs {
+				if err := e.forward(output{node: id}, out, inbox); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pass passes p through the node with id, adding the messages it produced
+// to inbox
+func (e *Engine) pass(ctx context.Context, id string, p pending, inbox map[string][]pending) error {
+	in, err := e.receive(p)
+	if err != nil {
+		e.ack(p)
+		return fmt.Errorf("node %s failed on message %s: %w", id, p.msg.ID, err)
+	}
+	port, outs, err := e.processNode(ctx, id, in)
+	e.ack(p)
+	if err != nil {
+		if errors.Is(err, ErrDrop) {
+			return nil
+		}
+		return fmt.Errorf("node %s failed on message %s: %w", id, in.ID, err)
+	}
+	var errs []error
+	for _, out := range outs {
+		if err := e.forward(output{node: id, port: port}, e.injectAfter(id, out), inbox); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// forward passes out, sent on port of a node, to the nodes receiving from
+// the port, or to Options.OnOutput if there are none. Nodes receiving
+// through a connection with a mapping get the message it maps out to; the
+// connections failing to map it are reported.
+func (e *Engine) forward(port output, out types.Message, inbox map[string][]pending) error {
+	children := e.children[port]
+	if len(children) == 0 && e.opts.OnOutput != nil {
+		name := port.node
+		if port.port != "" {
+			name += "." + port.port
+		}
+		e.opts.OnOutput(name, out)
+	}
+	mappings := e.mappings[port]
+	queued := e.enqueue(port.node, out, len(children)-len(mappings))
+	var errs []error
+	for _, child := range children {
+		forwarded := queued
+		if mapping := mappings[child]; mapping != nil {
+			mapped, err := mapping.apply(out)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("connection %s -> %s failed on message %s: %w", port.node, child, out.ID, err))
+				continue
+			}
+			forwarded = e.enqueue(port.node, mapped, 1)
+		}
+		forwarded.msg.Metadata.Source = port.node
+		forwarded.msg.Metadata.Target = child
+		inbox[child] = append(inbox[child], forwarded)
+	}
+	return errors.Join(errs...)
+}
+
+// processNode runs one node on one message, reporting the outcome. It
+// returns the output port the messages are sent on, "" for the default
+// output.
+func (e *Engine) processNode(ctx context.Context, id string, in types.Message) (string, []types.Message, error) {
+	start := time.Now()
+	var outs []types.Message
+	err := e.injectBefore(ctx, id, in)
+	if err == nil {
+		if multi, ok := e.nodes[id].(MultiOutput); ok {
+			outs, err = multi.ProcessMany(ctx, in)
+		} else {
+			var out types.Message
+			out, err = e.nodes[id].Process(ctx, in)
+			outs = []types.Message{out}
+		}
+	}
+	end := time.Now()
+
+	var port string
+	var routed *Routed
+	if errors.As(err, &routed) {
+		port, outs, err = routed.Port, []types.Message{routed.Message}, nil
+	}
+	var awaits *awaiting
+	if errors.As(err, &awaits) {
+		outs, err = nil, nil
+		e.await(awaits)
+	}
+
+	event := types.FlowEvent{
+		NodeID: id,
+		Data:   map[string]interface{}{"message_id": in.ID},
+	}
+	status := "ok"
+	switch {
+	case errors.Is(err, ErrDrop):
+		event.Type = TypeNodeDropped
+		event.Message = "Message dropped"
+	case err != nil:
+		status = "error"
+		event.Type = TypeNodeFailed
+		event.Message = err.Error()
+	case awaits != nil:
+		event.Type = TypeApprovalRequested
+		event.Message = "Message awaits approval"
+		event.Data["approval_id"] = awaits.held.ID
+		if awaits.held.Title != "" {
+			event.Data["title"] = awaits.held.Title
+		}
+	case port != "":
+		event.Type = TypeNodeRouted
+		event.Message = "Message sent to port " + port
+		event.Data["port"] = port
+		if routed.Reason != "" {
+			event.Data["reason"] = routed.Reason
+		}
+	default:
+		event.Type = TypeNodeProcessed
+		event.Message = "Message processed"
+	}
+	e.emit(event)
+	if e.opts.OnHop != nil {
+		e.recordHop(id, in, port, outs, event, err, start, end)
+	}
+	if status == "ok" {
+		e.saveState(id, e.nodes[id])
+	}
+
+	if e.opts.Metrics != nil {
+		m := types.FlowMetrics{
+			FlowID:    e.graph.FlowID,
+			NodeID:    id,
+			StartTime: start,
+			EndTime:   end,
+			Status:    status,
+		}
+		if err != nil && status == "error" {
+			m.Error = err.Error()
+		}
+		metrics.RecordFlow(e.opts.Metrics, m)
+	}
+	return port, outs, err
+}
+
+// Run starts the flow, processes the messages of input until it is closed
+// or ctx is done, and stops the flow. Failures of single messages are
+// reported as events and do not end the run.
+func (e *Engine) Run(ctx context.Context, input <-chan types.Message) error {
+	if err := e.Start(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		if err := e.Stop(context.Background()); err != nil {
+			e.log.Error("Failed to stop flow", err, types.Fields{
+				"function": "Run",
+				"flow_id":  e.graph.FlowID,
+			})
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-input:
+			if !ok {
+				return nil
+			}
+			if err := e.Process(ctx, msg); err != nil && ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// emit reports an event of the engine's flow
+func (e *Engine) emit(event types.FlowEvent) {
+	if e.opts.OnEvent == nil {
+		return
+	}
+	event.FlowID = e.graph.FlowID
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	e.emitMu.Lock()
+	defer e.emitMu.Unlock()
+	e.opts.OnEvent(event)
+}
+
+// maxCallDepth returns the most nested calls a message may go through
+func (e *Engine) maxCallDepth() int {
+	if e.opts.MaxCallDepth > 0 {
+		return e.opts.MaxCallDepth
+	}
+	return DefaultMaxCallDepth
+}
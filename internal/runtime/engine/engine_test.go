@@ -0,0 +1,1510 @@
+package engine_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"flow-control/internal/logger"
+	"flow-control/internal/metrics"
+	"flow-control/internal/parser"
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/runtime/schema"
+	"flow-control/internal/runtime/script"
+	"flow-control/internal/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+func load(t *testing.T, src, name string) (*engine.Graph, error) {
+	t.Helper()
+	program, diagnostics := parser.Parse(src, logger.New())
+	require.Empty(t, diagnostics)
+	return engine.Load(program, name)
+}
+
+func TestLoad(t *testing.T) {
+	// Nodes form a pipeline unless they name their inputs
+	graph, err := load(t, `flow "orders" {
+		node "read" { type: "Passthrough" }
+		node "audit" { type: "Passthrough" from: "enrich" }
+		node "enrich" { type: "Transform" from: "read" set: { source: "api" } }
+		node "store" { type: "Passthrough" }
+	}`, "")
+	require.NoError(t, err)
+	require.Equal(t, "orders", graph.FlowID)
+
+	var order []string
+	for _, node := range graph.Nodes {
+		order = append(order, node.Config.ID)
+	}
+	require.Equal(t, []string{"read", "enrich", "audit", "store"}, order)
+	require.Equal(t, []string{"enrich"}, graph.Nodes[3].From)
+	require.Equal(t, []string{"audit", "store"}, graph.Children("enrich"))
+	require.Equal(t, map[string]interface{}{"source": "api"}, graph.Nodes[1].Config.Settings["set"])
+
+	// Flows run once at a time unless they declare their concurrency
+	require.Equal(t, 1, graph.Concurrency.Limit())
+	require.Equal(t, 0, graph.Concurrency.Waiting())
+	graph, err = load(t, `flow "f" { concurrency: { max_runs: 2 overflow: "queue" } node "a" { type: "X" } }`, "")
+	require.NoError(t, err)
+	require.Equal(t, engine.Concurrency{MaxRuns: 2, Overflow: engine.OverflowQueue, QueueLimit: engine.DefaultQueueLimit}, graph.Concurrency)
+	graph, err = load(t, `flow "f" { concurrency: { overflow: "coalesce" } node "a" { type: "X" } }`, "")
+	require.NoError(t, err)
+	require.Equal(t, 1, graph.Concurrency.Waiting())
+
+	// Broken graphs are rejected
+	for name, src := range map[string]string{
+		"cycle":       `flow "f" { node "a" { type: "X" from: "b" } node "b" { type: "X" from: "a" } }`,
+		"unknown":     `flow "f" { node "a" { type: "X" from: "missing" } }`,
+		"untyped":     `flow "f" { node "a" { from: "b" } }`,
+		"no flow":     `node "a" { type: "X" }`,
+		"no runs":     `flow "f" { concurrency: { max_runs: 0 } node "a" { type: "X" } }`,
+		"overflow":    `flow "f" { concurrency: { overflow: "block" } node "a" { type: "X" } }`,
+		"queue limit": `flow "f" { concurrency: { queue_limit: 3 } node "a" { type: "X" } }`,
+	} {
+		_, err := load(t, src, "")
+		require.ErrorIs(t, err, engine.ErrInvalidGraph, name)
+	}
+	_, err = load(t, `flow "a" { node "n" { type: "X" } } flow "b" { node "n" { type: "X" } }`, "")
+	require.ErrorIs(t, err, engine.ErrInvalidGraph)
+	graph, err = load(t, `flow "a" { node "n" { type: "X" } } flow "b" { node "n" { type: "X" } }`, "b")
+	require.NoError(t, err)
+	require.Equal(t, "b", graph.FlowID)
+
+	// Source is parsed first, syntax errors making the graph invalid
+	graph, err = engine.LoadSource(`flow "a" { node "n" { type: "X" } }`, "", logger.New())
+	require.NoError(t, err)
+	require.Len(t, graph.Nodes, 1)
+	_, err = engine.LoadSource(`flow "a" {`, "", logger.New())
+	require.ErrorIs(t, err, engine.ErrInvalidGraph)
+}
+
+func TestConnections(t *testing.T) {
+	ctx := context.Background()
+	graph, err := load(t, `flow "users" {
+		node "read" { type: "Passthrough" }
+		node "store" { type: "Passthrough" inputs { users: { type: "json" } } }
+		node "audit" { type: "Passthrough" }
+		"read.out" -> "store.users" map {
+			user_id: payload.user.id
+			first: payload.items.0
+			tenant: headers.tenant
+			missing: payload.nope
+			source: "api"
+			pair: [payload.user.name, payload.nope]
+		}
+		"read" -> "audit"
+	}`, "")
+	require.NoError(t, err)
+
+	// Connected nodes do not receive from the node declared before them
+	require.Equal(t, []string{"read"}, graph.Nodes[1].From)
+	require.Equal(t, []string{"read"}, graph.Nodes[2].From)
+	require.Equal(t, []string{"store", "audit"}, graph.Children("read"))
+	require.NotNil(t, graph.Nodes[1].FromMaps["read"])
+	require.Nil(t, graph.Nodes[2].FromMaps)
+
+	outputs := map[string][]string{}
+	e, err := engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{
+		OnOutput: func(nodeID string, msg types.Message) {
+			outputs[nodeID] = append(outputs[nodeID], string(msg.Data))
+		},
+	})
+	require.NoError(t, err)
+
+	// Only the mapped connection reshapes the message
+	require.NoError(t, e.Process(ctx, types.Message{
+		ID:       "1",
+		Data:     json.RawMessage(`{"user":{"id":12345678901234567890,"name":"ann"},"items":["a","b"]}`),
+		Metadata: types.MessageMetadata{Headers: map[string]string{"tenant": "acme"}},
+	}))
+	require.Equal(t, []string{`{"first":"a","pair":["ann",null],"source":"api","tenant":"acme","user_id":12345678901234567890}`}, outputs["store"])
+	require.Equal(t, []string{`{"user":{"id":12345678901234567890,"name":"ann"},"items":["a","b"]}`}, outputs["audit"])
+
+	// Payloads that cannot be mapped fail the connection only
+	err = e.Process(ctx, types.Message{ID: "2", Data: json.RawMessage(`not json`)})
+	require.ErrorContains(t, err, "connection read -> store failed on message 2")
+	require.Len(t, outputs["store"], 1)
+	require.Len(t, outputs["audit"], 2)
+
+	for name, src := range map[string]string{
+		"unknown node":  `flow "f" { node "a" { type: "X" } "a" -> "b" }`,
+		"unknown input": `flow "f" { node "a" { type: "X" } node "b" { type: "X" } "a" -> "b.data" }`,
+		"twice":         `flow "f" { node "a" { type: "X" } node "b" { type: "X" from: "a" } "a" -> "b" }`,
+		"bad path":      `flow "f" { node "a" { type: "X" } node "b" { type: "X" } "a" -> "b" map { id: message.id } }`,
+		"cycle":         `flow "f" { node "a" { type: "X" } node "b" { type: "X" } "b" -> "a" }`,
+	} {
+		_, err := load(t, src, "")
+		require.ErrorIs(t, err, engine.ErrInvalidGraph, name)
+	}
+}
+
+func TestConnectionCompatibility(t *testing.T) {
+	flow := func(compatibility, out, in string) string {
+		return `flow "orders" {
+			` + compatibility + `
+			node "read" { type: "Passthrough" outputs { out: ` + out + ` } }
+			node "store" { type: "Passthrough" inputs { orders: ` + in + ` } }
+			"read" -> "store.orders"
+		}`
+	}
+	order := func(version string) string { return `{ type: "order", version: "` + version + `" }` }
+
+	// Consumers read data of older minor versions by default
+	graph, err := load(t, flow("", order("1.2.0"), order("1.3.0")), "")
+	require.NoError(t, err)
+	require.Equal(t, schema.CompatibilityBackward, graph.Compatibility)
+	_, err = load(t, flow("", order("1.3.0"), order("1.2.0")), "")
+	require.ErrorIs(t, err, engine.ErrInvalidGraph)
+	require.ErrorContains(t, err, "consumer is older than producer")
+
+	// The flow chooses the mode
+	_, err = load(t, flow(`compatibility: "forward"`, order("1.3.0"), order("1.2.0")), "")
+	require.NoError(t, err)
+	_, err = load(t, flow(`compatibility: "full"`, order("1.2.0"), order("1.3.0")), "")
+	require.ErrorContains(t, err, "minor versions differ")
+	_, err = load(t, flow(`compatibility: "none"`, order("1.0"), order("2.0")), "")
+	require.NoError(t, err)
+
+	// Types must match, unless the input takes any, and ports without a
+	// type take everything
+	_, err = load(t, flow("", `"order"`, `"invoice"`), "")
+	require.ErrorContains(t, err, "schema type order is not compatible with invoice")
+	_, err = load(t, flow("", `"order"`, `"any"`), "")
+	require.NoError(t, err)
+	_, err = load(t, flow("", `{ format: "csv" }`, `"invoice"`), "")
+	require.NoError(t, err)
+
+	for name, src := range map[string]string{
+		"bad mode":    flow(`compatibility: "sideways"`, `"order"`, `"order"`),
+		"bad version": flow("", order("one"), `"order"`),
+	} {
+		_, err := load(t, src, "")
+		require.ErrorIs(t, err, engine.ErrInvalidGraph, name)
+	}
+}
+
+func TestEngine(t *testing.T) {
+	ctx := context.Background()
+	graph, err := load(t, `flow "orders" {
+		node "paid" { type: "Filter" field: "order.status" equals: "paid" }
+		node "tag" { type: "Transform" set: { stage: "billing" } remove: ["secret"] }
+		node "archive" { type: "KafkaSink" from: "paid" }
+	}`, "")
+	require.NoError(t, err)
+
+	// Unknown node types fail unless stubbed
+	_, err = engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{})
+	require.ErrorIs(t, err, engine.ErrUnknownNodeType)
+
+	registry := metrics.NewRegistry()
+	var events []types.FlowEvent
+	outputs := map[string][]string{}
+	e, err := engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{
+		Metrics:     registry,
+		StubUnknown: true,
+		OnEvent:     func(event types.FlowEvent) { events = append(events, event) },
+		OnOutput: func(nodeID string, msg types.Message) {
+			outputs[nodeID] = append(outputs[nodeID], string(msg.Data))
+		},
+	})
+	require.NoError(t, err)
+
+	input := make(chan types.Message, 3)
+	input <- types.Message{ID: "1", Data: json.RawMessage(`{"order":{"status":"paid"},"secret":"x"}`)}
+	input <- types.Message{ID: "2", Data: json.RawMessage(`{"order":{"status":"open"}}`)}
+	input <- types.Message{ID: "3", Data: json.RawMessage(`"paid"`)}
+	close(input)
+	require.NoError(t, e.Run(ctx, input))
+
+	// Matching messages reach both branches, the others are dropped
+	require.Equal(t, map[string][]string{
+		"tag":     {`{"order":{"status":"paid"},"stage":"billing"}`},
+		"archive": {`{"order":{"status":"paid"},"secret":"x"}`},
+	}, outputs)
+
+	counts := map[string]int{}
+	for _, event := range events {
+		require.Equal(t, "orders", event.FlowID)
+		counts[event.Type]++
+	}
+	require.Equal(t, map[string]int{
+		"flow.started":   1,
+		"flow.stopped":   1,
+		"node.processed": 3,
+		"node.dropped":   2,
+	}, counts)
+	messages, ok := registry.Value(metrics.FlowMessagesMetric, map[string]string{"flow_id": "orders"})
+	require.True(t, ok)
+	require.Equal(t, 5.0, messages)
+
+	// Node failures are returned without stopping other messages
+	failing, err := load(t, `flow "f" { node "t" { type: "Transform" } }`, "")
+	require.NoError(t, err)
+	e, err = engine.New(failing, engine.NewRegistry(), logger.New(), engine.Options{})
+	require.NoError(t, err)
+	require.Error(t, e.Process(ctx, types.Message{ID: "1", Data: json.RawMessage(`[1]`)}))
+	require.NoError(t, e.Process(ctx, types.Message{ID: "2", Data: json.RawMessage(`{}`)}))
+}
+
+func TestFaults(t *testing.T) {
+	graph, err := load(t, `flow "orders" {
+		node "read" { type: "Passthrough" }
+		node "tag" { type: "Transform" set: { stage: "billing" } }
+	}`, "")
+	require.NoError(t, err)
+
+	run := func(faults engine.Faults) (outputs []types.Message, injected map[string]int, err error) {
+		injected = map[string]int{}
+		e, err := engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{
+			Faults: faults,
+			OnEvent: func(event types.FlowEvent) {
+				if event.Type == engine.TypeFaultInjected {
+					injected[event.Data["fault"].(string)]++
+				}
+			},
+			OnOutput: func(_ string, msg types.Message) { outputs = append(outputs, msg) },
+		})
+		require.NoError(t, err)
+		err = e.Process(context.Background(), types.Message{ID: "1", Data: json.RawMessage(`{"id":1}`)})
+		return outputs, injected, err
+	}
+
+	// Certain errors fail the first node they hit
+	_, injected, err := run(engine.Faults{ErrorRate: 1})
+	require.ErrorIs(t, err, engine.ErrInjected)
+	require.Equal(t, map[string]int{engine.FaultError: 1}, injected)
+
+	// Drops end the message without an error
+	outputs, injected, err := run(engine.Faults{DropRate: 1, Nodes: []string{"tag"}})
+	require.NoError(t, err)
+	require.Empty(t, outputs)
+	require.Equal(t, map[string]int{engine.FaultDrop: 1}, injected)
+
+	// Corrupted data makes the next node fail
+	_, injected, err = run(engine.Faults{CorruptRate: 1, Nodes: []string{"read"}})
+	require.Error(t, err)
+	require.NotErrorIs(t, err, engine.ErrInjected)
+	require.Equal(t, map[string]int{engine.FaultCorrupt: 1}, injected)
+
+	outputs, injected, err = run(engine.Faults{LatencyRate: 1, LatencyMs: 1})
+	require.NoError(t, err)
+	require.Len(t, outputs, 1)
+	require.Equal(t, map[string]int{engine.FaultLatency: 2}, injected)
+
+	// Seeded faults repeat
+	faults := engine.Faults{ErrorRate: 0.5, Seed: 42}
+	_, first, _ := run(faults)
+	_, second, _ := run(faults)
+	require.Equal(t, first, second)
+
+	_, err = engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{Faults: engine.Faults{DropRate: 2}})
+	require.ErrorContains(t, err, "drop_rate")
+
+	parsed, err := engine.ParseFaults("error=0.1, latency=0.5,latency_ms=20,nodes=read+tag,seed=7")
+	require.NoError(t, err)
+	require.Equal(t, engine.Faults{ErrorRate: 0.1, LatencyRate: 0.5, LatencyMs: 20, Nodes: []string{"read", "tag"}, Seed: 7}, parsed)
+	_, err = engine.ParseFaults("explode=1")
+	require.ErrorContains(t, err, "unknown fault setting")
+}
+
+func TestCall(t *testing.T) {
+	ctx := context.Background()
+	src := `flow "orders" {
+		node "bill" { type: "Call" target: "billing" }
+		node "notify" { type: "Call" target: "notify" mode: "async" }
+	}
+	flow "billing" {
+		node "paid" { type: "Filter" field: "status" equals: "paid" }
+		node "tag" { type: "Transform" set: { billed: 1 } }
+	}
+	flow "notify" { node "sent" { type: "Transform" set: { sent: true } } }
+	flow "loop" { node "again" { type: "Call" target: "loop" } }`
+	flows := engine.SourceFlows(src, logger.New())
+
+	graph, err := flows(ctx, "orders")
+	require.NoError(t, err)
+	require.Equal(t, []string{"billing", "notify"}, engine.Calls(graph))
+
+	// Call nodes need flows to call unless stubbed
+	_, err = engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{})
+	require.ErrorIs(t, err, engine.ErrUnknownNodeType)
+
+	// Synchronous calls pass on the output of the called flow, and
+	// asynchronous ones their input
+	var outputs []string
+	var eventFlows []string
+	e, err := engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{
+		Flows: flows,
+		OnEvent: func(event types.FlowEvent) {
+			if event.Type == engine.TypeNodeProcessed {
+				eventFlows = append(eventFlows, event.FlowID+"/"+event.NodeID)
+			}
+		},
+		OnOutput: func(nodeID string, msg types.Message) { outputs = append(outputs, string(msg.Data)) },
+	})
+	require.NoError(t, err)
+	require.NoError(t, e.Start(ctx))
+	require.NoError(t, e.Process(ctx, types.Message{ID: "1", Data: json.RawMessage(`{"status":"paid"}`)}))
+	require.NoError(t, e.Process(ctx, types.Message{ID: "2", Data: json.RawMessage(`{"status":"open"}`)}))
+	require.NoError(t, e.Stop(ctx))
+	require.Equal(t, []string{`{"billed":1,"status":"paid"}`}, outputs)
+	require.Contains(t, eventFlows, "billing/tag")
+	require.Contains(t, eventFlows, "notify/sent")
+
+	// Calls of missing flows fail when the flow starts
+	missing, err := engine.LoadSource(`flow "f" { node "c" { type: "Call" target: "missing" } }`, "", logger.New())
+	require.NoError(t, err)
+	e, err = engine.New(missing, engine.NewRegistry(), logger.New(), engine.Options{Flows: flows})
+	require.NoError(t, err)
+	require.ErrorIs(t, e.Start(ctx), engine.ErrInvalidGraph)
+
+	// Flows calling themselves stop at the depth limit
+	loop, err := flows(ctx, "loop")
+	require.NoError(t, err)
+	e, err = engine.New(loop, engine.NewRegistry(), logger.New(), engine.Options{Flows: flows, MaxCallDepth: 3})
+	require.NoError(t, err)
+	require.NoError(t, e.Start(ctx))
+	err = e.Process(ctx, types.Message{ID: "1", Data: json.RawMessage(`{}`)})
+	require.ErrorIs(t, err, engine.ErrCallDepth)
+	require.NoError(t, e.Stop(ctx))
+
+	// Cycles are found through any number of flows
+	calls := map[string][]string{"a": {"b"}, "b": {"c", "d"}, "d": {"a"}}
+	lookup := func(id string) []string { return calls[id] }
+	require.Equal(t, []string{"a", "b", "d", "a"}, engine.CallCycle("a", lookup))
+	require.Nil(t, engine.CallCycle("c", lookup))
+}
+
+func TestParams(t *testing.T) {
+	ctx := context.Background()
+	src := `flow "tagged" {
+		params: {
+			region: { type: "string", default: "eu" }
+			limit: { type: "number", default: 10 }
+			owner: { type: "string", description: "Team owning the orders" }
+		}
+		node "tag" { type: "Transform" set: { region: "${param.region}", limit: "${param.limit}", team: "team-${param.owner}" } }
+	}
+	flow "caller" {
+		node "call" { type: "Call" target: "tagged" params: { owner: "billing", limit: 5 } }
+	}`
+	flows := engine.SourceFlows(src, logger.New())
+	graph, err := flows(ctx, "tagged")
+	require.NoError(t, err)
+	require.Len(t, graph.Params, 3)
+	require.Equal(t, engine.Param{Name: "limit", Type: engine.ParamNumber, Default: "10"}, graph.Params[0])
+	require.True(t, graph.Params[1].Required)
+
+	// Values and defaults replace references, keeping the parameter's type
+	// where a setting is a single reference
+	run := func(graph *engine.Graph, params map[string]string) []string {
+		var outputs []string
+		e, err := engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{
+			Params:   params,
+			Flows:    flows,
+			OnOutput: func(nodeID string, msg types.Message) { outputs = append(outputs, string(msg.Data)) },
+		})
+		require.NoError(t, err)
+		require.NoError(t, e.Start(ctx))
+		require.NoError(t, e.Process(ctx, types.Message{ID: "1", Data: json.RawMessage(`{}`)}))
+		require.NoError(t, e.Stop(ctx))
+		return outputs
+	}
+	require.Equal(t, []string{`{"limit":10,"region":"eu","team":"team-orders"}`}, run(graph, map[string]string{"owner": "orders"}))
+	require.Equal(t, []string{`{"limit":2.5,"region":"us","team":"team-ops"}`}, run(graph, map[string]string{"owner": "ops", "region": "us", "limit": "2.5"}))
+
+	// Call nodes pass values to the flows they call
+	caller, err := flows(ctx, "caller")
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"limit":5,"region":"eu","team":"team-billing"}`}, run(caller, nil))
+
+	// Missing, unknown and mistyped values are rejected
+	for _, params := range []map[string]string{
+		{},
+		{"owner": "ops", "color": "red"},
+		{"owner": "ops", "limit": "many"},
+	} {
+		_, err := engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{Params: params})
+		require.ErrorIs(t, err, engine.ErrInvalidParams)
+	}
+
+	// References to undeclared parameters and bad declarations are invalid
+	for _, src := range []string{
+		`flow "f" { node "n" { type: "Passthrough" url: "${param.missing}" } }`,
+		`flow "f" { params: { n: { type: "date" } } node "n" { type: "Passthrough" } }`,
+		`flow "f" { params: { n: { type: "number", default: "ten" } } node "n" { type: "Passthrough" } }`,
+	} {
+		_, err := engine.LoadSource(src, "", logger.New())
+		require.ErrorIs(t, err, engine.ErrInvalidGraph, src)
+	}
+}
+
+// echo is a custom node type forwarding messages unchanged
+type echo struct {
+	engine.BaseNode
+}
+
+func (n *echo) Process(ctx context.Context, msg types.Message) (types.Message, error) {
+	return msg, nil
+}
+
+func TestCatalog(t *testing.T) {
+	registry := engine.NewRegistry()
+	registry.Register("Echo", func(cfg types.NodeConfig) (types.Node, error) {
+		return &echo{BaseNode: engine.BaseNode{Config: cfg}}, nil
+	})
+
+	catalog, err := registry.Catalog()
+	require.NoError(t, err)
+	var names []string
+	for _, nt := range catalog {
+		names = append(names, nt.Type)
+	}
+	require.Equal(t, []string{engine.TypeApproval, engine.TypeCall, engine.TypeCollect, engine.TypeDedup, "Echo", engine.TypeEnrich, engine.TypeFilter, engine.TypeGenerate, engine.TypePassthrough, engine.TypeReplay, engine.TypeScript, engine.TypeSplit, engine.TypeTransform, engine.TypeValidate}, names)
+
+	// Described settings become properties of the schema
+	filter, err := registry.NodeType(engine.TypeFilter)
+	require.NoError(t, err)
+	var schema struct {
+		Schema     string                            `json:"$schema"`
+		Type       string                            `json:"type"`
+		Properties map[string]map[string]interface{} `json:"properties"`
+		Required   []string                          `json:"required"`
+	}
+	require.NoError(t, json.Unmarshal(filter.Schema, &schema))
+	require.Equal(t, engine.SettingsSchemaDialect, schema.Schema)
+	require.Equal(t, "object", schema.Type)
+	require.Equal(t, []string{"field"}, schema.Required)
+	require.Equal(t, "string", schema.Properties["field"]["type"])
+	require.NotContains(t, schema.Properties["equals"], "type")
+
+	call, err := registry.NodeType(engine.TypeCall)
+	require.NoError(t, err)
+	require.Contains(t, string(call.Schema), `"enum":["sync","async"]`)
+
+	// Undescribed types accept any settings, until described
+	echoType, err := registry.NodeType("Echo")
+	require.NoError(t, err)
+	require.JSONEq(t, `{"$schema":"`+engine.SettingsSchemaDialect+`","title":"Echo","type":"object"}`, string(echoType.Schema))
+	registry.Describe("Echo", "Forwards messages", engine.Setting{Name: "label", Type: "string", Required: true})
+	echoType, err = registry.NodeType("Echo")
+	require.NoError(t, err)
+	require.Equal(t, "Forwards messages", echoType.Description)
+	require.Contains(t, string(echoType.Schema), `"required":["label"]`)
+
+	_, err = registry.NodeType("Missing")
+	require.ErrorIs(t, err, engine.ErrUnknownNodeType)
+}
+
+func TestSpill(t *testing.T) {
+	graph, err := load(t, `flow "uploads" {
+		node "read" { type: "Passthrough" }
+		node "thumbnail" { type: "Inspect" from: "read" }
+		node "archive" { type: "Inspect" from: "read" }
+	}`, "")
+	require.NoError(t, err)
+
+	// Nodes receive whole payloads while the copies waiting for other nodes
+	// are on disk
+	dir := t.TempDir()
+	spilledFiles := map[string]int{}
+	registry := engine.NewRegistry()
+	registry.Register("Inspect", func(cfg types.NodeConfig) (types.Node, error) {
+		return &inspect{BaseNode: engine.BaseNode{Config: cfg}, check: func(msg types.Message) {
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			spilledFiles[cfg.ID] = len(entries)
+		}}, nil
+	})
+
+	promRegistry := metrics.NewRegistry()
+	var outputs []types.Message
+	e, err := engine.New(graph, registry, logger.New(), engine.Options{
+		Spill:    engine.Spill{MaxInMemory: 16, Dir: dir},
+		Metrics:  promRegistry,
+		OnOutput: func(nodeID string, msg types.Message) { outputs = append(outputs, msg) },
+	})
+	require.NoError(t, err)
+
+	large := json.RawMessage(`{"image": "` + strings.Repeat("x", 64) + `"}`)
+	require.NoError(t, e.Process(context.Background(), types.Message{ID: "1", Data: large}))
+	require.Len(t, outputs, 2)
+	for _, out := range outputs {
+		require.JSONEq(t, string(large), string(out.Data))
+	}
+	require.Equal(t, map[string]int{"thumbnail": 1, "archive": 1}, spilledFiles)
+
+	// Payloads are removed once every node received them
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	var out strings.Builder
+	require.NoError(t, promRegistry.WritePrometheus(&out))
+	require.Contains(t, out.String(), `flow_spilled_messages_total{flow_id="uploads",node_id="read"} 1`)
+	require.Contains(t, out.String(), `flow_spilled_bytes_total{flow_id="uploads",node_id="read"} 77`)
+	require.Contains(t, out.String(), `flow_spill_bytes{flow_id="uploads",node_id="read"} 0`)
+
+	// Small payloads stay in memory
+	outputs = nil
+	require.NoError(t, e.Process(context.Background(), types.Message{ID: "2", Data: json.RawMessage(`{}`)}))
+	require.Len(t, outputs, 2)
+	require.Equal(t, map[string]int{"thumbnail": 0, "archive": 0}, spilledFiles)
+
+	_, err = engine.New(graph, registry, logger.New(), engine.Options{Spill: engine.Spill{MaxInMemory: -1}})
+	require.Error(t, err)
+}
+
+// inspect passes messages on after checking them
+type inspect struct {
+	engine.BaseNode
+	check func(types.Message)
+}
+
+func (n *inspect) Process(ctx context.Context, input types.Message) (types.Message, error) {
+	n.check(input)
+	return input, nil
+}
+
+func TestDedup(t *testing.T) {
+	ctx := context.Background()
+	graph, err := load(t, `flow "orders" {
+		node "unique" { type: "Dedup" fields: ["order.id"] window_count: 2 }
+	}`, "")
+	require.NoError(t, err)
+
+	states, err := engine.NewDirStateStore(t.TempDir())
+	require.NoError(t, err)
+	var outputs []string
+	start := func() *engine.Engine {
+		e, err := engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{
+			State:    states,
+			OnOutput: func(nodeID string, msg types.Message) { outputs = append(outputs, msg.ID) },
+		})
+		require.NoError(t, err)
+		require.NoError(t, e.Start(ctx))
+		return e
+	}
+	send := func(e *engine.Engine, id, data string) {
+		require.NoError(t, e.Process(ctx, types.Message{ID: id, Data: json.RawMessage(data)}))
+	}
+
+	// Messages are compared on their fields only
+	e := start()
+	send(e, "1", `{"order": {"id": 1}, "attempt": 1}`)
+	send(e, "2", `{"order": {"id": 1}, "attempt": 2}`)
+	send(e, "3", `{"order": {"id": 2}}`)
+	require.Equal(t, []string{"1", "3"}, outputs)
+
+	// Restarted nodes still remember the latest messages, and forget the
+	// ones beyond the window
+	require.NoError(t, e.Stop(ctx))
+	outputs = nil
+	e = start()
+	send(e, "4", `{"order": {"id": 2}}`)
+	send(e, "5", `{"order": {"id": 3}}`)
+	send(e, "6", `{"order": {"id": 1}}`)
+	require.Equal(t, []string{"5", "6"}, outputs)
+
+	// Without fields, whole payloads are compared whatever their formatting
+	whole, err := load(t, `flow "f" { node "unique" { type: "Dedup" } }`, "")
+	require.NoError(t, err)
+	outputs = nil
+	e, err = engine.New(whole, engine.NewRegistry(), logger.New(), engine.Options{
+		OnOutput: func(nodeID string, msg types.Message) { outputs = append(outputs, msg.ID) },
+	})
+	require.NoError(t, err)
+	send(e, "1", `{"a": 1, "b": 2}`)
+	send(e, "2", `{"b":2,"a":1}`)
+	send(e, "3", `{"a": 2}`)
+	require.Equal(t, []string{"1", "3"}, outputs)
+
+	invalid, err := load(t, `flow "f" { node "unique" { type: "Dedup" window_seconds: "1h" } }`, "")
+	require.NoError(t, err)
+	_, err = engine.New(invalid, engine.NewRegistry(), logger.New(), engine.Options{})
+	require.Error(t, err)
+}
+
+func TestEnrich(t *testing.T) {
+	ctx := context.Background()
+	var lookups engine.Lookups
+	run := func(src string, messages ...string) ([]string, error) {
+		t.Helper()
+		graph, err := load(t, src, "")
+		require.NoError(t, err)
+		var outputs []string
+		e, err := engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{
+			Lookups:  lookups,
+			OnOutput: func(nodeID string, msg types.Message) { outputs = append(outputs, string(msg.Data)) },
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer e.Stop(ctx)
+		for i, data := range messages {
+			if err := e.Process(ctx, types.Message{ID: string(rune('1' + i)), Data: json.RawMessage(data)}); err != nil {
+				return outputs, err
+			}
+		}
+		return outputs, nil
+	}
+
+	// Static tables set the value of the key, and drop misses when told to
+	outputs, err := run(`flow "f" {
+		node "region" {
+			type: "Enrich" source: "static" key: "country" into: "geo.region"
+			table: { fr: "eu", us: { name: "na" } }
+			on_miss: "drop"
+		}
+	}`, `{"country": "fr"}`, `{"country": "jp"}`, `{"country": "us"}`, `{}`)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		`{"country":"fr","geo":{"region":"eu"}}`,
+		`{"country":"us","geo":{"region":{"name":"na"}}}`,
+	}, outputs)
+
+	// HTTP lookups are cached, misses only when negative_ttl_seconds is set
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		require.Equal(t, "secret", r.Header.Get("X-Token"))
+		switch r.URL.Path {
+		case "/customers/7":
+			w.Write([]byte(`{"tier": "gold"}`))
+		case "/customers/9":
+			http.Redirect(w, r, "/admin", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	lookups.URLs = []string{server.URL + "/customers/"}
+	flow := `flow "f" {
+		node "customer" {
+			type: "Enrich" source: "http" key: "customer_id" into: "customer"
+			url: "` + server.URL + `/customers/{key}"
+			headers: { X_Token: "secret" }
+			%s
+		}
+	}`
+	outputs, err = run(strings.Replace(flow, "%s", "", 1),
+		`{"customer_id": 7}`, `{"customer_id": 7}`, `{"customer_id": 8}`, `{"customer_id": 8}`)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		`{"customer":{"tier":"gold"},"customer_id":7}`,
+		`{"customer":{"tier":"gold"},"customer_id":7}`,
+		`{"customer_id": 8}`,
+		`{"customer_id": 8}`,
+	}, outputs)
+	require.Equal(t, map[string]int{"/customers/7": 1, "/customers/8": 2}, requests)
+
+	requests = map[string]int{}
+	_, err = run(strings.Replace(flow, "%s", `negative_ttl_seconds: 60 cache_ttl_seconds: 0`, 1),
+		`{"customer_id": 7}`, `{"customer_id": 7}`, `{"customer_id": 8}`, `{"customer_id": 8}`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"/customers/7": 2, "/customers/8": 1}, requests)
+
+	_, err = run(strings.Replace(flow, "%s", `on_miss: "error"`, 1), `{"customer_id": 8}`)
+	require.ErrorIs(t, err, engine.ErrLookupMiss)
+
+	// Requests stay within the allowed URLs, whatever the key or redirects
+	requests = map[string]int{}
+	_, err = run(strings.Replace(flow, "%s", "", 1), `{"customer_id": ".."}`)
+	require.ErrorIs(t, err, engine.ErrLookupDenied)
+	_, err = run(strings.Replace(flow, "%s", "", 1), `{"customer_id": 9}`)
+	require.ErrorIs(t, err, engine.ErrLookupDenied)
+	require.Equal(t, map[string]int{"/customers/9": 1}, requests)
+	for _, url := range []string{server.URL + "/admin/{key}", server.URL + "/customers{key}", "http://169.254.169.254/{key}"} {
+		_, err = run(strings.NewReplacer("%s", "", server.URL+"/customers/{key}", url).Replace(flow))
+		require.ErrorIs(t, err, engine.ErrLookupDenied, url)
+	}
+
+	// Prefixes match whole path segments, not sibling paths
+	lookups.URLs = []string{server.URL + "/customers"}
+	for _, url := range []string{server.URL + "/customers-internal/{key}", server.URL + "/customersecret{key}"} {
+		_, err = run(strings.NewReplacer("%s", "", server.URL+"/customers/{key}", url).Replace(flow))
+		require.ErrorIs(t, err, engine.ErrLookupDenied, url)
+	}
+	outputs, err = run(strings.Replace(flow, "%s", "", 1), `{"customer_id": 7}`)
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"customer":{"tier":"gold"},"customer_id":7}`}, outputs)
+	lookups.URLs = nil
+	_, err = run(strings.Replace(flow, "%s", "", 1))
+	require.ErrorIs(t, err, engine.ErrLookupDenied)
+
+	// SQL lookups take the first row as an object of its columns
+	path := filepath.Join(t.TempDir(), "lookup.db")
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE products (sku TEXT, name TEXT, price REAL);
+		INSERT INTO products VALUES ('a1', 'Lamp', 12.5)`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	lookups.Databases = map[string]engine.LookupDatabase{"catalog": {Driver: "sqlite", DSN: path}}
+	products := `flow "f" {
+		node "product" {
+			type: "Enrich" source: "sql" database: "catalog"
+			query: "SELECT name, price FROM products WHERE sku = ?"
+			key: "sku" into: "product"
+		}
+	}`
+	outputs, err = run(products, `{"sku": "a1"}`, `{"sku": "b2"}`)
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"product":{"name":"Lamp","price":12.5},"sku":"a1"}`, `{"sku": "b2"}`}, outputs)
+
+	// Only the configured databases are queried
+	_, err = run(strings.Replace(products, "catalog", "billing", 1))
+	require.ErrorIs(t, err, engine.ErrLookupDenied)
+
+	_, err = run(`flow "f" { node "e" { type: "Enrich" source: "ldap" key: "id" into: "user" } }`)
+	require.Error(t, err)
+}
+
+func TestSplit(t *testing.T) {
+	ctx := context.Background()
+	graph, err := load(t, `flow "batches" {
+		node "items" { type: "Split" field: "batch.items" }
+		node "valid" { type: "Filter" field: "sku" }
+		node "tag" { type: "Transform" set: { checked: 1 } }
+		node "batch" { type: "Collect" }
+		node "each" { type: "Passthrough" from: "items" }
+	}`, "")
+	require.NoError(t, err)
+
+	var events []types.FlowEvent
+	outputs := map[string][]types.Message{}
+	e, err := engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{
+		OnEvent:  func(event types.FlowEvent) { events = append(events, event) },
+		OnOutput: func(nodeID string, msg types.Message) { outputs[nodeID] = append(outputs[nodeID], msg) },
+	})
+	require.NoError(t, err)
+
+	// Elements are processed one at a time and collected back in order,
+	// leaving out those dropped on the way
+	input := types.Message{
+		ID:       "b1",
+		Data:     json.RawMessage(`{"batch": {"items": [{"sku": "a"}, {"qty": 2}, {"sku": "c"}]}}`),
+		Metadata: types.MessageMetadata{Headers: map[string]string{"tenant": "acme"}},
+	}
+	require.NoError(t, e.Process(ctx, input))
+	require.Len(t, outputs["each"], 3)
+	for i, msg := range outputs["each"] {
+		require.Equal(t, "b1."+string(rune('0'+i)), msg.ID)
+		require.Equal(t, map[string]string{
+			"tenant":         "acme",
+			"correlation_id": "b1",
+			"split_id":       "b1",
+			"split_index":    string(rune('0' + i)),
+			"split_count":    "3",
+		}, msg.Metadata.Headers)
+	}
+	require.Len(t, outputs["batch"], 1)
+	collected := outputs["batch"][0]
+	require.Equal(t, "b1", collected.ID)
+	require.JSONEq(t, `[{"sku": "a", "checked": 1}, {"sku": "c", "checked": 1}]`, string(collected.Data))
+	require.Equal(t, map[string]string{"tenant": "acme", "correlation_id": "b1"}, collected.Metadata.Headers)
+
+	// Empty arrays lead to no messages without dropping the input
+	outputs = map[string][]types.Message{}
+	events = nil
+	require.NoError(t, e.Process(ctx, types.Message{ID: "b2", Data: json.RawMessage(`{"batch": {"items": []}}`)}))
+	require.Empty(t, outputs)
+	require.Equal(t, engine.TypeNodeProcessed, events[0].Type)
+
+	require.Error(t, e.Process(ctx, types.Message{ID: "b3", Data: json.RawMessage(`{"batch": {}}`)}))
+
+	// Incomplete arrays can be dropped instead
+	strict, err := load(t, `flow "f" {
+		node "items" { type: "Split" }
+		node "valid" { type: "Filter" field: "sku" }
+		node "batch" { type: "Collect" on_incomplete: "drop" }
+	}`, "")
+	require.NoError(t, err)
+	outputs = map[string][]types.Message{}
+	e, err = engine.New(strict, engine.NewRegistry(), logger.New(), engine.Options{
+		OnOutput: func(nodeID string, msg types.Message) { outputs[nodeID] = append(outputs[nodeID], msg) },
+	})
+	require.NoError(t, err)
+	require.NoError(t, e.Process(ctx, types.Message{ID: "1", Data: json.RawMessage(`[{"sku": "a"}, {}]`)}))
+	require.Empty(t, outputs)
+	require.NoError(t, e.Process(ctx, types.Message{ID: "2", Data: json.RawMessage(`[{"sku": "a"}, {"sku": "b"}]`)}))
+	require.Len(t, outputs["batch"], 1)
+	require.JSONEq(t, `[{"sku": "a"}, {"sku": "b"}]`, string(outputs["batch"][0].Data))
+}
+
+func TestValidate(t *testing.T) {
+	ctx := context.Background()
+	schemas := schema.NewRegistry()
+	_, err := schemas.ImportJSONSchema("order", "1.0.0", []byte(`{
+		"type": "object",
+		"properties": {
+			"id": {"type": "string"},
+			"items": {"type": "array", "items": {"type": "object", "properties": {"qty": {"type": "integer"}}, "required": ["qty"]}}
+		},
+		"required": ["id"]
+	}`))
+	require.NoError(t, err)
+
+	graph, err := load(t, `flow "orders" {
+		node "check" { type: "Validate" schema: "order" }
+		node "bill" { type: "Passthrough" }
+		node "quarantine" { type: "Passthrough" from: "check.quarantine" }
+	}`, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"bill"}, graph.Children("check"))
+	require.Equal(t, []string{"quarantine"}, graph.PortChildren("check", engine.PortQuarantine))
+
+	registry := metrics.NewRegistry()
+	var events []types.FlowEvent
+	outputs := map[string][]string{}
+	e, err := engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{
+		Schemas: schemas,
+		Metrics: registry,
+		OnEvent: func(event types.FlowEvent) { events = append(events, event) },
+		OnOutput: func(nodeID string, msg types.Message) {
+			outputs[nodeID] = append(outputs[nodeID], string(msg.Data))
+		},
+	})
+	require.NoError(t, err)
+
+	// Invalid messages reach the quarantine port with their errors instead
+	// of failing
+	require.NoError(t, e.Process(ctx, types.Message{ID: "1", Data: json.RawMessage(`{"id": "a", "items": [{"qty": 1}]}`)}))
+	require.NoError(t, e.Process(ctx, types.Message{ID: "2", Data: json.RawMessage(`{"items": [{"qty": 1}, {}, {}]}`)}))
+	require.NoError(t, e.Process(ctx, types.Message{ID: "3", Data: json.RawMessage(`not json`)}))
+	require.Equal(t, []string{`{"id": "a", "items": [{"qty": 1}]}`}, outputs["bill"])
+	require.Len(t, outputs["quarantine"], 2)
+	var quarantined engine.Quarantined
+	require.NoError(t, json.Unmarshal([]byte(outputs["quarantine"][0]), &quarantined))
+	require.Equal(t, "order", quarantined.Schema)
+	require.Equal(t, "1.0.0", quarantined.Version)
+	require.JSONEq(t, `{"items": [{"qty": 1}, {}, {}]}`, string(quarantined.Data))
+	require.Equal(t, []*schema.FieldError{
+		{Path: "/id", Message: "missing required field"},
+		{Path: "/items/1/qty", Message: "missing required field"},
+		{Path: "/items/2/qty", Message: "missing required field"},
+	}, quarantined.Errors)
+	require.NoError(t, json.Unmarshal([]byte(outputs["quarantine"][1]), &quarantined))
+	require.JSONEq(t, `"not json"`, string(quarantined.Data))
+
+	routed := 0
+	for _, event := range events {
+		if event.Type == engine.TypeNodeRouted {
+			routed++
+			require.Equal(t, engine.PortQuarantine, event.Data["port"])
+		}
+	}
+	require.Equal(t, 2, routed)
+
+	// Failures are counted by path, elements of arrays together
+	var out strings.Builder
+	require.NoError(t, registry.WritePrometheus(&out))
+	require.Contains(t, out.String(), `flow_validation_failures_total{flow_id="orders",node_id="check",path="/items/*/qty",schema="order"} 2`)
+	require.Contains(t, out.String(), `flow_validation_failures_total{flow_id="orders",node_id="check",path="/",schema="order"} 1`)
+
+	// Quarantined messages nothing receives leave the flow on the port
+	leaf, err := load(t, `flow "f" { node "check" { type: "Validate" schema: "order" version: "1.0.0" } }`, "")
+	require.NoError(t, err)
+	outputs = map[string][]string{}
+	e, err = engine.New(leaf, engine.NewRegistry(), logger.New(), engine.Options{
+		Schemas:  schemas,
+		OnOutput: func(nodeID string, msg types.Message) { outputs[nodeID] = append(outputs[nodeID], msg.ID) },
+	})
+	require.NoError(t, err)
+	require.NoError(t, e.Process(ctx, types.Message{ID: "1", Data: json.RawMessage(`{}`)}))
+	require.Equal(t, map[string][]string{"check.quarantine": {"1"}}, outputs)
+
+	// Unknown schemas and ports fail, and Validate needs schemas
+	unknown, err := load(t, `flow "f" { node "check" { type: "Validate" schema: "invoice" } }`, "")
+	require.NoError(t, err)
+	_, err = engine.New(unknown, engine.NewRegistry(), logger.New(), engine.Options{Schemas: schemas})
+	require.ErrorIs(t, err, schema.ErrSchemaNotFound)
+	_, err = engine.New(leaf, engine.NewRegistry(), logger.New(), engine.Options{})
+	require.ErrorIs(t, err, engine.ErrUnknownNodeType)
+	badPort, err := load(t, `flow "f" {
+		node "check" { type: "Validate" schema: "order" }
+		node "rejects" { type: "Passthrough" from: "check.rejected" }
+	}`, "")
+	require.NoError(t, err)
+	_, err = engine.New(badPort, engine.NewRegistry(), logger.New(), engine.Options{Schemas: schemas})
+	require.ErrorIs(t, err, engine.ErrInvalidGraph)
+}
+
+func TestScript(t *testing.T) {
+	ctx := context.Background()
+	graph, err := load(t, `flow "orders" {
+		node "price" {
+			type: "Script"
+			source: "
+				total = 0
+				for item in msg['items']:
+					total += item['qty'] * item['price']
+				if total == 0:
+					drop()
+				msg['total'] = total
+				headers['tier'] = 'gold' if total > 100 else 'standard'
+			"
+		}
+		node "lines" {
+			type: "Script"
+			from: "price"
+			source: "
+				for item in msg['items']:
+					emit({'order': msg['id'], 'sku': item['sku']})
+			"
+		}
+	}`, "")
+	require.NoError(t, err)
+
+	outputs := map[string][]types.Message{}
+	e, err := engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{
+		OnOutput: func(nodeID string, msg types.Message) { outputs[nodeID] = append(outputs[nodeID], msg) },
+	})
+	require.NoError(t, err)
+
+	// Scripts modify messages and headers, and emit messages of their own
+	require.NoError(t, e.Process(ctx, types.Message{
+		ID:       "o1",
+		Data:     json.RawMessage(`{"id": 7, "items": [{"sku": "a", "qty": 2, "price": 40}, {"sku": "b", "qty": 1, "price": 30}]}`),
+		Metadata: types.MessageMetadata{Headers: map[string]string{"tenant": "acme"}},
+	}))
+	require.Len(t, outputs["lines"], 2)
+	for i, sku := range []string{"a", "b"} {
+		msg := outputs["lines"][i]
+		require.Equal(t, "o1."+string(rune('0'+i)), msg.ID)
+		require.JSONEq(t, `{"order": 7, "sku": "`+sku+`"}`, string(msg.Data))
+		require.Equal(t, map[string]string{"tenant": "acme", "tier": "gold"}, msg.Metadata.Headers)
+	}
+
+	// Dropped messages go no further
+	outputs = map[string][]types.Message{}
+	require.NoError(t, e.Process(ctx, types.Message{ID: "o2", Data: json.RawMessage(`{"items": []}`)}))
+	require.Empty(t, outputs)
+
+	// Runaway scripts are stopped
+	graph, err = load(t, `flow "f" {
+		node "spin" { type: "Script" source: "while True:
+			pass" max_steps: 500 }
+	}`, "")
+	require.NoError(t, err)
+	e, err = engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{})
+	require.NoError(t, err)
+	err = e.Process(ctx, types.Message{ID: "m1", Data: json.RawMessage(`{}`)})
+	require.ErrorIs(t, err, script.ErrStepLimit)
+
+	// Invalid scripts are rejected up front
+	graph, err = load(t, `flow "f" {
+		node "bad" { type: "Script" source: "if msg['ok']" }
+	}`, "")
+	require.NoError(t, err)
+	_, err = engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{})
+	require.ErrorContains(t, err, "invalid script: line 1: got end of file, want ':'")
+}
+
+// shell logs like a node running a process
+type shell struct {
+	engine.BaseNode
+}
+
+func (n *shell) Process(ctx context.Context, msg types.Message) (types.Message, error) {
+	stdout := engine.LogWriter(n.GetLogs(), types.LogLevelInfo, types.Fields{"stream": "stdout"})
+	stderr := engine.LogWriter(n.GetLogs(), types.LogLevelWarn, types.Fields{"stream": "stderr"})
+	_, _ = io.WriteString(stdout, "line 1\r\nline")
+	_, _ = io.WriteString(stderr, "oops\n")
+	_, _ = io.WriteString(stdout, " 2\nlast")
+	_ = stdout.Close()
+	_ = stderr.Close()
+	n.GetLogs().WithFields(types.Fields{"message_id": msg.ID}).Error("exit status 1", errors.New("failed"), nil)
+	return msg, nil
+}
+
+func TestNodeLogs(t *testing.T) {
+	graph, err := load(t, `flow "jobs" {
+		node "run" { type: "Shell" }
+		node "note" { type: "Script" source: "log('ran %d' % msg['n'])" }
+	}`, "")
+	require.NoError(t, err)
+	registry := engine.NewRegistry()
+	registry.Register("Shell", func(cfg types.NodeConfig) (types.Node, error) {
+		return &shell{BaseNode: engine.BaseNode{Config: cfg}}, nil
+	})
+
+	var logs []types.NodeLog
+	e, err := engine.New(graph, registry, logger.New(), engine.Options{
+		RunID: "r1",
+		OnLog: func(entry types.NodeLog) { logs = append(logs, entry) },
+	})
+	require.NoError(t, err)
+	require.NoError(t, e.Process(context.Background(), types.Message{ID: "m1", Data: json.RawMessage(`{"n": 1}`)}))
+
+	// Entries are tagged with the flow, node and run, and process output
+	// is logged line by line
+	type line struct {
+		node, level, message string
+		fields               types.Fields
+	}
+	var got []line
+	for _, entry := range logs {
+		require.Equal(t, "jobs", entry.FlowID)
+		require.Equal(t, "r1", entry.RunID)
+		got = append(got, line{entry.NodeID, string(entry.Level), entry.Message, entry.Fields})
+	}
+	require.Equal(t, []line{
+		{"run", "info", "line 1", types.Fields{"stream": "stdout"}},
+		{"run", "warn", "oops", types.Fields{"stream": "stderr"}},
+		{"run", "info", "line 2", types.Fields{"stream": "stdout"}},
+		{"run", "info", "last", types.Fields{"stream": "stdout"}},
+		{"run", "error", "exit status 1", types.Fields{"message_id": "m1"}},
+		{"note", "info", "ran 1", types.Fields{"message_id": "m1"}},
+	}, got)
+	require.Equal(t, "failed", logs[4].Error)
+
+	// Nodes outside an engine can log too
+	(&shell{}).GetLogs().Info("discarded", nil)
+}
+
+func TestTimeline(t *testing.T) {
+	graph, err := load(t, `flow "orders" {
+		node "price" { type: "Script" source: "msg['total'] = msg['qty'] * 2" }
+		node "check" {
+			type: "Script"
+			source: "
+				if msg['total'] > 2:
+					fail('too much')
+			"
+		}
+	}`, "")
+	require.NoError(t, err)
+
+	var hops []types.MessageHop
+	e, err := engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{
+		RunID: "r1",
+		OnHop: func(hop types.MessageHop) { hops = append(hops, hop) },
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, e.Process(ctx, types.Message{ID: "m1", Data: json.RawMessage(`{"qty":1}`)}))
+	require.Error(t, e.Process(ctx, types.Message{ID: "m2", Data: json.RawMessage(`{"qty":2}`)}))
+
+	// Every node records what it saw and produced, in order
+	require.Len(t, hops, 4)
+	for _, hop := range hops {
+		require.Equal(t, "orders", hop.FlowID)
+		require.Equal(t, "r1", hop.RunID)
+		require.False(t, hop.FinishedAt.Before(hop.StartedAt))
+	}
+	require.Equal(t, "price", hops[0].NodeID)
+	require.JSONEq(t, `{"qty":1}`, string(hops[0].Input.Data))
+	require.Len(t, hops[0].Outputs, 1)
+	require.JSONEq(t, `{"qty":1,"total":2}`, string(hops[0].Outputs[0].Data))
+	require.Equal(t, "check", hops[1].NodeID)
+	require.Equal(t, engine.TypeNodeProcessed, hops[1].Event)
+
+	// Failures record the error instead of outputs
+	require.Equal(t, "check", hops[3].NodeID)
+	require.Equal(t, engine.TypeNodeFailed, hops[3].Event)
+	require.Contains(t, hops[3].Error, "too much")
+	require.Empty(t, hops[3].Outputs)
+}
+
+func TestPause(t *testing.T) {
+	ctx := context.Background()
+	graph, err := load(t, `flow "orders" {
+		node "read" { type: "Passthrough" }
+		node "bill" { type: "Transform" set: { billed: 1 } }
+		node "notify" { type: "Passthrough" }
+		node "audit" { type: "Passthrough" from: "read" }
+	}`, "")
+	require.NoError(t, err)
+	var outputs []string
+	var events []string
+	e, err := engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{
+		OnEvent: func(event types.FlowEvent) { events = append(events, event.Type) },
+		OnOutput: func(nodeID string, msg types.Message) {
+			outputs = append(outputs, nodeID+" "+msg.ID)
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, e.Start(ctx))
+	require.NoError(t, e.WaitIdle(ctx))
+
+	// Paused nodes buffer their input while the other branches go on
+	require.NoError(t, e.Pause("bill"))
+	require.NoError(t, e.Pause("bill"))
+	require.NoError(t, e.Process(ctx, types.Message{ID: "1", Data: json.RawMessage(`{}`)}))
+	require.NoError(t, e.Process(ctx, types.Message{ID: "2", Data: json.RawMessage(`{}`)}))
+	require.Equal(t, []string{"audit 1", "audit 2"}, outputs)
+	require.Equal(t, []engine.NodeStatus{
+		{ID: "read", State: types.ResourceStateRunning},
+		{ID: "bill", State: types.ResourceStateThrottled, Buffered: 2},
+		{ID: "notify", State: types.ResourceStateRunning},
+		{ID: "audit", State: types.ResourceStateRunning},
+	}, e.NodeStatuses())
+
+	waited := make(chan error, 1)
+	go func() { waited <- e.WaitIdle(ctx) }()
+	select {
+	case <-waited:
+		t.Fatal("WaitIdle returned while a node is paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Resuming passes the buffered messages on, in order
+	require.NoError(t, e.Resume(ctx, "bill"))
+	require.NoError(t, <-waited)
+	require.Equal(t, []string{"audit 1", "audit 2", "notify 1", "notify 2"}, outputs)
+	require.Equal(t, types.ResourceStateRunning, e.NodeStatuses()[1].State)
+	require.NoError(t, e.Resume(ctx, "bill"))
+	require.Contains(t, events, engine.TypeNodePaused)
+	require.Contains(t, events, engine.TypeNodeResumed)
+
+	require.ErrorIs(t, e.Pause("missing"), engine.ErrUnknownNode)
+	require.ErrorIs(t, e.Resume(ctx, "missing"), engine.ErrUnknownNode)
+
+	// Stopping drops what is still buffered
+	require.NoError(t, e.Pause("notify"))
+	require.NoError(t, e.Process(ctx, types.Message{ID: "3", Data: json.RawMessage(`{}`)}))
+	require.NoError(t, e.Stop(ctx))
+	require.Equal(t, 0, e.NodeStatuses()[2].Buffered)
+}
+
+func TestBreakpoints(t *testing.T) {
+	ctx := context.Background()
+	graph, err := load(t, `flow "orders" {
+		node "read" { type: "Passthrough" }
+		node "price" { type: "Script" source: "msg['total'] = msg['qty'] * 2" }
+		node "ship" { type: "Passthrough" }
+	}`, "")
+	require.NoError(t, err)
+	var outputs []string
+	e, err := engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{
+		RunID:       "r1",
+		Breakpoints: []string{"price"},
+		OnOutput: func(nodeID string, msg types.Message) {
+			outputs = append(outputs, nodeID+" "+string(msg.Data))
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, e.Start(ctx))
+	require.Equal(t, []string{"price"}, e.Breakpoints())
+
+	// Messages stop before nodes with breakpoints
+	require.NoError(t, e.Process(ctx, types.Message{ID: "1", Data: json.RawMessage(`{"qty":1}`)}))
+	require.Empty(t, outputs)
+	breaks := e.Breaks()
+	require.Len(t, breaks, 1)
+	require.Equal(t, "price", breaks[0].NodeID)
+	require.Equal(t, "r1", breaks[0].RunID)
+	require.JSONEq(t, `{"qty":1}`, string(breaks[0].Message.Data))
+
+	waited := make(chan error, 1)
+	go func() { waited <- e.WaitIdle(ctx) }()
+	select {
+	case <-waited:
+		t.Fatal("WaitIdle returned while a message is stopped")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Stopped messages can be edited, then stepped one node at a time
+	edited, err := e.EditBreak(breaks[0].ID, engine.BreakEdit{Data: json.RawMessage(`{"qty":5}`)})
+	require.NoError(t, err)
+	require.True(t, edited.Edited)
+	next, err := e.Step(ctx, breaks[0].ID)
+	require.NoError(t, err)
+	require.Len(t, next, 1)
+	require.Equal(t, "ship", next[0].NodeID)
+	require.JSONEq(t, `{"qty":5,"total":10}`, string(next[0].Message.Data))
+	_, err = e.Step(ctx, breaks[0].ID)
+	require.ErrorIs(t, err, engine.ErrBreakNotFound)
+
+	// Continuing lets them go until the next breakpoint
+	next, err = e.Continue(ctx, next[0].ID)
+	require.NoError(t, err)
+	require.Empty(t, next)
+	require.NoError(t, <-waited)
+	require.Equal(t, []string{`ship {"qty":5,"total":10}`}, outputs)
+
+	// Breakpoints are set and cleared while the flow runs
+	require.NoError(t, e.ClearBreakpoint("price"))
+	require.NoError(t, e.SetBreakpoint("ship"))
+	require.NoError(t, e.Process(ctx, types.Message{ID: "2", Data: json.RawMessage(`{"qty":1}`)}))
+	breaks = e.Breaks()
+	require.Len(t, breaks, 1)
+	require.Equal(t, "ship", breaks[0].NodeID)
+	require.ErrorIs(t, e.SetBreakpoint("missing"), engine.ErrUnknownNode)
+	_, err = engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{Breakpoints: []string{"missing"}})
+	require.ErrorIs(t, err, engine.ErrUnknownNode)
+
+	// Stopping drops the stopped messages
+	require.NoError(t, e.Stop(ctx))
+	require.Empty(t, e.Breaks())
+	require.NoError(t, e.WaitIdle(ctx))
+}
+
+func TestApproval(t *testing.T) {
+	ctx := context.Background()
+	graph, err := load(t, `flow "changes" {
+		node "approve" { type: "Approval" title: "Deploy" escalate_to: "lead" }
+		node "deploy" { type: "Passthrough" }
+		node "discard" { type: "Passthrough" from: "approve.rejected" }
+	}`, "")
+	require.NoError(t, err)
+	// Flow source has no fractional numbers
+	graph.Nodes[0].Config.Settings["escalate_after_seconds"] = 0.02
+	graph.Nodes[0].Config.Settings["timeout_seconds"] = 0.2
+
+	var mu sync.Mutex
+	var events []types.FlowEvent
+	var outputs []types.Message
+	e, err := engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{
+		RunID: "run-1",
+		OnEvent: func(event types.FlowEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, event)
+		},
+		OnOutput: func(nodeID string, msg types.Message) {
+			mu.Lock()
+			defer mu.Unlock()
+			outputs = append(outputs, types.Message{ID: nodeID + " " + msg.ID, Metadata: msg.Metadata})
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, e.Start(ctx))
+
+	// Messages are held until they are decided
+	require.NoError(t, e.Process(ctx, types.Message{ID: "1", Data: json.RawMessage(`{}`)}))
+	require.NoError(t, e.Process(ctx, types.Message{ID: "2", Data: json.RawMessage(`{}`)}))
+	pending := e.Approvals()
+	require.Len(t, pending, 2)
+	require.Equal(t, "1", pending[0].Message.ID)
+	require.Equal(t, engine.ApprovalPending, pending[0].Status)
+	require.Equal(t, "Deploy", pending[0].Title)
+	require.Equal(t, "run-1", pending[0].RunID)
+	require.NotNil(t, pending[0].Deadline)
+	require.Empty(t, outputs)
+
+	approved, err := e.Decide(ctx, pending[0].ID, engine.Decision{Approve: true, By: "alice", Comment: "ship it"})
+	require.NoError(t, err)
+	require.Equal(t, engine.ApprovalApproved, approved.Status)
+	require.Equal(t, "alice", approved.DecidedBy)
+	rejected, err := e.Decide(ctx, pending[1].ID, engine.Decision{By: "bob"})
+	require.NoError(t, err)
+	require.Equal(t, engine.ApprovalRejected, rejected.Status)
+	_, err = e.Decide(ctx, pending[0].ID, engine.Decision{Approve: true, By: "alice"})
+	require.ErrorIs(t, err, engine.ErrApprovalNotFound)
+	require.NoError(t, e.WaitIdle(ctx))
+
+	mu.Lock()
+	require.Len(t, outputs, 2)
+	require.Equal(t, "deploy 1", outputs[0].ID)
+	require.Equal(t, "alice", outputs[0].Metadata.Headers["approval_by"])
+	require.Equal(t, "discard 2", outputs[1].ID)
+	require.Equal(t, engine.ApprovalRejected, outputs[1].Metadata.Headers["approval_status"])
+	outputs = nil
+	mu.Unlock()
+
+	// Undecided approvals are escalated, then decided by their timeout
+	require.NoError(t, e.Process(ctx, types.Message{ID: "3", Data: json.RawMessage(`{}`)}))
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, e.WaitIdle(waitCtx))
+	mu.Lock()
+	require.Len(t, outputs, 1)
+	require.Equal(t, "discard 3", outputs[0].ID)
+	require.Equal(t, engine.DecidedByTimeout, outputs[0].Metadata.Headers["approval_by"])
+	var kinds []string
+	for _, event := range events {
+		if event.Type == engine.TypeApprovalEscalated {
+			require.Equal(t, "lead", event.Data["escalate_to"])
+		}
+		kinds = append(kinds, event.Type)
+	}
+	mu.Unlock()
+	require.Contains(t, kinds, engine.TypeApprovalRequested)
+	require.Contains(t, kinds, engine.TypeApprovalApproved)
+	require.Contains(t, kinds, engine.TypeApprovalRejected)
+	require.Contains(t, kinds, engine.TypeApprovalEscalated)
+
+	// Stopping cancels the pending approvals
+	require.NoError(t, e.Process(ctx, types.Message{ID: "4", Data: json.RawMessage(`{}`)}))
+	require.NoError(t, e.Stop(ctx))
+	require.Empty(t, e.Approvals())
+	require.NoError(t, e.WaitIdle(ctx))
+	mu.Lock()
+	require.Equal(t, engine.TypeApprovalCancelled, events[len(events)-2].Type)
+	mu.Unlock()
+
+	_, err = engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{})
+	require.NoError(t, err)
+	bad, err := load(t, `flow "bad" { node "approve" { type: "Approval" timeout_seconds: 1 escalate_after_seconds: 2 } }`, "")
+	require.NoError(t, err)
+	_, err = engine.New(bad, engine.NewRegistry(), logger.New(), engine.Options{})
+	require.ErrorContains(t, err, "escalate_after_seconds")
+}
+
+func TestSimulate(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	rows := filepath.Join(dir, "orders.csv")
+	require.NoError(t, os.WriteFile(rows, []byte("id,qty,paid\na,1,true\nb,2.5,false\nc,x,\n"), 0o600))
+	graph, err := load(t, `flow "orders" {
+		node "gen" {
+			type: "Generate"
+			count: 20
+			seed: 7
+			definition: {
+				type: "object"
+				properties: { qty: { type: "int" minimum: 1 maximum: 5 } }
+				required: ["qty"]
+			}
+		}
+		node "csv" { type: "Replay" from: [] path: "orders.csv" loop: true count: 7 }
+		node "sink" { type: "Passthrough" from: ["gen", "csv"] }
+	}`, "")
+	require.NoError(t, err)
+
+	simulate := func() map[string][]types.Message {
+		outputs := map[string][]types.Message{}
+		e, err := engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{
+			DataDir: dir,
+			OnOutput: func(nodeID string, msg types.Message) {
+				source := strings.SplitN(msg.ID, "-", 2)[0]
+				outputs[source] = append(outputs[source], msg)
+			},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"gen", "csv"}, e.Sources())
+		require.NoError(t, e.Start(ctx))
+		require.NoError(t, e.Simulate(ctx, nil))
+		require.NoError(t, e.Stop(ctx))
+		return outputs
+	}
+	outputs := simulate()
+
+	// Generated records match the schema and are marked as simulated
+	require.Len(t, outputs["gen"], 20)
+	for i, msg := range outputs["gen"] {
+		require.Equal(t, fmt.Sprintf("gen-%d", i+1), msg.ID)
+		require.Equal(t, "true", msg.Metadata.Headers[engine.HeaderSimulation])
+		var record struct{ Qty int }
+		require.NoError(t, json.Unmarshal(msg.Data, &record))
+		require.GreaterOrEqual(t, record.Qty, 1)
+		require.LessOrEqual(t, record.Qty, 5)
+	}
+
+	// Replayed rows are objects keyed by the header, looping until the
+	// count is reached
+	require.Len(t, outputs["csv"], 7)
+	require.JSONEq(t, `{"id":"a","qty":1,"paid":true}`, string(outputs["csv"][0].Data))
+	require.JSONEq(t, `{"id":"b","qty":2.5,"paid":false}`, string(outputs["csv"][1].Data))
+	require.JSONEq(t, `{"id":"c","qty":"x","paid":""}`, string(outputs["csv"][2].Data))
+	require.JSONEq(t, `{"id":"a","qty":1,"paid":true}`, string(outputs["csv"][3].Data))
+
+	// A seed repeats the records
+	again := simulate()
+	for i, msg := range outputs["gen"] {
+		require.JSONEq(t, string(msg.Data), string(again["gen"][i].Data))
+	}
+
+	// Pace is asked before each message and its failures end the sources
+	var mu sync.Mutex
+	paced := 0
+	errPace := errors.New("over quota")
+	e, err := engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{DataDir: dir})
+	require.NoError(t, err)
+	err = e.Simulate(ctx, func(context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		paced++
+		if paced > 3 {
+			return errPace
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, errPace)
+	require.ErrorContains(t, err, "source gen failed")
+	require.ErrorContains(t, err, "source csv failed")
+
+	// Rates space the messages out, and rows are sent once without a loop
+	graph, err = load(t, `flow "paced" {
+		node "csv" { type: "Replay" path: "`+rows+`" rate: 40 arrivals: "poisson" }
+	}`, "")
+	require.NoError(t, err)
+	var replayed int
+	e, err = engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{
+		DataDir:  dir,
+		OnOutput: func(string, types.Message) { replayed++ },
+	})
+	require.NoError(t, err)
+	require.NoError(t, e.Start(ctx))
+	require.NoError(t, e.Simulate(ctx, nil))
+	require.Equal(t, 3, replayed)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, e.Simulate(cancelled, nil), context.Canceled)
+
+	// Sources pass input on unchanged outside of simulations
+	require.NoError(t, e.Process(ctx, types.Message{ID: "m1", Data: json.RawMessage(`{}`)}))
+	require.Equal(t, 4, replayed)
+
+	// Missing files fail the source, and flows need sources to simulate
+	graph, err = load(t, `flow "broken" { node "csv" { type: "Replay" path: "`+rows+`.missing" } }`, "")
+	require.NoError(t, err)
+	e, err = engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{DataDir: dir})
+	require.NoError(t, err)
+	require.ErrorContains(t, e.Simulate(ctx, nil), "source csv failed")
+
+	// Files are only replayed from the data directory
+	e, err = engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{})
+	require.NoError(t, err)
+	require.ErrorContains(t, e.Simulate(ctx, nil), "need a data directory")
+	outside := filepath.Join(t.TempDir(), "secret.csv")
+	require.NoError(t, os.WriteFile(outside, []byte("a\n1\n"), 0o600))
+	for _, path := range []string{outside, "../" + filepath.Base(filepath.Dir(outside)) + "/secret.csv", "nested/../../secret.csv"} {
+		graph, err = load(t, `flow "escape" { node "csv" { type: "Replay" path: "`+path+`" } }`, "")
+		require.NoError(t, err)
+		_, err = engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{DataDir: dir})
+		require.ErrorIs(t, err, engine.ErrOutsideDataDir, path)
+	}
+	require.NoError(t, os.Symlink(outside, filepath.Join(dir, "link.csv")))
+	graph, err = load(t, `flow "link" { node "csv" { type: "Replay" path: "link.csv" } }`, "")
+	require.NoError(t, err)
+	e, err = engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{DataDir: dir})
+	require.NoError(t, err)
+	require.ErrorIs(t, e.Simulate(ctx, nil), engine.ErrOutsideDataDir)
+
+	graph, err = load(t, `flow "real" { node "read" { type: "Passthrough" } }`, "")
+	require.NoError(t, err)
+	e, err = engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{})
+	require.NoError(t, err)
+	require.ErrorIs(t, e.Simulate(ctx, nil), engine.ErrNoSources)
+
+	// Settings are checked when the flow is loaded
+	for name, src := range map[string]string{
+		"no schema":  `node "g" { type: "Generate" }`,
+		"registry":   `node "g" { type: "Generate" schema: "order" }`,
+		"definition": `node "g" { type: "Generate" definition: { type: "nope" } }`,
+		"arrivals":   `node "g" { type: "Generate" definition: { type: "int" } arrivals: "bursty" }`,
+		"count":      `node "g" { type: "Generate" definition: { type: "int" } count: 0 }`,
+		"path":       `node "r" { type: "Replay" }`,
+	} {
+		graph, err := load(t, `flow "f" { `+src+` }`, "")
+		require.NoError(t, err)
+		_, err = engine.New(graph, engine.NewRegistry(), logger.New(), engine.Options{})
+		require.Error(t, err, name)
+	}
+}
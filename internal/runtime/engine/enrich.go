@@ -0,0 +1,612 @@
+package engine
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"flow-control/internal/types"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// TypeEnrich joins messages against a lookup source. Its "key" setting is
+// the dotted path of the field looked up, and its "into" setting the dotted
+// path of the field the value found is set to. Its "source" setting is one
+// of EnrichStatic, EnrichHTTP or EnrichSQL.
+//
+// Values found are cached for cache_ttl_seconds, and keys the source holds
+// nothing for are cached for negative_ttl_seconds. The "on_miss" setting,
+// one of MissPass, the default, MissDrop or MissError, decides what happens
+// to messages whose key is missing or not found.
+const TypeEnrich = "Enrich"
+
+// Lookup sources of Enrich nodes
+const (
+	// EnrichStatic looks keys up in the object of the "table" setting
+	EnrichStatic = "static"
+	// EnrichHTTP gets the JSON document at the "url" setting, in which
+	// {key} is replaced by the escaped key; 404 responses are misses. The
+	// URL must start with one of Lookups.URLs.
+	EnrichHTTP = "http"
+	// EnrichSQL runs the "query" setting with the key as its only argument
+	// on the database of Lookups.Databases named by the "database" setting,
+	// and takes the first row as an object of its columns
+	EnrichSQL = "sql"
+)
+
+// Miss policies of Enrich nodes
+const (
+	// MissPass passes messages on unchanged
+	MissPass = "pass"
+	// MissDrop drops messages
+	MissDrop = "drop"
+	// MissError fails messages with ErrLookupMiss
+	MissError = "error"
+)
+
+// Defaults of Enrich nodes
+const (
+	DefaultEnrichCacheTTL  = 60 * time.Second
+	DefaultEnrichCacheSize = 1000
+	DefaultEnrichTimeout   = 5 * time.Second
+)
+
+// enrichMaxResponse bounds the HTTP responses Enrich nodes read
+const enrichMaxResponse = 1 << 20
+
+// ErrLookupMiss is returned by Enrich nodes failing messages whose key was
+// not found
+var ErrLookupMiss = errors.New("lookup miss")
+
+// ErrLookupDenied is returned for Enrich nodes reaching URLs or databases
+// missing from the Lookups of the engine
+var ErrLookupDenied = errors.New("lookup not allowed")
+
+// Lookups are the endpoints the http and sql sources of Enrich nodes may
+// reach. They are set by operators rather than flow source, so that flows
+// cannot make the engine request any URL or open any database it can.
+type Lookups struct {
+	// URLs are the prefixes of the URLs http sources may request, such as
+	// https://crm.internal/customers/. The scheme and host must match, and
+	// the cleaned path of a request must start with the path of the
+	// prefix.
+	URLs []string
+	// Databases are the databases sql sources may query, by the name
+	// their "database" setting refers to them by
+	Databases map[string]LookupDatabase
+}
+
+// LookupDatabase is a database the sql sources of Enrich nodes may query
+type LookupDatabase struct {
+	// Driver is postgres or sqlite
+	Driver string
+	// DSN is the data source name of the database
+	DSN string
+}
+
+// allowsURL reports whether target starts with one of the URL prefixes
+func (l Lookups) allowsURL(target *url.URL) bool {
+	if target.User != nil {
+		return false
+	}
+	for _, allowed := range l.URLs {
+		prefix, err := url.Parse(allowed)
+		if err != nil || prefix.Host == "" {
+			continue
+		}
+		if target.Scheme != prefix.Scheme || !strings.EqualFold(target.Host, prefix.Host) {
+			continue
+		}
+		// Dot segments are resolved first, so that keys such as .. cannot
+		// lead out of the prefix
+		clean := path.Clean("/" + target.Path)
+		if strings.HasSuffix(target.Path, "/") && clean != "/" {
+			clean += "/"
+		}
+		// Prefixes match whole path segments, so /customers does not allow
+		// /customers-internal
+		if clean == prefix.Path || strings.HasPrefix(clean, strings.TrimSuffix(prefix.Path, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupSource finds the value a source holds for a key
+type lookupSource interface {
+	// Lookup returns the value held for key, with found false if there is
+	// none
+	Lookup(ctx context.Context, key string) (value interface{}, found bool, err error)
+	Close() error
+}
+
+// enrich sets a field of messages to the value its source holds for
+// another field
+type enrich struct {
+	BaseNode
+	key    []string
+	into   []string
+	onMiss string
+	source lookupSource
+	cache  *lookupCache
+}
+
+func newEnrich(cfg types.NodeConfig) (types.Node, error) {
+	key, _ := cfg.Settings["key"].(string)
+	if key == "" {
+		return nil, fmt.Errorf("key must be set")
+	}
+	into, _ := cfg.Settings["into"].(string)
+	if into == "" {
+		return nil, fmt.Errorf("into must be set")
+	}
+	n := &enrich{
+		BaseNode: BaseNode{Config: cfg},
+		key:      strings.Split(key, "."),
+		into:     strings.Split(into, "."),
+		onMiss:   MissPass,
+	}
+	if onMiss, ok := cfg.Settings["on_miss"]; ok {
+		n.onMiss, _ = onMiss.(string)
+		if n.onMiss != MissPass && n.onMiss != MissDrop && n.onMiss != MissError {
+			return nil, fmt.Errorf("on_miss must be %s, %s or %s", MissPass, MissDrop, MissError)
+		}
+	}
+
+	ttl, err := numberSetting(cfg.Settings, "cache_ttl_seconds", DefaultEnrichCacheTTL.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	negativeTTL, err := numberSetting(cfg.Settings, "negative_ttl_seconds", 0)
+	if err != nil {
+		return nil, err
+	}
+	size, err := numberSetting(cfg.Settings, "cache_size", DefaultEnrichCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	n.cache = newLookupCache(seconds(ttl), seconds(negativeTTL), int(size))
+
+	timeout, err := numberSetting(cfg.Settings, "timeout_seconds", DefaultEnrichTimeout.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	source, _ := cfg.Settings["source"].(string)
+	switch source {
+	case EnrichStatic:
+		n.source, err = newStaticSource(cfg.Settings)
+	case EnrichHTTP:
+		n.source, err = newHTTPSource(cfg.Settings, seconds(timeout))
+	case EnrichSQL:
+		n.source, err = newSQLSource(cfg.Settings, seconds(timeout))
+	default:
+		return nil, fmt.Errorf("source must be %s, %s or %s", EnrichStatic, EnrichHTTP, EnrichSQL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Process implements types.Node.Process
+func (n *enrich) Process(ctx context.Context, input types.Message) (types.Message, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(input.Data, &fields); err != nil || fields == nil {
+		return types.Message{}, fmt.Errorf("enrich needs a JSON object message")
+	}
+	key, err := lookupKey(lookup(fields, n.key))
+	if err != nil {
+		return types.Message{}, err
+	}
+	if key == "" {
+		return n.miss(input, key)
+	}
+
+	value, found, cached := n.cache.get(key)
+	if !cached {
+		value, found, err = n.source.Lookup(ctx, key)
+		if err != nil {
+			return types.Message{}, err
+		}
+		n.cache.put(key, value, found)
+	}
+	if !found {
+		return n.miss(input, key)
+	}
+
+	setPath(fields, n.into, value)
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return types.Message{}, fmt.Errorf("failed to encode message: %w", err)
+	}
+	input.Data = data
+	return input, nil
+}
+
+// miss applies the miss policy to input, whose key was not found
+func (n *enrich) miss(input types.Message, key string) (types.Message, error) {
+	switch n.onMiss {
+	case MissDrop:
+		return types.Message{}, ErrDrop
+	case MissError:
+		return types.Message{}, fmt.Errorf("%w: no value for key %q", ErrLookupMiss, key)
+	}
+	return input, nil
+}
+
+// bind checks the source of the node against the lookups of the engine
+// running it, and connects sql sources to their database
+func (n *enrich) bind(e *Engine) error {
+	var err error
+	switch s := n.source.(type) {
+	case *httpSource:
+		err = s.bind(e.opts.Lookups)
+	case *sqlSource:
+		err = s.bind(e.opts.Lookups)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create node %s: %w", n.Config.ID, err)
+	}
+	return nil
+}
+
+// Stop implements types.Node.Stop
+func (n *enrich) Stop(ctx context.Context) error {
+	return n.source.Close()
+}
+
+// lookupKey returns the key of a field value, or "" when the field is
+// missing
+func lookupKey(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", fmt.Errorf("lookup key must be a string, number or boolean")
+}
+
+// setPath sets the field at path of fields to value, creating the objects
+// on the way and replacing the values that are not objects
+func setPath(fields map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := fields[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			fields[key] = next
+		}
+		fields = next
+	}
+	fields[path[len(path)-1]] = value
+}
+
+// numberSetting returns the setting called name, a number of at least 0,
+// or def when it is not set
+func numberSetting(settings map[string]interface{}, name string, def float64) (float64, error) {
+	value, ok := settings[name]
+	if !ok {
+		return def, nil
+	}
+	n, ok := value.(float64)
+	if !ok || n < 0 {
+		return 0, fmt.Errorf("%s must be a number of at least 0", name)
+	}
+	return n, nil
+}
+
+// seconds converts a number of seconds to a duration
+func seconds(n float64) time.Duration {
+	return time.Duration(n * float64(time.Second))
+}
+
+// staticSource looks keys up in a table of the node's settings
+type staticSource struct {
+	table map[string]interface{}
+}
+
+func newStaticSource(settings map[string]interface{}) (*staticSource, error) {
+	table, ok := settings["table"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("table must be an object mapping keys to their values")
+	}
+	return &staticSource{table: table}, nil
+}
+
+// Lookup implements lookupSource.Lookup
+func (s *staticSource) Lookup(ctx context.Context, key string) (interface{}, bool, error) {
+	value, ok := s.table[key]
+	return value, ok, nil
+}
+
+// Close implements lookupSource.Close
+func (s *staticSource) Close() error { return nil }
+
+// httpSource gets the values of keys from an HTTP endpoint
+type httpSource struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+	lookups Lookups
+}
+
+func newHTTPSource(settings map[string]interface{}, timeout time.Duration) (*httpSource, error) {
+	rawURL, _ := settings["url"].(string)
+	if !strings.Contains(rawURL, "{key}") {
+		return nil, fmt.Errorf("url must be set and contain {key}")
+	}
+	u, err := url.Parse(strings.ReplaceAll(rawURL, "{key}", "key"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("url must be an http or https URL")
+	}
+
+	s := &httpSource{
+		url:     rawURL,
+		headers: make(map[string]string),
+		client:  &http.Client{Timeout: timeout},
+	}
+	if headers, ok := settings["headers"]; ok {
+		fields, ok := headers.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("headers must be an object")
+		}
+		// Flow source names cannot hold dashes, so underscores stand for them
+		for name, value := range fields {
+			s.headers[strings.ReplaceAll(name, "_", "-")] = fmt.Sprint(value)
+		}
+	}
+	return s, nil
+}
+
+// bind checks the URL of the source against lookups, which the requests
+// of each key and their redirects are checked against too
+func (s *httpSource) bind(lookups Lookups) error {
+	s.lookups = lookups
+	u, err := url.Parse(strings.ReplaceAll(s.url, "{key}", "key"))
+	if err != nil || !lookups.allowsURL(u) {
+		return fmt.Errorf("%w: url %s", ErrLookupDenied, s.url)
+	}
+	s.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if !s.lookups.allowsURL(req.URL) {
+			return fmt.Errorf("%w: redirect to %s", ErrLookupDenied, req.URL.Redacted())
+		}
+		return nil
+	}
+	return nil
+}
+
+// Lookup implements lookupSource.Lookup
+func (s *httpSource) Lookup(ctx context.Context, key string) (interface{}, bool, error) {
+	target := strings.ReplaceAll(s.url, "{key}", url.PathEscape(key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create lookup request: %w", err)
+	}
+	// Keys may change more than the path, as in http://host{key}
+	if !s.lookups.allowsURL(req.URL) {
+		return nil, false, fmt.Errorf("%w: key %q leads out of the allowed URLs", ErrLookupDenied, key)
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to look up key: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if resp.StatusCode >= 300 {
+		return nil, false, fmt.Errorf("failed to look up key: lookup responded %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, enrichMaxResponse))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read lookup response: %w", err)
+	}
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil, false, fmt.Errorf("invalid lookup response: %w", err)
+	}
+	return value, value != nil, nil
+}
+
+// Close implements lookupSource.Close
+func (s *httpSource) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// sqlDrivers maps the drivers of SQL sources to their database/sql names
+var sqlDrivers = map[string]string{
+	"postgres": "pgx",
+	"sqlite":   "sqlite3",
+}
+
+// sqlSource gets the values of keys from a database query
+type sqlSource struct {
+	database string
+	db       *sql.DB
+	query    string
+	timeout  time.Duration
+}
+
+func newSQLSource(settings map[string]interface{}, timeout time.Duration) (*sqlSource, error) {
+	database, _ := settings["database"].(string)
+	if database == "" {
+		return nil, fmt.Errorf("database must be set")
+	}
+	query, _ := settings["query"].(string)
+	if query == "" {
+		return nil, fmt.Errorf("query must be set")
+	}
+	return &sqlSource{database: database, query: query, timeout: timeout}, nil
+}
+
+// bind opens the database of lookups the source names
+func (s *sqlSource) bind(lookups Lookups) error {
+	database, ok := lookups.Databases[s.database]
+	if !ok {
+		return fmt.Errorf("%w: database %s", ErrLookupDenied, s.database)
+	}
+	driverName, ok := sqlDrivers[database.Driver]
+	if !ok {
+		return fmt.Errorf("driver of database %s must be postgres or sqlite", s.database)
+	}
+	// Connections are made on the first lookup
+	db, err := sql.Open(driverName, database.DSN)
+	if err != nil {
+		return fmt.Errorf("failed to open lookup database: %w", err)
+	}
+	s.db = db
+	return nil
+}
+
+// Lookup implements lookupSource.Lookup
+func (s *sqlSource) Lookup(ctx context.Context, key string) (interface{}, bool, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	rows, err := s.db.QueryContext(ctx, s.query, key)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to look up key: %w", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, false, fmt.Errorf("failed to look up key: %w", err)
+		}
+		return nil, false, nil
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to look up key: %w", err)
+	}
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err := rows.Scan(pointers...); err != nil {
+		return nil, false, fmt.Errorf("failed to read lookup row: %w", err)
+	}
+	row := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		if b, ok := values[i].([]byte); ok {
+			values[i] = string(b)
+		}
+		row[column] = values[i]
+	}
+	return row, true, nil
+}
+
+// Close implements lookupSource.Close
+func (s *sqlSource) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// lookupCache remembers the values found for keys, and the keys found to
+// have none, evicting the least recently used keys when it is full
+type lookupCache struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+	size        int
+	now         func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order lists the entries from the most recently used
+	order *list.List
+}
+
+// cacheEntry is a key of a lookupCache
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	found   bool
+	expires time.Time
+}
+
+func newLookupCache(ttl, negativeTTL time.Duration, size int) *lookupCache {
+	return &lookupCache{
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		size:        size,
+		now:         time.Now,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+	}
+}
+
+// get returns what was cached for key, with cached false if nothing was
+func (c *lookupCache) get(key string) (value interface{}, found, cached bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, entry.found, true
+}
+
+// put caches what the source holds for key
+func (c *lookupCache) put(key string, value interface{}, found bool) {
+	ttl := c.ttl
+	if !found {
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 || c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &cacheEntry{key: key, value: value, found: found, expires: c.now().Add(ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,155 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"flow-control/internal/types"
+	"flow-control/pkg/api"
+)
+
+// TypeFaultInjected is reported for every fault injected into a flow
+const TypeFaultInjected = "fault.injected"
+
+// Kinds of injected faults
+const (
+	FaultError   = "error"
+	FaultLatency = "latency"
+	FaultDrop    = "drop"
+	FaultCorrupt = "corrupt"
+)
+
+// ErrInjected is the error of nodes failed by fault injection
+var ErrInjected = errors.New("injected fault")
+
+// Faults configures the failures injected into a flow, as the dry run API
+// takes them
+type Faults = api.Faults
+
+// ParseFaults parses faults written as comma-separated settings, such as
+// "error=0.1,latency=0.5,latency_ms=20,nodes=enrich+send,seed=7". The rate
+// settings are error, drop, corrupt and latency.
+func ParseFaults(spec string) (Faults, error) {
+	var f Faults
+	for _, setting := range strings.Split(spec, ",") {
+		setting = strings.TrimSpace(setting)
+		if setting == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(setting, "=")
+		if !ok {
+			return Faults{}, fmt.Errorf("invalid fault setting %q: expected name=value", setting)
+		}
+		var err error
+		switch name {
+		case FaultError:
+			f.ErrorRate, err = strconv.ParseFloat(value, 64)
+		case FaultDrop:
+			f.DropRate, err = strconv.ParseFloat(value, 64)
+		case FaultCorrupt:
+			f.CorruptRate, err = strconv.ParseFloat(value, 64)
+		case FaultLatency:
+			f.LatencyRate, err = strconv.ParseFloat(value, 64)
+		case "latency_ms":
+			f.LatencyMs, err = strconv.Atoi(value)
+		case "seed":
+			f.Seed, err = strconv.ParseInt(value, 10, 64)
+		case "nodes":
+			f.Nodes = strings.Split(value, "+")
+		default:
+			return Faults{}, fmt.Errorf("unknown fault setting %q", name)
+		}
+		if err != nil {
+			return Faults{}, fmt.Errorf("invalid fault setting %q: %w", setting, err)
+		}
+	}
+	return f, f.Validate()
+}
+
+// injector decides which faults hit each message
+type injector struct {
+	faults Faults
+	mu     sync.Mutex
+	rand   *rand.Rand
+}
+
+// newInjector returns an injector for faults, or nil if they inject nothing
+func newInjector(faults Faults) *injector {
+	if !faults.Enabled() {
+		return nil
+	}
+	seed := faults.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &injector{faults: faults, rand: rand.New(rand.NewSource(seed))}
+}
+
+// hit reports whether a fault with rate hits node id
+func (i *injector) hit(id string, rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	if len(i.faults.Nodes) > 0 && !slices.Contains(i.faults.Nodes, id) {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.rand.Float64() < rate
+}
+
+// injectBefore injects the faults that hit node id before it processes a
+// message: a delay, then a failure or a drop. It returns the error that
+// replaces processing the message, if any.
+func (e *Engine) injectBefore(ctx context.Context, id string, msg types.Message) error {
+	f := e.faults
+	if f == nil {
+		return nil
+	}
+	if f.hit(id, f.faults.LatencyRate) && f.faults.LatencyMs > 0 {
+		e.emitFault(id, msg, FaultLatency)
+		select {
+		case <-time.After(time.Duration(f.faults.LatencyMs) * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if f.hit(id, f.faults.ErrorRate) {
+		e.emitFault(id, msg, FaultError)
+		return ErrInjected
+	}
+	if f.hit(id, f.faults.DropRate) {
+		e.emitFault(id, msg, FaultDrop)
+		return ErrDrop
+	}
+	return nil
+}
+
+// injectAfter corrupts the output of node id if a corruption hits it
+func (e *Engine) injectAfter(id string, out types.Message) types.Message {
+	if e.faults == nil || !e.faults.hit(id, e.faults.faults.CorruptRate) {
+		return out
+	}
+	e.emitFault(id, out, FaultCorrupt)
+	// Cutting the data in half leaves JSON values unterminated, and empty
+	// data is not JSON either
+	out.Data = append([]byte(nil), out.Data[:len(out.Data)/2]...)
+	return out
+}
+
+// emitFault reports a fault injected into node id
+func (e *Engine) emitFault(id string, msg types.Message, fault string) {
+	e.emit(types.FlowEvent{
+		Type:    TypeFaultInjected,
+		NodeID:  id,
+		Message: "Injected " + fault,
+		Data:    map[string]interface{}{"message_id": msg.ID, "fault": fault},
+	})
+}
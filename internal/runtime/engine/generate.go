@@ -0,0 +1,490 @@
+package engine
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"flow-control/internal/runtime/schema"
+	"flow-control/internal/types"
+)
+
+// TypeGenerate sends random records of a schema. Its "schema" and
+// "version" settings name a schema of the schema registry, or its
+// "definition" setting describes one inline as a schema.Definition.
+const TypeGenerate = "Generate"
+
+// TypeReplay sends the rows of a CSV file whose first row names the
+// columns, each as a JSON object mapping the names to the row's values. The
+// file is looked up in the data directory of the engine.
+const TypeReplay = "Replay"
+
+// Arrival patterns of generated messages
+const (
+	// ArrivalFixed sends messages at even intervals
+	ArrivalFixed = "fixed"
+	// ArrivalPoisson sends messages at random intervals, as a Poisson
+	// process of the node's rate
+	ArrivalPoisson = "poisson"
+)
+
+// ErrNoSources is returned by Simulate for flows without sources
+var ErrNoSources = errors.New("flow has no sources")
+
+// ErrOutsideDataDir is returned for Replay nodes whose file is not in the
+// data directory of the engine
+var ErrOutsideDataDir = errors.New("path is outside the data directory")
+
+// DefaultGenerateCount is the number of records a Generate node sends when
+// its count is not set
+const DefaultGenerateCount = 100
+
+// HeaderSimulation marks the messages sources generate, so that nodes and
+// outputs can tell them from real data
+const HeaderSimulation = "simulation"
+
+// Source is implemented by nodes generating messages of their own, such as
+// Generate and Replay. Simulate runs them; to Process they pass the
+// messages they receive on unchanged, so that flows keep running when real
+// sources replace them.
+type Source interface {
+	// Generate calls emit with each message the node generates until it
+	// is done, emit fails or ctx is done
+	Generate(ctx context.Context, emit func(types.Message) error) error
+}
+
+// pacing spaces out the messages of a source
+type pacing struct {
+	count   int
+	rate    float64
+	poisson bool
+	rng     *rand.Rand
+}
+
+func newPacing(settings map[string]interface{}, defaultCount int) (pacing, error) {
+	var p pacing
+	count, err := numberSetting(settings, "count", float64(defaultCount))
+	if err != nil {
+		return p, err
+	}
+	p.count = int(count)
+	if p.rate, err = numberSetting(settings, "rate", 0); err != nil {
+		return p, err
+	}
+	arrivals, _ := settings["arrivals"].(string)
+	switch arrivals {
+	case "", ArrivalFixed:
+	case ArrivalPoisson:
+		p.poisson = true
+	default:
+		return p, fmt.Errorf("arrivals must be %s or %s", ArrivalFixed, ArrivalPoisson)
+	}
+	seed, err := numberSetting(settings, "seed", 0)
+	if err != nil {
+		return p, err
+	}
+	if seed == 0 {
+		seed = float64(time.Now().UnixNano())
+	}
+	p.rng = rand.New(rand.NewSource(int64(seed)))
+	return p, nil
+}
+
+// interval returns how long to wait before the next message; zero without
+// a rate
+func (p pacing) interval() time.Duration {
+	if p.rate == 0 {
+		return 0
+	}
+	if p.poisson {
+		return seconds(p.rng.ExpFloat64() / p.rate)
+	}
+	return seconds(1 / p.rate)
+}
+
+// run calls next for each message until count messages were sent or next
+// reports there are none left, keeping to the rate. Waits are measured from
+// when the run started, so that slow flows do not lower the rate.
+func (p pacing) run(ctx context.Context, next func(seq int) (bool, error)) error {
+	due := time.Now()
+	for seq := 1; p.count == 0 || seq <= p.count; seq++ {
+		if wait := time.Until(due); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+		more, err := next(seq)
+		if err != nil || !more {
+			return err
+		}
+		due = due.Add(p.interval())
+	}
+	return nil
+}
+
+// generated builds the seq'th message of the source node with id
+func generated(id string, seq int, value interface{}) (types.Message, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return types.Message{}, fmt.Errorf("failed to encode generated record: %w", err)
+	}
+	return types.Message{
+		ID:   fmt.Sprintf("%s-%d", id, seq),
+		Data: data,
+		Metadata: types.MessageMetadata{
+			Timestamp: time.Now(),
+			Source:    id,
+			Headers:   map[string]string{HeaderSimulation: "true"},
+		},
+	}, nil
+}
+
+// generate sends random records of a schema
+type generate struct {
+	BaseNode
+	schemaType string
+	version    string
+	schema     types.Schema
+	pacing     pacing
+}
+
+func newGenerate(cfg types.NodeConfig) (types.Node, error) {
+	n := &generate{BaseNode: BaseNode{Config: cfg}}
+	n.schemaType, _ = cfg.Settings["schema"].(string)
+	if version, ok := cfg.Settings["version"]; ok {
+		n.version, _ = version.(string)
+		if n.version == "" {
+			return nil, fmt.Errorf("version must be a schema version")
+		}
+	}
+	if definition, ok := cfg.Settings["definition"]; ok {
+		if n.schemaType != "" {
+			return nil, fmt.Errorf("schema and definition cannot both be set")
+		}
+		data, err := json.Marshal(definition)
+		if err != nil {
+			return nil, fmt.Errorf("invalid definition: %w", err)
+		}
+		var def schema.Definition
+		if err := json.Unmarshal(data, &def); err != nil {
+			return nil, fmt.Errorf("invalid definition: %w", err)
+		}
+		if n.schema, err = schema.NewRegistry().Compile(def); err != nil {
+			return nil, fmt.Errorf("invalid definition: %w", err)
+		}
+	} else if n.schemaType == "" {
+		return nil, fmt.Errorf("schema or definition must be set")
+	}
+
+	var err error
+	if n.pacing, err = newPacing(cfg.Settings, DefaultGenerateCount); err != nil {
+		return nil, err
+	}
+	if n.pacing.count == 0 {
+		return nil, fmt.Errorf("count must be at least 1")
+	}
+	return n, nil
+}
+
+// bind gives the node the schema of the registry of the engine running it,
+// unless it describes its schema inline
+func (n *generate) bind(e *Engine) error {
+	if n.schema != nil {
+		return nil
+	}
+	if e.opts.Schemas == nil {
+		return fmt.Errorf("%w: %q nodes need a schema registry or a definition", ErrUnknownNodeType, TypeGenerate)
+	}
+	var err error
+	if n.version == "" {
+		n.schema, err = e.opts.Schemas.GetLatest(n.schemaType)
+	} else {
+		n.schema, err = e.opts.Schemas.Get(n.schemaType, n.version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create node %s: %w", n.Config.ID, err)
+	}
+	return nil
+}
+
+// Process implements types.Node.Process
+func (n *generate) Process(ctx context.Context, input types.Message) (types.Message, error) {
+	return input, nil
+}
+
+// Generate implements Source.Generate
+func (n *generate) Generate(ctx context.Context, emit func(types.Message) error) error {
+	return n.pacing.run(ctx, func(seq int) (bool, error) {
+		msg, err := generated(n.Config.ID, seq, schema.Sample(n.schema, n.pacing.rng))
+		if err != nil {
+			return false, err
+		}
+		return true, emit(msg)
+	})
+}
+
+// replay sends the rows of a CSV file
+type replay struct {
+	BaseNode
+	path string
+	// dir is the data directory of the engine running the node, which path
+	// is relative to
+	dir    string
+	loop   bool
+	pacing pacing
+}
+
+func newReplay(cfg types.NodeConfig) (types.Node, error) {
+	n := &replay{BaseNode: BaseNode{Config: cfg}}
+	n.path, _ = cfg.Settings["path"].(string)
+	if n.path == "" {
+		return nil, fmt.Errorf("path must be set")
+	}
+	// Flow source writes booleans as the identifiers true and false
+	switch loop := cfg.Settings["loop"]; loop {
+	case nil, false, "false":
+	case true, "true":
+		n.loop = true
+	default:
+		return nil, fmt.Errorf("loop must be true or false")
+	}
+	var err error
+	if n.pacing, err = newPacing(cfg.Settings, 0); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// bind checks that the path of the node stays in the data directory of the
+// engine running it
+func (n *replay) bind(e *Engine) error {
+	if e.opts.DataDir == "" {
+		return nil
+	}
+	dir, err := filepath.Abs(e.opts.DataDir)
+	if err != nil {
+		return fmt.Errorf("invalid data directory: %w", err)
+	}
+	n.dir = dir
+	if _, err := dataPath(n.dir, n.path); err != nil {
+		return fmt.Errorf("failed to create node %s: %w", n.Config.ID, err)
+	}
+	return nil
+}
+
+// Process implements types.Node.Process
+func (n *replay) Process(ctx context.Context, input types.Message) (types.Message, error) {
+	return input, nil
+}
+
+// Generate implements Source.Generate. The rows are sent once, or over and
+// over when the node loops, until count messages were sent if it is set.
+func (n *replay) Generate(ctx context.Context, emit func(types.Message) error) error {
+	if n.dir == "" {
+		return fmt.Errorf("%q nodes need a data directory", TypeReplay)
+	}
+	rows, err := newCSVRows(n.dir, n.path)
+	if err != nil {
+		return err
+	}
+	defer func() { rows.close() }()
+
+	return n.pacing.run(ctx, func(seq int) (bool, error) {
+		row, err := rows.next()
+		if errors.Is(err, io.EOF) && n.loop && rows.read > 0 {
+			var again *csvRows
+			if again, err = newCSVRows(n.dir, n.path); err != nil {
+				return false, err
+			}
+			rows.close()
+			rows = again
+			row, err = rows.next()
+		}
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		msg, err := generated(n.Config.ID, seq, row)
+		if err != nil {
+			return false, err
+		}
+		return true, emit(msg)
+	})
+}
+
+// csvRows reads the rows of a CSV file as objects keyed by its header
+type csvRows struct {
+	file   *os.File
+	reader *csv.Reader
+	header []string
+	// read counts the rows read, so that empty files are not looped over
+	read int
+}
+
+func newCSVRows(dir, name string) (*csvRows, error) {
+	path, err := dataPath(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	// Links may lead out of the directory, so the file is checked again
+	// once they are followed
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replayed file: %w", err)
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open data directory: %w", err)
+	}
+	if !inDir(realDir, resolved) {
+		return nil, fmt.Errorf("%w: %s", ErrOutsideDataDir, name)
+	}
+	file, err := os.Open(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replayed file: %w", err)
+	}
+	reader := csv.NewReader(file)
+	header, err := reader.Read()
+	if err != nil && !errors.Is(err, io.EOF) {
+		file.Close()
+		return nil, fmt.Errorf("failed to read header of %s: %w", path, err)
+	}
+	return &csvRows{file: file, reader: reader, header: header}, nil
+}
+
+// next returns the next row, or io.EOF after the last one. Values that
+// parse as numbers or booleans become numbers and booleans.
+func (r *csvRows) next() (map[string]interface{}, error) {
+	if r.header == nil {
+		return nil, io.EOF
+	}
+	record, err := r.reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read row of %s: %w", r.file.Name(), err)
+	}
+	r.read++
+	row := make(map[string]interface{}, len(record))
+	for i, value := range record {
+		row[r.header[i]] = csvValue(value)
+	}
+	return row, nil
+}
+
+// close closes the file
+func (r *csvRows) close() {
+	r.file.Close()
+}
+
+// csvValue converts a CSV value to the JSON value it stands for
+func csvValue(value string) interface{} {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+	if b, err := strconv.ParseBool(value); err == nil {
+		return b
+	}
+	return value
+}
+
+// Sources returns the IDs of the nodes generating messages of their own,
+// in the order of the graph
+func (e *Engine) Sources() []string {
+	var ids []string
+	for _, gn := range e.graph.Nodes {
+		if _, ok := e.nodes[gn.Config.ID].(Source); ok {
+			ids = append(ids, gn.Config.ID)
+		}
+	}
+	return ids
+}
+
+// Simulate runs the sources of the started flow concurrently, passing each
+// message they generate through the flow from the source on, until every
+// source is done or ctx is. Failures of single messages are reported as
+// events and do not end the simulation; the returned error joins the
+// failures of the sources themselves. It returns ErrNoSources when the
+// flow has no sources. Pace, when set, is called before each message and
+// may hold it back, or fail it to end its source.
+func (e *Engine) Simulate(ctx context.Context, pace func(context.Context) error) error {
+	ids := e.Sources()
+	if len(ids) == 0 {
+		return fmt.Errorf("%w: flow %s", ErrNoSources, e.graph.FlowID)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(ids))
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			err := e.nodes[id].(Source).Generate(ctx, func(msg types.Message) error {
+				if pace != nil {
+					if err := pace(ctx); err != nil {
+						return err
+					}
+				}
+				e.runMu.Lock()
+				defer e.runMu.Unlock()
+				if err := e.drain(ctx, map[string][]pending{id: {{msg: msg}}}); err != nil && ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return nil
+			})
+			if err != nil && ctx.Err() == nil {
+				errs[i] = fmt.Errorf("source %s failed: %w", id, err)
+			}
+		}(i, id)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// dataPath returns name joined to dir, unless it leads out of dir
+func dataPath(dir, name string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("invalid data directory: %w", err)
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	path = filepath.Clean(path)
+	if !inDir(dir, path) {
+		return "", fmt.Errorf("%w: %s", ErrOutsideDataDir, name)
+	}
+	return path, nil
+}
+
+// inDir reports whether the clean, absolute path is dir or below it
+func inDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,477 @@
+package engine
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"flow-control/internal/parser"
+	"flow-control/internal/parser/ast"
+	"flow-control/internal/runtime/schema"
+	"flow-control/internal/types"
+)
+
+// ErrInvalidGraph is returned for flows whose nodes cannot be connected
+var ErrInvalidGraph = errors.New("invalid flow graph")
+
+// Graph is a flow's nodes and the connections between them
+type Graph struct {
+	// FlowID is the name of the flow
+	FlowID string
+	// Nodes are the flow's nodes in execution order: every node comes after
+	// the nodes it receives messages from
+	Nodes []*GraphNode
+	// Params are the parameters the flow declares, ordered by name
+	Params []Param
+	// Concurrency limits the runs of the flow at once
+	Concurrency Concurrency
+	// Compatibility is the mode the data types of connected ports are
+	// checked with, schema.CompatibilityBackward by default
+	Compatibility schema.CompatibilityMode
+}
+
+// GraphNode is a node of a graph
+type GraphNode struct {
+	// Config is the node's type and settings
+	Config types.NodeConfig
+	// From lists the nodes whose output the node receives. Entry nodes,
+	// which receive the flow's input, have none.
+	From []string
+	// FromPorts maps the nodes of From to the output port the node
+	// receives from; it receives their default output otherwise
+	FromPorts map[string]string
+	// FromMaps maps the nodes of From to the mapping reshaping the messages
+	// the node receives from them; they arrive unchanged otherwise
+	FromMaps map[string]*Mapping
+}
+
+// Load builds the graph of the flow called name in program, or of its only
+// flow when name is empty.
+//
+// A node receives the output of the nodes named by its "from" setting, a
+// name or a list of names. Without it, a node receives the output of the
+// node declared before it, so that nodes form a pipeline by default; the
+// first node receives the flow's input. Names followed by a dot and a port,
+// such as "check.quarantine", receive what the node sends on that output
+// port instead of its default output.
+//
+// Connections of the flow, such as `"read.out" -> "store.in"`, add to the
+// nodes the receiving node gets the output of, and keep it from receiving
+// the output of the node declared before it. Their ends name a node or a
+// node followed by a port: "out" stands for the default output of the
+// sending node and "in" for the input of the receiving one, which may also
+// be named after the inputs the node declares. A connection with a map
+// reshapes the messages crossing it, as described by Mapping.
+//
+// The ports a node declares in its inputs and outputs sections, such as
+// `outputs { out: { type: "order", version: "1.2.0" } }`, have the data
+// type their type names, at version 1.0 unless they give one. Connections
+// between ports that both have a data type are refused unless the types
+// are compatible under the "compatibility" setting of the flow: backward
+// (the default), forward, full or none, as schema.CheckCompatibility
+// describes.
+//
+// The "params" setting of the flow declares its parameters, which node
+// settings refer to as ${param.NAME}; references to undeclared parameters
+// are errors. Its "concurrency" setting limits the runs of the flow at once,
+// as described by Concurrency.
+func Load(program *ast.Program, name string) (*Graph, error) {
+	var flows []*ast.Flow
+	for _, stmt := range program.Statements {
+		if f, ok := stmt.(*ast.Flow); ok && (name == "" || f.Name.Value == name) {
+			flows = append(flows, f)
+		}
+	}
+	switch {
+	case len(flows) == 0 && name != "":
+		return nil, fmt.Errorf("%w: no flow named %q", ErrInvalidGraph, name)
+	case len(flows) == 0:
+		return nil, fmt.Errorf("%w: no flow defined", ErrInvalidGraph)
+	case len(flows) > 1:
+		return nil, fmt.Errorf("%w: several flows defined, choose one by name", ErrInvalidGraph)
+	}
+	flow := flows[0]
+
+	graph := &Graph{FlowID: flow.Name.Value, Compatibility: schema.CompatibilityBackward}
+	var declared []*GraphNode
+	var connections []*ast.Connection
+	byID := map[string]*GraphNode{}
+	for _, stmt := range flow.Body.Statements {
+		if c, ok := stmt.(*ast.Connection); ok {
+			connections = append(connections, c)
+			continue
+		}
+		if a, ok := stmt.(*ast.Assignment); ok && a.Name.Value == "params" {
+			params, err := loadParams(ast.Value(a.Value))
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidGraph, err)
+			}
+			graph.Params = params
+			continue
+		}
+		if a, ok := stmt.(*ast.Assignment); ok && a.Name.Value == "concurrency" {
+			concurrency, err := loadConcurrency(ast.Value(a.Value))
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidGraph, err)
+			}
+			graph.Concurrency = concurrency
+			continue
+		}
+		if a, ok := stmt.(*ast.Assignment); ok && a.Name.Value == "compatibility" {
+			raw, _ := ast.Value(a.Value).(string)
+			mode, err := schema.ParseCompatibilityMode(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidGraph, err)
+			}
+			graph.Compatibility = mode
+			continue
+		}
+		n, ok := stmt.(*ast.FlowNode)
+		if !ok {
+			continue
+		}
+		node, err := loadNode(n)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := byID[node.Config.ID]; dup {
+			return nil, fmt.Errorf("%w: node %q is defined twice", ErrInvalidGraph, node.Config.ID)
+		}
+		declared = append(declared, node)
+		byID[node.Config.ID] = node
+	}
+
+	resolvePorts(declared, byID)
+	for _, c := range connections {
+		if err := connect(c, byID, graph.Compatibility); err != nil {
+			return nil, fmt.Errorf("%w: connection %q -> %q: %v", ErrInvalidGraph, c.From.Value, c.To.Value, err)
+		}
+	}
+	for i, node := range declared {
+		if node.From == nil && i > 0 {
+			node.From = []string{declared[i-1].Config.ID}
+		}
+	}
+	if err := checkParamRefs(graph.Params, declared); err != nil {
+		return nil, err
+	}
+	order, err := sortNodes(declared, byID)
+	if err != nil {
+		return nil, err
+	}
+	graph.Nodes = order
+	return graph, nil
+}
+
+// checkParamRefs rejects node settings referring to parameters the flow
+// does not declare
+func checkParamRefs(params []Param, nodes []*GraphNode) error {
+	known := make(map[string]bool, len(params))
+	for _, p := range params {
+		known[p.Name] = true
+	}
+	for _, node := range nodes {
+		refs := map[string]bool{}
+		paramRefs(node.Config.Settings, refs)
+		var undeclared []string
+		for name := range refs {
+			if !known[name] {
+				undeclared = append(undeclared, name)
+			}
+		}
+		if len(undeclared) > 0 {
+			sort.Strings(undeclared)
+			return fmt.Errorf("%w: node %q refers to undeclared parameters %s", ErrInvalidGraph, node.Config.ID, strings.Join(undeclared, ", "))
+		}
+	}
+	return nil
+}
+
+// LoadSource parses Flow source and builds the graph of the flow called
+// name, as Load does. Syntax errors are reported as ErrInvalidGraph.
+func LoadSource(src, name string, log types.Logger) (*Graph, error) {
+	program, diagnostics := parser.Parse(src, log)
+	if len(diagnostics) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidGraph, diagnostics[0])
+	}
+	return Load(program, name)
+}
+
+// loadNode converts a node definition into a graph node
+func loadNode(n *ast.FlowNode) (*GraphNode, error) {
+	node := &GraphNode{
+		Config: types.NodeConfig{
+			ID:       n.Name.Value,
+			Settings: map[string]interface{}{},
+		},
+	}
+	for _, stmt := range n.Body.Statements {
+		switch s := stmt.(type) {
+		case *ast.Assignment:
+			value := ast.Value(s.Value)
+			switch s.Name.Value {
+			case "type":
+				node.Config.Type, _ = value.(string)
+			case "from":
+				from, err := nodeNames(value)
+				if err != nil {
+					return nil, fmt.Errorf("%w: node %q: %v", ErrInvalidGraph, node.Config.ID, err)
+				}
+				node.From = from
+			default:
+				node.Config.Settings[s.Name.Value] = value
+			}
+		case *ast.Config:
+			node.Config.Settings["config"] = blockValue(s.Body)
+		case *ast.Section:
+			node.Config.Settings[s.Token.Literal] = blockValue(s.Body)
+		}
+	}
+	if node.Config.Type == "" {
+		return nil, fmt.Errorf("%w: node %q has no type", ErrInvalidGraph, node.Config.ID)
+	}
+	var err error
+	if node.Config.InputPorts, err = loadPorts(node.Config.Settings["inputs"], types.PortDirectionInput); err != nil {
+		return nil, fmt.Errorf("%w: node %q: %v", ErrInvalidGraph, node.Config.ID, err)
+	}
+	if node.Config.OutputPorts, err = loadPorts(node.Config.Settings["outputs"], types.PortDirectionOutput); err != nil {
+		return nil, fmt.Errorf("%w: node %q: %v", ErrInvalidGraph, node.Config.ID, err)
+	}
+	return node, nil
+}
+
+// loadPorts converts an inputs or outputs section into the ports it
+// declares, ordered by name. Ports are declared by their type, or by an
+// object with type and version fields.
+func loadPorts(section interface{}, direction types.PortDirection) ([]types.PortConfig, error) {
+	fields, ok := section.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	ports := make([]types.PortConfig, 0, len(fields))
+	for name, value := range fields {
+		port := types.PortConfig{Name: name, Direction: direction}
+		declared := portSchema{version: "1.0"}
+		switch v := value.(type) {
+		case string:
+			declared.typ = v
+		case map[string]interface{}:
+			declared.typ, _ = v["type"].(string)
+			if version, ok := v["version"].(string); ok {
+				if _, err := schema.ParseVersion(version); err != nil {
+					return nil, fmt.Errorf("port %s: %v", name, err)
+				}
+				declared.version = version
+			}
+		}
+		if declared.typ != "" {
+			port.DataType = declared
+		}
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Name < ports[j].Name })
+	return ports, nil
+}
+
+// portSchema is the data type a port declares in Flow source. It names a
+// type and version for compatibility checks without validating messages.
+type portSchema struct {
+	typ     string
+	version string
+}
+
+func (s portSchema) Validate(interface{}) error { return nil }
+func (s portSchema) GetType() string            { return s.typ }
+func (s portSchema) GetVersion() string         { return s.version }
+
+// findPort returns the port called name of ports
+func findPort(ports []types.PortConfig, name string) (types.PortConfig, bool) {
+	for _, port := range ports {
+		if port.Name == name {
+			return port, true
+		}
+	}
+	return types.PortConfig{}, false
+}
+
+// nodeNames converts a "from" setting into a list of node names
+func nodeNames(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		names := make([]string, 0, len(v))
+		for _, name := range v {
+			s, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("from must name nodes")
+			}
+			names = append(names, s)
+		}
+		return names, nil
+	}
+	return nil, fmt.Errorf("from must name nodes")
+}
+
+// blockValue converts the fields of a block into a map
+func blockValue(block *ast.BlockStatement) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for _, stmt := range block.Statements {
+		if s, ok := stmt.(*ast.Assignment); ok {
+			fields[s.Name.Value] = ast.Value(s.Value)
+		}
+	}
+	return fields
+}
+
+// resolvePorts splits the "from" entries naming an output port of a node,
+// such as "check.quarantine", into the node and the port. Entries naming a
+// node are left alone, even when the name holds a dot.
+func resolvePorts(nodes []*GraphNode, byID map[string]*GraphNode) {
+	for _, node := range nodes {
+		for i, from := range node.From {
+			if _, ok := byID[from]; ok {
+				continue
+			}
+			dot := strings.LastIndex(from, ".")
+			if dot <= 0 {
+				continue
+			}
+			if _, ok := byID[from[:dot]]; !ok {
+				continue
+			}
+			if node.FromPorts == nil {
+				node.FromPorts = map[string]string{}
+			}
+			node.From[i] = from[:dot]
+			node.FromPorts[from[:dot]] = from[dot+1:]
+		}
+	}
+}
+
+// connect adds the connection c to the node it leads to, refusing it when
+// the data types of its ports are not compatible under mode
+func connect(c *ast.Connection, byID map[string]*GraphNode, mode schema.CompatibilityMode) error {
+	from, port, err := connectionEnd(c.From.Value, byID)
+	if err != nil {
+		return err
+	}
+	if port == "out" {
+		port = ""
+	}
+	to, input, err := connectionEnd(c.To.Value, byID)
+	if err != nil {
+		return err
+	}
+	if inputs, _ := to.Config.Settings["inputs"].(map[string]interface{}); input != "" && input != "in" && inputs[input] == nil {
+		return fmt.Errorf("node %q has no input %q", to.Config.ID, input)
+	}
+	for _, f := range to.From {
+		if f == from.Config.ID {
+			return fmt.Errorf("node %q already receives from node %q", to.Config.ID, f)
+		}
+	}
+	out, hasOut := findPort(from.Config.OutputPorts, cmp.Or(port, "out"))
+	in, hasIn := findPort(to.Config.InputPorts, cmp.Or(input, "in"))
+	if hasOut && hasIn && out.DataType != nil && in.DataType != nil {
+		if err := schema.CheckConnection(out, in, mode); err != nil {
+			return err
+		}
+	}
+
+	to.From = append(to.From, from.Config.ID)
+	if port != "" {
+		if to.FromPorts == nil {
+			to.FromPorts = map[string]string{}
+		}
+		to.FromPorts[from.Config.ID] = port
+	}
+	if c.Map != nil {
+		mapping, err := compileMapping(c.Map)
+		if err != nil {
+			return fmt.Errorf("invalid map: %w", err)
+		}
+		if to.FromMaps == nil {
+			to.FromMaps = map[string]*Mapping{}
+		}
+		to.FromMaps[from.Config.ID] = mapping
+	}
+	return nil
+}
+
+// connectionEnd returns the node an end of a connection names, and the port
+// following its name
+func connectionEnd(end string, byID map[string]*GraphNode) (*GraphNode, string, error) {
+	if node, ok := byID[end]; ok {
+		return node, "", nil
+	}
+	if dot := strings.LastIndex(end, "."); dot > 0 {
+		if node, ok := byID[end[:dot]]; ok {
+			return node, end[dot+1:], nil
+		}
+	}
+	return nil, "", fmt.Errorf("unknown node %q", end)
+}
+
+// sortNodes orders nodes so that every node comes after the nodes it
+// receives from, keeping declaration order where the connections allow
+func sortNodes(declared []*GraphNode, byID map[string]*GraphNode) ([]*GraphNode, error) {
+	pending := map[string]int{}
+	for _, node := range declared {
+		for _, from := range node.From {
+			if _, ok := byID[from]; !ok {
+				return nil, fmt.Errorf("%w: node %q receives from unknown node %q", ErrInvalidGraph, node.Config.ID, from)
+			}
+		}
+		pending[node.Config.ID] = len(node.From)
+	}
+
+	// Repeatedly take the first declared node whose inputs are all done
+	order := make([]*GraphNode, 0, len(declared))
+	done := map[string]bool{}
+	for len(order) < len(declared) {
+		var next *GraphNode
+		for _, node := range declared {
+			if !done[node.Config.ID] && pending[node.Config.ID] == 0 {
+				next = node
+				break
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("%w: nodes form a cycle", ErrInvalidGraph)
+		}
+		done[next.Config.ID] = true
+		order = append(order, next)
+		for _, other := range declared {
+			for _, from := range other.From {
+				if from == next.Config.ID {
+					pending[other.Config.ID]--
+				}
+			}
+		}
+	}
+	return order, nil
+}
+
+// Children returns the IDs of the nodes receiving the default output of
+// node id
+func (g *Graph) Children(id string) []string {
+	return g.PortChildren(id, "")
+}
+
+// PortChildren returns the IDs of the nodes receiving what node id sends on
+// port, "" being its default output
+func (g *Graph) PortChildren(id, port string) []string {
+	var children []string
+	for _, node := range g.Nodes {
+		for _, from := range node.From {
+			if from == id && node.FromPorts[from] == port {
+				children = append(children, node.Config.ID)
+				break
+			}
+		}
+	}
+	return children
+}
@@ -0,0 +1,277 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// maxLogLine is the longest line LogWriter logs as one entry; longer lines
+// are split
+const maxLogLine = 64 << 10
+
+// logged is implemented by the nodes embedding BaseNode, which the engine
+// gives a log of their own
+type logged interface {
+	bindLogs(log types.LogPort)
+}
+
+// nodeLog is the log of a node run by an engine. It writes the node's
+// entries to the engine's log, tagged with the flow, node and run IDs, and
+// passes them to Options.OnLog. The zero nodeLog discards entries.
+type nodeLog struct {
+	engine *Engine
+	nodeID string
+	fields types.Fields
+	// shared is the level and hooks of the log and the children derived
+	// from it
+	shared *nodeLogShared
+}
+
+// nodeLogShared is the state a node log shares with its children
+type nodeLogShared struct {
+	mu    sync.Mutex
+	level types.LogLevel
+	hooks []types.LogHook
+}
+
+var _ types.LogPort = (*nodeLog)(nil)
+
+// nodeLog returns the log of a node
+func (e *Engine) nodeLog(nodeID string) *nodeLog {
+	return &nodeLog{engine: e, nodeID: nodeID, shared: &nodeLogShared{level: types.LogLevelDebug}}
+}
+
+// Debug implements types.LogPort.Debug
+func (l *nodeLog) Debug(msg string, fields types.Fields) {
+	l.log(types.LogLevelDebug, msg, nil, fields)
+}
+
+// Info implements types.LogPort.Info
+func (l *nodeLog) Info(msg string, fields types.Fields) {
+	l.log(types.LogLevelInfo, msg, nil, fields)
+}
+
+// Warn implements types.LogPort.Warn
+func (l *nodeLog) Warn(msg string, fields types.Fields) {
+	l.log(types.LogLevelWarn, msg, nil, fields)
+}
+
+// Error implements types.LogPort.Error
+func (l *nodeLog) Error(msg string, err error, fields types.Fields) {
+	l.log(types.LogLevelError, msg, err, fields)
+}
+
+// SetLevel implements types.LogPort.SetLevel. Entries below level are
+// neither written nor captured.
+func (l *nodeLog) SetLevel(level types.LogLevel) {
+	if l.shared == nil {
+		return
+	}
+	l.shared.mu.Lock()
+	defer l.shared.mu.Unlock()
+	l.shared.level = level
+}
+
+// AddHook implements types.LogPort.AddHook
+func (l *nodeLog) AddHook(hook types.LogHook) {
+	if l.shared == nil {
+		return
+	}
+	l.shared.mu.Lock()
+	defer l.shared.mu.Unlock()
+	l.shared.hooks = append(l.shared.hooks, hook)
+}
+
+// WithContext implements types.LogPort.WithContext
+func (l *nodeLog) WithContext(ctx context.Context) types.LogPort {
+	return l
+}
+
+// WithFields implements types.LogPort.WithFields
+func (l *nodeLog) WithFields(fields types.Fields) types.LogPort {
+	child := *l
+	child.fields = make(types.Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		child.fields[k] = v
+	}
+	for k, v := range fields {
+		child.fields[k] = v
+	}
+	return &child
+}
+
+// log writes and captures an entry
+func (l *nodeLog) log(level types.LogLevel, msg string, err error, fields types.Fields) {
+	if l.engine == nil {
+		return
+	}
+	l.shared.mu.Lock()
+	enabled := levelRank(level) >= levelRank(l.shared.level)
+	hooks := l.shared.hooks
+	l.shared.mu.Unlock()
+	if !enabled {
+		return
+	}
+
+	e := l.engine
+	merged := make(types.Fields, len(l.fields)+len(fields)+3)
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	entry := types.LogEntry{Level: level, Message: msg, Fields: merged, Time: time.Now(), Error: err}
+	for _, hook := range hooks {
+		if hookWants(hook, level) {
+			_ = hook.Fire(entry)
+		}
+	}
+
+	if e.opts.OnLog != nil {
+		captured := types.NodeLog{
+			FlowID:    e.graph.FlowID,
+			NodeID:    l.nodeID,
+			RunID:     e.opts.RunID,
+			Level:     level,
+			Message:   msg,
+			Timestamp: entry.Time,
+		}
+		if len(merged) > 0 {
+			captured.Fields = merged
+		}
+		if err != nil {
+			captured.Error = err.Error()
+		}
+		e.emitMu.Lock()
+		e.opts.OnLog(captured)
+		e.emitMu.Unlock()
+	}
+
+	tagged := make(types.Fields, len(merged)+3)
+	for k, v := range merged {
+		tagged[k] = v
+	}
+	tagged["flow_id"] = e.graph.FlowID
+	tagged["node_id"] = l.nodeID
+	if e.opts.RunID != "" {
+		tagged["run_id"] = e.opts.RunID
+	}
+	switch level {
+	case types.LogLevelDebug:
+		e.log.Debug(msg, tagged)
+	case types.LogLevelInfo:
+		e.log.Info(msg, tagged)
+	case types.LogLevelWarn:
+		e.log.Warn(msg, tagged)
+	default:
+		e.log.Error(msg, err, tagged)
+	}
+}
+
+// levelRank orders log levels from the most verbose
+func levelRank(level types.LogLevel) int {
+	switch level {
+	case types.LogLevelDebug:
+		return 0
+	case types.LogLevelInfo:
+		return 1
+	case types.LogLevelWarn:
+		return 2
+	}
+	return 3
+}
+
+// hookWants reports whether a hook handles a level; hooks listing no
+// levels handle all of them
+func hookWants(hook types.LogHook, level types.LogLevel) bool {
+	levels := hook.Levels()
+	if len(levels) == 0 {
+		return true
+	}
+	for _, l := range levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// LogWriter returns a writer logging each line written to it as an entry
+// at level, with fields. It captures the output of the processes nodes
+// run, such as their stdout and stderr, in the node's log:
+//
+//	cmd.Stdout = LogWriter(n.GetLogs(), types.LogLevelInfo, nil)
+//	cmd.Stderr = LogWriter(n.GetLogs(), types.LogLevelWarn, nil)
+//
+// Close logs the last line if it was not terminated. Lines longer than
+// 64 KiB are split.
+func LogWriter(log types.LogPort, level types.LogLevel, fields types.Fields) io.WriteCloser {
+	return &logWriter{log: log, level: level, fields: fields}
+}
+
+// logWriter logs the lines written to it
+type logWriter struct {
+	log    types.LogPort
+	level  types.LogLevel
+	fields types.Fields
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+// Write implements io.Writer
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		end := bytes.IndexByte(w.partial, '\n')
+		if end < 0 {
+			if len(w.partial) >= maxLogLine {
+				end = maxLogLine
+			} else {
+				break
+			}
+		}
+		line := w.partial[:end]
+		if end < len(w.partial) && w.partial[end] == '\n' {
+			end++
+		}
+		w.write(string(bytes.TrimSuffix(line, []byte("\r"))))
+		w.partial = w.partial[end:]
+	}
+	// Do not keep the consumed lines alive
+	w.partial = append([]byte(nil), w.partial...)
+	return len(p), nil
+}
+
+// Close implements io.Closer
+func (w *logWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.write(string(w.partial))
+		w.partial = nil
+	}
+	return nil
+}
+
+// write logs a line. w.mu must be held.
+func (w *logWriter) write(line string) {
+	switch w.level {
+	case types.LogLevelDebug:
+		w.log.Debug(line, w.fields)
+	case types.LogLevelInfo:
+		w.log.Info(line, w.fields)
+	case types.LogLevelWarn:
+		w.log.Warn(line, w.fields)
+	default:
+		w.log.Error(line, nil, w.fields)
+	}
+}
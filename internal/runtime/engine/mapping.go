@@ -0,0 +1,175 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"flow-control/internal/parser/ast"
+	"flow-control/internal/types"
+)
+
+// Roots of the paths of a mapping
+const (
+	// MapPayload is the root of paths into the payload of a message
+	MapPayload = "payload"
+	// MapHeaders is the root of paths into the headers of a message
+	MapHeaders = "headers"
+)
+
+// Mapping reshapes the messages crossing a connection into a new JSON
+// payload. It is an object whose values are paths into the message, such as
+// payload.user.id or headers.tenant, literals, or nested objects and arrays
+// of them. Fields whose path leads nowhere are left out of the payload.
+type Mapping struct {
+	root *mapValue
+	// payload tells whether a path reads the payload, which is only decoded
+	// then
+	payload bool
+}
+
+// mapValue is one value of a mapping: a path, a constant, an object or an
+// array
+type mapValue struct {
+	path     []string
+	constant interface{}
+	fields   map[string]*mapValue
+	elements []*mapValue
+}
+
+// compileMapping compiles the map of a connection
+func compileMapping(object *ast.ObjectLiteral) (*Mapping, error) {
+	m := &Mapping{}
+	root, err := m.compile(object)
+	if err != nil {
+		return nil, err
+	}
+	m.root = root
+	return m, nil
+}
+
+func (m *Mapping) compile(expr ast.Expression) (*mapValue, error) {
+	switch e := expr.(type) {
+	case *ast.Path:
+		return m.compilePath(e.Parts)
+	case *ast.Identifier:
+		return m.compilePath([]string{e.Value})
+	case *ast.StringLiteral, *ast.NumberLiteral:
+		return &mapValue{constant: ast.Value(e)}, nil
+	case *ast.ObjectLiteral:
+		v := &mapValue{fields: make(map[string]*mapValue, len(e.Fields))}
+		for _, f := range e.Fields {
+			field, err := m.compile(f.Value)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", f.Name.Value, err)
+			}
+			v.fields[f.Name.Value] = field
+		}
+		return v, nil
+	case *ast.ArrayLiteral:
+		v := &mapValue{elements: make([]*mapValue, len(e.Elements))}
+		for i, el := range e.Elements {
+			element, err := m.compile(el)
+			if err != nil {
+				return nil, err
+			}
+			v.elements[i] = element
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported value %s", expr.String())
+}
+
+func (m *Mapping) compilePath(parts []string) (*mapValue, error) {
+	switch parts[0] {
+	case MapPayload:
+		m.payload = true
+	case MapHeaders:
+		if len(parts) > 2 {
+			return nil, fmt.Errorf("path %s goes past a header", strings.Join(parts, "."))
+		}
+	default:
+		return nil, fmt.Errorf("path %s must start with %s or %s; quote text meant as a string", strings.Join(parts, "."), MapPayload, MapHeaders)
+	}
+	return &mapValue{path: parts}, nil
+}
+
+// apply returns msg with its payload replaced by the mapped one. The
+// schema of msg no longer describes the payload, so it is dropped.
+func (m *Mapping) apply(msg types.Message) (types.Message, error) {
+	var payload interface{}
+	if m.payload && len(msg.Data) > 0 {
+		// Numbers are kept as written, so large integers keep their digits
+		dec := json.NewDecoder(bytes.NewReader(msg.Data))
+		dec.UseNumber()
+		if err := dec.Decode(&payload); err != nil {
+			return msg, fmt.Errorf("payload is not JSON: %w", err)
+		}
+	}
+	headers := make(map[string]interface{}, len(msg.Metadata.Headers))
+	for name, value := range msg.Metadata.Headers {
+		headers[name] = value
+	}
+
+	value, _ := m.root.eval(payload, headers)
+	data, err := json.Marshal(value)
+	if err != nil {
+		return msg, fmt.Errorf("failed to encode mapped payload: %w", err)
+	}
+	msg.Data = data
+	msg.Schema = nil
+	return msg, nil
+}
+
+// eval returns the value v maps the message to, and whether there is one
+func (v *mapValue) eval(payload interface{}, headers map[string]interface{}) (interface{}, bool) {
+	switch {
+	case v.path != nil:
+		var value interface{} = headers
+		if v.path[0] == MapPayload {
+			value = payload
+		}
+		return follow(value, v.path[1:])
+	case v.fields != nil:
+		object := make(map[string]interface{}, len(v.fields))
+		for name, field := range v.fields {
+			if value, ok := field.eval(payload, headers); ok {
+				object[name] = value
+			}
+		}
+		return object, true
+	case v.elements != nil:
+		// Missing elements are null, so the others keep their index
+		array := make([]interface{}, len(v.elements))
+		for i, element := range v.elements {
+			array[i], _ = element.eval(payload, headers)
+		}
+		return array, true
+	}
+	return v.constant, true
+}
+
+// follow follows path through the objects and arrays of value
+func follow(value interface{}, path []string) (interface{}, bool) {
+	for _, part := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[part]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
@@ -0,0 +1,255 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"flow-control/internal/runtime/script"
+	"flow-control/internal/types"
+)
+
+var (
+	// ErrDrop is returned by a node's Process method to discard a message
+	// without failing
+	ErrDrop = errors.New("message dropped")
+
+	// ErrUnknownNodeType is returned for node types missing from the registry
+	ErrUnknownNodeType = errors.New("unknown node type")
+)
+
+// Routed is returned by a node's Process method to send a message on one of
+// its named output ports instead of its default output. Nodes receive from
+// a port by naming it after the node in their "from" setting, as in
+// "check.quarantine".
+type Routed struct {
+	Port    string
+	Message types.Message
+	// Reason, if any, is reported with the node.routed event
+	Reason string
+}
+
+// Error implements the error interface
+func (r *Routed) Error() string {
+	return "message sent to port " + r.Port
+}
+
+// PortNode is implemented by nodes with named output ports besides their
+// default output, such as Validate
+type PortNode interface {
+	// Ports returns the names of the node's output ports
+	Ports() []string
+}
+
+// hasPort reports whether node has the named output port
+func hasPort(node types.Node, port string) bool {
+	ports, ok := node.(PortNode)
+	return ok && slices.Contains(ports.Ports(), port)
+}
+
+// Factory creates a node from its configuration
+type Factory func(cfg types.NodeConfig) (types.Node, error)
+
+// Registry maps node types to the factories creating them
+type Registry struct {
+	mu           sync.RWMutex
+	factories    map[string]Factory
+	descriptions map[string]description
+}
+
+// NewRegistry creates a registry holding the built-in node types
+func NewRegistry() *Registry {
+	r := &Registry{
+		factories:    make(map[string]Factory),
+		descriptions: make(map[string]description),
+	}
+	r.Register(TypePassthrough, newPassthrough)
+	r.Describe(TypePassthrough, "Forwards messages unchanged")
+	r.Register(TypeTransform, newTransform)
+	r.Describe(TypeTransform, "Sets and removes fields of JSON object messages",
+		Setting{Name: "set", Type: "object", Description: "Fields to set, mapped to their values"},
+		Setting{Name: "remove", Type: "array", Items: "string", Description: "Names of the fields to remove"},
+	)
+	r.Register(TypeFilter, newFilter)
+	r.Describe(TypeFilter, "Drops messages whose field does not match",
+		Setting{Name: "field", Type: "string", Required: true, Description: "Dotted path of the field to match, such as order.status"},
+		Setting{Name: "equals", Description: "Value the field must equal; the field only has to exist when left out"},
+	)
+	r.Register(TypeDedup, newDedup)
+	r.Describe(TypeDedup, "Drops messages whose content was seen within a window",
+		Setting{Name: "fields", Type: "array", Items: "string", Description: "Dotted paths of the fields identifying a message; the whole payload when left out"},
+		Setting{Name: "window_seconds", Type: "number", Description: "How long a message is remembered after it was first seen"},
+		Setting{Name: "window_count", Type: "number", Description: "How many of the latest messages are remembered; 10000 when neither window is set"},
+	)
+	r.Register(TypeSplit, newSplit)
+	r.Describe(TypeSplit, "Sends each element of a JSON array as its own message",
+		Setting{Name: "field", Type: "string", Description: "Dotted path of the array in JSON object messages; the whole message when left out"},
+	)
+	r.Register(TypeCollect, newCollect)
+	r.Describe(TypeCollect, "Reassembles the elements sent by a Split node into a JSON array",
+		Setting{Name: "on_incomplete", Type: "string", Enum: []interface{}{CollectEmit, CollectDrop}, Default: CollectEmit, Description: "Whether arrays missing elements dropped on the way are passed on or dropped"},
+	)
+	r.Register(TypeValidate, newValidate)
+	r.Describe(TypeValidate, "Passes on the messages matching a schema and sends the others on its quarantine port",
+		Setting{Name: "schema", Type: "string", Required: true, Description: "Type of the schema of the schema registry"},
+		Setting{Name: "version", Type: "string", Description: "Version of the schema; the latest when left out"},
+	)
+	r.Register(TypeEnrich, newEnrich)
+	r.Describe(TypeEnrich, "Sets a field of JSON object messages to the value a lookup source holds for another",
+		Setting{Name: "source", Type: "string", Required: true, Enum: []interface{}{EnrichStatic, EnrichHTTP, EnrichSQL}, Description: "Where values are looked up"},
+		Setting{Name: "key", Type: "string", Required: true, Description: "Dotted path of the field looked up, such as order.customer_id"},
+		Setting{Name: "into", Type: "string", Required: true, Description: "Dotted path of the field set to the value found"},
+		Setting{Name: "table", Type: "object", Description: "Keys mapped to their values, for the static source"},
+		Setting{Name: "url", Type: "string", Description: "URL of the JSON value of a key, in which {key} is replaced by the key, for the http source; it must start with a lookup URL the operator allowed"},
+		Setting{Name: "headers", Type: "object", Description: "Headers of the requests of the http source, with underscores in names standing for dashes"},
+		Setting{Name: "database", Type: "string", Description: "Name of the lookup database the operator configured, for the sql source"},
+		Setting{Name: "query", Type: "string", Description: "Query of the sql source, taking the key as its only argument; its first row is the value"},
+		Setting{Name: "timeout_seconds", Type: "number", Default: DefaultEnrichTimeout.Seconds(), Description: "How long a lookup may take"},
+		Setting{Name: "cache_ttl_seconds", Type: "number", Default: DefaultEnrichCacheTTL.Seconds(), Description: "How long values found are cached; 0 disables caching"},
+		Setting{Name: "negative_ttl_seconds", Type: "number", Default: 0, Description: "How long keys without a value are cached"},
+		Setting{Name: "cache_size", Type: "number", Default: DefaultEnrichCacheSize, Description: "How many keys are cached"},
+		Setting{Name: "on_miss", Type: "string", Enum: []interface{}{MissPass, MissDrop, MissError}, Default: MissPass, Description: "What happens to messages whose key is missing or has no value"},
+	)
+	r.Register(TypeScript, newScript)
+	r.Describe(TypeScript, "Runs a script on JSON messages, for logic too complex for the other node types",
+		Setting{Name: "source", Type: "string", Required: true, Description: "Script run on each message, reading and modifying the payload as msg and the headers as headers"},
+		Setting{Name: "max_steps", Type: "number", Default: script.DefaultMaxSteps, Description: "How many Starlark steps a script may run on a message"},
+		Setting{Name: "timeout_seconds", Type: "number", Default: script.DefaultTimeout.Seconds(), Description: "How long a script may run on a message"},
+	)
+	r.Register(TypeApproval, newApproval)
+	r.Describe(TypeApproval, "Holds each message until a person approves or rejects it, passing approved messages on and sending rejected ones on its rejected port",
+		Setting{Name: "title", Type: "string", Description: "What is approved, shown to approvers"},
+		Setting{Name: "timeout_seconds", Type: "number", Default: 0, Description: "How long a message awaits a decision before on_timeout decides it; 0 waits forever"},
+		Setting{Name: "on_timeout", Type: "string", Enum: []interface{}{ApprovalReject, ApprovalApprove}, Default: ApprovalReject, Description: "How messages are decided when they time out"},
+		Setting{Name: "escalate_after_seconds", Type: "number", Default: 0, Description: "How long a message awaits a decision before it is escalated; 0 never escalates"},
+		Setting{Name: "escalate_to", Type: "string", Description: "Who approvals are escalated to, reported in escalation events"},
+	)
+	r.Register(TypeGenerate, newGenerate)
+	r.Describe(TypeGenerate, "Sends random records of a schema when the flow is simulated, passing messages it receives on unchanged",
+		Setting{Name: "schema", Type: "string", Description: "Type of the schema of the schema registry records are drawn from"},
+		Setting{Name: "version", Type: "string", Description: "Version of the schema; the latest when left out"},
+		Setting{Name: "definition", Type: "object", Description: "Definition of the schema records are drawn from, in place of a schema of the registry"},
+		Setting{Name: "count", Type: "number", Default: DefaultGenerateCount, Description: "How many records are sent"},
+		generateSettings[0], generateSettings[1], generateSettings[2],
+	)
+	r.Register(TypeReplay, newReplay)
+	r.Describe(TypeReplay, "Sends the rows of a CSV file as JSON objects keyed by its header when the flow is simulated, passing messages it receives on unchanged",
+		Setting{Name: "path", Type: "string", Required: true, Description: "Path of the CSV file in the data directory, whose first row names the columns"},
+		Setting{Name: "loop", Type: "boolean", Default: false, Description: "Whether the rows are sent over and over"},
+		Setting{Name: "count", Type: "number", Description: "How many rows are sent at most; every row, or forever when looping, when left out"},
+		generateSettings[0], generateSettings[1], generateSettings[2],
+	)
+	return r
+}
+
+// generateSettings are the pacing settings Generate and Replay nodes share
+var generateSettings = []Setting{
+	{Name: "rate", Type: "number", Default: 0, Description: "How many messages are sent per second on average; 0 sends them as fast as the flow takes them"},
+	{Name: "arrivals", Type: "string", Enum: []interface{}{ArrivalFixed, ArrivalPoisson}, Default: ArrivalFixed, Description: "Whether messages arrive at even intervals or as a Poisson process"},
+	{Name: "seed", Type: "number", Description: "Seed of the random values, so that runs repeat them; random when left out"},
+}
+
+// Register adds a node type, replacing any factory registered for it
+func (r *Registry) Register(nodeType string, factory Factory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[nodeType] = factory
+}
+
+// Types returns the registered node types in alphabetical order
+func (r *Registry) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Create creates a node of cfg.Type. It returns ErrUnknownNodeType if the
+// type is not registered.
+func (r *Registry) Create(cfg types.NodeConfig) (types.Node, error) {
+	r.mu.RLock()
+	factory, ok := r.factories[cfg.Type]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownNodeType, cfg.Type)
+	}
+
+	node, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create node %s: %w", cfg.ID, err)
+	}
+	return node, nil
+}
+
+// MultiOutput is implemented by nodes turning one message into any number
+// of messages, such as Split. The engine calls ProcessMany in place of
+// Process; returning no messages does not count as dropping the input.
+type MultiOutput interface {
+	ProcessMany(ctx context.Context, input types.Message) ([]types.Message, error)
+}
+
+// Flusher is implemented by nodes holding messages back until others
+// arrive, such as Collect. Once a node processed every message an input of
+// the flow led to, the engine calls Flush and passes on the messages it
+// returns.
+type Flusher interface {
+	Flush(ctx context.Context) ([]types.Message, error)
+}
+
+// BaseNode implements the parts of types.Node that simple nodes have no use
+// for, so that they only need to implement Process
+type BaseNode struct {
+	Config types.NodeConfig
+	// logs is the log the engine running the node gives it
+	logs types.LogPort
+}
+
+// GetConfig implements types.Node.GetConfig
+func (n *BaseNode) GetConfig() types.NodeConfig { return n.Config }
+
+// SetConfig implements types.Node.SetConfig
+func (n *BaseNode) SetConfig(cfg types.NodeConfig) error {
+	n.Config = cfg
+	return nil
+}
+
+// GetMetadata implements types.Node.GetMetadata
+func (n *BaseNode) GetMetadata() types.NodeMetadata { return types.NodeMetadata{} }
+
+// GetMetrics implements types.Node.GetMetrics
+func (n *BaseNode) GetMetrics() types.MetricsPort { return nil }
+
+// GetLogs implements types.Node.GetLogs. The entries of nodes run by an
+// engine are tagged with the flow, node and run and captured for the run;
+// other nodes discard them.
+func (n *BaseNode) GetLogs() types.LogPort {
+	if n.logs == nil {
+		return &nodeLog{}
+	}
+	return n.logs
+}
+
+// bindLogs implements logged.bindLogs
+func (n *BaseNode) bindLogs(log types.LogPort) { n.logs = log }
+
+// GetTraces implements types.Node.GetTraces
+func (n *BaseNode) GetTraces() types.TracePort { return nil }
+
+// Init implements types.Node.Init
+func (n *BaseNode) Init(ctx context.Context) error { return nil }
+
+// Start implements types.Node.Start
+func (n *BaseNode) Start(ctx context.Context) error { return nil }
+
+// Stop implements types.Node.Stop
+func (n *BaseNode) Stop(ctx context.Context) error { return nil }
+
+// Reset implements types.Node.Reset
+func (n *BaseNode) Reset(ctx context.Context) error { return nil }
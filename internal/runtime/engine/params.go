@@ -0,0 +1,215 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Types of flow parameters
+const (
+	ParamString  = "string"
+	ParamNumber  = "number"
+	ParamBoolean = "boolean"
+)
+
+// ErrInvalidParams is returned for parameter values that do not match the
+// parameters a flow declares
+var ErrInvalidParams = errors.New("invalid flow parameters")
+
+// paramRef matches parameter references. Node settings refer to the
+// parameters of their flow as ${param.NAME}.
+var paramRef = regexp.MustCompile(`\$\{param\.([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// paramName matches the names of parameters
+var paramName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Param is a parameter a flow declares in its "params" setting, which maps
+// parameter names to their type, default and description:
+//
+//	params: {
+//	    endpoint: { type: "string", default: "https://api.example.com" }
+//	    token: { type: "string", description: "API token" }
+//	}
+//
+// Parameters without a default must be given a value.
+type Param struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Default     string `json:"default,omitempty"`
+	Required    bool   `json:"required"`
+}
+
+// loadParams converts the "params" setting of a flow into its parameters,
+// ordered by name
+func loadParams(value interface{}) ([]Param, error) {
+	decls, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("params must map parameter names to declarations")
+	}
+	params := make([]Param, 0, len(decls))
+	for name, v := range decls {
+		if !paramName.MatchString(name) {
+			return nil, fmt.Errorf("invalid parameter name %q", name)
+		}
+		decl, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("parameter %s must be declared as an object", name)
+		}
+		p := Param{Name: name, Type: ParamString, Required: true}
+		for key, setting := range decl {
+			switch key {
+			case "type":
+				p.Type, _ = setting.(string)
+				if p.Type != ParamString && p.Type != ParamNumber && p.Type != ParamBoolean {
+					return nil, fmt.Errorf("parameter %s: type must be %s, %s or %s", name, ParamString, ParamNumber, ParamBoolean)
+				}
+			case "description":
+				p.Description, _ = setting.(string)
+			case "default":
+				def, ok := paramString(setting)
+				if !ok {
+					return nil, fmt.Errorf("parameter %s: default must be a string, number or boolean", name)
+				}
+				p.Default, p.Required = def, false
+			default:
+				return nil, fmt.Errorf("parameter %s: unknown setting %s", name, key)
+			}
+		}
+		if !p.Required {
+			if _, err := p.value(p.Default); err != nil {
+				return nil, fmt.Errorf("parameter %s: invalid default: %w", name, err)
+			}
+		}
+		params = append(params, p)
+	}
+	sort.Slice(params, func(i, j int) bool { return params[i].Name < params[j].Name })
+	return params, nil
+}
+
+// paramString converts a setting value into a parameter value
+func paramString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
+
+// value converts a value of the parameter into the setting it stands for
+// when a setting is nothing but a reference to the parameter
+func (p Param) value(s string) (interface{}, error) {
+	switch p.Type {
+	case ParamNumber:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", s)
+		}
+		return n, nil
+	case ParamBoolean:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", s)
+		}
+		return b, nil
+	default:
+		return s, nil
+	}
+}
+
+// ResolveParams checks values against the parameters of a flow and returns
+// the value of every parameter, taking defaults for those left out. It
+// fails with ErrInvalidParams for unknown parameters, missing required ones
+// and values not of their parameter's type.
+func ResolveParams(params []Param, values map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(params))
+	for _, p := range params {
+		value, ok := values[p.Name]
+		if !ok {
+			if p.Required {
+				return nil, fmt.Errorf("%w: %s is required", ErrInvalidParams, p.Name)
+			}
+			value = p.Default
+		}
+		if _, err := p.value(value); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidParams, p.Name, err)
+		}
+		resolved[p.Name] = value
+	}
+	var unknown []string
+	for name := range values {
+		if _, ok := resolved[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("%w: unknown parameters %s", ErrInvalidParams, strings.Join(unknown, ", "))
+	}
+	return resolved, nil
+}
+
+// paramRefs returns the names of the parameters value refers to, which may
+// nest maps and slices as node settings do
+func paramRefs(value interface{}, names map[string]bool) {
+	switch v := value.(type) {
+	case string:
+		for _, match := range paramRef.FindAllStringSubmatch(v, -1) {
+			names[match[1]] = true
+		}
+	case map[string]interface{}:
+		for _, child := range v {
+			paramRefs(child, names)
+		}
+	case []interface{}:
+		for _, child := range v {
+			paramRefs(child, names)
+		}
+	}
+}
+
+// bindParams replaces the parameter references in value with the values of
+// the parameters, returning a copy. A string holding nothing but one
+// reference becomes the value with the type of its parameter, so that
+// numbers and booleans keep their type.
+func bindParams(value interface{}, params []Param, values map[string]string) interface{} {
+	switch v := value.(type) {
+	case string:
+		if match := paramRef.FindStringSubmatch(v); match != nil && match[0] == v {
+			for _, p := range params {
+				if p.Name == match[1] {
+					typed, err := p.value(values[p.Name])
+					if err == nil {
+						return typed
+					}
+				}
+			}
+		}
+		return paramRef.ReplaceAllStringFunc(v, func(ref string) string {
+			return values[paramRef.FindStringSubmatch(ref)[1]]
+		})
+	case map[string]interface{}:
+		bound := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			bound[key] = bindParams(child, params, values)
+		}
+		return bound
+	case []interface{}:
+		bound := make([]interface{}, len(v))
+		for i, child := range v {
+			bound[i] = bindParams(child, params, values)
+		}
+		return bound
+	default:
+		return value
+	}
+}
@@ -0,0 +1,191 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"flow-control/internal/types"
+)
+
+// Event types reported when nodes are paused and resumed
+const (
+	TypeNodePaused  = "node.paused"
+	TypeNodeResumed = "node.resumed"
+)
+
+// ErrUnknownNode is returned for nodes the flow does not have
+var ErrUnknownNode = errors.New("unknown node")
+
+// NodeStatus is the state of a node of a running flow
+type NodeStatus struct {
+	ID string `json:"id"`
+	// State is ResourceStateThrottled while the node is paused, and
+	// ResourceStateRunning otherwise
+	State types.ResourceState `json:"state"`
+	// Buffered is the number of messages waiting for the node to resume
+	Buffered int `json:"buffered"`
+}
+
+// holds counts what keeps messages given to an engine from going through,
+// paused nodes, messages awaiting approval and messages stopped at
+// breakpoints
+type holds struct {
+	mu    sync.Mutex
+	count int
+	// idle is closed while count is zero
+	idle chan struct{}
+}
+
+func newHolds() *holds {
+	idle := make(chan struct{})
+	close(idle)
+	return &holds{idle: idle}
+}
+
+// add counts a new hold
+func (h *holds) add() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		h.idle = make(chan struct{})
+	}
+	h.count++
+}
+
+// done counts a hold as released
+func (h *holds) done() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.count--
+	if h.count == 0 {
+		close(h.idle)
+	}
+}
+
+// pauses holds the paused nodes of an engine and the messages waiting for
+// them
+type pauses struct {
+	mu    sync.Mutex
+	nodes map[string][]pending
+}
+
+func newPauses() *pauses {
+	return &pauses{nodes: map[string][]pending{}}
+}
+
+// hold buffers waiting, the messages reaching node, if the node is paused
+func (p *pauses) hold(node string, waiting []pending) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	buffered, ok := p.nodes[node]
+	if ok {
+		p.nodes[node] = append(buffered, waiting...)
+	}
+	return ok
+}
+
+// Pause stops the node with id from processing messages without stopping
+// the flow. The messages reaching the node are buffered until Resume, and
+// the nodes after it receive nothing from it in the meantime. Pausing a
+// paused node does nothing.
+func (e *Engine) Pause(id string) error {
+	if _, ok := e.nodes[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownNode, id)
+	}
+	e.paused.mu.Lock()
+	if _, ok := e.paused.nodes[id]; ok {
+		e.paused.mu.Unlock()
+		return nil
+	}
+	e.paused.nodes[id] = nil
+	e.paused.mu.Unlock()
+	e.holds.add()
+
+	e.emit(types.FlowEvent{NodeID: id, Type: TypeNodePaused, Message: "Node paused"})
+	return nil
+}
+
+// Resume lets the paused node with id process messages again, passing the
+// messages buffered while it was paused through it and the nodes after it,
+// in the order they arrived, before returning. Resuming a node that is not
+// paused does nothing. Like Process, the returned error joins the
+// failures of nodes.
+func (e *Engine) Resume(ctx context.Context, id string) error {
+	if _, ok := e.nodes[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownNode, id)
+	}
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
+	e.paused.mu.Lock()
+	buffered, ok := e.paused.nodes[id]
+	if !ok {
+		e.paused.mu.Unlock()
+		return nil
+	}
+	delete(e.paused.nodes, id)
+	e.paused.mu.Unlock()
+	// The buffered messages are held until they went through
+	defer e.holds.done()
+
+	e.emit(types.FlowEvent{
+		NodeID:  id,
+		Type:    TypeNodeResumed,
+		Message: "Node resumed",
+		Data:    map[string]interface{}{"buffered": len(buffered)},
+	})
+	return e.drain(ctx, map[string][]pending{id: buffered})
+}
+
+// NodeStatuses returns the state of every node, in the order of the graph
+func (e *Engine) NodeStatuses() []NodeStatus {
+	e.paused.mu.Lock()
+	defer e.paused.mu.Unlock()
+	statuses := make([]NodeStatus, len(e.graph.Nodes))
+	for i, gn := range e.graph.Nodes {
+		statuses[i] = NodeStatus{ID: gn.Config.ID, State: types.ResourceStateRunning}
+		if buffered, ok := e.paused.nodes[gn.Config.ID]; ok {
+			statuses[i].State = types.ResourceStateThrottled
+			statuses[i].Buffered = len(buffered)
+		}
+	}
+	return statuses
+}
+
+// WaitIdle waits until no node is paused, no message awaits approval and
+// none is stopped at a breakpoint, so that every message the flow was
+// given went through, or until ctx is done
+func (e *Engine) WaitIdle(ctx context.Context) error {
+	e.holds.mu.Lock()
+	idle := e.holds.idle
+	e.holds.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// dropPaused drops the messages buffered for paused nodes when the flow
+// stops
+func (e *Engine) dropPaused() {
+	e.paused.mu.Lock()
+	defer e.paused.mu.Unlock()
+	for id, buffered := range e.paused.nodes {
+		if len(buffered) > 0 {
+			e.log.Warn("Dropping messages buffered for paused node", types.Fields{
+				"function": "Stop",
+				"flow_id":  e.graph.FlowID,
+				"node_id":  id,
+				"count":    len(buffered),
+			})
+		}
+		for _, p := range buffered {
+			e.ack(p)
+		}
+		e.paused.nodes[id] = nil
+	}
+}
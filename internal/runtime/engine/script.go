@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"flow-control/internal/runtime/script"
+	"flow-control/internal/types"
+)
+
+// TypeScript runs the Starlark script of its "source" setting on JSON
+// messages, for logic too complex for the other node types; see package
+// script. Scripts read and modify the payload as the variable msg and the
+// headers as the variable headers. The messages a script emits are passed
+// on in place of its input; when it emits none, msg is passed on unless the
+// script drops it. The "max_steps" and "timeout_seconds" settings bound how
+// long a script runs on each message.
+const TypeScript = "Script"
+
+// scriptNode runs a script on each message
+type scriptNode struct {
+	BaseNode
+	script *script.Script
+	limits script.Limits
+}
+
+func newScript(cfg types.NodeConfig) (types.Node, error) {
+	src, _ := cfg.Settings["source"].(string)
+	if src == "" {
+		return nil, fmt.Errorf("source must be a script")
+	}
+	compiled, err := script.Compile(src, "msg", "headers")
+	if err != nil {
+		return nil, fmt.Errorf("invalid script: %w", err)
+	}
+	steps, err := numberSetting(cfg.Settings, "max_steps", script.DefaultMaxSteps)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := numberSetting(cfg.Settings, "timeout_seconds", script.DefaultTimeout.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	return &scriptNode{
+		BaseNode: BaseNode{Config: cfg},
+		script:   compiled,
+		limits:   script.Limits{MaxSteps: int(steps), Timeout: seconds(timeout)},
+	}, nil
+}
+
+// Process implements types.Node.Process. It passes on the first message
+// emitted only; the engine calls ProcessMany instead.
+func (n *scriptNode) Process(ctx context.Context, input types.Message) (types.Message, error) {
+	outs, err := n.ProcessMany(ctx, input)
+	if err != nil {
+		return types.Message{}, err
+	}
+	if len(outs) == 0 {
+		return types.Message{}, ErrDrop
+	}
+	return outs[0], nil
+}
+
+// ProcessMany implements MultiOutput.ProcessMany
+func (n *scriptNode) ProcessMany(ctx context.Context, input types.Message) ([]types.Message, error) {
+	var msg interface{}
+	if err := json.Unmarshal(input.Data, &msg); err != nil {
+		return nil, fmt.Errorf("script needs a JSON message")
+	}
+	headers := make(map[string]interface{}, len(input.Metadata.Headers))
+	for name, value := range input.Metadata.Headers {
+		headers[name] = value
+	}
+
+	started := time.Now()
+	res, err := n.script.Run(ctx, map[string]interface{}{"msg": msg, "headers": headers}, n.limits)
+	for _, line := range res.Logs {
+		n.GetLogs().Info(line, types.Fields{"message_id": input.ID})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("script failed after %s: %w", time.Since(started).Round(time.Millisecond), err)
+	}
+
+	out := input
+	out.Metadata.Headers = make(map[string]string)
+	if fields, ok := res.Vars["headers"].(map[string]interface{}); ok {
+		for name, value := range fields {
+			if s, ok := value.(string); ok {
+				out.Metadata.Headers[name] = s
+				continue
+			}
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode header %s: %w", name, err)
+			}
+			out.Metadata.Headers[name] = string(encoded)
+		}
+	}
+
+	payloads := res.Emitted
+	if len(payloads) == 0 {
+		if res.Dropped {
+			return nil, nil
+		}
+		payloads = []interface{}{res.Vars["msg"]}
+	}
+	outs := make([]types.Message, 0, len(payloads))
+	for i, payload := range payloads {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode script output: %w", err)
+		}
+		emitted := out
+		emitted.Data = data
+		if len(res.Emitted) > 1 {
+			emitted.ID = fmt.Sprintf("%s.%d", input.ID, i)
+		}
+		outs = append(outs, emitted)
+	}
+	return outs, nil
+}
@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"flow-control/internal/compression"
+	"flow-control/internal/metrics"
+	"flow-control/internal/types"
+)
+
+// Spill moves the payloads of large messages to temporary files while they
+// wait for the nodes receiving them, so that large messages fanned out to
+// many branches are not all held in memory. Nodes still receive the whole
+// payload when they process a message.
+type Spill struct {
+	// MaxInMemory is the largest payload, in bytes, kept in memory while it
+	// waits for nodes; zero keeps every payload in memory
+	MaxInMemory int
+	// Dir is the directory of the temporary files; os.TempDir() when empty
+	Dir string
+	// Compression is the codec compressing the temporary files, one of
+	// compression.Codecs; payloads are written as they are when empty
+	Compression string
+	// Metrics, when set, records the spilled messages and bytes in place of
+	// Options.Metrics
+	Metrics types.MetricsPort
+}
+
+// Validate checks that the limit is not negative and the codec supported
+func (s Spill) Validate() error {
+	if s.MaxInMemory < 0 {
+		return fmt.Errorf("invalid max in-memory payload size %d: cannot be negative", s.MaxInMemory)
+	}
+	return compression.Validate(s.Compression)
+}
+
+// payload is the data of a message spilled to a temporary file. Each node
+// the message waits for holds a reference to it, which the node gives up
+// once it processed the message; the file is removed with the last one.
+type payload struct {
+	path string
+	// size is the size of the payload and stored the size of its file
+	size   int
+	stored int64
+	refs   int
+	// node is the node whose output the payload is
+	node string
+}
+
+// pending is a message waiting for a node, referring to its payload by
+// handle when it was spilled
+type pending struct {
+	msg     types.Message
+	spilled *payload
+	// step stops the message before the node it waits for, as the message
+	// is stepped through the flow
+	step bool
+}
+
+// enqueue prepares msg to wait for receivers nodes, spilling its payload
+// when it is over the limit. Payloads that cannot be spilled stay in
+// memory.
+func (e *Engine) enqueue(nodeID string, msg types.Message, receivers int) pending {
+	limit := e.opts.Spill.MaxInMemory
+	if limit <= 0 || len(msg.Data) <= limit || receivers == 0 {
+		return pending{msg: msg}
+	}
+
+	stored, path, err := e.writeSpill(msg.Data)
+	if err != nil {
+		e.log.Warn("Failed to spill payload, keeping it in memory", types.Fields{
+			"function":   "enqueue",
+			"flow_id":    e.graph.FlowID,
+			"message_id": msg.ID,
+			"error":      err.Error(),
+		})
+		return pending{msg: msg}
+	}
+
+	p := &payload{path: path, size: len(msg.Data), stored: stored, refs: receivers, node: nodeID}
+	msg.Data = nil
+	if port := e.spillMetrics(); port != nil {
+		labels := e.spillLabels(p)
+		port.Inc(metrics.FlowSpilledMessagesMetric, 1, labels)
+		port.Inc(metrics.FlowSpilledBytesMetric, float64(p.size), labels)
+		port.Inc(metrics.FlowSpillBytesMetric, float64(p.stored), labels)
+		compression.Record(port, e.graph.FlowID, "spill", e.opts.Spill.Compression, int64(p.size), p.stored)
+	}
+	return pending{msg: msg, spilled: p}
+}
+
+// writeSpill writes data to a new temporary file, compressed with the
+// spill codec, and returns the size and path of the file
+func (e *Engine) writeSpill(data []byte) (int64, string, error) {
+	f, err := os.CreateTemp(e.opts.Spill.Dir, "flowcontrol-spill-*")
+	if err != nil {
+		return 0, "", err
+	}
+	w, err := compression.NewWriter(e.opts.Spill.Compression, f)
+	if err == nil {
+		_, err = w.Write(data)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	}
+	var stored int64
+	if err == nil {
+		stored, err = f.Seek(0, io.SeekCurrent)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return 0, "", err
+	}
+	return stored, f.Name(), nil
+}
+
+// receive returns the message of p with its payload
+func (e *Engine) receive(p pending) (types.Message, error) {
+	if p.spilled == nil {
+		return p.msg, nil
+	}
+	stored, err := os.ReadFile(p.spilled.path)
+	if err != nil {
+		return types.Message{}, fmt.Errorf("failed to read spilled payload: %w", err)
+	}
+	data, err := compression.Decode(e.opts.Spill.Compression, stored)
+	if err != nil {
+		return types.Message{}, fmt.Errorf("failed to read spilled payload: %w", err)
+	}
+	msg := p.msg
+	msg.Data = data
+	return msg, nil
+}
+
+// ack gives up the reference of a node to the payload of p once the node
+// is done with it, removing the payload's file with the last reference
+func (e *Engine) ack(p pending) {
+	if p.spilled == nil {
+		return
+	}
+	p.spilled.refs--
+	if p.spilled.refs > 0 {
+		return
+	}
+	if err := os.Remove(p.spilled.path); err != nil {
+		e.log.Warn("Failed to remove spilled payload", types.Fields{
+			"function": "ack",
+			"flow_id":  e.graph.FlowID,
+			"path":     p.spilled.path,
+			"error":    err.Error(),
+		})
+	}
+	if port := e.spillMetrics(); port != nil {
+		port.Dec(metrics.FlowSpillBytesMetric, float64(p.spilled.stored), e.spillLabels(p.spilled))
+	}
+}
+
+// spillMetrics returns where spilled payloads are recorded, or nil
+func (e *Engine) spillMetrics() types.MetricsPort {
+	if e.opts.Spill.Metrics != nil {
+		return e.opts.Spill.Metrics
+	}
+	return e.opts.Metrics
+}
+
+// spillLabels returns the metric labels of p
+func (e *Engine) spillLabels(p *payload) map[string]string {
+	return map[string]string{"flow_id": e.graph.FlowID, "node_id": p.node}
+}
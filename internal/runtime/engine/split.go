@@ -0,0 +1,254 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"flow-control/internal/types"
+)
+
+// TypeSplit turns a message holding a JSON array into one message per
+// element, so that the nodes after it process the elements one at a time.
+// Its "field" setting is the dotted path of the array in JSON object
+// messages; the whole message is the array when it is not set.
+const TypeSplit = "Split"
+
+// TypeCollect reassembles the elements a Split node sent into one message
+// holding them as a JSON array, in their original order. Elements dropped
+// on the way are left out: once every element the input led to reached the
+// node, incomplete arrays are passed on, or dropped when its "on_incomplete"
+// setting is CollectDrop.
+const TypeCollect = "Collect"
+
+// Policies of Collect nodes for incomplete arrays
+const (
+	CollectEmit = "emit"
+	CollectDrop = "drop"
+)
+
+// Headers of the messages Split nodes send
+const (
+	// HeaderCorrelationID is the ID of the message the elements come from,
+	// or the correlation ID that message already had
+	HeaderCorrelationID = "correlation_id"
+	// HeaderSplitID is the ID of the split message, which Collect nodes
+	// group elements by
+	HeaderSplitID = "split_id"
+	// HeaderSplitIndex is the position of the element in the array
+	HeaderSplitIndex = "split_index"
+	// HeaderSplitCount is the length of the array
+	HeaderSplitCount = "split_count"
+)
+
+// split sends each element of an array as its own message
+type split struct {
+	BaseNode
+	path []string
+}
+
+func newSplit(cfg types.NodeConfig) (types.Node, error) {
+	n := &split{BaseNode: BaseNode{Config: cfg}}
+	if field, ok := cfg.Settings["field"]; ok {
+		s, _ := field.(string)
+		if s == "" {
+			return nil, fmt.Errorf("field must be a dotted path")
+		}
+		n.path = strings.Split(s, ".")
+	}
+	return n, nil
+}
+
+// Process implements types.Node.Process. It passes on the first element
+// only; the engine calls ProcessMany instead.
+func (n *split) Process(ctx context.Context, input types.Message) (types.Message, error) {
+	outs, err := n.ProcessMany(ctx, input)
+	if err != nil {
+		return types.Message{}, err
+	}
+	if len(outs) == 0 {
+		return types.Message{}, ErrDrop
+	}
+	return outs[0], nil
+}
+
+// ProcessMany implements MultiOutput.ProcessMany
+func (n *split) ProcessMany(ctx context.Context, input types.Message) ([]types.Message, error) {
+	var value interface{}
+	if err := json.Unmarshal(input.Data, &value); err != nil {
+		return nil, fmt.Errorf("split needs a JSON array message")
+	}
+	if n.path != nil {
+		value = lookup(value, n.path)
+	}
+	elements, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("split needs a JSON array to split")
+	}
+
+	correlationID := input.Metadata.Headers[HeaderCorrelationID]
+	if correlationID == "" {
+		correlationID = input.ID
+	}
+	outs := make([]types.Message, 0, len(elements))
+	for i, element := range elements {
+		data, err := json.Marshal(element)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode element %d: %w", i, err)
+		}
+		out := input
+		out.ID = fmt.Sprintf("%s.%d", input.ID, i)
+		out.Data = data
+		out.Metadata.Headers = make(map[string]string, len(input.Metadata.Headers)+4)
+		for name, value := range input.Metadata.Headers {
+			out.Metadata.Headers[name] = value
+		}
+		out.Metadata.Headers[HeaderCorrelationID] = correlationID
+		out.Metadata.Headers[HeaderSplitID] = input.ID
+		out.Metadata.Headers[HeaderSplitIndex] = strconv.Itoa(i)
+		out.Metadata.Headers[HeaderSplitCount] = strconv.Itoa(len(elements))
+		outs = append(outs, out)
+	}
+	return outs, nil
+}
+
+// collect gathers the elements of split messages back into arrays
+type collect struct {
+	BaseNode
+	dropIncomplete bool
+
+	mu sync.Mutex
+	// groups maps split IDs to the elements received so far, in the order
+	// the first element of each arrived
+	groups map[string]*collectGroup
+	order  []string
+}
+
+// collectGroup is the elements of a split message received by a Collect
+// node
+type collectGroup struct {
+	first    types.Message
+	count    int
+	elements map[int]json.RawMessage
+}
+
+func newCollect(cfg types.NodeConfig) (types.Node, error) {
+	n := &collect{
+		BaseNode: BaseNode{Config: cfg},
+		groups:   make(map[string]*collectGroup),
+	}
+	if policy, ok := cfg.Settings["on_incomplete"]; ok {
+		switch policy {
+		case CollectEmit:
+		case CollectDrop:
+			n.dropIncomplete = true
+		default:
+			return nil, fmt.Errorf("on_incomplete must be %s or %s", CollectEmit, CollectDrop)
+		}
+	}
+	return n, nil
+}
+
+// Process implements types.Node.Process. It drops the elements that do not
+// complete an array; the engine calls ProcessMany instead.
+func (n *collect) Process(ctx context.Context, input types.Message) (types.Message, error) {
+	outs, err := n.ProcessMany(ctx, input)
+	if err != nil {
+		return types.Message{}, err
+	}
+	if len(outs) == 0 {
+		return types.Message{}, ErrDrop
+	}
+	return outs[0], nil
+}
+
+// ProcessMany implements MultiOutput.ProcessMany. It returns the array the
+// element completes, if any.
+func (n *collect) ProcessMany(ctx context.Context, input types.Message) ([]types.Message, error) {
+	headers := input.Metadata.Headers
+	id := headers[HeaderSplitID]
+	index, indexErr := strconv.Atoi(headers[HeaderSplitIndex])
+	count, countErr := strconv.Atoi(headers[HeaderSplitCount])
+	if id == "" || indexErr != nil || countErr != nil || index < 0 || index >= count {
+		return nil, fmt.Errorf("collect needs the elements sent by a Split node")
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	group, ok := n.groups[id]
+	if !ok {
+		group = &collectGroup{first: input, count: count, elements: make(map[int]json.RawMessage)}
+		n.groups[id] = group
+		n.order = append(n.order, id)
+	}
+	group.elements[index] = input.Data
+	if len(group.elements) < group.count {
+		return nil, nil
+	}
+	out, err := n.assemble(id, group)
+	if err != nil {
+		return nil, err
+	}
+	return []types.Message{out}, nil
+}
+
+// Flush implements Flusher.Flush, passing on or dropping the arrays still
+// missing elements
+func (n *collect) Flush(ctx context.Context) ([]types.Message, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var outs []types.Message
+	for _, id := range n.order {
+		group, ok := n.groups[id]
+		if !ok {
+			continue
+		}
+		if n.dropIncomplete {
+			delete(n.groups, id)
+			continue
+		}
+		out, err := n.assemble(id, group)
+		if err != nil {
+			return outs, err
+		}
+		outs = append(outs, out)
+	}
+	n.order = nil
+	return outs, nil
+}
+
+// assemble returns the message holding the elements of group, which it
+// forgets. n.mu must be held.
+func (n *collect) assemble(id string, group *collectGroup) (types.Message, error) {
+	delete(n.groups, id)
+	indexes := make([]int, 0, len(group.elements))
+	for index := range group.elements {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	elements := make([]json.RawMessage, len(indexes))
+	for i, index := range indexes {
+		elements[i] = group.elements[index]
+	}
+	data, err := json.Marshal(elements)
+	if err != nil {
+		return types.Message{}, fmt.Errorf("failed to encode collected elements: %w", err)
+	}
+
+	out := group.first
+	out.ID = id
+	out.Data = data
+	out.Metadata.Headers = make(map[string]string, len(group.first.Metadata.Headers))
+	for name, value := range group.first.Metadata.Headers {
+		switch name {
+		case HeaderSplitID, HeaderSplitIndex, HeaderSplitCount:
+		default:
+			out.Metadata.Headers[name] = value
+		}
+	}
+	return out, nil
+}
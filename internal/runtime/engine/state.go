@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"flow-control/internal/types"
+)
+
+// StateStore persists the state of stateful nodes, so that it survives
+// restarts of their flow. States are opaque to the store.
+type StateStore interface {
+	// LoadNodeState returns the saved state of a node of a flow, or nil if
+	// it has none
+	LoadNodeState(flowID, nodeID string) ([]byte, error)
+	// SaveNodeState replaces the saved state of a node of a flow
+	SaveNodeState(flowID, nodeID string, state []byte) error
+}
+
+// Stateful is implemented by nodes keeping state across messages. With a
+// StateStore, the engine restores their state before initializing them and
+// saves it after every message they process; without one, their state only
+// lasts as long as the engine.
+type Stateful interface {
+	// RestoreState replaces the node's state with one it saved
+	RestoreState(state []byte) error
+	// SaveState returns the node's state
+	SaveState() ([]byte, error)
+}
+
+// restoreState restores the saved state of node with id, if it is stateful
+func (e *Engine) restoreState(id string, node types.Node) error {
+	stateful, ok := node.(Stateful)
+	if !ok || e.opts.State == nil {
+		return nil
+	}
+	state, err := e.opts.State.LoadNodeState(e.graph.FlowID, id)
+	if err != nil {
+		return fmt.Errorf("failed to load state of node %s: %w", id, err)
+	}
+	if state == nil {
+		return nil
+	}
+	if err := stateful.RestoreState(state); err != nil {
+		return fmt.Errorf("failed to restore state of node %s: %w", id, err)
+	}
+	return nil
+}
+
+// saveState saves the state of node with id, if it is stateful. Failures
+// are logged rather than failing the message, which was processed.
+func (e *Engine) saveState(id string, node types.Node) {
+	stateful, ok := node.(Stateful)
+	if !ok || e.opts.State == nil {
+		return
+	}
+	state, err := stateful.SaveState()
+	if err == nil {
+		err = e.opts.State.SaveNodeState(e.graph.FlowID, id, state)
+	}
+	if err != nil {
+		e.log.Error("Failed to save node state", err, types.Fields{
+			"function": "saveState",
+			"flow_id":  e.graph.FlowID,
+			"node_id":  id,
+		})
+	}
+}
+
+// DirStateStore is a StateStore keeping the state of each node in a file
+// of a directory, under a subdirectory per flow
+type DirStateStore struct {
+	dir string
+}
+
+// NewDirStateStore keeps states in dir, creating it if needed
+func NewDirStateStore(dir string) (*DirStateStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return &DirStateStore{dir: dir}, nil
+}
+
+// LoadNodeState implements StateStore.LoadNodeState
+func (s *DirStateStore) LoadNodeState(flowID, nodeID string) ([]byte, error) {
+	state, err := os.ReadFile(s.path(flowID, nodeID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load node state: %w", err)
+	}
+	return state, nil
+}
+
+// SaveNodeState implements StateStore.SaveNodeState. States are written to
+// a temporary file first, so that a crash never leaves one half written.
+func (s *DirStateStore) SaveNodeState(flowID, nodeID string, state []byte) error {
+	path := s.path(flowID, nodeID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to save node state: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, state, 0o600); err != nil {
+		return fmt.Errorf("failed to save node state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to save node state: %w", err)
+	}
+	return nil
+}
+
+// path returns the file holding the state of a node. IDs are escaped so
+// that they never reach outside the directory.
+func (s *DirStateStore) path(flowID, nodeID string) string {
+	flowDir := url.PathEscape(flowID)
+	if strings.Trim(flowDir, ".") == "" {
+		flowDir = strings.ReplaceAll(flowDir, ".", "%2E")
+	}
+	return filepath.Join(s.dir, flowDir, url.PathEscape(nodeID)+".state")
+}
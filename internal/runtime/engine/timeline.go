@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"encoding/json"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// MaxSnapshotBytes is the largest payload the snapshots of the timeline of
+// a run keep; larger payloads are recorded by their size alone
+const MaxSnapshotBytes = 64 << 10
+
+// recordHop passes the hop of in through node id to Options.OnHop: the
+// outputs it produced on port, the event reported for it and its error
+func (e *Engine) recordHop(id string, in types.Message, port string, outs []types.Message, event types.FlowEvent, err error, start, end time.Time) {
+	hop := types.MessageHop{
+		FlowID:     e.graph.FlowID,
+		NodeID:     id,
+		RunID:      e.opts.RunID,
+		Source:     in.Metadata.Source,
+		Input:      snapshot(in),
+		Port:       port,
+		Event:      event.Type,
+		StartedAt:  start,
+		FinishedAt: end,
+	}
+	if err == nil {
+		for _, out := range outs {
+			hop.Outputs = append(hop.Outputs, snapshot(out))
+		}
+	} else if event.Type == TypeNodeFailed {
+		hop.Error = err.Error()
+	}
+	e.emitMu.Lock()
+	defer e.emitMu.Unlock()
+	e.opts.OnHop(hop)
+}
+
+// snapshot copies msg for the timeline. Payloads that are not JSON are kept
+// as JSON strings.
+func snapshot(msg types.Message) types.MessageSnapshot {
+	snap := types.MessageSnapshot{ID: msg.ID, Size: len(msg.Data)}
+	if len(msg.Metadata.Headers) > 0 {
+		snap.Headers = make(map[string]string, len(msg.Metadata.Headers))
+		for name, value := range msg.Metadata.Headers {
+			snap.Headers[name] = value
+		}
+	}
+	switch {
+	case len(msg.Data) == 0 || len(msg.Data) > MaxSnapshotBytes:
+	case json.Valid(msg.Data):
+		snap.Data = append(json.RawMessage(nil), msg.Data...)
+	default:
+		snap.Data, _ = json.Marshal(string(msg.Data))
+	}
+	return snap
+}
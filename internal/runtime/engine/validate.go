@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"flow-control/internal/metrics"
+	"flow-control/internal/runtime/schema"
+	"flow-control/internal/types"
+)
+
+// TypeValidate checks messages against a schema of the schema registry.
+// Its "schema" setting names the schema type and its "version" setting the
+// version, the latest one when it is not set. Valid messages are passed on
+// and the others are sent on the PortQuarantine output port, as a
+// Quarantined object, instead of failing.
+const TypeValidate = "Validate"
+
+// PortQuarantine is the output port of the messages Validate nodes reject
+const PortQuarantine = "quarantine"
+
+// SchemaSource provides the schemas Validate nodes check messages against
+// and Generate nodes sample; *schema.SchemaRegistry implements it
+type SchemaSource interface {
+	Get(schemaType, version string) (types.Schema, error)
+	GetLatest(schemaType string) (types.Schema, error)
+	Validator(schemaType, version string) (schema.Validator, error)
+}
+
+// Quarantined is the payload of a message a Validate node rejected
+type Quarantined struct {
+	// Data is the rejected payload, as a JSON string when it is not JSON
+	Data json.RawMessage `json:"data"`
+	// Schema and Version identify the schema the payload does not match
+	Schema  string `json:"schema"`
+	Version string `json:"version"`
+	// Errors are the validation failures
+	Errors []*schema.FieldError `json:"errors"`
+}
+
+// validate passes on the messages matching a schema and quarantines the
+// others
+type validate struct {
+	BaseNode
+	schemaType string
+	version    string
+	check      schema.Validator
+	flowID     string
+	metrics    types.MetricsPort
+}
+
+func newValidate(cfg types.NodeConfig) (types.Node, error) {
+	n := &validate{BaseNode: BaseNode{Config: cfg}}
+	n.schemaType, _ = cfg.Settings["schema"].(string)
+	if n.schemaType == "" {
+		return nil, fmt.Errorf("schema must be set")
+	}
+	if version, ok := cfg.Settings["version"]; ok {
+		n.version, _ = version.(string)
+		if n.version == "" {
+			return nil, fmt.Errorf("version must be a schema version")
+		}
+	}
+	return n, nil
+}
+
+// bind gives the node the schemas and metrics of the engine running it
+func (n *validate) bind(e *Engine) error {
+	if e.opts.Schemas == nil {
+		return fmt.Errorf("%w: %q nodes need a schema registry", ErrUnknownNodeType, TypeValidate)
+	}
+	if n.version == "" {
+		latest, err := e.opts.Schemas.GetLatest(n.schemaType)
+		if err != nil {
+			return fmt.Errorf("failed to create node %s: %w", n.Config.ID, err)
+		}
+		n.version = latest.GetVersion()
+	}
+	check, err := e.opts.Schemas.Validator(n.schemaType, n.version)
+	if err != nil {
+		return fmt.Errorf("failed to create node %s: %w", n.Config.ID, err)
+	}
+	n.check = check
+	n.flowID = e.graph.FlowID
+	n.metrics = e.opts.Metrics
+	return nil
+}
+
+// Ports implements PortNode.Ports
+func (n *validate) Ports() []string {
+	return []string{PortQuarantine}
+}
+
+// Process implements types.Node.Process
+func (n *validate) Process(ctx context.Context, input types.Message) (types.Message, error) {
+	var failures []*schema.FieldError
+	data := input.Data
+	// Numbers are decoded as json.Number, so that integer schemas accept
+	// integral values
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(input.Data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		if err == nil {
+			err = fmt.Errorf("trailing data after the JSON value")
+		}
+		failures = []*schema.FieldError{{Message: "invalid JSON: " + err.Error()}}
+		data, _ = json.Marshal(string(input.Data))
+	} else if err := n.check(value); err != nil {
+		var validationErr *schema.ValidationError
+		if errors.As(err, &validationErr) {
+			failures = validationErr.Errors
+		} else {
+			failures = []*schema.FieldError{{Message: err.Error()}}
+		}
+	}
+	if len(failures) == 0 {
+		return input, nil
+	}
+
+	n.record(failures)
+	quarantined, err := json.Marshal(Quarantined{
+		Data:    data,
+		Schema:  n.schemaType,
+		Version: n.version,
+		Errors:  failures,
+	})
+	if err != nil {
+		return types.Message{}, fmt.Errorf("failed to encode quarantined message: %w", err)
+	}
+	out := input
+	out.Data = quarantined
+	return types.Message{}, &Routed{
+		Port:    PortQuarantine,
+		Message: out,
+		Reason:  (&schema.ValidationError{Errors: failures}).Error(),
+	}
+}
+
+// record counts failures by path
+func (n *validate) record(failures []*schema.FieldError) {
+	if n.metrics == nil {
+		return
+	}
+	for _, failure := range failures {
+		n.metrics.Inc(metrics.FlowValidationFailuresMetric, 1, map[string]string{
+			"flow_id": n.flowID,
+			"node_id": n.Config.ID,
+			"schema":  n.schemaType,
+			"path":    failurePath(failure.Path),
+		})
+	}
+}
+
+// failurePath returns the metric label of a JSON pointer: "/" for the root,
+// and array indexes replaced by *, so that the paths of the elements of an
+// array are counted together
+func failurePath(pointer string) string {
+	if pointer == "" {
+		return "/"
+	}
+	segments := strings.Split(pointer, "/")
+	for i, segment := range segments {
+		if _, err := strconv.Atoi(segment); err == nil {
+			segments[i] = "*"
+		}
+	}
+	return strings.Join(segments, "/")
+}
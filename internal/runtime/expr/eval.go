@@ -0,0 +1,304 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// node is a node of an expression's syntax tree
+type node interface {
+	eval(payload interface{}) (interface{}, error)
+}
+
+// literalNode is a constant
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+// rootNode is the payload itself
+type rootNode struct{}
+
+func (rootNode) eval(payload interface{}) (interface{}, error) {
+	return payload, nil
+}
+
+// fieldNode reads a field of an object
+type fieldNode struct {
+	pos  int
+	x    node
+	name string
+}
+
+func (n *fieldNode) eval(payload interface{}) (interface{}, error) {
+	x, err := n.x.eval(payload)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return x[n.name], nil
+	}
+	return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("cannot read field %q of %s", n.name, TypeName(x))}
+}
+
+// indexNode reads an element of an array or a field of an object
+type indexNode struct {
+	pos   int
+	x     node
+	index node
+}
+
+func (n *indexNode) eval(payload interface{}) (interface{}, error) {
+	x, err := n.x.eval(payload)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	switch x := x.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		i, ok := index.(float64)
+		if !ok || i != math.Trunc(i) {
+			return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("array index must be an integer, got %s", TypeName(index))}
+		}
+		// Negative indexes count from the end
+		if i < 0 {
+			i += float64(len(x))
+		}
+		if i < 0 || int(i) >= len(x) {
+			return nil, nil
+		}
+		return x[int(i)], nil
+	case map[string]interface{}:
+		key, ok := index.(string)
+		if !ok {
+			return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("object key must be a string, got %s", TypeName(index))}
+		}
+		return x[key], nil
+	}
+	return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("cannot index %s", TypeName(x))}
+}
+
+// unaryNode applies ! or - to its operand
+type unaryNode struct {
+	pos int
+	op  string
+	x   node
+}
+
+func (n *unaryNode) eval(payload interface{}) (interface{}, error) {
+	x, err := n.x.eval(payload)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !Truthy(x), nil
+	}
+	f, ok := x.(float64)
+	if !ok {
+		return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("cannot negate %s", TypeName(x))}
+	}
+	return -f, nil
+}
+
+// binaryNode applies a binary operator
+type binaryNode struct {
+	pos  int
+	op   string
+	x, y node
+}
+
+func (n *binaryNode) eval(payload interface{}) (interface{}, error) {
+	x, err := n.x.eval(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// && and || only evaluate their right operand when needed
+	switch n.op {
+	case "&&":
+		if !Truthy(x) {
+			return false, nil
+		}
+	case "||":
+		if Truthy(x) {
+			return true, nil
+		}
+	}
+
+	y, err := n.y.eval(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "&&", "||":
+		return Truthy(y), nil
+	case "==":
+		return equal(x, y), nil
+	case "!=":
+		return !equal(x, y), nil
+	case "+":
+		_, xs := x.(string)
+		_, ys := y.(string)
+		if xs || ys {
+			return formatValue(x) + formatValue(y), nil
+		}
+	case "<", "<=", ">", ">=":
+		return n.compare(x, y)
+	}
+
+	a, aok := x.(float64)
+	b, bok := y.(float64)
+	if !aok || !bok {
+		return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("invalid operands %s %s %s", TypeName(x), n.op, TypeName(y))}
+	}
+	switch n.op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return nil, &Error{Pos: n.pos, Msg: "division by zero"}
+		}
+		return a / b, nil
+	default: // %
+		if b == 0 {
+			return nil, &Error{Pos: n.pos, Msg: "division by zero"}
+		}
+		return math.Mod(a, b), nil
+	}
+}
+
+// compare orders two numbers or two strings
+func (n *binaryNode) compare(x, y interface{}) (interface{}, error) {
+	c, ok := 0, false
+	switch a := x.(type) {
+	case float64:
+		if b, isNum := y.(float64); isNum {
+			c, ok = cmp(a < b, a > b), true
+		}
+	case string:
+		if b, isStr := y.(string); isStr {
+			c, ok = cmp(a < b, a > b), true
+		}
+	}
+	if !ok {
+		return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("cannot compare %s %s %s", TypeName(x), n.op, TypeName(y))}
+	}
+	switch n.op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	}
+	return c >= 0, nil
+}
+
+// cmp converts the results of < and > into -1, 0 or 1
+func cmp(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+// equal reports whether two values are deeply equal
+func equal(x, y interface{}) bool {
+	return reflect.DeepEqual(x, y)
+}
+
+// ifNode evaluates one of two expressions depending on a condition
+type ifNode struct {
+	cond, then, otherwise node
+}
+
+func (n *ifNode) eval(payload interface{}) (interface{}, error) {
+	cond, err := n.cond.eval(payload)
+	if err != nil {
+		return nil, err
+	}
+	if Truthy(cond) {
+		return n.then.eval(payload)
+	}
+	return n.otherwise.eval(payload)
+}
+
+// callNode calls a built-in function
+type callNode struct {
+	pos  int
+	name string
+	fn   function
+	args []node
+}
+
+func (n *callNode) eval(payload interface{}) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(payload)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := n.fn.call(args)
+	if err != nil {
+		return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("%s: %v", n.name, err)}
+	}
+	return v, nil
+}
+
+// arrayNode builds an array
+type arrayNode struct {
+	elements []node
+}
+
+func (n *arrayNode) eval(payload interface{}) (interface{}, error) {
+	values := make([]interface{}, len(n.elements))
+	for i, el := range n.elements {
+		v, err := el.eval(payload)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// objectNode builds an object
+type objectNode struct {
+	keys   []string
+	values []node
+}
+
+func (n *objectNode) eval(payload interface{}) (interface{}, error) {
+	obj := make(map[string]interface{}, len(n.keys))
+	for i, key := range n.keys {
+		v, err := n.values[i].eval(payload)
+		if err != nil {
+			return nil, err
+		}
+		obj[key] = v
+	}
+	return obj, nil
+}
@@ -0,0 +1,179 @@
+/*
+Package expr implements the Flow transform expression language, used to
+compute values from message payloads.
+
+Expressions are evaluated against a payload decoded from JSON. Names refer
+to the payload's fields and $ to the payload itself:
+
+	order.total * 1.2
+	items[0].name + " x" + string(items[0].quantity)
+	status == "paid" && len(items) > 0
+	if(customer.vip, "priority", "standard")
+
+Values are JSON values: null, booleans, numbers (float64), strings, arrays
+and objects. Reading a missing field or index yields null rather than an
+error. The operators are, by increasing precedence, ||, &&, == and !=, the
+comparisons < <= > >=, + and -, * / and %, and the unary ! and -. Adding a
+string to any value concatenates them. && and || yield booleans; null,
+false, 0 and "" are false and every other value is true.
+*/
+package expr
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Error is a syntax or evaluation error at a position of an expression
+type Error struct {
+	// Pos is the 1-based column of the error in the expression
+	Pos int
+	// Msg describes the error
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("col %d: %s", e.Pos, e.Msg)
+}
+
+// Expr is a compiled expression
+type Expr struct {
+	src  string
+	root node
+}
+
+// Compile parses an expression
+func Compile(src string) (*Expr, error) {
+	p := &parser{lex: newLexer(src)}
+	p.next()
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// MustCompile is like Compile but panics on errors. It simplifies
+// expressions known to be valid, such as those in tests.
+func MustCompile(src string) *Expr {
+	e, err := Compile(src)
+	if err != nil {
+		panic(fmt.Sprintf("expr: Compile(%q): %v", src, err))
+	}
+	return e
+}
+
+// Eval compiles and evaluates an expression against payload
+func Eval(src string, payload interface{}) (interface{}, error) {
+	e, err := Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	return e.Eval(payload)
+}
+
+// Eval evaluates the expression against payload, which holds values as
+// decoded by encoding/json
+func (e *Expr) Eval(payload interface{}) (interface{}, error) {
+	return e.root.eval(payload)
+}
+
+// String returns the source of the expression
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Functions returns the names of the built-in functions in alphabetical
+// order
+func Functions() []string {
+	names := make([]string, 0, len(functions)+1)
+	for name := range functions {
+		names = append(names, name)
+	}
+	names = append(names, "if")
+	sort.Strings(names)
+	return names
+}
+
+// TypeName returns the expression language's name for the type of v:
+// null, bool, number, string, array or object
+func TypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// Truthy reports whether v counts as true in conditions
+func Truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return true
+}
+
+// formatValue converts a value to a string the way string() does: strings
+// as they are, numbers without exponent where possible, and other values
+// as JSON
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, el := range v {
+			parts[i] = quoteValue(el)
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			parts[i] = strconv.Quote(k) + ":" + quoteValue(v[k])
+		}
+		return "{" + strings.Join(parts, ",") + "}"
+	}
+	return fmt.Sprint(v)
+}
+
+// quoteValue formats v as JSON, quoting strings
+func quoteValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s)
+	}
+	return formatValue(v)
+}
@@ -0,0 +1,135 @@
+package expr_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"flow-control/internal/runtime/expr"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEval(t *testing.T) {
+	var payload interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"status": "paid",
+		"total": 40,
+		"customer": {"name": " Ada ", "vip": true},
+		"items": [{"sku": "a", "qty": 2}, {"sku": "b", "qty": 1}],
+		"tags": ["new", "eu"]
+	}`), &payload))
+
+	for src, want := range map[string]interface{}{
+		// Paths
+		`status`:                              "paid",
+		`customer.vip`:                        true,
+		`items[1].sku`:                        "b",
+		`items[-1].qty`:                       1.0,
+		`items[5]`:                            nil,
+		`missing.field`:                       nil,
+		`$["status"]`:                         "paid",
+		`customer["na" + "me"]`:               " Ada ",
+		`len($)`:                              5.0,
+		`$.items[0]["sku"]`:                   "a",
+		`{id: 1, "s": status}`:                map[string]interface{}{"id": 1.0, "s": "paid"},
+		`[total, -total, 1.5e1]`:              []interface{}{40.0, -40.0, 15.0},
+		`(total + 2) * 3 / 6 % 5`:             1.0,
+		`total - 2 * 3`:                       34.0,
+		`"#" + total + "/" + true`:            "#40/true",
+		`'it\'s ' + "☺"`:                      "it's ☺",
+		`null`:                                nil,
+		`status == "paid"`:                    true,
+		`tags != ["new", "eu"]`:               false,
+		`total >= 40 && total < 41`:           true,
+		`"a" < "b"`:                           true,
+		`!customer.vip || missing`:            false,
+		`missing && 1 / 0`:                    false,
+		`if(customer.vip, "priority", 1 / 0)`: "priority",
+		// Functions
+		`upper(status)`:                  "PAID",
+		`lower("AB")`:                    "ab",
+		`trim(customer.name)`:            "Ada",
+		`len(items) + len("héllo")`:      7.0,
+		`contains(tags, "eu")`:           true,
+		`contains(status, "ai")`:         true,
+		`join(tags, ",")`:                "new,eu",
+		`split("a-b", "-")`:              []interface{}{"a", "b"},
+		`keys(customer)`:                 []interface{}{"name", "vip"},
+		`round(2.345, 2)`:                2.35,
+		`round(total / 3)`:               13.0,
+		`string(items[0])`:               `{"qty":2,"sku":"a"}`,
+		`string(total * 1.5)`:            "60",
+		`number(" 4.5 ") + number(true)`: 5.5,
+		`coalesce(missing, null, "x")`:   "x",
+		`append(tags, "uk", 1)`:          []interface{}{"new", "eu", "uk", 1.0},
+		`append(missing, 1)`:             []interface{}{1.0},
+		`type(customer) + type(tags)`:    "objectarray",
+	} {
+		got, err := expr.Eval(src, payload)
+		require.NoError(t, err, src)
+		require.Equal(t, want, got, src)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	payload := map[string]interface{}{"n": 1.0, "s": "x", "a": []interface{}{1.0}}
+
+	// Syntax errors report where they were found
+	for src, want := range map[string]string{
+		`n +`:         `col 4: expected a value, got end of expression`,
+		`n n`:         `col 3: expected an operator, got "n"`,
+		`(n`:          `col 3: expected ")", got end of expression`,
+		`"abc`:        `col 1: unterminated string`,
+		`n # 1`:       `col 3: unexpected character '#'`,
+		`nope(1)`:     `col 1: unknown function "nope"`,
+		`upper(1, 2)`: `col 1: upper takes 1 argument`,
+		`if(n)`:       `col 1: if takes 3 arguments`,
+		`{1: n}`:      `col 2: expected a field name, got number 1`,
+		`n.`:          `col 3: expected a field name, got end of expression`,
+	} {
+		_, err := expr.Compile(src)
+		require.EqualError(t, err, want, src)
+		var exprErr *expr.Error
+		require.ErrorAs(t, err, &exprErr)
+	}
+
+	// So do evaluation errors
+	for src, want := range map[string]string{
+		`n / 0`:      `col 3: division by zero`,
+		`s * 2`:      `col 3: invalid operands string * number`,
+		`n < s`:      `col 3: cannot compare number < string`,
+		`-s`:         `col 1: cannot negate string`,
+		`s.field`:    `col 2: cannot read field "field" of string`,
+		`a["x"]`:     `col 2: array index must be an integer, got string`,
+		`n[0]`:       `col 2: cannot index number`,
+		`upper(n)`:   `col 1: upper: expected a string, got number`,
+		`number(s)`:  `col 1: number: invalid number "x"`,
+		`keys(a)`:    `col 1: keys: expected an object, got array`,
+		`len(n) + 1`: `col 1: len: cannot measure number`,
+	} {
+		_, err := expr.Eval(src, payload)
+		require.EqualError(t, err, want, src)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	e := expr.MustCompile(`price * qty`)
+	require.Equal(t, `price * qty`, e.String())
+	for _, tc := range []struct {
+		payload map[string]interface{}
+		want    interface{}
+	}{
+		{map[string]interface{}{"price": 2.5, "qty": 4.0}, 10.0},
+		{map[string]interface{}{"price": 1.0, "qty": 3.0}, 3.0},
+	} {
+		got, err := e.Eval(tc.payload)
+		require.NoError(t, err)
+		require.Equal(t, tc.want, got)
+	}
+
+	require.Panics(t, func() { expr.MustCompile(`(`) })
+	require.Contains(t, expr.Functions(), "if")
+	require.Equal(t, "object", expr.TypeName(map[string]interface{}{}))
+	require.False(t, expr.Truthy(""))
+	require.True(t, expr.Truthy([]interface{}{}))
+}
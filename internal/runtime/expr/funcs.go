@@ -0,0 +1,180 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// function is a built-in function. maxArgs is -1 for variadic functions.
+type function struct {
+	minArgs, maxArgs int
+	call             func(args []interface{}) (interface{}, error)
+}
+
+// arity describes the number of arguments the function takes
+func (f function) arity() string {
+	switch {
+	case f.maxArgs < 0:
+		return fmt.Sprintf("at least %d arguments", f.minArgs)
+	case f.minArgs == f.maxArgs && f.minArgs == 1:
+		return "1 argument"
+	case f.minArgs == f.maxArgs:
+		return fmt.Sprintf("%d arguments", f.minArgs)
+	}
+	return fmt.Sprintf("%d to %d arguments", f.minArgs, f.maxArgs)
+}
+
+// functions holds the built-in functions by name. if is handled by the
+// parser because it only evaluates one of its branches.
+var functions = map[string]function{
+	"len": {1, 1, func(args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case nil:
+			return 0.0, nil
+		case string:
+			return float64(utf8.RuneCountInString(v)), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		}
+		return nil, fmt.Errorf("cannot measure %s", TypeName(args[0]))
+	}},
+	"upper": stringFunc(strings.ToUpper),
+	"lower": stringFunc(strings.ToLower),
+	"trim":  stringFunc(strings.TrimSpace),
+	"contains": {2, 2, func(args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case string:
+			return strings.Contains(v, formatValue(args[1])), nil
+		case []interface{}:
+			for _, el := range v {
+				if equal(el, args[1]) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+		return nil, fmt.Errorf("cannot search %s", TypeName(args[0]))
+	}},
+	"split": {2, 2, func(args []interface{}) (interface{}, error) {
+		s, ok1 := args[0].(string)
+		sep, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, errors.New("expected two strings")
+		}
+		parts := strings.Split(s, sep)
+		values := make([]interface{}, len(parts))
+		for i, part := range parts {
+			values[i] = part
+		}
+		return values, nil
+	}},
+	"join": {2, 2, func(args []interface{}) (interface{}, error) {
+		items, ok1 := args[0].([]interface{})
+		sep, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, errors.New("expected an array and a string")
+		}
+		parts := make([]string, len(items))
+		for i, item := range items {
+			parts[i] = formatValue(item)
+		}
+		return strings.Join(parts, sep), nil
+	}},
+	"keys": {1, 1, func(args []interface{}) (interface{}, error) {
+		obj, ok := args[0].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected an object, got %s", TypeName(args[0]))
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		keys := make([]interface{}, len(names))
+		for i, name := range names {
+			keys[i] = name
+		}
+		return keys, nil
+	}},
+	"round": {1, 2, func(args []interface{}) (interface{}, error) {
+		n, ok := args[0].(float64)
+		if !ok {
+			return nil, fmt.Errorf("expected a number, got %s", TypeName(args[0]))
+		}
+		digits := 0.0
+		if len(args) == 2 {
+			if digits, ok = args[1].(float64); !ok {
+				return nil, fmt.Errorf("expected a number of digits, got %s", TypeName(args[1]))
+			}
+		}
+		scale := math.Pow(10, math.Trunc(digits))
+		return math.Round(n*scale) / scale, nil
+	}},
+	"string": {1, 1, func(args []interface{}) (interface{}, error) {
+		return formatValue(args[0]), nil
+	}},
+	"number": {1, 1, func(args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case float64:
+			return v, nil
+		case bool:
+			if v {
+				return 1.0, nil
+			}
+			return 0.0, nil
+		case string:
+			n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", v)
+			}
+			return n, nil
+		}
+		return nil, fmt.Errorf("cannot convert %s to a number", TypeName(args[0]))
+	}},
+	"append": {2, -1, func(args []interface{}) (interface{}, error) {
+		var items []interface{}
+		switch v := args[0].(type) {
+		case nil:
+		case []interface{}:
+			items = v
+		default:
+			return nil, fmt.Errorf("expected an array, got %s", TypeName(args[0]))
+		}
+		// Copy the array, leaving the one given unchanged
+		values := make([]interface{}, 0, len(items)+len(args)-1)
+		values = append(values, items...)
+		return append(values, args[1:]...), nil
+	}},
+	"type": {1, 1, func(args []interface{}) (interface{}, error) {
+		return TypeName(args[0]), nil
+	}},
+	"coalesce": {1, -1, func(args []interface{}) (interface{}, error) {
+		for _, arg := range args {
+			if arg != nil {
+				return arg, nil
+			}
+		}
+		return nil, nil
+	}},
+}
+
+// stringFunc adapts a string transformation into a function. Null stays
+// null.
+func stringFunc(f func(string) string) function {
+	return function{1, 1, func(args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case nil:
+			return nil, nil
+		case string:
+			return f(v), nil
+		}
+		return nil, fmt.Errorf("expected a string, got %s", TypeName(args[0]))
+	}}
+}
@@ -0,0 +1,156 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokenKind is the kind of a lexical token
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOperator
+)
+
+// token is a lexical token of an expression
+type token struct {
+	kind tokenKind
+	// text is the operator or identifier, or the decoded string literal
+	text string
+	num  float64
+	// pos is the token's 1-based column
+	pos int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokNumber:
+		return fmt.Sprintf("number %s", formatValue(t.num))
+	case tokString:
+		return fmt.Sprintf("string %q", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// operators lists the operator tokens, two-character ones first so that
+// they win over their prefixes
+var operators = []string{
+	"==", "!=", "<=", ">=", "&&", "||",
+	"+", "-", "*", "/", "%", "<", ">", "!",
+	"(", ")", "[", "]", "{", "}", ".", ",", ":", "$",
+}
+
+// lexer splits an expression into tokens
+type lexer struct {
+	src string
+	off int
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: src}
+}
+
+// next returns the next token
+func (l *lexer) next() (token, error) {
+	for l.off < len(l.src) {
+		r, size := utf8.DecodeRuneInString(l.src[l.off:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		l.off += size
+	}
+	pos := utf8.RuneCountInString(l.src[:l.off]) + 1
+	if l.off >= len(l.src) {
+		return token{kind: tokEOF, pos: pos}, nil
+	}
+
+	rest := l.src[l.off:]
+	r, _ := utf8.DecodeRuneInString(rest)
+	switch {
+	case r == '"' || r == '\'':
+		return l.string(r, pos)
+	case r >= '0' && r <= '9':
+		return l.number(pos)
+	case r == '_' || unicode.IsLetter(r):
+		end := strings.IndexFunc(rest, func(r rune) bool {
+			return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if end < 0 {
+			end = len(rest)
+		}
+		l.off += end
+		return token{kind: tokIdent, text: rest[:end], pos: pos}, nil
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op) {
+			l.off += len(op)
+			return token{kind: tokOperator, text: op, pos: pos}, nil
+		}
+	}
+	return token{}, &Error{Pos: pos, Msg: fmt.Sprintf("unexpected character %q", r)}
+}
+
+// number reads a decimal number with optional fraction and exponent
+func (l *lexer) number(pos int) (token, error) {
+	start := l.off
+	digits := func() {
+		for l.off < len(l.src) && l.src[l.off] >= '0' && l.src[l.off] <= '9' {
+			l.off++
+		}
+	}
+	digits()
+	if l.off+1 < len(l.src) && l.src[l.off] == '.' && l.src[l.off+1] >= '0' && l.src[l.off+1] <= '9' {
+		l.off++
+		digits()
+	}
+	if l.off < len(l.src) && (l.src[l.off] == 'e' || l.src[l.off] == 'E') {
+		l.off++
+		if l.off < len(l.src) && (l.src[l.off] == '+' || l.src[l.off] == '-') {
+			l.off++
+		}
+		digits()
+	}
+
+	text := l.src[start:l.off]
+	n, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return token{}, &Error{Pos: pos, Msg: fmt.Sprintf("invalid number %q", text)}
+	}
+	return token{kind: tokNumber, text: text, num: n, pos: pos}, nil
+}
+
+// string reads a string literal quoted with quote. Backslash escapes are
+// those of Go string literals.
+func (l *lexer) string(quote rune, pos int) (token, error) {
+	var b strings.Builder
+	l.off++ // opening quote
+	for l.off < len(l.src) {
+		r, size := utf8.DecodeRuneInString(l.src[l.off:])
+		switch {
+		case r == quote:
+			l.off += size
+			return token{kind: tokString, text: b.String(), pos: pos}, nil
+		case r == '\\':
+			value, _, tail, err := strconv.UnquoteChar(l.src[l.off:], byte(quote))
+			if err != nil {
+				return token{}, &Error{Pos: pos, Msg: "invalid escape in string"}
+			}
+			b.WriteRune(value)
+			l.off = len(l.src) - len(tail)
+		default:
+			b.WriteRune(r)
+			l.off += size
+		}
+	}
+	return token{}, &Error{Pos: pos, Msg: "unterminated string"}
+}
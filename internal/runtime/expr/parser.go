@@ -0,0 +1,281 @@
+package expr
+
+import "fmt"
+
+// binaryLevels lists the binary operators by increasing precedence
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// parser builds the syntax tree of an expression by recursive descent
+type parser struct {
+	lex *lexer
+	tok token
+	err error
+}
+
+// next advances to the next token, keeping the first lexical error
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+	tok, err := p.lex.next()
+	if err != nil {
+		p.err = err
+		p.tok = token{kind: tokEOF, pos: tok.pos}
+		return
+	}
+	p.tok = tok
+}
+
+// is reports whether the current token is the operator op
+func (p *parser) is(op string) bool {
+	return p.tok.kind == tokOperator && p.tok.text == op
+}
+
+// expect consumes the operator op or fails
+func (p *parser) expect(op string) error {
+	if !p.is(op) {
+		return p.unexpected(fmt.Sprintf("%q", op))
+	}
+	p.next()
+	return nil
+}
+
+// unexpected reports the current token where want was expected
+func (p *parser) unexpected(want string) error {
+	if p.err != nil {
+		return p.err
+	}
+	return &Error{Pos: p.tok.pos, Msg: fmt.Sprintf("expected %s, got %s", want, p.tok)}
+}
+
+// parse parses a whole expression
+func (p *parser) parse() (node, error) {
+	n, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.unexpected("an operator")
+	}
+	return n, nil
+}
+
+// binary parses the operators of binaryLevels[level] and above
+func (p *parser) binary(level int) (node, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+	x, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.operator(binaryLevels[level])
+		if !ok {
+			return x, nil
+		}
+		pos := p.tok.pos
+		p.next()
+		y, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryNode{pos: pos, op: op, x: x, y: y}
+	}
+}
+
+// operator returns the current token if it is one of ops
+func (p *parser) operator(ops []string) (string, bool) {
+	for _, op := range ops {
+		if p.is(op) {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+// unary parses prefix operators
+func (p *parser) unary() (node, error) {
+	if op, ok := p.operator([]string{"!", "-"}); ok {
+		pos := p.tok.pos
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{pos: pos, op: op, x: x}, nil
+	}
+	return p.postfix()
+}
+
+// postfix parses an operand followed by field accesses and indexes
+func (p *parser) postfix() (node, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.tok.pos
+		switch {
+		case p.is("."):
+			p.next()
+			if p.tok.kind != tokIdent {
+				return nil, p.unexpected("a field name")
+			}
+			x = &fieldNode{pos: pos, x: x, name: p.tok.text}
+			p.next()
+		case p.is("["):
+			p.next()
+			index, err := p.binary(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &indexNode{pos: pos, x: x, index: index}
+		default:
+			return x, nil
+		}
+	}
+}
+
+// primary parses literals, names, calls and parenthesized expressions
+func (p *parser) primary() (node, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		p.next()
+		return &literalNode{value: tok.num}, nil
+	case tokString:
+		p.next()
+		return &literalNode{value: tok.text}, nil
+	case tokIdent:
+		p.next()
+		switch tok.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		}
+		if p.is("(") {
+			return p.call(tok)
+		}
+		return &fieldNode{pos: tok.pos, x: rootNode{}, name: tok.text}, nil
+	case tokOperator:
+		switch tok.text {
+		case "$":
+			p.next()
+			return rootNode{}, nil
+		case "(":
+			p.next()
+			x, err := p.binary(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		case "[":
+			return p.array()
+		case "{":
+			return p.object()
+		}
+	}
+	return nil, p.unexpected("a value")
+}
+
+// call parses the arguments of a call to the function named by name
+func (p *parser) call(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok && name.text != "if" {
+		return nil, &Error{Pos: name.pos, Msg: fmt.Sprintf("unknown function %q", name.text)}
+	}
+	p.next() // (
+	args, err := p.list(")")
+	if err != nil {
+		return nil, err
+	}
+	if name.text == "if" {
+		if len(args) != 3 {
+			return nil, &Error{Pos: name.pos, Msg: "if takes 3 arguments"}
+		}
+		return &ifNode{cond: args[0], then: args[1], otherwise: args[2]}, nil
+	}
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, &Error{Pos: name.pos, Msg: fmt.Sprintf("%s takes %s", name.text, fn.arity())}
+	}
+	return &callNode{pos: name.pos, name: name.text, fn: fn, args: args}, nil
+}
+
+// list parses comma-separated expressions up to the closing operator end
+func (p *parser) list(end string) ([]node, error) {
+	var items []node
+	for !p.is(end) {
+		item, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if !p.is(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect(end); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// array parses an array literal
+func (p *parser) array() (node, error) {
+	p.next() // [
+	elements, err := p.list("]")
+	if err != nil {
+		return nil, err
+	}
+	return &arrayNode{elements: elements}, nil
+}
+
+// object parses an object literal. Keys are names or strings.
+func (p *parser) object() (node, error) {
+	p.next() // {
+	obj := &objectNode{}
+	for !p.is("}") {
+		if p.tok.kind != tokIdent && p.tok.kind != tokString {
+			return nil, p.unexpected("a field name")
+		}
+		obj.keys = append(obj.keys, p.tok.text)
+		p.next()
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.binary(0)
+		if err != nil {
+			return nil, err
+		}
+		obj.values = append(obj.values, value)
+		if !p.is(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
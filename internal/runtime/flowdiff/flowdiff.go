@@ -0,0 +1,217 @@
+/*
+Package flowdiff compares two versions of a flow structurally. Instead of
+the lines of their source, it reports the nodes that were added, removed or
+changed, the connections between nodes that were added or removed, and for
+changed nodes the settings that differ. Nested settings, such as the fields
+of config blocks, are compared key by key under dotted names.
+*/
+package flowdiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+
+	"flow-control/internal/runtime/engine"
+)
+
+// Kinds of change
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Diff is the structural difference between two flows
+type Diff struct {
+	Nodes       []NodeChange       `json:"nodes"`
+	Connections []ConnectionChange `json:"connections"`
+}
+
+// NodeChange is a node that was added, removed or changed
+type NodeChange struct {
+	ID     string `json:"id"`
+	Change string `json:"change"`
+	// Type is the node's type, or its old type when it was removed
+	Type string `json:"type"`
+	// Keys lists the settings of a changed node that differ. A changed
+	// type is reported under the key "type".
+	Keys []KeyChange `json:"keys,omitempty"`
+}
+
+// KeyChange is a setting that was added, removed or changed
+type KeyChange struct {
+	Key    string      `json:"key"`
+	Change string      `json:"change"`
+	Old    interface{} `json:"old,omitempty"`
+	New    interface{} `json:"new,omitempty"`
+}
+
+// ConnectionChange is a connection that was added or removed
+type ConnectionChange struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Change string `json:"change"`
+}
+
+// Compare returns the changes that turn before into after. Nodes are
+// matched by name; changed and added nodes are listed in the order of after,
+// followed by removed nodes in the order of before.
+func Compare(before, after *engine.Graph) *Diff {
+	d := &Diff{Nodes: []NodeChange{}, Connections: []ConnectionChange{}}
+
+	oldNodes := make(map[string]*engine.GraphNode, len(before.Nodes))
+	for _, n := range before.Nodes {
+		oldNodes[n.Config.ID] = n
+	}
+	newNodes := make(map[string]*engine.GraphNode, len(after.Nodes))
+	for _, n := range after.Nodes {
+		newNodes[n.Config.ID] = n
+	}
+
+	for _, n := range after.Nodes {
+		prev, ok := oldNodes[n.Config.ID]
+		if !ok {
+			d.Nodes = append(d.Nodes, NodeChange{ID: n.Config.ID, Change: Added, Type: n.Config.Type})
+			continue
+		}
+		if keys := compareKeys(settings(prev), settings(n)); len(keys) > 0 {
+			d.Nodes = append(d.Nodes, NodeChange{ID: n.Config.ID, Change: Changed, Type: n.Config.Type, Keys: keys})
+		}
+	}
+	for _, n := range before.Nodes {
+		if _, ok := newNodes[n.Config.ID]; !ok {
+			d.Nodes = append(d.Nodes, NodeChange{ID: n.Config.ID, Change: Removed, Type: n.Config.Type})
+		}
+	}
+
+	oldConns, newConns := connections(before), connections(after)
+	for _, c := range newConns.list {
+		if !oldConns.set[c] {
+			d.Connections = append(d.Connections, ConnectionChange{From: c[0], To: c[1], Change: Added})
+		}
+	}
+	for _, c := range oldConns.list {
+		if !newConns.set[c] {
+			d.Connections = append(d.Connections, ConnectionChange{From: c[0], To: c[1], Change: Removed})
+		}
+	}
+	return d
+}
+
+// Empty reports whether the flows are the same
+func (d *Diff) Empty() bool {
+	return len(d.Nodes) == 0 && len(d.Connections) == 0
+}
+
+// WriteText writes the diff in a readable form, one change per line
+// marked with + for additions, - for removals and ~ for changes
+func (d *Diff) WriteText(w io.Writer) error {
+	marks := map[string]string{Added: "+", Removed: "-", Changed: "~"}
+	for _, n := range d.Nodes {
+		if _, err := fmt.Fprintf(w, "%s node %s (%s)\n", marks[n.Change], n.ID, n.Type); err != nil {
+			return err
+		}
+		for _, k := range n.Keys {
+			var line string
+			switch k.Change {
+			case Added:
+				line = fmt.Sprintf("%s: %s", k.Key, format(k.New))
+			case Removed:
+				line = fmt.Sprintf("%s: %s", k.Key, format(k.Old))
+			default:
+				line = fmt.Sprintf("%s: %s -> %s", k.Key, format(k.Old), format(k.New))
+			}
+			if _, err := fmt.Fprintf(w, "    %s %s\n", marks[k.Change], line); err != nil {
+				return err
+			}
+		}
+	}
+	for _, c := range d.Connections {
+		if _, err := fmt.Fprintf(w, "%s connection %s -> %s\n", marks[c.Change], c.From, c.To); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// connectionSet holds the connections of a graph in graph order
+type connectionSet struct {
+	list [][2]string
+	set  map[[2]string]bool
+}
+
+// connections lists the connections of a graph
+func connections(g *engine.Graph) connectionSet {
+	cs := connectionSet{set: map[[2]string]bool{}}
+	for _, n := range g.Nodes {
+		for _, from := range n.From {
+			c := [2]string{from, n.Config.ID}
+			if !cs.set[c] {
+				cs.set[c] = true
+				cs.list = append(cs.list, c)
+			}
+		}
+	}
+	return cs
+}
+
+// settings flattens the type and settings of a node into dotted keys
+func settings(n *engine.GraphNode) map[string]interface{} {
+	flat := map[string]interface{}{"type": n.Config.Type}
+	flatten(flat, "", n.Config.Settings)
+	return flat
+}
+
+// flatten adds the values of m to flat under prefix, recursing into
+// nested maps
+func flatten(flat map[string]interface{}, prefix string, m map[string]interface{}) {
+	for key, value := range m {
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			flatten(flat, prefix+key+".", nested)
+			continue
+		}
+		flat[prefix+key] = value
+	}
+}
+
+// compareKeys lists the keys that differ between two flattened settings
+// maps, in alphabetical order
+func compareKeys(before, after map[string]interface{}) []KeyChange {
+	keys := make([]string, 0, len(before)+len(after))
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []KeyChange
+	for _, key := range keys {
+		old, inBefore := before[key]
+		value, inAfter := after[key]
+		switch {
+		case !inBefore:
+			changes = append(changes, KeyChange{Key: key, Change: Added, New: value})
+		case !inAfter:
+			changes = append(changes, KeyChange{Key: key, Change: Removed, Old: old})
+		case !reflect.DeepEqual(old, value):
+			changes = append(changes, KeyChange{Key: key, Change: Changed, Old: old, New: value})
+		}
+	}
+	return changes
+}
+
+// format formats a setting value as JSON
+func format(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,87 @@
+package flowdiff_test
+
+import (
+	"bytes"
+	"testing"
+
+	"flow-control/internal/logger"
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/runtime/flowdiff"
+
+	"github.com/stretchr/testify/require"
+)
+
+func load(t *testing.T, src string) *engine.Graph {
+	t.Helper()
+	graph, err := engine.LoadSource(src, "", logger.New())
+	require.NoError(t, err)
+	return graph
+}
+
+func TestCompare(t *testing.T) {
+	before := load(t, `flow "orders" {
+  node "read" { type: "Passthrough" }
+  node "paid" {
+    type: "Filter"
+    field: "status"
+    config { limit: 10, retry: { count: 3, delay: "1s" } }
+  }
+  node "audit" { type: "Passthrough" from: "read" }
+}`)
+	after := load(t, `flow "orders" {
+  node "read" { type: "Transform" }
+  node "paid" {
+    type: "Filter"
+    field: "status"
+    config { limit: 20, retry: { count: 3 }, strict: "on" }
+  }
+  node "notify" { type: "Passthrough" }
+}`)
+
+	diff := flowdiff.Compare(before, after)
+	require.False(t, diff.Empty())
+	require.Equal(t, []flowdiff.NodeChange{
+		{ID: "read", Change: flowdiff.Changed, Type: "Transform", Keys: []flowdiff.KeyChange{
+			{Key: "type", Change: flowdiff.Changed, Old: "Passthrough", New: "Transform"},
+		}},
+		{ID: "paid", Change: flowdiff.Changed, Type: "Filter", Keys: []flowdiff.KeyChange{
+			{Key: "config.limit", Change: flowdiff.Changed, Old: float64(10), New: float64(20)},
+			{Key: "config.retry.delay", Change: flowdiff.Removed, Old: "1s"},
+			{Key: "config.strict", Change: flowdiff.Added, New: "on"},
+		}},
+		{ID: "notify", Change: flowdiff.Added, Type: "Passthrough"},
+		{ID: "audit", Change: flowdiff.Removed, Type: "Passthrough"},
+	}, diff.Nodes)
+	require.Equal(t, []flowdiff.ConnectionChange{
+		{From: "paid", To: "notify", Change: flowdiff.Added},
+		{From: "read", To: "audit", Change: flowdiff.Removed},
+	}, diff.Connections)
+
+	var buf bytes.Buffer
+	require.NoError(t, diff.WriteText(&buf))
+	require.Equal(t, `~ node read (Transform)
+    ~ type: "Passthrough" -> "Transform"
+~ node paid (Filter)
+    ~ config.limit: 10 -> 20
+    - config.retry.delay: "1s"
+    + config.strict: "on"
++ node notify (Passthrough)
+- node audit (Passthrough)
++ connection paid -> notify
+- connection read -> audit
+`, buf.String())
+
+	// A flow does not differ from itself, and node order does not matter
+	require.True(t, flowdiff.Compare(before, before).Empty())
+	reordered := load(t, `flow "orders" {
+  node "read" { type: "Passthrough" }
+  node "audit" { type: "Passthrough" from: "read" }
+  node "paid" {
+    type: "Filter"
+    from: "read"
+    field: "status"
+    config { retry: { delay: "1s", count: 3 }, limit: 10 }
+  }
+}`)
+	require.True(t, flowdiff.Compare(before, reordered).Empty())
+}
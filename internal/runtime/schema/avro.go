@@ -0,0 +1,548 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+
+	"flow-control/internal/types"
+)
+
+// avroName matches valid Avro record and field names, which are valid
+// protobuf field names too
+var avroName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// avroPrimitives maps built-in schema types to Avro types
+var avroPrimitives = map[string]string{
+	"string": "string",
+	"int":    "long",
+	"float":  "double",
+	"bool":   "boolean",
+}
+
+// avroCodec serializes values in the Avro binary encoding. Objects become
+// records whose fields are ordered by name, optional and nullable values
+// become unions with null, times and timestamps are timestamp-micros longs
+// and decimals use the decimal logical type. Values are encoded by goavro
+// with the schema ToAvroSchema returns, which readers need to decode the
+// data.
+type avroCodec struct{}
+
+// Name implements Codec.Name
+func (avroCodec) Name() string { return CodecAvro }
+
+// Encode implements Codec.Encode
+func (avroCodec) Encode(s types.Schema, data interface{}) ([]byte, error) {
+	codec, err := avroCodecFor(s)
+	if err != nil {
+		return nil, err
+	}
+	native, err := avroNative(s, data, avroRootName(s))
+	if err != nil {
+		return nil, err
+	}
+	return codec.BinaryFromNative(nil, native)
+}
+
+// Decode implements Codec.Decode
+func (avroCodec) Decode(s types.Schema, data []byte) (interface{}, error) {
+	codec, err := avroCodecFor(s)
+	if err != nil {
+		return nil, err
+	}
+	native, rest, err := codec.NativeFromBinary(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%d trailing bytes after avro value", len(rest))
+	}
+	return avroValue(s, native, avroRootName(s))
+}
+
+// ToAvroSchema converts a schema to an Avro schema document. Records are
+// named after the schema type, nested records after their field path.
+func ToAvroSchema(s types.Schema) ([]byte, error) {
+	doc, err := toAvroSchema(s, avroRootName(s))
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode Avro schema: %w", err)
+	}
+	return data, nil
+}
+
+// toAvroSchema converts a schema to an unencoded Avro schema
+func toAvroSchema(s types.Schema, name string) (interface{}, error) {
+	switch v := unwrapSchema(s).(type) {
+	case *BasicSchema:
+		if v.schemaType == "time" {
+			return map[string]interface{}{"type": "long", "logicalType": "timestamp-micros"}, nil
+		}
+		avroType, ok := avroPrimitives[v.schemaType]
+		if !ok {
+			return nil, fmt.Errorf("schema type %s has no Avro equivalent", v.schemaType)
+		}
+		return avroType, nil
+
+	case *DecimalSchema:
+		return map[string]interface{}{
+			"type":        "bytes",
+			"logicalType": "decimal",
+			"precision":   v.precision,
+			"scale":       v.scale,
+		}, nil
+
+	case *BytesSchema:
+		return "bytes", nil
+
+	case *TimestampSchema:
+		return map[string]interface{}{"type": "long", "logicalType": "timestamp-micros"}, nil
+
+	case *ArraySchema:
+		items, err := toAvroSchema(v.elementSchema, name+"_item")
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "array", "items": items}, nil
+
+	case *MapSchema:
+		if err := checkStringKeys(v); err != nil {
+			return nil, err
+		}
+		values, err := toAvroSchema(v.valueSchema, name+"_value")
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "map", "values": values}, nil
+
+	case *ObjectSchema:
+		fields := make([]interface{}, 0, len(v.properties))
+		for _, field := range sortedProperties(v) {
+			if !avroName.MatchString(field) {
+				return nil, fmt.Errorf("property %s is not a valid Avro field name", field)
+			}
+			prop := v.properties[field]
+			fieldType, err := toAvroSchema(prop, name+"_"+field)
+			if err != nil {
+				return nil, fmt.Errorf("property %s: %w", field, err)
+			}
+			entry := map[string]interface{}{"name": field, "type": fieldType}
+			if !isRequired(v, field) && !isNullable(prop) {
+				entry["type"] = []interface{}{"null", fieldType}
+				entry["default"] = nil
+			}
+			fields = append(fields, entry)
+		}
+		return map[string]interface{}{"type": "record", "name": name, "fields": fields}, nil
+
+	case *UnionSchema:
+		variants := make([]interface{}, len(v.variants))
+		for i, variant := range v.variants {
+			if isUnion(variant) {
+				return nil, fmt.Errorf("avro unions cannot contain %s", variant.GetType())
+			}
+			converted, err := toAvroSchema(variant, fmt.Sprintf("%s_%d", name, i))
+			if err != nil {
+				return nil, err
+			}
+			variants[i] = converted
+		}
+		return variants, nil
+
+	case *NullableSchema:
+		if isUnion(v.inner) {
+			return nil, fmt.Errorf("avro unions cannot contain %s", v.inner.GetType())
+		}
+		inner, err := toAvroSchema(v.inner, name)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{"null", inner}, nil
+
+	default:
+		return nil, fmt.Errorf("schema %s of kind %T cannot be converted to Avro", s.GetType(), s)
+	}
+}
+
+// avroCodecs caches goavro codecs by schema
+var avroCodecs sync.Map
+
+// avroCodecFor returns the goavro codec of a schema's Avro schema
+func avroCodecFor(s types.Schema) (*goavro.Codec, error) {
+	if codec, ok := avroCodecs.Load(s); ok {
+		return codec.(*goavro.Codec), nil
+	}
+	doc, err := ToAvroSchema(s)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := goavro.NewCodec(string(doc))
+	if err != nil {
+		return nil, fmt.Errorf("schema %s has no valid Avro schema: %w", s.GetType(), err)
+	}
+	avroCodecs.Store(s, codec)
+	return codec, nil
+}
+
+// avroRootName returns the name of the top-level record of a schema
+func avroRootName(s types.Schema) string {
+	if defined, ok := s.(*DefinedSchema); ok {
+		return avroRecordName(defined.name)
+	}
+	return "Record"
+}
+
+// avroTypeName returns the name goavro gives a union branch holding values
+// of a schema, which toAvroSchema named name
+func avroTypeName(s types.Schema, name string) string {
+	switch v := unwrapSchema(s).(type) {
+	case *BasicSchema:
+		if v.schemaType == "time" {
+			return "long.timestamp-micros"
+		}
+		return avroPrimitives[v.schemaType]
+	case *DecimalSchema:
+		return "bytes.decimal"
+	case *BytesSchema:
+		return "bytes"
+	case *TimestampSchema:
+		return "long.timestamp-micros"
+	case *ArraySchema:
+		return "array"
+	case *MapSchema:
+		return "map"
+	default:
+		return name
+	}
+}
+
+// avroNative converts a value of a schema to the goavro native form of the
+// Avro schema toAvroSchema gives it under name
+func avroNative(s types.Schema, data interface{}, name string) (interface{}, error) {
+	switch v := unwrapSchema(s).(type) {
+	case *BasicSchema:
+		switch v.schemaType {
+		case "string":
+			str, ok := data.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string, got %T", data)
+			}
+			return str, nil
+		case "int":
+			n, ok := toInt64(data)
+			if !ok {
+				return nil, fmt.Errorf("expected integer, got %v", data)
+			}
+			return n, nil
+		case "float":
+			f, ok := toFloat(data)
+			if !ok {
+				return nil, fmt.Errorf("expected number, got %T", data)
+			}
+			return f, nil
+		case "bool":
+			b, ok := data.(bool)
+			if !ok {
+				return nil, fmt.Errorf("expected bool, got %T", data)
+			}
+			return b, nil
+		case "time":
+			return toTime(data)
+		}
+
+	case *DecimalSchema:
+		n, err := decimalUnscaled(data, v.scale)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Rat).SetFrac(n, decimalScale(v.scale)), nil
+
+	case *BytesSchema:
+		return toBytes(data)
+
+	case *TimestampSchema:
+		return v.parse(data)
+
+	case *ArraySchema:
+		items, err := toArray(data)
+		if err != nil {
+			return nil, err
+		}
+		native := make([]interface{}, len(items))
+		for i, item := range items {
+			if native[i], err = avroNative(v.elementSchema, item, name+"_item"); err != nil {
+				return nil, fmt.Errorf("item %d: %w", i, err)
+			}
+		}
+		return native, nil
+
+	case *MapSchema:
+		m, err := toObject(data)
+		if err != nil {
+			return nil, err
+		}
+		native := make(map[string]interface{}, len(m))
+		for key, value := range m {
+			if native[key], err = avroNative(v.valueSchema, value, name+"_value"); err != nil {
+				return nil, fmt.Errorf("key %s: %w", key, err)
+			}
+		}
+		return native, nil
+
+	case *ObjectSchema:
+		m, err := toObject(data)
+		if err != nil {
+			return nil, err
+		}
+		record := make(map[string]interface{}, len(v.properties))
+		for _, field := range sortedProperties(v) {
+			prop := v.properties[field]
+			value, present := m[field]
+			fieldName := name + "_" + field
+			if !isRequired(v, field) && !isNullable(prop) {
+				// Optional fields are unions of null and their type
+				if !present || value == nil {
+					record[field] = nil
+					continue
+				}
+				native, err := avroNative(prop, value, fieldName)
+				if err != nil {
+					return nil, fmt.Errorf("field %s: %w", field, err)
+				}
+				record[field] = goavro.Union(avroTypeName(prop, fieldName), native)
+				continue
+			}
+			if !present && !isNullable(prop) {
+				return nil, fmt.Errorf("missing required field %s", field)
+			}
+			if record[field], err = avroNative(prop, value, fieldName); err != nil {
+				return nil, fmt.Errorf("field %s: %w", field, err)
+			}
+		}
+		return record, nil
+
+	case *UnionSchema:
+		for i, variant := range v.variants {
+			if variant.Validate(data) != nil {
+				continue
+			}
+			variantName := fmt.Sprintf("%s_%d", name, i)
+			native, err := avroNative(variant, data, variantName)
+			if err != nil {
+				return nil, err
+			}
+			return goavro.Union(avroTypeName(variant, variantName), native), nil
+		}
+		return nil, fmt.Errorf("value matches no variant of %s", v.GetType())
+
+	case *NullableSchema:
+		if data == nil {
+			return nil, nil
+		}
+		native, err := avroNative(v.inner, data, name)
+		if err != nil {
+			return nil, err
+		}
+		return goavro.Union(avroTypeName(v.inner, name), native), nil
+	}
+	return nil, fmt.Errorf("schema %s of kind %T has no Avro encoding", s.GetType(), s)
+}
+
+// avroValue converts a goavro native value to a value of a schema
+func avroValue(s types.Schema, native interface{}, name string) (interface{}, error) {
+	switch v := unwrapSchema(s).(type) {
+	case *BasicSchema:
+		if t, ok := native.(time.Time); ok && v.schemaType == "time" {
+			return t.UTC(), nil
+		}
+		return native, nil
+
+	case *DecimalSchema:
+		r, ok := native.(*big.Rat)
+		if !ok {
+			return nil, fmt.Errorf("expected decimal, got %T", native)
+		}
+		n := new(big.Rat).Mul(r, new(big.Rat).SetInt(decimalScale(v.scale)))
+		return formatDecimal(n.Num(), v.scale), nil
+
+	case *BytesSchema:
+		b, ok := native.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("expected bytes, got %T", native)
+		}
+		return append([]byte{}, b...), nil
+
+	case *TimestampSchema:
+		t, ok := native.(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("expected timestamp, got %T", native)
+		}
+		return t.UTC().Format(v.layout), nil
+
+	case *ArraySchema:
+		items, err := toArray(native)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, len(items))
+		for i, item := range items {
+			if values[i], err = avroValue(v.elementSchema, item, name+"_item"); err != nil {
+				return nil, fmt.Errorf("item %d: %w", i, err)
+			}
+		}
+		return values, nil
+
+	case *MapSchema:
+		m, err := toObject(native)
+		if err != nil {
+			return nil, err
+		}
+		values := make(map[string]interface{}, len(m))
+		for key, item := range m {
+			if values[key], err = avroValue(v.valueSchema, item, name+"_value"); err != nil {
+				return nil, fmt.Errorf("key %s: %w", key, err)
+			}
+		}
+		return values, nil
+
+	case *ObjectSchema:
+		record, err := toObject(native)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, len(v.properties))
+		for _, field := range sortedProperties(v) {
+			prop := v.properties[field]
+			value := record[field]
+			if !isRequired(v, field) && !isNullable(prop) {
+				if value == nil {
+					continue
+				}
+				if _, value, err = avroBranch(value); err != nil {
+					return nil, fmt.Errorf("field %s: %w", field, err)
+				}
+			}
+			if m[field], err = avroValue(prop, value, name+"_"+field); err != nil {
+				return nil, fmt.Errorf("field %s: %w", field, err)
+			}
+		}
+		return m, nil
+
+	case *UnionSchema:
+		branch, value, err := avroBranch(native)
+		if err != nil {
+			return nil, err
+		}
+		for i, variant := range v.variants {
+			variantName := fmt.Sprintf("%s_%d", name, i)
+			if avroTypeName(variant, variantName) == branch {
+				return avroValue(variant, value, variantName)
+			}
+		}
+		return nil, fmt.Errorf("invalid union branch %s", branch)
+
+	case *NullableSchema:
+		if native == nil {
+			return nil, nil
+		}
+		_, value, err := avroBranch(native)
+		if err != nil {
+			return nil, err
+		}
+		return avroValue(v.inner, value, name)
+	}
+	return nil, fmt.Errorf("schema %s of kind %T has no Avro encoding", s.GetType(), s)
+}
+
+// avroBranch splits a goavro union value into its branch name and value
+func avroBranch(native interface{}) (string, interface{}, error) {
+	if m, ok := native.(map[string]interface{}); ok && len(m) == 1 {
+		for branch, value := range m {
+			return branch, value, nil
+		}
+	}
+	return "", nil, fmt.Errorf("expected union value, got %T", native)
+}
+
+// decimalScale returns 10^scale
+func decimalScale(scale int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+}
+
+// avroRecordName converts a schema type name to a valid Avro name
+func avroRecordName(name string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+	if cleaned == "" || ('0' <= cleaned[0] && cleaned[0] <= '9') {
+		cleaned = "_" + cleaned
+	}
+	return cleaned
+}
+
+// checkStringKeys rejects maps whose keys are not strings
+func checkStringKeys(m *MapSchema) error {
+	if basic, ok := unwrapSchema(m.keySchema).(*BasicSchema); !ok || basic.schemaType != "string" {
+		return fmt.Errorf("map keys of type %s are not supported, only strings", m.keySchema.GetType())
+	}
+	return nil
+}
+
+// sortedProperties returns an object's property names in order
+func sortedProperties(s *ObjectSchema) []string {
+	names := make([]string, 0, len(s.properties))
+	for name := range s.properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortedKeys returns a map's keys in order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isRequired reports whether an object requires a property
+func isRequired(s *ObjectSchema, name string) bool {
+	for _, required := range s.required {
+		if required == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isNullable reports whether a schema accepts nil
+func isNullable(s types.Schema) bool {
+	_, ok := unwrapSchema(s).(*NullableSchema)
+	return ok
+}
+
+// isUnion reports whether a schema is encoded as a union
+func isUnion(s types.Schema) bool {
+	switch unwrapSchema(s).(type) {
+	case *UnionSchema, *NullableSchema:
+		return true
+	default:
+		return false
+	}
+}
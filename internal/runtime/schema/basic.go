@@ -10,6 +10,7 @@
 package schema
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -18,13 +19,15 @@ import (
 
 // BasicSchema implements the Schema interface for primitive types
 type BasicSchema struct {
-	schemaType string
-	version    string
-	validator  func(interface{}) error
+	schemaType  string
+	version     string
+	validator   func(interface{}) error
+	constraints Constraints
 }
 
-// NewStringSchema creates a schema for string validation
-func NewStringSchema() types.Schema {
+// NewStringSchema creates a schema for string validation. Options add
+// length, pattern and enum constraints.
+func NewStringSchema(opts ...Option) types.Schema {
 	return &BasicSchema{
 		schemaType: "string",
 		version:    "1.0",
@@ -34,38 +37,55 @@ func NewStringSchema() types.Schema {
 			}
 			return nil
 		},
+		constraints: newConstraints(opts),
 	}
 }
 
-// NewIntSchema creates a schema for integer validation
-func NewIntSchema() types.Schema {
+// NewIntSchema creates a schema for integer validation. Options add range
+// and enum constraints. Unsigned integers and integral json.Number values
+// from a decoder using UseNumber are accepted too.
+func NewIntSchema(opts ...Option) types.Schema {
 	return &BasicSchema{
 		schemaType: "int",
 		version:    "1.0",
 		validator: func(data interface{}) error {
-			switch data.(type) {
-			case int, int8, int16, int32, int64:
+			switch v := data.(type) {
+			case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+				return nil
+			case json.Number:
+				if _, err := v.Int64(); err != nil {
+					return fmt.Errorf("expected integer, got %s", v)
+				}
 				return nil
 			default:
 				return fmt.Errorf("expected integer, got %T", data)
 			}
 		},
+		constraints: newConstraints(opts),
 	}
 }
 
-// NewFloatSchema creates a schema for float validation
-func NewFloatSchema() types.Schema {
+// NewFloatSchema creates a schema for float validation. Options add range
+// and enum constraints. json.Number values from a decoder using UseNumber
+// are accepted too.
+func NewFloatSchema(opts ...Option) types.Schema {
 	return &BasicSchema{
 		schemaType: "float",
 		version:    "1.0",
 		validator: func(data interface{}) error {
-			switch data.(type) {
+			switch v := data.(type) {
 			case float32, float64:
 				return nil
+			case json.Number:
+				if _, err := v.Float64(); err != nil {
+					return fmt.Errorf("expected float, got %s", v)
+				}
+				return nil
 			default:
 				return fmt.Errorf("expected float, got %T", data)
 			}
 		},
+		constraints: newConstraints(opts),
 	}
 }
 
@@ -111,7 +131,10 @@ func (s *BasicSchema) Validate(data interface{}) error {
 	if data == nil {
 		return fmt.Errorf("cannot validate nil data")
 	}
-	return s.validator(data)
+	if err := s.validator(data); err != nil {
+		return err
+	}
+	return s.constraints.check(data)
 }
 
 // GetType implements Schema.GetType
@@ -124,13 +147,24 @@ func (s *BasicSchema) GetVersion() string {
 	return s.version
 }
 
-// IsCompatible checks if two schemas are compatible
+// Constraints returns the constraints set on the schema
+func (s *BasicSchema) Constraints() Constraints {
+	return s.constraints
+}
+
+// IsCompatible checks if two schemas share a type and major version. Use
+// CheckCompatibility to also take the direction of the data into account.
 func IsCompatible(s1, s2 types.Schema) bool {
 	if s1.GetType() != s2.GetType() {
 		return false
 	}
-	// For now, we only check major version compatibility
-	v1 := s1.GetVersion()
-	v2 := s2.GetVersion()
-	return v1[0] == v2[0] // Compare major version
+	v1, err := ParseVersion(s1.GetVersion())
+	if err != nil {
+		return false
+	}
+	v2, err := ParseVersion(s2.GetVersion())
+	if err != nil {
+		return false
+	}
+	return v1.Major == v2.Major
 }
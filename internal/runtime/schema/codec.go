@@ -0,0 +1,209 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// Codec names accepted in port configurations
+const (
+	CodecJSON     = "json"
+	CodecAvro     = "avro"
+	CodecProtobuf = "protobuf"
+)
+
+// Codec serializes values described by a schema to a wire format. Encoders
+// accept values in their decoded JSON form, with numbers as Go numbers or
+// json.Number and times as time.Time or RFC 3339 strings. Decoders return
+// objects as map[string]interface{}, arrays as []interface{}, integers as
+// int64, floats as float64, times as time.Time, bytes as []byte, and
+// decimals and timestamps as strings.
+type Codec interface {
+	// Name returns the codec name used in port configurations
+	Name() string
+
+	// Encode serializes a value of the schema
+	Encode(s types.Schema, data interface{}) ([]byte, error)
+
+	// Decode deserializes a value of the schema
+	Decode(s types.Schema, data []byte) (interface{}, error)
+}
+
+// codecs holds the available codecs by name
+var codecs = map[string]Codec{
+	CodecJSON:     jsonCodec{},
+	CodecAvro:     avroCodec{},
+	CodecProtobuf: protobufCodec{},
+}
+
+// GetCodec returns a codec by name. An empty name selects JSON.
+func GetCodec(name string) (Codec, error) {
+	if name == "" {
+		name = CodecJSON
+	}
+	codec, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec: %s", name)
+	}
+	return codec, nil
+}
+
+// EncodeMessage serializes a message's data with the codec configured on a
+// port, so that sinks can write it in the port's wire format. The port's
+// data type describes the payload, falling back to the message schema.
+func EncodeMessage(msg types.Message, port types.PortConfig) ([]byte, error) {
+	codec, err := GetCodec(port.Codec)
+	if err != nil {
+		return nil, fmt.Errorf("port %s: %w", port.Name, err)
+	}
+	s := port.DataType
+	if s == nil {
+		s = msg.Schema
+	}
+	if s == nil {
+		return nil, fmt.Errorf("port %s: message %s has no schema", port.Name, msg.ID)
+	}
+
+	var data interface{}
+	decoder := json.NewDecoder(bytes.NewReader(msg.Data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to decode message %s: %w", msg.ID, err)
+	}
+
+	encoded, err := codec.Encode(s, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message %s as %s: %w", msg.ID, codec.Name(), err)
+	}
+	return encoded, nil
+}
+
+// DecodeMessage deserializes data read by a source in a port's wire format
+// into a message carrying the port's data type. The decoded value is
+// validated against the schema.
+func DecodeMessage(id string, data []byte, port types.PortConfig) (types.Message, error) {
+	codec, err := GetCodec(port.Codec)
+	if err != nil {
+		return types.Message{}, fmt.Errorf("port %s: %w", port.Name, err)
+	}
+	if port.DataType == nil {
+		return types.Message{}, fmt.Errorf("port %s has no data type", port.Name)
+	}
+
+	value, err := codec.Decode(port.DataType, data)
+	if err != nil {
+		return types.Message{}, fmt.Errorf("failed to decode message %s as %s: %w", id, codec.Name(), err)
+	}
+	if err := port.DataType.Validate(value); err != nil {
+		return types.Message{}, fmt.Errorf("message %s does not match schema %s: %w", id, port.DataType.GetType(), err)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return types.Message{}, fmt.Errorf("failed to encode message %s: %w", id, err)
+	}
+
+	return types.Message{
+		ID:     id,
+		Schema: port.DataType,
+		Data:   encoded,
+		Metadata: types.MessageMetadata{
+			Timestamp: time.Now(),
+			Target:    port.Name,
+		},
+	}, nil
+}
+
+// jsonCodec serializes values as JSON
+type jsonCodec struct{}
+
+// Name implements Codec.Name
+func (jsonCodec) Name() string { return CodecJSON }
+
+// Encode implements Codec.Encode
+func (jsonCodec) Encode(s types.Schema, data interface{}) ([]byte, error) {
+	return json.Marshal(data)
+}
+
+// Decode implements Codec.Decode
+func (jsonCodec) Decode(s types.Schema, data []byte) (interface{}, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// unwrapSchema strips named wrappers from a schema
+func unwrapSchema(s types.Schema) types.Schema {
+	for {
+		defined, ok := s.(*DefinedSchema)
+		if !ok {
+			return s
+		}
+		s = defined.schema
+	}
+}
+
+// toInt64 converts an integral Go number or json.Number to int64
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	default:
+		f, ok := toFloat(v)
+		if !ok || f != math.Trunc(f) || math.Abs(f) > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	}
+}
+
+// toTime converts a time.Time or an RFC 3339 string to a time
+func toTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q: %w", t, err)
+		}
+		return parsed, nil
+	default:
+		return time.Time{}, fmt.Errorf("expected time, got %T", v)
+	}
+}
+
+// toObject converts an object value to a map
+func toObject(v interface{}) (map[string]interface{}, error) {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("expected object, got %T", v)
+}
+
+// toArray converts an array value to a slice
+func toArray(v interface{}) ([]interface{}, error) {
+	if a, ok := v.([]interface{}); ok {
+		return a, nil
+	}
+	return nil, fmt.Errorf("expected array, got %T", v)
+}
@@ -0,0 +1,223 @@
+package schema
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"flow-control/internal/types"
+)
+
+// Version is a parsed semantic version
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+}
+
+// ParseVersion parses a semantic version. A leading "v" is allowed, missing
+// minor and patch numbers default to zero ("1.0" is 1.0.0) and build metadata
+// is ignored.
+func ParseVersion(s string) (Version, error) {
+	text := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(text, '+'); i >= 0 {
+		text = text[:i]
+	}
+
+	var v Version
+	if i := strings.IndexByte(text, '-'); i >= 0 {
+		v.Prerelease = text[i+1:]
+		text = text[:i]
+		if v.Prerelease == "" {
+			return Version{}, fmt.Errorf("invalid version %q: empty prerelease", s)
+		}
+	}
+
+	parts := strings.Split(text, ".")
+	if len(parts) > 3 {
+		return Version{}, fmt.Errorf("invalid version %q: too many components", s)
+	}
+	numbers := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || (len(part) > 1 && part[0] == '0') {
+			return Version{}, fmt.Errorf("invalid version %q: bad component %q", s, part)
+		}
+		*numbers[i] = n
+	}
+	return v, nil
+}
+
+// String returns the version in major.minor.patch form
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 as v precedes, equals or follows o in semantic
+// version order
+func (v Version) Compare(o Version) int {
+	for _, pair := range [][2]int{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+		if pair[0] != pair[1] {
+			return compareInts(pair[0], pair[1])
+		}
+	}
+	return comparePrerelease(v.Prerelease, o.Prerelease)
+}
+
+// CompatibilityMode selects which version differences are allowed between
+// the schema of the data a producer sends and the schema a consumer expects
+type CompatibilityMode string
+
+const (
+	// CompatibilityBackward lets consumers read data written with an older
+	// minor or patch version of their schema
+	CompatibilityBackward CompatibilityMode = "backward"
+	// CompatibilityForward lets consumers read data written with a newer
+	// minor or patch version of their schema
+	CompatibilityForward CompatibilityMode = "forward"
+	// CompatibilityFull only allows patch differences, which are compatible
+	// in both directions
+	CompatibilityFull CompatibilityMode = "full"
+	// CompatibilityNone allows any versions of the same type
+	CompatibilityNone CompatibilityMode = "none"
+)
+
+// ParseCompatibilityMode parses a compatibility mode name
+func ParseCompatibilityMode(s string) (CompatibilityMode, error) {
+	mode := CompatibilityMode(strings.ToLower(strings.TrimSpace(s)))
+	switch mode {
+	case CompatibilityBackward, CompatibilityForward, CompatibilityFull, CompatibilityNone:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown compatibility mode: %s", s)
+	}
+}
+
+// CheckCompatibility reports whether data valid for the producer schema may
+// be handed to a consumer expecting the consumer schema. Both schemas must
+// have the same type and major version. Prerelease versions and 0.x versions
+// promise no stability, so they must match exactly up to the patch number.
+func CheckCompatibility(producer, consumer types.Schema, mode CompatibilityMode) error {
+	if producer.GetType() != consumer.GetType() {
+		return fmt.Errorf("schema type %s is not compatible with %s", producer.GetType(), consumer.GetType())
+	}
+	if mode == CompatibilityNone {
+		return nil
+	}
+
+	p, err := ParseVersion(producer.GetVersion())
+	if err != nil {
+		return err
+	}
+	c, err := ParseVersion(consumer.GetVersion())
+	if err != nil {
+		return err
+	}
+
+	incompatible := func(reason string) error {
+		return fmt.Errorf("schema %s version %s is not %s compatible with version %s: %s",
+			producer.GetType(), p, mode, c, reason)
+	}
+
+	if p.Major != c.Major {
+		return incompatible("major versions differ")
+	}
+	if p.Prerelease != "" || c.Prerelease != "" {
+		if p.Compare(c) != 0 {
+			return incompatible("prerelease versions must match exactly")
+		}
+		return nil
+	}
+	if p.Major == 0 && p.Minor != c.Minor {
+		return incompatible("minor versions of 0.x schemas must match")
+	}
+
+	switch mode {
+	case CompatibilityBackward:
+		if c.Compare(p) < 0 {
+			return incompatible("consumer is older than producer")
+		}
+	case CompatibilityForward:
+		if p.Compare(c) < 0 {
+			return incompatible("producer is older than consumer")
+		}
+	case CompatibilityFull:
+		if p.Minor != c.Minor {
+			return incompatible("minor versions differ")
+		}
+	default:
+		return fmt.Errorf("unknown compatibility mode: %s", mode)
+	}
+	return nil
+}
+
+// CheckConnection reports whether an output port may be connected to an
+// input port. Ports without a data type, and inputs accepting any type, take
+// every message; otherwise the schemas must be compatible under mode.
+func CheckConnection(out, in types.PortConfig, mode CompatibilityMode) error {
+	if out.Direction != types.PortDirectionOutput {
+		return fmt.Errorf("port %s is not an output port", out.Name)
+	}
+	if in.Direction != types.PortDirectionInput {
+		return fmt.Errorf("port %s is not an input port", in.Name)
+	}
+	if out.DataType == nil || in.DataType == nil || in.DataType.GetType() == "any" {
+		return nil
+	}
+	if err := CheckCompatibility(out.DataType, in.DataType, mode); err != nil {
+		return fmt.Errorf("cannot connect port %s to %s: %w", out.Name, in.Name, err)
+	}
+	return nil
+}
+
+// compareInts returns -1, 0 or 1 as a is less than, equal to or greater than b
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// comparePrerelease orders prerelease tags by semantic version precedence: a
+// release follows its prereleases, numeric identifiers compare numerically
+// and precede alphanumeric ones
+func comparePrerelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				return compareInts(an, bn)
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInts(len(as), len(bs))
+}
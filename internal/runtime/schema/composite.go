@@ -3,6 +3,8 @@ package schema
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 
 	"flow-control/internal/types"
 )
@@ -18,30 +20,39 @@ import (
 type ArraySchema struct {
 	elementSchema types.Schema
 	version       string
+	constraints   Constraints
 }
 
-// NewArraySchema creates a schema for array validation
-func NewArraySchema(elementSchema types.Schema) types.Schema {
+// NewArraySchema creates a schema for array validation. Options add item
+// count constraints.
+func NewArraySchema(elementSchema types.Schema, opts ...Option) types.Schema {
 	return &ArraySchema{
 		elementSchema: elementSchema,
 		version:       "1.0",
+		constraints:   newConstraints(opts),
 	}
 }
 
-// Validate implements Schema.Validate for arrays
+// Validate implements Schema.Validate for arrays. Every invalid element is
+// reported in a *ValidationError.
 func (s *ArraySchema) Validate(data interface{}) error {
+	return validateAll(s, data)
+}
+
+// validatePath validates an array and its elements
+func (s *ArraySchema) validatePath(data interface{}, path string, errs *[]*FieldError) {
 	val := reflect.ValueOf(data)
 	if val.Kind() != reflect.Slice && val.Kind() != reflect.Array {
-		return fmt.Errorf("expected array, got %T", data)
+		*errs = append(*errs, &FieldError{Path: path, Message: fmt.Sprintf("expected array, got %T", data)})
+		return
+	}
+	if err := s.constraints.check(data); err != nil {
+		*errs = append(*errs, &FieldError{Path: path, Message: err.Error()})
 	}
 
 	for i := 0; i < val.Len(); i++ {
-		elem := val.Index(i).Interface()
-		if err := s.elementSchema.Validate(elem); err != nil {
-			return fmt.Errorf("invalid element at index %d: %w", i, err)
-		}
+		validateAt(s.elementSchema, val.Index(i).Interface(), pointerIndex(path, i), errs)
 	}
-	return nil
 }
 
 // GetType implements Schema.GetType
@@ -54,6 +65,16 @@ func (s *ArraySchema) GetVersion() string {
 	return s.version
 }
 
+// Elements returns the schema of the array's elements
+func (s *ArraySchema) Elements() types.Schema {
+	return s.elementSchema
+}
+
+// Constraints returns the constraints set on the schema
+func (s *ArraySchema) Constraints() Constraints {
+	return s.constraints
+}
+
 // ObjectSchema implements Schema for object types
 type ObjectSchema struct {
 	properties map[string]types.Schema
@@ -70,39 +91,50 @@ func NewObjectSchema(properties map[string]types.Schema, required []string) type
 	}
 }
 
-// Validate implements Schema.Validate for objects
+// Validate implements Schema.Validate for objects. Every missing or invalid
+// field is reported in a *ValidationError.
 func (s *ObjectSchema) Validate(data interface{}) error {
+	return validateAll(s, data)
+}
+
+// validatePath validates an object and its fields
+func (s *ObjectSchema) validatePath(data interface{}, path string, errs *[]*FieldError) {
 	val := reflect.ValueOf(data)
-	if val.Kind() != reflect.Map && val.Kind() != reflect.Struct {
-		return fmt.Errorf("expected object, got %T", data)
-	}
 
 	// Convert to map if struct
 	var m map[string]interface{}
-	if val.Kind() == reflect.Struct {
+	switch {
+	case val.Kind() == reflect.Struct:
 		m = structToMap(val)
-	} else {
-		m = data.(map[string]interface{})
+	case val.Kind() == reflect.Map && val.Type().Key().Kind() == reflect.String:
+		m = make(map[string]interface{}, val.Len())
+		iter := val.MapRange()
+		for iter.Next() {
+			m[iter.Key().String()] = iter.Value().Interface()
+		}
+	default:
+		*errs = append(*errs, &FieldError{Path: path, Message: fmt.Sprintf("expected object, got %T", data)})
+		return
 	}
 
 	// Check required fields
 	for _, req := range s.required {
 		if _, ok := m[req]; !ok {
-			return fmt.Errorf("missing required field: %s", req)
+			*errs = append(*errs, &FieldError{Path: pointerField(path, req), Message: "missing required field"})
 		}
 	}
 
-	// Validate each field
-	for name, value := range m {
-		schema, ok := s.properties[name]
-		if !ok {
-			continue // Skip unknown fields
-		}
-		if err := schema.Validate(value); err != nil {
-			return fmt.Errorf("invalid field %s: %w", name, err)
+	// Validate each field in a stable order
+	names := make([]string, 0, len(m))
+	for name := range m {
+		if _, ok := s.properties[name]; ok { // Skip unknown fields
+			names = append(names, name)
 		}
 	}
-	return nil
+	sort.Strings(names)
+	for _, name := range names {
+		validateAt(s.properties[name], m[name], pointerField(path, name), errs)
+	}
 }
 
 // GetType implements Schema.GetType
@@ -115,20 +147,195 @@ func (s *ObjectSchema) GetVersion() string {
 	return s.version
 }
 
-// Helper function to convert struct to map
+// Properties returns the schemas of the object's known fields
+func (s *ObjectSchema) Properties() map[string]types.Schema {
+	return s.properties
+}
+
+// Required returns the fields the object must have
+func (s *ObjectSchema) Required() []string {
+	return s.required
+}
+
+// structToMap converts a struct to a map keyed by json field names, as
+// FromStruct names properties. Pointers are dereferenced so that nil
+// pointers validate as null.
 func structToMap(val reflect.Value) map[string]interface{} {
 	m := make(map[string]interface{})
-	typ := val.Type()
-	for i := 0; i < val.NumField(); i++ {
-		field := typ.Field(i)
-		if field.PkgPath != "" { // Skip unexported fields
+	for _, field := range structFields(val.Type()) {
+		fieldVal, err := val.FieldByIndexErr(field.index)
+		if err != nil { // Behind a nil embedded pointer
 			continue
 		}
-		name := field.Tag.Get("json")
-		if name == "" {
-			name = field.Name
+		if fieldVal.Kind() == reflect.Ptr {
+			if fieldVal.IsNil() {
+				m[field.name] = nil
+				continue
+			}
+			fieldVal = fieldVal.Elem()
 		}
-		m[name] = val.Field(i).Interface()
+		m[field.name] = fieldVal.Interface()
 	}
 	return m
 }
+
+// MapSchema implements Schema for maps with arbitrary keys
+type MapSchema struct {
+	keySchema   types.Schema
+	valueSchema types.Schema
+	version     string
+}
+
+// NewMapSchema creates a schema for maps whose keys and values each match a
+// schema
+func NewMapSchema(keySchema, valueSchema types.Schema) types.Schema {
+	return &MapSchema{
+		keySchema:   keySchema,
+		valueSchema: valueSchema,
+		version:     "1.0",
+	}
+}
+
+// Validate implements Schema.Validate for maps. Every invalid key and value
+// is reported in a *ValidationError.
+func (s *MapSchema) Validate(data interface{}) error {
+	return validateAll(s, data)
+}
+
+// validatePath validates a map and its entries
+func (s *MapSchema) validatePath(data interface{}, path string, errs *[]*FieldError) {
+	val := reflect.ValueOf(data)
+	if val.Kind() != reflect.Map {
+		*errs = append(*errs, &FieldError{Path: path, Message: fmt.Sprintf("expected map, got %T", data)})
+		return
+	}
+
+	// Visit entries in a stable order
+	keys := val.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+	for _, key := range keys {
+		entryPath := pointerField(path, fmt.Sprint(key.Interface()))
+		if err := s.keySchema.Validate(key.Interface()); err != nil {
+			*errs = append(*errs, &FieldError{Path: entryPath, Message: "invalid key: " + err.Error()})
+		}
+		validateAt(s.valueSchema, val.MapIndex(key).Interface(), entryPath, errs)
+	}
+}
+
+// GetType implements Schema.GetType
+func (s *MapSchema) GetType() string {
+	return fmt.Sprintf("map<%s,%s>", s.keySchema.GetType(), s.valueSchema.GetType())
+}
+
+// GetVersion implements Schema.GetVersion
+func (s *MapSchema) GetVersion() string {
+	return s.version
+}
+
+// Keys returns the schema of the map's keys
+func (s *MapSchema) Keys() types.Schema {
+	return s.keySchema
+}
+
+// Values returns the schema of the map's values
+func (s *MapSchema) Values() types.Schema {
+	return s.valueSchema
+}
+
+// UnionSchema implements Schema for values matching any of several schemas
+type UnionSchema struct {
+	variants []types.Schema
+	version  string
+}
+
+// NewUnionSchema creates a schema accepting values that match at least one
+// of the given schemas
+func NewUnionSchema(variants ...types.Schema) types.Schema {
+	return &UnionSchema{
+		variants: variants,
+		version:  "1.0",
+	}
+}
+
+// Validate implements Schema.Validate for unions
+func (s *UnionSchema) Validate(data interface{}) error {
+	if len(s.variants) == 0 {
+		return fmt.Errorf("union has no variants")
+	}
+
+	problems := make([]string, 0, len(s.variants))
+	for _, variant := range s.variants {
+		err := variant.Validate(data)
+		if err == nil {
+			return nil
+		}
+		problems = append(problems, fmt.Sprintf("%s: %v", variant.GetType(), err))
+	}
+	return fmt.Errorf("value matches no variant of %s (%s)", s.GetType(), strings.Join(problems, "; "))
+}
+
+// GetType implements Schema.GetType
+func (s *UnionSchema) GetType() string {
+	names := make([]string, len(s.variants))
+	for i, variant := range s.variants {
+		names[i] = variant.GetType()
+	}
+	return fmt.Sprintf("union<%s>", strings.Join(names, "|"))
+}
+
+// GetVersion implements Schema.GetVersion
+func (s *UnionSchema) GetVersion() string {
+	return s.version
+}
+
+// Variants returns the schemas the union accepts
+func (s *UnionSchema) Variants() []types.Schema {
+	return s.variants
+}
+
+// NullableSchema implements Schema for values that may be nil
+type NullableSchema struct {
+	inner   types.Schema
+	version string
+}
+
+// NewNullableSchema creates a schema accepting nil as well as values that
+// match inner
+func NewNullableSchema(inner types.Schema) types.Schema {
+	return &NullableSchema{
+		inner:   inner,
+		version: "1.0",
+	}
+}
+
+// Validate implements Schema.Validate for nullable values
+func (s *NullableSchema) Validate(data interface{}) error {
+	if data == nil {
+		return nil
+	}
+	return s.inner.Validate(data)
+}
+
+// validatePath validates a nullable value, keeping the inner schema's paths
+func (s *NullableSchema) validatePath(data interface{}, path string, errs *[]*FieldError) {
+	if data != nil {
+		validateAt(s.inner, data, path, errs)
+	}
+}
+
+// GetType implements Schema.GetType
+func (s *NullableSchema) GetType() string {
+	return fmt.Sprintf("nullable<%s>", s.inner.GetType())
+}
+
+// GetVersion implements Schema.GetVersion
+func (s *NullableSchema) GetVersion() string {
+	return s.version
+}
+
+// Inner returns the schema non-nil values must match
+func (s *NullableSchema) Inner() types.Schema {
+	return s.inner
+}
@@ -0,0 +1,201 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"unicode/utf8"
+)
+
+// Constraints restrict the values a schema accepts beyond their Go type.
+// Each constraint applies only to values it makes sense for: lengths and
+// patterns to strings, ranges to numbers and item counts to arrays. Unset
+// constraints are nil or empty.
+type Constraints struct {
+	// MinLength and MaxLength bound a string's length in characters
+	MinLength *int
+	MaxLength *int
+
+	// Pattern is a regular expression strings must match
+	Pattern string
+
+	// Enum lists the only values accepted
+	Enum []interface{}
+
+	// Minimum and Maximum bound numbers, inclusively
+	Minimum *float64
+	Maximum *float64
+
+	// MinItems and MaxItems bound an array's length
+	MinItems *int
+	MaxItems *int
+
+	pattern *regexp.Regexp
+}
+
+// Option sets a constraint on a schema
+type Option func(*Constraints)
+
+// WithMinLength requires strings to have at least n characters
+func WithMinLength(n int) Option {
+	return func(c *Constraints) { c.MinLength = &n }
+}
+
+// WithMaxLength requires strings to have at most n characters
+func WithMaxLength(n int) Option {
+	return func(c *Constraints) { c.MaxLength = &n }
+}
+
+// WithPattern requires strings to match a regular expression. It panics if
+// the expression does not compile, like regexp.MustCompile.
+func WithPattern(expr string) Option {
+	re := regexp.MustCompile(expr)
+	return func(c *Constraints) {
+		c.Pattern = expr
+		c.pattern = re
+	}
+}
+
+// WithEnum only accepts the given values. Numbers compare by value, so
+// WithEnum(1, 2) accepts int64(1) and 2.0 alike.
+func WithEnum(values ...interface{}) Option {
+	return func(c *Constraints) { c.Enum = values }
+}
+
+// WithMinimum requires numbers to be at least min
+func WithMinimum(min float64) Option {
+	return func(c *Constraints) { c.Minimum = &min }
+}
+
+// WithMaximum requires numbers to be at most max
+func WithMaximum(max float64) Option {
+	return func(c *Constraints) { c.Maximum = &max }
+}
+
+// WithRange requires numbers to lie between min and max, inclusively
+func WithRange(min, max float64) Option {
+	return func(c *Constraints) {
+		c.Minimum = &min
+		c.Maximum = &max
+	}
+}
+
+// WithMinItems requires arrays to have at least n elements
+func WithMinItems(n int) Option {
+	return func(c *Constraints) { c.MinItems = &n }
+}
+
+// WithMaxItems requires arrays to have at most n elements
+func WithMaxItems(n int) Option {
+	return func(c *Constraints) { c.MaxItems = &n }
+}
+
+// newConstraints applies options to an empty set of constraints
+func newConstraints(opts []Option) Constraints {
+	var c Constraints
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// check validates data against every constraint that applies to it
+func (c *Constraints) check(data interface{}) error {
+	if len(c.Enum) > 0 && !inEnum(data, c.Enum) {
+		return fmt.Errorf("value %v is not one of %v", data, c.Enum)
+	}
+
+	if s, ok := data.(string); ok {
+		n := utf8.RuneCountInString(s)
+		if c.MinLength != nil && n < *c.MinLength {
+			return fmt.Errorf("length %d is less than minimum %d", n, *c.MinLength)
+		}
+		if c.MaxLength != nil && n > *c.MaxLength {
+			return fmt.Errorf("length %d exceeds maximum %d", n, *c.MaxLength)
+		}
+		if c.pattern != nil && !c.pattern.MatchString(s) {
+			return fmt.Errorf("value %q does not match pattern %s", s, c.Pattern)
+		}
+		return nil
+	}
+
+	if f, ok := toFloat(data); ok {
+		if c.Minimum != nil && f < *c.Minimum {
+			return fmt.Errorf("value %v is less than minimum %v", data, *c.Minimum)
+		}
+		if c.Maximum != nil && f > *c.Maximum {
+			return fmt.Errorf("value %v exceeds maximum %v", data, *c.Maximum)
+		}
+		return nil
+	}
+
+	if val := reflect.ValueOf(data); val.Kind() == reflect.Slice || val.Kind() == reflect.Array {
+		n := val.Len()
+		if c.MinItems != nil && n < *c.MinItems {
+			return fmt.Errorf("array has %d items, fewer than minimum %d", n, *c.MinItems)
+		}
+		if c.MaxItems != nil && n > *c.MaxItems {
+			return fmt.Errorf("array has %d items, more than maximum %d", n, *c.MaxItems)
+		}
+	}
+	return nil
+}
+
+// empty reports whether no constraint is set
+func (c *Constraints) empty() bool {
+	return c.MinLength == nil && c.MaxLength == nil && c.Pattern == "" && len(c.Enum) == 0 &&
+		c.Minimum == nil && c.Maximum == nil && c.MinItems == nil && c.MaxItems == nil
+}
+
+// inEnum reports whether data equals one of the allowed values
+func inEnum(data interface{}, values []interface{}) bool {
+	f, numeric := toFloat(data)
+	for _, v := range values {
+		if numeric {
+			if g, ok := toFloat(v); ok && f == g {
+				return true
+			}
+			continue
+		}
+		if reflect.DeepEqual(data, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// toFloat converts any Go number or json.Number to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
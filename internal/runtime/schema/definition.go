@@ -0,0 +1,351 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// Definition is a serializable description of a schema. Type names a built-in
+// type, "array", "object", "map", "union" or any type already in the
+// registry.
+type Definition struct {
+	// Type is the schema type
+	Type string `json:"type"`
+
+	// Items describes the elements of an array
+	Items *Definition `json:"items,omitempty"`
+
+	// Properties describes the fields of an object
+	Properties map[string]*Definition `json:"properties,omitempty"`
+
+	// Required lists the fields an object must have
+	Required []string `json:"required,omitempty"`
+
+	// Keys and Values describe the entries of a map; keys default to strings
+	Keys   *Definition `json:"keys,omitempty"`
+	Values *Definition `json:"values,omitempty"`
+
+	// Variants lists the schemas a union accepts
+	Variants []*Definition `json:"variants,omitempty"`
+
+	// Nullable also accepts nil values
+	Nullable bool `json:"nullable,omitempty"`
+
+	// MinLength, MaxLength, Pattern and Enum constrain strings; Enum,
+	// Minimum and Maximum constrain numbers; MinItems and MaxItems
+	// constrain arrays
+	MinLength *int          `json:"minLength,omitempty"`
+	MaxLength *int          `json:"maxLength,omitempty"`
+	Pattern   string        `json:"pattern,omitempty"`
+	Enum      []interface{} `json:"enum,omitempty"`
+	Minimum   *float64      `json:"minimum,omitempty"`
+	Maximum   *float64      `json:"maximum,omitempty"`
+	MinItems  *int          `json:"minItems,omitempty"`
+	MaxItems  *int          `json:"maxItems,omitempty"`
+
+	// Precision and Scale describe decimals, MaxSize limits the size of
+	// bytes and Layout is the time.Parse layout of timestamps
+	Precision *int   `json:"precision,omitempty"`
+	Scale     *int   `json:"scale,omitempty"`
+	MaxSize   *int   `json:"maxSize,omitempty"`
+	Layout    string `json:"layout,omitempty"`
+}
+
+// constrainedTypes builds the built-in types that accept constraints
+var constrainedTypes = map[string]func(...Option) types.Schema{
+	"string": NewStringSchema,
+	"int":    NewIntSchema,
+	"float":  NewFloatSchema,
+}
+
+// DefinedSchema is a custom schema built from a Definition. Unlike schemas
+// constructed in code it can be stored and loaded again.
+type DefinedSchema struct {
+	name       string
+	version    string
+	definition Definition
+	schema     types.Schema
+}
+
+// Validate implements Schema.Validate
+func (s *DefinedSchema) Validate(data interface{}) error {
+	return s.schema.Validate(data)
+}
+
+// validatePath validates data against the compiled schema, keeping its paths
+func (s *DefinedSchema) validatePath(data interface{}, path string, errs *[]*FieldError) {
+	validateAt(s.schema, data, path, errs)
+}
+
+// GetType implements Schema.GetType
+func (s *DefinedSchema) GetType() string {
+	return s.name
+}
+
+// GetVersion implements Schema.GetVersion
+func (s *DefinedSchema) GetVersion() string {
+	return s.version
+}
+
+// Definition returns the definition the schema was built from
+func (s *DefinedSchema) Definition() Definition {
+	return s.definition
+}
+
+// Define builds a custom schema from a definition and registers it under the
+// given type name and version
+func (r *SchemaRegistry) Define(name, version string, def Definition) (*DefinedSchema, error) {
+	schema, err := r.newDefinedSchema(name, version, def)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Register(schema); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// newDefinedSchema compiles a definition into a named schema
+func (r *SchemaRegistry) newDefinedSchema(name, version string, def Definition) (*DefinedSchema, error) {
+	if name == "" || version == "" {
+		return nil, fmt.Errorf("%w: schema type and version cannot be empty", ErrInvalidDefinition)
+	}
+
+	compiled, err := r.Compile(def)
+	if err != nil {
+		return nil, fmt.Errorf("%w for schema %s: %v", ErrInvalidDefinition, name, err)
+	}
+
+	return &DefinedSchema{
+		name:       name,
+		version:    version,
+		definition: def,
+		schema:     compiled,
+	}, nil
+}
+
+// Compile turns a definition into a schema, resolving named types against
+// the latest registered version
+func (r *SchemaRegistry) Compile(def Definition) (types.Schema, error) {
+	compiled, err := r.compileType(def)
+	if err != nil || !def.Nullable {
+		return compiled, err
+	}
+	return NewNullableSchema(compiled), nil
+}
+
+// compileType compiles a definition, ignoring whether it is nullable
+func (r *SchemaRegistry) compileType(def Definition) (types.Schema, error) {
+	switch def.Type {
+	case "":
+		return nil, fmt.Errorf("definition has no type")
+	case "array":
+		if def.Items == nil {
+			return nil, fmt.Errorf("array definition has no items")
+		}
+		items, err := r.Compile(*def.Items)
+		if err != nil {
+			return nil, fmt.Errorf("invalid array items: %w", err)
+		}
+		opts, err := def.options()
+		if err != nil {
+			return nil, err
+		}
+		return NewArraySchema(items, opts...), nil
+	case "object":
+		if def.constrained() {
+			return nil, fmt.Errorf("constraints do not apply to objects")
+		}
+		properties := make(map[string]types.Schema, len(def.Properties))
+		for name, prop := range def.Properties {
+			if prop == nil {
+				return nil, fmt.Errorf("property %s has no definition", name)
+			}
+			compiled, err := r.Compile(*prop)
+			if err != nil {
+				return nil, fmt.Errorf("invalid property %s: %w", name, err)
+			}
+			properties[name] = compiled
+		}
+		return NewObjectSchema(properties, def.Required), nil
+	case "map":
+		if def.constrained() {
+			return nil, fmt.Errorf("constraints do not apply to maps")
+		}
+		if def.Values == nil {
+			return nil, fmt.Errorf("map definition has no values")
+		}
+		keys := NewStringSchema()
+		if def.Keys != nil {
+			var err error
+			if keys, err = r.Compile(*def.Keys); err != nil {
+				return nil, fmt.Errorf("invalid map keys: %w", err)
+			}
+		}
+		values, err := r.Compile(*def.Values)
+		if err != nil {
+			return nil, fmt.Errorf("invalid map values: %w", err)
+		}
+		return NewMapSchema(keys, values), nil
+	case "union":
+		if def.constrained() {
+			return nil, fmt.Errorf("constraints do not apply to unions")
+		}
+		if len(def.Variants) == 0 {
+			return nil, fmt.Errorf("union definition has no variants")
+		}
+		variants := make([]types.Schema, len(def.Variants))
+		for i, variant := range def.Variants {
+			if variant == nil {
+				return nil, fmt.Errorf("union variant %d has no definition", i)
+			}
+			compiled, err := r.Compile(*variant)
+			if err != nil {
+				return nil, fmt.Errorf("invalid union variant %d: %w", i, err)
+			}
+			variants[i] = compiled
+		}
+		return NewUnionSchema(variants...), nil
+	case "decimal", "bytes", "timestamp":
+		if def.constrained() {
+			return nil, fmt.Errorf("constraints do not apply to type %s", def.Type)
+		}
+		return r.compilePrimitive(def)
+	default:
+		if def.parameterized() {
+			return nil, fmt.Errorf("precision, scale, maxSize and layout do not apply to type %s", def.Type)
+		}
+		if !def.constrained() {
+			return r.GetLatest(def.Type)
+		}
+		build, ok := constrainedTypes[def.Type]
+		if !ok {
+			return nil, fmt.Errorf("constraints do not apply to type %s", def.Type)
+		}
+		opts, err := def.options()
+		if err != nil {
+			return nil, err
+		}
+		return build(opts...), nil
+	}
+}
+
+// compilePrimitive compiles a decimal, bytes or timestamp definition. Without
+// parameters the registered built-in is used.
+func (r *SchemaRegistry) compilePrimitive(def Definition) (types.Schema, error) {
+	if !def.parameterized() {
+		return r.GetLatest(def.Type)
+	}
+
+	switch def.Type {
+	case "decimal":
+		if def.MaxSize != nil || def.Layout != "" {
+			return nil, fmt.Errorf("decimals only take precision and scale")
+		}
+		precision, scale := DefaultDecimalPrecision, 0
+		if def.Precision != nil {
+			precision = *def.Precision
+		}
+		if def.Scale != nil {
+			scale = *def.Scale
+		}
+		if err := checkDecimal(precision, scale); err != nil {
+			return nil, err
+		}
+		return NewDecimalSchema(precision, scale), nil
+	case "bytes":
+		if def.Precision != nil || def.Scale != nil || def.Layout != "" {
+			return nil, fmt.Errorf("bytes only take maxSize")
+		}
+		if def.MaxSize == nil || *def.MaxSize < 0 {
+			return nil, fmt.Errorf("bytes maxSize cannot be negative")
+		}
+		return NewBytesSchema(*def.MaxSize), nil
+	default:
+		if def.Precision != nil || def.Scale != nil || def.MaxSize != nil {
+			return nil, fmt.Errorf("timestamps only take a layout")
+		}
+		// A layout must parse what it formats
+		reference := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+		if _, err := time.Parse(def.Layout, reference.Format(def.Layout)); err != nil {
+			return nil, fmt.Errorf("invalid timestamp layout %q: %w", def.Layout, err)
+		}
+		return NewTimestampSchema(def.Layout), nil
+	}
+}
+
+// parameterized reports whether the definition sets a decimal, bytes or
+// timestamp parameter
+func (d Definition) parameterized() bool {
+	return d.Precision != nil || d.Scale != nil || d.MaxSize != nil || d.Layout != ""
+}
+
+// constrained reports whether the definition sets any constraint
+func (d Definition) constrained() bool {
+	c := Constraints{
+		MinLength: d.MinLength,
+		MaxLength: d.MaxLength,
+		Pattern:   d.Pattern,
+		Enum:      d.Enum,
+		Minimum:   d.Minimum,
+		Maximum:   d.Maximum,
+		MinItems:  d.MinItems,
+		MaxItems:  d.MaxItems,
+	}
+	return !c.empty()
+}
+
+// options converts the definition's constraints to schema options
+func (d Definition) options() ([]Option, error) {
+	var opts []Option
+	if d.MinLength != nil {
+		opts = append(opts, WithMinLength(*d.MinLength))
+	}
+	if d.MaxLength != nil {
+		opts = append(opts, WithMaxLength(*d.MaxLength))
+	}
+	if d.Pattern != "" {
+		if _, err := regexp.Compile(d.Pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		opts = append(opts, WithPattern(d.Pattern))
+	}
+	if len(d.Enum) > 0 {
+		opts = append(opts, WithEnum(d.Enum...))
+	}
+	if d.Minimum != nil {
+		opts = append(opts, WithMinimum(*d.Minimum))
+	}
+	if d.Maximum != nil {
+		opts = append(opts, WithMaximum(*d.Maximum))
+	}
+	if d.MinItems != nil {
+		opts = append(opts, WithMinItems(*d.MinItems))
+	}
+	if d.MaxItems != nil {
+		opts = append(opts, WithMaxItems(*d.MaxItems))
+	}
+	return opts, nil
+}
+
+// MarshalDefinition encodes a definition as JSON
+func MarshalDefinition(def Definition) (string, error) {
+	data, err := json.Marshal(def)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode schema definition: %w", err)
+	}
+	return string(data), nil
+}
+
+// UnmarshalDefinition decodes a JSON definition
+func UnmarshalDefinition(data string) (Definition, error) {
+	var def Definition
+	if err := json.Unmarshal([]byte(data), &def); err != nil {
+		return Definition{}, fmt.Errorf("failed to decode schema definition: %w", err)
+	}
+	return def, nil
+}
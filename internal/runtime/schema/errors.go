@@ -0,0 +1,100 @@
+package schema
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"flow-control/internal/types"
+)
+
+// FieldError is a single validation failure
+type FieldError struct {
+	// Path is a JSON pointer to the offending value; the root is ""
+	Path string `json:"path"`
+
+	// Message describes the failure
+	Message string `json:"message"`
+}
+
+// Error implements the error interface
+func (e *FieldError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// ValidationError collects every failure found while validating a value.
+// Composite schemas return it so that all bad fields are reported at once.
+type ValidationError struct {
+	Errors []*FieldError `json:"errors"`
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		messages[i] = fieldErr.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns the individual field errors
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		errs[i] = fieldErr
+	}
+	return errs
+}
+
+// pathValidator is implemented by schemas that report failures below the
+// value they validate
+type pathValidator interface {
+	validatePath(data interface{}, path string, errs *[]*FieldError)
+}
+
+// validateAt validates data against a schema and records failures under path
+func validateAt(s types.Schema, data interface{}, path string, errs *[]*FieldError) {
+	if pv, ok := s.(pathValidator); ok {
+		pv.validatePath(data, path, errs)
+		return
+	}
+
+	err := s.Validate(data)
+	if err == nil {
+		return
+	}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		for _, fieldErr := range validationErr.Errors {
+			*errs = append(*errs, &FieldError{Path: path + fieldErr.Path, Message: fieldErr.Message})
+		}
+		return
+	}
+	*errs = append(*errs, &FieldError{Path: path, Message: err.Error()})
+}
+
+// validateAll runs a path validator from the root and returns the collected
+// failures, or nil
+func validateAll(pv pathValidator, data interface{}) error {
+	var errs []*FieldError
+	pv.validatePath(data, "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: errs}
+}
+
+// pointerField appends an object field to a JSON pointer
+func pointerField(path, name string) string {
+	name = strings.ReplaceAll(name, "~", "~0")
+	name = strings.ReplaceAll(name, "/", "~1")
+	return path + "/" + name
+}
+
+// pointerIndex appends an array index to a JSON pointer
+func pointerIndex(path string, i int) string {
+	return path + "/" + strconv.Itoa(i)
+}
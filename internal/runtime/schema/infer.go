@@ -0,0 +1,142 @@
+package schema
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+
+	"flow-control/internal/types"
+)
+
+// Infer derives a schema that accepts every sample, with samples holding
+// values as decoded by encoding/json; decoding with UseNumber keeps the
+// samples valid against the result. Numbers are ints while every sample
+// is integral and floats otherwise; object fields are required when every
+// sample object has them; values seen with several types become unions and
+// nulls make them nullable. Without samples, or with only nulls, the
+// result accepts anything.
+func Infer(samples ...interface{}) types.Schema {
+	shape := &inferredShape{}
+	for _, sample := range samples {
+		shape.add(sample)
+	}
+	return shape.schema()
+}
+
+// inferredShape accumulates the types seen at one position of the samples
+type inferredShape struct {
+	null    bool
+	scalars map[string]bool
+	// objects counts the objects seen, fields the objects each field was
+	// seen in
+	objects int
+	fields  map[string]*inferredShape
+	counts  map[string]int
+	// arrays is set once an array was seen, elements merges their elements
+	arrays   bool
+	elements *inferredShape
+}
+
+// add merges the type of v into the shape
+func (s *inferredShape) add(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		s.null = true
+	case bool:
+		s.addScalar("bool")
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			s.addScalar("int")
+		} else {
+			s.addScalar("float")
+		}
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			s.addScalar("int")
+		} else {
+			s.addScalar("float")
+		}
+	case string:
+		s.addScalar("string")
+	case []interface{}:
+		s.arrays = true
+		if s.elements == nil {
+			s.elements = &inferredShape{}
+		}
+		for _, el := range v {
+			s.elements.add(el)
+		}
+	case map[string]interface{}:
+		if s.fields == nil {
+			s.fields = map[string]*inferredShape{}
+			s.counts = map[string]int{}
+		}
+		s.objects++
+		for name, field := range v {
+			if s.fields[name] == nil {
+				s.fields[name] = &inferredShape{}
+			}
+			s.fields[name].add(field)
+			s.counts[name]++
+		}
+	default:
+		s.addScalar("any")
+	}
+}
+
+func (s *inferredShape) addScalar(name string) {
+	if s.scalars == nil {
+		s.scalars = map[string]bool{}
+	}
+	s.scalars[name] = true
+}
+
+// schema converts the shape into a schema
+func (s *inferredShape) schema() types.Schema {
+	var variants []types.Schema
+	// Ints widen to floats
+	if s.scalars["int"] && !s.scalars["float"] {
+		variants = append(variants, NewIntSchema())
+	}
+	if s.scalars["float"] {
+		variants = append(variants, NewFloatSchema())
+	}
+	if s.scalars["string"] {
+		variants = append(variants, NewStringSchema())
+	}
+	if s.scalars["bool"] {
+		variants = append(variants, NewBoolSchema())
+	}
+	if s.arrays {
+		variants = append(variants, NewArraySchema(s.elements.schema()))
+	}
+	if s.objects > 0 {
+		properties := make(map[string]types.Schema, len(s.fields))
+		var required []string
+		for name, field := range s.fields {
+			properties[name] = field.schema()
+			if s.counts[name] == s.objects {
+				required = append(required, name)
+			}
+		}
+		sort.Strings(required)
+		variants = append(variants, NewObjectSchema(properties, required))
+	}
+	if s.scalars["any"] {
+		variants = []types.Schema{NewAnySchema()}
+	}
+
+	var schema types.Schema
+	switch len(variants) {
+	case 0:
+		return NewNullableSchema(NewAnySchema())
+	case 1:
+		schema = variants[0]
+	default:
+		schema = NewUnionSchema(variants...)
+	}
+	if s.null {
+		schema = NewNullableSchema(schema)
+	}
+	return schema
+}
@@ -0,0 +1,434 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// JSONSchemaDialect is the JSON Schema draft declared by exported documents
+const JSONSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchemaTypes maps built-in schema types to JSON Schema types
+var jsonSchemaTypes = map[string]string{
+	"string": "string",
+	"int":    "integer",
+	"float":  "number",
+	"bool":   "boolean",
+}
+
+// jsonSchema is the subset of a JSON Schema document the registry
+// understands
+type jsonSchema struct {
+	Schema     string                 `json:"$schema,omitempty"`
+	Title      string                 `json:"title,omitempty"`
+	Type       json.RawMessage        `json:"type,omitempty"`
+	Format     string                 `json:"format,omitempty"`
+	Items      *jsonSchema            `json:"items,omitempty"`
+	Properties map[string]*jsonSchema `json:"properties,omitempty"`
+	Required   []string               `json:"required,omitempty"`
+	MinLength  *int                   `json:"minLength,omitempty"`
+	MaxLength  *int                   `json:"maxLength,omitempty"`
+	Pattern    string                 `json:"pattern,omitempty"`
+	Enum       []interface{}          `json:"enum,omitempty"`
+	Minimum    *float64               `json:"minimum,omitempty"`
+	Maximum    *float64               `json:"maximum,omitempty"`
+	MinItems   *int                   `json:"minItems,omitempty"`
+	MaxItems   *int                   `json:"maxItems,omitempty"`
+	AnyOf      []*jsonSchema          `json:"anyOf,omitempty"`
+
+	// AdditionalProperties and PropertyNames describe maps
+	AdditionalProperties json.RawMessage `json:"additionalProperties,omitempty"`
+	PropertyNames        *jsonSchema     `json:"propertyNames,omitempty"`
+
+	// ContentEncoding marks base64 byte payloads
+	ContentEncoding string `json:"contentEncoding,omitempty"`
+
+	// Extension keywords describing decimals, byte payloads and timestamps,
+	// which JSON Schema has no keywords for
+	Precision *int   `json:"x-precision,omitempty"`
+	Scale     *int   `json:"x-scale,omitempty"`
+	MaxBytes  *int   `json:"x-maxBytes,omitempty"`
+	Layout    string `json:"x-layout,omitempty"`
+
+	// Keywords that are recognized only to be rejected
+	Ref   string            `json:"$ref,omitempty"`
+	AllOf []json.RawMessage `json:"allOf,omitempty"`
+	OneOf []json.RawMessage `json:"oneOf,omitempty"`
+}
+
+// jsonNull is the JSON Schema type of null values
+const jsonNull = `"null"`
+
+// ToJSONSchema converts a schema to a JSON Schema document
+func ToJSONSchema(s types.Schema) ([]byte, error) {
+	doc, err := toJSONSchema(s)
+	if err != nil {
+		return nil, err
+	}
+	doc.Schema = JSONSchemaDialect
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode JSON Schema: %w", err)
+	}
+	return data, nil
+}
+
+// ExportJSONSchema returns a registered schema as a JSON Schema document
+func (r *SchemaRegistry) ExportJSONSchema(schemaType, version string) ([]byte, error) {
+	s, err := r.Get(schemaType, version)
+	if err != nil {
+		return nil, err
+	}
+	return ToJSONSchema(s)
+}
+
+// ImportJSONSchema converts a JSON Schema document to a definition and
+// registers it under the given type name and version
+func (r *SchemaRegistry) ImportJSONSchema(name, version string, data []byte) (*DefinedSchema, error) {
+	def, err := ParseJSONSchema(data)
+	if err != nil {
+		return nil, err
+	}
+	return r.Define(name, version, def)
+}
+
+// ParseJSONSchema converts a JSON Schema document to a definition. Only the
+// keywords that map onto the registry's schema types are supported: anyOf
+// becomes a union, a null alternative makes a schema nullable and objects
+// described only by additionalProperties become maps. Strings with format
+// decimal, base64 content or an x-layout become decimals, bytes and
+// timestamps. References, allOf and oneOf are rejected.
+func ParseJSONSchema(data []byte) (Definition, error) {
+	var doc jsonSchema
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return Definition{}, fmt.Errorf("failed to decode JSON Schema: %w", err)
+	}
+	return doc.definition("#")
+}
+
+// toJSONSchema converts a schema to an unencoded JSON Schema document
+func toJSONSchema(s types.Schema) (*jsonSchema, error) {
+	switch v := s.(type) {
+	case *DefinedSchema:
+		doc, err := toJSONSchema(v.schema)
+		if err != nil {
+			return nil, err
+		}
+		doc.Title = v.name
+		return doc, nil
+
+	case *BasicSchema:
+		doc := &jsonSchema{}
+		switch v.schemaType {
+		case "any":
+		case "time":
+			doc.Type = json.RawMessage(`"string"`)
+			doc.Format = "date-time"
+		default:
+			jsonType, ok := jsonSchemaTypes[v.schemaType]
+			if !ok {
+				return nil, fmt.Errorf("schema type %s has no JSON Schema equivalent", v.schemaType)
+			}
+			doc.Type = json.RawMessage(`"` + jsonType + `"`)
+		}
+		doc.setConstraints(v.constraints)
+		return doc, nil
+
+	case *DecimalSchema:
+		precision, scale := v.precision, v.scale
+		return &jsonSchema{
+			Type:      json.RawMessage(`"string"`),
+			Format:    "decimal",
+			Precision: &precision,
+			Scale:     &scale,
+		}, nil
+
+	case *BytesSchema:
+		doc := &jsonSchema{Type: json.RawMessage(`"string"`), ContentEncoding: "base64"}
+		if v.maxSize > 0 {
+			maxSize := v.maxSize
+			doc.MaxBytes = &maxSize
+		}
+		return doc, nil
+
+	case *TimestampSchema:
+		doc := &jsonSchema{Type: json.RawMessage(`"string"`), Layout: v.layout}
+		if v.layout == time.RFC3339 || v.layout == time.RFC3339Nano {
+			doc.Format = "date-time"
+		}
+		return doc, nil
+
+	case *ArraySchema:
+		items, err := toJSONSchema(v.elementSchema)
+		if err != nil {
+			return nil, err
+		}
+		doc := &jsonSchema{Type: json.RawMessage(`"array"`), Items: items}
+		doc.setConstraints(v.constraints)
+		return doc, nil
+
+	case *ObjectSchema:
+		doc := &jsonSchema{
+			Type:       json.RawMessage(`"object"`),
+			Properties: make(map[string]*jsonSchema, len(v.properties)),
+			Required:   v.required,
+		}
+		for name, prop := range v.properties {
+			converted, err := toJSONSchema(prop)
+			if err != nil {
+				return nil, fmt.Errorf("property %s: %w", name, err)
+			}
+			doc.Properties[name] = converted
+		}
+		return doc, nil
+
+	case *MapSchema:
+		values, err := toJSONSchema(v.valueSchema)
+		if err != nil {
+			return nil, err
+		}
+		encoded, err := json.Marshal(values)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode map values: %w", err)
+		}
+		doc := &jsonSchema{Type: json.RawMessage(`"object"`), AdditionalProperties: encoded}
+
+		// JSON object keys are always strings, so only string key schemas
+		// can be expressed, through propertyNames when constrained
+		keys, err := toJSONSchema(v.keySchema)
+		if err != nil {
+			return nil, err
+		}
+		if string(keys.Type) != `"string"` || keys.Format != "" {
+			return nil, fmt.Errorf("map keys of type %s cannot be converted to JSON Schema", v.keySchema.GetType())
+		}
+		if !reflect.DeepEqual(keys, &jsonSchema{Type: keys.Type}) {
+			doc.PropertyNames = keys
+		}
+		return doc, nil
+
+	case *UnionSchema:
+		doc := &jsonSchema{AnyOf: make([]*jsonSchema, len(v.variants))}
+		for i, variant := range v.variants {
+			converted, err := toJSONSchema(variant)
+			if err != nil {
+				return nil, err
+			}
+			doc.AnyOf[i] = converted
+		}
+		return doc, nil
+
+	case *NullableSchema:
+		inner, err := toJSONSchema(v.inner)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonSchema{AnyOf: []*jsonSchema{inner, {Type: json.RawMessage(jsonNull)}}}, nil
+
+	default:
+		return nil, fmt.Errorf("schema %s of kind %T cannot be converted to JSON Schema", s.GetType(), s)
+	}
+}
+
+// setConstraints copies schema constraints onto a JSON Schema document
+func (d *jsonSchema) setConstraints(c Constraints) {
+	d.MinLength = c.MinLength
+	d.MaxLength = c.MaxLength
+	d.Pattern = c.Pattern
+	d.Enum = c.Enum
+	d.Minimum = c.Minimum
+	d.Maximum = c.Maximum
+	d.MinItems = c.MinItems
+	d.MaxItems = c.MaxItems
+}
+
+// definition converts a JSON Schema document to a definition. path locates
+// the document within its root for error messages.
+func (d *jsonSchema) definition(path string) (Definition, error) {
+	switch {
+	case d.Ref != "":
+		return Definition{}, fmt.Errorf("%s: $ref is not supported", path)
+	case len(d.AllOf) > 0 || len(d.OneOf) > 0:
+		return Definition{}, fmt.Errorf("%s: allOf and oneOf are not supported", path)
+	case len(d.AnyOf) > 0:
+		return d.anyOfDefinition(path)
+	}
+
+	jsonType, nullable, err := d.jsonType(path)
+	if err != nil {
+		return Definition{}, err
+	}
+
+	def := Definition{
+		MinLength: d.MinLength,
+		MaxLength: d.MaxLength,
+		Pattern:   d.Pattern,
+		Enum:      d.Enum,
+		Minimum:   d.Minimum,
+		Maximum:   d.Maximum,
+		MinItems:  d.MinItems,
+		MaxItems:  d.MaxItems,
+	}
+
+	switch jsonType {
+	case "":
+		def.Type = "any"
+	case "string":
+		switch {
+		case d.Format == "decimal":
+			def.Type = "decimal"
+			def.Precision = d.Precision
+			def.Scale = d.Scale
+		case d.ContentEncoding == "base64":
+			def.Type = "bytes"
+			def.MaxSize = d.MaxBytes
+		case d.Layout != "":
+			def.Type = "timestamp"
+			def.Layout = d.Layout
+		case d.Format == "date-time":
+			def.Type = "time"
+		default:
+			def.Type = "string"
+		}
+	case "integer":
+		def.Type = "int"
+	case "number":
+		def.Type = "float"
+	case "boolean":
+		def.Type = "bool"
+	case "array":
+		def.Type = "array"
+		items := &jsonSchema{}
+		if d.Items != nil {
+			items = d.Items
+		}
+		converted, err := items.definition(path + "/items")
+		if err != nil {
+			return Definition{}, err
+		}
+		def.Items = &converted
+	case "object":
+		if len(d.Properties) == 0 && isSchemaObject(d.AdditionalProperties) {
+			return d.mapDefinition(path, def)
+		}
+		def.Type = "object"
+		def.Required = d.Required
+		def.Properties = make(map[string]*Definition, len(d.Properties))
+		for name, prop := range d.Properties {
+			if prop == nil {
+				return Definition{}, fmt.Errorf("%s/properties/%s: property has no schema", path, name)
+			}
+			converted, err := prop.definition(path + "/properties/" + name)
+			if err != nil {
+				return Definition{}, err
+			}
+			def.Properties[name] = &converted
+		}
+	default:
+		return Definition{}, fmt.Errorf("%s: unsupported type %s", path, jsonType)
+	}
+
+	def.Nullable = nullable
+	return def, nil
+}
+
+// jsonType returns the document's type and whether null is also allowed.
+// Besides a single type name, only a type paired with "null" is accepted.
+func (d *jsonSchema) jsonType(path string) (string, bool, error) {
+	if len(d.Type) == 0 {
+		return "", false, nil
+	}
+
+	var jsonType string
+	if err := json.Unmarshal(d.Type, &jsonType); err == nil {
+		if jsonType == "null" {
+			return "", false, fmt.Errorf("%s: null is only supported as an alternative to another type", path)
+		}
+		return jsonType, false, nil
+	}
+
+	var pair []string
+	if err := json.Unmarshal(d.Type, &pair); err == nil && len(pair) == 2 {
+		switch {
+		case pair[0] == "null" && pair[1] != "null":
+			return pair[1], true, nil
+		case pair[1] == "null" && pair[0] != "null":
+			return pair[0], true, nil
+		}
+	}
+	return "", false, fmt.Errorf("%s: type must be a single type, optionally paired with null; use anyOf for unions", path)
+}
+
+// anyOfDefinition converts an anyOf document to a union, or to a nullable
+// schema when one alternative is null
+func (d *jsonSchema) anyOfDefinition(path string) (Definition, error) {
+	if len(d.Type) > 0 {
+		return Definition{}, fmt.Errorf("%s: type cannot be combined with anyOf", path)
+	}
+
+	nullable := false
+	var variants []*Definition
+	for i, alternative := range d.AnyOf {
+		if alternative == nil {
+			return Definition{}, fmt.Errorf("%s/anyOf/%d: alternative has no schema", path, i)
+		}
+		if string(alternative.Type) == jsonNull {
+			nullable = true
+			continue
+		}
+		converted, err := alternative.definition(fmt.Sprintf("%s/anyOf/%d", path, i))
+		if err != nil {
+			return Definition{}, err
+		}
+		variants = append(variants, &converted)
+	}
+
+	var def Definition
+	switch len(variants) {
+	case 0:
+		return Definition{}, fmt.Errorf("%s: anyOf has no alternative besides null", path)
+	case 1:
+		def = *variants[0]
+	default:
+		def = Definition{Type: "union", Variants: variants}
+	}
+	def.Nullable = def.Nullable || nullable
+	return def, nil
+}
+
+// mapDefinition converts an object document described only by
+// additionalProperties to a map
+func (d *jsonSchema) mapDefinition(path string, def Definition) (Definition, error) {
+	var values jsonSchema
+	if err := json.Unmarshal(d.AdditionalProperties, &values); err != nil {
+		return Definition{}, fmt.Errorf("%s/additionalProperties: %w", path, err)
+	}
+	converted, err := values.definition(path + "/additionalProperties")
+	if err != nil {
+		return Definition{}, err
+	}
+
+	def.Type = "map"
+	def.Values = &converted
+	if d.PropertyNames != nil {
+		keys, err := d.PropertyNames.definition(path + "/propertyNames")
+		if err != nil {
+			return Definition{}, err
+		}
+		def.Keys = &keys
+	}
+	return def, nil
+}
+
+// isSchemaObject reports whether a raw keyword value is a schema rather than
+// a boolean
+func isSchemaObject(raw json.RawMessage) bool {
+	trimmed := strings.TrimSpace(string(raw))
+	return strings.HasPrefix(trimmed, "{")
+}
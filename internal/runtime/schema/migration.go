@@ -0,0 +1,192 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"flow-control/internal/types"
+)
+
+// MigrationFunc upgrades a value from one schema version to the next. Values
+// are in their decoded JSON form, so objects are map[string]interface{} and,
+// for messages, numbers are json.Number. Functions must not modify their
+// input.
+type MigrationFunc func(data interface{}) (interface{}, error)
+
+// migration is a registered upgrade step
+type migration struct {
+	to string
+	fn MigrationFunc
+}
+
+// RegisterMigration registers the upgrade of a schema type from one version
+// to a later one. Both versions must be registered and each version can be
+// upgraded by only one migration.
+func (r *SchemaRegistry) RegisterMigration(schemaType, from, to string, fn MigrationFunc) error {
+	if fn == nil {
+		return fmt.Errorf("migration function cannot be nil")
+	}
+	if compareVersions(from, to) >= 0 {
+		return fmt.Errorf("migration of schema %s must go from an older to a newer version, got %s to %s", schemaType, from, to)
+	}
+	if _, err := r.Get(schemaType, from); err != nil {
+		return err
+	}
+	if _, err := r.Get(schemaType, to); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.migrations[schemaType]; !ok {
+		r.migrations[schemaType] = make(map[string]migration)
+	}
+	if existing, ok := r.migrations[schemaType][from]; ok {
+		return fmt.Errorf("schema %s version %s already migrates to %s", schemaType, from, existing.to)
+	}
+
+	r.migrations[schemaType][from] = migration{to: to, fn: fn}
+	return nil
+}
+
+// Migrate upgrades a value of a schema type from one version to another by
+// chaining registered migrations, and validates the result against the
+// target version
+func (r *SchemaRegistry) Migrate(schemaType, from, to string, data interface{}) (interface{}, error) {
+	validate, err := r.Validator(schemaType, to)
+	if err != nil {
+		return nil, err
+	}
+	if from == to {
+		return data, nil
+	}
+	if compareVersions(from, to) > 0 {
+		return nil, fmt.Errorf("cannot migrate schema %s from %s down to %s", schemaType, from, to)
+	}
+
+	for current := from; current != to; {
+		r.mu.RLock()
+		step, ok := r.migrations[schemaType][current]
+		r.mu.RUnlock()
+		if !ok || compareVersions(step.to, to) > 0 {
+			return nil, fmt.Errorf("no migration path for schema %s from %s to %s", schemaType, current, to)
+		}
+
+		if data, err = step.fn(data); err != nil {
+			return nil, fmt.Errorf("failed to migrate schema %s from %s to %s: %w", schemaType, current, step.to, err)
+		}
+		current = step.to
+	}
+
+	if err := validate(data); err != nil {
+		return nil, fmt.Errorf("migrated value does not match schema %s version %s: %w", schemaType, to, err)
+	}
+	return data, nil
+}
+
+// MigrateMessage upgrades a message produced against an older version of the
+// target schema so that ports can accept it. Messages already at the target
+// version are returned unchanged; messages of another schema type are
+// rejected.
+func (r *SchemaRegistry) MigrateMessage(msg types.Message, target types.Schema) (types.Message, error) {
+	if msg.Schema == nil {
+		return msg, fmt.Errorf("message %s has no schema", msg.ID)
+	}
+	if msg.Schema.GetType() != target.GetType() {
+		return msg, fmt.Errorf("message %s has schema %s, expected %s", msg.ID, msg.Schema.GetType(), target.GetType())
+	}
+	if msg.Schema.GetVersion() == target.GetVersion() {
+		return msg, nil
+	}
+
+	// Numbers stay json.Number so they validate as ints or floats and are
+	// re-encoded exactly
+	var data interface{}
+	decoder := json.NewDecoder(bytes.NewReader(msg.Data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return msg, fmt.Errorf("failed to decode message %s: %w", msg.ID, err)
+	}
+	migrated, err := r.Migrate(target.GetType(), msg.Schema.GetVersion(), target.GetVersion(), data)
+	if err != nil {
+		return msg, err
+	}
+	encoded, err := json.Marshal(migrated)
+	if err != nil {
+		return msg, fmt.Errorf("failed to encode message %s: %w", msg.ID, err)
+	}
+
+	msg.Schema = target
+	msg.Data = encoded
+	return msg, nil
+}
+
+// RenameField returns a migration that renames an object field
+func RenameField(from, to string) MigrationFunc {
+	return func(data interface{}) (interface{}, error) {
+		m, err := copyObject(data)
+		if err != nil {
+			return nil, err
+		}
+		if value, ok := m[from]; ok {
+			delete(m, from)
+			m[to] = value
+		}
+		return m, nil
+	}
+}
+
+// DefaultField returns a migration that sets an object field to value when
+// it is missing
+func DefaultField(name string, value interface{}) MigrationFunc {
+	return func(data interface{}) (interface{}, error) {
+		m, err := copyObject(data)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := m[name]; !ok {
+			m[name] = value
+		}
+		return m, nil
+	}
+}
+
+// RemoveField returns a migration that drops an object field
+func RemoveField(name string) MigrationFunc {
+	return func(data interface{}) (interface{}, error) {
+		m, err := copyObject(data)
+		if err != nil {
+			return nil, err
+		}
+		delete(m, name)
+		return m, nil
+	}
+}
+
+// ChainMigrations returns a migration applying each function in turn
+func ChainMigrations(fns ...MigrationFunc) MigrationFunc {
+	return func(data interface{}) (interface{}, error) {
+		var err error
+		for _, fn := range fns {
+			if data, err = fn(data); err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	}
+}
+
+// copyObject returns a shallow copy of an object value
+func copyObject(data interface{}) (map[string]interface{}, error) {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected object, got %T", data)
+	}
+	copied := make(map[string]interface{}, len(m)+1)
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied, nil
+}
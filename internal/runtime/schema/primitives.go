@@ -0,0 +1,269 @@
+package schema
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// Defaults of the decimal, bytes and timestamp built-ins
+const (
+	DefaultDecimalPrecision = 38
+	DefaultDecimalScale     = 18
+	DefaultTimestampLayout  = time.RFC3339Nano
+)
+
+// decimalPattern matches decimal numbers without exponents
+var decimalPattern = regexp.MustCompile(`^[+-]?([0-9]+)(?:\.([0-9]+))?$`)
+
+// DecimalSchema implements Schema for exact decimal numbers. Values are
+// strings, or json.Number values from a decoder using UseNumber, so that no
+// precision is lost to floating point.
+type DecimalSchema struct {
+	precision int
+	scale     int
+	version   string
+}
+
+// NewDecimalSchema creates a schema for decimals with at most precision
+// significant digits, up to scale of them after the decimal point and up to
+// precision-scale before it, as in SQL DECIMAL(precision, scale). It panics if
+// precision is not positive or scale is outside 0..precision, like
+// regexp.MustCompile.
+func NewDecimalSchema(precision, scale int) types.Schema {
+	if err := checkDecimal(precision, scale); err != nil {
+		panic(err)
+	}
+	return &DecimalSchema{
+		precision: precision,
+		scale:     scale,
+		version:   "1.0",
+	}
+}
+
+// Validate implements Schema.Validate for decimals
+func (s *DecimalSchema) Validate(data interface{}) error {
+	text, ok := decimalText(data)
+	if !ok {
+		return fmt.Errorf("expected decimal string, got %T", data)
+	}
+	match := decimalPattern.FindStringSubmatch(text)
+	if match == nil {
+		return fmt.Errorf("invalid decimal %q", text)
+	}
+
+	integer, fraction := strings.TrimLeft(match[1], "0"), match[2]
+	if len(fraction) > s.scale {
+		return fmt.Errorf("decimal %s has %d digits after the point, more than scale %d", text, len(fraction), s.scale)
+	}
+	if len(integer) > s.precision-s.scale {
+		return fmt.Errorf("decimal %s has %d digits before the point, more than precision %d less scale %d allows",
+			text, len(integer), s.precision, s.scale)
+	}
+	return nil
+}
+
+// GetType implements Schema.GetType
+func (s *DecimalSchema) GetType() string {
+	return "decimal"
+}
+
+// GetVersion implements Schema.GetVersion
+func (s *DecimalSchema) GetVersion() string {
+	return s.version
+}
+
+// Precision returns the maximum number of significant digits
+func (s *DecimalSchema) Precision() int {
+	return s.precision
+}
+
+// Scale returns the maximum number of digits after the decimal point
+func (s *DecimalSchema) Scale() int {
+	return s.scale
+}
+
+// BytesSchema implements Schema for raw binary payloads. Values are byte
+// slices, or standard base64 strings as encoding/json writes them.
+type BytesSchema struct {
+	maxSize int
+	version string
+}
+
+// NewBytesSchema creates a schema for byte payloads of at most maxSize
+// bytes; zero means no limit
+func NewBytesSchema(maxSize int) types.Schema {
+	return &BytesSchema{
+		maxSize: maxSize,
+		version: "1.0",
+	}
+}
+
+// Validate implements Schema.Validate for byte payloads
+func (s *BytesSchema) Validate(data interface{}) error {
+	b, err := toBytes(data)
+	if err != nil {
+		return err
+	}
+	if s.maxSize > 0 && len(b) > s.maxSize {
+		return fmt.Errorf("payload of %d bytes exceeds maximum %d", len(b), s.maxSize)
+	}
+	return nil
+}
+
+// GetType implements Schema.GetType
+func (s *BytesSchema) GetType() string {
+	return "bytes"
+}
+
+// GetVersion implements Schema.GetVersion
+func (s *BytesSchema) GetVersion() string {
+	return s.version
+}
+
+// MaxSize returns the largest accepted payload in bytes, or zero
+func (s *BytesSchema) MaxSize() int {
+	return s.maxSize
+}
+
+// TimestampSchema implements Schema for timestamps written in a fixed
+// layout. Values are strings in the layout or time.Time values.
+type TimestampSchema struct {
+	layout  string
+	version string
+}
+
+// NewTimestampSchema creates a schema for timestamps in a time.Parse layout.
+// An empty layout selects RFC 3339.
+func NewTimestampSchema(layout string) types.Schema {
+	if layout == "" {
+		layout = DefaultTimestampLayout
+	}
+	return &TimestampSchema{
+		layout:  layout,
+		version: "1.0",
+	}
+}
+
+// Validate implements Schema.Validate for timestamps
+func (s *TimestampSchema) Validate(data interface{}) error {
+	_, err := s.parse(data)
+	return err
+}
+
+// GetType implements Schema.GetType
+func (s *TimestampSchema) GetType() string {
+	return "timestamp"
+}
+
+// GetVersion implements Schema.GetVersion
+func (s *TimestampSchema) GetVersion() string {
+	return s.version
+}
+
+// Layout returns the time.Parse layout of the timestamps
+func (s *TimestampSchema) Layout() string {
+	return s.layout
+}
+
+// parse converts a timestamp value to a time
+func (s *TimestampSchema) parse(data interface{}) (time.Time, error) {
+	switch v := data.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		t, err := time.Parse(s.layout, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("timestamp %q does not match layout %s", v, s.layout)
+		}
+		return t, nil
+	default:
+		return time.Time{}, fmt.Errorf("expected timestamp string, got %T", data)
+	}
+}
+
+// checkDecimal validates decimal precision and scale
+func checkDecimal(precision, scale int) error {
+	if precision <= 0 {
+		return fmt.Errorf("decimal precision must be positive, got %d", precision)
+	}
+	if scale < 0 || scale > precision {
+		return fmt.Errorf("decimal scale must be between 0 and precision %d, got %d", precision, scale)
+	}
+	return nil
+}
+
+// decimalText returns the text of a decimal value
+func decimalText(data interface{}) (string, bool) {
+	switch v := data.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	default:
+		return "", false
+	}
+}
+
+// toBytes converts a byte slice or base64 string to bytes
+func toBytes(data interface{}) ([]byte, error) {
+	switch v := data.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 payload: %w", err)
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("expected bytes, got %T", data)
+	}
+}
+
+// decimalUnscaled parses a decimal as an integer scaled by 10^scale
+func decimalUnscaled(data interface{}, scale int) (*big.Int, error) {
+	text, ok := decimalText(data)
+	if !ok {
+		return nil, fmt.Errorf("expected decimal string, got %T", data)
+	}
+	match := decimalPattern.FindStringSubmatch(text)
+	if match == nil {
+		return nil, fmt.Errorf("invalid decimal %q", text)
+	}
+	if len(match[2]) > scale {
+		return nil, fmt.Errorf("decimal %s has more than %d digits after the point", text, scale)
+	}
+
+	digits := match[1] + match[2] + strings.Repeat("0", scale-len(match[2]))
+	n, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid decimal %q", text)
+	}
+	if strings.HasPrefix(text, "-") {
+		n.Neg(n)
+	}
+	return n, nil
+}
+
+// formatDecimal formats an unscaled integer with scale digits after the
+// decimal point
+func formatDecimal(n *big.Int, scale int) string {
+	digits := new(big.Int).Abs(n).String()
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	if scale > 0 {
+		digits = digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+	}
+	if n.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
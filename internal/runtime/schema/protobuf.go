@@ -0,0 +1,656 @@
+package schema
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"flow-control/internal/types"
+)
+
+// protoValueField names the field wrapping values that are not objects
+const protoValueField = "value"
+
+// protobufCodec serializes values in the Protocol Buffers wire format. Objects
+// become messages whose fields are numbered from 1 in property name order,
+// and other values are wrapped in a message with a single "value" field.
+// Integers are int64, floats double, times and timestamps
+// google.protobuf.Timestamp, decimals strings, maps map<string, V> and
+// unions messages with one oneof field per variant.
+// Messages are built with dynamicpb from the descriptor of the schema, which
+// ToProtoSchema prints as a proto3 definition.
+type protobufCodec struct{}
+
+// Name implements Codec.Name
+func (protobufCodec) Name() string { return CodecProtobuf }
+
+// Encode implements Codec.Encode
+func (protobufCodec) Encode(s types.Schema, data interface{}) ([]byte, error) {
+	desc, err := protoDescriptor(s)
+	if err != nil {
+		return nil, err
+	}
+	obj, wrapped := protoRoot(s)
+	if wrapped {
+		data = map[string]interface{}{protoValueField: data}
+	}
+
+	msg := dynamicpb.NewMessage(desc)
+	if err := protoSetMessage(msg, obj, data); err != nil {
+		return nil, err
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+}
+
+// Decode implements Codec.Decode
+func (protobufCodec) Decode(s types.Schema, data []byte) (interface{}, error) {
+	desc, err := protoDescriptor(s)
+	if err != nil {
+		return nil, err
+	}
+	obj, wrapped := protoRoot(s)
+
+	msg := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	m, err := protoGetMessage(msg, obj)
+	if err != nil {
+		return nil, err
+	}
+	if wrapped {
+		return m[protoValueField], nil
+	}
+	return m, nil
+}
+
+// protoRoot returns the object schema of a top-level message, wrapping
+// schemas that are not objects
+func protoRoot(s types.Schema) (*ObjectSchema, bool) {
+	if obj, ok := unwrapSchema(s).(*ObjectSchema); ok {
+		return obj, false
+	}
+	return &ObjectSchema{
+		properties: map[string]types.Schema{protoValueField: s},
+		required:   []string{protoValueField},
+	}, true
+}
+
+// protoDescriptors caches message descriptors by schema
+var protoDescriptors sync.Map
+
+// protoTimestampName is the full name of google.protobuf.Timestamp
+var protoTimestampName = (&timestamppb.Timestamp{}).ProtoReflect().Descriptor().FullName()
+
+// protoDescriptor returns the descriptor of the top-level message of a
+// schema, named after the schema type
+func protoDescriptor(s types.Schema) (protoreflect.MessageDescriptor, error) {
+	if desc, ok := protoDescriptors.Load(s); ok {
+		return desc.(protoreflect.MessageDescriptor), nil
+	}
+
+	name := "Message"
+	if defined, ok := s.(*DefinedSchema); ok {
+		name = protoMessageName(defined.name)
+	}
+	obj, _ := protoRoot(s)
+	root, err := protoMessage(name, "."+name, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:        proto.String(name + ".proto"),
+		Syntax:      proto.String("proto3"),
+		Dependency:  []string{timestamppb.File_google_protobuf_timestamp_proto.Path()},
+		MessageType: []*descriptorpb.DescriptorProto{root},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		return nil, fmt.Errorf("schema %s has no valid protobuf message: %w", s.GetType(), err)
+	}
+	desc := file.Messages().Get(0)
+	protoDescriptors.Store(s, desc)
+	return desc, nil
+}
+
+// protoMessage describes the message of an object. Nested objects and
+// unions become nested messages.
+func protoMessage(name, fullName string, s *ObjectSchema) (*descriptorpb.DescriptorProto, error) {
+	msg := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+	for i, field := range sortedProperties(s) {
+		if !avroName.MatchString(field) {
+			return nil, fmt.Errorf("property %s is not a valid protobuf field name", field)
+		}
+		prop := s.properties[field]
+		optional := !isRequired(s, field) || isNullable(prop)
+		if isNullable(prop) {
+			prop = unwrapSchema(prop).(*NullableSchema).inner
+		}
+
+		fd := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(field),
+			Number: proto.Int32(int32(i + 1)),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		if array, ok := unwrapSchema(prop).(*ArraySchema); ok {
+			if err := protoSingular(array.elementSchema); err != nil {
+				return nil, fmt.Errorf("property %s: %w", field, err)
+			}
+			fd.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			prop = array.elementSchema
+		}
+		if err := protoFieldType(fd, field, prop, msg, fullName); err != nil {
+			return nil, fmt.Errorf("property %s: %w", field, err)
+		}
+
+		// Optional singular fields track their presence in a synthetic oneof
+		if optional && fd.GetLabel() != descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+			fd.Proto3Optional = proto.Bool(true)
+			fd.OneofIndex = proto.Int32(int32(len(msg.OneofDecl)))
+			msg.OneofDecl = append(msg.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String("_" + field)})
+		}
+		msg.Field = append(msg.Field, fd)
+	}
+	return msg, nil
+}
+
+// protoFieldType sets the type of a field holding values of a schema,
+// nesting the messages it needs in parent
+func protoFieldType(fd *descriptorpb.FieldDescriptorProto, field string, s types.Schema, parent *descriptorpb.DescriptorProto, parentName string) error {
+	message := func(name string) {
+		fd.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		fd.TypeName = proto.String(name)
+	}
+	timestamp := "." + string(protoTimestampName)
+
+	switch v := unwrapSchema(s).(type) {
+	case *BasicSchema:
+		switch v.schemaType {
+		case "string":
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+		case "int":
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()
+		case "float":
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE.Enum()
+		case "bool":
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_BOOL.Enum()
+		case "time":
+			message(timestamp)
+		default:
+			return fmt.Errorf("schema type %s has no protobuf equivalent", v.schemaType)
+		}
+
+	case *DecimalSchema:
+		fd.Type = descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+
+	case *BytesSchema:
+		fd.Type = descriptorpb.FieldDescriptorProto_TYPE_BYTES.Enum()
+
+	case *TimestampSchema:
+		message(timestamp)
+
+	case *MapSchema:
+		if err := checkStringKeys(v); err != nil {
+			return err
+		}
+		if err := protoSingular(v.valueSchema); err != nil {
+			return err
+		}
+		entry := &descriptorpb.DescriptorProto{
+			Name: proto.String(protoMessageName(field) + "Entry"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:   proto.String("key"),
+				Number: proto.Int32(1),
+				Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:   descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			}, {
+				Name:   proto.String("value"),
+				Number: proto.Int32(2),
+				Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			}},
+			Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+		}
+		if err := protoFieldType(entry.Field[1], field+"_value", v.valueSchema, parent, parentName); err != nil {
+			return err
+		}
+		parent.NestedType = append(parent.NestedType, entry)
+		fd.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		message(parentName + "." + entry.GetName())
+
+	case *ObjectSchema:
+		name := protoMessageName(field)
+		nested, err := protoMessage(name, parentName+"."+name, v)
+		if err != nil {
+			return err
+		}
+		parent.NestedType = append(parent.NestedType, nested)
+		message(parentName + "." + name)
+
+	case *UnionSchema:
+		name := protoMessageName(field) + "Union"
+		union := &descriptorpb.DescriptorProto{
+			Name:      proto.String(name),
+			OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("value")}},
+		}
+		for i, variant := range v.variants {
+			if err := protoSingular(variant); err != nil {
+				return err
+			}
+			variantField := fmt.Sprintf("variant_%d", i+1)
+			vd := &descriptorpb.FieldDescriptorProto{
+				Name:       proto.String(variantField),
+				Number:     proto.Int32(int32(i + 1)),
+				Label:      descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				OneofIndex: proto.Int32(0),
+			}
+			if err := protoFieldType(vd, variantField, variant, union, parentName+"."+name); err != nil {
+				return err
+			}
+			union.Field = append(union.Field, vd)
+		}
+		parent.NestedType = append(parent.NestedType, union)
+		message(parentName + "." + name)
+
+	default:
+		return fmt.Errorf("schema %s of kind %T cannot be converted to protobuf", s.GetType(), s)
+	}
+	return nil
+}
+
+// protoSingular rejects schemas that cannot be array elements, map values or
+// oneof fields, which protobuf requires to be singular
+func protoSingular(s types.Schema) error {
+	switch unwrapSchema(s).(type) {
+	case *ArraySchema, *MapSchema, *NullableSchema:
+		return fmt.Errorf("protobuf cannot nest %s in a repeated, map or oneof field", s.GetType())
+	default:
+		return nil
+	}
+}
+
+// protoField returns the field of a message numbered n
+func protoField(msg protoreflect.Message, n int) protoreflect.FieldDescriptor {
+	return msg.Descriptor().Fields().ByNumber(protowire.Number(n))
+}
+
+// protoSetMessage sets the fields of a message from an object
+func protoSetMessage(msg protoreflect.Message, s *ObjectSchema, data interface{}) error {
+	m, err := toObject(data)
+	if err != nil {
+		return err
+	}
+	for i, field := range sortedProperties(s) {
+		prop := s.properties[field]
+		value, present := m[field]
+		if !present && isRequired(s, field) && !isNullable(prop) {
+			return fmt.Errorf("missing required field %s", field)
+		}
+		// Absent and null optional fields are both left out
+		if !present || (value == nil && (!isRequired(s, field) || isNullable(prop))) {
+			continue
+		}
+		if isNullable(prop) {
+			prop = unwrapSchema(prop).(*NullableSchema).inner
+		}
+		if err := protoSet(msg, protoField(msg, i+1), prop, value); err != nil {
+			return fmt.Errorf("field %s: %w", field, err)
+		}
+	}
+	return nil
+}
+
+// protoSet sets a field of a message to a value of a schema
+func protoSet(msg protoreflect.Message, fd protoreflect.FieldDescriptor, s types.Schema, data interface{}) error {
+	switch v := unwrapSchema(s).(type) {
+	case *ArraySchema:
+		items, err := toArray(data)
+		if err != nil {
+			return err
+		}
+		list := msg.Mutable(fd).List()
+		for i, item := range items {
+			value, err := protoValue(fd.Message(), v.elementSchema, item)
+			if err != nil {
+				return fmt.Errorf("item %d: %w", i, err)
+			}
+			list.Append(value)
+		}
+		return nil
+
+	case *MapSchema:
+		m, err := toObject(data)
+		if err != nil {
+			return err
+		}
+		entries := msg.Mutable(fd).Map()
+		for key, item := range m {
+			value, err := protoValue(fd.MapValue().Message(), v.valueSchema, item)
+			if err != nil {
+				return fmt.Errorf("key %s: %w", key, err)
+			}
+			entries.Set(protoreflect.ValueOfString(key).MapKey(), value)
+		}
+		return nil
+
+	default:
+		value, err := protoValue(fd.Message(), s, data)
+		if err != nil {
+			return err
+		}
+		msg.Set(fd, value)
+		return nil
+	}
+}
+
+// protoValue converts a singular value of a schema. Messages are created
+// with desc, the message type of the field holding the value.
+func protoValue(desc protoreflect.MessageDescriptor, s types.Schema, data interface{}) (protoreflect.Value, error) {
+	switch v := unwrapSchema(s).(type) {
+	case *BasicSchema:
+		switch v.schemaType {
+		case "string":
+			str, ok := data.(string)
+			if !ok {
+				return protoreflect.Value{}, fmt.Errorf("expected string, got %T", data)
+			}
+			return protoreflect.ValueOfString(str), nil
+		case "int":
+			n, ok := toInt64(data)
+			if !ok {
+				return protoreflect.Value{}, fmt.Errorf("expected integer, got %v", data)
+			}
+			return protoreflect.ValueOfInt64(n), nil
+		case "float":
+			f, ok := toFloat(data)
+			if !ok {
+				return protoreflect.Value{}, fmt.Errorf("expected number, got %T", data)
+			}
+			return protoreflect.ValueOfFloat64(f), nil
+		case "bool":
+			b, ok := data.(bool)
+			if !ok {
+				return protoreflect.Value{}, fmt.Errorf("expected bool, got %T", data)
+			}
+			return protoreflect.ValueOfBool(b), nil
+		case "time":
+			t, err := toTime(data)
+			if err != nil {
+				return protoreflect.Value{}, err
+			}
+			return protoTimestamp(desc, t), nil
+		}
+
+	case *DecimalSchema:
+		n, err := decimalUnscaled(data, v.scale)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfString(formatDecimal(n, v.scale)), nil
+
+	case *BytesSchema:
+		b, err := toBytes(data)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfBytes(b), nil
+
+	case *TimestampSchema:
+		t, err := v.parse(data)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoTimestamp(desc, t), nil
+
+	case *ObjectSchema:
+		msg := dynamicpb.NewMessage(desc)
+		if err := protoSetMessage(msg, v, data); err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfMessage(msg), nil
+
+	case *UnionSchema:
+		msg := dynamicpb.NewMessage(desc)
+		for i, variant := range v.variants {
+			if variant.Validate(data) != nil {
+				continue
+			}
+			if err := protoSet(msg, protoField(msg, i+1), variant, data); err != nil {
+				return protoreflect.Value{}, err
+			}
+			return protoreflect.ValueOfMessage(msg), nil
+		}
+		return protoreflect.Value{}, fmt.Errorf("value matches no variant of %s", v.GetType())
+	}
+	return protoreflect.Value{}, fmt.Errorf("schema %s of kind %T has no protobuf encoding", s.GetType(), s)
+}
+
+// protoTimestamp converts a time to a google.protobuf.Timestamp message
+func protoTimestamp(desc protoreflect.MessageDescriptor, t time.Time) protoreflect.Value {
+	msg := dynamicpb.NewMessage(desc)
+	fields := desc.Fields()
+	msg.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(t.Unix()))
+	msg.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(int32(t.Nanosecond())))
+	return protoreflect.ValueOfMessage(msg)
+}
+
+// protoTime converts a google.protobuf.Timestamp message to a time
+func protoTime(msg protoreflect.Message) time.Time {
+	fields := msg.Descriptor().Fields()
+	return time.Unix(msg.Get(fields.ByName("seconds")).Int(), msg.Get(fields.ByName("nanos")).Int()).UTC()
+}
+
+// protoGetMessage converts a message to an object. Absent required fields
+// take their zero value and absent nullable ones are null, as in proto3.
+func protoGetMessage(msg protoreflect.Message, s *ObjectSchema) (map[string]interface{}, error) {
+	m := make(map[string]interface{}, len(s.properties))
+	for i, field := range sortedProperties(s) {
+		prop := s.properties[field]
+		fd := protoField(msg, i+1)
+		if !msg.Has(fd) {
+			if !isRequired(s, field) {
+				continue
+			}
+			if isNullable(prop) {
+				m[field] = nil
+				continue
+			}
+		}
+		if isNullable(prop) {
+			prop = unwrapSchema(prop).(*NullableSchema).inner
+		}
+
+		var err error
+		if m[field], err = protoGet(msg.Get(fd), prop); err != nil {
+			return nil, fmt.Errorf("field %s: %w", field, err)
+		}
+	}
+	return m, nil
+}
+
+// protoGet converts a field value to a value of a schema
+func protoGet(value protoreflect.Value, s types.Schema) (interface{}, error) {
+	switch v := unwrapSchema(s).(type) {
+	case *BasicSchema:
+		switch v.schemaType {
+		case "string":
+			return value.String(), nil
+		case "int":
+			return value.Int(), nil
+		case "float":
+			return value.Float(), nil
+		case "bool":
+			return value.Bool(), nil
+		case "time":
+			return protoTime(value.Message()), nil
+		}
+
+	case *DecimalSchema:
+		if value.String() == "" {
+			return formatDecimal(new(big.Int), v.scale), nil
+		}
+		return value.String(), nil
+
+	case *BytesSchema:
+		return append([]byte{}, value.Bytes()...), nil
+
+	case *TimestampSchema:
+		return protoTime(value.Message()).Format(v.layout), nil
+
+	case *ArraySchema:
+		list := value.List()
+		items := make([]interface{}, 0, list.Len())
+		for i := 0; i < list.Len(); i++ {
+			item, err := protoGet(list.Get(i), v.elementSchema)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+
+	case *MapSchema:
+		entries := value.Map()
+		m := make(map[string]interface{}, entries.Len())
+		var err error
+		entries.Range(func(key protoreflect.MapKey, item protoreflect.Value) bool {
+			if m[key.String()], err = protoGet(item, v.valueSchema); err != nil {
+				err = fmt.Errorf("key %s: %w", key.String(), err)
+				return false
+			}
+			return true
+		})
+		return m, err
+
+	case *ObjectSchema:
+		return protoGetMessage(value.Message(), v)
+
+	case *UnionSchema:
+		msg := value.Message()
+		fd := msg.WhichOneof(msg.Descriptor().Oneofs().Get(0))
+		if fd == nil {
+			return nil, fmt.Errorf("union %s has no variant set", v.GetType())
+		}
+		return protoGet(msg.Get(fd), v.variants[fd.Number()-1])
+	}
+	return nil, fmt.Errorf("schema %s of kind %T has no protobuf encoding", s.GetType(), s)
+}
+
+// ToProtoSchema converts a schema to a proto3 message definition, named after
+// the schema type. Nested objects and unions become nested messages.
+func ToProtoSchema(s types.Schema) (string, error) {
+	desc, err := protoDescriptor(s)
+	if err != nil {
+		return "", err
+	}
+
+	w := &protoWriter{}
+	w.message(desc, 0)
+
+	var out strings.Builder
+	out.WriteString("syntax = \"proto3\";\n\n")
+	if w.timestamps {
+		out.WriteString("import \"google/protobuf/timestamp.proto\";\n\n")
+	}
+	out.WriteString(w.body.String())
+	return out.String(), nil
+}
+
+// protoWriter writes proto3 message definitions from descriptors
+type protoWriter struct {
+	body       strings.Builder
+	timestamps bool
+}
+
+// message writes the definition of a message and its nested messages
+func (w *protoWriter) message(desc protoreflect.MessageDescriptor, depth int) {
+	indent := strings.Repeat("  ", depth)
+	fmt.Fprintf(&w.body, "%smessage %s {\n", indent, desc.Name())
+
+	fields := desc.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		oneof := fd.ContainingOneof()
+		if oneof != nil && !oneof.IsSynthetic() {
+			// Oneofs are written with their first field
+			if oneof.Fields().Get(0) == fd {
+				w.oneof(oneof, depth+1)
+			}
+			continue
+		}
+		label := ""
+		switch {
+		case fd.HasOptionalKeyword():
+			label = "optional "
+		case fd.IsList():
+			label = "repeated "
+		}
+		fmt.Fprintf(&w.body, "%s  %s%s %s = %d;\n", indent, label, w.fieldType(fd), fd.Name(), fd.Number())
+	}
+
+	nested := desc.Messages()
+	for i := 0; i < nested.Len(); i++ {
+		if !nested.Get(i).IsMapEntry() {
+			w.message(nested.Get(i), depth+1)
+		}
+	}
+	fmt.Fprintf(&w.body, "%s}\n", indent)
+}
+
+// oneof writes a oneof and its fields
+func (w *protoWriter) oneof(oneof protoreflect.OneofDescriptor, depth int) {
+	indent := strings.Repeat("  ", depth)
+	fmt.Fprintf(&w.body, "%soneof %s {\n", indent, oneof.Name())
+	fields := oneof.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		fmt.Fprintf(&w.body, "%s  %s %s = %d;\n", indent, w.fieldType(fd), fd.Name(), fd.Number())
+	}
+	fmt.Fprintf(&w.body, "%s}\n", indent)
+}
+
+// fieldType returns the proto type of a field
+func (w *protoWriter) fieldType(fd protoreflect.FieldDescriptor) string {
+	switch {
+	case fd.IsMap():
+		return fmt.Sprintf("map<%s, %s>", w.fieldType(fd.MapKey()), w.fieldType(fd.MapValue()))
+	case fd.Message() == nil:
+		return fd.Kind().String()
+	case fd.Message().FullName() == protoTimestampName:
+		w.timestamps = true
+		return string(fd.Message().FullName())
+	default:
+		return string(fd.Message().Name())
+	}
+}
+
+// protoMessageName converts a field or type name to a CamelCase message name
+func protoMessageName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range avroRecordName(name) {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper && 'a' <= r && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		upper = false
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 || ('0' <= b.String()[0] && b.String()[0] <= '9') {
+		return "M" + b.String()
+	}
+	return b.String()
+}
@@ -0,0 +1,176 @@
+package schema
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// timeType is the reflected type of time.Time
+var timeType = reflect.TypeOf(time.Time{})
+
+// structField is an exported struct field as seen by encoding/json
+type structField struct {
+	name     string
+	index    []int
+	typ      reflect.Type
+	required bool
+}
+
+// FromStruct derives an object schema from a struct value, or a pointer to
+// one, so Go node authors need not assemble property maps by hand.
+// Properties are named by their json tags and fields tagged
+// `validate:"required"` are required. Strings, numbers, bools, times, byte
+// slices, other slices, maps with string keys and nested structs map onto
+// the matching schemas; pointers are nullable and interfaces accept
+// anything, nil included. Recursive types are rejected.
+func FromStruct(v interface{}) (types.Schema, error) {
+	typ := reflect.TypeOf(v)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected struct, got %T", v)
+	}
+	return schemaFromType(typ, make(map[reflect.Type]bool))
+}
+
+// schemaFromType derives a schema from a Go type. visiting holds the structs
+// being derived, to detect recursion.
+func schemaFromType(typ reflect.Type, visiting map[reflect.Type]bool) (types.Schema, error) {
+	if typ == timeType {
+		return NewTimeSchema(), nil
+	}
+
+	switch typ.Kind() {
+	case reflect.String:
+		return NewStringSchema(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return NewIntSchema(), nil
+	case reflect.Float32, reflect.Float64:
+		return NewFloatSchema(), nil
+	case reflect.Bool:
+		return NewBoolSchema(), nil
+	case reflect.Interface:
+		return NewNullableSchema(NewAnySchema()), nil
+
+	case reflect.Ptr:
+		inner, err := schemaFromType(typ.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		return NewNullableSchema(inner), nil
+
+	case reflect.Slice, reflect.Array:
+		if typ.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.Uint8 {
+			return NewBytesSchema(0), nil
+		}
+		elem, err := schemaFromType(typ.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		return NewArraySchema(elem), nil
+
+	case reflect.Map:
+		if typ.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map %s must have string keys", typ)
+		}
+		values, err := schemaFromType(typ.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		return NewMapSchema(NewStringSchema(), values), nil
+
+	case reflect.Struct:
+		if visiting[typ] {
+			return nil, fmt.Errorf("recursive type %s cannot be converted to a schema", typ)
+		}
+		visiting[typ] = true
+		defer delete(visiting, typ)
+
+		properties := make(map[string]types.Schema)
+		var required []string
+		for _, field := range structFields(typ) {
+			prop, err := schemaFromType(field.typ, visiting)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", field.name, err)
+			}
+			properties[field.name] = prop
+			if field.required {
+				required = append(required, field.name)
+			}
+		}
+		return NewObjectSchema(properties, required), nil
+
+	default:
+		return nil, fmt.Errorf("type %s cannot be converted to a schema", typ)
+	}
+}
+
+// structFields lists the exported fields of a struct under their json
+// names. Fields tagged json:"-" are skipped and untagged embedded structs
+// are flattened, with outer fields taking precedence.
+func structFields(typ reflect.Type) []structField {
+	var fields []structField
+	seen := make(map[string]bool)
+	var embedded []structField
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			inner := field.Type
+			if inner.Kind() == reflect.Ptr {
+				inner = inner.Elem()
+			}
+			if inner.Kind() == reflect.Struct {
+				for _, promoted := range structFields(inner) {
+					promoted.index = append([]int{i}, promoted.index...)
+					embedded = append(embedded, promoted)
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		seen[name] = true
+		fields = append(fields, structField{
+			name:     name,
+			index:    field.Index,
+			typ:      field.Type,
+			required: hasTagOption(field.Tag.Get("validate"), "required"),
+		})
+	}
+
+	for _, field := range embedded {
+		if !seen[field.name] {
+			seen[field.name] = true
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// hasTagOption reports whether a comma separated tag contains an option
+func hasTagOption(tag, option string) bool {
+	for _, part := range strings.Split(tag, ",") {
+		if strings.TrimSpace(part) == option {
+			return true
+		}
+	}
+	return false
+}
@@ -1,8 +1,12 @@
 package schema
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"flow-control/internal/types"
 )
@@ -14,22 +18,79 @@ import (
 // - Message (message.go) - Used in validation examples
 // - Fields (types.go) - Used for structured logging
 
+var (
+	// ErrSchemaNotFound is returned when a schema type or version is not registered
+	ErrSchemaNotFound = errors.New("schema not found")
+	// ErrSchemaExists is returned when a schema type and version is already registered
+	ErrSchemaExists = errors.New("schema already exists")
+	// ErrInvalidDefinition is returned when a definition cannot be compiled
+	ErrInvalidDefinition = errors.New("invalid schema definition")
+)
+
+// Store persists custom schemas so they survive restarts
+type Store interface {
+	// SaveSchema stores a schema record, failing if the type and version
+	// already exist
+	SaveSchema(record *types.SchemaRecord) error
+
+	// ListSchemas returns every stored schema record in registration order
+	ListSchemas() ([]*types.SchemaRecord, error)
+}
+
 // SchemaRegistry manages schema types and versions
 type SchemaRegistry struct {
-	schemas map[string]map[string]types.Schema // type -> version -> schema
-	mu      sync.RWMutex
+	schemas    map[string]map[string]types.Schema // type -> version -> schema
+	migrations map[string]map[string]migration    // type -> from version -> upgrade
+	validators map[validatorKey]Validator
+	store      Store
+	mu         sync.RWMutex
 }
 
 // NewRegistry creates a new schema registry
 func NewRegistry() *SchemaRegistry {
 	r := &SchemaRegistry{
-		schemas: make(map[string]map[string]types.Schema),
+		schemas:    make(map[string]map[string]types.Schema),
+		migrations: make(map[string]map[string]migration),
+		validators: make(map[validatorKey]Validator),
 	}
 	r.registerBuiltins()
 	return r
 }
 
-// Register adds a schema to the registry
+// NewPersistentRegistry creates a schema registry backed by a store. Schemas
+// already in the store are loaded, and every schema registered afterwards is
+// saved before it becomes visible.
+func NewPersistentRegistry(store Store) (*SchemaRegistry, error) {
+	r := NewRegistry()
+
+	records, err := store.ListSchemas()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schemas: %w", err)
+	}
+
+	// Records are in registration order, so any type a definition refers to
+	// has already been loaded
+	for _, record := range records {
+		def, err := UnmarshalDefinition(record.Definition)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load schema %s version %s: %w", record.Type, record.Version, err)
+		}
+		schema, err := r.newDefinedSchema(record.Type, record.Version, def)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load schema %s version %s: %w", record.Type, record.Version, err)
+		}
+		if err := r.Register(schema); err != nil {
+			return nil, fmt.Errorf("failed to load schema %s version %s: %w", record.Type, record.Version, err)
+		}
+	}
+
+	r.store = store
+	return r, nil
+}
+
+// Register adds a schema to the registry. A persistent registry only accepts
+// schemas built from a Definition, since those are the only ones it can
+// store.
 func (r *SchemaRegistry) Register(schema types.Schema) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -37,17 +98,47 @@ func (r *SchemaRegistry) Register(schema types.Schema) error {
 	schemaType := schema.GetType()
 	version := schema.GetVersion()
 
+	// Check for existing schema
+	if _, ok := r.schemas[schemaType][version]; ok {
+		return fmt.Errorf("%w: %s version %s", ErrSchemaExists, schemaType, version)
+	}
+
+	if r.store != nil {
+		if err := r.persist(schema); err != nil {
+			return err
+		}
+	}
+
 	// Initialize version map if needed
 	if _, ok := r.schemas[schemaType]; !ok {
 		r.schemas[schemaType] = make(map[string]types.Schema)
 	}
 
-	// Check for existing schema
-	if _, ok := r.schemas[schemaType][version]; ok {
-		return fmt.Errorf("schema %s version %s already exists", schemaType, version)
+	r.schemas[schemaType][version] = schema
+	return nil
+}
+
+// persist saves a schema to the registry's store
+func (r *SchemaRegistry) persist(schema types.Schema) error {
+	defined, ok := schema.(*DefinedSchema)
+	if !ok {
+		return fmt.Errorf("schema %s version %s has no definition and cannot be persisted", schema.GetType(), schema.GetVersion())
 	}
 
-	r.schemas[schemaType][version] = schema
+	definition, err := MarshalDefinition(defined.Definition())
+	if err != nil {
+		return err
+	}
+
+	record := &types.SchemaRecord{
+		Type:       defined.GetType(),
+		Version:    defined.GetVersion(),
+		Definition: definition,
+		CreatedAt:  time.Now(),
+	}
+	if err := r.store.SaveSchema(record); err != nil {
+		return fmt.Errorf("failed to save schema %s version %s: %w", record.Type, record.Version, err)
+	}
 	return nil
 }
 
@@ -58,12 +149,12 @@ func (r *SchemaRegistry) Get(schemaType, version string) (types.Schema, error) {
 
 	versions, ok := r.schemas[schemaType]
 	if !ok {
-		return nil, fmt.Errorf("unknown schema type: %s", schemaType)
+		return nil, fmt.Errorf("%w: unknown schema type %s", ErrSchemaNotFound, schemaType)
 	}
 
 	schema, ok := versions[version]
 	if !ok {
-		return nil, fmt.Errorf("unknown version %s for schema type %s", version, schemaType)
+		return nil, fmt.Errorf("%w: unknown version %s for schema type %s", ErrSchemaNotFound, version, schemaType)
 	}
 
 	return schema, nil
@@ -76,12 +167,12 @@ func (r *SchemaRegistry) GetLatest(schemaType string) (types.Schema, error) {
 
 	versions, ok := r.schemas[schemaType]
 	if !ok {
-		return nil, fmt.Errorf("unknown schema type: %s", schemaType)
+		return nil, fmt.Errorf("%w: unknown schema type %s", ErrSchemaNotFound, schemaType)
 	}
 
 	var latestVersion string
 	for version := range versions {
-		if latestVersion == "" || version > latestVersion {
+		if latestVersion == "" || compareVersions(version, latestVersion) > 0 {
 			latestVersion = version
 		}
 	}
@@ -89,7 +180,21 @@ func (r *SchemaRegistry) GetLatest(schemaType string) (types.Schema, error) {
 	return versions[latestVersion], nil
 }
 
-// ListTypes returns all registered schema types
+// compareVersions orders version strings semantically, falling back to
+// string order for versions that are not semantic versions or that are
+// equivalent, such as "1.0" and "1.0.0"
+func compareVersions(a, b string) int {
+	va, errA := ParseVersion(a)
+	vb, errB := ParseVersion(b)
+	if errA == nil && errB == nil {
+		if c := va.Compare(vb); c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// ListTypes returns all registered schema types in alphabetical order
 func (r *SchemaRegistry) ListTypes() []string {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -98,23 +203,27 @@ func (r *SchemaRegistry) ListTypes() []string {
 	for t := range r.schemas {
 		schemaTypes = append(schemaTypes, t)
 	}
+	sort.Strings(schemaTypes)
 	return schemaTypes
 }
 
-// ListVersions returns all versions for a schema type
+// ListVersions returns all versions for a schema type, oldest first
 func (r *SchemaRegistry) ListVersions(schemaType string) ([]string, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	versions, ok := r.schemas[schemaType]
 	if !ok {
-		return nil, fmt.Errorf("unknown schema type: %s", schemaType)
+		return nil, fmt.Errorf("%w: unknown schema type %s", ErrSchemaNotFound, schemaType)
 	}
 
 	result := make([]string, 0, len(versions))
 	for v := range versions {
 		result = append(result, v)
 	}
+	sort.Slice(result, func(i, j int) bool {
+		return compareVersions(result[i], result[j]) < 0
+	})
 	return result, nil
 }
 
@@ -127,6 +236,9 @@ func (r *SchemaRegistry) registerBuiltins() {
 		NewBoolSchema(),
 		NewTimeSchema(),
 		NewAnySchema(),
+		NewDecimalSchema(DefaultDecimalPrecision, DefaultDecimalScale),
+		NewBytesSchema(0),
+		NewTimestampSchema(DefaultTimestampLayout),
 	}
 
 	for _, schema := range builtins {
@@ -0,0 +1,222 @@
+package schema
+
+import (
+	"encoding/base64"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// Sample bounds for values whose schema leaves them open
+const (
+	sampleMaxLength = 12
+	sampleMaxItems  = 4
+	sampleIntRange  = 1000
+)
+
+// sampleLetters are the characters sampled strings are made of
+const sampleLetters = "abcdefghijklmnopqrstuvwxyz"
+
+// sampleEpoch anchors sampled timestamps, which fall within the year after
+// it
+var sampleEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Sample returns a random value valid against s, drawn from rng so that a
+// seeded source repeats its values. Enums, lengths, ranges and item counts
+// are honoured; patterns are not, so values of schemas with a pattern may
+// fail validation. Optional object fields are left out now and then and
+// nullable values are sometimes nil. Schemas of unknown types yield nil.
+func Sample(s types.Schema, rng *rand.Rand) interface{} {
+	switch s := s.(type) {
+	case *DefinedSchema:
+		return Sample(s.schema, rng)
+	case *BasicSchema:
+		return sampleBasic(s, rng)
+	case *ArraySchema:
+		c := s.Constraints()
+		n := sampleCount(rng, c.MinItems, c.MaxItems, sampleMaxItems)
+		values := make([]interface{}, n)
+		for i := range values {
+			values[i] = Sample(s.Elements(), rng)
+		}
+		return values
+	case *ObjectSchema:
+		required := make(map[string]bool, len(s.Required()))
+		for _, name := range s.Required() {
+			required[name] = true
+		}
+		// Fields are visited in order so a seed always draws the same values
+		names := make([]string, 0, len(s.Properties()))
+		for name := range s.Properties() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		object := make(map[string]interface{}, len(names))
+		for _, name := range names {
+			if !required[name] && rng.Intn(4) == 0 {
+				continue
+			}
+			object[name] = Sample(s.Properties()[name], rng)
+		}
+		return object
+	case *MapSchema:
+		n := rng.Intn(sampleMaxItems + 1)
+		values := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			key, ok := Sample(s.Keys(), rng).(string)
+			if !ok {
+				key = sampleString(rng, 1, sampleMaxLength)
+			}
+			values[key] = Sample(s.Values(), rng)
+		}
+		return values
+	case *UnionSchema:
+		variants := s.Variants()
+		if len(variants) == 0 {
+			return nil
+		}
+		return Sample(variants[rng.Intn(len(variants))], rng)
+	case *NullableSchema:
+		if rng.Intn(5) == 0 {
+			return nil
+		}
+		return Sample(s.Inner(), rng)
+	case *DecimalSchema:
+		return sampleDecimal(s, rng)
+	case *BytesSchema:
+		n := sampleMaxLength
+		if s.MaxSize() > 0 && s.MaxSize() < n {
+			n = s.MaxSize()
+		}
+		b := make([]byte, rng.Intn(n+1))
+		rng.Read(b)
+		return base64.StdEncoding.EncodeToString(b)
+	case *TimestampSchema:
+		return sampleTime(rng).Format(s.Layout())
+	default:
+		return nil
+	}
+}
+
+// sampleBasic returns a random value of a primitive schema
+func sampleBasic(s *BasicSchema, rng *rand.Rand) interface{} {
+	c := s.Constraints()
+	if len(c.Enum) > 0 {
+		return c.Enum[rng.Intn(len(c.Enum))]
+	}
+	switch s.GetType() {
+	case "string":
+		min, max := 1, sampleMaxLength
+		if c.MinLength != nil {
+			min = *c.MinLength
+		}
+		if c.MaxLength != nil {
+			max = *c.MaxLength
+		}
+		if max < min {
+			if c.MinLength != nil {
+				max = min
+			} else {
+				min = max
+			}
+		}
+		return sampleString(rng, min, max)
+	case "int":
+		lo, hi := 0.0, float64(sampleIntRange)
+		if c.Minimum != nil {
+			lo = math.Ceil(*c.Minimum)
+			if c.Maximum == nil {
+				hi = lo + sampleIntRange
+			}
+		}
+		if c.Maximum != nil {
+			hi = math.Floor(*c.Maximum)
+			if c.Minimum == nil {
+				lo = math.Min(0, hi-sampleIntRange)
+			}
+		}
+		if hi < lo {
+			return int64(lo)
+		}
+		return int64(lo) + rng.Int63n(int64(hi-lo)+1)
+	case "float":
+		lo, hi := 0.0, 1.0
+		if c.Minimum != nil {
+			lo = *c.Minimum
+			if c.Maximum == nil {
+				hi = lo + sampleIntRange
+			}
+		}
+		if c.Maximum != nil {
+			hi = *c.Maximum
+			if c.Minimum == nil {
+				lo = math.Min(0, hi-sampleIntRange)
+			}
+		}
+		return lo + rng.Float64()*(hi-lo)
+	case "bool":
+		return rng.Intn(2) == 0
+	case "time":
+		return sampleTime(rng)
+	default:
+		return sampleString(rng, 1, sampleMaxLength)
+	}
+}
+
+// sampleCount returns a random count within optional bounds, defaulting to
+// 0..def
+func sampleCount(rng *rand.Rand, min, max *int, def int) int {
+	lo, hi := 0, def
+	if min != nil {
+		lo = *min
+		if hi < lo {
+			hi = lo
+		}
+	}
+	if max != nil {
+		hi = *max
+	}
+	if hi <= lo {
+		return lo
+	}
+	return lo + rng.Intn(hi-lo+1)
+}
+
+// sampleString returns a random lowercase string of min to max letters
+func sampleString(rng *rand.Rand, min, max int) string {
+	n := sampleCount(rng, &min, &max, max)
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteByte(sampleLetters[rng.Intn(len(sampleLetters))])
+	}
+	return b.String()
+}
+
+// sampleDecimal returns a random decimal string fitting the precision and
+// scale of s
+func sampleDecimal(s *DecimalSchema, rng *rand.Rand) string {
+	digits := func(n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			b.WriteByte(byte('0' + rng.Intn(10)))
+		}
+		return b.String()
+	}
+	integer := strings.TrimLeft(digits(rng.Intn(s.Precision()-s.Scale()+1)), "0")
+	if integer == "" {
+		integer = "0"
+	}
+	if s.Scale() == 0 {
+		return integer
+	}
+	return integer + "." + digits(s.Scale())
+}
+
+// sampleTime returns a random time within the year after sampleEpoch
+func sampleTime(rng *rand.Rand) time.Time {
+	return sampleEpoch.Add(time.Duration(rng.Int63n(int64(365 * 24 * time.Hour))))
+}
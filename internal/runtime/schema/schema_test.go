@@ -1,6 +1,10 @@
 package schema_test
 
 import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
 	"testing"
 	"time"
 
@@ -166,6 +170,90 @@ func TestArraySchema(t *testing.T) {
 	require.Equal(t, "array<string>", stringArray.GetType())
 }
 
+func TestSchemaConstraints(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  types.Schema
+		valid   []interface{}
+		invalid []interface{}
+	}{
+		{
+			name:    "string length",
+			schema:  schema.NewStringSchema(schema.WithMinLength(2), schema.WithMaxLength(4)),
+			valid:   []interface{}{"ab", "abcd", "héé"},
+			invalid: []interface{}{"a", "abcde"},
+		},
+		{
+			name:    "string pattern",
+			schema:  schema.NewStringSchema(schema.WithPattern(`^[A-Z]{3}$`)),
+			valid:   []interface{}{"USD", "EUR"},
+			invalid: []interface{}{"usd", "EURO"},
+		},
+		{
+			name:    "string enum",
+			schema:  schema.NewStringSchema(schema.WithEnum("low", "high")),
+			valid:   []interface{}{"low", "high"},
+			invalid: []interface{}{"medium", ""},
+		},
+		{
+			name:    "int range",
+			schema:  schema.NewIntSchema(schema.WithRange(1, 10)),
+			valid:   []interface{}{1, int64(10), int8(5)},
+			invalid: []interface{}{0, int64(11), 2.5},
+		},
+		{
+			name:    "int enum",
+			schema:  schema.NewIntSchema(schema.WithEnum(1, 2, 3)),
+			valid:   []interface{}{1, int64(2), int32(3)},
+			invalid: []interface{}{4, "1"},
+		},
+		{
+			name:    "float minimum",
+			schema:  schema.NewFloatSchema(schema.WithMinimum(0)),
+			valid:   []interface{}{0.0, 3.14},
+			invalid: []interface{}{-0.5},
+		},
+		{
+			name:    "array items",
+			schema:  schema.NewArraySchema(schema.NewStringSchema(schema.WithMinLength(1)), schema.WithMinItems(1), schema.WithMaxItems(2)),
+			valid:   []interface{}{[]string{"a"}, []string{"a", "b"}},
+			invalid: []interface{}{[]string{}, []string{"a", "b", "c"}, []string{""}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, v := range tt.valid {
+				require.NoError(t, tt.schema.Validate(v), "expected %v to be valid", v)
+			}
+			for _, v := range tt.invalid {
+				require.Error(t, tt.schema.Validate(v), "expected %v to be invalid", v)
+			}
+		})
+	}
+
+	// Invalid patterns fail fast
+	require.Panics(t, func() { schema.WithPattern(`(`) })
+
+	// Definitions carry constraints
+	registry := schema.NewRegistry()
+	minimum, maxItems := 18.0, 2
+	compiled, err := registry.Compile(schema.Definition{
+		Type:     "array",
+		MaxItems: &maxItems,
+		Items:    &schema.Definition{Type: "int", Minimum: &minimum},
+	})
+	require.NoError(t, err)
+	require.NoError(t, compiled.Validate([]int{18, 40}))
+	require.Error(t, compiled.Validate([]int{17}))
+	require.Error(t, compiled.Validate([]int{18, 19, 20}))
+
+	_, err = registry.Compile(schema.Definition{Type: "string", Pattern: `(`})
+	require.Error(t, err)
+	_, err = registry.Compile(schema.Definition{Type: "bool", Enum: []interface{}{true}})
+	require.Error(t, err)
+}
+
 func TestObjectSchema(t *testing.T) {
 	// Create person schema
 	personSchema := schema.NewObjectSchema(
@@ -212,6 +300,53 @@ func TestObjectSchema(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestMapSchema(t *testing.T) {
+	counts := schema.NewMapSchema(schema.NewStringSchema(schema.WithMinLength(1)), schema.NewIntSchema())
+
+	require.NoError(t, counts.Validate(map[string]int{"a": 1, "b": 2}))
+	require.NoError(t, counts.Validate(map[string]interface{}{"a": int64(1)}))
+	require.NoError(t, counts.Validate(map[string]int{}))
+
+	// Keys and values are both checked
+	require.Error(t, counts.Validate(map[string]int{"": 1}))
+	require.Error(t, counts.Validate(map[string]interface{}{"a": "one"}))
+	require.Error(t, counts.Validate(map[int]int{1: 1}))
+	require.Error(t, counts.Validate([]int{1}))
+
+	require.Equal(t, "map<string,int>", counts.GetType())
+}
+
+func TestUnionSchema(t *testing.T) {
+	id := schema.NewUnionSchema(schema.NewStringSchema(), schema.NewIntSchema())
+
+	require.NoError(t, id.Validate("abc"))
+	require.NoError(t, id.Validate(42))
+	err := id.Validate(3.14)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "string")
+	require.Contains(t, err.Error(), "int")
+
+	require.Equal(t, "union<string|int>", id.GetType())
+	require.Error(t, schema.NewUnionSchema().Validate("abc"))
+}
+
+func TestNullableSchema(t *testing.T) {
+	nickname := schema.NewNullableSchema(schema.NewStringSchema())
+
+	require.NoError(t, nickname.Validate(nil))
+	require.NoError(t, nickname.Validate("Johnny"))
+	require.Error(t, nickname.Validate(42))
+	require.Equal(t, "nullable<string>", nickname.GetType())
+
+	// Nullable fields may be present with a nil value
+	person := schema.NewObjectSchema(map[string]types.Schema{
+		"name":     schema.NewStringSchema(),
+		"nickname": nickname,
+	}, []string{"name", "nickname"})
+	require.NoError(t, person.Validate(map[string]interface{}{"name": "John", "nickname": nil}))
+	require.Error(t, person.Validate(map[string]interface{}{"name": nil, "nickname": nil}))
+}
+
 func TestSchemaRegistry(t *testing.T) {
 	registry := schema.NewRegistry()
 
@@ -303,3 +438,852 @@ func TestListTypes(t *testing.T) {
 	require.NoError(t, err)
 	require.Contains(t, versions, "1.0")
 }
+
+// memoryStore is an in-memory schema.Store
+type memoryStore struct {
+	records []*types.SchemaRecord
+}
+
+func (m *memoryStore) SaveSchema(record *types.SchemaRecord) error {
+	for _, existing := range m.records {
+		if existing.Type == record.Type && existing.Version == record.Version {
+			return fmt.Errorf("schema %s version %s already stored", record.Type, record.Version)
+		}
+	}
+	m.records = append(m.records, record)
+	return nil
+}
+
+func (m *memoryStore) ListSchemas() ([]*types.SchemaRecord, error) {
+	return m.records, nil
+}
+
+func TestPersistentRegistry(t *testing.T) {
+	store := &memoryStore{}
+	registry, err := schema.NewPersistentRegistry(store)
+	require.NoError(t, err)
+
+	// Defined schemas are saved on registration
+	_, err = registry.Define("user", "1.0", schema.Definition{
+		Type: "object",
+		Properties: map[string]*schema.Definition{
+			"name": {Type: "string"},
+			"age":  {Type: "int"},
+		},
+		Required: []string{"name"},
+	})
+	require.NoError(t, err)
+	_, err = registry.Define("team", "1.0", schema.Definition{
+		Type:  "array",
+		Items: &schema.Definition{Type: "user"},
+	})
+	require.NoError(t, err)
+	require.Len(t, store.records, 2)
+
+	// Schemas without a definition cannot be persisted
+	err = registry.Register(schema.NewObjectSchema(nil, nil))
+	require.Error(t, err)
+	_, err = registry.Get("object", "1.0")
+	require.Error(t, err)
+
+	// Invalid definitions are rejected before anything is saved
+	_, err = registry.Define("broken", "1.0", schema.Definition{Type: "no-such-type"})
+	require.Error(t, err)
+	require.Len(t, store.records, 2)
+
+	// A new registry over the same store loads every saved schema
+	reloaded, err := schema.NewPersistentRegistry(store)
+	require.NoError(t, err)
+	require.Len(t, store.records, 2)
+
+	team, err := reloaded.Get("team", "1.0")
+	require.NoError(t, err)
+	require.NoError(t, team.Validate([]interface{}{
+		map[string]interface{}{"name": "Ada", "age": int64(36)},
+	}))
+	require.Error(t, team.Validate([]interface{}{
+		map[string]interface{}{"age": int64(36)},
+	}))
+
+	// Registering a loaded schema again still fails
+	_, err = reloaded.Define("user", "1.0", schema.Definition{Type: "string"})
+	require.Error(t, err)
+}
+
+func TestJSONSchema(t *testing.T) {
+	registry := schema.NewRegistry()
+	minimum := 0.0
+	_, err := registry.Define("order", "1.0", schema.Definition{
+		Type: "object",
+		Properties: map[string]*schema.Definition{
+			"id":       {Type: "string", Pattern: `^o-[0-9]+$`},
+			"total":    {Type: "float", Minimum: &minimum},
+			"placed":   {Type: "time"},
+			"priority": {Type: "int", Enum: []interface{}{1, 2, 3}},
+			"lines":    {Type: "array", Items: &schema.Definition{Type: "string"}},
+			"extra":    {Type: "any"},
+		},
+		Required: []string{"id"},
+	})
+	require.NoError(t, err)
+
+	// Export produces a standard document
+	data, err := registry.ExportJSONSchema("order", "1.0")
+	require.NoError(t, err)
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &doc))
+	require.Equal(t, schema.JSONSchemaDialect, doc["$schema"])
+	require.Equal(t, "order", doc["title"])
+	require.Equal(t, "object", doc["type"])
+	properties := doc["properties"].(map[string]interface{})
+	require.Equal(t, map[string]interface{}{"type": "string", "format": "date-time"}, properties["placed"])
+	require.Equal(t, map[string]interface{}{"type": "number", "minimum": 0.0}, properties["total"])
+	require.Equal(t, map[string]interface{}{}, properties["extra"])
+
+	// Importing the export into another registry preserves validation
+	other := schema.NewRegistry()
+	imported, err := other.ImportJSONSchema("order", "1.0", data)
+	require.NoError(t, err)
+
+	valid := map[string]interface{}{
+		"id":       "o-42",
+		"total":    9.5,
+		"placed":   time.Now(),
+		"priority": 2,
+		"lines":    []interface{}{"a"},
+	}
+	require.NoError(t, imported.Validate(valid))
+	for field, value := range map[string]interface{}{
+		"id":       "42",
+		"total":    -1.0,
+		"priority": 5,
+		"lines":    []interface{}{1},
+	} {
+		invalid := map[string]interface{}{}
+		for k, v := range valid {
+			invalid[k] = v
+		}
+		invalid[field] = value
+		require.Error(t, imported.Validate(invalid), "expected invalid %s to fail", field)
+	}
+
+	// Unsupported keywords are rejected with their location
+	_, err = schema.ParseJSONSchema([]byte(`{"type":"object","properties":{"a":{"$ref":"#/defs/a"}}}`))
+	require.ErrorContains(t, err, "#/properties/a")
+	_, err = schema.ParseJSONSchema([]byte(`{"type":["string","integer"]}`))
+	require.Error(t, err)
+	_, err = schema.ParseJSONSchema([]byte(`{"oneOf":[{"type":"string"}]}`))
+	require.Error(t, err)
+
+	// Maps, unions and nullable schemas round-trip through JSON Schema
+	minLength := 2
+	def := schema.Definition{
+		Type: "object",
+		Properties: map[string]*schema.Definition{
+			"labels": {Type: "map", Keys: &schema.Definition{Type: "string", MinLength: &minLength}, Values: &schema.Definition{Type: "string"}},
+			"ref":    {Type: "union", Variants: []*schema.Definition{{Type: "string"}, {Type: "int"}}},
+			"note":   {Type: "string", Nullable: true},
+		},
+	}
+	_, err = registry.Define("tagged", "1.0", def)
+	require.NoError(t, err)
+	data, err = registry.ExportJSONSchema("tagged", "1.0")
+	require.NoError(t, err)
+
+	parsed, err := schema.ParseJSONSchema(data)
+	require.NoError(t, err)
+	require.Equal(t, "map", parsed.Properties["labels"].Type)
+	require.Equal(t, &minLength, parsed.Properties["labels"].Keys.MinLength)
+	require.Equal(t, "union", parsed.Properties["ref"].Type)
+	require.True(t, parsed.Properties["note"].Nullable)
+
+	tagged, err := schema.NewRegistry().Compile(parsed)
+	require.NoError(t, err)
+	require.NoError(t, tagged.Validate(map[string]interface{}{
+		"labels": map[string]interface{}{"env": "prod"},
+		"ref":    7,
+		"note":   nil,
+	}))
+	require.Error(t, tagged.Validate(map[string]interface{}{"labels": map[string]interface{}{"e": "prod"}}))
+	require.Error(t, tagged.Validate(map[string]interface{}{"ref": true}))
+
+	// A type paired with null is nullable
+	parsed, err = schema.ParseJSONSchema([]byte(`{"type":["null","integer"]}`))
+	require.NoError(t, err)
+	require.Equal(t, schema.Definition{Type: "int", Nullable: true}, parsed)
+}
+
+func TestVersionCompatibility(t *testing.T) {
+	// Versions parse and order semantically
+	for _, tt := range []struct{ a, b string }{
+		{"1.0", "1.0.1"},
+		{"1.9.0", "1.10.0"},
+		{"9.0", "10.0"},
+		{"1.0.0-alpha", "1.0.0-alpha.1"},
+		{"1.0.0-alpha.2", "1.0.0-alpha.10"},
+		{"1.0.0-rc.1", "1.0.0"},
+	} {
+		a, err := schema.ParseVersion(tt.a)
+		require.NoError(t, err)
+		b, err := schema.ParseVersion(tt.b)
+		require.NoError(t, err)
+		require.Equal(t, -1, a.Compare(b), "%s < %s", tt.a, tt.b)
+		require.Equal(t, 1, b.Compare(a), "%s > %s", tt.b, tt.a)
+	}
+	v, err := schema.ParseVersion("v2.3.4+build.5")
+	require.NoError(t, err)
+	require.Equal(t, "2.3.4", v.String())
+	for _, bad := range []string{"", "1.x", "1.2.3.4", "01.0", "1.0-"} {
+		_, err := schema.ParseVersion(bad)
+		require.Error(t, err, "expected %q to be rejected", bad)
+	}
+
+	registry := schema.NewRegistry()
+	define := func(version string) types.Schema {
+		s, err := registry.Define("event", version, schema.Definition{Type: "string"})
+		require.NoError(t, err)
+		return s
+	}
+	v1_0, v1_2, v1_2_1, v2_0 := define("1.0.0"), define("1.2.0"), define("1.2.1"), define("2.0.0")
+
+	tests := []struct {
+		producer, consumer types.Schema
+		mode               schema.CompatibilityMode
+		compatible         bool
+	}{
+		{v1_0, v1_2, schema.CompatibilityBackward, true},
+		{v1_2, v1_0, schema.CompatibilityBackward, false},
+		{v1_2, v1_0, schema.CompatibilityForward, true},
+		{v1_0, v1_2, schema.CompatibilityForward, false},
+		{v1_2, v1_2_1, schema.CompatibilityFull, true},
+		{v1_0, v1_2, schema.CompatibilityFull, false},
+		{v1_0, v2_0, schema.CompatibilityBackward, false},
+		{v1_0, v2_0, schema.CompatibilityNone, true},
+		{v1_0, schema.NewStringSchema(), schema.CompatibilityNone, false},
+	}
+	for _, tt := range tests {
+		err := schema.CheckCompatibility(tt.producer, tt.consumer, tt.mode)
+		if tt.compatible {
+			require.NoError(t, err, "%s -> %s (%s)", tt.producer.GetVersion(), tt.consumer.GetVersion(), tt.mode)
+		} else {
+			require.Error(t, err, "%s -> %s (%s)", tt.producer.GetVersion(), tt.consumer.GetVersion(), tt.mode)
+		}
+	}
+
+	// IsCompatible compares whole major versions
+	require.True(t, schema.IsCompatible(v1_0, v1_2))
+	require.False(t, schema.IsCompatible(v1_0, v2_0))
+
+	// The latest version is chosen semantically
+	define("10.0.0")
+	latest, err := registry.GetLatest("event")
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0", latest.GetVersion())
+
+	// Port connections check direction and schema compatibility
+	out := types.PortConfig{Name: "out", Direction: types.PortDirectionOutput, DataType: v1_0}
+	in := types.PortConfig{Name: "in", Direction: types.PortDirectionInput, DataType: v1_2}
+	require.NoError(t, schema.CheckConnection(out, in, schema.CompatibilityBackward))
+	require.Error(t, schema.CheckConnection(out, in, schema.CompatibilityForward))
+	require.Error(t, schema.CheckConnection(in, out, schema.CompatibilityBackward))
+	in.DataType = schema.NewAnySchema()
+	require.NoError(t, schema.CheckConnection(out, in, schema.CompatibilityFull))
+
+	mode, err := schema.ParseCompatibilityMode("FULL")
+	require.NoError(t, err)
+	require.Equal(t, schema.CompatibilityFull, mode)
+	_, err = schema.ParseCompatibilityMode("sideways")
+	require.Error(t, err)
+}
+
+func TestValidationErrors(t *testing.T) {
+	order := schema.NewObjectSchema(map[string]types.Schema{
+		"id":    schema.NewStringSchema(),
+		"total": schema.NewFloatSchema(schema.WithMinimum(0)),
+		"lines": schema.NewArraySchema(schema.NewObjectSchema(map[string]types.Schema{
+			"sku": schema.NewStringSchema(),
+			"qty": schema.NewIntSchema(schema.WithMinimum(1)),
+		}, []string{"sku"})),
+		"labels": schema.NewMapSchema(schema.NewStringSchema(), schema.NewStringSchema()),
+	}, []string{"id", "customer/ref"})
+
+	err := order.Validate(map[string]interface{}{
+		"total": -1.0,
+		"lines": []interface{}{
+			map[string]interface{}{"sku": "a", "qty": 2},
+			map[string]interface{}{"qty": 0},
+		},
+		"labels": map[string]interface{}{"env": 1},
+	})
+
+	// Every problem is reported in one pass, each with its JSON pointer
+	var validationErr *schema.ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	paths := make([]string, len(validationErr.Errors))
+	for i, fieldErr := range validationErr.Errors {
+		paths[i] = fieldErr.Path
+	}
+	require.Equal(t, []string{
+		"/id",
+		"/customer~1ref",
+		"/labels/env",
+		"/lines/1/sku",
+		"/lines/1/qty",
+		"/total",
+	}, paths)
+	require.Contains(t, err.Error(), "/total: value -1 is less than minimum 0")
+
+	// Field errors can be matched individually
+	var fieldErr *schema.FieldError
+	require.ErrorAs(t, err, &fieldErr)
+	require.Equal(t, "/id", fieldErr.Path)
+
+	// Paths continue through registered schemas
+	registry := schema.NewRegistry()
+	_, err = registry.Define("line", "1.0", schema.Definition{
+		Type:       "object",
+		Properties: map[string]*schema.Definition{"qty": {Type: "int"}},
+	})
+	require.NoError(t, err)
+	batch, err := registry.Compile(schema.Definition{Type: "array", Items: &schema.Definition{Type: "line"}})
+	require.NoError(t, err)
+	err = batch.Validate([]interface{}{map[string]interface{}{"qty": "two"}})
+	require.ErrorAs(t, err, &validationErr)
+	require.Len(t, validationErr.Errors, 1)
+	require.Equal(t, "/0/qty", validationErr.Errors[0].Path)
+
+	// Root failures have an empty path
+	err = order.Validate("not an object")
+	require.ErrorAs(t, err, &validationErr)
+	require.Equal(t, "", validationErr.Errors[0].Path)
+}
+
+func TestSchemaMigrations(t *testing.T) {
+	registry := schema.NewRegistry()
+	define := func(version string, def schema.Definition) types.Schema {
+		s, err := registry.Define("customer", version, def)
+		require.NoError(t, err)
+		return s
+	}
+	define("1.0.0", schema.Definition{
+		Type:       "object",
+		Properties: map[string]*schema.Definition{"name": {Type: "string"}},
+		Required:   []string{"name"},
+	})
+	define("1.1.0", schema.Definition{
+		Type:       "object",
+		Properties: map[string]*schema.Definition{"full_name": {Type: "string"}},
+		Required:   []string{"full_name"},
+	})
+	v2 := define("2.0.0", schema.Definition{
+		Type: "object",
+		Properties: map[string]*schema.Definition{
+			"full_name": {Type: "string"},
+			"tier":      {Type: "int"},
+		},
+		Required: []string{"full_name", "tier"},
+	})
+
+	require.NoError(t, registry.RegisterMigration("customer", "1.0.0", "1.1.0", schema.RenameField("name", "full_name")))
+	require.NoError(t, registry.RegisterMigration("customer", "1.1.0", "2.0.0", schema.DefaultField("tier", 1)))
+
+	// Migrations must move forward between registered versions, once per version
+	require.Error(t, registry.RegisterMigration("customer", "2.0.0", "1.0.0", schema.RemoveField("tier")))
+	require.Error(t, registry.RegisterMigration("customer", "1.0.0", "9.0.0", schema.RemoveField("tier")))
+	require.Error(t, registry.RegisterMigration("customer", "1.0.0", "2.0.0", schema.RemoveField("tier")))
+
+	// Migrations chain across versions without touching their input
+	input := map[string]interface{}{"name": "Ada"}
+	migrated, err := registry.Migrate("customer", "1.0.0", "2.0.0", input)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"full_name": "Ada", "tier": 1}, migrated)
+	require.Equal(t, map[string]interface{}{"name": "Ada"}, input)
+
+	// Results must match the target version
+	_, err = registry.Migrate("customer", "1.0.0", "2.0.0", map[string]interface{}{"nickname": "Ada"})
+	require.Error(t, err)
+
+	// There is no path downwards
+	_, err = registry.Migrate("customer", "2.0.0", "1.0.0", map[string]interface{}{})
+	require.Error(t, err)
+
+	// Messages produced against older versions are upgraded for ports
+	old, err := registry.Get("customer", "1.0.0")
+	require.NoError(t, err)
+	msg := types.Message{ID: "m-1", Schema: old, Data: json.RawMessage(`{"name":"Ada"}`)}
+	upgraded, err := registry.MigrateMessage(msg, v2)
+	require.NoError(t, err)
+	require.Equal(t, "2.0.0", upgraded.Schema.GetVersion())
+	require.JSONEq(t, `{"full_name":"Ada","tier":1}`, string(upgraded.Data))
+
+	// Numbers keep their exact encoding and still validate as ints
+	v1_1, err := registry.Get("customer", "1.1.0")
+	require.NoError(t, err)
+	msg = types.Message{ID: "m-2", Schema: v1_1, Data: json.RawMessage(`{"full_name":"Bob","tier":12345678901234}`)}
+	upgraded, err = registry.MigrateMessage(msg, v2)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"full_name":"Bob","tier":12345678901234}`, string(upgraded.Data))
+
+	_, err = registry.MigrateMessage(types.Message{ID: "m-3", Schema: schema.NewStringSchema()}, v2)
+	require.Error(t, err)
+}
+
+func TestCodecs(t *testing.T) {
+	registry := schema.NewRegistry()
+	order, err := registry.Define("order", "1.0.0", schema.Definition{
+		Type: "object",
+		Properties: map[string]*schema.Definition{
+			"id":       {Type: "string"},
+			"qty":      {Type: "int"},
+			"price":    {Type: "float"},
+			"paid":     {Type: "bool"},
+			"placed":   {Type: "time"},
+			"tags":     {Type: "array", Items: &schema.Definition{Type: "string"}},
+			"scores":   {Type: "array", Items: &schema.Definition{Type: "int"}},
+			"attrs":    {Type: "map", Values: &schema.Definition{Type: "string"}},
+			"ref":      {Type: "union", Variants: []*schema.Definition{{Type: "string"}, {Type: "int"}}},
+			"note":     {Type: "string", Nullable: true},
+			"discount": {Type: "float"},
+			"customer": {
+				Type:       "object",
+				Properties: map[string]*schema.Definition{"name": {Type: "string"}},
+				Required:   []string{"name"},
+			},
+		},
+		Required: []string{"id", "qty", "price", "paid", "placed", "tags", "scores", "attrs", "ref", "note", "customer"},
+	})
+	require.NoError(t, err)
+
+	placed := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	msg := types.Message{
+		ID:     "m-1",
+		Schema: order,
+		Data: json.RawMessage(`{"id":"o-1","qty":-3,"price":9.5,"paid":true,"placed":"2024-05-01T12:30:00Z",` +
+			`"tags":["a","b"],"scores":[1,300,-2],"attrs":{"k":"v"},"ref":7,"note":null,"customer":{"name":"Ada"}}`),
+	}
+	expected := map[string]interface{}{
+		"id": "o-1", "qty": int64(-3), "price": 9.5, "paid": true, "placed": placed,
+		"tags": []interface{}{"a", "b"}, "scores": []interface{}{int64(1), int64(300), int64(-2)},
+		"attrs": map[string]interface{}{"k": "v"}, "ref": int64(7), "note": nil,
+		"customer": map[string]interface{}{"name": "Ada"},
+	}
+
+	for _, name := range []string{schema.CodecAvro, schema.CodecProtobuf} {
+		t.Run(name, func(t *testing.T) {
+			port := types.PortConfig{Name: "out", Direction: types.PortDirectionOutput, DataType: order, Codec: name}
+			encoded, err := schema.EncodeMessage(msg, port)
+			require.NoError(t, err)
+
+			codec, err := schema.GetCodec(name)
+			require.NoError(t, err)
+			decoded, err := codec.Decode(order, encoded)
+			require.NoError(t, err)
+			require.Equal(t, expected, decoded)
+
+			// Sources turn wire data back into messages of the port's type
+			received, err := schema.DecodeMessage("m-2", encoded, port)
+			require.NoError(t, err)
+			require.Equal(t, order, received.Schema)
+			require.JSONEq(t, `{"id":"o-1","qty":-3,"price":9.5,"paid":true,"placed":"2024-05-01T12:30:00Z",`+
+				`"tags":["a","b"],"scores":[1,300,-2],"attrs":{"k":"v"},"ref":7,"note":null,"customer":{"name":"Ada"}}`,
+				string(received.Data))
+
+			// Values that are not objects are encoded on their own
+			encoded, err = codec.Encode(schema.NewStringSchema(), "hello")
+			require.NoError(t, err)
+			decoded, err = codec.Decode(schema.NewStringSchema(), encoded)
+			require.NoError(t, err)
+			require.Equal(t, "hello", decoded)
+
+			// Missing required fields and mistyped values are rejected
+			_, err = codec.Encode(order, map[string]interface{}{"qty": 1})
+			require.Error(t, err)
+			_, err = codec.Encode(schema.NewIntSchema(), "seven")
+			require.Error(t, err)
+		})
+	}
+
+	// Ports default to JSON and reject unknown codecs
+	port := types.PortConfig{Name: "out", DataType: order}
+	encoded, err := schema.EncodeMessage(msg, port)
+	require.NoError(t, err)
+	require.JSONEq(t, string(msg.Data), string(encoded))
+	port.Codec = "xml"
+	_, err = schema.EncodeMessage(msg, port)
+	require.Error(t, err)
+
+	// Avro and proto3 schemas describe the encodings
+	avroSchema, err := schema.ToAvroSchema(order)
+	require.NoError(t, err)
+	var avroDoc map[string]interface{}
+	require.NoError(t, json.Unmarshal(avroSchema, &avroDoc))
+	require.Equal(t, "record", avroDoc["type"])
+	require.Equal(t, "order", avroDoc["name"])
+	require.Len(t, avroDoc["fields"], 12)
+
+	proto, err := schema.ToProtoSchema(order)
+	require.NoError(t, err)
+	require.Contains(t, proto, `syntax = "proto3";`)
+	require.Contains(t, proto, `import "google/protobuf/timestamp.proto";`)
+	require.Contains(t, proto, "message Order {")
+	require.Contains(t, proto, "optional double discount = 3;")
+	require.Contains(t, proto, "repeated int64 scores = 11;")
+	require.Contains(t, proto, "map<string, string> attrs = 1;")
+	require.Contains(t, proto, "message Customer {")
+	require.Contains(t, proto, "oneof value {")
+}
+
+func TestFromStruct(t *testing.T) {
+	type Audit struct {
+		CreatedBy string `json:"created_by" validate:"required"`
+	}
+	type Line struct {
+		SKU string `json:"sku" validate:"required"`
+		Qty uint   `json:"qty"`
+	}
+	type Order struct {
+		Audit
+		ID       string            `json:"id" validate:"required"`
+		Lines    []Line            `json:"lines,omitempty" validate:"required,dive"`
+		Labels   map[string]string `json:"labels"`
+		Note     *string           `json:"note"`
+		Total    float64           `json:"total"`
+		Paid     bool              `json:"paid"`
+		Placed   time.Time         `json:"placed"`
+		Extra    interface{}       `json:"extra"`
+		Internal string            `json:"-"`
+		secret   string
+	}
+
+	s, err := schema.FromStruct(&Order{})
+	require.NoError(t, err)
+	obj, ok := s.(*schema.ObjectSchema)
+	require.True(t, ok)
+	require.ElementsMatch(t, []string{"id", "lines", "created_by"}, obj.Required())
+
+	props := obj.Properties()
+	require.Len(t, props, 9)
+	require.Equal(t, "string", props["id"].GetType())
+	require.Equal(t, "array<object>", props["lines"].GetType())
+	require.Equal(t, "map<string,string>", props["labels"].GetType())
+	require.Equal(t, "nullable<string>", props["note"].GetType())
+	require.Equal(t, "float", props["total"].GetType())
+	require.Equal(t, "bool", props["paid"].GetType())
+	require.Equal(t, "time", props["placed"].GetType())
+	require.Equal(t, "nullable<any>", props["extra"].GetType())
+	require.Equal(t, "string", props["created_by"].GetType())
+	require.NotContains(t, props, "Internal")
+	require.NotContains(t, props, "secret")
+
+	// Struct values validate against their derived schema
+	order := Order{
+		Audit:  Audit{CreatedBy: "ada"},
+		ID:     "o-1",
+		Lines:  []Line{{SKU: "x", Qty: 2}},
+		Labels: map[string]string{"k": "v"},
+		Placed: time.Now(),
+	}
+	require.NoError(t, s.Validate(order))
+	require.NoError(t, s.Validate(map[string]interface{}{
+		"id": "o-1", "lines": []interface{}{map[string]interface{}{"sku": "x"}}, "created_by": "ada",
+	}))
+	require.Error(t, s.Validate(map[string]interface{}{"id": "o-1"}))
+
+	// Only structs without cycles can be converted
+	type Node struct {
+		Next *Node `json:"next"`
+	}
+	_, err = schema.FromStruct(Node{})
+	require.Error(t, err)
+	_, err = schema.FromStruct("not a struct")
+	require.Error(t, err)
+	_, err = schema.FromStruct(struct {
+		Ch chan int `json:"ch"`
+	}{})
+	require.Error(t, err)
+}
+
+// benchmarkSchema returns an order schema and a decoded JSON order
+func benchmarkSchema(tb testing.TB) (types.Schema, interface{}) {
+	registry := schema.NewRegistry()
+	order, err := registry.Define("order", "1.0.0", schema.Definition{
+		Type: "object",
+		Properties: map[string]*schema.Definition{
+			"id":  {Type: "string", MinLength: intPtr(1)},
+			"qty": {Type: "int"},
+			"lines": {Type: "array", Items: &schema.Definition{
+				Type: "object",
+				Properties: map[string]*schema.Definition{
+					"sku":   {Type: "string"},
+					"price": {Type: "float"},
+				},
+				Required: []string{"sku"},
+			}},
+			"attrs": {Type: "map", Values: &schema.Definition{Type: "string"}},
+			"note":  {Type: "string", Nullable: true},
+		},
+		Required: []string{"id", "lines"},
+	})
+	require.NoError(tb, err)
+
+	var data interface{}
+	decoder := json.NewDecoder(strings.NewReader(`{"id":"o-1","qty":2,"note":null,"attrs":{"a":"b","c":"d"},` +
+		`"lines":[{"sku":"x","price":1.5},{"sku":"y","price":2},{"sku":"z","price":3}]}`))
+	decoder.UseNumber()
+	require.NoError(tb, decoder.Decode(&data))
+	return order, data
+}
+
+func intPtr(n int) *int { return &n }
+
+func TestCompiledValidator(t *testing.T) {
+	order, data := benchmarkSchema(t)
+	validate := schema.CompileValidator(order)
+	require.NoError(t, validate(data))
+
+	// Compiled validators report the same failures as the schema
+	bad := map[string]interface{}{
+		"id":    "",
+		"qty":   "two",
+		"attrs": map[string]interface{}{"a": 1},
+		"lines": []interface{}{map[string]interface{}{"price": "free"}, "line"},
+	}
+	expected := order.Validate(bad)
+	require.Error(t, expected)
+	require.Equal(t, expected, validate(bad))
+
+	// Values that are not decoded JSON fall back to the schema
+	type line struct {
+		SKU string `json:"sku"`
+	}
+	require.Equal(t, order.Validate(map[string]interface{}{"id": "o", "lines": []line{{}}}),
+		validate(map[string]interface{}{"id": "o", "lines": []line{{}}}))
+
+	// The registry compiles each type and version once
+	registry := schema.NewRegistry()
+	first, err := registry.Validator("int", "1.0")
+	require.NoError(t, err)
+	second, err := registry.Validator("int", "1.0")
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%p", first), fmt.Sprintf("%p", second))
+	require.Error(t, first("one"))
+
+	_, err = registry.Validator("missing", "1.0")
+	require.ErrorIs(t, err, schema.ErrSchemaNotFound)
+}
+
+func BenchmarkValidate(b *testing.B) {
+	order, data := benchmarkSchema(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := order.Validate(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompiledValidate(b *testing.B) {
+	order, data := benchmarkSchema(b)
+	validate := schema.CompileValidator(order)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := validate(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPrimitiveSchemas(t *testing.T) {
+	// Decimals are bounded by precision and scale
+	price := schema.NewDecimalSchema(6, 2)
+	require.Equal(t, "decimal", price.GetType())
+	require.NoError(t, price.Validate("1234.56"))
+	require.NoError(t, price.Validate("-0001.5"))
+	require.NoError(t, price.Validate(json.Number("42")))
+	require.Error(t, price.Validate("1.234"))
+	require.Error(t, price.Validate("12345.6"))
+	require.Error(t, price.Validate("1e3"))
+	require.Error(t, price.Validate(1.5))
+	require.Panics(t, func() { schema.NewDecimalSchema(2, 3) })
+
+	// Bytes accept slices and base64 strings up to their size limit
+	payload := schema.NewBytesSchema(4)
+	require.NoError(t, payload.Validate([]byte("abcd")))
+	require.NoError(t, payload.Validate("YWJj"))
+	require.Error(t, payload.Validate([]byte("abcde")))
+	require.Error(t, payload.Validate("not base64!"))
+	require.NoError(t, schema.NewBytesSchema(0).Validate(make([]byte, 1<<20)))
+
+	// Timestamps must match their layout
+	day := schema.NewTimestampSchema("2006-01-02")
+	require.NoError(t, day.Validate("2024-05-01"))
+	require.NoError(t, day.Validate(time.Now()))
+	require.Error(t, day.Validate("2024-05-01T10:00:00Z"))
+	require.NoError(t, schema.NewTimestampSchema("").Validate("2024-05-01T10:00:00Z"))
+
+	// The defaults are built in and definitions set parameters
+	registry := schema.NewRegistry()
+	for _, builtin := range []string{"decimal", "bytes", "timestamp"} {
+		_, err := registry.GetLatest(builtin)
+		require.NoError(t, err)
+	}
+	invoice, err := registry.Define("invoice", "1.0.0", schema.Definition{
+		Type: "object",
+		Properties: map[string]*schema.Definition{
+			"amount": {Type: "decimal", Precision: intPtr(10), Scale: intPtr(2)},
+			"scan":   {Type: "bytes", MaxSize: intPtr(16)},
+			"due":    {Type: "timestamp", Layout: "2006-01-02"},
+			"raw":    {Type: "bytes"},
+		},
+		Required: []string{"amount", "scan", "due"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, invoice.Validate(map[string]interface{}{"amount": "19.99", "scan": "AAEC", "due": "2024-06-30"}))
+	require.Error(t, invoice.Validate(map[string]interface{}{"amount": "19.999", "scan": "AAEC", "due": "2024-06-30"}))
+
+	for _, def := range []schema.Definition{
+		{Type: "decimal", Precision: intPtr(0)},
+		{Type: "decimal", Precision: intPtr(4), Scale: intPtr(5)},
+		{Type: "decimal", Layout: "2006"},
+		{Type: "bytes", MaxSize: intPtr(-1)},
+		{Type: "timestamp", MaxSize: intPtr(1)},
+		{Type: "string", Precision: intPtr(3)},
+		{Type: "decimal", MinLength: intPtr(1)},
+	} {
+		_, err := registry.Compile(def)
+		require.Error(t, err, "%+v", def)
+	}
+
+	// JSON Schema keeps the parameters
+	doc, err := schema.ToJSONSchema(invoice)
+	require.NoError(t, err)
+	imported, err := registry.ImportJSONSchema("invoice", "1.0.1", doc)
+	require.NoError(t, err)
+	require.Equal(t, invoice.Definition().Properties, imported.Definition().Properties)
+
+	// Avro and protobuf carry the values exactly
+	value := map[string]interface{}{"amount": "-1234.50", "scan": []byte{0, 1, 2, 255}, "due": "2024-06-30"}
+	for _, name := range []string{schema.CodecAvro, schema.CodecProtobuf} {
+		codec, err := schema.GetCodec(name)
+		require.NoError(t, err)
+		encoded, err := codec.Encode(invoice, value)
+		require.NoError(t, err, name)
+		decoded, err := codec.Decode(invoice, encoded)
+		require.NoError(t, err, name)
+		require.Equal(t, value, decoded, name)
+	}
+
+	avroSchema, err := schema.ToAvroSchema(invoice)
+	require.NoError(t, err)
+	require.Contains(t, string(avroSchema), `"logicalType": "decimal"`)
+	proto, err := schema.ToProtoSchema(invoice)
+	require.NoError(t, err)
+	require.Contains(t, proto, "bytes scan = 4;")
+}
+
+func TestInfer(t *testing.T) {
+	decoder := json.NewDecoder(strings.NewReader(`
+		{"id": 1, "total": 9, "tags": ["a"], "customer": {"name": "Ada"}, "note": null}
+		{"id": 2, "total": 9.5, "tags": [], "customer": {"name": "Bob", "vip": true}, "code": "x"}
+		{"id": 3, "total": 4, "tags": ["b", 1], "customer": {"name": "Cy"}, "code": 7, "note": "hi"}
+	`))
+	decoder.UseNumber()
+	var samples []interface{}
+	for decoder.More() {
+		var v interface{}
+		require.NoError(t, decoder.Decode(&v))
+		samples = append(samples, v)
+	}
+
+	s := schema.Infer(samples...)
+	for _, sample := range samples {
+		require.NoError(t, s.Validate(sample))
+	}
+
+	obj, ok := s.(*schema.ObjectSchema)
+	require.True(t, ok)
+	require.Equal(t, []string{"customer", "id", "tags", "total"}, obj.Required())
+	propTypes := map[string]string{}
+	for name, prop := range obj.Properties() {
+		propTypes[name] = prop.GetType()
+	}
+	require.Equal(t, map[string]string{
+		"id":       "int",
+		"total":    "float",
+		"tags":     "array<union<int|string>>",
+		"customer": "object",
+		"code":     "union<int|string>",
+		"note":     "nullable<string>",
+	}, propTypes)
+	customer := obj.Properties()["customer"].(*schema.ObjectSchema)
+	require.Equal(t, []string{"name"}, customer.Required())
+	require.Equal(t, "bool", customer.Properties()["vip"].GetType())
+
+	// Values of different kinds, nulls and no samples at all
+	require.Equal(t, "nullable<union<int|object>>", schema.Infer(json.Number("1"), map[string]interface{}{}, nil).GetType())
+	require.Equal(t, "nullable<any>", schema.Infer().GetType())
+	require.Equal(t, "array<nullable<any>>", schema.Infer([]interface{}{}).GetType())
+}
+
+func TestSample(t *testing.T) {
+	registry := schema.NewRegistry()
+	min, max, two := 2, 5, 2
+	low, high := 10.0, 20.0
+	order, err := registry.Define("order", "1.0", schema.Definition{
+		Type: "object",
+		Properties: map[string]*schema.Definition{
+			"id":       {Type: "string", MinLength: &min, MaxLength: &max},
+			"qty":      {Type: "int", Minimum: &low, Maximum: &high},
+			"price":    {Type: "decimal", Precision: &max, Scale: &two},
+			"status":   {Type: "string", Enum: []interface{}{"new", "paid"}},
+			"placed":   {Type: "timestamp", Layout: time.RFC3339},
+			"tags":     {Type: "array", Items: &schema.Definition{Type: "string"}, MaxItems: &two},
+			"attrs":    {Type: "map", Values: &schema.Definition{Type: "float"}},
+			"note":     {Type: "string", Nullable: true},
+			"discount": {Type: "union", Variants: []*schema.Definition{{Type: "int"}, {Type: "bool"}}},
+		},
+		Required: []string{"id", "qty", "price", "status", "placed"},
+	})
+	require.NoError(t, err)
+
+	// Samples are valid and a seed repeats them
+	rng := rand.New(rand.NewSource(1))
+	var samples []interface{}
+	for i := 0; i < 200; i++ {
+		sample := schema.Sample(order, rng)
+		require.NoError(t, order.Validate(sample), "%v", sample)
+		samples = append(samples, sample)
+	}
+	rng = rand.New(rand.NewSource(1))
+	for _, sample := range samples {
+		require.Equal(t, sample, schema.Sample(order, rng))
+	}
+
+	// Optional fields are sometimes left out and nullable ones nil
+	var missing, null int
+	for _, sample := range samples {
+		note, ok := sample.(map[string]interface{})["note"]
+		if !ok {
+			missing++
+		} else if note == nil {
+			null++
+		}
+	}
+	require.Positive(t, missing)
+	require.Positive(t, null)
+
+	for _, s := range []types.Schema{
+		schema.NewBoolSchema(),
+		schema.NewTimeSchema(),
+		schema.NewFloatSchema(schema.WithMaximum(-5)),
+		schema.NewIntSchema(schema.WithEnum(3, 4)),
+		schema.NewStringSchema(schema.WithMaxLength(0)),
+		schema.NewBytesSchema(4),
+	} {
+		for i := 0; i < 20; i++ {
+			require.NoError(t, s.Validate(schema.Sample(s, rng)), s.GetType())
+		}
+	}
+}
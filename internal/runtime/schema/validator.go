@@ -0,0 +1,180 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+
+	"flow-control/internal/types"
+)
+
+// Validator checks a value against a compiled schema. It returns the same
+// errors as the schema's Validate method.
+type Validator func(data interface{}) error
+
+// validatorKey identifies a cached validator
+type validatorKey struct {
+	schemaType string
+	version    string
+}
+
+// pathFunc validates a value and records failures under path
+type pathFunc func(data interface{}, path string, errs *[]*FieldError)
+
+// CompileValidator turns a schema into a validator built from closures.
+// Property lists are sorted and child schemas compiled once, and decoded
+// JSON values (map[string]interface{} and []interface{}) are checked with
+// type switches instead of reflection. Other values, such as structs, fall
+// back to the schema's own validation.
+func CompileValidator(s types.Schema) Validator {
+	unwrapped := unwrapSchema(s)
+	if _, ok := unwrapped.(pathValidator); !ok {
+		return s.Validate
+	}
+
+	fn := compilePath(unwrapped)
+	return func(data interface{}) error {
+		var errs []*FieldError
+		fn(data, "", &errs)
+		if len(errs) == 0 {
+			return nil
+		}
+		return &ValidationError{Errors: errs}
+	}
+}
+
+// Validator returns the compiled validator of a registered schema, compiling
+// it on first use. Registered schemas never change, so validators are cached
+// for the registry's lifetime.
+func (r *SchemaRegistry) Validator(schemaType, version string) (Validator, error) {
+	key := validatorKey{schemaType: schemaType, version: version}
+	r.mu.RLock()
+	validator, ok := r.validators[key]
+	r.mu.RUnlock()
+	if ok {
+		return validator, nil
+	}
+
+	s, err := r.Get(schemaType, version)
+	if err != nil {
+		return nil, err
+	}
+	validator = CompileValidator(s)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cached, ok := r.validators[key]; ok {
+		return cached, nil
+	}
+	r.validators[key] = validator
+	return validator, nil
+}
+
+// compilePath compiles a schema into a path validator
+func compilePath(s types.Schema) pathFunc {
+	switch v := unwrapSchema(s).(type) {
+	case *BasicSchema:
+		return func(data interface{}, path string, errs *[]*FieldError) {
+			if err := v.Validate(data); err != nil {
+				*errs = append(*errs, &FieldError{Path: path, Message: err.Error()})
+			}
+		}
+
+	case *ArraySchema:
+		elem := compilePath(v.elementSchema)
+		constrained := !v.constraints.empty()
+		return func(data interface{}, path string, errs *[]*FieldError) {
+			items, ok := data.([]interface{})
+			if !ok {
+				v.validatePath(data, path, errs)
+				return
+			}
+			if constrained {
+				if err := v.constraints.check(items); err != nil {
+					*errs = append(*errs, &FieldError{Path: path, Message: err.Error()})
+				}
+			}
+			for i, item := range items {
+				elem(item, pointerIndex(path, i), errs)
+			}
+		}
+
+	case *ObjectSchema:
+		names := sortedProperties(v)
+		props := make([]pathFunc, len(names))
+		for i, name := range names {
+			props[i] = compilePath(v.properties[name])
+		}
+		return func(data interface{}, path string, errs *[]*FieldError) {
+			m, ok := data.(map[string]interface{})
+			if !ok {
+				v.validatePath(data, path, errs)
+				return
+			}
+			for _, req := range v.required {
+				if _, ok := m[req]; !ok {
+					*errs = append(*errs, &FieldError{Path: pointerField(path, req), Message: "missing required field"})
+				}
+			}
+			for i, name := range names {
+				if value, ok := m[name]; ok {
+					props[i](value, pointerField(path, name), errs)
+				}
+			}
+		}
+
+	case *MapSchema:
+		keys := CompileValidator(v.keySchema)
+		values := compilePath(v.valueSchema)
+		return func(data interface{}, path string, errs *[]*FieldError) {
+			m, ok := data.(map[string]interface{})
+			if !ok {
+				v.validatePath(data, path, errs)
+				return
+			}
+			for _, key := range sortedKeys(m) {
+				entryPath := pointerField(path, key)
+				if err := keys(key); err != nil {
+					*errs = append(*errs, &FieldError{Path: entryPath, Message: "invalid key: " + err.Error()})
+				}
+				values(m[key], entryPath, errs)
+			}
+		}
+
+	case *UnionSchema:
+		variants := make([]Validator, len(v.variants))
+		for i, variant := range v.variants {
+			variants[i] = CompileValidator(variant)
+		}
+		return func(data interface{}, path string, errs *[]*FieldError) {
+			if len(variants) == 0 {
+				*errs = append(*errs, &FieldError{Path: path, Message: "union has no variants"})
+				return
+			}
+			problems := make([]string, 0, len(variants))
+			for i, variant := range variants {
+				err := variant(data)
+				if err == nil {
+					return
+				}
+				problems = append(problems, fmt.Sprintf("%s: %v", v.variants[i].GetType(), err))
+			}
+			*errs = append(*errs, &FieldError{
+				Path:    path,
+				Message: fmt.Sprintf("value matches no variant of %s (%s)", v.GetType(), strings.Join(problems, "; ")),
+			})
+		}
+
+	case *NullableSchema:
+		inner := compilePath(v.inner)
+		return func(data interface{}, path string, errs *[]*FieldError) {
+			if data != nil {
+				inner(data, path, errs)
+			}
+		}
+
+	default:
+		return func(data interface{}, path string, errs *[]*FieldError) {
+			validateAt(s, data, path, errs)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+/*
+Package script runs the scripts of Script nodes, for logic too complex for
+the single expressions of package expr. Scripts are Starlark
+(https://github.com/bazelbuild/starlark), a small dialect of Python, with
+while loops and if and for statements allowed at the top level. The
+variables given to a script, such as the message payload msg, are
+predeclared, along with functions to pass results on:
+
+	# Price the items in stock and flag large orders
+	total = 0
+	for item in msg['items']:
+		if item['stock'] > 0:
+			total += item['qty'] * item['price']
+	msg['total'] = total
+	if total == 0:
+		drop()
+	elif total > 1000:
+		msg['review'] = True
+
+The functions are:
+
+	emit(value)     sends a message with value as its payload
+	drop()          drops the message and ends the script
+	fail(reason)    fails the message with reason
+	log(value)      logs value
+
+Scripts change their variables in place or assign them new values. Values
+are converted from and to those of encoding/json: objects are dicts, arrays
+are lists and whole numbers are ints. The common indentation of the lines of
+a script is removed, so that scripts may be indented within flow source.
+
+Scripts are sandboxed: they only see the values given to them, and Limits
+bound the steps they run, how long they run and the size of the values they
+pass on. Starlark does not bound the memory a script allocates meanwhile,
+which the step limit only does roughly.
+*/
+package script
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Defaults of Limits
+const (
+	DefaultMaxSteps      = 1000000
+	DefaultTimeout       = time.Second
+	DefaultMaxValueBytes = 1 << 20
+)
+
+var (
+	// ErrStepLimit is returned by scripts running more steps than allowed
+	ErrStepLimit = errors.New("script step limit exceeded")
+	// ErrTimeout is returned by scripts running longer than allowed
+	ErrTimeout = errors.New("script timed out")
+	// ErrValueTooLarge is returned by scripts passing on values larger than
+	// allowed
+	ErrValueTooLarge = errors.New("script value too large")
+
+	// errDrop ends the scripts calling drop
+	errDrop = errors.New("message dropped")
+)
+
+// filename names scripts in Starlark positions
+const filename = "script"
+
+// maxDepth bounds the nesting of the values converted from Starlark, which
+// may contain themselves
+const maxDepth = 1000
+
+// fileOptions are the Starlark dialect of scripts
+var fileOptions = &syntax.FileOptions{
+	While:           true,
+	TopLevelControl: true,
+	GlobalReassign:  true,
+}
+
+// Limits bound what a script may do. Zero fields take their defaults.
+type Limits struct {
+	// MaxSteps is the most Starlark execution steps a run takes, roughly
+	// the operations it evaluates
+	MaxSteps int
+	// Timeout bounds how long a run takes
+	Timeout time.Duration
+	// MaxValueBytes bounds the size of the values a script emits and logs,
+	// and of its variables when it ends, as encoded in JSON
+	MaxValueBytes int
+}
+
+// withDefaults returns the limits with defaults in place of zero fields
+func (l Limits) withDefaults() Limits {
+	if l.MaxSteps <= 0 {
+		l.MaxSteps = DefaultMaxSteps
+	}
+	if l.Timeout <= 0 {
+		l.Timeout = DefaultTimeout
+	}
+	if l.MaxValueBytes <= 0 {
+		l.MaxValueBytes = DefaultMaxValueBytes
+	}
+	return l
+}
+
+// Error is a syntax or runtime error at a line of a script
+type Error struct {
+	// Line is the 1-based line of the error in the script
+	Line int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Script is a compiled script
+type Script struct {
+	program *starlark.Program
+	vars    []string
+}
+
+// builtins are the functions predeclared in scripts, besides their
+// variables
+var builtins = []string{"emit", "drop", "fail", "log"}
+
+// Compile compiles a script whose variables are named vars
+func Compile(src string, vars ...string) (*Script, error) {
+	names := make(map[string]bool, len(vars)+len(builtins))
+	for _, name := range append(builtins, vars...) {
+		names[name] = true
+	}
+	_, program, err := starlark.SourceProgramOptions(fileOptions, filename, dedent(src), func(name string) bool {
+		return names[name]
+	})
+	if err != nil {
+		var syntaxErr syntax.Error
+		var resolveErrs resolve.ErrorList
+		switch {
+		case errors.As(err, &syntaxErr):
+			return nil, &Error{Line: int(syntaxErr.Pos.Line), Err: errors.New(syntaxErr.Msg)}
+		case errors.As(err, &resolveErrs) && len(resolveErrs) > 0:
+			return nil, &Error{Line: int(resolveErrs[0].Pos.Line), Err: errors.New(resolveErrs[0].Msg)}
+		}
+		return nil, err
+	}
+	return &Script{program: program, vars: vars}, nil
+}
+
+// dedent removes the indentation common to the lines of src that are not
+// blank
+func dedent(src string) string {
+	lines := strings.Split(src, "\n")
+	prefix := ""
+	first := true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if first {
+			prefix, first = indent, false
+			continue
+		}
+		for !strings.HasPrefix(indent, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, prefix)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Result is the outcome of a run
+type Result struct {
+	// Vars are the variables when the script ended
+	Vars map[string]interface{}
+	// Emitted are the payloads emitted, in order
+	Emitted []interface{}
+	// Dropped reports whether the script dropped the message
+	Dropped bool
+	// Logs are the values logged, in order, with values other than strings
+	// encoded in JSON
+	Logs []string
+}
+
+// Run runs the script with vars as the values of its variables, which it
+// may modify. Values are those of encoding/json. Scripts failing still
+// return a result, holding what they logged before the error.
+func (s *Script) Run(ctx context.Context, vars map[string]interface{}, limits Limits) (*Result, error) {
+	limits = limits.withDefaults()
+	ctx, cancel := context.WithTimeout(ctx, limits.Timeout)
+	defer cancel()
+
+	r := &run{limits: limits, res: &Result{}}
+	predeclared := starlark.StringDict{
+		"emit": starlark.NewBuiltin("emit", r.emit),
+		"drop": starlark.NewBuiltin("drop", r.drop),
+		"fail": starlark.NewBuiltin("fail", r.fail),
+		"log":  starlark.NewBuiltin("log", r.log),
+	}
+	for _, name := range s.vars {
+		value, err := toStarlark(vars[name])
+		if err != nil {
+			return r.res, fmt.Errorf("failed to convert %s: %w", name, err)
+		}
+		predeclared[name] = value
+	}
+
+	thread := &starlark.Thread{Name: filename}
+	thread.SetMaxExecutionSteps(uint64(limits.MaxSteps))
+	stop := context.AfterFunc(ctx, func() { thread.Cancel(ctx.Err().Error()) })
+	defer stop()
+
+	globals, err := s.program.Init(thread, predeclared)
+	if errors.Is(err, errDrop) {
+		r.res.Dropped, err = true, nil
+	}
+	if err != nil {
+		switch {
+		case thread.ExecutionSteps() >= uint64(limits.MaxSteps):
+			err = withLine(err, ErrStepLimit)
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			err = withLine(err, ErrTimeout)
+		case ctx.Err() != nil:
+			err = withLine(err, ctx.Err())
+		default:
+			err = withLine(err, nil)
+		}
+		return &Result{Logs: r.res.Logs}, err
+	}
+
+	r.res.Vars = make(map[string]interface{}, len(s.vars))
+	for _, name := range s.vars {
+		value, ok := globals[name]
+		if !ok {
+			value = predeclared[name]
+		}
+		converted, err := r.convert(value)
+		if err != nil {
+			return &Result{Logs: r.res.Logs}, fmt.Errorf("%s: %w", name, err)
+		}
+		r.res.Vars[name] = converted
+	}
+	return r.res, nil
+}
+
+// withLine returns err, a Starlark error, as an *Error at the line of the
+// script it occurred on, with cause in place of its own error if not nil
+func withLine(err error, cause error) error {
+	var evalErr *starlark.EvalError
+	if !errors.As(err, &evalErr) {
+		if cause != nil {
+			return cause
+		}
+		return err
+	}
+	if cause == nil {
+		if cause = evalErr.Unwrap(); cause == nil {
+			cause = errors.New(evalErr.Msg)
+		}
+	}
+	for i := range evalErr.CallStack {
+		if pos := evalErr.CallStack.At(i).Pos; pos.Filename() == filename {
+			return &Error{Line: int(pos.Line), Err: cause}
+		}
+	}
+	return cause
+}
+
+// run holds the state of one run of a script
+type run struct {
+	limits Limits
+	res    *Result
+}
+
+func (r *run) emit(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var value starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &value); err != nil {
+		return nil, err
+	}
+	payload, err := r.convert(value)
+	if err != nil {
+		return nil, err
+	}
+	r.res.Emitted = append(r.res.Emitted, payload)
+	return starlark.None, nil
+}
+
+func (r *run) drop(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	return nil, errDrop
+}
+
+func (r *run) fail(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var reason starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &reason); err != nil {
+		return nil, err
+	}
+	text, err := r.text(reason)
+	if err != nil {
+		return nil, err
+	}
+	return nil, errors.New(text)
+}
+
+func (r *run) log(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var value starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &value); err != nil {
+		return nil, err
+	}
+	text, err := r.text(value)
+	if err != nil {
+		return nil, err
+	}
+	r.res.Logs = append(r.res.Logs, text)
+	return starlark.None, nil
+}
+
+// text returns value as a string if it is one, or else encoded in JSON
+func (r *run) text(value starlark.Value) (string, error) {
+	if s, ok := value.(starlark.String); ok {
+		if len(s) > r.limits.MaxValueBytes {
+			return "", ErrValueTooLarge
+		}
+		return string(s), nil
+	}
+	converted, err := r.convert(value)
+	if err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(converted)
+	return string(encoded), err
+}
+
+// convert returns value as a value of encoding/json, failing if it is
+// larger than allowed once encoded
+func (r *run) convert(value starlark.Value) (interface{}, error) {
+	converted, err := fromStarlark(value, 0)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(converted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", value.Type(), err)
+	}
+	if len(encoded) > r.limits.MaxValueBytes {
+		return nil, ErrValueTooLarge
+	}
+	return converted, nil
+}
+
+// toStarlark converts a value of encoding/json to Starlark
+func toStarlark(value interface{}) (starlark.Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case float64:
+		// Whole numbers are ints, as scripts expect of counts and indexes
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return starlark.MakeInt64(int64(v)), nil
+		}
+		return starlark.Float(v), nil
+	case string:
+		return starlark.String(v), nil
+	case []interface{}:
+		elements := make([]starlark.Value, len(v))
+		for i, element := range v {
+			converted, err := toStarlark(element)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = converted
+		}
+		return starlark.NewList(elements), nil
+	case map[string]interface{}:
+		// Dicts keep the order of their keys, which are sorted as when
+		// encoded in JSON
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		dict := starlark.NewDict(len(v))
+		for _, key := range keys {
+			converted, err := toStarlark(v[key])
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(starlark.String(key), converted); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("unsupported value of type %T", value)
+}
+
+// fromStarlark converts a Starlark value to a value of encoding/json
+func fromStarlark(value starlark.Value, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("value nested more than %d deep", maxDepth)
+	}
+	switch v := value.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return v.BigInt(), nil
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case *starlark.List, starlark.Tuple:
+		seq := v.(starlark.Indexable)
+		array := make([]interface{}, seq.Len())
+		for i := range array {
+			converted, err := fromStarlark(seq.Index(i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			array[i] = converted
+		}
+		return array, nil
+	case *starlark.Dict:
+		object := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("object keys must be strings, got %s", item[0].Type())
+			}
+			converted, err := fromStarlark(item[1], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			object[string(key)] = converted
+		}
+		return object, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to JSON", value.Type())
+}
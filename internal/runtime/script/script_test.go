@@ -0,0 +1,127 @@
+package script_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"flow-control/internal/runtime/script"
+
+	"github.com/stretchr/testify/require"
+)
+
+func run(t *testing.T, src, payload string, limits script.Limits) (*script.Result, error) {
+	t.Helper()
+	s, err := script.Compile(src, "msg")
+	require.NoError(t, err)
+	var msg interface{}
+	require.NoError(t, json.Unmarshal([]byte(payload), &msg))
+	return s.Run(context.Background(), map[string]interface{}{"msg": msg}, limits)
+}
+
+func TestRun(t *testing.T) {
+	src := `
+		# Price the items in stock
+		total = 0
+		skipped = []
+		for item in msg['items']:
+			if item['stock'] == 0:
+				skipped.append(item['sku'])
+				continue
+			elif item['qty'] > 10:
+				fail('too many ' + item['sku'])
+			total += item['qty'] * item['price']
+		msg['summary'] = {'total': total, 'skipped': skipped}
+		if total == 0:
+			drop()
+		else:
+			msg['review'] = total > 100`
+
+	res, err := run(t, src, `{"items": [
+		{"sku": "a", "qty": 2, "price": 30, "stock": 1},
+		{"sku": "b", "qty": 1, "price": 9, "stock": 0},
+		{"sku": "c", "qty": 1, "price": 50.5, "stock": 4}
+	]}`, script.Limits{})
+	require.NoError(t, err)
+	require.False(t, res.Dropped)
+	msg := res.Vars["msg"].(map[string]interface{})
+	require.Equal(t, map[string]interface{}{"total": 110.5, "skipped": []interface{}{"b"}}, msg["summary"])
+	require.Equal(t, true, msg["review"])
+
+	res, err = run(t, src, `{"items": [{"sku": "b", "qty": 1, "price": 9, "stock": 0}]}`, script.Limits{})
+	require.NoError(t, err)
+	require.True(t, res.Dropped)
+
+	_, err = run(t, src, `{"items": [{"sku": "a", "qty": 11, "price": 1, "stock": 1}]}`, script.Limits{})
+	require.EqualError(t, err, "line 10: too many a")
+
+	// Emitting replaces the message; objects iterate over their keys in
+	// order, and whole numbers are ints
+	res, err = run(t, `
+		for name in msg:
+			emit({'name': name, 'value': msg[name]})
+		n = 0
+		while True:
+			n += 1
+			if n == 3:
+				break
+		emit(n)
+		log('done after %d' % n)
+		log({'n': n})
+		msg = None`, `{"b": 2, "a": 1}`, script.Limits{})
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{
+		map[string]interface{}{"name": "a", "value": int64(1)},
+		map[string]interface{}{"name": "b", "value": int64(2)},
+		int64(3),
+	}, res.Emitted)
+	require.Equal(t, []string{"done after 3", `{"n":3}`}, res.Logs)
+	require.Nil(t, res.Vars["msg"])
+}
+
+func TestLimits(t *testing.T) {
+	_, err := run(t, "while True:\n\tpass", `{}`, script.Limits{MaxSteps: 1000})
+	require.ErrorIs(t, err, script.ErrStepLimit)
+	require.EqualError(t, err, "line 1: script step limit exceeded")
+
+	start := time.Now()
+	_, err = run(t, "while True:\n\tpass", `{}`, script.Limits{MaxSteps: 1 << 40, Timeout: 50 * time.Millisecond})
+	require.ErrorIs(t, err, script.ErrTimeout)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	_, err = run(t, "\nemit('"+strings.Repeat("a,", 600)+"'.split(','))", `{}`, script.Limits{MaxValueBytes: 1024})
+	require.ErrorIs(t, err, script.ErrValueTooLarge)
+	require.EqualError(t, err, "line 2: script value too large")
+
+	_, err = run(t, "msg['s'] = 'x' * 2048", `{}`, script.Limits{MaxValueBytes: 1024})
+	require.ErrorIs(t, err, script.ErrValueTooLarge)
+
+	// Values containing themselves cannot be passed on
+	_, err = run(t, "l = []\nl.append(l)\nemit(l)", `{}`, script.Limits{})
+	require.ErrorContains(t, err, "line 3: value nested more than")
+}
+
+func TestErrors(t *testing.T) {
+	for src, want := range map[string]string{
+		"x =":                          "line 1: got end of file, want primary expression",
+		"\nif True:\nx = 1":            "line 3: got identifier, want indent",
+		"emit(y)":                      "line 1: undefined: y",
+		"def f():\n\treturn 1\nreturn": "line 3: return statement not within a function",
+	} {
+		_, err := script.Compile(src, "msg")
+		require.EqualError(t, err, want, src)
+	}
+
+	for src, want := range map[string]string{
+		"msg = 1\nmsg.y = 2":         "line 2: can't assign to .y field of int",
+		"\n\nemit(1 // 0)":           "line 3: floored division by zero",
+		"fail({'code': 1})":          `line 1: {"code":1}`,
+		"emit({1: 2})":               "line 1: object keys must be strings, got int",
+		"def f():\n\tfail('x')\nf()": "line 2: x",
+	} {
+		_, err := run(t, src, `{}`, script.Limits{})
+		require.EqualError(t, err, want, src)
+	}
+}
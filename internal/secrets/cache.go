@@ -0,0 +1,182 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// Renewal bounds: leases are renewed at half their duration, but Run
+// checks at least every maxRenewWait and at most every minRenewWait
+const (
+	minRenewWait = time.Second
+	maxRenewWait = time.Minute
+)
+
+// Cache keeps the secrets of a provider. Secrets with a lease are kept
+// until the lease expires, and Run renews the leases that can be renewed,
+// once for all the secrets sharing a lease; other secrets are kept for the
+// cache's TTL.
+type Cache struct {
+	provider Provider
+	ttl      time.Duration
+	log      types.Logger
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+// cacheEntry is a cached secret
+type cacheEntry struct {
+	secret  Secret
+	fetched time.Time
+	expires time.Time
+}
+
+// NewCache creates a cache of provider. Secrets without a lease are kept
+// for ttl, or fetched every time while ttl is zero.
+func NewCache(provider Provider, ttl time.Duration, log types.Logger) *Cache {
+	return &Cache{
+		provider: provider,
+		ttl:      ttl,
+		log:      log,
+		entries:  make(map[string]*cacheEntry),
+	}
+}
+
+// Get implements Provider.Get, fetching the secret from the provider when
+// it is not cached or has expired
+func (c *Cache) Get(ctx context.Context, name string) (Secret, error) {
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.entries[name]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.secret, nil
+	}
+
+	secret, err := c.provider.Get(ctx, name)
+	if err != nil {
+		return Secret{}, err
+	}
+	entry = &cacheEntry{secret: secret, fetched: now, expires: now.Add(c.ttl)}
+	if secret.LeaseDuration > 0 {
+		entry.expires = now.Add(time.Duration(secret.LeaseDuration) * time.Second)
+	}
+	c.mu.Lock()
+	if entry.expires.After(now) {
+		c.entries[name] = entry
+	}
+	c.mu.Unlock()
+	return secret, nil
+}
+
+// Renew renews the leases of the cached secrets that have renewable
+// leases. Secrets whose lease fails to renew are dropped, so that they are
+// fetched again, and the errors of all are returned.
+func (c *Cache) Renew(ctx context.Context) error {
+	return c.renew(ctx, func(cacheEntry) bool { return true })
+}
+
+// Run renews leases as they reach half their duration until ctx is done
+func (c *Cache) Run(ctx context.Context) {
+	timer := time.NewTimer(c.nextRenewal())
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			if err := c.renewDue(ctx); err != nil {
+				c.log.Error("Failed to renew secret leases", err, types.Fields{
+					"function": "Run",
+				})
+			}
+			timer.Reset(c.nextRenewal())
+		}
+	}
+}
+
+// leases returns the cached secrets with renewable leases
+func (c *Cache) leases() map[string]cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	leases := make(map[string]cacheEntry)
+	for name, entry := range c.entries {
+		if entry.secret.LeaseID != "" && entry.secret.Renewable {
+			leases[name] = *entry
+		}
+	}
+	return leases
+}
+
+// renewDue renews the leases that have reached half their duration
+func (c *Cache) renewDue(ctx context.Context) error {
+	now := time.Now()
+	return c.renew(ctx, func(entry cacheEntry) bool { return !now.Before(renewAt(entry)) })
+}
+
+// renew renews the renewable leases that due selects for any of the
+// secrets holding them, once per lease
+func (c *Cache) renew(ctx context.Context, due func(cacheEntry) bool) error {
+	renewer, ok := c.provider.(Renewer)
+	if !ok {
+		return nil
+	}
+	leases := c.leases()
+	holders := make(map[string][]string)
+	for name, entry := range leases {
+		holders[entry.secret.LeaseID] = append(holders[entry.secret.LeaseID], name)
+	}
+	var errs []error
+	for leaseID, names := range holders {
+		slices.Sort(names)
+		if !slices.ContainsFunc(names, func(name string) bool { return due(leases[name]) }) {
+			continue
+		}
+		renewed, err := renewer.Renew(ctx, leases[names[0]].secret)
+		now := time.Now()
+		c.mu.Lock()
+		for _, name := range names {
+			if err != nil {
+				delete(c.entries, name)
+				continue
+			}
+			secret := leases[name].secret
+			secret.LeaseDuration = renewed.LeaseDuration
+			secret.Renewable = renewed.Renewable
+			c.entries[name] = &cacheEntry{
+				secret:  secret,
+				fetched: now,
+				expires: now.Add(time.Duration(secret.LeaseDuration) * time.Second),
+			}
+		}
+		c.mu.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("lease %s of secrets %s: %w", leaseID, strings.Join(names, ", "), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// nextRenewal returns how long to wait for the next lease to renew
+func (c *Cache) nextRenewal() time.Duration {
+	wait := maxRenewWait
+	for _, entry := range c.leases() {
+		if until := time.Until(renewAt(entry)); until < wait {
+			wait = until
+		}
+	}
+	return max(wait, minRenewWait)
+}
+
+// renewAt returns when the lease of entry reaches half its duration
+func renewAt(entry cacheEntry) time.Time {
+	return entry.fetched.Add(entry.expires.Sub(entry.fetched) / 2)
+}
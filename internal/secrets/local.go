@@ -0,0 +1,64 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EnvProvider reads secrets from environment variables named by a prefix
+// and the secret's name, such as SECRET_API_TOKEN
+type EnvProvider struct {
+	prefix string
+	lookup func(string) (string, bool)
+}
+
+// NewEnvProvider creates a provider reading the variables that lookup
+// finds, usually os.LookupEnv
+func NewEnvProvider(prefix string, lookup func(string) (string, bool)) *EnvProvider {
+	return &EnvProvider{prefix: prefix, lookup: lookup}
+}
+
+// Get returns the value of the variable of the secret called name
+func (p *EnvProvider) Get(ctx context.Context, name string) (Secret, error) {
+	if err := checkName(name); err != nil {
+		return Secret{}, err
+	}
+	value, ok := p.lookup(p.prefix + name)
+	if !ok {
+		return Secret{}, fmt.Errorf("%w: environment variable %s is not set", ErrNotFound, p.prefix+name)
+	}
+	return Secret{Value: value}, nil
+}
+
+// FileProvider reads secrets from files named after them in a directory,
+// as mounted by Docker and Kubernetes
+type FileProvider struct {
+	dir string
+}
+
+// NewFileProvider creates a provider reading the files in dir
+func NewFileProvider(dir string) *FileProvider {
+	return &FileProvider{dir: dir}
+}
+
+// Get returns the content of the file of the secret called name, without
+// its trailing newline
+func (p *FileProvider) Get(ctx context.Context, name string) (Secret, error) {
+	if err := checkName(name); err != nil {
+		return Secret{}, err
+	}
+	path := filepath.Join(p.dir, name)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Secret{}, fmt.Errorf("%w: no file %s", ErrNotFound, path)
+	}
+	if err != nil {
+		return Secret{}, fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return Secret{Value: strings.TrimRight(string(data), "\r\n")}, nil
+}
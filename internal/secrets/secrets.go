@@ -0,0 +1,131 @@
+// Package secrets resolves the secrets that flow configs and sensitive
+// settings refer to, so that their values never live in a config.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// ErrNotFound is returned by providers for secrets they do not hold
+var ErrNotFound = errors.New("secret not found")
+
+// refPattern matches secret references. Configs refer to secrets as
+// ${secret.NAME}.
+var refPattern = regexp.MustCompile(`\$\{secret\.([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// namePattern matches the names of secrets
+var namePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Secret is the value of a secret. Secrets with a lease, such as the
+// dynamic credentials of Vault, expire after LeaseDuration unless renewed.
+type Secret struct {
+	Value         string
+	LeaseID       string
+	LeaseDuration int
+	Renewable     bool
+}
+
+// Provider looks up secrets by name
+type Provider interface {
+	// Get returns the secret called name, or ErrNotFound
+	Get(ctx context.Context, name string) (Secret, error)
+}
+
+// Renewer is implemented by providers whose secrets have leases
+type Renewer interface {
+	// Renew extends the lease of secret, returning it with the new lease
+	Renew(ctx context.Context, secret Secret) (Secret, error)
+}
+
+// checkName rejects names that cannot appear in a reference, which also
+// keeps them from escaping a directory or a Vault path
+func checkName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid secret name %q", name)
+	}
+	return nil
+}
+
+// References returns the names of the secrets s refers to, sorted and
+// without duplicates
+func References(s string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, match := range refPattern.FindAllStringSubmatch(s, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve replaces the secret references in s with the values of the
+// secrets from p
+func Resolve(ctx context.Context, p Provider, s string) (string, error) {
+	names := References(s)
+	if len(names) == 0 {
+		return s, nil
+	}
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		secret, err := p.Get(ctx, name)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve secret %s: %w", name, err)
+		}
+		values[name] = secret.Value
+	}
+	return refPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		return values[refPattern.FindStringSubmatch(ref)[1]]
+	}), nil
+}
+
+// ResolveValue resolves the secret references in the strings of value,
+// which may nest maps and slices as decoded from JSON or node settings.
+// The result is a copy; value is not modified.
+func ResolveValue(ctx context.Context, p Provider, value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		return Resolve(ctx, p, value)
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			v, err := ResolveValue(ctx, p, child)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			resolved[key] = v
+		}
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, len(value))
+		for i, child := range value {
+			v, err := ResolveValue(ctx, p, child)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			resolved[i] = v
+		}
+		return resolved, nil
+	default:
+		return value, nil
+	}
+}
+
+// ResolveSettings resolves the secret references in node settings,
+// returning a copy
+func ResolveSettings(ctx context.Context, p Provider, settings map[string]interface{}) (map[string]interface{}, error) {
+	if settings == nil {
+		return nil, nil
+	}
+	resolved, err := ResolveValue(ctx, p, settings)
+	if err != nil {
+		return nil, err
+	}
+	return resolved.(map[string]interface{}), nil
+}
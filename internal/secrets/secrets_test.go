@@ -0,0 +1,186 @@
+package secrets_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"flow-control/internal/logger"
+	"flow-control/internal/secrets"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	env := map[string]string{"SECRET_USER": "admin", "SECRET_PASSWORD": "hunter2"}
+	p := secrets.NewEnvProvider("SECRET_", func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+
+	require.Equal(t, []string{"PASSWORD", "USER"}, secrets.References("${secret.USER}:${secret.PASSWORD}@${secret.USER}"))
+
+	// Every reference in a string is replaced
+	s, err := secrets.Resolve(ctx, p, "postgres://${secret.USER}:${secret.PASSWORD}@db/flows")
+	require.NoError(t, err)
+	require.Equal(t, "postgres://admin:hunter2@db/flows", s)
+
+	// Settings are resolved at any depth, leaving the original untouched
+	settings := map[string]interface{}{
+		"url":     "https://api.example.com",
+		"retries": 3.0,
+		"auth":    map[string]interface{}{"password": "${secret.PASSWORD}"},
+		"headers": []interface{}{"X-User: ${secret.USER}"},
+	}
+	resolved, err := secrets.ResolveSettings(ctx, p, settings)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"url":     "https://api.example.com",
+		"retries": 3.0,
+		"auth":    map[string]interface{}{"password": "hunter2"},
+		"headers": []interface{}{"X-User: admin"},
+	}, resolved)
+	require.Equal(t, "${secret.PASSWORD}", settings["auth"].(map[string]interface{})["password"])
+
+	// Missing secrets are reported with the setting that refers to them
+	_, err = secrets.ResolveSettings(ctx, p, map[string]interface{}{"auth": map[string]interface{}{"token": "${secret.TOKEN}"}})
+	require.ErrorIs(t, err, secrets.ErrNotFound)
+	require.Contains(t, err.Error(), "auth: token: failed to resolve secret TOKEN")
+}
+
+func TestFileProvider(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "API_TOKEN"), []byte("abc123\n"), 0o600))
+	p := secrets.NewFileProvider(dir)
+
+	// Mounted files keep their value without the trailing newline
+	secret, err := p.Get(ctx, "API_TOKEN")
+	require.NoError(t, err)
+	require.Equal(t, "abc123", secret.Value)
+
+	_, err = p.Get(ctx, "MISSING")
+	require.ErrorIs(t, err, secrets.ErrNotFound)
+
+	// Names cannot leave the directory
+	_, err = p.Get(ctx, "../API_TOKEN")
+	require.Error(t, err)
+}
+
+func TestVaultProvider(t *testing.T) {
+	ctx := context.Background()
+	var reads, renewals atomic.Int32
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"permission denied"}})
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/flow-control":
+			reads.Add(1)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{
+					"data":     map[string]interface{}{"API_TOKEN": "kv-token"},
+					"metadata": map[string]interface{}{"version": 3},
+				},
+			})
+		case "/v1/database/creds/app":
+			// Every read creates new credentials with their own lease
+			n := reads.Add(1)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"lease_id":       fmt.Sprintf("database/creds/app/%d", n),
+				"lease_duration": 3600,
+				"renewable":      true,
+				"data":           map[string]interface{}{"username": fmt.Sprintf("v-app-%d", n), "password": fmt.Sprintf("dyn-pass-%d", n)},
+			})
+		case "/v1/sys/leases/renew":
+			renewals.Add(1)
+			var body map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"lease_id":       body["lease_id"],
+				"lease_duration": 7200,
+				"renewable":      true,
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{}})
+		}
+	}))
+	defer vault.Close()
+
+	p, err := secrets.NewVaultProvider(secrets.VaultConfig{
+		Address: vault.URL,
+		Token:   "root",
+		Path:    "secret/data/flow-control",
+		Paths:   map[string]string{"DB_USER": "database/creds/app#username", "DB_PASSWORD": "database/creds/app#password"},
+	})
+	require.NoError(t, err)
+
+	// KV version 2 secrets are read from the default path
+	secret, err := p.Get(ctx, "API_TOKEN")
+	require.NoError(t, err)
+	require.Equal(t, "kv-token", secret.Value)
+	_, err = p.Get(ctx, "OTHER")
+	require.ErrorIs(t, err, secrets.ErrNotFound)
+
+	// Dynamic secrets carry their lease, and the keys of a path are read
+	// from the same lease
+	reads.Store(0)
+	secret, err = p.Get(ctx, "DB_PASSWORD")
+	require.NoError(t, err)
+	require.Equal(t, secrets.Secret{Value: "dyn-pass-1", LeaseID: "database/creds/app/1", LeaseDuration: 3600, Renewable: true}, secret)
+	user, err := p.Get(ctx, "DB_USER")
+	require.NoError(t, err)
+	require.Equal(t, "v-app-1", user.Value)
+	require.Equal(t, "database/creds/app/1", user.LeaseID)
+	require.Equal(t, int32(1), reads.Load())
+
+	// Vault's errors are reported
+	denied, err := secrets.NewVaultProvider(secrets.VaultConfig{Address: vault.URL, Token: "wrong", Path: "secret/data/flow-control"})
+	require.NoError(t, err)
+	_, err = denied.Get(ctx, "API_TOKEN")
+	require.ErrorContains(t, err, "permission denied")
+
+	// The cache serves secrets until they expire and renews leases, once
+	// for the secrets sharing one. The credentials still come from the
+	// lease read above.
+	cache := secrets.NewCache(p, time.Hour, logger.New())
+	reads.Store(0)
+	for i := 0; i < 3; i++ {
+		secret, err = cache.Get(ctx, "DB_PASSWORD")
+		require.NoError(t, err)
+		_, err = cache.Get(ctx, "DB_USER")
+		require.NoError(t, err)
+		_, err = cache.Get(ctx, "API_TOKEN")
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(1), reads.Load())
+	require.NoError(t, cache.Renew(ctx))
+	require.Equal(t, int32(1), renewals.Load())
+	secret, err = cache.Get(ctx, "DB_PASSWORD")
+	require.NoError(t, err)
+	require.Equal(t, 7200, secret.LeaseDuration)
+	require.Equal(t, "dyn-pass-1", secret.Value)
+	user, err = cache.Get(ctx, "DB_USER")
+	require.NoError(t, err)
+	require.Equal(t, "v-app-1", user.Value)
+	require.Equal(t, int32(1), reads.Load())
+
+	// Without a TTL, secrets without a lease are read every time
+	uncached := secrets.NewCache(p, 0, logger.New())
+	_, err = uncached.Get(ctx, "API_TOKEN")
+	require.NoError(t, err)
+	_, err = uncached.Get(ctx, "API_TOKEN")
+	require.NoError(t, err)
+	require.Equal(t, int32(3), reads.Load())
+}
@@ -0,0 +1,224 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VaultConfig configures a VaultProvider. Secrets are read from the key
+// named after them in the secret at Path, unless Paths maps their name to
+// another location as "path#key", such as "database/creds/app#password".
+// A location without a key reads the key named after the secret.
+type VaultConfig struct {
+	Address string
+	Token   string
+	Path    string
+	Paths   map[string]string
+}
+
+// VaultProvider reads secrets from HashiCorp Vault over its HTTP API. It
+// reads both versions of the KV secrets engine and dynamic secrets, whose
+// leases it renews. Dynamic secrets are read once per path while their
+// lease lasts, so that the keys of one path, such as the username and
+// password of database credentials, come from the same lease.
+type VaultProvider struct {
+	cfg    VaultConfig
+	client *http.Client
+
+	// mu guards leased and is held while reading a path, so that
+	// concurrent reads of a path share its lease
+	mu     sync.Mutex
+	leased map[string]*vaultLease
+}
+
+// vaultLease is the response of a path read with a lease
+type vaultLease struct {
+	resp    *vaultResponse
+	expires time.Time
+}
+
+// vaultResponse is the part of a Vault response that secrets use
+type vaultResponse struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Errors        []string               `json:"errors"`
+}
+
+// NewVaultProvider creates a provider reading from the Vault server at
+// cfg.Address
+func NewVaultProvider(cfg VaultConfig) (*VaultProvider, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("vault address is required")
+	}
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("vault token is required")
+	}
+	cfg.Address = strings.TrimRight(cfg.Address, "/")
+	return &VaultProvider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		leased: make(map[string]*vaultLease),
+	}, nil
+}
+
+// Get implements Provider.Get
+func (p *VaultProvider) Get(ctx context.Context, name string) (Secret, error) {
+	if err := checkName(name); err != nil {
+		return Secret{}, err
+	}
+	path, key := p.location(name)
+	if path == "" {
+		return Secret{}, fmt.Errorf("%w: no vault path for %s", ErrNotFound, name)
+	}
+
+	resp, leaseDuration, err := p.read(ctx, path)
+	if err != nil {
+		return Secret{}, err
+	}
+	data := resp.Data
+	// KV version 2 nests the secret under data, next to its metadata
+	if nested, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = nested
+	}
+	value, ok := data[key]
+	if !ok {
+		return Secret{}, fmt.Errorf("%w: no key %s in vault secret %s", ErrNotFound, key, path)
+	}
+	secret := Secret{
+		LeaseID:       resp.LeaseID,
+		LeaseDuration: leaseDuration,
+		Renewable:     resp.Renewable,
+	}
+	if s, ok := value.(string); ok {
+		secret.Value = s
+	} else {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return Secret{}, fmt.Errorf("failed to encode vault secret: %w", err)
+		}
+		secret.Value = string(encoded)
+	}
+	return secret, nil
+}
+
+// read returns the response of path and the seconds left of its lease,
+// reusing the response of a path read with a lease until it expires
+func (p *VaultProvider) read(ctx context.Context, path string) (*vaultResponse, int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if lease, ok := p.leased[path]; ok {
+		if left := int(lease.expires.Sub(now) / time.Second); left > 0 {
+			return lease.resp, left, nil
+		}
+		delete(p.leased, path)
+	}
+
+	resp, err := p.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.LeaseID != "" && resp.LeaseDuration > 0 {
+		p.leased[path] = &vaultLease{
+			resp:    resp,
+			expires: now.Add(time.Duration(resp.LeaseDuration) * time.Second),
+		}
+	}
+	return resp, resp.LeaseDuration, nil
+}
+
+// Renew implements Renewer.Renew, asking for the lease's current duration
+// again. The paths read with the lease keep it for the new duration, or
+// are read again with a new lease once its renewal fails.
+func (p *VaultProvider) Renew(ctx context.Context, secret Secret) (Secret, error) {
+	if secret.LeaseID == "" || !secret.Renewable {
+		return Secret{}, fmt.Errorf("secret has no renewable lease")
+	}
+	resp, err := p.do(ctx, http.MethodPut, "sys/leases/renew", map[string]interface{}{
+		"lease_id":  secret.LeaseID,
+		"increment": secret.LeaseDuration,
+	})
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for path, lease := range p.leased {
+		if lease.resp.LeaseID != secret.LeaseID {
+			continue
+		}
+		if err != nil {
+			delete(p.leased, path)
+			continue
+		}
+		lease.resp.LeaseDuration = resp.LeaseDuration
+		lease.resp.Renewable = resp.Renewable
+		lease.expires = time.Now().Add(time.Duration(resp.LeaseDuration) * time.Second)
+	}
+	if err != nil {
+		return Secret{}, fmt.Errorf("failed to renew lease: %w", err)
+	}
+	secret.LeaseDuration = resp.LeaseDuration
+	secret.Renewable = resp.Renewable
+	return secret, nil
+}
+
+// location returns the path and key of the secret called name
+func (p *VaultProvider) location(name string) (path, key string) {
+	path = p.cfg.Path
+	if mapped, ok := p.cfg.Paths[name]; ok {
+		path = mapped
+	}
+	path, key, found := strings.Cut(path, "#")
+	if !found || key == "" {
+		key = name
+	}
+	return strings.Trim(path, "/"), key
+}
+
+// do sends a request to the Vault API and decodes its response
+func (p *VaultProvider) do(ctx context.Context, method, path string, body interface{}) (*vaultResponse, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, fmt.Errorf("failed to encode vault request: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.cfg.Address+"/v1/"+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", p.cfg.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var decoded vaultResponse
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil && resp.StatusCode < 300 {
+		return nil, fmt.Errorf("failed to decode vault response: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%w: no vault secret %s", ErrNotFound, path)
+	case resp.StatusCode >= 300:
+		if len(decoded.Errors) > 0 {
+			return nil, fmt.Errorf("vault responded %s: %s", resp.Status, strings.Join(decoded.Errors, "; "))
+		}
+		return nil, fmt.Errorf("vault responded %s", resp.Status)
+	}
+	return &decoded, nil
+}
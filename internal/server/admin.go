@@ -0,0 +1,108 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"flow-control/internal/restart"
+	"flow-control/internal/store"
+	"flow-control/internal/types"
+)
+
+// @Summary Download a database backup
+// @Description Stream a consistent snapshot of the database taken while the server keeps running. Requires the admin token.
+// @Tags admin
+// @Produce application/octet-stream
+// @Success 200 {file} file
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 501 {string} string "Backups not supported"
+// @Router /admin/backup [get]
+func (s *Server) handleBackup(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleBackup",
+	}
+
+	backupStore, ok := s.store.(store.BackupStore)
+	if !ok {
+		http.Error(w, "Backups not supported by this store", http.StatusNotImplemented)
+		return
+	}
+
+	name := fmt.Sprintf("flows-%s.db", time.Now().UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	if err := backupStore.Backup(w); err != nil {
+		// Headers may already be sent, so the error can only be logged
+		s.requestLog(r).Error("Failed to write backup", err, fields)
+	}
+}
+
+// @Summary Restore the database from a backup
+// @Description Replace the database contents with an uploaded backup after verifying its integrity. Requires the admin token.
+// @Tags admin
+// @Accept application/octet-stream
+// @Success 204
+// @Failure 400 {string} string "Invalid backup"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 501 {string} string "Restore not supported"
+// @Router /admin/restore [post]
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleRestore",
+	}
+
+	backupStore, ok := s.store.(store.BackupStore)
+	if !ok {
+		http.Error(w, "Restore not supported by this store", http.StatusNotImplemented)
+		return
+	}
+
+	if err := backupStore.Restore(r.Body); err != nil {
+		if errors.Is(err, store.ErrInvalidBackup) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.requestLog(r).Error("Failed to restore backup", err, fields)
+		http.Error(w, "Failed to restore backup", http.StatusInternalServerError)
+		return
+	}
+
+	s.requestLog(r).Info("Restored database from uploaded backup", fields)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Restart the server
+// @Description Restart the server on its current binary, such as after an upgrade. The server stops taking requests, checkpoints its running flows and starts the binary again, which resumes them and serves the connections made in the meantime. Requires the admin token.
+// @Tags admin
+// @Success 202
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 409 {string} string "Restart in progress"
+// @Failure 501 {string} string "Restart not supported"
+// @Router /admin/restart [post]
+func (s *Server) handleRestart(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleRestart",
+	}
+
+	if s.restart == nil {
+		http.Error(w, "Restart not supported by this server", http.StatusNotImplemented)
+		return
+	}
+
+	// The restart waits for this request to finish, so it only starts here
+	if err := s.restart(); err != nil {
+		if errors.Is(err, restart.ErrInProgress) {
+			http.Error(w, "Restart already in progress", http.StatusConflict)
+			return
+		}
+		s.requestLog(r).Error("Failed to request restart", err, fields)
+		http.Error(w, "Failed to request restart", http.StatusInternalServerError)
+		return
+	}
+
+	s.requestLog(r).Info("Restart requested", fields)
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,386 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"flow-control/internal/config"
+	"flow-control/internal/logger"
+	"flow-control/internal/restart"
+	"flow-control/internal/server"
+	"flow-control/internal/store"
+	"flow-control/internal/testsupport"
+	"flow-control/internal/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminBackupRestore(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "admin.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	require.NoError(t, st.CreateFlow(&types.RuntimeFlow{ID: "admin-flow", Name: "Admin Flow", Config: "{}"}))
+
+	srv := server.New(st, log, server.WithAdminToken("s3cret"))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	send := func(method, path, token string, body io.Reader) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, body)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Backups and restores require the admin token
+	for _, token := range []string{"", "wrong"} {
+		resp := send(http.MethodGet, "/api/admin/backup", token, nil)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		resp = send(http.MethodPost, "/api/admin/restore", token, strings.NewReader("garbage"))
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	// Download a backup
+	resp := send(http.MethodGet, "/api/admin/backup", "s3cret", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/octet-stream", resp.Header.Get("Content-Type"))
+	require.Contains(t, resp.Header.Get("Content-Disposition"), "attachment")
+	backup, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	require.NoError(t, st.DeleteFlow("admin-flow"))
+
+	// Upload it again
+	resp = send(http.MethodPost, "/api/admin/restore", "s3cret", bytes.NewReader(backup))
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	_, err = st.GetFlow("admin-flow")
+	require.NoError(t, err)
+
+	// Invalid uploads are rejected
+	resp = send(http.MethodPost, "/api/admin/restore", "s3cret", strings.NewReader("garbage"))
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestAdminLogLevel(t *testing.T) {
+	// Create test dependencies
+	log := logger.New(logger.WithFile(filepath.Join(t.TempDir(), "admin.log")))
+	st, err := store.New(filepath.Join(t.TempDir(), "admin.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	srv := server.New(st, log, server.WithAdminToken("s3cret"))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	send := func(method, body, token string) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+"/api/admin/log-level", strings.NewReader(body))
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	put := func(body string) *http.Response {
+		return send(http.MethodPut, body, "s3cret")
+	}
+
+	// The log level is not readable or changeable without the admin token
+	for _, token := range []string{"", "wrong"} {
+		resp := send(http.MethodGet, "", token)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		resp = send(http.MethodPut, `{"level": "debug"}`, token)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+	require.Equal(t, types.LogLevelInfo, log.Level())
+
+	// Read the initial configuration
+	resp := send(http.MethodGet, "", "s3cret")
+	var current server.LogLevelConfig
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&current))
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, types.LogLevelInfo, current.Level)
+	require.Empty(t, current.Components)
+
+	// Change the level and add overrides
+	resp = put(`{"level": "warn", "components": {"engine": "debug"}, "sampling": {"engine": {"debug_every": 10}}}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&current))
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, server.LogLevelConfig{
+		Level:      types.LogLevelWarn,
+		Components: map[string]types.LogLevel{"engine": types.LogLevelDebug},
+		Sampling:   map[string]logger.SamplingRule{"engine": {DebugEvery: 10}},
+	}, current)
+	require.Equal(t, types.LogLevelWarn, log.Level())
+
+	// The override applies only to its component
+	log.Info("filtered", nil)
+	log.Debug("kept", types.Fields{"component": "engine"})
+	entries, err := log.ReadLogs(&logger.LogQuery{Contains: "filtered"})
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	entries, err = log.ReadLogs(&logger.LogQuery{Component: "engine"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	// Omitted fields are unchanged and empty objects clear overrides
+	resp = put(`{"components": {}}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	current = server.LogLevelConfig{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&current))
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, types.LogLevelWarn, current.Level)
+	require.Empty(t, current.Components)
+	require.Len(t, current.Sampling, 1)
+
+	// Invalid levels change nothing
+	resp = put(`{"level": "debug", "components": {"engine": "loud"}}`)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Equal(t, types.LogLevelWarn, log.Level())
+}
+
+func TestAdminDiagnostics(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "admin.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	get := func(ts *httptest.Server, path string, header http.Header) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		require.NoError(t, err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp, string(body)
+	}
+
+	// Diagnostics are unavailable without a configured token
+	open := httptest.NewServer(server.New(st, log))
+	defer open.Close()
+	resp, _ := get(open, "/api/admin/goroutines", nil)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	ts := httptest.NewServer(server.New(st, log, server.WithAdminToken("s3cret")))
+	defer ts.Close()
+
+	// Requests without the right token are rejected
+	resp, _ = get(ts, "/api/admin/goroutines", nil)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, _ = get(ts, "/api/admin/goroutines", http.Header{"Authorization": {"Bearer wrong"}})
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// The token is accepted as a bearer token or in its own header
+	bearer := http.Header{"Authorization": {"Bearer s3cret"}}
+	resp, body := get(ts, "/api/admin/goroutines", bearer)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, body, "goroutine")
+
+	resp, body = get(ts, "/api/admin/debug/vars", http.Header{"X-Admin-Token": {"s3cret"}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, body, "memstats")
+
+	resp, body = get(ts, "/api/admin/debug/pprof/", bearer)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, body, "heap")
+
+	resp, body = get(ts, "/api/admin/debug/pprof/heap?debug=1", bearer)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, body, "heap profile")
+
+	// The other admin routes share the token
+	resp, _ = get(ts, "/api/admin/log-level", bearer)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/api/admin/backup"},
+		{http.MethodPost, "/api/admin/restore"},
+		{http.MethodGet, "/api/admin/log-level"},
+		{http.MethodPut, "/api/admin/log-level"},
+		{http.MethodGet, "/api/admin/config"},
+		{http.MethodPost, "/api/admin/restart"},
+		{http.MethodGet, "/api/admin/logs/stream"},
+		{http.MethodGet, "/api/admin/debug/pprof/heap"},
+	} {
+		for srv, status := range map[*httptest.Server]int{ts: http.StatusUnauthorized, open: http.StatusForbidden} {
+			req, err := http.NewRequest(route.method, srv.URL+route.path, nil)
+			require.NoError(t, err)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+			require.Equal(t, status, resp.StatusCode, "%s %s", route.method, route.path)
+		}
+	}
+}
+
+func TestAdminConfig(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "admin.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	env := map[string]string{"ADMIN_TOKEN": "s3cret", "SERVER_PORT": "9090"}
+	cfg, err := config.Load("", log, config.FromEnv(func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}))
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(server.New(st, log,
+		server.WithAdminToken(cfg.Server.AdminToken),
+		server.WithConfig(func() *config.Config { return cfg }),
+	))
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/admin/config", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// The configuration shows where each value came from, without secrets
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotContains(t, string(body), "s3cret")
+
+	var dump server.ConfigDump
+	require.NoError(t, json.Unmarshal(body, &dump))
+	require.Equal(t, 9090, dump.Config.Server.Port)
+	require.Equal(t, "REDACTED", dump.Config.Server.AdminToken)
+	sources := map[string]string{}
+	for _, setting := range dump.Settings {
+		sources[setting.Field] = setting.Source
+	}
+	require.Equal(t, "environment variable SERVER_PORT", sources["server.port"])
+	require.Equal(t, config.SourceDefault, sources["server.host"])
+
+	// Without a configuration the endpoint is unavailable
+	unconfigured := httptest.NewServer(server.New(st, log, server.WithAdminToken("s3cret")))
+	defer unconfigured.Close()
+	req, err = http.NewRequest(http.MethodGet, unconfigured.URL+"/api/admin/config", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+}
+
+func TestAdminLogStream(t *testing.T) {
+	h := testsupport.New(t, testsupport.WithAdminToken("admin-secret"))
+	ctx := context.Background()
+
+	// The stream requires the admin token and a known level
+	_, err := testsupport.NewClient(h.URL).Send(ctx, http.MethodGet, "/api/admin/logs/stream", nil)
+	require.NoError(t, err)
+	resp, err := h.Client.Send(ctx, http.MethodGet, "/api/admin/logs/stream?level=loud", nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	unauthorized, err := testsupport.NewClient(h.URL).Send(ctx, http.MethodGet, "/api/admin/logs/stream", nil)
+	require.NoError(t, err)
+	require.NoError(t, unauthorized.Body.Close())
+	require.Equal(t, http.StatusUnauthorized, unauthorized.StatusCode)
+
+	// Follow the warnings of one component
+	resp, err = h.Client.Send(ctx, http.MethodGet, "/api/admin/logs/stream?level=warn&component=engine", nil)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	h.Log.Info("too quiet", types.Fields{"component": "engine"})
+	h.Log.Warn("other component", types.Fields{"component": "store"})
+	h.Log.Warn("node slow", types.Fields{"component": "engine", "node": "enrich"})
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "event: log\n", line)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	data, ok := strings.CutPrefix(line, "data: ")
+	require.True(t, ok)
+	var entry logger.LogEntry
+	require.NoError(t, json.Unmarshal([]byte(data), &entry))
+	require.Equal(t, "WARN", entry.Level)
+	require.Equal(t, "node slow", entry.Message)
+	require.Equal(t, "enrich", entry.Fields["node"])
+}
+
+func TestAdminRestart(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "admin.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	restartServer := func(ts *httptest.Server, header http.Header) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/admin/restart", nil)
+		require.NoError(t, err)
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+	bearer := http.Header{"Authorization": {"Bearer s3cret"}}
+
+	// Servers without a restart function cannot restart
+	unsupported := httptest.NewServer(server.New(st, log, server.WithAdminToken("s3cret")))
+	defer unsupported.Close()
+	require.Equal(t, http.StatusNotImplemented, restartServer(unsupported, bearer))
+
+	trigger := restart.NewTrigger()
+	ts := httptest.NewServer(server.New(st, log,
+		server.WithAdminToken("s3cret"),
+		server.WithRestart(trigger.Request),
+	))
+	defer ts.Close()
+
+	// Restarts require the admin token
+	require.Equal(t, http.StatusUnauthorized, restartServer(ts, http.Header{}))
+
+	// Only the first of several requests restarts the server
+	require.Equal(t, http.StatusAccepted, restartServer(ts, bearer))
+	require.Equal(t, http.StatusConflict, restartServer(ts, bearer))
+	<-trigger.C()
+}
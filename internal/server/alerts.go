@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"flow-control/internal/alerting"
+	"flow-control/internal/types"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// requireAlerting rejects alerting requests when no evaluator is configured
+func (s *Server) requireAlerting(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.alerts == nil {
+			http.Error(w, "Alerting not configured", http.StatusNotImplemented)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// @Summary List firing alerts
+// @Description List the alerts that are currently firing, oldest first
+// @Tags alerts
+// @Produce json
+// @Success 200 {array} alerting.Alert
+// @Failure 501 {string} string "Alerting not configured"
+// @Router /alerts [get]
+func (s *Server) handleListAlerts(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleListAlerts",
+	}
+
+	s.writeJSON(w, r, http.StatusOK, s.alerts.Active(), fields)
+}
+
+// @Summary List alert rules
+// @Description List every alert rule, optionally only those of one flow
+// @Tags alerts
+// @Produce json
+// @Param flow_id query string false "Only list rules of this flow"
+// @Success 200 {array} types.AlertRule
+// @Failure 501 {string} string "Alerting not configured"
+// @Router /alerts/rules [get]
+func (s *Server) handleListAlertRules(w http.ResponseWriter, r *http.Request) {
+	flowID := r.URL.Query().Get("flow_id")
+	fields := types.Fields{
+		"function": "handleListAlertRules",
+		"flow_id":  flowID,
+	}
+
+	rules, err := s.alerts.Rules()
+	if err != nil {
+		s.handleStoreError(w, r, err, "Failed to list alert rules", fields)
+		return
+	}
+	if flowID != "" {
+		filtered := []*types.AlertRule{}
+		for _, rule := range rules {
+			if rule.FlowID == flowID {
+				filtered = append(filtered, rule)
+			}
+		}
+		rules = filtered
+	}
+
+	s.writeJSON(w, r, http.StatusOK, rules, fields)
+}
+
+// @Summary Create an alert rule
+// @Description Create a rule raising an alert when a flow's error rate, silence or p99 latency crosses a threshold
+// @Tags alerts
+// @Accept json
+// @Produce json
+// @Param rule body types.AlertRule true "Alert rule"
+// @Success 201 {object} types.AlertRule
+// @Failure 400 {string} string "Invalid alert rule"
+// @Failure 404 {string} string "Flow not found"
+// @Router /alerts/rules [post]
+func (s *Server) handleCreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleCreateAlertRule",
+	}
+
+	var rule types.AlertRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid alert rule data", http.StatusBadRequest)
+		return
+	}
+
+	// IDs are assigned by the store, so creating never replaces a rule
+	rule.ID = ""
+	if err := s.alerts.SaveRule(&rule); err != nil {
+		s.handleAlertError(w, r, err, "Failed to create alert rule", fields)
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusCreated, rule, fields)
+}
+
+// @Summary Update an alert rule
+// @Description Replace an alert rule
+// @Tags alerts
+// @Accept json
+// @Produce json
+// @Param id path string true "Rule ID"
+// @Param rule body types.AlertRule true "Alert rule"
+// @Success 200 {object} types.AlertRule
+// @Failure 400 {string} string "Invalid alert rule"
+// @Failure 404 {string} string "Alert rule not found"
+// @Router /alerts/rules/{id} [put]
+func (s *Server) handleUpdateAlertRule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function": "handleUpdateAlertRule",
+		"rule_id":  id,
+	}
+
+	var rule types.AlertRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid alert rule data", http.StatusBadRequest)
+		return
+	}
+
+	rules, err := s.alerts.Rules()
+	if err != nil {
+		s.handleStoreError(w, r, err, "Failed to update alert rule", fields)
+		return
+	}
+	exists := slices.ContainsFunc(rules, func(existing *types.AlertRule) bool {
+		return existing.ID == id
+	})
+	if !exists {
+		http.Error(w, "Alert rule not found", http.StatusNotFound)
+		return
+	}
+
+	rule.ID = id
+	if err := s.alerts.SaveRule(&rule); err != nil {
+		s.handleAlertError(w, r, err, "Failed to update alert rule", fields)
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusOK, rule, fields)
+}
+
+// @Summary Delete an alert rule
+// @Description Delete an alert rule. An alert it raised resolves at the next evaluation.
+// @Tags alerts
+// @Param id path string true "Rule ID"
+// @Success 204 "No Content"
+// @Failure 404 {string} string "Alert rule not found"
+// @Router /alerts/rules/{id} [delete]
+func (s *Server) handleDeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function": "handleDeleteAlertRule",
+		"rule_id":  id,
+	}
+
+	if err := s.alerts.DeleteRule(id); err != nil {
+		s.handleStoreError(w, r, err, "Failed to delete alert rule", fields)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAlertError maps rule validation and store errors to HTTP responses
+func (s *Server) handleAlertError(w http.ResponseWriter, r *http.Request, err error, msg string, fields types.Fields) {
+	if errors.Is(err, alerting.ErrInvalidRule) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.handleStoreError(w, r, err, msg, fields)
+}
@@ -0,0 +1,104 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"flow-control/internal/alerting"
+	"flow-control/internal/logger"
+	"flow-control/internal/metrics"
+	"flow-control/internal/server"
+	"flow-control/internal/store"
+	"flow-control/internal/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlertsAPI(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "alerts.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+	require.NoError(t, st.CreateFlow(&types.RuntimeFlow{ID: "orders", Name: "Orders", Config: "{}"}))
+
+	registry := metrics.NewRegistry()
+	evaluator := alerting.New(st, registry, log, alerting.Options{})
+	ts := httptest.NewServer(server.New(st, log, server.WithAlerting(evaluator)))
+	defer ts.Close()
+
+	send := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Rules are validated and must name an existing flow
+	resp := send(http.MethodPost, "/api/alerts/rules", `{"flow_id":"orders","condition":"cpu","window_seconds":60}`)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = send(http.MethodPost, "/api/alerts/rules", `{"flow_id":"missing","condition":"no_messages","window_seconds":60}`)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = send(http.MethodPost, "/api/alerts/rules",
+		`{"flow_id":"orders","condition":"error_rate","threshold":0.1,"window_seconds":60,"enabled":true}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var rule types.AlertRule
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&rule))
+	require.NoError(t, resp.Body.Close())
+	require.NotEmpty(t, rule.ID)
+
+	// Rules can be listed per flow and replaced
+	resp = send(http.MethodGet, "/api/alerts/rules?flow_id=orders", "")
+	var rules []types.AlertRule
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&rules))
+	require.NoError(t, resp.Body.Close())
+	require.Len(t, rules, 1)
+
+	resp = send(http.MethodPut, "/api/alerts/rules/"+rule.ID,
+		`{"flow_id":"orders","condition":"error_rate","threshold":0.2,"window_seconds":60,"enabled":true}`)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = send(http.MethodPut, "/api/alerts/rules/unknown", `{"flow_id":"orders","condition":"no_messages","window_seconds":60}`)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Firing alerts are listed
+	start := time.Now()
+	require.NoError(t, evaluator.Evaluate(context.Background(), start))
+	for i := 0; i < 4; i++ {
+		metrics.RecordFlow(registry, types.FlowMetrics{FlowID: "orders", Status: "error"})
+	}
+	require.NoError(t, evaluator.Evaluate(context.Background(), start.Add(time.Minute)))
+	resp = send(http.MethodGet, "/api/alerts", "")
+	var alerts []alerting.Alert
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&alerts))
+	require.NoError(t, resp.Body.Close())
+	require.Len(t, alerts, 1)
+	require.Equal(t, rule.ID, alerts[0].Rule.ID)
+
+	resp = send(http.MethodDelete, "/api/alerts/rules/"+rule.ID, "")
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = send(http.MethodDelete, "/api/alerts/rules/"+rule.ID, "")
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Without an evaluator the endpoints are unavailable
+	bare := httptest.NewServer(server.New(st, log))
+	defer bare.Close()
+	resp, err = http.Get(bare.URL + "/api/alerts")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/store"
+	"flow-control/internal/types"
+)
+
+// approvalActions maps the approval event types to the actions recorded in
+// the audit trail of approvals
+var approvalActions = map[string]string{
+	engine.TypeApprovalRequested: store.AuditActionRequest,
+	engine.TypeApprovalEscalated: store.AuditActionEscalate,
+	engine.TypeApprovalApproved:  store.AuditActionApprove,
+	engine.TypeApprovalRejected:  store.AuditActionReject,
+	engine.TypeApprovalCancelled: store.AuditActionCancel,
+}
+
+// DecisionRequest approves or rejects an approval
+type DecisionRequest struct {
+	// By names who decides, recorded in the audit trail
+	By      string `json:"by"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// recordApproval records an approval event of the run with runID in the
+// audit trail of the approval and publishes it. Other events are ignored.
+func (s *Server) recordApproval(runID string, event types.FlowEvent) {
+	action, ok := approvalActions[event.Type]
+	if !ok {
+		return
+	}
+	id, _ := event.Data["approval_id"].(string)
+	fields := types.Fields{
+		"function":    "recordApproval",
+		"flow_id":     event.FlowID,
+		"run_id":      runID,
+		"approval_id": id,
+	}
+
+	details := map[string]interface{}{
+		"flow_id": event.FlowID,
+		"node_id": event.NodeID,
+		"run_id":  runID,
+	}
+	for name, value := range event.Data {
+		if name != "approval_id" {
+			details[name] = value
+		}
+	}
+	if err := s.store.RecordAudit(store.AuditEntityApproval, id, action, details); err != nil {
+		s.log.Error("Failed to record approval", err, fields)
+	}
+	s.publishEvent(context.Background(), s.log, event)
+}
+
+// approvalRun returns the running backfill holding the approval with id
+func (s *Server) approvalRun(id string) *backfillRun {
+	s.backfillMu.Lock()
+	defer s.backfillMu.Unlock()
+	for _, run := range s.backfills {
+		if run.Status != types.FlowStatusRunning {
+			continue
+		}
+		for _, approval := range run.engine.Approvals() {
+			if approval.ID == id {
+				return run
+			}
+		}
+	}
+	return nil
+}
+
+// @Summary List pending approvals
+// @Description List the messages the Approval nodes of the flows running on this server hold until they are approved or rejected, the oldest first
+// @Tags approvals
+// @Produce json
+// @Param flow query string false "Only list the approvals of this flow"
+// @Success 200 {array} engine.Approval
+// @Router /approvals [get]
+func (s *Server) handleListApprovals(w http.ResponseWriter, r *http.Request) {
+	flowID := r.URL.Query().Get("flow")
+	fields := types.Fields{
+		"function": "handleListApprovals",
+		"flow_id":  flowID,
+	}
+
+	s.backfillMu.Lock()
+	approvals := []engine.Approval{}
+	for _, run := range s.backfills {
+		if run.Status == types.FlowStatusRunning && (flowID == "" || run.FlowID == flowID) {
+			approvals = append(approvals, run.engine.Approvals()...)
+		}
+	}
+	s.backfillMu.Unlock()
+	sort.Slice(approvals, func(i, j int) bool {
+		return approvals[i].RequestedAt.Before(approvals[j].RequestedAt)
+	})
+
+	s.writeJSON(w, r, http.StatusOK, approvals, fields)
+}
+
+// @Summary Approve a message
+// @Description Approve a pending approval, passing its message on through the nodes after the Approval node before the response is sent. The decision is recorded in the audit trail of the approval.
+// @Tags approvals
+// @Accept json
+// @Produce json
+// @Param approval path string true "Approval ID"
+// @Param request body DecisionRequest true "Who approves, and why"
+// @Success 200 {object} engine.Approval
+// @Failure 400 {string} string "Invalid decision"
+// @Failure 404 {string} string "Approval not found or no longer pending"
+// @Router /approvals/{approval}/approve [post]
+func (s *Server) handleApprove(w http.ResponseWriter, r *http.Request) {
+	s.decide(w, r, true, types.Fields{
+		"function":    "handleApprove",
+		"approval_id": chi.URLParam(r, "approval"),
+	})
+}
+
+// @Summary Reject a message
+// @Description Reject a pending approval, sending its message on the rejected port of the Approval node before the response is sent. The decision is recorded in the audit trail of the approval.
+// @Tags approvals
+// @Accept json
+// @Produce json
+// @Param approval path string true "Approval ID"
+// @Param request body DecisionRequest true "Who rejects, and why"
+// @Success 200 {object} engine.Approval
+// @Failure 400 {string} string "Invalid decision"
+// @Failure 404 {string} string "Approval not found or no longer pending"
+// @Router /approvals/{approval}/reject [post]
+func (s *Server) handleReject(w http.ResponseWriter, r *http.Request) {
+	s.decide(w, r, false, types.Fields{
+		"function":    "handleReject",
+		"approval_id": chi.URLParam(r, "approval"),
+	})
+}
+
+// decide approves or rejects the approval in the URL
+func (s *Server) decide(w http.ResponseWriter, r *http.Request, approve bool, fields types.Fields) {
+	id := chi.URLParam(r, "approval")
+	var req DecisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid decision", http.StatusBadRequest)
+		return
+	}
+	if req.By == "" {
+		http.Error(w, "by is required", http.StatusBadRequest)
+		return
+	}
+
+	run := s.approvalRun(id)
+	if run == nil {
+		http.Error(w, "Approval not found", http.StatusNotFound)
+		return
+	}
+	// Failures of the nodes after the Approval node are reported as node
+	// events, like those of any other message
+	approval, err := run.engine.Decide(run.ctx, id, engine.Decision{Approve: approve, By: req.By, Comment: req.Comment})
+	if errors.Is(err, engine.ErrApprovalNotFound) {
+		// Decided by someone else, or its timeout, in the meantime
+		http.Error(w, "Approval not found", http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, approval, fields)
+}
+
+// @Summary Get the audit trail of an approval
+// @Description List what happened to an approval, oldest first: when it was requested, escalated, and approved, rejected or cancelled, and by whom
+// @Tags approvals
+// @Produce json
+// @Param approval path string true "Approval ID"
+// @Success 200 {array} types.AuditRecord
+// @Failure 404 {string} string "Approval not found"
+// @Router /approvals/{approval}/audit [get]
+func (s *Server) handleApprovalAudit(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "approval")
+	fields := types.Fields{
+		"function":    "handleApprovalAudit",
+		"approval_id": id,
+	}
+
+	records, err := s.store.ListAuditRecords(store.AuditEntityApproval, id)
+	if err != nil {
+		s.handleStoreError(w, r, err, "Failed to list audit records", fields)
+		return
+	}
+	if len(records) == 0 {
+		http.Error(w, "Approval not found", http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, records, fields)
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"flow-control/internal/types"
+)
+
+// apiKeyHeader is an alternative to a bearer Authorization header
+const apiKeyHeader = "X-API-Key"
+
+// requireAPIKey only lets requests carrying one of the configured API keys
+// through. Without configured keys every request is let through.
+func (s *Server) requireAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(s.apiKeys) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := r.Header.Get(apiKeyHeader)
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			key = bearer
+		}
+		for _, valid := range s.apiKeys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(valid)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		s.requestLog(r).Warn("Rejected API request", types.Fields{
+			"function": "requireAPIKey",
+			"path":     r.URL.Path,
+		})
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
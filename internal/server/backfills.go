@@ -0,0 +1,581 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"flow-control/internal/events"
+	"flow-control/internal/runtime/backfill"
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/types"
+	"flow-control/pkg/api"
+)
+
+// backfillLogBatch is the number of node log entries, or message hops, of
+// a backfill saved at once
+const backfillLogBatch = 100
+
+// drainInterval is how often Drain checks whether the backfills ended
+const drainInterval = 50 * time.Millisecond
+
+// backfillRun is a backfill the server ran since it started
+type backfillRun struct {
+	// Backfill is guarded by Server.backfillMu
+	api.Backfill
+	engine *engine.Engine
+	ctx    context.Context
+	cancel context.CancelFunc
+	// logs are the node log entries of the run not saved yet. The engine
+	// never logs concurrently, so they need no lock.
+	logs []types.NodeLog
+	// hops are the message hops of the run not saved yet, when it records
+	// its timeline
+	hops []types.MessageHop
+}
+
+// @Summary Start a backfill
+// @Description Run a stored flow over bounded input, the lines of files in the data directory, the rows of a SQL query on a configured database or the windows of a time range, in the background. The flow is running until all of the input went through, and then completed. The run is returned right away; poll it for its progress. The concurrency setting of the flow limits its backfills at once, one by default; backfills started beyond it are refused, queued, or coalesced into the queued backfill, which is returned instead. Backfills are refused with 429 while the project of the flow, named by its project:NAME tag, has as many runs as its quota allows or its run data is at its storage quota, and their input is paced at the project's message rate quota.
+// @Tags flows
+// @Accept json
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Param request body api.BackfillRequest true "Input and parameter values"
+// @Success 202 {object} api.Backfill
+// @Failure 400 {string} string "Invalid input, parameters or breakpoints"
+// @Failure 404 {string} string "Flow not found"
+// @Failure 409 {string} string "Backfills of the flow at their limit"
+// @Failure 422 {string} string "Flow config is not Flow source"
+// @Failure 429 {string} string "Project quota exceeded"
+// @Failure 503 {string} string "Server is restarting"
+// @Router /flows/{id}/backfills [post]
+func (s *Server) handleStartBackfill(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function": "handleStartBackfill",
+		"flow_id":  id,
+	}
+
+	var req api.BackfillRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid backfill request", http.StatusBadRequest)
+		return
+	}
+	if err := req.Input.Validate(); err != nil {
+		http.Error(w, "Invalid input: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.startBackfill(w, r, id, req, false, fields)
+}
+
+// startBackfill starts a backfill of the flow with id, or a simulation
+// running its sources in place of req.Input, and writes the run
+func (s *Server) startBackfill(w http.ResponseWriter, r *http.Request, id string, req api.BackfillRequest, simulation bool, fields types.Fields) {
+	flow, err := s.store.GetFlow(id)
+	if err != nil {
+		s.handleStoreError(w, r, err, "Failed to get flow", fields)
+		return
+	}
+	graph, err := engine.LoadSource(flow.Config, "", s.requestLog(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if _, err := engine.ResolveParams(graph.Params, req.Params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	runID, err := newRunID()
+	if err != nil {
+		s.requestLog(r).Error("Failed to start backfill", err, fields)
+		http.Error(w, "Failed to start backfill", http.StatusInternalServerError)
+		return
+	}
+	fields["run_id"] = runID
+
+	project := s.flowProject(flow.Tags)
+	var exceeded *quotaError
+	if err := s.checkStorage(project); err != nil {
+		if errors.As(err, &exceeded) {
+			s.quotaExceeded(r.Context(), s.requestLog(r), id, exceeded)
+			http.Error(w, "Quota exceeded: "+err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		s.handleStoreError(w, r, err, "Failed to check project quota", fields)
+		return
+	}
+
+	run := &backfillRun{Backfill: api.Backfill{
+		ID:         runID,
+		FlowID:     id,
+		Project:    project,
+		Simulation: simulation,
+		Status:     types.FlowStatusRunning,
+		Input:      req.Input,
+		Params:     req.Params,
+		StartedAt:  time.Now(),
+	}}
+	var schemas engine.SchemaSource
+	if s.schemas != nil {
+		schemas = s.schemas
+	}
+	var onHop func(types.MessageHop)
+	if req.Timeline {
+		onHop = func(hop types.MessageHop) {
+			run.hops = append(run.hops, hop)
+			if len(run.hops) >= backfillLogBatch {
+				s.saveBackfillHops(run, run.hops)
+				run.hops = run.hops[:0]
+			}
+		}
+	}
+	e, err := engine.New(graph, s.nodes, s.log, engine.Options{
+		Schemas:      schemas,
+		Params:       req.Params,
+		Flows:        s.storedFlows(s.log),
+		MaxCallDepth: s.limits.MaxCallDepth,
+		DataDir:      s.dataDir,
+		Lookups:      s.lookups,
+		Secrets:      s.secrets,
+		Spill:        s.spill(id),
+		OnEvent: func(event types.FlowEvent) {
+			if event.Type == engine.TypeNodeFailed {
+				s.backfillMu.Lock()
+				run.NodeFailures++
+				s.backfillMu.Unlock()
+			}
+			s.recordApproval(runID, event)
+			if debugEvents[event.Type] {
+				s.publishEvent(context.Background(), s.log, event)
+			}
+		},
+		RunID: runID,
+		OnLog: func(entry types.NodeLog) {
+			run.logs = append(run.logs, entry)
+			if len(run.logs) >= backfillLogBatch {
+				s.saveBackfillLogs(run, run.logs)
+				run.logs = run.logs[:0]
+			}
+		},
+		OnHop:       onHop,
+		Breakpoints: req.Breakpoints,
+	})
+	if errors.Is(err, engine.ErrUnknownNode) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if simulation && len(e.Sources()) == 0 {
+		http.Error(w, "Flow has no Generate or Replay nodes to simulate", http.StatusUnprocessableEntity)
+		return
+	}
+
+	run.engine = e
+	run.ctx, run.cancel = context.WithCancel(context.Background())
+
+	s.backfillMu.Lock()
+	if s.draining {
+		s.backfillMu.Unlock()
+		run.cancel()
+		http.Error(w, "Server is restarting", http.StatusServiceUnavailable)
+		return
+	}
+	if err := s.checkRuns(project, id); err != nil {
+		s.backfillMu.Unlock()
+		run.cancel()
+		if errors.As(err, &exceeded) {
+			s.quotaExceeded(r.Context(), s.requestLog(r), id, exceeded)
+			http.Error(w, "Quota exceeded: "+err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		s.handleStoreError(w, r, err, "Failed to check project quota", fields)
+		return
+	}
+	if simulation {
+		// Simulations leave the flow and its production runs alone
+		s.backfills[runID] = run
+		s.backfillMu.Unlock()
+		s.requestLog(r).Info("Simulation started", fields)
+		s.launchBackfill(run)
+		s.writeJSON(w, r, http.StatusAccepted, s.backfill(run), fields)
+		return
+	}
+	admitted, err := s.admitBackfill(run, graph.Concurrency)
+	if err != nil {
+		s.backfillMu.Unlock()
+		run.cancel()
+		http.Error(w, "Backfill refused: "+err.Error(), http.StatusConflict)
+		return
+	}
+	status := admitted.Status
+	s.backfillMu.Unlock()
+	if status == api.BackfillQueued {
+		s.requestLog(r).Info("Backfill queued", fields)
+		s.writeJSON(w, r, http.StatusAccepted, s.backfill(admitted), fields)
+		return
+	}
+
+	if err := s.store.StartFlow(id, req.Params); err != nil {
+		run.cancel()
+		s.backfillMu.Lock()
+		delete(s.backfills, runID)
+		s.backfillMu.Unlock()
+		s.handleStoreError(w, r, err, "Failed to update flow status", fields)
+		return
+	}
+	s.publish(r, types.FlowEvent{
+		FlowID:  id,
+		Type:    events.TypeFlowStarted,
+		Message: "Backfill started",
+	})
+
+	s.launchBackfill(run)
+	s.writeJSON(w, r, http.StatusAccepted, s.backfill(run), fields)
+}
+
+// admitBackfill adds run to the backfills of its flow, as running when the
+// flow runs fewer than its concurrency allows and as queued otherwise. When
+// the coalesce overflow policy merges run into the queued backfill, that
+// backfill is returned instead. Runs beyond the queue are refused with an
+// error. The caller holds backfillMu.
+func (s *Server) admitBackfill(run *backfillRun, concurrency engine.Concurrency) (*backfillRun, error) {
+	running := 0
+	for _, other := range s.backfills {
+		if other.FlowID == run.FlowID && other.Status == types.FlowStatusRunning && !other.Simulation {
+			running++
+		}
+	}
+	if running < concurrency.Limit() {
+		s.backfills[run.ID] = run
+		return run, nil
+	}
+
+	queue := s.backfillQueue[run.FlowID]
+	switch {
+	case concurrency.Waiting() == 0:
+		return nil, fmt.Errorf("flow already runs %d backfills, its limit", running)
+	case concurrency.Overflow == engine.OverflowCoalesce && len(queue) > 0:
+		queue[0].Coalesced++
+		return queue[0], nil
+	case len(queue) >= concurrency.Waiting():
+		return nil, fmt.Errorf("flow already has %d backfills queued, its limit", len(queue))
+	}
+	run.Status = api.BackfillQueued
+	s.backfills[run.ID] = run
+	s.backfillQueue[run.FlowID] = append(queue, run)
+	return run, nil
+}
+
+// launchBackfill runs run in the background, as backfills outlive the
+// request that started them
+func (s *Server) launchBackfill(run *backfillRun) {
+	s.backfillMu.Lock()
+	s.launched++
+	s.backfillMu.Unlock()
+	go func() {
+		err := s.runBackfill(run)
+		s.saveBackfillLogs(run, run.logs)
+		s.saveBackfillHops(run, run.hops)
+		s.finishBackfill(run, err)
+		s.backfillMu.Lock()
+		s.launched--
+		s.backfillMu.Unlock()
+	}()
+}
+
+// runBackfill sends the input of run through its flow, or runs its sources
+// when it is a simulation, waiting for the nodes paused on the way to
+// resume and the messages held for approval to be decided
+func (s *Server) runBackfill(run *backfillRun) error {
+	e := run.engine
+	if err := e.Start(run.ctx); err != nil {
+		return err
+	}
+	var err error
+	if run.Simulation {
+		pace := s.pace(run)
+		err = e.Simulate(run.ctx, func(ctx context.Context) error {
+			if pace != nil {
+				if err := pace(ctx); err != nil {
+					return err
+				}
+			}
+			s.backfillMu.Lock()
+			run.Progress.Messages++
+			s.backfillMu.Unlock()
+			return nil
+		})
+	} else {
+		_, err = backfill.Run(run.ctx, run.Input, s.backfillSources(), s.throttle(run, e), func(progress backfill.Progress) {
+			s.backfillMu.Lock()
+			run.Progress = progress
+			s.backfillMu.Unlock()
+		})
+	}
+	if err == nil {
+		err = e.WaitIdle(run.ctx)
+	}
+	if stopErr := e.Stop(context.Background()); stopErr != nil && err == nil {
+		err = stopErr
+	}
+	return err
+}
+
+// backfillSources returns the files and databases backfills may read: the
+// files of the data directory and the lookup databases
+func (s *Server) backfillSources() backfill.Sources {
+	sources := backfill.Sources{DataDir: s.dataDir, Databases: make(map[string]backfill.Database, len(s.lookups.Databases))}
+	for name, db := range s.lookups.Databases {
+		sources.Databases[name] = backfill.Database{Driver: db.Driver, DSN: db.DSN}
+	}
+	return sources
+}
+
+// finishBackfill records how run ended, err being the error it ended with,
+// and starts the next queued backfill of its flow. Once no backfill of the
+// flow is left running, it moves the flow to the status matching run.
+// Flows stopped or deleted during the run keep the status they were given,
+// and simulations leave it alone.
+func (s *Server) finishBackfill(run *backfillRun, err error) {
+	fields := types.Fields{
+		"function": "finishBackfill",
+		"flow_id":  run.FlowID,
+		"run_id":   run.ID,
+	}
+
+	now := time.Now()
+	status, eventType, message := types.FlowStatusCompleted, events.TypeFlowCompleted, "Backfill completed"
+	switch {
+	case run.ctx.Err() != nil:
+		status = types.FlowStatusStopped
+	case err != nil:
+		status, eventType, message = types.FlowStatusFailed, events.TypeFlowFailed, "Backfill failed: "+err.Error()
+	}
+	s.backfillMu.Lock()
+	run.Status = status
+	run.FinishedAt = &now
+	if err != nil && status == types.FlowStatusFailed {
+		run.Error = err.Error()
+	}
+	var next *backfillRun
+	if queue := s.backfillQueue[run.FlowID]; len(queue) > 0 && status != types.FlowStatusStopped && !run.Simulation {
+		next = queue[0]
+		s.backfillQueue[run.FlowID] = queue[1:]
+		next.Status = types.FlowStatusRunning
+		next.StartedAt = now
+	}
+	last := next == nil
+	for _, other := range s.backfills {
+		if other.FlowID == run.FlowID && other.Status == types.FlowStatusRunning && !other.Simulation {
+			last = false
+		}
+	}
+	s.backfillMu.Unlock()
+	run.cancel()
+
+	if next != nil {
+		s.log.Info("Starting queued backfill", types.Fields{
+			"function": "finishBackfill",
+			"flow_id":  next.FlowID,
+			"run_id":   next.ID,
+		})
+		s.launchBackfill(next)
+	}
+	if status == types.FlowStatusStopped {
+		s.log.Info("Backfill stopped", fields)
+		return
+	}
+	if !last || run.Simulation {
+		s.log.Info(message, fields)
+		return
+	}
+	if err := s.store.UpdateFlowStatus(run.FlowID, status); err != nil {
+		s.log.Error("Failed to update flow status", err, fields)
+		return
+	}
+	s.log.Info(message, fields)
+	s.publishEvent(context.Background(), s.log, types.FlowEvent{
+		FlowID:  run.FlowID,
+		Type:    eventType,
+		Message: message,
+	})
+}
+
+// saveBackfillLogs saves node log entries of run
+func (s *Server) saveBackfillLogs(run *backfillRun, entries []types.NodeLog) {
+	if len(entries) == 0 {
+		return
+	}
+	if err := s.store.SaveNodeLogs(entries); err != nil {
+		s.log.Error("Failed to save node logs", err, types.Fields{
+			"function": "saveBackfillLogs",
+			"flow_id":  run.FlowID,
+			"run_id":   run.ID,
+		})
+	}
+}
+
+// saveBackfillHops saves message hops of run
+func (s *Server) saveBackfillHops(run *backfillRun, hops []types.MessageHop) {
+	if len(hops) == 0 {
+		return
+	}
+	if err := s.store.SaveMessageHops(hops); err != nil {
+		s.log.Error("Failed to save message hops", err, types.Fields{
+			"function": "saveBackfillHops",
+			"flow_id":  run.FlowID,
+			"run_id":   run.ID,
+		})
+	}
+}
+
+// stopBackfills stops the running backfills of the flow with id and drops
+// its queued ones
+func (s *Server) stopBackfills(id string) {
+	s.backfillMu.Lock()
+	defer s.backfillMu.Unlock()
+	now := time.Now()
+	for _, run := range s.backfillQueue[id] {
+		run.Status = types.FlowStatusStopped
+		run.FinishedAt = &now
+		run.cancel()
+	}
+	delete(s.backfillQueue, id)
+	for _, run := range s.backfills {
+		if run.FlowID == id && run.Status == types.FlowStatusRunning {
+			run.cancel()
+		}
+	}
+}
+
+// Drain waits for the backfills and simulations the server runs or queued
+// to end, refusing new ones meanwhile, and then for the engines of the flow
+// runs RunFlow runs to hold no messages, so that a restart loses none of
+// their progress, held approvals, paused nodes or stopped messages. The
+// server keeps refusing new runs once drained, as it is about to restart.
+// When ctx is done first, it takes new runs again and the error of ctx is
+// returned.
+func (s *Server) Drain(ctx context.Context) error {
+	s.backfillMu.Lock()
+	s.draining = true
+	s.backfillMu.Unlock()
+	undrain := func() {
+		s.backfillMu.Lock()
+		s.draining = false
+		s.backfillMu.Unlock()
+	}
+
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for {
+		active := s.activeBackfills()
+		if active == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			undrain()
+			return fmt.Errorf("%d backfills still running: %w", active, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	s.backfillMu.Lock()
+	runs := make(map[string]*engine.Engine, len(s.flowRuns))
+	for id, e := range s.flowRuns {
+		runs[id] = e
+	}
+	s.backfillMu.Unlock()
+	for id, e := range runs {
+		if err := e.WaitIdle(ctx); err != nil {
+			undrain()
+			return fmt.Errorf("flow %s still holds messages: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// activeBackfills counts the backfills and simulations running or queued
+func (s *Server) activeBackfills() int {
+	s.backfillMu.Lock()
+	defer s.backfillMu.Unlock()
+	active := s.launched
+	for _, queue := range s.backfillQueue {
+		active += len(queue)
+	}
+	return active
+}
+
+// backfill returns a copy of the state of run
+func (s *Server) backfill(run *backfillRun) api.Backfill {
+	s.backfillMu.Lock()
+	defer s.backfillMu.Unlock()
+	return run.Backfill
+}
+
+// @Summary List the backfills of a flow
+// @Description List the backfills of a flow run since the server started, the most recent first, with their status and progress
+// @Tags flows
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Success 200 {array} api.Backfill
+// @Router /flows/{id}/backfills [get]
+func (s *Server) handleListBackfills(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function": "handleListBackfills",
+		"flow_id":  id,
+	}
+
+	s.backfillMu.Lock()
+	runs := []api.Backfill{}
+	for _, run := range s.backfills {
+		if run.FlowID == id {
+			runs = append(runs, run.Backfill)
+		}
+	}
+	s.backfillMu.Unlock()
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+
+	s.writeJSON(w, r, http.StatusOK, runs, fields)
+}
+
+// @Summary Get a backfill
+// @Description Get the status and progress of a backfill of a flow. Its progress tells how much of the input went through, in bytes of files, rows of queries or windows of ranges, and as a percentage.
+// @Tags flows
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Param run path string true "Backfill ID"
+// @Success 200 {object} api.Backfill
+// @Failure 404 {string} string "Backfill not found"
+// @Router /flows/{id}/backfills/{run} [get]
+func (s *Server) handleGetBackfill(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	runID := chi.URLParam(r, "run")
+	fields := types.Fields{
+		"function": "handleGetBackfill",
+		"flow_id":  id,
+		"run_id":   runID,
+	}
+
+	s.backfillMu.Lock()
+	run, ok := s.backfills[runID]
+	s.backfillMu.Unlock()
+	if !ok || run.FlowID != id {
+		http.Error(w, "Backfill not found", http.StatusNotFound)
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusOK, s.backfill(run), fields)
+}
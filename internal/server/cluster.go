@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"flow-control/internal/cluster"
+	"flow-control/internal/events"
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/types"
+)
+
+// flowStopTimeout bounds how long the engine of a claimed flow may take to
+// stop once its claim ends
+const flowStopTimeout = 10 * time.Second
+
+// @Summary Get the cluster status
+// @Description Get the instance that served the request and the leader of its cluster, which alone runs backups, the retention janitor and the alert evaluator
+// @Tags cluster
+// @Produce json
+// @Success 200 {object} cluster.Status
+// @Failure 501 {string} string "Cluster mode not enabled"
+// @Router /cluster [get]
+func (s *Server) handleClusterStatus(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleClusterStatus",
+	}
+
+	if s.cluster == nil {
+		http.Error(w, "Cluster mode not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	status, err := s.cluster.Status()
+	if err != nil {
+		s.requestLog(r).Error("Failed to get cluster status", err, fields)
+		http.Error(w, "Failed to get cluster status", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, status, fields)
+}
+
+// @Summary List flow run placements
+// @Description List the live instances of the cluster and which instance runs each running flow. Runs no instance claimed yet have no instance.
+// @Tags cluster
+// @Produce json
+// @Success 200 {object} cluster.Placement
+// @Failure 501 {string} string "Cluster mode not enabled"
+// @Router /cluster/runs [get]
+func (s *Server) handleListRunPlacements(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleListRunPlacements",
+	}
+
+	if s.cluster == nil {
+		http.Error(w, "Cluster mode not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	placement, err := cluster.Placements(s.store)
+	if err != nil {
+		s.requestLog(r).Error("Failed to list run placements", err, fields)
+		http.Error(w, "Failed to list run placements", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, placement, fields)
+}
+
+// RunFlow runs the engine of a running flow until ctx is done, for the
+// instance of a cluster that claimed its run, publishing the events of its
+// nodes. Drain waits for the engine to hold no messages. Flows whose engine cannot start are marked failed, so that no
+// instance claims them again until they are restarted, and the error is
+// returned at once.
+func (s *Server) RunFlow(ctx context.Context, flow *types.RuntimeFlow) error {
+	fields := types.Fields{
+		"function": "RunFlow",
+		"flow_id":  flow.ID,
+	}
+
+	e, err := s.newFlowEngine(flow)
+	if err == nil {
+		err = e.Start(ctx)
+	}
+	if err != nil {
+		s.failFlow(flow.ID, err, fields)
+		return err
+	}
+	s.log.Info("Flow run started", fields)
+	s.backfillMu.Lock()
+	s.flowRuns[flow.ID] = e
+	s.backfillMu.Unlock()
+
+	<-ctx.Done()
+	s.backfillMu.Lock()
+	delete(s.flowRuns, flow.ID)
+	s.backfillMu.Unlock()
+	stopCtx, cancel := context.WithTimeout(context.Background(), flowStopTimeout)
+	defer cancel()
+	if err := e.Stop(stopCtx); err != nil {
+		return fmt.Errorf("failed to stop flow %s: %w", flow.ID, err)
+	}
+	s.log.Info("Flow run stopped", fields)
+	return nil
+}
+
+// newFlowEngine creates the engine running a stored flow with the
+// parameters it was started with
+func (s *Server) newFlowEngine(flow *types.RuntimeFlow) (*engine.Engine, error) {
+	graph, err := engine.LoadSource(flow.Config, "", s.log)
+	if err != nil {
+		return nil, err
+	}
+	runID, err := newRunID()
+	if err != nil {
+		return nil, err
+	}
+	var schemas engine.SchemaSource
+	if s.schemas != nil {
+		schemas = s.schemas
+	}
+	return engine.New(graph, s.nodes, s.log, engine.Options{
+		Schemas:      schemas,
+		Params:       flow.Params,
+		Flows:        s.storedFlows(s.log),
+		MaxCallDepth: s.limits.MaxCallDepth,
+		DataDir:      s.dataDir,
+		Lookups:      s.lookups,
+		Secrets:      s.secrets,
+		Spill:        s.spill(flow.ID),
+		OnEvent: func(event types.FlowEvent) {
+			s.publishEvent(context.Background(), s.log, event)
+		},
+		RunID: runID,
+	})
+}
+
+// failFlow marks a flow whose run could not start as failed
+func (s *Server) failFlow(id string, cause error, fields types.Fields) {
+	s.log.Error("Failed to start flow run", cause, fields)
+	if err := s.store.UpdateFlowStatus(id, types.FlowStatusFailed); err != nil {
+		s.log.Error("Failed to update flow status", err, fields)
+		return
+	}
+	s.publishEvent(context.Background(), s.log, types.FlowEvent{
+		FlowID:  id,
+		Type:    events.TypeFlowFailed,
+		Message: "Flow run failed to start: " + cause.Error(),
+	})
+}
@@ -0,0 +1,204 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"flow-control/internal/cluster"
+	"flow-control/internal/config"
+	"flow-control/internal/logger"
+	"flow-control/internal/server"
+	"flow-control/internal/store"
+	"flow-control/internal/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterStatus(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "cluster.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	getStatus := func(ts *httptest.Server) (int, cluster.Status) {
+		resp, err := http.Get(ts.URL + "/api/cluster")
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		var status cluster.Status
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+		}
+		return resp.StatusCode, status
+	}
+
+	// Standalone servers have no cluster
+	standalone := httptest.NewServer(server.New(st, log))
+	defer standalone.Close()
+	code, _ := getStatus(standalone)
+	require.Equal(t, http.StatusNotImplemented, code)
+
+	elector := cluster.NewElector(st, log, cluster.Options{Instance: "node-a", LeaseDuration: time.Second})
+	ts := httptest.NewServer(server.New(st, log, server.WithCluster(elector)))
+	defer ts.Close()
+
+	// No instance leads before the election
+	code, status := getStatus(ts)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, cluster.Status{Instance: "node-a"}, status)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = elector.Run(ctx, func(ctx context.Context) { <-ctx.Done() })
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	require.Eventually(t, elector.IsLeader, time.Second, 5*time.Millisecond)
+
+	code, status = getStatus(ts)
+	require.Equal(t, http.StatusOK, code)
+	require.True(t, status.IsLeader)
+	require.Equal(t, "node-a", status.Leader)
+	require.NotNil(t, status.LeaderSince)
+}
+
+func TestClusterRuns(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "cluster.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	for _, id := range []string{"billing", "orders"} {
+		require.NoError(t, st.CreateFlow(&types.RuntimeFlow{ID: id, Name: id, Config: "{}", Status: "stopped"}))
+		require.NoError(t, st.StartFlow(id, nil))
+	}
+
+	getRuns := func(ts *httptest.Server) (int, cluster.Placement) {
+		resp, err := http.Get(ts.URL + "/api/cluster/runs")
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		var placement cluster.Placement
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&placement))
+		}
+		return resp.StatusCode, placement
+	}
+
+	standalone := httptest.NewServer(server.New(st, log))
+	defer standalone.Close()
+	code, _ := getRuns(standalone)
+	require.Equal(t, http.StatusNotImplemented, code)
+
+	opts := cluster.Options{Instance: "node-a", LeaseDuration: time.Minute}
+	ts := httptest.NewServer(server.New(st, log, server.WithCluster(cluster.NewElector(st, log, opts))))
+	defer ts.Close()
+
+	// Runs are unplaced until an instance claims them
+	code, placement := getRuns(ts)
+	require.Equal(t, http.StatusOK, code)
+	require.Empty(t, placement.Instances)
+	require.Equal(t, []cluster.Run{{FlowID: "billing"}, {FlowID: "orders"}}, placement.Runs)
+
+	worker := cluster.NewWorker(st, log, opts, func(ctx context.Context, flow *types.RuntimeFlow) error {
+		<-ctx.Done()
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, worker.Heartbeat(ctx))
+
+	code, placement = getRuns(ts)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, []string{"node-a"}, placement.Instances)
+	require.Len(t, placement.Runs, 2)
+	for _, run := range placement.Runs {
+		require.Equal(t, "node-a", run.Instance)
+		require.NotNil(t, run.ClaimedAt)
+	}
+}
+
+func TestClusterRunFlow(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "cluster.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+	require.NoError(t, st.CreateFlow(&types.RuntimeFlow{ID: "orders", Name: "orders", Status: "stopped", Config: `flow "orders" {
+		node "read" { type: "Passthrough" }
+		node "paid" { type: "Filter" field: "status" }
+	}`}))
+	require.NoError(t, st.StartFlow("orders", nil))
+	require.NoError(t, st.CreateFlow(&types.RuntimeFlow{ID: "broken", Name: "broken", Config: "{}", Status: "stopped"}))
+	require.NoError(t, st.StartFlow("broken", nil))
+	srv := server.New(st, log)
+
+	// The engine of a claimed flow runs until the claim ends
+	flow, err := st.GetFlow("orders")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.RunFlow(ctx, flow) }()
+	select {
+	case err := <-done:
+		t.Fatalf("run ended before its claim: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Restarts wait for the engine to hold no messages
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), time.Second)
+	defer cancelDrain()
+	require.NoError(t, srv.Drain(drainCtx))
+	cancel()
+	require.NoError(t, <-done)
+
+	// Flows whose engine cannot start fail at once and are not run again
+	flow, err = st.GetFlow("broken")
+	require.NoError(t, err)
+	require.Error(t, srv.RunFlow(context.Background(), flow))
+	flow, err = st.GetFlow("broken")
+	require.NoError(t, err)
+	require.Equal(t, types.FlowStatusFailed, flow.Status)
+	placement, err := cluster.Placements(st)
+	require.NoError(t, err)
+	require.Equal(t, []cluster.Run{{FlowID: "orders"}}, placement.Runs)
+}
+
+func TestClusterAdmitRun(t *testing.T) {
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "cluster.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+	for _, id := range []string{"orders", "refunds"} {
+		require.NoError(t, st.CreateFlow(&types.RuntimeFlow{ID: id, Name: id, Config: "{}", Status: "stopped"}))
+		require.NoError(t, st.StartFlow(id, nil))
+	}
+	srv := server.New(st, log, server.WithQuotas(map[string]server.Quota{config.DefaultProject: {MaxRuns: 1}}))
+
+	// Runs beyond the quota of their project are left unclaimed
+	opts := cluster.Options{Instance: "node-a", LeaseDuration: time.Minute, Admit: srv.AdmitRun}
+	w := cluster.NewWorker(st, log, opts, func(ctx context.Context, flow *types.RuntimeFlow) error {
+		<-ctx.Done()
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, w.Heartbeat(ctx))
+	require.Equal(t, []string{"orders"}, w.Claims())
+	require.NoError(t, w.Heartbeat(ctx))
+	require.Equal(t, []string{"orders"}, w.Claims())
+
+	// and claimed once another run of the project ends
+	require.NoError(t, st.UpdateFlowStatus("orders", types.FlowStatusStopped))
+	require.NoError(t, w.Heartbeat(ctx))
+	require.Equal(t, []string{"refunds"}, w.Claims())
+}
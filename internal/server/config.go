@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+
+	"flow-control/internal/config"
+	"flow-control/internal/types"
+)
+
+// ConfigDump is the effective configuration, with the source of every
+// setting
+type ConfigDump struct {
+	// Profile is the config profile applied, such as staging or prod
+	Profile string         `json:"profile,omitempty"`
+	Config  *config.Config `json:"config"`
+	// Settings lists the set values by dotted path, with the file line,
+	// environment variable or flag they came from
+	Settings []config.Setting `json:"settings"`
+}
+
+// @Summary Get the effective configuration
+// @Description Return the configuration the server runs with, including reloaded settings, and where each value came from. Secrets are redacted. Requires the admin token.
+// @Tags admin
+// @Produce json
+// @Success 200 {object} ConfigDump
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 501 {string} string "Configuration not available"
+// @Router /admin/config [get]
+func (s *Server) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleGetConfig",
+	}
+
+	if s.config == nil {
+		http.Error(w, "Configuration not available", http.StatusNotImplemented)
+		return
+	}
+
+	cfg := s.config()
+	s.writeJSON(w, r, http.StatusOK, ConfigDump{
+		Profile:  cfg.Profile(),
+		Config:   cfg.Redacted(),
+		Settings: cfg.Settings(),
+	}, fields)
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/types"
+)
+
+// debugEvents are the event types of breakpoints published to the event
+// stream, for debuggers to follow the messages they stop
+var debugEvents = map[string]bool{
+	engine.TypeBreakpointHit:       true,
+	engine.TypeBreakpointStepped:   true,
+	engine.TypeBreakpointContinued: true,
+}
+
+// StepResult is what stepping or continuing a stopped message led to
+type StepResult struct {
+	// Breaks are where the message, or the messages the node produced,
+	// stopped next. It is empty once they left the flow.
+	Breaks []engine.Break `json:"breaks"`
+	// Error joins the failures of the nodes the message went through
+	Error string `json:"error,omitempty"`
+}
+
+// breakRun returns the running backfill holding the message stopped at the
+// break with id
+func (s *Server) breakRun(id string) *backfillRun {
+	s.backfillMu.Lock()
+	defer s.backfillMu.Unlock()
+	for _, run := range s.backfills {
+		if run.Status != types.FlowStatusRunning {
+			continue
+		}
+		for _, b := range run.engine.Breaks() {
+			if b.ID == id {
+				return run
+			}
+		}
+	}
+	return nil
+}
+
+// @Summary List the breakpoints of a running flow
+// @Description List the nodes of a flow running on this server that messages stop before, in the order of the flow
+// @Tags debug
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Success 200 {array} string
+// @Failure 404 {string} string "Flow not found"
+// @Failure 409 {string} string "Flow not running"
+// @Router /flows/{id}/breakpoints [get]
+func (s *Server) handleListBreakpoints(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleListBreakpoints",
+		"flow_id":  chi.URLParam(r, "id"),
+	}
+
+	runs := s.runningBackfills(w, r, fields)
+	if runs == nil {
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, runs[0].engine.Breakpoints(), fields)
+}
+
+// @Summary Set a breakpoint
+// @Description Make the messages reaching a node of a running flow, in each of its runs, stop before it until they are stepped or continued through /breaks. Backfills do not complete while messages are stopped. Backfills can also start with breakpoints set.
+// @Tags debug
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Param node path string true "Node ID"
+// @Success 200 {array} string
+// @Failure 404 {string} string "Flow or node not found"
+// @Failure 409 {string} string "Flow not running"
+// @Router /flows/{id}/breakpoints/{node} [put]
+func (s *Server) handleSetBreakpoint(w http.ResponseWriter, r *http.Request) {
+	s.changeBreakpoint(w, r, (*engine.Engine).SetBreakpoint, types.Fields{
+		"function": "handleSetBreakpoint",
+		"flow_id":  chi.URLParam(r, "id"),
+		"node_id":  chi.URLParam(r, "node"),
+	})
+}
+
+// @Summary Clear a breakpoint
+// @Description Let the messages reaching a node of a running flow go through it again. Messages already stopped before it stay stopped.
+// @Tags debug
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Param node path string true "Node ID"
+// @Success 200 {array} string
+// @Failure 404 {string} string "Flow or node not found"
+// @Failure 409 {string} string "Flow not running"
+// @Router /flows/{id}/breakpoints/{node} [delete]
+func (s *Server) handleClearBreakpoint(w http.ResponseWriter, r *http.Request) {
+	s.changeBreakpoint(w, r, (*engine.Engine).ClearBreakpoint, types.Fields{
+		"function": "handleClearBreakpoint",
+		"flow_id":  chi.URLParam(r, "id"),
+		"node_id":  chi.URLParam(r, "node"),
+	})
+}
+
+// changeBreakpoint sets or clears the breakpoint of the node in the URL in
+// every run of its flow, responding with the breakpoints of the flow
+func (s *Server) changeBreakpoint(w http.ResponseWriter, r *http.Request, change func(*engine.Engine, string) error, fields types.Fields) {
+	nodeID := chi.URLParam(r, "node")
+	runs := s.runningBackfills(w, r, fields)
+	if runs == nil {
+		return
+	}
+	for _, run := range runs {
+		// Changing breakpoints only fails for nodes the flow does not have
+		if err := change(run.engine, nodeID); err != nil {
+			http.Error(w, "Node not found", http.StatusNotFound)
+			return
+		}
+	}
+	s.writeJSON(w, r, http.StatusOK, runs[0].engine.Breakpoints(), fields)
+}
+
+// @Summary List stopped messages
+// @Description List the messages stopped at the breakpoints of the flows running on this server, the oldest first
+// @Tags debug
+// @Produce json
+// @Param flow query string false "Only list the messages of this flow"
+// @Success 200 {array} engine.Break
+// @Router /breaks [get]
+func (s *Server) handleListBreaks(w http.ResponseWriter, r *http.Request) {
+	flowID := r.URL.Query().Get("flow")
+	fields := types.Fields{
+		"function": "handleListBreaks",
+		"flow_id":  flowID,
+	}
+
+	s.backfillMu.Lock()
+	breaks := []engine.Break{}
+	for _, run := range s.backfills {
+		if run.Status == types.FlowStatusRunning && (flowID == "" || run.FlowID == flowID) {
+			breaks = append(breaks, run.engine.Breaks()...)
+		}
+	}
+	s.backfillMu.Unlock()
+	sort.Slice(breaks, func(i, j int) bool {
+		return breaks[i].HitAt.Before(breaks[j].HitAt)
+	})
+
+	s.writeJSON(w, r, http.StatusOK, breaks, fields)
+}
+
+// @Summary Get a stopped message
+// @Description Get a message stopped at a breakpoint, as the node it waits for will receive it
+// @Tags debug
+// @Produce json
+// @Param break path string true "Break ID"
+// @Success 200 {object} engine.Break
+// @Failure 404 {string} string "Break not found"
+// @Router /breaks/{break} [get]
+func (s *Server) handleGetBreak(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "break")
+	fields := types.Fields{
+		"function": "handleGetBreak",
+		"break_id": id,
+	}
+
+	if run := s.breakRun(id); run != nil {
+		for _, b := range run.engine.Breaks() {
+			if b.ID == id {
+				s.writeJSON(w, r, http.StatusOK, b, fields)
+				return
+			}
+		}
+	}
+	http.Error(w, "Break not found", http.StatusNotFound)
+}
+
+// @Summary Edit a stopped message
+// @Description Replace the payload or the headers of a message stopped at a breakpoint before stepping or continuing it. Fields left out are kept.
+// @Tags debug
+// @Accept json
+// @Produce json
+// @Param break path string true "Break ID"
+// @Param request body engine.BreakEdit true "New payload or headers"
+// @Success 200 {object} engine.Break
+// @Failure 400 {string} string "Invalid edit"
+// @Failure 404 {string} string "Break not found"
+// @Router /breaks/{break} [put]
+func (s *Server) handleEditBreak(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "break")
+	fields := types.Fields{
+		"function": "handleEditBreak",
+		"break_id": id,
+	}
+
+	var edit engine.BreakEdit
+	if err := json.NewDecoder(r.Body).Decode(&edit); err != nil {
+		http.Error(w, "Invalid edit", http.StatusBadRequest)
+		return
+	}
+	run := s.breakRun(id)
+	if run == nil {
+		http.Error(w, "Break not found", http.StatusNotFound)
+		return
+	}
+	b, err := run.engine.EditBreak(id, edit)
+	if err != nil {
+		// Stepped or continued in the meantime
+		http.Error(w, "Break not found", http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, b, fields)
+}
+
+// @Summary Step a stopped message
+// @Description Pass a message stopped at a breakpoint through the node it waits for, stopping the messages the node produced before the nodes after it, before the response is sent
+// @Tags debug
+// @Produce json
+// @Param break path string true "Break ID"
+// @Success 200 {object} StepResult
+// @Failure 404 {string} string "Break not found"
+// @Router /breaks/{break}/step [post]
+func (s *Server) handleStepBreak(w http.ResponseWriter, r *http.Request) {
+	s.release(w, r, (*engine.Engine).Step, types.Fields{
+		"function": "handleStepBreak",
+		"break_id": chi.URLParam(r, "break"),
+	})
+}
+
+// @Summary Continue a stopped message
+// @Description Pass a message stopped at a breakpoint on through its flow until it leaves the flow or reaches other breakpoints, before the response is sent
+// @Tags debug
+// @Produce json
+// @Param break path string true "Break ID"
+// @Success 200 {object} StepResult
+// @Failure 404 {string} string "Break not found"
+// @Router /breaks/{break}/continue [post]
+func (s *Server) handleContinueBreak(w http.ResponseWriter, r *http.Request) {
+	s.release(w, r, (*engine.Engine).Continue, types.Fields{
+		"function": "handleContinueBreak",
+		"break_id": chi.URLParam(r, "break"),
+	})
+}
+
+// release steps or continues the message stopped at the break in the URL
+func (s *Server) release(w http.ResponseWriter, r *http.Request, release func(*engine.Engine, context.Context, string) ([]engine.Break, error), fields types.Fields) {
+	id := chi.URLParam(r, "break")
+	run := s.breakRun(id)
+	if run == nil {
+		http.Error(w, "Break not found", http.StatusNotFound)
+		return
+	}
+	breaks, err := release(run.engine, run.ctx, id)
+	if errors.Is(err, engine.ErrBreakNotFound) {
+		http.Error(w, "Break not found", http.StatusNotFound)
+		return
+	}
+	result := StepResult{Breaks: breaks}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	s.writeJSON(w, r, http.StatusOK, result, fields)
+}
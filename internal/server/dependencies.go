@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/types"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// FlowDependencies lists the flows one flow calls and the flows calling it
+type FlowDependencies struct {
+	FlowID string `json:"flow_id"`
+	// Calls are the flows the flow's Call nodes invoke
+	Calls []string `json:"calls"`
+	// CalledBy are the flows whose Call nodes invoke the flow
+	CalledBy []string `json:"called_by"`
+	// Missing are the called flows that are not stored
+	Missing []string `json:"missing"`
+}
+
+// DependencyGraph maps every stored flow to the flows it calls
+type DependencyGraph struct {
+	Flows map[string][]string `json:"flows"`
+}
+
+// @Summary Get the flow dependency graph
+// @Description Map every flow to the flows its Call nodes invoke. Flows whose config does not parse call none.
+// @Tags flows
+// @Produce json
+// @Success 200 {object} DependencyGraph
+// @Router /flows/dependencies [get]
+func (s *Server) handleFlowDependencyGraph(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleFlowDependencyGraph",
+	}
+
+	calls, err := s.flowCalls(r)
+	if err != nil {
+		s.requestLog(r).Error("Failed to list flows", err, fields)
+		http.Error(w, "Failed to list flows", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, DependencyGraph{Flows: calls}, fields)
+}
+
+// @Summary Get the dependencies of a flow
+// @Description List the flows a flow calls, the flows calling it and the called flows that do not exist
+// @Tags flows
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Success 200 {object} FlowDependencies
+// @Failure 404 {string} string "Flow not found"
+// @Router /flows/{id}/dependencies [get]
+func (s *Server) handleFlowDependencies(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function": "handleFlowDependencies",
+		"flow_id":  id,
+	}
+
+	calls, err := s.flowCalls(r)
+	if err != nil {
+		s.requestLog(r).Error("Failed to list flows", err, fields)
+		http.Error(w, "Failed to list flows", http.StatusInternalServerError)
+		return
+	}
+	own, ok := calls[id]
+	if !ok {
+		http.Error(w, "Flow not found", http.StatusNotFound)
+		return
+	}
+
+	deps := FlowDependencies{
+		FlowID:   id,
+		Calls:    own,
+		CalledBy: []string{},
+		Missing:  []string{},
+	}
+	for _, called := range own {
+		if _, ok := calls[called]; !ok {
+			deps.Missing = append(deps.Missing, called)
+		}
+	}
+	for caller, called := range calls {
+		for _, c := range called {
+			if c == id {
+				deps.CalledBy = append(deps.CalledBy, caller)
+				break
+			}
+		}
+	}
+	sort.Strings(deps.CalledBy)
+	s.writeJSON(w, r, http.StatusOK, deps, fields)
+}
+
+// flowCalls maps the ID of every stored flow to the flows it calls
+func (s *Server) flowCalls(r *http.Request) (map[string][]string, error) {
+	flows, err := s.store.ListFlows()
+	if err != nil {
+		return nil, err
+	}
+	calls := make(map[string][]string, len(flows))
+	for _, flow := range flows {
+		calls[flow.ID] = configCalls(flow.Config, s.requestLog(r))
+	}
+	return calls, nil
+}
+
+// configCalls returns the flows the Call nodes of a flow config invoke, or
+// none if the config does not parse
+func configCalls(config string, log types.Logger) []string {
+	graph, err := engine.LoadSource(config, "", log)
+	if err != nil {
+		return []string{}
+	}
+	calls := engine.Calls(graph)
+	if calls == nil {
+		calls = []string{}
+	}
+	return calls
+}
+
+// callCycle returns the flows through which the flow with id would call
+// itself if it had config, starting and ending with id, or nil if it would
+// not
+func (s *Server) callCycle(r *http.Request, id, config string) ([]string, error) {
+	calls, err := s.flowCalls(r)
+	if err != nil {
+		return nil, err
+	}
+	calls[id] = configCalls(config, s.requestLog(r))
+	return engine.CallCycle(id, func(id string) []string { return calls[id] }), nil
+}
+
+// rejectCallCycle responds with 422 and returns true if saving flow would
+// make flows call each other in a loop
+func (s *Server) rejectCallCycle(w http.ResponseWriter, r *http.Request, flow *types.RuntimeFlow, fields types.Fields) bool {
+	cycle, err := s.callCycle(r, flow.ID, flow.Config)
+	if err != nil {
+		s.requestLog(r).Error("Failed to check flow calls", err, fields)
+		http.Error(w, "Failed to check flow calls", http.StatusInternalServerError)
+		return true
+	}
+	if cycle == nil {
+		return false
+	}
+	http.Error(w, callCycleMessage(cycle), http.StatusUnprocessableEntity)
+	return true
+}
+
+// callCycleMessage describes a cycle of flows calling each other
+func callCycleMessage(cycle []string) string {
+	return fmt.Sprintf("flow %q calls itself: %s", cycle[0], strings.Join(cycle, " -> "))
+}
+
+// storedFlows resolves the calls of dry runs to stored flows
+func (s *Server) storedFlows(log types.Logger) engine.FlowLoader {
+	return func(ctx context.Context, id string) (*engine.Graph, error) {
+		flow, err := s.store.GetFlow(id)
+		if err != nil {
+			return nil, err
+		}
+		return engine.LoadSource(flow.Config, "", log)
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	rpprof "runtime/pprof"
+	"strings"
+
+	"flow-control/internal/types"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// adminTokenHeader is an alternative to a bearer Authorization header
+const adminTokenHeader = "X-Admin-Token"
+
+// requireAdmin only lets requests carrying the admin token through. Without
+// a configured token the guarded routes are unavailable.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			http.Error(w, "Admin token not configured", http.StatusForbidden)
+			return
+		}
+
+		token := r.Header.Get(adminTokenHeader)
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			token = bearer
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			s.requestLog(r).Warn("Rejected admin request", types.Fields{
+				"function": "requireAdmin",
+				"path":     r.URL.Path,
+			})
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// diagnosticsRoutes serves profiling and runtime diagnostics on r, which
+// must be guarded by requireAdmin
+func (s *Server) diagnosticsRoutes(r chi.Router) {
+	r.Get("/goroutines", s.handleGoroutines)
+	r.Get("/config", s.handleGetConfig)
+	r.Post("/restart", s.handleRestart)
+	r.Get("/logs/stream", s.handleStreamLogs)
+	r.Get("/debug/vars", expvar.Handler().ServeHTTP)
+	r.Get("/debug/pprof/", pprof.Index)
+	r.Get("/debug/pprof/cmdline", pprof.Cmdline)
+	r.Get("/debug/pprof/profile", pprof.Profile)
+	r.Get("/debug/pprof/symbol", pprof.Symbol)
+	r.Post("/debug/pprof/symbol", pprof.Symbol)
+	r.Get("/debug/pprof/trace", pprof.Trace)
+	r.Get("/debug/pprof/{profile}", func(w http.ResponseWriter, r *http.Request) {
+		pprof.Handler(chi.URLParam(r, "profile")).ServeHTTP(w, r)
+	})
+}
+
+// @Summary Dump goroutines
+// @Description Dump the stacks of all goroutines as text. Requires the admin token.
+// @Tags admin
+// @Produce plain
+// @Success 200 {string} string "Goroutine stacks"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /admin/goroutines [get]
+func (s *Server) handleGoroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := rpprof.Lookup("goroutine").WriteTo(w, 2); err != nil {
+		s.requestLog(r).Error("Failed to dump goroutines", err, types.Fields{
+			"function": "handleGoroutines",
+		})
+	}
+}
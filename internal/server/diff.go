@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/runtime/flowdiff"
+	"flow-control/internal/types"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// DiffRequest holds the two versions of flow source compared by the diff
+// endpoint
+type DiffRequest struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+	// Flow selects the flow to compare when the sources define several
+	Flow string `json:"flow,omitempty"`
+}
+
+// @Summary Compare flow sources
+// @Description Structurally compare two versions of Flow source, listing the nodes, connections and settings that were added, removed or changed
+// @Tags flows
+// @Accept json
+// @Produce json
+// @Param request body DiffRequest true "Sources to compare"
+// @Success 200 {object} flowdiff.Diff
+// @Failure 400 {string} string "Invalid request"
+// @Failure 422 {string} string "Invalid flow source"
+// @Router /flows/diff [post]
+func (s *Server) handleDiffSources(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleDiffSources",
+	}
+
+	var req DiffRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid diff request", http.StatusBadRequest)
+		return
+	}
+
+	log := s.requestLog(r)
+	before, err := engine.LoadSource(req.Old, req.Flow, log)
+	if err != nil {
+		http.Error(w, "old: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	after, err := engine.LoadSource(req.New, req.Flow, log)
+	if err != nil {
+		http.Error(w, "new: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusOK, flowdiff.Compare(before, after), fields)
+}
+
+// @Summary Compare flow versions
+// @Description Structurally compare two saved versions of a flow's config. By default the current version is compared with the one before it; a flow's first version is compared with an empty flow.
+// @Tags flows
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Param from query int false "Old version (default: the version before to)"
+// @Param to query int false "New version (default: the current version)"
+// @Param flow query string false "Flow to compare when the config defines several"
+// @Success 200 {object} flowdiff.Diff
+// @Failure 400 {string} string "Invalid version"
+// @Failure 404 {string} string "Flow or version not found"
+// @Failure 422 {string} string "Invalid flow config"
+// @Router /flows/{id}/diff [get]
+func (s *Server) handleDiffFlowVersions(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function": "handleDiffFlowVersions",
+		"flow_id":  id,
+	}
+
+	query := r.URL.Query()
+	from, err := versionParam(query.Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from version", http.StatusBadRequest)
+		return
+	}
+	to, err := versionParam(query.Get("to"))
+	if err != nil {
+		http.Error(w, "Invalid to version", http.StatusBadRequest)
+		return
+	}
+
+	versions, err := s.store.ListFlowVersions(id)
+	if err != nil {
+		s.handleStoreError(w, r, err, "Failed to list flow versions", fields)
+		return
+	}
+
+	// Versions are listed newest first
+	newIndex := 0
+	if to != 0 {
+		newIndex = findVersion(versions, to)
+	}
+	if newIndex < 0 || newIndex >= len(versions) {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+	oldIndex := newIndex + 1
+	if from != 0 {
+		if oldIndex = findVersion(versions, from); oldIndex < 0 {
+			http.Error(w, "Version not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	log := s.requestLog(r)
+	before := &engine.Graph{}
+	if oldIndex < len(versions) {
+		if before, err = loadVersion(versions[oldIndex], query.Get("flow"), log); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	after, err := loadVersion(versions[newIndex], query.Get("flow"), log)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusOK, flowdiff.Compare(before, after), fields)
+}
+
+// versionParam parses an optional version number, returning 0 when absent
+func versionParam(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid version %q", value)
+	}
+	return version, nil
+}
+
+// findVersion returns the index of a version in versions, or -1
+func findVersion(versions []*types.FlowVersion, version int) int {
+	for i, v := range versions {
+		if v.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// loadVersion builds the graph of a saved flow version
+func loadVersion(version *types.FlowVersion, flow string, log types.Logger) (*engine.Graph, error) {
+	graph, err := engine.LoadSource(version.Config, flow, log)
+	if err != nil {
+		return nil, fmt.Errorf("version %d: %w", version.Version, err)
+	}
+	return graph, nil
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/types"
+	"flow-control/pkg/api"
+)
+
+// Limits bound the work the server does within a single request
+type Limits struct {
+	// DryRunMessages is the most sample messages a dry run accepts
+	DryRunMessages int
+	// DryRunTimeout cancels dry runs that take longer
+	DryRunTimeout time.Duration
+	// MaxCallDepth is the most nested calls of other flows a message may
+	// go through
+	MaxCallDepth int
+	// MaxPayloadBytes is the largest payload kept in memory while it waits
+	// for nodes; larger ones are spilled to temporary files in SpillDir.
+	// Zero keeps every payload in memory.
+	MaxPayloadBytes int
+	SpillDir        string
+}
+
+// DefaultLimits returns the limits of servers created without WithLimits
+func DefaultLimits() Limits {
+	return Limits{
+		DryRunMessages: 100,
+		DryRunTimeout:  5 * time.Second,
+		MaxCallDepth:   engine.DefaultMaxCallDepth,
+	}
+}
+
+// @Summary Dry-run flow source
+// @Description Run Flow source in process on sample messages without saving or deploying it, returning the messages that left the flow and the events of its nodes and of the stored flows it calls. With simulate, its Generate and Replay nodes feed it synthetic data instead. By default at most 100 messages are accepted and the run is cancelled after 5 seconds.
+// @Tags flows
+// @Accept json
+// @Produce json
+// @Param request body api.DryRunRequest true "Source and sample messages"
+// @Success 200 {object} api.DryRunResult
+// @Failure 400 {string} string "Invalid request"
+// @Failure 403 {string} string "Fault injection disabled"
+// @Failure 422 {string} string "Invalid flow source, or sources failing to simulate"
+// @Failure 504 {string} string "Dry run timed out"
+// @Router /flows/run [post]
+func (s *Server) handleDryRun(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleDryRun",
+	}
+
+	var req api.DryRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid dry run request", http.StatusBadRequest)
+		return
+	}
+	if req.Simulate && len(req.Messages) > 0 {
+		http.Error(w, "Simulated dry runs take no messages", http.StatusBadRequest)
+		return
+	}
+	if len(req.Messages) > s.limits.DryRunMessages {
+		http.Error(w, fmt.Sprintf("At most %d messages can be run", s.limits.DryRunMessages), http.StatusBadRequest)
+		return
+	}
+	if req.Faults != nil {
+		if s.faults == nil {
+			http.Error(w, "Fault injection is disabled", http.StatusForbidden)
+			return
+		}
+		if err := req.Faults.Validate(); err != nil {
+			http.Error(w, "Invalid faults: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	log := s.requestLog(r)
+	graph, err := engine.LoadSource(req.Source, req.Flow, log)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	faults := s.faults[graph.FlowID]
+	if req.Faults != nil {
+		faults = *req.Faults
+	}
+
+	runID, err := newRunID()
+	if err != nil {
+		s.requestLog(r).Error("Failed to start dry run", err, fields)
+		http.Error(w, "Failed to run flow", http.StatusInternalServerError)
+		return
+	}
+	fields["run_id"] = runID
+	result := api.DryRunResult{
+		RunID:   runID,
+		Outputs: []api.DryRunOutput{},
+		Events:  []types.FlowEvent{},
+		Logs:    []types.NodeLog{},
+	}
+	var schemas engine.SchemaSource
+	if s.schemas != nil {
+		schemas = s.schemas
+	}
+	var hops []types.MessageHop
+	var onHop func(types.MessageHop)
+	if req.Timeline {
+		onHop = func(hop types.MessageHop) {
+			hops = append(hops, hop)
+		}
+	}
+	e, err := engine.New(graph, s.nodes, log, engine.Options{
+		StubUnknown:  req.Stub,
+		Schemas:      schemas,
+		Params:       req.Params,
+		Faults:       faults,
+		Flows:        s.storedFlows(log),
+		MaxCallDepth: s.limits.MaxCallDepth,
+		DataDir:      s.dataDir,
+		Lookups:      s.lookups,
+		Secrets:      s.secrets,
+		Spill:        s.spill(graph.FlowID),
+		OnEvent: func(event types.FlowEvent) {
+			if event.Type == engine.TypeNodeFailed {
+				result.Failed = true
+			}
+			result.Events = append(result.Events, event)
+		},
+		OnOutput: func(nodeID string, msg types.Message) {
+			result.Outputs = append(result.Outputs, api.DryRunOutput{Node: nodeID, ID: msg.ID, Data: msg.Data})
+		},
+		RunID: runID,
+		OnLog: func(entry types.NodeLog) {
+			result.Logs = append(result.Logs, entry)
+		},
+		OnHop: onHop,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if req.Simulate && len(e.Sources()) == 0 {
+		http.Error(w, "Flow has no Generate or Replay nodes to simulate", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// The engine handles one message at a time, even from several sources,
+	// so the callbacks above are never called concurrently
+	messages := make(chan types.Message, len(req.Messages))
+	for i, data := range req.Messages {
+		messages <- types.Message{
+			ID:   strconv.Itoa(i + 1),
+			Data: data,
+			Metadata: types.MessageMetadata{
+				Timestamp: time.Now(),
+				Source:    "input",
+			},
+		}
+	}
+	close(messages)
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.limits.DryRunTimeout)
+	defer cancel()
+	if req.Simulate {
+		err = simulate(ctx, e)
+	} else {
+		err = e.Run(ctx, messages)
+	}
+	// Logs and timelines matter most when runs fail, so they are saved
+	// either way
+	if saveErr := s.store.SaveNodeLogs(result.Logs); saveErr != nil {
+		s.requestLog(r).Error("Failed to save node logs", saveErr, fields)
+	}
+	if saveErr := s.store.SaveMessageHops(hops); saveErr != nil {
+		s.requestLog(r).Error("Failed to save message hops", saveErr, fields)
+	}
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			http.Error(w, "Dry run timed out", http.StatusGatewayTimeout)
+			return
+		}
+		if req.Simulate && ctx.Err() == nil {
+			http.Error(w, "Simulation failed: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		s.requestLog(r).Error("Failed to run flow", err, fields)
+		http.Error(w, "Failed to run flow", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusOK, result, fields)
+}
+
+// simulate starts the flow of e, runs its sources and stops it
+func simulate(ctx context.Context, e *engine.Engine) error {
+	if err := e.Start(ctx); err != nil {
+		return err
+	}
+	err := e.Simulate(ctx, nil)
+	if stopErr := e.Stop(context.Background()); stopErr != nil && err == nil {
+		err = stopErr
+	}
+	return err
+}
+
+// spill returns how dry runs of the flow with id spill large payloads,
+// recording the spilled bytes in the server's metrics
+func (s *Server) spill(id string) engine.Spill {
+	spill := engine.Spill{
+		MaxInMemory: s.limits.MaxPayloadBytes,
+		Dir:         s.limits.SpillDir,
+		Compression: s.compression.For(id),
+	}
+	if s.metrics != nil {
+		spill.Metrics = s.metrics
+	}
+	return spill
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"flow-control/internal/events"
+	"flow-control/internal/types"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// eventKeepAlive is how often an idle event stream sends a comment, so
+// proxies do not time the connection out
+const eventKeepAlive = 15 * time.Second
+
+// @Summary Stream events
+// @Description Stream flow events as server-sent events, optionally filtered by flow and type
+// @Tags events
+// @Produce text/event-stream
+// @Param flow_id query string false "Only stream events of this flow"
+// @Param type query []string false "Only stream events of these types" collectionFormat(multi)
+// @Success 200 {object} types.FlowEvent
+// @Failure 501 {string} string "Event bus not configured"
+// @Router /events [get]
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	s.streamEvents(w, r, events.Filter{
+		FlowID: r.URL.Query().Get("flow_id"),
+		Types:  r.URL.Query()["type"],
+	})
+}
+
+// @Summary Stream flow events
+// @Description Stream the events of one flow as server-sent events
+// @Tags events
+// @Produce text/event-stream
+// @Param id path string true "Flow ID"
+// @Param type query []string false "Only stream events of these types" collectionFormat(multi)
+// @Success 200 {object} types.FlowEvent
+// @Failure 501 {string} string "Event bus not configured"
+// @Router /flows/{id}/events [get]
+func (s *Server) handleFlowEvents(w http.ResponseWriter, r *http.Request) {
+	s.streamEvents(w, r, events.Filter{
+		FlowID: chi.URLParam(r, "id"),
+		Types:  r.URL.Query()["type"],
+	})
+}
+
+// streamEvents writes the events matching filter as server-sent events until
+// the client disconnects or the bus is closed
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, filter events.Filter) {
+	fields := types.Fields{
+		"function": "streamEvents",
+		"flow_id":  filter.FlowID,
+	}
+
+	if s.events == nil {
+		http.Error(w, "Event bus not configured", http.StatusNotImplemented)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := s.events.Subscribe(filter)
+	defer func() {
+		sub.Close()
+		if dropped := sub.Dropped(); dropped > 0 {
+			fields["dropped"] = dropped
+			s.requestLog(r).Warn("Event stream fell behind", fields)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				s.requestLog(r).Error("Failed to encode event", err, fields)
+				continue
+			}
+			if _, err := w.Write([]byte("event: " + event.Type + "\ndata: " + string(data) + "\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// publish sends a flow event to the bus, if there is one. Failures are
+// logged but do not fail the request that caused the event.
+func (s *Server) publish(r *http.Request, event types.FlowEvent) {
+	s.publishEvent(r.Context(), s.requestLog(r), event)
+}
+
+// publishEvent is publish for events that happen outside of requests, such
+// as the end of a backfill, logging failures to log
+func (s *Server) publishEvent(ctx context.Context, log types.Logger, event types.FlowEvent) {
+	if s.events == nil {
+		return
+	}
+	if err := s.events.Publish(ctx, event); err != nil {
+		log.Warn("Failed to publish event", types.Fields{
+			"function": "publish",
+			"flow_id":  event.FlowID,
+			"type":     event.Type,
+			"error":    err.Error(),
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package server_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"flow-control/internal/events"
+	"flow-control/internal/logger"
+	"flow-control/internal/server"
+	"flow-control/internal/store"
+	"flow-control/internal/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventStream(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "events.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	bus := events.NewMemoryBus(0)
+	defer func() { _ = bus.Close() }()
+	ts := httptest.NewServer(server.New(st, log, server.WithEventBus(bus)))
+	defer ts.Close()
+
+	// Subscribe to one flow's events
+	resp, err := http.Get(ts.URL + "/api/flows/stream-flow/events")
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// Lifecycle changes of other flows are filtered out
+	for _, id := range []string{"other-flow", "stream-flow"} {
+		created, err := http.Post(ts.URL+"/api/flows/", "application/json",
+			strings.NewReader(`{"id":"`+id+`","name":"Stream","config":"{}"}`))
+		require.NoError(t, err)
+		require.NoError(t, created.Body.Close())
+		require.Equal(t, http.StatusCreated, created.StatusCode)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "event: "+events.TypeFlowCreated+"\n", line)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	data, ok := strings.CutPrefix(line, "data: ")
+	require.True(t, ok)
+	var event types.FlowEvent
+	require.NoError(t, json.Unmarshal([]byte(data), &event))
+	require.Equal(t, "stream-flow", event.FlowID)
+
+	// Without a bus the streams are unavailable
+	bare := httptest.NewServer(server.New(st, log))
+	defer bare.Close()
+	for _, path := range []string{"/api/events", "/api/flows/stream-flow/events"} {
+		resp, err := http.Get(bare.URL + path)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	}
+}
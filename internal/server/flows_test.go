@@ -0,0 +1,1318 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"flow-control/internal/config"
+	"flow-control/internal/events"
+	"flow-control/internal/logger"
+	"flow-control/internal/restart"
+	"flow-control/internal/runtime/backfill"
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/secrets"
+	"flow-control/internal/server"
+	"flow-control/internal/store"
+	"flow-control/internal/testsupport"
+	"flow-control/internal/types"
+	"flow-control/pkg/api"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateFlowConflict(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "flows.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	require.NoError(t, st.CreateFlow(&types.RuntimeFlow{ID: "edit-flow", Name: "Edit Flow", Config: "{}"}))
+
+	srv := server.New(st, log)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	put := func(body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/flows/edit-flow", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// An update based on the current revision succeeds and bumps it
+	resp := put(`{"name":"First","config":"{}","revision":1}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var flow types.RuntimeFlow
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&flow))
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, 2, flow.Revision)
+
+	// Replaying the same revision conflicts
+	resp = put(`{"name":"Second","config":"{}","revision":1}`)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// Unknown flows are not found
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/flows/missing", strings.NewReader(`{"revision":1}`))
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestRequestCorrelation(t *testing.T) {
+	// Create test dependencies
+	log := logger.New(logger.WithFile(filepath.Join(t.TempDir(), "requests.log")))
+	st, err := store.New(filepath.Join(t.TempDir(), "flows.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	srv := server.New(st, log)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	// Entries logged while handling a request carry its ID
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/flows/", strings.NewReader("not json"))
+	require.NoError(t, err)
+	req.Header.Set("X-Request-Id", "req-123")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.Equal(t, "req-123", resp.Header.Get("X-Request-Id"))
+
+	entries, err := log.ReadLogs(&logger.LogQuery{Contains: "decode flow"})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "req-123", entries[0].Fields[logger.FieldRequestID])
+	require.Equal(t, "handleCreateFlow", entries[0].Fields["function"])
+
+	// Requests without an ID are assigned one
+	resp, err = http.Get(ts.URL + "/api/flows/")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.NotEmpty(t, resp.Header.Get("X-Request-Id"))
+}
+
+func TestStartStopFlow(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "flows.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+	require.NoError(t, st.CreateFlow(&types.RuntimeFlow{ID: "run-flow", Name: "Run Flow", Config: "{}"}))
+
+	ts := httptest.NewServer(server.New(st, log))
+	defer ts.Close()
+
+	for path, status := range map[string]string{"/start": "running", "/stop": "stopped"} {
+		resp, err := http.Post(ts.URL+"/api/flows/run-flow"+path, "application/json", nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var flow types.RuntimeFlow
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&flow))
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, status, flow.Status)
+	}
+
+	resp, err := http.Post(ts.URL+"/api/flows/missing/start", "application/json", nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAPIKeys(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "flows.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	ts := httptest.NewServer(server.New(st, log, server.WithAPIKeys("ci", "ops")))
+	defer ts.Close()
+
+	get := func(header, value string) int {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/flows", nil)
+		require.NoError(t, err)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	// Requests need one of the keys, in either header
+	require.Equal(t, http.StatusUnauthorized, get("", ""))
+	require.Equal(t, http.StatusUnauthorized, get("X-API-Key", "wrong"))
+	require.Equal(t, http.StatusOK, get("X-API-Key", "ci"))
+	require.Equal(t, http.StatusOK, get("Authorization", "Bearer ops"))
+}
+
+func TestFlowGraph(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "flows.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+	require.NoError(t, st.CreateFlow(&types.RuntimeFlow{ID: "orders", Name: "Orders", Config: `flow "orders" {
+		node "read" { type: "Passthrough" }
+		node "paid" { type: "Filter" field: "status" qos: "at-least-once" }
+	}`}))
+	require.NoError(t, st.CreateFlow(&types.RuntimeFlow{ID: "broken", Name: "Broken", Config: "{}"}))
+
+	ts := httptest.NewServer(server.New(st, log))
+	defer ts.Close()
+
+	get := func(path string) (int, string, string) {
+		resp, err := http.Get(ts.URL + path)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		var body strings.Builder
+		_, err = io.Copy(&body, resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, resp.Header.Get("Content-Type"), body.String()
+	}
+
+	// SVG is the default format
+	code, contentType, body := get("/api/flows/orders/graph")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "image/svg+xml", contentType)
+	require.True(t, strings.HasPrefix(body, "<svg "), body)
+
+	code, contentType, body = get("/api/flows/orders/graph?format=dot")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "text/vnd.graphviz; charset=utf-8", contentType)
+	require.Contains(t, body, `"read" -> "paid" [label="at-least-once"];`)
+
+	code, _, body = get("/api/flows/orders/graph?format=mermaid")
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, `n0 -->|"at-least-once"| n1`)
+
+	// Errors
+	code, _, _ = get("/api/flows/orders/graph?format=png")
+	require.Equal(t, http.StatusBadRequest, code)
+	code, _, _ = get("/api/flows/orders/graph?flow=missing")
+	require.Equal(t, http.StatusUnprocessableEntity, code)
+	code, _, _ = get("/api/flows/broken/graph")
+	require.Equal(t, http.StatusUnprocessableEntity, code)
+	code, _, _ = get("/api/flows/missing/graph")
+	require.Equal(t, http.StatusNotFound, code)
+}
+
+func TestFlowDiff(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "flows.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+	flow := &types.RuntimeFlow{ID: "orders", Name: "Orders", Config: `flow "orders" {
+		node "read" { type: "Passthrough" }
+	}`}
+	require.NoError(t, st.CreateFlow(flow))
+	flow.Config = `flow "orders" {
+		node "read" { type: "Passthrough" }
+		node "paid" { type: "Filter" field: "status" }
+	}`
+	require.NoError(t, st.UpdateFlow(flow))
+	flow.Config = `flow "orders" {
+		node "read" { type: "Passthrough" }
+		node "paid" { type: "Filter" field: "state" }
+	}`
+	require.NoError(t, st.UpdateFlow(flow))
+
+	ts := httptest.NewServer(server.New(st, log))
+	defer ts.Close()
+
+	type diff struct {
+		Nodes []struct {
+			ID     string `json:"id"`
+			Change string `json:"change"`
+			Keys   []struct {
+				Key string      `json:"key"`
+				Old interface{} `json:"old"`
+				New interface{} `json:"new"`
+			} `json:"keys"`
+		} `json:"nodes"`
+		Connections []struct {
+			From   string `json:"from"`
+			To     string `json:"to"`
+			Change string `json:"change"`
+		} `json:"connections"`
+	}
+	decode := func(resp *http.Response) diff {
+		defer func() { _ = resp.Body.Close() }()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var d diff
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&d))
+		return d
+	}
+	status := func(resp *http.Response, err error) int {
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// By default the current version is compared with the previous one
+	resp, err := http.Get(ts.URL + "/api/flows/orders/diff")
+	require.NoError(t, err)
+	d := decode(resp)
+	require.Len(t, d.Nodes, 1)
+	require.Equal(t, "paid", d.Nodes[0].ID)
+	require.Equal(t, "changed", d.Nodes[0].Change)
+	require.Equal(t, "field", d.Nodes[0].Keys[0].Key)
+	require.Equal(t, "status", d.Nodes[0].Keys[0].Old)
+	require.Equal(t, "state", d.Nodes[0].Keys[0].New)
+	require.Empty(t, d.Connections)
+
+	resp, err = http.Get(ts.URL + "/api/flows/orders/diff?from=1&to=2")
+	require.NoError(t, err)
+	d = decode(resp)
+	require.Len(t, d.Nodes, 1)
+	require.Equal(t, "added", d.Nodes[0].Change)
+	require.Len(t, d.Connections, 1)
+	require.Equal(t, "read", d.Connections[0].From)
+
+	// The first version is compared with an empty flow
+	resp, err = http.Get(ts.URL + "/api/flows/orders/diff?to=1")
+	require.NoError(t, err)
+	d = decode(resp)
+	require.Len(t, d.Nodes, 1)
+	require.Equal(t, "read", d.Nodes[0].ID)
+	require.Equal(t, "added", d.Nodes[0].Change)
+
+	require.Equal(t, http.StatusBadRequest, status(http.Get(ts.URL+"/api/flows/orders/diff?from=first")))
+	require.Equal(t, http.StatusNotFound, status(http.Get(ts.URL+"/api/flows/orders/diff?from=9")))
+	require.Equal(t, http.StatusNotFound, status(http.Get(ts.URL+"/api/flows/missing/diff")))
+
+	// Sources can be compared without saving them
+	body := `{"old": "flow \"f\" { node \"a\" { type: \"Passthrough\" } }",
+		"new": "flow \"f\" { node \"a\" { type: \"Transform\" } }"}`
+	resp, err = http.Post(ts.URL+"/api/flows/diff", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	d = decode(resp)
+	require.Len(t, d.Nodes, 1)
+	require.Equal(t, "type", d.Nodes[0].Keys[0].Key)
+	require.Equal(t, "Transform", d.Nodes[0].Keys[0].New)
+
+	require.Equal(t, http.StatusBadRequest, status(http.Post(ts.URL+"/api/flows/diff", "application/json", strings.NewReader("{"))))
+	require.Equal(t, http.StatusUnprocessableEntity, status(http.Post(ts.URL+"/api/flows/diff", "application/json",
+		strings.NewReader(`{"old": "{}", "new": "flow \"f\" {}"}`))))
+}
+
+func TestValidateSource(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "flows.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	ts := httptest.NewServer(server.New(st, log))
+	defer ts.Close()
+
+	validate := func(src string) api.ValidateResult {
+		body, err := json.Marshal(api.ValidateRequest{Source: src})
+		require.NoError(t, err)
+		resp, err := http.Post(ts.URL+"/api/flows/validate", "application/json", strings.NewReader(string(body)))
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var result api.ValidateResult
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return result
+	}
+
+	result := validate(`flow "orders" { node "read" { type: "Passthrough" } }`)
+	require.True(t, result.Valid)
+	require.Empty(t, result.Diagnostics)
+
+	// Syntax errors are located in the source
+	result = validate("flow \"orders\" {\n  node \"read\" {\n")
+	require.False(t, result.Valid)
+	require.NotEmpty(t, result.Diagnostics)
+	require.Equal(t, "error", result.Diagnostics[0].Severity)
+	require.Positive(t, result.Diagnostics[0].Line)
+
+	resp, err := http.Post(ts.URL+"/api/flows/validate", "application/json", strings.NewReader("not json"))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestDryRun(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "flows.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	ts := httptest.NewServer(server.New(st, log))
+	defer ts.Close()
+
+	run := func(req api.DryRunRequest) *http.Response {
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+		resp, err := http.Post(ts.URL+"/api/flows/run", "application/json", strings.NewReader(string(body)))
+		require.NoError(t, err)
+		return resp
+	}
+
+	src := `flow "orders" {
+		node "paid" { type: "Filter" field: "status" equals: "paid" }
+		node "tag" { type: "Transform" set: { stage: "billing" } remove: ["card"] }
+	}`
+	resp := run(api.DryRunRequest{
+		Source: src,
+		Messages: []json.RawMessage{
+			json.RawMessage(`{"status":"paid","card":"4242"}`),
+			json.RawMessage(`{"status":"open"}`),
+		},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var result api.DryRunResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	require.NoError(t, resp.Body.Close())
+	require.False(t, result.Failed)
+	require.Len(t, result.Outputs, 1)
+	require.Equal(t, "tag", result.Outputs[0].Node)
+	require.Equal(t, "1", result.Outputs[0].ID)
+	require.JSONEq(t, `{"status":"paid","stage":"billing"}`, string(result.Outputs[0].Data))
+	require.NotEmpty(t, result.Events)
+
+	// Unknown node types are rejected unless stubbed
+	unknown := `flow "orders" { node "send" { type: "Mailer" } }`
+	resp = run(api.DryRunRequest{Source: unknown, Messages: []json.RawMessage{json.RawMessage(`1`)}})
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	resp = run(api.DryRunRequest{Source: unknown, Messages: []json.RawMessage{json.RawMessage(`1`)}, Stub: true})
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	require.NoError(t, resp.Body.Close())
+	require.Len(t, result.Outputs, 1)
+
+	// Invalid sources and oversized runs are rejected
+	resp = run(api.DryRunRequest{Source: `flow "orders" {`})
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	resp = run(api.DryRunRequest{Source: src, Messages: make([]json.RawMessage, 101)})
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// The limits are configurable
+	limited := httptest.NewServer(server.New(st, log, server.WithLimits(server.Limits{DryRunMessages: 1})))
+	defer limited.Close()
+	body, err := json.Marshal(api.DryRunRequest{Source: src, Messages: make([]json.RawMessage, 2)})
+	require.NoError(t, err)
+	resp, err = http.Post(limited.URL+"/api/flows/run", "application/json", strings.NewReader(string(body)))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Enrich nodes only reach the endpoints the server allows
+	for _, settings := range []string{
+		`source: "http" url: "http://169.254.169.254/latest/{key}"`,
+		`source: "sql" database: "flows" query: "SELECT * FROM flows WHERE id = ?"`,
+	} {
+		resp = run(api.DryRunRequest{
+			Source:   `flow "f" { node "e" { type: "Enrich" key: "id" into: "found" ` + settings + ` } }`,
+			Messages: []json.RawMessage{json.RawMessage(`{"id": 1}`)},
+		})
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode, settings)
+	}
+}
+
+func TestDryRunFaults(t *testing.T) {
+	ctx := context.Background()
+	src := `flow "orders" { node "read" { type: "Passthrough" } }`
+	messages := []json.RawMessage{json.RawMessage(`1`), json.RawMessage(`2`)}
+
+	// Servers without fault injection reject faults
+	h := testsupport.New(t)
+	_, err := h.Client.DryRun(ctx, api.DryRunRequest{Source: src, Messages: messages, Faults: &engine.Faults{ErrorRate: 1}})
+	require.Equal(t, http.StatusForbidden, testsupport.StatusCode(err))
+
+	// Configured faults hit their flow
+	h = testsupport.New(t, testsupport.WithServerOptions(
+		server.WithFaults(map[string]engine.Faults{"orders": {DropRate: 1}}),
+	))
+	result, err := h.Client.DryRun(ctx, api.DryRunRequest{Source: src, Messages: messages})
+	require.NoError(t, err)
+	require.Empty(t, result.Outputs)
+	var injected int
+	for _, event := range result.Events {
+		if event.Type == engine.TypeFaultInjected {
+			injected++
+		}
+	}
+	require.Equal(t, 2, injected)
+
+	// Requests replace them with their own
+	result, err = h.Client.DryRun(ctx, api.DryRunRequest{Source: src, Messages: messages, Faults: &engine.Faults{ErrorRate: 1}})
+	require.NoError(t, err)
+	require.True(t, result.Failed)
+	_, err = h.Client.DryRun(ctx, api.DryRunRequest{Source: src, Messages: messages, Faults: &engine.Faults{ErrorRate: 2}})
+	require.Equal(t, http.StatusBadRequest, testsupport.StatusCode(err))
+}
+
+func TestRunLogs(t *testing.T) {
+	ctx := context.Background()
+	h := testsupport.New(t, testsupport.WithFlows(
+		&types.RuntimeFlow{ID: "billing", Name: "Billing", Config: `flow "billing" {
+			node "bill" { type: "Script" source: "log('billing %d' % msg['id'])" }
+		}`},
+	))
+
+	// Dry runs return what their nodes and the flows they call logged
+	src := `flow "orders" {
+		node "check" {
+			type: "Script"
+			source: "
+				log('checking %d' % msg['id'])
+				if msg['id'] == 2:
+					fail('bad order')
+			"
+		}
+		node "bill" { type: "Call" target: "billing" }
+	}`
+	result, err := h.Client.DryRun(ctx, api.DryRunRequest{
+		Source:   src,
+		Messages: []json.RawMessage{json.RawMessage(`{"id":1}`), json.RawMessage(`{"id":2}`)},
+	})
+	require.NoError(t, err)
+	require.True(t, result.Failed)
+	require.NotEmpty(t, result.RunID)
+	var lines []string
+	for _, entry := range result.Logs {
+		require.Equal(t, result.RunID, entry.RunID)
+		lines = append(lines, entry.FlowID+"/"+entry.NodeID+": "+entry.Message)
+	}
+	require.Equal(t, []string{
+		"orders/check: checking 1",
+		"billing/bill: billing 1",
+		"orders/check: checking 2",
+	}, lines)
+
+	// They are also served per run, even when the run failed
+	logs, err := h.Client.RunLogs(ctx, result.RunID, "")
+	require.NoError(t, err)
+	require.Len(t, logs, 3)
+	require.Equal(t, "1", logs[0].Fields["message_id"])
+	logs, err = h.Client.RunLogs(ctx, result.RunID, "flow=billing")
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	logs, err = h.Client.RunLogs(ctx, result.RunID, "level=warn")
+	require.NoError(t, err)
+	require.Empty(t, logs)
+	logs, err = h.Client.RunLogs(ctx, "missing", "")
+	require.NoError(t, err)
+	require.Empty(t, logs)
+
+	_, err = h.Client.RunLogs(ctx, result.RunID, "level=loud")
+	require.Equal(t, http.StatusBadRequest, testsupport.StatusCode(err))
+}
+
+func TestRunTimeline(t *testing.T) {
+	ctx := context.Background()
+	h := testsupport.New(t)
+
+	src := `flow "orders" {
+		node "price" { type: "Script" source: "msg['total'] = msg['qty'] * 2" }
+		node "ship" { type: "Passthrough" }
+	}`
+	messages := []json.RawMessage{json.RawMessage(`{"qty":1}`), json.RawMessage(`{"qty":2}`)}
+
+	// Runs record their timeline only when asked to
+	result, err := h.Client.DryRun(ctx, api.DryRunRequest{Source: src, Messages: messages})
+	require.NoError(t, err)
+	hops, err := h.Client.RunTimeline(ctx, result.RunID, "")
+	require.NoError(t, err)
+	require.Empty(t, hops)
+
+	result, err = h.Client.DryRun(ctx, api.DryRunRequest{Source: src, Messages: messages, Timeline: true})
+	require.NoError(t, err)
+	hops, err = h.Client.RunTimeline(ctx, result.RunID, "")
+	require.NoError(t, err)
+	var steps []string
+	for _, hop := range hops {
+		steps = append(steps, hop.NodeID+": "+hop.Input.ID)
+	}
+	require.Equal(t, []string{"price: 1", "ship: 1", "price: 2", "ship: 2"}, steps)
+	require.JSONEq(t, `{"qty":2}`, string(hops[2].Input.Data))
+	require.JSONEq(t, `{"qty":2,"total":4}`, string(hops[2].Outputs[0].Data))
+
+	hops, err = h.Client.RunTimeline(ctx, result.RunID, "node=ship")
+	require.NoError(t, err)
+	require.Len(t, hops, 2)
+	hops, err = h.Client.RunTimeline(ctx, result.RunID, "message=2&limit=1")
+	require.NoError(t, err)
+	require.Len(t, hops, 1)
+	require.Equal(t, "price", hops[0].NodeID)
+
+	_, err = h.Client.RunTimeline(ctx, result.RunID, "limit=many")
+	require.Equal(t, http.StatusBadRequest, testsupport.StatusCode(err))
+}
+
+func TestBackfill(t *testing.T) {
+	ctx := context.Background()
+	h := testsupport.New(t, testsupport.WithFlows(
+		&types.RuntimeFlow{ID: "orders", Name: "Orders", Config: `flow "orders" {
+			node "check" {
+				type: "Script"
+				source: "
+					log('order %d' % msg['n'])
+					if msg['n'] == 2:
+						fail('bad order')
+				"
+			}
+		}`},
+	))
+	path := filepath.Join(h.DataDir, "orders.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n"), 0o644))
+
+	// Backfills run in the background until all of their input went through
+	run, err := h.Client.StartBackfill(ctx, "orders", api.BackfillRequest{Input: backfill.Input{Files: []string{filepath.Base(path)}}})
+	require.NoError(t, err)
+	require.NotEmpty(t, run.ID)
+	run = waitBackfill(t, h, run.ID)
+	require.Equal(t, types.FlowStatusCompleted, run.Status)
+	require.Equal(t, backfill.Progress{Done: 24, Total: 24, Percent: 100, Messages: 3}, run.Progress)
+	require.Equal(t, 1, run.NodeFailures)
+	require.NotNil(t, run.FinishedAt)
+	flow, err := h.Client.GetFlow(ctx, "orders")
+	require.NoError(t, err)
+	require.Equal(t, types.FlowStatusCompleted, flow.Status)
+	logs, err := h.Client.RunLogs(ctx, run.ID, "")
+	require.NoError(t, err)
+	require.Len(t, logs, 3)
+
+	// Input that cannot be read fails the run and the flow
+	run, err = h.Client.StartBackfill(ctx, "orders", api.BackfillRequest{Input: backfill.Input{Files: []string{"orders.jsonl.missing"}}})
+	require.NoError(t, err)
+	run = waitBackfill(t, h, run.ID)
+	require.Equal(t, types.FlowStatusFailed, run.Status)
+	require.Contains(t, run.Error, "failed to read input file")
+	flow, err = h.Client.GetFlow(ctx, "orders")
+	require.NoError(t, err)
+	require.Equal(t, types.FlowStatusFailed, flow.Status)
+
+	// Stopping the flow stops its backfill, and one backfill runs at a time
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	long := api.BackfillRequest{Input: backfill.Input{Range: &backfill.Range{From: from, To: from.AddDate(1, 0, 0), StepSeconds: 1}}}
+	run, err = h.Client.StartBackfill(ctx, "orders", long)
+	require.NoError(t, err)
+	_, err = h.Client.StartBackfill(ctx, "orders", long)
+	require.Equal(t, http.StatusConflict, testsupport.StatusCode(err))
+	_, err = h.Client.StopFlow(ctx, "orders")
+	require.NoError(t, err)
+	run = waitBackfill(t, h, run.ID)
+	require.Equal(t, types.FlowStatusStopped, run.Status)
+	require.Less(t, run.Progress.Percent, 100.0)
+	flow, err = h.Client.GetFlow(ctx, "orders")
+	require.NoError(t, err)
+	require.Equal(t, types.FlowStatusStopped, flow.Status)
+
+	var runs []api.Backfill
+	require.NoError(t, h.Client.Do(ctx, http.MethodGet, "/api/flows/orders/backfills", nil, &runs))
+	require.Len(t, runs, 3)
+	require.Equal(t, run.ID, runs[0].ID)
+
+	_, err = h.Client.StartBackfill(ctx, "orders", api.BackfillRequest{})
+	require.Equal(t, http.StatusBadRequest, testsupport.StatusCode(err))
+
+	// Input is limited to the data directory and the configured databases
+	for _, escaping := range []string{filepath.Join(h.Dir, "flows.db"), "../flows.db"} {
+		_, err = h.Client.StartBackfill(ctx, "orders", api.BackfillRequest{Input: backfill.Input{Files: []string{escaping}}})
+		require.Equal(t, http.StatusBadRequest, testsupport.StatusCode(err), escaping)
+	}
+	run, err = h.Client.StartBackfill(ctx, "orders", api.BackfillRequest{Input: backfill.Input{Query: &backfill.Query{Database: "flows", SQL: "SELECT * FROM flows"}}})
+	require.NoError(t, err)
+	run = waitBackfill(t, h, run.ID)
+	require.Equal(t, types.FlowStatusFailed, run.Status)
+	require.Contains(t, run.Error, "input not allowed")
+
+	_, err = h.Client.StartBackfill(ctx, "missing", api.BackfillRequest{Input: backfill.Input{Files: []string{filepath.Base(path)}}})
+	require.Equal(t, http.StatusNotFound, testsupport.StatusCode(err))
+	_, err = h.Client.Backfill(ctx, "orders", "missing")
+	require.Equal(t, http.StatusNotFound, testsupport.StatusCode(err))
+}
+
+func TestPauseNode(t *testing.T) {
+	ctx := context.Background()
+	h := testsupport.New(t, testsupport.WithFlows(
+		&types.RuntimeFlow{ID: "orders", Name: "Orders", Config: `flow "orders" {
+			node "read" { type: "Passthrough" }
+			node "bill" { type: "Transform" set: { billed: 1 } }
+		}`},
+	))
+	pause := func(node, action string) (engine.NodeStatus, error) {
+		var status engine.NodeStatus
+		err := h.Client.Do(ctx, http.MethodPost, "/api/flows/orders/nodes/"+node+"/"+action, nil, &status)
+		return status, err
+	}
+
+	// Only flows running on the server have nodes to pause
+	_, err := pause("bill", "pause")
+	require.Equal(t, http.StatusConflict, testsupport.StatusCode(err))
+
+	// Paused nodes of running backfills buffer their input
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	run, err := h.Client.StartBackfill(ctx, "orders", api.BackfillRequest{
+		Input: backfill.Input{Range: &backfill.Range{From: from, To: from.AddDate(1, 0, 0), StepSeconds: 1}},
+	})
+	require.NoError(t, err)
+	status, err := pause("bill", "pause")
+	require.NoError(t, err)
+	require.Equal(t, types.ResourceStateThrottled, status.State)
+	_, err = pause("missing", "pause")
+	require.Equal(t, http.StatusNotFound, testsupport.StatusCode(err))
+
+	var statuses []engine.NodeStatus
+	require.Eventually(t, func() bool {
+		require.NoError(t, h.Client.Do(ctx, http.MethodGet, "/api/flows/orders/nodes", nil, &statuses))
+		return statuses[1].Buffered > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, types.ResourceStateRunning, statuses[0].State)
+	require.Equal(t, types.ResourceStateThrottled, statuses[1].State)
+
+	// Resuming passes the buffered messages on
+	status, err = pause("bill", "resume")
+	require.NoError(t, err)
+	require.Equal(t, engine.NodeStatus{ID: "bill", State: types.ResourceStateRunning}, status)
+
+	_, err = h.Client.StopFlow(ctx, "orders")
+	require.NoError(t, err)
+	run = waitBackfill(t, h, run.ID)
+	require.Equal(t, types.FlowStatusStopped, run.Status)
+	require.Equal(t, 0, run.NodeFailures)
+}
+
+func TestApprovals(t *testing.T) {
+	ctx := context.Background()
+	h := testsupport.New(t, testsupport.WithFlows(
+		&types.RuntimeFlow{ID: "orders", Name: "Orders", Config: `flow "orders" {
+			node "approve" { type: "Approval" title: "Ship order" }
+			node "ship" { type: "Passthrough" }
+			node "cancel" { type: "Passthrough" from: "approve.rejected" }
+		}`},
+	))
+	path := filepath.Join(h.DataDir, "orders.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"n\":1}\n{\"n\":2}\n"), 0o644))
+	decide := func(id, action string, req server.DecisionRequest) (engine.Approval, error) {
+		var approval engine.Approval
+		err := h.Client.Do(ctx, http.MethodPost, "/api/approvals/"+id+"/"+action, req, &approval)
+		return approval, err
+	}
+
+	// Backfills run until every message held for approval is decided
+	run, err := h.Client.StartBackfill(ctx, "orders", api.BackfillRequest{Input: backfill.Input{Files: []string{filepath.Base(path)}}})
+	require.NoError(t, err)
+	var pending []engine.Approval
+	require.Eventually(t, func() bool {
+		require.NoError(t, h.Client.Do(ctx, http.MethodGet, "/api/approvals?flow=orders", nil, &pending))
+		return len(pending) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "Ship order", pending[0].Title)
+	require.Equal(t, run.ID, pending[0].RunID)
+
+	_, err = decide(pending[0].ID, "approve", server.DecisionRequest{})
+	require.Equal(t, http.StatusBadRequest, testsupport.StatusCode(err))
+	approval, err := decide(pending[0].ID, "approve", server.DecisionRequest{By: "alice", Comment: "paid"})
+	require.NoError(t, err)
+	require.Equal(t, engine.ApprovalApproved, approval.Status)
+	_, err = decide(pending[0].ID, "reject", server.DecisionRequest{By: "bob"})
+	require.Equal(t, http.StatusNotFound, testsupport.StatusCode(err))
+	require.Equal(t, types.FlowStatusRunning, waitApprovalPending(t, h, run.ID))
+
+	_, err = decide(pending[1].ID, "reject", server.DecisionRequest{By: "bob"})
+	require.NoError(t, err)
+	run = waitBackfill(t, h, run.ID)
+	require.Equal(t, types.FlowStatusCompleted, run.Status)
+	require.Equal(t, 0, run.NodeFailures)
+
+	// Who decided what is kept in the audit trail of the approval
+	var records []types.AuditRecord
+	require.NoError(t, h.Client.Do(ctx, http.MethodGet, "/api/approvals/"+pending[0].ID+"/audit", nil, &records))
+	require.Len(t, records, 2)
+	require.Equal(t, store.AuditActionRequest, records[0].Action)
+	require.Equal(t, store.AuditActionApprove, records[1].Action)
+	require.Equal(t, "alice", records[1].Details["by"])
+	require.Equal(t, "paid", records[1].Details["comment"])
+	require.Equal(t, "orders", records[1].Details["flow_id"])
+	err = h.Client.Do(ctx, http.MethodGet, "/api/approvals/missing/audit", nil, &records)
+	require.Equal(t, http.StatusNotFound, testsupport.StatusCode(err))
+}
+
+func TestRestartDrain(t *testing.T) {
+	ctx := context.Background()
+	h := testsupport.New(t, testsupport.WithFlows(
+		&types.RuntimeFlow{ID: "orders", Name: "Orders", Config: `flow "orders" {
+			node "approve" { type: "Approval" title: "Ship order" }
+			node "ship" { type: "Passthrough" }
+		}`},
+	))
+	path := filepath.Join(h.DataDir, "orders.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"n\":1}\n"), 0o644))
+	req := api.BackfillRequest{Input: backfill.Input{Files: []string{filepath.Base(path)}}}
+	run, err := h.Client.StartBackfill(ctx, "orders", req)
+	require.NoError(t, err)
+	var pending []engine.Approval
+	require.Eventually(t, func() bool {
+		require.NoError(t, h.Client.Do(ctx, http.MethodGet, "/api/approvals?flow=orders", nil, &pending))
+		return len(pending) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// A restart while the backfill waits for its approval is abandoned once
+	// the drain times out, keeping the backfill and its approval
+	drainCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, h.Server.Drain(drainCtx), context.DeadlineExceeded)
+	run, err = h.Client.Backfill(ctx, "orders", run.ID)
+	require.NoError(t, err)
+	require.Equal(t, types.FlowStatusRunning, run.Status)
+	require.NoError(t, h.Client.Do(ctx, http.MethodGet, "/api/approvals?flow=orders", nil, &pending))
+	require.Len(t, pending, 1)
+
+	// While draining, new runs are refused and held messages can still be
+	// decided, which lets the backfill end and the restart go ahead
+	drained := make(chan error, 1)
+	go func() { drained <- h.Server.Drain(ctx) }()
+	require.Eventually(t, func() bool {
+		_, err := h.Client.StartBackfill(ctx, "orders", req)
+		return testsupport.StatusCode(err) == http.StatusServiceUnavailable
+	}, 5*time.Second, 10*time.Millisecond)
+	var approval engine.Approval
+	require.NoError(t, h.Client.Do(ctx, http.MethodPost, "/api/approvals/"+pending[0].ID+"/approve", server.DecisionRequest{By: "alice"}, &approval))
+	require.NoError(t, <-drained)
+	run, err = h.Client.Backfill(ctx, "orders", run.ID)
+	require.NoError(t, err)
+	require.Equal(t, types.FlowStatusCompleted, run.Status)
+
+	// The checkpoint taken once drained has nothing left to lose
+	flow, err := h.Store.GetFlow("orders")
+	require.NoError(t, err)
+	require.Equal(t, types.FlowStatusCompleted, flow.Status)
+	cp, err := restart.Take(h.Store)
+	require.NoError(t, err)
+	require.Empty(t, cp.Flows)
+}
+
+func TestBreakpoints(t *testing.T) {
+	ctx := context.Background()
+	h := testsupport.New(t, testsupport.WithFlows(
+		&types.RuntimeFlow{ID: "orders", Name: "Orders", Config: `flow "orders" {
+			node "price" { type: "Script" source: "msg['total'] = msg['n'] * 2" }
+			node "ship" { type: "Passthrough" }
+		}`},
+	))
+	path := filepath.Join(h.DataDir, "orders.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{\"n\":1}\n"), 0o644))
+	release := func(id, action string) (server.StepResult, error) {
+		var result server.StepResult
+		err := h.Client.Do(ctx, http.MethodPost, "/api/breaks/"+id+"/"+action, nil, &result)
+		return result, err
+	}
+
+	// Backfills started in debug mode stop messages before breakpoints
+	_, err := h.Client.StartBackfill(ctx, "orders", api.BackfillRequest{
+		Input:       backfill.Input{Files: []string{filepath.Base(path)}},
+		Breakpoints: []string{"missing"},
+	})
+	require.Equal(t, http.StatusBadRequest, testsupport.StatusCode(err))
+	run, err := h.Client.StartBackfill(ctx, "orders", api.BackfillRequest{
+		Input:       backfill.Input{Files: []string{filepath.Base(path)}},
+		Breakpoints: []string{"price"},
+	})
+	require.NoError(t, err)
+	var breaks []engine.Break
+	require.Eventually(t, func() bool {
+		require.NoError(t, h.Client.Do(ctx, http.MethodGet, "/api/breaks?flow=orders", nil, &breaks))
+		return len(breaks) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "price", breaks[0].NodeID)
+	require.Equal(t, run.ID, breaks[0].RunID)
+
+	var breakpoints []string
+	require.NoError(t, h.Client.Do(ctx, http.MethodPut, "/api/flows/orders/breakpoints/ship", nil, &breakpoints))
+	require.Equal(t, []string{"price", "ship"}, breakpoints)
+	err = h.Client.Do(ctx, http.MethodPut, "/api/flows/orders/breakpoints/missing", nil, &breakpoints)
+	require.Equal(t, http.StatusNotFound, testsupport.StatusCode(err))
+
+	// Stopped messages are inspected, edited and stepped
+	var stopped engine.Break
+	require.NoError(t, h.Client.Do(ctx, http.MethodPut, "/api/breaks/"+breaks[0].ID, engine.BreakEdit{Data: json.RawMessage(`{"n":4}`)}, &stopped))
+	require.True(t, stopped.Edited)
+	require.NoError(t, h.Client.Do(ctx, http.MethodGet, "/api/breaks/"+breaks[0].ID, nil, &stopped))
+	require.JSONEq(t, `{"n":4}`, string(stopped.Message.Data))
+	result, err := release(breaks[0].ID, "step")
+	require.NoError(t, err)
+	require.Len(t, result.Breaks, 1)
+	require.Equal(t, "ship", result.Breaks[0].NodeID)
+	require.JSONEq(t, `{"n":4,"total":8}`, string(result.Breaks[0].Message.Data))
+	_, err = release(breaks[0].ID, "step")
+	require.Equal(t, http.StatusNotFound, testsupport.StatusCode(err))
+	run, err = h.Client.Backfill(ctx, "orders", run.ID)
+	require.NoError(t, err)
+	require.Equal(t, types.FlowStatusRunning, run.Status)
+
+	// Continuing lets the backfill complete
+	require.NoError(t, h.Client.Do(ctx, http.MethodDelete, "/api/flows/orders/breakpoints/price", nil, &breakpoints))
+	require.Equal(t, []string{"ship"}, breakpoints)
+	result, err = release(result.Breaks[0].ID, "continue")
+	require.NoError(t, err)
+	require.Empty(t, result.Breaks)
+	run = waitBackfill(t, h, run.ID)
+	require.Equal(t, types.FlowStatusCompleted, run.Status)
+}
+
+func TestBackfillConcurrency(t *testing.T) {
+	ctx := context.Background()
+	flow := func(id, concurrency string) *types.RuntimeFlow {
+		return &types.RuntimeFlow{ID: id, Name: id, Config: `flow "` + id + `" {
+			concurrency: ` + concurrency + `
+			node "approve" { type: "Approval" }
+		}`}
+	}
+	h := testsupport.New(t, testsupport.WithFlows(
+		flow("queued", `{ overflow: "queue" queue_limit: 1 }`),
+		flow("coalesced", `{ overflow: "coalesce" }`),
+	))
+	path := filepath.Join(h.DataDir, "orders.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("{}\n"), 0o644))
+	req := api.BackfillRequest{Input: backfill.Input{Files: []string{filepath.Base(path)}}}
+	// approve approves the approvals of the flow once there are n
+	approve := func(flowID string, n int) {
+		var pending []engine.Approval
+		require.Eventually(t, func() bool {
+			require.NoError(t, h.Client.Do(ctx, http.MethodGet, "/api/approvals?flow="+flowID, nil, &pending))
+			return len(pending) == n
+		}, 5*time.Second, 10*time.Millisecond)
+		for _, approval := range pending {
+			require.NoError(t, h.Client.Do(ctx, http.MethodPost, "/api/approvals/"+approval.ID+"/approve", server.DecisionRequest{By: "alice"}, nil))
+		}
+	}
+	wait := func(flowID, runID string) *api.Backfill {
+		var run *api.Backfill
+		require.Eventually(t, func() bool {
+			var err error
+			run, err = h.Client.Backfill(ctx, flowID, runID)
+			require.NoError(t, err)
+			return run.Status != types.FlowStatusRunning && run.Status != api.BackfillQueued
+		}, 5*time.Second, 10*time.Millisecond)
+		return run
+	}
+
+	// Backfills beyond the limit wait in order, up to the queue limit
+	first, err := h.Client.StartBackfill(ctx, "queued", req)
+	require.NoError(t, err)
+	require.Equal(t, types.FlowStatusRunning, first.Status)
+	second, err := h.Client.StartBackfill(ctx, "queued", req)
+	require.NoError(t, err)
+	require.Equal(t, api.BackfillQueued, second.Status)
+	_, err = h.Client.StartBackfill(ctx, "queued", req)
+	require.Equal(t, http.StatusConflict, testsupport.StatusCode(err))
+
+	approve("queued", 1)
+	require.Equal(t, types.FlowStatusCompleted, wait("queued", first.ID).Status)
+	second, err = h.Client.Backfill(ctx, "queued", second.ID)
+	require.NoError(t, err)
+	require.Equal(t, types.FlowStatusRunning, second.Status)
+	stored, err := h.Client.GetFlow(ctx, "queued")
+	require.NoError(t, err)
+	require.Equal(t, types.FlowStatusRunning, stored.Status)
+	approve("queued", 1)
+	require.Equal(t, types.FlowStatusCompleted, wait("queued", second.ID).Status)
+	stored, err = h.Client.GetFlow(ctx, "queued")
+	require.NoError(t, err)
+	require.Equal(t, types.FlowStatusCompleted, stored.Status)
+
+	// Coalesced backfills merge into the one queued
+	first, err = h.Client.StartBackfill(ctx, "coalesced", req)
+	require.NoError(t, err)
+	second, err = h.Client.StartBackfill(ctx, "coalesced", req)
+	require.NoError(t, err)
+	third, err := h.Client.StartBackfill(ctx, "coalesced", req)
+	require.NoError(t, err)
+	require.Equal(t, second.ID, third.ID)
+	require.Equal(t, 1, third.Coalesced)
+
+	// Stopping the flow drops its queued backfills
+	_, err = h.Client.StopFlow(ctx, "coalesced")
+	require.NoError(t, err)
+	require.Equal(t, types.FlowStatusStopped, wait("coalesced", first.ID).Status)
+	require.Equal(t, types.FlowStatusStopped, wait("coalesced", second.ID).Status)
+}
+
+// waitApprovalPending returns the status of the backfill runID of the
+// orders flow once it has one approval left
+func waitApprovalPending(t *testing.T, h *testsupport.Harness, runID string) string {
+	t.Helper()
+	var pending []engine.Approval
+	require.Eventually(t, func() bool {
+		require.NoError(t, h.Client.Do(context.Background(), http.MethodGet, "/api/approvals", nil, &pending))
+		return len(pending) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	run, err := h.Client.Backfill(context.Background(), "orders", runID)
+	require.NoError(t, err)
+	return run.Status
+}
+
+// waitBackfill waits for the backfill runID of the orders flow to finish
+func waitBackfill(t *testing.T, h *testsupport.Harness, runID string) *api.Backfill {
+	t.Helper()
+	return waitBackfillOf(t, h, "orders", runID)
+}
+
+// waitBackfillOf waits for the backfill runID of the flow with id to finish
+func waitBackfillOf(t *testing.T, h *testsupport.Harness, id, runID string) *api.Backfill {
+	t.Helper()
+	var run *api.Backfill
+	require.Eventually(t, func() bool {
+		var err error
+		run, err = h.Client.Backfill(context.Background(), id, runID)
+		require.NoError(t, err)
+		return run.Status != types.FlowStatusRunning
+	}, 5*time.Second, 10*time.Millisecond)
+	return run
+}
+
+func TestProjectQuotas(t *testing.T) {
+	ctx := context.Background()
+	h := testsupport.New(t,
+		testsupport.WithFlows(
+			&types.RuntimeFlow{ID: "invoices", Name: "Invoices", Config: `flow "invoices" {
+				node "approve" { type: "Approval" }
+			}`},
+			&types.RuntimeFlow{ID: "refunds", Name: "Refunds", Config: `flow "refunds" {
+				node "note" { type: "Script" source: "log('refund %d' % msg['n'])" }
+			}`},
+			&types.RuntimeFlow{ID: "reports", Name: "Reports", Config: `flow "reports" {
+				node "read" { type: "Passthrough" }
+			}`},
+			&types.RuntimeFlow{ID: "inbox", Name: "Inbox", Config: `flow "inbox" { node "read" { type: "Passthrough" } }`},
+			&types.RuntimeFlow{ID: "audit", Name: "Audit", Config: `flow "audit" { node "read" { type: "Passthrough" } }`},
+		),
+		testsupport.WithServerOptions(server.WithQuotas(map[string]server.Quota{
+			"billing":             {MaxRuns: 1, MaxStorageBytes: 1},
+			"analytics":           {MaxMessagesPerSecond: 5},
+			config.DefaultProject: {MaxRuns: 1},
+		})),
+	)
+	for id, project := range map[string]string{"invoices": "billing", "refunds": "billing", "reports": "analytics", "audit": "unknown"} {
+		require.NoError(t, h.Client.Do(ctx, http.MethodPut, "/api/flows/"+id+"/tags", []string{server.ProjectTag + project}, nil))
+	}
+	exceeded := h.Bus.Subscribe(events.Filter{Types: []string{events.TypeQuotaExceeded}})
+	path := filepath.Join(h.DataDir, "input.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Repeat("{\"n\":1}\n", 7)), 0o644))
+	input := backfill.Input{Files: []string{filepath.Base(path)}}
+
+	// Projects run at most as many backfills at once as their quota allows,
+	// across their flows
+	held, err := h.Client.StartBackfill(ctx, "invoices", api.BackfillRequest{Input: input})
+	require.NoError(t, err)
+	require.Equal(t, "billing", held.Project)
+	_, err = h.Client.StartBackfill(ctx, "refunds", api.BackfillRequest{Input: input})
+	require.Equal(t, http.StatusTooManyRequests, testsupport.StatusCode(err))
+	event := <-exceeded.Events()
+	require.Equal(t, "refunds", event.FlowID)
+	require.Equal(t, server.QuotaRuns, event.Data["quota"])
+	require.Equal(t, "billing", event.Data["project"])
+
+	var usage []server.ProjectUsage
+	require.NoError(t, h.Client.Do(ctx, http.MethodGet, "/api/quotas", nil, &usage))
+	require.Len(t, usage, 3)
+	require.Equal(t, "analytics", usage[0].Project)
+	require.Equal(t, server.ProjectUsage{Project: "billing", Quota: server.Quota{MaxRuns: 1, MaxStorageBytes: 1}, Runs: 1}, usage[1])
+	require.Equal(t, config.DefaultProject, usage[2].Project)
+
+	// Started flows are runs as well
+	_, err = h.Client.StartFlow(ctx, "refunds", nil)
+	require.Equal(t, http.StatusTooManyRequests, testsupport.StatusCode(err))
+	event = <-exceeded.Events()
+	require.Equal(t, "refunds", event.FlowID)
+	require.Equal(t, server.QuotaRuns, event.Data["quota"])
+
+	_, err = h.Client.StopFlow(ctx, "invoices")
+	require.NoError(t, err)
+	waitBackfillOf(t, h, "invoices", held.ID)
+	_, err = h.Client.StartFlow(ctx, "refunds", nil)
+	require.NoError(t, err)
+	_, err = h.Client.StartBackfill(ctx, "invoices", api.BackfillRequest{Input: input})
+	require.Equal(t, http.StatusTooManyRequests, testsupport.StatusCode(err))
+	event = <-exceeded.Events()
+	require.Equal(t, "invoices", event.FlowID)
+	_, err = h.Client.StopFlow(ctx, "refunds")
+	require.NoError(t, err)
+
+	// Backfills are refused once the run data of the project is at its
+	// storage quota
+	run, err := h.Client.StartBackfill(ctx, "refunds", api.BackfillRequest{Input: input})
+	require.NoError(t, err)
+	waitBackfillOf(t, h, "refunds", run.ID)
+	_, err = h.Client.StartBackfill(ctx, "refunds", api.BackfillRequest{Input: input})
+	require.Equal(t, http.StatusTooManyRequests, testsupport.StatusCode(err))
+	event = <-exceeded.Events()
+	require.Equal(t, server.QuotaStorage, event.Data["quota"])
+
+	// The input of backfills is paced at the message rate of the project
+	started := time.Now()
+	run, err = h.Client.StartBackfill(ctx, "reports", api.BackfillRequest{Input: input})
+	require.NoError(t, err)
+	run = waitBackfillOf(t, h, "reports", run.ID)
+	require.Equal(t, types.FlowStatusCompleted, run.Status)
+	require.GreaterOrEqual(t, time.Since(started), 300*time.Millisecond)
+	event = <-exceeded.Events()
+	require.Equal(t, "reports", event.FlowID)
+	require.Equal(t, server.QuotaMessageRate, event.Data["quota"])
+
+	// Flows without a project, or of projects without a quota, share the
+	// default quota
+	_, err = h.Client.StartFlow(ctx, "inbox", nil)
+	require.NoError(t, err)
+	_, err = h.Client.StartFlow(ctx, "audit", nil)
+	require.Equal(t, http.StatusTooManyRequests, testsupport.StatusCode(err))
+	event = <-exceeded.Events()
+	require.Equal(t, "audit", event.FlowID)
+	require.Equal(t, config.DefaultProject, event.Data["project"])
+}
+
+func TestSimulation(t *testing.T) {
+	ctx := context.Background()
+	source := `flow "orders" {
+		node "gen" {
+			type: "Generate"
+			count: 5
+			seed: 1
+			definition: { type: "object" properties: { n: { type: "int" maximum: 9 } } required: ["n"] }
+		}
+		node "check" {
+			type: "Script"
+			source: "
+				if msg['n'] > 9:
+					fail('too big')
+			"
+		}
+	}`
+	dataDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "rows.csv"), []byte("n\n1\n2\n"), 0o644))
+	h := testsupport.New(t, testsupport.WithServerOptions(server.WithDataDir(dataDir)), testsupport.WithFlows(
+		&types.RuntimeFlow{ID: "orders", Name: "Orders", Config: source},
+		&types.RuntimeFlow{ID: "load", Name: "Load", Config: `flow "load" {
+			node "gen" { type: "Generate" count: 50 rate: 20 definition: { type: "int" } }
+		}`},
+		&types.RuntimeFlow{ID: "real", Name: "Real", Config: `flow "real" { node "read" { type: "Passthrough" } }`},
+	))
+	flow, err := h.Client.GetFlow(ctx, "orders")
+	require.NoError(t, err)
+	status := flow.Status
+
+	// Simulations run the sources of the flow and leave its status alone
+	run, err := h.Client.StartSimulation(ctx, "orders", api.SimulationRequest{Timeline: true})
+	require.NoError(t, err)
+	require.True(t, run.Simulation)
+	run = waitBackfill(t, h, run.ID)
+	require.Equal(t, types.FlowStatusCompleted, run.Status)
+	require.Equal(t, int64(5), run.Progress.Messages)
+	require.Zero(t, run.NodeFailures)
+	flow, err = h.Client.GetFlow(ctx, "orders")
+	require.NoError(t, err)
+	require.Equal(t, status, flow.Status)
+	hops, err := h.Client.RunTimeline(ctx, run.ID, "")
+	require.NoError(t, err)
+	require.Len(t, hops, 10)
+	require.Equal(t, "true", hops[0].Input.Headers[engine.HeaderSimulation])
+
+	// They do not take the place of production runs
+	simulation, err := h.Client.StartSimulation(ctx, "load", api.SimulationRequest{})
+	require.NoError(t, err)
+	path := filepath.Join(dataDir, "input.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte("1\n"), 0o644))
+	run, err = h.Client.StartBackfill(ctx, "load", api.BackfillRequest{Input: backfill.Input{Files: []string{filepath.Base(path)}}})
+	require.NoError(t, err)
+	require.Equal(t, types.FlowStatusCompleted, waitBackfillOf(t, h, "load", run.ID).Status)
+	_, err = h.Client.StopFlow(ctx, "load")
+	require.NoError(t, err)
+	require.Equal(t, types.FlowStatusStopped, waitBackfillOf(t, h, "load", simulation.ID).Status)
+
+	// Flows need sources to be simulated
+	_, err = h.Client.StartSimulation(ctx, "real", api.SimulationRequest{})
+	require.Equal(t, http.StatusUnprocessableEntity, testsupport.StatusCode(err))
+
+	// Dry runs simulate flow source too
+	var result api.DryRunResult
+	require.NoError(t, h.Client.Do(ctx, http.MethodPost, "/api/flows/run", api.DryRunRequest{Source: source, Simulate: true}, &result))
+	require.Len(t, result.Outputs, 5)
+	require.Equal(t, "gen-1", result.Outputs[0].ID)
+	err = h.Client.Do(ctx, http.MethodPost, "/api/flows/run", api.DryRunRequest{
+		Source:   source,
+		Simulate: true,
+		Messages: []json.RawMessage{json.RawMessage(`{}`)},
+	}, nil)
+	require.Equal(t, http.StatusBadRequest, testsupport.StatusCode(err))
+	err = h.Client.Do(ctx, http.MethodPost, "/api/flows/run", api.DryRunRequest{
+		Source:   `flow "f" { node "r" { type: "Replay" path: "rows.csv.missing" } }`,
+		Simulate: true,
+	}, nil)
+	require.Equal(t, http.StatusUnprocessableEntity, testsupport.StatusCode(err))
+
+	// Replay nodes only read the files of the data directory
+	result = api.DryRunResult{}
+	require.NoError(t, h.Client.Do(ctx, http.MethodPost, "/api/flows/run", api.DryRunRequest{
+		Source:   `flow "f" { node "r" { type: "Replay" path: "rows.csv" } }`,
+		Simulate: true,
+	}, &result))
+	require.Len(t, result.Outputs, 2)
+	outside := filepath.Join(h.Dir, "flows.db")
+	for _, escaping := range []string{outside, "../" + filepath.Base(h.Dir) + "/flows.db", "/etc/passwd"} {
+		err = h.Client.Do(ctx, http.MethodPost, "/api/flows/run", api.DryRunRequest{
+			Source:   `flow "f" { node "r" { type: "Replay" path: "` + escaping + `" } }`,
+			Simulate: true,
+		}, nil)
+		require.Equal(t, http.StatusUnprocessableEntity, testsupport.StatusCode(err), escaping)
+		require.ErrorContains(t, err, "outside the data directory")
+	}
+}
+
+func TestFlowCalls(t *testing.T) {
+	ctx := context.Background()
+	h := testsupport.New(t, testsupport.WithFlows(
+		&types.RuntimeFlow{ID: "billing", Name: "Billing", Config: `flow "billing" {
+			node "tag" { type: "Transform" set: { billed: 1 } }
+		}`},
+		&types.RuntimeFlow{ID: "legacy", Name: "Legacy", Config: "{}"},
+	))
+	orders := `flow "orders" {
+		node "bill" { type: "Call" target: "billing" }
+		node "audit" { type: "Call" target: "audit" mode: "async" }
+	}`
+	_, err := h.Client.CreateFlow(ctx, &types.RuntimeFlow{ID: "orders", Name: "Orders", Config: orders})
+	require.NoError(t, err)
+
+	// Dependencies are listed both ways, with calls of missing flows
+	var graph server.DependencyGraph
+	require.NoError(t, h.Client.Do(ctx, http.MethodGet, "/api/flows/dependencies", nil, &graph))
+	require.Equal(t, map[string][]string{
+		"billing": {},
+		"legacy":  {},
+		"orders":  {"audit", "billing"},
+	}, graph.Flows)
+	var deps server.FlowDependencies
+	require.NoError(t, h.Client.Do(ctx, http.MethodGet, "/api/flows/billing/dependencies", nil, &deps))
+	require.Equal(t, server.FlowDependencies{FlowID: "billing", Calls: []string{}, CalledBy: []string{"orders"}, Missing: []string{}}, deps)
+	require.NoError(t, h.Client.Do(ctx, http.MethodGet, "/api/flows/orders/dependencies", nil, &deps))
+	require.Equal(t, []string{"audit"}, deps.Missing)
+	err = h.Client.Do(ctx, http.MethodGet, "/api/flows/missing/dependencies", nil, &deps)
+	require.Equal(t, http.StatusNotFound, testsupport.StatusCode(err))
+
+	// Flows calling each other in a loop are rejected when saved and
+	// reported by validation
+	loop := `flow "billing" {
+		node "tag" { type: "Transform" set: { billed: 1 } }
+		node "again" { type: "Call" target: "orders" }
+	}`
+	billing, err := h.Client.GetFlow(ctx, "billing")
+	require.NoError(t, err)
+	billing.Config = loop
+	_, err = h.Client.UpdateFlow(ctx, billing)
+	require.Equal(t, http.StatusUnprocessableEntity, testsupport.StatusCode(err))
+	require.ErrorContains(t, err, "billing -> orders -> billing")
+	_, err = h.Client.CreateFlow(ctx, &types.RuntimeFlow{ID: "self", Name: "Self", Config: `flow "self" { node "c" { type: "Call" target: "self" } }`})
+	require.Equal(t, http.StatusUnprocessableEntity, testsupport.StatusCode(err))
+
+	var validated api.ValidateResult
+	require.NoError(t, h.Client.Do(ctx, http.MethodPost, "/api/flows/validate", api.ValidateRequest{Source: loop, ID: "billing"}, &validated))
+	require.False(t, validated.Valid)
+	require.Equal(t, []api.SourceDiagnostic{{
+		Line:     3,
+		Column:   4,
+		Severity: "error",
+		Message:  `flow "billing" calls itself: billing -> orders -> billing`,
+	}}, validated.Diagnostics)
+	result, err := h.Client.Validate(ctx, loop)
+	require.NoError(t, err)
+	require.True(t, result.Valid)
+
+	// Dry runs call stored flows
+	run, err := h.Client.DryRun(ctx, api.DryRunRequest{
+		Source:   `flow "checkout" { node "bill" { type: "Call" target: "billing" } }`,
+		Messages: []json.RawMessage{json.RawMessage(`{"id":1}`)},
+	})
+	require.NoError(t, err)
+	require.False(t, run.Failed)
+	require.Equal(t, []api.DryRunOutput{{Node: "bill", ID: "1", Data: json.RawMessage(`{"billed":1,"id":1}`)}}, run.Outputs)
+	var calledFlows []string
+	for _, event := range run.Events {
+		calledFlows = append(calledFlows, event.FlowID)
+	}
+	require.Contains(t, calledFlows, "billing")
+}
+
+func TestFlowParams(t *testing.T) {
+	ctx := context.Background()
+	source := `flow "poll" {
+		params: {
+			endpoint: { type: "string", default: "https://api.example.com" }
+			limit: { type: "number", default: 10 }
+			team: { type: "string" }
+		}
+		node "tag" { type: "Transform" set: { url: "${param.endpoint}/orders", limit: "${param.limit}", team: "${param.team}" } }
+	}`
+	h := testsupport.New(t, testsupport.WithFlows(&types.RuntimeFlow{ID: "poll", Name: "Poll", Config: source}))
+
+	// Starting records the values, and requires those without a default
+	_, err := h.Client.StartFlow(ctx, "poll", nil)
+	require.Equal(t, http.StatusBadRequest, testsupport.StatusCode(err))
+	_, err = h.Client.StartFlow(ctx, "poll", map[string]string{"team": "ops", "limit": "many"})
+	require.Equal(t, http.StatusBadRequest, testsupport.StatusCode(err))
+	started, err := h.Client.StartFlow(ctx, "poll", map[string]string{"team": "ops", "endpoint": "https://staging.example.com"})
+	require.NoError(t, err)
+	require.Equal(t, "running", started.Status)
+	require.Equal(t, map[string]string{"team": "ops", "endpoint": "https://staging.example.com"}, started.Params)
+
+	// Dry runs take values the same way
+	result, err := h.Client.DryRun(ctx, api.DryRunRequest{
+		Source:   source,
+		Params:   map[string]string{"team": "ops", "limit": "5"},
+		Messages: []json.RawMessage{json.RawMessage(`{}`)},
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"url":"https://api.example.com/orders","limit":5,"team":"ops"}`, string(result.Outputs[0].Data))
+
+	// References to undeclared parameters are reported by validation
+	validation, err := h.Client.Validate(ctx, `flow "f" { node "n" { type: "Passthrough" url: "${param.host}" } }`)
+	require.NoError(t, err)
+	require.False(t, validation.Valid)
+	require.Contains(t, validation.Diagnostics[0].Message, `undeclared parameter "host"`)
+}
+
+func TestFlowSecrets(t *testing.T) {
+	ctx := context.Background()
+	source := `flow "secret" { node "t" { type: "Transform" set: { token: "${secret.API_TOKEN}" } } }`
+	lookup := func(name string) (string, bool) {
+		if name == "SECRET_API_TOKEN" {
+			return "s3cret", true
+		}
+		return "", false
+	}
+	h := testsupport.New(t, testsupport.WithServerOptions(server.WithSecrets(secrets.NewEnvProvider("SECRET_", lookup))))
+
+	// Secret references in node settings are resolved by the server
+	result, err := h.Client.DryRun(ctx, api.DryRunRequest{
+		Source:   source,
+		Messages: []json.RawMessage{json.RawMessage(`{}`)},
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"token":"s3cret"}`, string(result.Outputs[0].Data))
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"flow-control/internal/runtime/diagram"
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/types"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// @Summary Export a flow graph
+// @Description Render the nodes and connections of a flow's config as a diagram
+// @Tags flows
+// @Produce plain
+// @Param id path string true "Flow ID"
+// @Param format query string false "Diagram format: svg (default), dot or mermaid"
+// @Param flow query string false "Flow to render when the config defines several"
+// @Success 200 {string} string "Diagram"
+// @Failure 400 {string} string "Unknown diagram format"
+// @Failure 404 {string} string "Flow not found"
+// @Failure 422 {string} string "Invalid flow config"
+// @Router /flows/{id}/graph [get]
+func (s *Server) handleFlowGraph(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function": "handleFlowGraph",
+		"flow_id":  id,
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = diagram.FormatSVG
+	}
+
+	flow, err := s.store.GetFlow(id)
+	if err != nil {
+		s.handleStoreError(w, r, err, "Failed to get flow", fields)
+		return
+	}
+
+	graph, err := engine.LoadSource(flow.Config, r.URL.Query().Get("flow"), s.requestLog(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	out, err := diagram.Render(graph, format)
+	if errors.Is(err, diagram.ErrUnknownFormat) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.requestLog(r).Error("Failed to render flow graph", err, fields)
+		http.Error(w, "Failed to render flow graph", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", diagram.ContentType(format))
+	if _, err := w.Write(out); err != nil {
+		s.requestLog(r).Error("Failed to write flow graph", err, fields)
+	}
+}
@@ -4,16 +4,13 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
-	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
 	"flow-control/internal/docserver"
-	"flow-control/internal/logger"
-	"flow-control/internal/server"
-	"flow-control/internal/store"
+	"flow-control/internal/testsupport"
 	"flow-control/internal/types"
 
 	"github.com/stretchr/testify/require"
@@ -61,15 +58,15 @@ func setupTestTemplates(t *testing.T) string {
 		"source_dir.html":      `{{define "content"}}<h1>{{.Title}}</h1>{{range .Files}}<div><a href="/docs/src/{{.Path}}/{{.Name}}">{{.Name}}</a></div>{{end}}{{end}}`,
 		"search.html":          `{{define "content"}}<h1>Search Results</h1>{{if .Query}}{{range .Results}}<div><a href="{{.URL}}">{{.Title}}</a></div>{{end}}{{end}}{{end}}`,
 		"package_content.html": `{{define "content"}}<h1>Package {{.Title}}</h1>{{range .Files}}<div><a href="/docs/src/{{.Name}}">{{.Name}}</a></div>{{end}}{{end}}`,
+		"guide.html":           `{{define "content"}}<h1>{{.Title}}</h1>{{range .Guides}}<div><a href="/docs/guide/{{.Path}}">{{.Title}}</a></div>{{end}}{{end}}`,
+		"playground.html":      `{{define "content"}}<h1>{{.Title}}</h1><textarea>{{.Example.Source}}</textarea>{{end}}`,
+		"language.html":        `{{define "content"}}<h1>{{.Title}}</h1>{{range .Reference.Sections}}<a href="#{{.Title}}">{{.Title}}</a>{{end}}{{end}}`,
 	}
 
-	// Create a temporary directory for test templates
-	tmpDir, err := os.MkdirTemp("", "docserver-test")
-	require.NoError(t, err)
-
 	// Create templates directory
+	tmpDir := t.TempDir()
 	templatesDir := filepath.Join(tmpDir, "internal", "docserver", "templates")
-	err = os.MkdirAll(templatesDir, 0o755)
+	err := os.MkdirAll(templatesDir, 0o755)
 	require.NoError(t, err)
 
 	// Create test templates
@@ -92,55 +89,20 @@ func setupTestTemplates(t *testing.T) string {
 }
 
 func TestLinks(t *testing.T) {
-	// Set up test templates
+	// Start a server with the test templates and a test flow for the API
+	// endpoints
 	tmpDir := setupTestTemplates(t)
-	defer func() {
-		if err := os.RemoveAll(tmpDir); err != nil {
-			t.Errorf("Failed to remove temp dir: %v", err)
-		}
-	}()
-
-	// Change to the temp directory for the test
-	originalWd, err := os.Getwd()
-	require.NoError(t, err)
-	err = os.Chdir(tmpDir)
-	require.NoError(t, err)
-	defer func() {
-		if err := os.Chdir(originalWd); err != nil {
-			t.Errorf("Failed to change back to original directory: %v", err)
-		}
-	}()
-
-	// Create test dependencies
-	log := logger.New()
-	st, err := store.New("test.db", log)
-	require.NoError(t, err)
-	defer func() { _ = st.Close() }()
-
-	// Create test server
-	srv := server.New(st, log)
-
-	// Mount documentation server
-	docs := docserver.New(log)
-	srv.Mount("/docs", docs)
-
-	ts := httptest.NewServer(srv)
-	defer ts.Close()
-
-	// Create a test flow for API endpoints
-	config := map[string]interface{}{
-		"retries": 3,
-	}
-	configJSON, err := json.Marshal(config)
-	require.NoError(t, err)
-
-	testFlow := &types.RuntimeFlow{
-		ID:     "test-flow",
-		Name:   "Test Flow",
-		Config: string(configJSON),
-	}
-	err = st.CreateFlow(testFlow)
-	require.NoError(t, err)
+	h := testsupport.New(t,
+		testsupport.WithDocs(
+			docserver.WithRootDir(tmpDir),
+			docserver.WithTemplateDir(filepath.Join(tmpDir, "internal", "docserver", "templates")),
+		),
+		testsupport.WithFlows(&types.RuntimeFlow{
+			ID:     "test-flow",
+			Name:   "Test Flow",
+			Config: `{"retries":3}`,
+		}),
+	)
 
 	// Define all pages to check
 	pages := []struct {
@@ -158,7 +120,7 @@ func TestLinks(t *testing.T) {
 	// Visit each page and check its links
 	for _, page := range pages {
 		t.Run(page.name, func(t *testing.T) {
-			resp, err := http.Get(ts.URL + page.path)
+			resp, err := http.Get(h.URL + page.path)
 			require.NoError(t, err)
 			defer func() { _ = resp.Body.Close() }()
 
@@ -185,7 +147,7 @@ func TestLinks(t *testing.T) {
 				}
 
 				t.Run(link, func(t *testing.T) {
-					linkResp, err := http.Get(ts.URL + link)
+					linkResp, err := http.Get(h.URL + link)
 					require.NoError(t, err)
 					defer func() { _ = linkResp.Body.Close() }()
 
@@ -207,7 +169,19 @@ func TestLinks(t *testing.T) {
 			{"List Flows", "GET", "/api/flows", ""},
 			{"Get Flow", "GET", "/api/flows/test-flow", ""},
 			{"Create Flow", "POST", "/api/flows", `{"id":"new-flow","name":"new-flow","config":"{\"retries\":3}"}`},
-			{"Update Flow", "PUT", "/api/flows/test-flow", `{"id":"test-flow","name":"updated-flow","config":"{\"retries\":5}"}`},
+			{"Update Flow", "PUT", "/api/flows/test-flow", `{"id":"test-flow","name":"updated-flow","config":"{\"retries\":5}","revision":1}`},
+			{"Set Flow Tags", "PUT", "/api/flows/test-flow/tags", `["prod","etl"]`},
+			{"Add Flow Tags", "POST", "/api/flows/test-flow/tags", `["nightly"]`},
+			{"Get Flow Tags", "GET", "/api/flows/test-flow/tags", ""},
+			{"List Flows By Tag", "GET", "/api/flows?tag=prod&tag=etl", ""},
+			{"Search Flows", "GET", "/api/flows/search?q=retries", ""},
+			{"Remove Flow Tag", "DELETE", "/api/flows/test-flow/tags/nightly", ""},
+			{"Create Flow Step", "POST", "/api/flows/test-flow/steps", `{"id":"reader","type":"FileReader"}`},
+			{"List Flow Steps", "GET", "/api/flows/test-flow/steps", ""},
+			{"Get Flow Step", "GET", "/api/flows/test-flow/steps/reader", ""},
+			{"Update Flow Step", "PUT", "/api/flows/test-flow/steps/reader", `{"type":"S3Reader"}`},
+			{"Reorder Flow Steps", "PUT", "/api/flows/test-flow/steps/order", `["reader"]`},
+			{"Delete Flow Step", "DELETE", "/api/flows/test-flow/steps/reader", ""},
 			{"Delete Flow", "DELETE", "/api/flows/test-flow", ""},
 		}
 
@@ -217,9 +191,9 @@ func TestLinks(t *testing.T) {
 				var err error
 
 				if e.body != "" {
-					req, err = http.NewRequest(e.method, ts.URL+e.path, strings.NewReader(e.body))
+					req, err = http.NewRequest(e.method, h.URL+e.path, strings.NewReader(e.body))
 				} else {
-					req, err = http.NewRequest(e.method, ts.URL+e.path, http.NoBody)
+					req, err = http.NewRequest(e.method, h.URL+e.path, http.NoBody)
 				}
 				require.NoError(t, err)
 
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"flow-control/internal/logger"
+	"flow-control/internal/types"
+)
+
+// LogController adjusts log filtering at runtime. The server's logger must
+// implement it for the log level endpoints to be available; logger.Logger
+// does.
+type LogController interface {
+	Level() types.LogLevel
+	SetLevel(level types.LogLevel)
+	ComponentLevels() map[string]types.LogLevel
+	SetComponentLevels(levels map[string]types.LogLevel)
+	Sampling() map[string]logger.SamplingRule
+	SetSampling(rules map[string]logger.SamplingRule)
+}
+
+// LogStreamer follows the entries of a log as they are written. The
+// server's logger must implement it for the log stream endpoint to be
+// available; logger.Logger does.
+type LogStreamer interface {
+	Stream(filter logger.StreamFilter, buffer int) *logger.Stream
+}
+
+// LogLevelConfig is the effective log filtering configuration
+type LogLevelConfig struct {
+	// Level is the minimum level of components without an override
+	Level types.LogLevel `json:"level"`
+	// Components maps component names to their minimum levels
+	Components map[string]types.LogLevel `json:"components"`
+	// Sampling maps component names to their sampling rules
+	Sampling map[string]logger.SamplingRule `json:"sampling"`
+}
+
+// LogLevelUpdate changes log filtering. Omitted fields are left unchanged;
+// components and sampling replace the current overrides, so an empty object
+// clears them.
+type LogLevelUpdate struct {
+	Level      string                         `json:"level,omitempty"`
+	Components map[string]string              `json:"components,omitempty"`
+	Sampling   map[string]logger.SamplingRule `json:"sampling,omitempty"`
+}
+
+// @Summary Get log filtering
+// @Description Get the current log level, per-component overrides and sampling rules. Requires the admin token.
+// @Tags admin
+// @Produce json
+// @Success 200 {object} LogLevelConfig
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 501 {string} string "Logger not adjustable"
+// @Router /admin/log-level [get]
+func (s *Server) handleGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleGetLogLevel",
+	}
+
+	controller, ok := s.log.(LogController)
+	if !ok {
+		http.Error(w, "Logger does not support runtime changes", http.StatusNotImplemented)
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusOK, logLevelConfig(controller), fields)
+}
+
+// @Summary Change log filtering
+// @Description Change the log level, per-component overrides or sampling rules without a restart. Requires the admin token.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param update body LogLevelUpdate true "Changes to apply"
+// @Success 200 {object} LogLevelConfig
+// @Failure 400 {string} string "Invalid level or rule"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 501 {string} string "Logger not adjustable"
+// @Router /admin/log-level [put]
+func (s *Server) handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleSetLogLevel",
+	}
+
+	controller, ok := s.log.(LogController)
+	if !ok {
+		http.Error(w, "Logger does not support runtime changes", http.StatusNotImplemented)
+		return
+	}
+
+	var update LogLevelUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid log level data", http.StatusBadRequest)
+		return
+	}
+
+	// Validate everything before applying anything
+	var level types.LogLevel
+	if update.Level != "" {
+		parsed, ok := logger.LookupLevel(update.Level)
+		if !ok {
+			http.Error(w, fmt.Sprintf("invalid log level: %s", update.Level), http.StatusBadRequest)
+			return
+		}
+		level = parsed
+	}
+	var components map[string]types.LogLevel
+	if update.Components != nil {
+		components = make(map[string]types.LogLevel, len(update.Components))
+		for component, name := range update.Components {
+			parsed, ok := logger.LookupLevel(name)
+			if !ok {
+				http.Error(w, fmt.Sprintf("invalid log level for %s: %s", component, name), http.StatusBadRequest)
+				return
+			}
+			components[component] = parsed
+		}
+	}
+	for component, rule := range update.Sampling {
+		if rule.DebugEvery < 0 || rule.InfoEvery < 0 || rule.MaxPerMinute < 0 {
+			http.Error(w, fmt.Sprintf("log sampling for %s cannot be negative", component), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if level != "" {
+		controller.SetLevel(level)
+	}
+	if components != nil {
+		controller.SetComponentLevels(components)
+	}
+	if update.Sampling != nil {
+		controller.SetSampling(update.Sampling)
+	}
+
+	current := logLevelConfig(controller)
+	fields["level"] = current.Level
+	s.requestLog(r).Info("Changed log filtering", fields)
+	s.writeJSON(w, r, http.StatusOK, current, fields)
+}
+
+// logLevelConfig reads the effective configuration of a controller
+func logLevelConfig(controller LogController) LogLevelConfig {
+	return LogLevelConfig{
+		Level:      controller.Level(),
+		Components: controller.ComponentLevels(),
+		Sampling:   controller.Sampling(),
+	}
+}
+
+// @Summary Stream logs
+// @Description Stream the entries the server logs from now on as server-sent events, optionally filtered by minimum level and component. Requires the admin token.
+// @Tags admin
+// @Produce text/event-stream
+// @Param level query string false "Only stream entries at this level or above"
+// @Param component query string false "Only stream entries of this component"
+// @Success 200 {object} logger.LogEntry
+// @Failure 400 {string} string "Invalid level"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 501 {string} string "Logger not streamable"
+// @Router /admin/logs/stream [get]
+func (s *Server) handleStreamLogs(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleStreamLogs",
+	}
+
+	streamer, ok := s.log.(LogStreamer)
+	if !ok {
+		http.Error(w, "Logger does not support streaming", http.StatusNotImplemented)
+		return
+	}
+	filter := logger.StreamFilter{
+		Level:     r.URL.Query().Get("level"),
+		Component: r.URL.Query().Get("component"),
+	}
+	if _, ok := logger.LookupLevel(filter.Level); filter.Level != "" && !ok {
+		http.Error(w, fmt.Sprintf("invalid log level: %s", filter.Level), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	stream := streamer.Stream(filter, 0)
+	defer func() {
+		stream.Close()
+		if dropped := stream.Dropped(); dropped > 0 {
+			fields["dropped"] = dropped
+			s.requestLog(r).Warn("Log stream fell behind", fields)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case entry, ok := <-stream.Entries():
+			if !ok {
+				return
+			}
+			// Entries were marshaled once already when they were written,
+			// so they cannot fail here
+			data, _ := json.Marshal(entry)
+			if _, err := w.Write([]byte("event: log\ndata: " + string(data) + "\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+
+	"flow-control/internal/types"
+)
+
+// @Summary Get process resource usage
+// @Description Sample the CPU, memory, goroutine and database pool usage of the server process
+// @Tags metrics
+// @Produce json
+// @Success 200 {object} types.ResourceMetrics
+// @Failure 501 {string} string "Metrics not configured"
+// @Router /metrics/process [get]
+func (s *Server) handleProcessMetrics(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleProcessMetrics",
+	}
+
+	if s.process == nil {
+		http.Error(w, "Process metrics not configured", http.StatusNotImplemented)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, s.process.Sample(), fields)
+}
+
+// handlePrometheus serves all metrics in the Prometheus text format
+func (s *Server) handlePrometheus(w http.ResponseWriter, r *http.Request) {
+	if s.metrics == nil {
+		http.Error(w, "Metrics not configured", http.StatusNotImplemented)
+		return
+	}
+	s.metrics.Handler().ServeHTTP(w, r)
+}
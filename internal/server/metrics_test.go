@@ -0,0 +1,72 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"flow-control/internal/logger"
+	"flow-control/internal/metrics"
+	"flow-control/internal/server"
+	"flow-control/internal/store"
+	"flow-control/internal/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsAPI(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	registry := metrics.NewRegistry()
+	opts := store.DefaultOptions()
+	opts.Metrics = registry
+	st, err := store.New(filepath.Join(t.TempDir(), "metrics.db"), log, opts)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	process := metrics.NewProcessCollector(st)
+	require.NoError(t, registry.Register(process))
+
+	srv := server.New(st, log, server.WithMetrics(registry, process))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	// Store queries and process samples are scraped together
+	resp, err := http.Get(ts.URL + "/api/flows/")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	resp, err = http.Get(ts.URL + "/metrics")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, resp.Header.Get("Content-Type"), "version=0.0.4")
+	require.Contains(t, string(body), "# TYPE store_query_duration_seconds histogram")
+	require.Contains(t, string(body), "process_heap_bytes ")
+	require.Contains(t, string(body), "store_pool_open_connections ")
+
+	// Process usage is available as ResourceMetrics
+	resp, err = http.Get(ts.URL + "/api/metrics/process")
+	require.NoError(t, err)
+	var sample types.ResourceMetrics
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&sample))
+	require.NoError(t, resp.Body.Close())
+	require.Positive(t, sample.MemoryHeap)
+	require.Positive(t, sample.Goroutines)
+	require.Positive(t, sample.DBConnections)
+
+	// Without metrics both endpoints are unavailable
+	bare := httptest.NewServer(server.New(st, log))
+	defer bare.Close()
+	for _, path := range []string{"/metrics", "/api/metrics/process"} {
+		resp, err = http.Get(bare.URL + path)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+	}
+}
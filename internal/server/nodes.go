@@ -0,0 +1,59 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/types"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// @Summary List node types
+// @Description List the node types flows can use, each with the JSON Schema of its settings for rendering and checking config forms
+// @Tags nodes
+// @Produce json
+// @Success 200 {array} engine.NodeType
+// @Router /v1/nodes [get]
+func (s *Server) handleListNodeTypes(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleListNodeTypes",
+	}
+
+	catalog, err := s.nodes.Catalog()
+	if err != nil {
+		s.requestLog(r).Error("Failed to describe node types", err, fields)
+		http.Error(w, "Failed to describe node types", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, catalog, fields)
+}
+
+// @Summary Get a node type
+// @Description Get a node type with the JSON Schema of its settings
+// @Tags nodes
+// @Produce json
+// @Param type path string true "Node type"
+// @Success 200 {object} engine.NodeType
+// @Failure 404 {string} string "Node type not found"
+// @Router /v1/nodes/{type} [get]
+func (s *Server) handleGetNodeType(w http.ResponseWriter, r *http.Request) {
+	nodeType := chi.URLParam(r, "type")
+	fields := types.Fields{
+		"function":  "handleGetNodeType",
+		"node_type": nodeType,
+	}
+
+	nt, err := s.nodes.NodeType(nodeType)
+	if errors.Is(err, engine.ErrUnknownNodeType) {
+		http.Error(w, "Node type not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.requestLog(r).Error("Failed to describe node type", err, fields)
+		http.Error(w, "Failed to describe node type", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, nt, fields)
+}
@@ -0,0 +1,46 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/server"
+	"flow-control/internal/testsupport"
+	"flow-control/internal/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+// echo is a custom node type forwarding messages unchanged
+type echo struct {
+	engine.BaseNode
+}
+
+func (n *echo) Process(ctx context.Context, msg types.Message) (types.Message, error) {
+	return msg, nil
+}
+
+func TestNodeCatalog(t *testing.T) {
+	registry := engine.NewRegistry()
+	registry.Register("Echo", func(cfg types.NodeConfig) (types.Node, error) {
+		return &echo{BaseNode: engine.BaseNode{Config: cfg}}, nil
+	})
+	registry.Describe("Echo", "Forwards messages", engine.Setting{Name: "label", Type: "string", Required: true})
+	h := testsupport.New(t, testsupport.WithServerOptions(server.WithNodeRegistry(registry)))
+	ctx := context.Background()
+
+	var catalog []engine.NodeType
+	require.NoError(t, h.Client.Do(ctx, http.MethodGet, "/api/v1/nodes", nil, &catalog))
+	require.Len(t, catalog, 14)
+	require.Equal(t, engine.TypeApproval, catalog[0].Type)
+
+	var echoType engine.NodeType
+	require.NoError(t, h.Client.Do(ctx, http.MethodGet, "/api/v1/nodes/Echo", nil, &echoType))
+	require.Equal(t, "Forwards messages", echoType.Description)
+	require.Contains(t, string(echoType.Schema), `"required":["label"]`)
+
+	err := h.Client.Do(ctx, http.MethodGet, "/api/v1/nodes/Missing", nil, nil)
+	require.Equal(t, http.StatusNotFound, testsupport.StatusCode(err))
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/types"
+)
+
+// runningBackfills returns the runs of the flow in the URL on this server,
+// its running backfills, responding with an error when there are none
+func (s *Server) runningBackfills(w http.ResponseWriter, r *http.Request, fields types.Fields) []*backfillRun {
+	id := chi.URLParam(r, "id")
+	if _, err := s.store.GetFlow(id); err != nil {
+		s.handleStoreError(w, r, err, "Failed to get flow", fields)
+		return nil
+	}
+
+	s.backfillMu.Lock()
+	defer s.backfillMu.Unlock()
+	var runs []*backfillRun
+	for _, run := range s.backfills {
+		if run.FlowID == id && run.Status == types.FlowStatusRunning {
+			runs = append(runs, run)
+		}
+	}
+	if len(runs) == 0 {
+		http.Error(w, "Flow is not running on this server", http.StatusConflict)
+	}
+	return runs
+}
+
+// nodeStatuses returns the state of every node of runs, in the order of
+// their flow, a node being paused in any run counting as paused and its
+// buffered messages adding up
+func nodeStatuses(runs []*backfillRun) []engine.NodeStatus {
+	statuses := runs[0].engine.NodeStatuses()
+	for _, run := range runs[1:] {
+		for i, status := range run.engine.NodeStatuses() {
+			if status.State == types.ResourceStateThrottled {
+				statuses[i].State = status.State
+			}
+			statuses[i].Buffered += status.Buffered
+		}
+	}
+	return statuses
+}
+
+// @Summary List the nodes of a running flow
+// @Description List the state of every node of a flow running on this server, in the order of the flow: throttled while the node is paused, with the number of messages waiting for it in every run of the flow, and running otherwise
+// @Tags flows
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Success 200 {array} engine.NodeStatus
+// @Failure 404 {string} string "Flow not found"
+// @Failure 409 {string} string "Flow not running"
+// @Router /flows/{id}/nodes [get]
+func (s *Server) handleListFlowNodes(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleListFlowNodes",
+		"flow_id":  chi.URLParam(r, "id"),
+	}
+
+	runs := s.runningBackfills(w, r, fields)
+	if runs == nil {
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, nodeStatuses(runs), fields)
+}
+
+// @Summary Pause a node
+// @Description Stop one node of a running flow, in each of its runs, from processing messages, without stopping the flow, such as to spare a misbehaving downstream system during an incident. The messages reaching the node are buffered until it is resumed, and the nodes after it receive nothing from it in the meantime. Backfills do not complete while nodes are paused. Stopping the flow drops the buffered messages.
+// @Tags flows
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Param node path string true "Node ID"
+// @Success 200 {object} engine.NodeStatus
+// @Failure 404 {string} string "Flow or node not found"
+// @Failure 409 {string} string "Flow not running"
+// @Router /flows/{id}/nodes/{node}/pause [post]
+func (s *Server) handlePauseNode(w http.ResponseWriter, r *http.Request) {
+	nodeID := chi.URLParam(r, "node")
+	fields := types.Fields{
+		"function": "handlePauseNode",
+		"flow_id":  chi.URLParam(r, "id"),
+		"node_id":  nodeID,
+	}
+
+	runs := s.runningBackfills(w, r, fields)
+	if runs == nil {
+		return
+	}
+	for _, run := range runs {
+		// Pausing only fails for nodes the flow does not have
+		if err := run.engine.Pause(nodeID); err != nil {
+			http.Error(w, "Node not found", http.StatusNotFound)
+			return
+		}
+	}
+	s.publish(r, types.FlowEvent{
+		FlowID:  runs[0].FlowID,
+		NodeID:  nodeID,
+		Type:    engine.TypeNodePaused,
+		Message: "Node paused",
+	})
+	s.writeNodeStatus(w, r, runs, nodeID, fields)
+}
+
+// @Summary Resume a node
+// @Description Let a paused node of a running flow process messages again. The messages buffered while it was paused go through it and the nodes after it, in the order they arrived, before the response is sent.
+// @Tags flows
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Param node path string true "Node ID"
+// @Success 200 {object} engine.NodeStatus
+// @Failure 404 {string} string "Flow or node not found"
+// @Failure 409 {string} string "Flow not running"
+// @Router /flows/{id}/nodes/{node}/resume [post]
+func (s *Server) handleResumeNode(w http.ResponseWriter, r *http.Request) {
+	nodeID := chi.URLParam(r, "node")
+	fields := types.Fields{
+		"function": "handleResumeNode",
+		"flow_id":  chi.URLParam(r, "id"),
+		"node_id":  nodeID,
+	}
+
+	runs := s.runningBackfills(w, r, fields)
+	if runs == nil {
+		return
+	}
+	for _, run := range runs {
+		// Failures on the buffered messages are reported as node events,
+		// like those of any other message
+		if err := run.engine.Resume(run.ctx, nodeID); errors.Is(err, engine.ErrUnknownNode) {
+			http.Error(w, "Node not found", http.StatusNotFound)
+			return
+		}
+	}
+	s.publish(r, types.FlowEvent{
+		FlowID:  runs[0].FlowID,
+		NodeID:  nodeID,
+		Type:    engine.TypeNodeResumed,
+		Message: "Node resumed",
+	})
+	s.writeNodeStatus(w, r, runs, nodeID, fields)
+}
+
+// writeNodeStatus responds with the status of the node with id
+func (s *Server) writeNodeStatus(w http.ResponseWriter, r *http.Request, runs []*backfillRun, id string, fields types.Fields) {
+	for _, status := range nodeStatuses(runs) {
+		if status.ID == id {
+			s.writeJSON(w, r, http.StatusOK, status, fields)
+			return
+		}
+	}
+}
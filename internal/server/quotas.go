@@ -0,0 +1,369 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"flow-control/internal/cluster"
+	"flow-control/internal/config"
+	"flow-control/internal/events"
+	"flow-control/internal/runtime/backfill"
+	"flow-control/internal/store"
+	"flow-control/internal/types"
+)
+
+// ProjectTag prefixes the tag naming the project of a flow, such as
+// "project:billing"
+const ProjectTag = "project:"
+
+// Names of the quotas of projects, reported in quota.exceeded events
+const (
+	QuotaRuns        = "max_runs"
+	QuotaMessageRate = "max_messages_per_second"
+	QuotaStorage     = "max_storage_bytes"
+)
+
+// Quota bounds the flows of a project together, so that one project cannot
+// starve the others. Zero leaves a resource unlimited.
+type Quota struct {
+	// MaxRuns is the number of runs, running flows and backfills, the flows
+	// of the project have at once; runs started beyond it are refused
+	MaxRuns int `json:"max_runs,omitempty"`
+	// MaxMessagesPerSecond is the rate the backfills of the project are
+	// fed their input at, together
+	MaxMessagesPerSecond float64 `json:"max_messages_per_second,omitempty"`
+	// MaxStorageBytes is the size of the run data, node logs and
+	// timelines, kept for the flows of the project beyond which their
+	// backfills are refused
+	MaxStorageBytes int64 `json:"max_storage_bytes,omitempty"`
+}
+
+// ProjectUsage is what the flows of a project use of its quota
+type ProjectUsage struct {
+	Project      string `json:"project"`
+	Quota        Quota  `json:"quota"`
+	Runs         int    `json:"runs"`
+	StorageBytes int64  `json:"storage_bytes"`
+}
+
+// project is a project with a quota
+type project struct {
+	quota Quota
+	// limiter paces the messages of the backfills of the project, when
+	// it has a message rate quota
+	limiter *limiter
+}
+
+// quotaError is returned when a run would exceed a quota of its project
+type quotaError struct {
+	project string
+	quota   string
+	limit   float64
+	used    float64
+}
+
+func (e *quotaError) Error() string {
+	return fmt.Sprintf("project %s is at its %s quota of %v", e.project, e.quota, e.limit)
+}
+
+// WithQuotas limits the flows of each project in quotas together. Flows
+// belong to the project their ProjectTag tag names; flows without one, or
+// of projects missing from quotas, share the quota of
+// config.DefaultProject.
+func WithQuotas(quotas map[string]Quota) Option {
+	return func(s *Server) {
+		s.projects = make(map[string]*project, len(quotas))
+		for name, quota := range quotas {
+			p := &project{quota: quota}
+			if quota.MaxMessagesPerSecond > 0 {
+				p.limiter = newLimiter(quota.MaxMessagesPerSecond)
+			}
+			s.projects[name] = p
+		}
+	}
+}
+
+// flowProject returns the project a flow with tags belongs to: the project
+// its ProjectTag tag names when that project has a quota, and
+// config.DefaultProject otherwise
+func (s *Server) flowProject(tags []string) string {
+	for _, tag := range tags {
+		if name, ok := strings.CutPrefix(tag, ProjectTag); ok {
+			if _, ok := s.projects[name]; ok {
+				return name
+			}
+		}
+	}
+	return config.DefaultProject
+}
+
+// projectFlows returns the flows of the project with name
+func (s *Server) projectFlows(name string) ([]*types.RuntimeFlow, error) {
+	flows, err := s.store.ListFlows(store.FlowFilter{})
+	if err != nil {
+		return nil, err
+	}
+	var members []*types.RuntimeFlow
+	for _, flow := range flows {
+		if s.flowProject(flow.Tags) == name {
+			members = append(members, flow)
+		}
+	}
+	return members, nil
+}
+
+// projectStorage returns the size of the run data kept for the flows of
+// the project with name
+func (s *Server) projectStorage(name string) (int64, error) {
+	flows, err := s.projectFlows(name)
+	if err != nil {
+		return 0, err
+	}
+	ids := make([]string, len(flows))
+	for i, flow := range flows {
+		ids[i] = flow.ID
+	}
+	return s.store.RunDataSize(ids)
+}
+
+// checkStorage fails when the run data of the project with name is at its
+// storage quota
+func (s *Server) checkStorage(name string) error {
+	p, ok := s.projects[name]
+	if !ok || p.quota.MaxStorageBytes == 0 {
+		return nil
+	}
+	used, err := s.projectStorage(name)
+	if err != nil {
+		return err
+	}
+	if used >= p.quota.MaxStorageBytes {
+		return &quotaError{project: name, quota: QuotaStorage, limit: float64(p.quota.MaxStorageBytes), used: float64(used)}
+	}
+	return nil
+}
+
+// checkRuns fails when the project with name already has as many runs as
+// its quota allows, leaving out the run of the flow with id, which is
+// about to start a run. The caller holds backfillMu.
+func (s *Server) checkRuns(name, id string) error {
+	p, ok := s.projects[name]
+	if !ok || p.quota.MaxRuns == 0 {
+		return nil
+	}
+	running, err := s.projectRuns(name, id)
+	if err != nil {
+		return err
+	}
+	if running >= p.quota.MaxRuns {
+		return &quotaError{project: name, quota: QuotaRuns, limit: float64(p.quota.MaxRuns), used: float64(running)}
+	}
+	return nil
+}
+
+// projectRuns counts the runs of the project with name: its running
+// backfills and simulations, and its running flows that run no backfill,
+// but for the flow with exclude. The caller holds backfillMu.
+func (s *Server) projectRuns(name, exclude string) (int, error) {
+	running := 0
+	backfilled := make(map[string]bool)
+	for _, other := range s.backfills {
+		if other.Project == name && other.Status == types.FlowStatusRunning {
+			running++
+			if !other.Simulation {
+				backfilled[other.FlowID] = true
+			}
+		}
+	}
+	flows, err := s.projectFlows(name)
+	if err != nil {
+		return 0, err
+	}
+	for _, flow := range flows {
+		if flow.ID != exclude && flow.Status == types.FlowStatusRunning && !backfilled[flow.ID] {
+			running++
+		}
+	}
+	return running, nil
+}
+
+// AdmitRun fails with the quota it exceeds when the project of flow already
+// has as many runs claimed across the cluster as its quota allows, so that
+// the instance leaves the run of flow unclaimed until another one ends
+func (s *Server) AdmitRun(flow *types.RuntimeFlow) error {
+	name := s.flowProject(flow.Tags)
+	p, ok := s.projects[name]
+	if !ok || p.quota.MaxRuns == 0 {
+		return nil
+	}
+	placement, err := cluster.Placements(s.store)
+	if err != nil {
+		return err
+	}
+	flows, err := s.projectFlows(name)
+	if err != nil {
+		return err
+	}
+	members := make(map[string]bool, len(flows))
+	for _, member := range flows {
+		members[member.ID] = true
+	}
+	claimed := 0
+	for _, run := range placement.Runs {
+		if run.Instance != "" && run.FlowID != flow.ID && members[run.FlowID] {
+			claimed++
+		}
+	}
+	if claimed >= p.quota.MaxRuns {
+		return &quotaError{project: name, quota: QuotaRuns, limit: float64(p.quota.MaxRuns), used: float64(claimed)}
+	}
+	return nil
+}
+
+// quotaExceeded reports that a run of the flow with id exceeded a quota of
+// its project
+func (s *Server) quotaExceeded(ctx context.Context, log types.Logger, id string, err *quotaError) {
+	log.Warn("Project quota exceeded", types.Fields{
+		"function": "quotaExceeded",
+		"flow_id":  id,
+		"project":  err.project,
+		"quota":    err.quota,
+	})
+	s.publishEvent(ctx, log, types.FlowEvent{
+		FlowID:  id,
+		Type:    events.TypeQuotaExceeded,
+		Message: "Quota exceeded: " + err.Error(),
+		Data: map[string]interface{}{
+			"project": err.project,
+			"quota":   err.quota,
+			"limit":   err.limit,
+			"used":    err.used,
+		},
+	})
+}
+
+// throttle paces the messages run is fed at the message rate quota of its
+// project, shared with the other runs of the project
+func (s *Server) throttle(run *backfillRun, flow backfill.Processor) backfill.Processor {
+	pace := s.pace(run)
+	if pace == nil {
+		return flow
+	}
+	return &throttled{Processor: flow, pace: pace}
+}
+
+// pace returns a function waiting for the message rate quota of run's
+// project before each message, or nil when the project has none
+func (s *Server) pace(run *backfillRun) func(context.Context) error {
+	p, ok := s.projects[run.Project]
+	if !ok || p.limiter == nil {
+		return nil
+	}
+	// Only the first wait is reported
+	var once sync.Once
+	exceeded := func() {
+		s.quotaExceeded(context.Background(), s.log, run.FlowID, &quotaError{
+			project: run.Project,
+			quota:   QuotaMessageRate,
+			limit:   p.quota.MaxMessagesPerSecond,
+			used:    p.quota.MaxMessagesPerSecond,
+		})
+	}
+	return func(ctx context.Context) error {
+		wait := p.limiter.reserve()
+		if wait <= 0 {
+			return nil
+		}
+		once.Do(exceeded)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// throttled waits for its pace before passing each message to its flow
+type throttled struct {
+	backfill.Processor
+	pace func(context.Context) error
+}
+
+// Process implements backfill.Processor
+func (t *throttled) Process(ctx context.Context, msg types.Message) error {
+	if err := t.pace(ctx); err != nil {
+		return err
+	}
+	return t.Processor.Process(ctx, msg)
+}
+
+// limiter is a token bucket letting through rate messages per second, in
+// bursts of up to a second's worth
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64) *limiter {
+	return &limiter{rate: rate, tokens: math.Max(1, rate)}
+}
+
+// reserve takes a token, returning how long to wait until it is available
+func (l *limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens = math.Min(math.Max(1, l.rate), l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// @Summary List project quotas
+// @Description List the projects with quotas and what their flows use of them: the flows and backfills running at once and the size of the run data kept for them. Flows belong to the project their project:NAME tag names, or to the default project when it has no quota.
+// @Tags quotas
+// @Produce json
+// @Success 200 {array} ProjectUsage
+// @Router /quotas [get]
+func (s *Server) handleListQuotas(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleListQuotas",
+	}
+
+	usages := make([]ProjectUsage, 0, len(s.projects))
+	for name, p := range s.projects {
+		storage, err := s.projectStorage(name)
+		if err != nil {
+			s.handleStoreError(w, r, err, "Failed to measure project storage", fields)
+			return
+		}
+		s.backfillMu.Lock()
+		running, err := s.projectRuns(name, "")
+		s.backfillMu.Unlock()
+		if err != nil {
+			s.handleStoreError(w, r, err, "Failed to count project runs", fields)
+			return
+		}
+		usages = append(usages, ProjectUsage{Project: name, Quota: p.quota, Runs: running, StorageBytes: storage})
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].Project < usages[j].Project
+	})
+	s.writeJSON(w, r, http.StatusOK, usages, fields)
+}
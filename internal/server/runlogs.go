@@ -0,0 +1,121 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"flow-control/internal/logger"
+	"flow-control/internal/store"
+	"flow-control/internal/types"
+)
+
+// logLevels lists the log levels from the most verbose
+var logLevels = []types.LogLevel{types.LogLevelDebug, types.LogLevelInfo, types.LogLevelWarn, types.LogLevelError}
+
+// newRunID returns a random run ID
+func newRunID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate run ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// @Summary List the node logs of a run
+// @Description List what the nodes logged during a run, such as a dry run, in the order they logged it, including the nodes of the flows they called and the output of the processes nodes ran. At most 1000 entries are returned unless limit is set.
+// @Tags flows
+// @Produce json
+// @Param run path string true "Run ID"
+// @Param flow query string false "Only return the entries of this flow"
+// @Param node query string false "Only return the entries of this node"
+// @Param level query string false "Only return entries at this level or above"
+// @Param limit query int false "Maximum number of entries"
+// @Success 200 {array} types.NodeLog
+// @Failure 400 {string} string "Invalid filter"
+// @Router /runs/{run}/logs [get]
+func (s *Server) handleListRunLogs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := store.NodeLogFilter{
+		RunID:  chi.URLParam(r, "run"),
+		FlowID: query.Get("flow"),
+		NodeID: query.Get("node"),
+	}
+	fields := types.Fields{
+		"function": "handleListRunLogs",
+		"run_id":   filter.RunID,
+	}
+
+	if v := query.Get("level"); v != "" {
+		level, ok := logger.LookupLevel(v)
+		if !ok {
+			http.Error(w, fmt.Sprintf("invalid log level: %s", v), http.StatusBadRequest)
+			return
+		}
+		filter.Levels = logLevels[slices.Index(logLevels, level):]
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+
+	logs, err := s.store.ListNodeLogs(filter)
+	if err != nil {
+		s.requestLog(r).Error("Failed to list run logs", err, fields)
+		http.Error(w, "Failed to list run logs", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, logs, fields)
+}
+
+// @Summary Get the timeline of a run
+// @Description List the messages that went through the nodes of a run that recorded its timeline, such as a dry run with timeline set, step by step: what each node saw, what it produced and when, including the nodes of the flows they called. Payloads larger than 64 KiB are recorded by their size alone. At most 1000 hops are returned unless limit is set.
+// @Tags flows
+// @Produce json
+// @Param run path string true "Run ID"
+// @Param flow query string false "Only return the hops through the nodes of this flow"
+// @Param node query string false "Only return the hops through this node"
+// @Param message query string false "Only return the hops of the message with this ID"
+// @Param limit query int false "Maximum number of hops"
+// @Success 200 {array} types.MessageHop
+// @Failure 400 {string} string "Invalid filter"
+// @Router /runs/{run}/timeline [get]
+func (s *Server) handleRunTimeline(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := store.MessageHopFilter{
+		RunID:     chi.URLParam(r, "run"),
+		FlowID:    query.Get("flow"),
+		NodeID:    query.Get("node"),
+		MessageID: query.Get("message"),
+	}
+	fields := types.Fields{
+		"function": "handleRunTimeline",
+		"run_id":   filter.RunID,
+	}
+
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+
+	hops, err := s.store.ListMessageHops(filter)
+	if err != nil {
+		s.requestLog(r).Error("Failed to get run timeline", err, fields)
+		http.Error(w, "Failed to get run timeline", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, hops, fields)
+}
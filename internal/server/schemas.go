@@ -0,0 +1,356 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"flow-control/internal/runtime/schema"
+	"flow-control/internal/store"
+	"flow-control/internal/types"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// latestVersion selects the newest version of a schema type in paths
+const latestVersion = "latest"
+
+// SchemaSummary describes a registered schema type
+type SchemaSummary struct {
+	Type     string   `json:"type"`
+	Versions []string `json:"versions"`
+	Latest   string   `json:"latest"`
+}
+
+// RegisterSchemaRequest registers a schema from either a definition or a
+// JSON Schema document
+type RegisterSchemaRequest struct {
+	Type       string             `json:"type"`
+	Version    string             `json:"version"`
+	Definition *schema.Definition `json:"definition,omitempty"`
+	JSONSchema json.RawMessage    `json:"json_schema,omitempty" swaggertype:"object"`
+}
+
+// CompatibilityResult reports whether two versions of a schema type may be
+// connected
+type CompatibilityResult struct {
+	Compatible bool   `json:"compatible"`
+	Mode       string `json:"mode"`
+	Producer   string `json:"producer"`
+	Consumer   string `json:"consumer"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// ValidationResult reports whether a payload matches a schema
+type ValidationResult struct {
+	Valid  bool                 `json:"valid"`
+	Errors []*schema.FieldError `json:"errors,omitempty"`
+}
+
+// requireSchemas rejects schema requests when no registry is configured
+func (s *Server) requireSchemas(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.schemas == nil {
+			http.Error(w, "Schema registry not configured", http.StatusNotImplemented)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// @Summary List schemas
+// @Description List every registered schema type with its versions
+// @Tags schemas
+// @Produce json
+// @Success 200 {array} SchemaSummary
+// @Failure 501 {string} string "Schema registry not configured"
+// @Router /v1/schemas [get]
+func (s *Server) handleListSchemas(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleListSchemas",
+	}
+
+	summaries := []SchemaSummary{}
+	for _, schemaType := range s.schemas.ListTypes() {
+		summary, err := s.schemaSummary(schemaType)
+		if err != nil {
+			s.handleSchemaError(w, r, err, "Failed to list schemas", fields)
+			return
+		}
+		summaries = append(summaries, summary)
+	}
+
+	s.writeJSON(w, r, http.StatusOK, summaries, fields)
+}
+
+// @Summary List schema versions
+// @Description List the registered versions of a schema type
+// @Tags schemas
+// @Produce json
+// @Param type path string true "Schema type"
+// @Success 200 {object} SchemaSummary
+// @Failure 404 {string} string "Schema not found"
+// @Router /v1/schemas/{type} [get]
+func (s *Server) handleListSchemaVersions(w http.ResponseWriter, r *http.Request) {
+	schemaType := chi.URLParam(r, "type")
+	fields := types.Fields{
+		"function": "handleListSchemaVersions",
+		"type":     schemaType,
+	}
+
+	summary, err := s.schemaSummary(schemaType)
+	if err != nil {
+		s.handleSchemaError(w, r, err, "Failed to list schema versions", fields)
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusOK, summary, fields)
+}
+
+// @Summary Get a schema
+// @Description Get a schema version as a JSON Schema document; "latest" selects the newest version
+// @Tags schemas
+// @Produce json
+// @Param type path string true "Schema type"
+// @Param version path string true "Schema version or latest"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {string} string "Schema not found"
+// @Router /v1/schemas/{type}/{version} [get]
+func (s *Server) handleGetSchema(w http.ResponseWriter, r *http.Request) {
+	schemaType := chi.URLParam(r, "type")
+	version := chi.URLParam(r, "version")
+	fields := types.Fields{
+		"function": "handleGetSchema",
+		"type":     schemaType,
+		"version":  version,
+	}
+
+	found, err := s.lookupSchema(schemaType, version)
+	if err != nil {
+		s.handleSchemaError(w, r, err, "Failed to get schema", fields)
+		return
+	}
+
+	doc, err := schema.ToJSONSchema(found)
+	if err != nil {
+		s.handleSchemaError(w, r, err, "Failed to export schema", fields)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	if _, err := w.Write(doc); err != nil {
+		s.requestLog(r).Error("Failed to write schema", err, fields)
+	}
+}
+
+// @Summary Register a schema
+// @Description Register a new schema version from a definition or a JSON Schema document
+// @Tags schemas
+// @Accept json
+// @Produce json
+// @Param schema body RegisterSchemaRequest true "Schema to register"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {string} string "Invalid request"
+// @Failure 409 {string} string "Schema already exists"
+// @Failure 422 {string} string "Invalid definition"
+// @Router /v1/schemas [post]
+func (s *Server) handleRegisterSchema(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleRegisterSchema",
+	}
+
+	var req RegisterSchemaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.requestLog(r).Error("Failed to decode schema", err, fields)
+		http.Error(w, "Invalid schema data", http.StatusBadRequest)
+		return
+	}
+	fields["type"] = req.Type
+	fields["version"] = req.Version
+
+	if (req.Definition == nil) == (len(req.JSONSchema) == 0) {
+		http.Error(w, "Exactly one of definition and json_schema is required", http.StatusBadRequest)
+		return
+	}
+
+	var registered *schema.DefinedSchema
+	var err error
+	if req.Definition != nil {
+		registered, err = s.schemas.Define(req.Type, req.Version, *req.Definition)
+	} else {
+		def, parseErr := schema.ParseJSONSchema(req.JSONSchema)
+		if parseErr != nil {
+			http.Error(w, parseErr.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		registered, err = s.schemas.Define(req.Type, req.Version, def)
+	}
+	if err != nil {
+		s.handleSchemaError(w, r, err, "Failed to register schema", fields)
+		return
+	}
+
+	doc, err := schema.ToJSONSchema(registered)
+	if err != nil {
+		s.handleSchemaError(w, r, err, "Failed to export schema", fields)
+		return
+	}
+
+	s.requestLog(r).Info("Registered schema", fields)
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusCreated)
+	if _, err := w.Write(doc); err != nil {
+		s.requestLog(r).Error("Failed to write schema", err, fields)
+	}
+}
+
+// @Summary Check schema compatibility
+// @Description Check whether data produced with one version of a schema type may be consumed with another
+// @Tags schemas
+// @Produce json
+// @Param type path string true "Schema type"
+// @Param producer query string true "Producer version"
+// @Param consumer query string true "Consumer version"
+// @Param mode query string false "Compatibility mode: backward (default), forward, full or none"
+// @Success 200 {object} CompatibilityResult
+// @Failure 400 {string} string "Invalid request"
+// @Failure 404 {string} string "Schema not found"
+// @Router /v1/schemas/{type}/compatibility [get]
+func (s *Server) handleCheckSchemaCompatibility(w http.ResponseWriter, r *http.Request) {
+	schemaType := chi.URLParam(r, "type")
+	query := r.URL.Query()
+	fields := types.Fields{
+		"function": "handleCheckSchemaCompatibility",
+		"type":     schemaType,
+	}
+
+	if query.Get("producer") == "" || query.Get("consumer") == "" {
+		http.Error(w, "producer and consumer versions are required", http.StatusBadRequest)
+		return
+	}
+	mode := schema.CompatibilityBackward
+	if raw := query.Get("mode"); raw != "" {
+		parsed, err := schema.ParseCompatibilityMode(raw)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mode = parsed
+	}
+
+	producer, err := s.lookupSchema(schemaType, query.Get("producer"))
+	if err != nil {
+		s.handleSchemaError(w, r, err, "Failed to get producer schema", fields)
+		return
+	}
+	consumer, err := s.lookupSchema(schemaType, query.Get("consumer"))
+	if err != nil {
+		s.handleSchemaError(w, r, err, "Failed to get consumer schema", fields)
+		return
+	}
+
+	result := CompatibilityResult{
+		Compatible: true,
+		Mode:       string(mode),
+		Producer:   producer.GetVersion(),
+		Consumer:   consumer.GetVersion(),
+	}
+	if err := schema.CheckCompatibility(producer, consumer, mode); err != nil {
+		result.Compatible = false
+		result.Reason = err.Error()
+	}
+
+	s.writeJSON(w, r, http.StatusOK, result, fields)
+}
+
+// @Summary Validate a payload
+// @Description Validate a JSON payload against a schema version, reporting every invalid field
+// @Tags schemas
+// @Accept json
+// @Produce json
+// @Param type path string true "Schema type"
+// @Param version path string true "Schema version or latest"
+// @Param payload body object true "Payload"
+// @Success 200 {object} ValidationResult
+// @Failure 400 {string} string "Invalid JSON"
+// @Failure 404 {string} string "Schema not found"
+// @Router /v1/schemas/{type}/{version}/validate [post]
+func (s *Server) handleValidateSchema(w http.ResponseWriter, r *http.Request) {
+	schemaType := chi.URLParam(r, "type")
+	version := chi.URLParam(r, "version")
+	fields := types.Fields{
+		"function": "handleValidateSchema",
+		"type":     schemaType,
+		"version":  version,
+	}
+
+	found, err := s.lookupSchema(schemaType, version)
+	if err != nil {
+		s.handleSchemaError(w, r, err, "Failed to get schema", fields)
+		return
+	}
+
+	// Numbers are kept as json.Number so integer fields validate
+	var payload interface{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		return
+	}
+
+	validate, err := s.schemas.Validator(found.GetType(), found.GetVersion())
+	if err != nil {
+		s.handleSchemaError(w, r, err, "Failed to compile schema", fields)
+		return
+	}
+
+	result := ValidationResult{Valid: true}
+	if err := validate(payload); err != nil {
+		result.Valid = false
+		var validationErr *schema.ValidationError
+		if errors.As(err, &validationErr) {
+			result.Errors = validationErr.Errors
+		} else {
+			result.Errors = []*schema.FieldError{{Message: err.Error()}}
+		}
+	}
+
+	s.writeJSON(w, r, http.StatusOK, result, fields)
+}
+
+// schemaSummary describes a registered schema type
+func (s *Server) schemaSummary(schemaType string) (SchemaSummary, error) {
+	versions, err := s.schemas.ListVersions(schemaType)
+	if err != nil {
+		return SchemaSummary{}, err
+	}
+	latest, err := s.schemas.GetLatest(schemaType)
+	if err != nil {
+		return SchemaSummary{}, err
+	}
+	return SchemaSummary{Type: schemaType, Versions: versions, Latest: latest.GetVersion()}, nil
+}
+
+// lookupSchema gets a schema version, resolving "latest"
+func (s *Server) lookupSchema(schemaType, version string) (types.Schema, error) {
+	if version == latestVersion {
+		return s.schemas.GetLatest(schemaType)
+	}
+	return s.schemas.Get(schemaType, version)
+}
+
+// handleSchemaError maps schema registry errors to HTTP responses
+func (s *Server) handleSchemaError(w http.ResponseWriter, r *http.Request, err error, msg string, fields types.Fields) {
+	switch {
+	case errors.Is(err, schema.ErrSchemaNotFound):
+		http.Error(w, "Schema not found", http.StatusNotFound)
+	case errors.Is(err, schema.ErrSchemaExists), errors.Is(err, store.ErrSchemaExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, schema.ErrInvalidDefinition):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		s.requestLog(r).Error(msg, err, fields)
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,153 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"flow-control/internal/logger"
+	"flow-control/internal/runtime/schema"
+	"flow-control/internal/server"
+	"flow-control/internal/store"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaAPI(t *testing.T) {
+	// Create test dependencies
+	log := logger.New()
+	st, err := store.New(filepath.Join(t.TempDir(), "schemas.db"), log)
+	require.NoError(t, err)
+	defer func() { _ = st.Close() }()
+
+	registry, err := schema.NewPersistentRegistry(st)
+	require.NoError(t, err)
+
+	srv := server.New(st, log, server.WithSchemaRegistry(registry))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	post := func(path, body string) *http.Response {
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		return resp
+	}
+	get := func(path string) *http.Response {
+		resp, err := http.Get(ts.URL + path)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Register from a definition and from a JSON Schema document
+	resp := post("/api/v1/schemas", `{"type":"order","version":"1.0.0","definition":{"type":"object","properties":{"id":{"type":"string"}},"required":["id"]}}`)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp = post("/api/v1/schemas", `{"type":"order","version":"1.1.0","json_schema":{"type":"object","properties":{"id":{"type":"string"},"qty":{"type":"integer"}},"required":["id"]}}`)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Duplicates, bad definitions and ambiguous requests are rejected
+	resp = post("/api/v1/schemas", `{"type":"order","version":"1.0.0","definition":{"type":"string"}}`)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp = post("/api/v1/schemas", `{"type":"bad","version":"1.0.0","definition":{"type":"nope"}}`)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	resp = post("/api/v1/schemas", `{"type":"bad","version":"1.0.0"}`)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// List types and versions
+	resp = get("/api/v1/schemas/order")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var summary server.SchemaSummary
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&summary))
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, []string{"1.0.0", "1.1.0"}, summary.Versions)
+	require.Equal(t, "1.1.0", summary.Latest)
+
+	resp = get("/api/v1/schemas")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var summaries []server.SchemaSummary
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&summaries))
+	require.NoError(t, resp.Body.Close())
+	require.Contains(t, summaries, summary)
+
+	resp = get("/api/v1/schemas/missing")
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Get the latest version as JSON Schema
+	resp = get("/api/v1/schemas/order/latest")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/schema+json", resp.Header.Get("Content-Type"))
+	var doc map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&doc))
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, "order", doc["title"])
+	require.Contains(t, doc["properties"], "qty")
+
+	resp = get("/api/v1/schemas/order/2.0.0")
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Check compatibility
+	var compat server.CompatibilityResult
+	resp = get("/api/v1/schemas/order/compatibility?producer=1.0.0&consumer=1.1.0")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&compat))
+	require.NoError(t, resp.Body.Close())
+	require.True(t, compat.Compatible)
+	require.Equal(t, "backward", compat.Mode)
+
+	resp = get("/api/v1/schemas/order/compatibility?producer=1.0.0&consumer=1.1.0&mode=full")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&compat))
+	require.NoError(t, resp.Body.Close())
+	require.False(t, compat.Compatible)
+	require.NotEmpty(t, compat.Reason)
+
+	resp = get("/api/v1/schemas/order/compatibility?producer=1.0.0&consumer=1.1.0&mode=sideways")
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Validate payloads, reporting every bad field
+	var result server.ValidationResult
+	resp = post("/api/v1/schemas/order/1.1.0/validate", `{"id":"a","qty":3}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	require.NoError(t, resp.Body.Close())
+	require.True(t, result.Valid)
+
+	result = server.ValidationResult{}
+	resp = post("/api/v1/schemas/order/latest/validate", `{"qty":"three"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	require.NoError(t, resp.Body.Close())
+	require.False(t, result.Valid)
+	require.Len(t, result.Errors, 2)
+
+	// Registered schemas are persisted
+	reloaded, err := schema.NewPersistentRegistry(st)
+	require.NoError(t, err)
+	_, err = reloaded.Get("order", "1.1.0")
+	require.NoError(t, err)
+}
+
+func TestSchemaAPIWithoutRegistry(t *testing.T) {
+	log := logger.New()
+	srv := server.New(&store.SQLiteStore{}, log)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/schemas")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+}
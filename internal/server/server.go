@@ -6,14 +6,32 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	// Import swagger docs
 	_ "flow-control/docs"
+	"flow-control/internal/alerting"
+	"flow-control/internal/cluster"
+	"flow-control/internal/compression"
+	"flow-control/internal/config"
+	"flow-control/internal/events"
+	"flow-control/internal/logger"
+	"flow-control/internal/metrics"
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/runtime/schema"
+	"flow-control/internal/secrets"
 	"flow-control/internal/store"
+	"flow-control/internal/templates"
+	"flow-control/internal/tracing"
 	"flow-control/internal/types"
+	"flow-control/pkg/api"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -22,17 +40,241 @@ import (
 
 // Server represents the HTTP server
 type Server struct {
-	router chi.Router
-	store  *store.Store
-	log    types.Logger
+	router  chi.Router
+	store   store.Store
+	schemas *schema.SchemaRegistry
+	tracer  *tracing.Tracer
+	metrics *metrics.Registry
+	process *metrics.ProcessCollector
+	events  events.Bus
+	alerts  *alerting.Evaluator
+	log     types.Logger
+
+	adminToken string
+	apiKeys    []string
+	limits     Limits
+	// dataDir holds the files the Replay nodes of simulations send
+	dataDir string
+	// lookups are the endpoints Enrich nodes may reach
+	lookups engine.Lookups
+	// secrets resolves the ${secret.NAME} references in node settings
+	secrets secrets.Provider
+	config  func() *config.Config
+	// faults maps flow IDs to the faults injected into their dry runs;
+	// fault injection is disabled while it is nil
+	faults      map[string]engine.Faults
+	compression compression.Policy
+	templates   *templates.Library
+	nodes       *engine.Registry
+	restart     func() error
+	cluster     *cluster.Elector
+	// projects maps project names to their quotas
+	projects map[string]*project
+
+	// backfills holds the backfills run since the server started by ID
+	backfillMu sync.Mutex
+	backfills  map[string]*backfillRun
+	// backfillQueue holds the queued backfills of each flow ID, in the
+	// order they start
+	backfillQueue map[string][]*backfillRun
+	// launched counts the backfills and simulations running in the
+	// background, until they recorded how they ended
+	launched int
+	// draining refuses new backfills and simulations while a restart waits
+	// for the running ones to end
+	draining bool
+	// flowRuns holds the engines of the flow runs RunFlow runs by flow ID
+	flowRuns map[string]*engine.Engine
+}
+
+// Option configures optional Server dependencies
+type Option func(*Server)
+
+// WithSchemaRegistry serves the schema registry API from registry. Without
+// it the schema endpoints respond with 501 Not Implemented.
+func WithSchemaRegistry(registry *schema.SchemaRegistry) Option {
+	return func(s *Server) {
+		s.schemas = registry
+	}
+}
+
+// WithTracer starts a server span for every request, continuing traces
+// propagated by callers
+func WithTracer(tracer *tracing.Tracer) Option {
+	return func(s *Server) {
+		s.tracer = tracer
+	}
+}
+
+// WithMetrics serves registry to Prometheus at /metrics and samples of
+// process at /api/metrics/process. Without it both respond with 501 Not
+// Implemented.
+func WithMetrics(registry *metrics.Registry, process *metrics.ProcessCollector) Option {
+	return func(s *Server) {
+		s.metrics = registry
+		s.process = process
+	}
+}
+
+// WithEventBus streams the events published on bus to clients as
+// server-sent events and publishes flow lifecycle changes to it. Without it
+// the event streams respond with 501 Not Implemented.
+func WithEventBus(bus events.Bus) Option {
+	return func(s *Server) {
+		s.events = bus
+	}
+}
+
+// WithAlerting serves the alert rules and firing alerts of evaluator. Without
+// it the alerting endpoints respond with 501 Not Implemented.
+func WithAlerting(evaluator *alerting.Evaluator) Option {
+	return func(s *Server) {
+		s.alerts = evaluator
+	}
+}
+
+// WithAdminToken enables the admin endpoints for requests presenting token,
+// as a bearer token or in the X-Admin-Token header
+func WithAdminToken(token string) Option {
+	return func(s *Server) {
+		s.adminToken = token
+	}
+}
+
+// WithAPIKeys requires the flow, event, alerting, metrics and schema
+// endpoints to be called with one of keys, in the X-API-Key header or as a
+// bearer token. Without keys those endpoints are open.
+func WithAPIKeys(keys ...string) Option {
+	return func(s *Server) {
+		s.apiKeys = keys
+	}
+}
+
+// WithConfig serves the configuration that current returns, with secrets
+// redacted, at /admin/config. Without it the endpoint responds with 501
+// Not Implemented.
+func WithConfig(current func() *config.Config) Option {
+	return func(s *Server) {
+		s.config = current
+	}
+}
+
+// WithLimits bounds the work done within a request. Zero fields keep the
+// values of DefaultLimits.
+func WithLimits(limits Limits) Option {
+	return func(s *Server) {
+		if limits.DryRunMessages > 0 {
+			s.limits.DryRunMessages = limits.DryRunMessages
+		}
+		if limits.DryRunTimeout > 0 {
+			s.limits.DryRunTimeout = limits.DryRunTimeout
+		}
+		if limits.MaxCallDepth > 0 {
+			s.limits.MaxCallDepth = limits.MaxCallDepth
+		}
+		if limits.MaxPayloadBytes > 0 {
+			s.limits.MaxPayloadBytes = limits.MaxPayloadBytes
+		}
+		s.limits.SpillDir = limits.SpillDir
+	}
+}
+
+// WithDataDir lets the Replay nodes of simulations and simulated dry runs
+// send the files in dir, and backfills read them. Without it they send
+// and read nothing, since their paths come from flow source and requests.
+func WithDataDir(dir string) Option {
+	return func(s *Server) {
+		s.dataDir = dir
+	}
+}
+
+// WithLookups lets the http and sql sources of Enrich nodes reach the URLs
+// and databases of lookups, and backfill queries run on its databases.
+// Without it, flows with such nodes cannot run.
+func WithLookups(lookups engine.Lookups) Option {
+	return func(s *Server) {
+		s.lookups = lookups
+	}
+}
+
+// WithSecrets resolves the ${secret.NAME} references in the settings of
+// the nodes of the flows the server runs from provider. Without it, the
+// references are left as they are.
+func WithSecrets(provider secrets.Provider) Option {
+	return func(s *Server) {
+		s.secrets = provider
+	}
+}
+
+// WithCompression compresses the payloads dry runs spill to disk with the
+// codec policy chooses for their flow
+func WithCompression(policy compression.Policy) Option {
+	return func(s *Server) {
+		s.compression = policy
+	}
+}
+
+// WithFaults enables fault injection, injecting the faults of each flow
+// ID in flows into its dry runs. Dry run requests can then also ask for
+// their own faults. Servers in production should never enable it.
+func WithFaults(flows map[string]engine.Faults) Option {
+	return func(s *Server) {
+		s.faults = make(map[string]engine.Faults, len(flows))
+		for id, faults := range flows {
+			s.faults[id] = faults
+		}
+	}
+}
+
+// WithTemplates serves library from the template endpoints instead of the
+// built-in templates
+func WithTemplates(library *templates.Library) Option {
+	return func(s *Server) {
+		s.templates = library
+	}
+}
+
+// WithNodeRegistry creates the nodes of dry runs from registry and
+// describes its node types in the node catalog, instead of the built-in
+// node types
+func WithNodeRegistry(registry *engine.Registry) Option {
+	return func(s *Server) {
+		s.nodes = registry
+	}
+}
+
+// WithRestart serves POST /admin/restart, which calls request to restart
+// the server. request returns restart.ErrInProgress while a restart is under
+// way. Without it the endpoint responds with 501 Not Implemented.
+func WithRestart(request func() error) Option {
+	return func(s *Server) {
+		s.restart = request
+	}
+}
+
+// WithCluster reports the leadership elector campaigns for at /cluster.
+// Without it the endpoint responds with 501 Not Implemented.
+func WithCluster(elector *cluster.Elector) Option {
+	return func(s *Server) {
+		s.cluster = elector
+	}
 }
 
 // New creates a new Server instance
-func New(s *store.Store, log types.Logger) *Server {
+func New(s store.Store, log types.Logger, opts ...Option) *Server {
 	srv := &Server{
-		router: chi.NewRouter(),
-		store:  s,
-		log:    log,
+		router:        chi.NewRouter(),
+		store:         s,
+		log:           log,
+		limits:        DefaultLimits(),
+		templates:     templates.Builtin(),
+		nodes:         engine.NewRegistry(),
+		backfills:     map[string]*backfillRun{},
+		backfillQueue: map[string][]*backfillRun{},
+		flowRuns:      map[string]*engine.Engine{},
+	}
+	for _, opt := range opts {
+		opt(srv)
 	}
 
 	srv.setupRoutes()
@@ -45,8 +287,13 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) setupRoutes() {
 	// Add middleware
+	s.router.Use(middleware.RequestID)
 	s.router.Use(middleware.Logger)
 	s.router.Use(middleware.Recoverer)
+	s.router.Use(s.correlate)
+	if s.tracer != nil {
+		s.router.Use(s.tracer.Middleware)
+	}
 
 	// API routes
 	s.router.Route("/api", func(r chi.Router) {
@@ -55,13 +302,132 @@ func (s *Server) setupRoutes() {
 			httpSwagger.URL("/api/swagger/doc.json"),
 		))
 
-		// Flow routes
-		r.Route("/flows", func(r chi.Router) {
-			r.Get("/", s.handleListFlows)
-			r.Post("/", s.handleCreateFlow)
-			r.Get("/{id}", s.handleGetFlow)
-			r.Put("/{id}", s.handleUpdateFlow)
-			r.Delete("/{id}", s.handleDeleteFlow)
+		// Admin routes, all guarded by the admin token
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(s.requireAdmin)
+			r.Get("/backup", s.handleBackup)
+			r.Post("/restore", s.handleRestore)
+			r.Get("/log-level", s.handleGetLogLevel)
+			r.Put("/log-level", s.handleSetLogLevel)
+			s.diagnosticsRoutes(r)
+		})
+
+		// Routes guarded by the API keys
+		r.Group(func(r chi.Router) {
+			r.Use(s.requireAPIKey)
+
+			// Flow routes
+			r.Route("/flows", func(r chi.Router) {
+				r.Get("/", s.handleListFlows)
+				r.Post("/", s.handleCreateFlow)
+				r.Get("/search", s.handleSearchFlows)
+				r.Post("/diff", s.handleDiffSources)
+				r.Post("/validate", s.handleValidateSource)
+				r.Post("/run", s.handleDryRun)
+				r.Get("/dependencies", s.handleFlowDependencyGraph)
+				r.Get("/{id}", s.handleGetFlow)
+				r.Put("/{id}", s.handleUpdateFlow)
+				r.Delete("/{id}", s.handleDeleteFlow)
+				r.Get("/{id}/events", s.handleFlowEvents)
+				r.Get("/{id}/graph", s.handleFlowGraph)
+				r.Get("/{id}/dependencies", s.handleFlowDependencies)
+				r.Get("/{id}/diff", s.handleDiffFlowVersions)
+				r.Post("/{id}/start", s.handleStartFlow)
+				r.Post("/{id}/stop", s.handleStopFlow)
+				r.Get("/{id}/backfills", s.handleListBackfills)
+				r.Post("/{id}/backfills", s.handleStartBackfill)
+				r.Get("/{id}/backfills/{run}", s.handleGetBackfill)
+				r.Post("/{id}/simulations", s.handleStartSimulation)
+				r.Get("/{id}/nodes", s.handleListFlowNodes)
+				r.Post("/{id}/nodes/{node}/pause", s.handlePauseNode)
+				r.Post("/{id}/nodes/{node}/resume", s.handleResumeNode)
+				r.Get("/{id}/breakpoints", s.handleListBreakpoints)
+				r.Put("/{id}/breakpoints/{node}", s.handleSetBreakpoint)
+				r.Delete("/{id}/breakpoints/{node}", s.handleClearBreakpoint)
+
+				// Tag routes
+				r.Get("/{id}/tags", s.handleGetFlowTags)
+				r.Put("/{id}/tags", s.handleSetFlowTags)
+				r.Post("/{id}/tags", s.handleAddFlowTags)
+				r.Delete("/{id}/tags/{tag}", s.handleRemoveFlowTag)
+
+				// Step routes
+				r.Get("/{id}/steps", s.handleListFlowSteps)
+				r.Post("/{id}/steps", s.handleCreateFlowStep)
+				r.Put("/{id}/steps/order", s.handleReorderFlowSteps)
+				r.Post("/{id}/steps/sync", s.handleSyncFlowSteps)
+				r.Get("/{id}/steps/{step}", s.handleGetFlowStep)
+				r.Put("/{id}/steps/{step}", s.handleUpdateFlowStep)
+				r.Delete("/{id}/steps/{step}", s.handleDeleteFlowStep)
+			})
+
+			// Event stream routes
+			r.Get("/events", s.handleEvents)
+
+			// Run routes
+			r.Get("/runs/{run}/logs", s.handleListRunLogs)
+			r.Get("/runs/{run}/timeline", s.handleRunTimeline)
+
+			// Approvals of the messages Approval nodes hold
+			r.Route("/approvals", func(r chi.Router) {
+				r.Get("/", s.handleListApprovals)
+				r.Post("/{approval}/approve", s.handleApprove)
+				r.Post("/{approval}/reject", s.handleReject)
+				r.Get("/{approval}/audit", s.handleApprovalAudit)
+			})
+
+			// Messages stopped at breakpoints
+			r.Route("/breaks", func(r chi.Router) {
+				r.Get("/", s.handleListBreaks)
+				r.Get("/{break}", s.handleGetBreak)
+				r.Put("/{break}", s.handleEditBreak)
+				r.Post("/{break}/step", s.handleStepBreak)
+				r.Post("/{break}/continue", s.handleContinueBreak)
+			})
+
+			// Project quotas
+			r.Get("/quotas", s.handleListQuotas)
+
+			// Cluster routes
+			r.Get("/cluster", s.handleClusterStatus)
+			r.Get("/cluster/runs", s.handleListRunPlacements)
+
+			// Alerting routes
+			r.Route("/alerts", func(r chi.Router) {
+				r.Use(s.requireAlerting)
+				r.Get("/", s.handleListAlerts)
+				r.Get("/rules", s.handleListAlertRules)
+				r.Post("/rules", s.handleCreateAlertRule)
+				r.Put("/rules/{id}", s.handleUpdateAlertRule)
+				r.Delete("/rules/{id}", s.handleDeleteAlertRule)
+			})
+
+			// Metrics routes
+			r.Get("/metrics/process", s.handleProcessMetrics)
+
+			// Schema registry routes
+			r.Route("/v1/schemas", func(r chi.Router) {
+				r.Use(s.requireSchemas)
+				r.Get("/", s.handleListSchemas)
+				r.Post("/", s.handleRegisterSchema)
+				r.Get("/{type}", s.handleListSchemaVersions)
+				r.Get("/{type}/compatibility", s.handleCheckSchemaCompatibility)
+				r.Get("/{type}/{version}", s.handleGetSchema)
+				r.Post("/{type}/{version}/validate", s.handleValidateSchema)
+			})
+
+			// Template library routes
+			r.Route("/v1/templates", func(r chi.Router) {
+				r.Get("/", s.handleListTemplates)
+				r.Get("/{id}", s.handleGetTemplate)
+				r.Post("/{id}/instantiate", s.handleInstantiateTemplate)
+			})
+
+			// Node catalog routes
+			r.Route("/v1/nodes", func(r chi.Router) {
+				r.Get("/", s.handleListNodeTypes)
+				r.Get("/{type}", s.handleGetNodeType)
+			})
 		})
 	})
 
@@ -70,6 +436,9 @@ func (s *Server) setupRoutes() {
 		http.Redirect(w, r, "/docs", http.StatusFound)
 	})
 
+	// Prometheus scrape endpoint
+	s.router.Get("/metrics", s.handlePrometheus)
+
 	// Internal management routes
 	s.router.Route("/_internal", func(r chi.Router) {
 		r.Post("/shutdown", s.handleShutdown)
@@ -91,7 +460,7 @@ func (s *Server) handleShutdown(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Log shutdown request
-	s.log.Info("Received shutdown request", types.Fields{
+	s.requestLog(r).Info("Received shutdown request", types.Fields{
 		"remote_addr": r.RemoteAddr,
 	})
 
@@ -101,7 +470,7 @@ func (s *Server) handleShutdown(w http.ResponseWriter, r *http.Request) {
 	// Trigger shutdown in a goroutine
 	go func() {
 		time.Sleep(100 * time.Millisecond) // Give time for response to be sent
-		s.log.Info("Initiating server shutdown", nil)
+		s.requestLog(r).Info("Initiating server shutdown", nil)
 		os.Exit(0)
 	}()
 }
@@ -112,16 +481,19 @@ func (s *Server) Mount(path string, handler http.Handler) {
 }
 
 // @Summary List all flows
-// @Description Get a list of all flows
+// @Description Get a list of all flows, optionally filtered by tags
 // @Tags flows
 // @Accept json
 // @Produce json
+// @Param tag query []string false "Only return flows carrying every given tag" collectionFormat(multi)
 // @Success 200 {array} types.RuntimeFlow
 // @Router /flows [get]
 func (s *Server) handleListFlows(w http.ResponseWriter, r *http.Request) {
-	flows, err := s.store.ListFlows()
+	flows, err := s.store.ListFlows(store.FlowFilter{
+		Tags: r.URL.Query()["tag"],
+	})
 	if err != nil {
-		s.log.Error("Failed to list flows", err, types.Fields{
+		s.requestLog(r).Error("Failed to list flows", err, types.Fields{
 			"function": "handleListFlows",
 		})
 		http.Error(w, "Failed to list flows", http.StatusInternalServerError)
@@ -130,7 +502,7 @@ func (s *Server) handleListFlows(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(flows); err != nil {
-		s.log.Error("Failed to encode flows", err, types.Fields{
+		s.requestLog(r).Error("Failed to encode flows", err, types.Fields{
 			"function": "handleListFlows",
 		})
 		http.Error(w, "Failed to encode flows", http.StatusInternalServerError)
@@ -138,6 +510,47 @@ func (s *Server) handleListFlows(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// @Summary Search flows
+// @Description Full-text search over flow names, descriptions and configs
+// @Tags flows
+// @Produce json
+// @Param q query string true "Search query"
+// @Param limit query int false "Maximum number of results"
+// @Success 200 {array} store.FlowSearchResult
+// @Failure 400 {string} string "Invalid search parameters"
+// @Router /flows/search [get]
+func (s *Server) handleSearchFlows(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	fields := types.Fields{
+		"function": "handleSearchFlows",
+		"query":    query,
+	}
+
+	if strings.TrimSpace(query) == "" {
+		http.Error(w, "Missing search query", http.StatusBadRequest)
+		return
+	}
+
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	results, err := s.store.SearchFlows(query, limit)
+	if err != nil {
+		s.requestLog(r).Error("Failed to search flows", err, fields)
+		http.Error(w, "Failed to search flows", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusOK, results, fields)
+}
+
 // @Summary Create a new flow
 // @Description Create a new flow with the provided configuration
 // @Tags flows
@@ -145,30 +558,39 @@ func (s *Server) handleListFlows(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param flow body types.RuntimeFlow true "Flow configuration"
 // @Success 201 {object} types.RuntimeFlow
+// @Failure 422 {string} string "Flow calls itself"
 // @Router /flows [post]
 func (s *Server) handleCreateFlow(w http.ResponseWriter, r *http.Request) {
 	var flow types.RuntimeFlow
 	if err := json.NewDecoder(r.Body).Decode(&flow); err != nil {
-		s.log.Error("Failed to decode flow", err, types.Fields{
+		s.requestLog(r).Error("Failed to decode flow", err, types.Fields{
 			"function": "handleCreateFlow",
 		})
 		http.Error(w, "Invalid flow data", http.StatusBadRequest)
 		return
 	}
+	if s.rejectCallCycle(w, r, &flow, types.Fields{"function": "handleCreateFlow", "flow_id": flow.ID}) {
+		return
+	}
 
-	if err := s.store.CreateFlow(&flow); err != nil {
-		s.log.Error("Failed to create flow", err, types.Fields{
+	if err := s.store.CreateFlowContext(r.Context(), &flow); err != nil {
+		s.requestLog(r).Error("Failed to create flow", err, types.Fields{
 			"function": "handleCreateFlow",
 			"flow_id":  flow.ID,
 		})
 		http.Error(w, "Failed to create flow", http.StatusInternalServerError)
 		return
 	}
+	s.publish(r, types.FlowEvent{
+		FlowID:  flow.ID,
+		Type:    events.TypeFlowCreated,
+		Message: "Flow created",
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(flow); err != nil {
-		s.log.Error("Failed to encode flow", err, types.Fields{
+		s.requestLog(r).Error("Failed to encode flow", err, types.Fields{
 			"function": "handleCreateFlow",
 			"flow_id":  flow.ID,
 		})
@@ -189,7 +611,7 @@ func (s *Server) handleGetFlow(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	flow, err := s.store.GetFlow(id)
 	if err != nil {
-		s.log.Error("Failed to get flow", err, types.Fields{
+		s.requestLog(r).Error("Failed to get flow", err, types.Fields{
 			"function": "handleGetFlow",
 			"flow_id":  id,
 		})
@@ -199,7 +621,7 @@ func (s *Server) handleGetFlow(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(flow); err != nil {
-		s.log.Error("Failed to encode flow", err, types.Fields{
+		s.requestLog(r).Error("Failed to encode flow", err, types.Fields{
 			"function": "handleGetFlow",
 			"flow_id":  id,
 		})
@@ -209,19 +631,22 @@ func (s *Server) handleGetFlow(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary Update a flow
-// @Description Update an existing flow's configuration
+// @Description Update an existing flow's configuration. The body must carry the flow's current revision.
 // @Tags flows
 // @Accept json
 // @Produce json
 // @Param id path string true "Flow ID"
 // @Param flow body types.RuntimeFlow true "Updated flow configuration"
 // @Success 200 {object} types.RuntimeFlow
+// @Failure 404 {string} string "Flow not found"
+// @Failure 409 {string} string "Flow revision conflict"
+// @Failure 422 {string} string "Flow calls itself"
 // @Router /flows/{id} [put]
 func (s *Server) handleUpdateFlow(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	var flow types.RuntimeFlow
 	if err := json.NewDecoder(r.Body).Decode(&flow); err != nil {
-		s.log.Error("Failed to decode flow", err, types.Fields{
+		s.requestLog(r).Error("Failed to decode flow", err, types.Fields{
 			"function": "handleUpdateFlow",
 			"flow_id":  id,
 		})
@@ -230,18 +655,26 @@ func (s *Server) handleUpdateFlow(w http.ResponseWriter, r *http.Request) {
 	}
 
 	flow.ID = id
-	if err := s.store.UpdateFlow(&flow); err != nil {
-		s.log.Error("Failed to update flow", err, types.Fields{
+	if s.rejectCallCycle(w, r, &flow, types.Fields{"function": "handleUpdateFlow", "flow_id": id}) {
+		return
+	}
+	if err := s.store.UpdateFlowContext(r.Context(), &flow); err != nil {
+		s.handleStoreError(w, r, err, "Failed to update flow", types.Fields{
 			"function": "handleUpdateFlow",
 			"flow_id":  id,
 		})
-		http.Error(w, "Failed to update flow", http.StatusInternalServerError)
 		return
 	}
+	s.publish(r, types.FlowEvent{
+		FlowID:  id,
+		Type:    events.TypeFlowUpdated,
+		Message: "Flow updated",
+		Data:    map[string]interface{}{"revision": flow.Revision},
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(flow); err != nil {
-		s.log.Error("Failed to encode flow", err, types.Fields{
+		s.requestLog(r).Error("Failed to encode flow", err, types.Fields{
 			"function": "handleUpdateFlow",
 			"flow_id":  id,
 		})
@@ -261,13 +694,181 @@ func (s *Server) handleUpdateFlow(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleDeleteFlow(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if err := s.store.DeleteFlow(id); err != nil {
-		s.log.Error("Failed to delete flow", err, types.Fields{
+		s.requestLog(r).Error("Failed to delete flow", err, types.Fields{
 			"function": "handleDeleteFlow",
 			"flow_id":  id,
 		})
 		http.Error(w, "Failed to delete flow", http.StatusInternalServerError)
 		return
 	}
+	s.stopBackfills(id)
+	s.publish(r, types.FlowEvent{
+		FlowID:  id,
+		Type:    events.TypeFlowDeleted,
+		Message: "Flow deleted",
+	})
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// @Summary Start a flow
+// @Description Mark a flow as running with the values of the parameters it declares, which replace those it was started with before. The body may be omitted when every parameter has a default. Flows are refused with 429 while their project, named by their project:NAME tag, has as many runs as its quota allows or its run data is at its storage quota.
+// @Tags flows
+// @Accept json
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Param request body api.StartFlowRequest false "Parameter values"
+// @Success 200 {object} types.RuntimeFlow
+// @Failure 400 {string} string "Invalid parameters"
+// @Failure 404 {string} string "Flow not found"
+// @Failure 429 {string} string "Project quota exceeded"
+// @Router /flows/{id}/start [post]
+func (s *Server) handleStartFlow(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function": "handleStartFlow",
+		"flow_id":  id,
+	}
+
+	var req api.StartFlowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid start request", http.StatusBadRequest)
+		return
+	}
+	flow, err := s.store.GetFlow(id)
+	if err != nil {
+		s.handleStoreError(w, r, err, "Failed to get flow", fields)
+		return
+	}
+
+	// Flows whose config is not Flow source declare no parameters
+	var params []engine.Param
+	if graph, err := engine.LoadSource(flow.Config, "", s.requestLog(r)); err == nil {
+		params = graph.Params
+	}
+	if _, err := engine.ResolveParams(params, req.Params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Starting a running flow again only changes its parameters
+	project := s.flowProject(flow.Tags)
+	s.backfillMu.Lock()
+	if flow.Status != types.FlowStatusRunning {
+		err = s.checkStorage(project)
+		if err == nil {
+			err = s.checkRuns(project, id)
+		}
+	}
+	if err == nil {
+		err = s.store.StartFlow(id, req.Params)
+	}
+	s.backfillMu.Unlock()
+	var exceeded *quotaError
+	if errors.As(err, &exceeded) {
+		s.quotaExceeded(r.Context(), s.requestLog(r), id, exceeded)
+		http.Error(w, "Quota exceeded: "+err.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	s.setFlowStatus(w, r, types.FlowStatusRunning, events.TypeFlowStarted, "Flow started", func() error {
+		return err
+	})
+}
+
+// @Summary Stop a flow
+// @Description Mark a flow as stopped, stopping its running backfills
+// @Tags flows
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Success 200 {object} types.RuntimeFlow
+// @Failure 404 {string} string "Flow not found"
+// @Router /flows/{id}/stop [post]
+func (s *Server) handleStopFlow(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	s.stopBackfills(id)
+	s.setFlowStatus(w, r, types.FlowStatusStopped, events.TypeFlowStopped, "Flow stopped", func() error {
+		return s.store.UpdateFlowStatus(id, types.FlowStatusStopped)
+	})
+}
+
+// setFlowStatus changes the status of the flow in the URL with update,
+// publishes eventType and responds with the updated flow
+func (s *Server) setFlowStatus(w http.ResponseWriter, r *http.Request, status, eventType, message string, update func() error) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function": "setFlowStatus",
+		"flow_id":  id,
+		"status":   status,
+	}
+
+	if err := update(); err != nil {
+		s.handleStoreError(w, r, err, "Failed to update flow status", fields)
+		return
+	}
+	s.publish(r, types.FlowEvent{
+		FlowID:  id,
+		Type:    eventType,
+		Message: message,
+	})
+
+	flow, err := s.store.GetFlow(id)
+	if err != nil {
+		s.handleStoreError(w, r, err, "Failed to get flow", fields)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, flow, fields)
+}
+
+// writeJSON encodes v as the JSON response body with the given status code
+func (s *Server) writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}, fields types.Fields) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.requestLog(r).Error("Failed to encode response", err, fields)
+	}
+}
+
+// correlate tags the request context with its request ID, so that every
+// entry logged through requestLog while handling it carries the ID. The ID
+// is echoed in the X-Request-Id response header.
+func (s *Server) correlate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := middleware.GetReqID(r.Context())
+		w.Header().Set(middleware.RequestIDHeader, id)
+		ctx := logger.ContextWithFields(r.Context(), types.Fields{logger.FieldRequestID: id})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestLog returns the logger for a request, adding the correlation
+// fields of its context when the logger supports them
+func (s *Server) requestLog(r *http.Request) types.Logger {
+	if port, ok := s.log.(types.LogPort); ok {
+		return port.WithContext(r.Context())
+	}
+	return s.log
+}
+
+// handleStoreError maps store errors to HTTP responses
+func (s *Server) handleStoreError(w http.ResponseWriter, r *http.Request, err error, msg string, fields types.Fields) {
+	switch {
+	case errors.Is(err, store.ErrFlowNotFound):
+		http.Error(w, "Flow not found", http.StatusNotFound)
+	case errors.Is(err, store.ErrTagNotFound):
+		http.Error(w, "Tag not found", http.StatusNotFound)
+	case errors.Is(err, store.ErrStepNotFound):
+		http.Error(w, "Step not found", http.StatusNotFound)
+	case errors.Is(err, store.ErrAlertRuleNotFound):
+		http.Error(w, "Alert rule not found", http.StatusNotFound)
+	case errors.Is(err, store.ErrInvalidStepOrder):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, store.ErrInvalidFlowConfig):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, store.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		s.requestLog(r).Error(msg, err, fields)
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"flow-control/internal/types"
+	"flow-control/pkg/api"
+)
+
+// @Summary Start a simulation
+// @Description Run a stored flow on the synthetic data of its Generate and Replay nodes in the background, to load-test it before it is connected to real sources. The run is a backfill flagged as a simulation: it is listed and polled with the backfills of the flow, counts toward the quotas of its project, but leaves the status of the flow alone and does not count toward its concurrency. It completes once every source sent its messages.
+// @Tags flows
+// @Accept json
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Param request body api.SimulationRequest true "Parameter values"
+// @Success 202 {object} api.Backfill
+// @Failure 400 {string} string "Invalid parameters or breakpoints"
+// @Failure 404 {string} string "Flow not found"
+// @Failure 422 {string} string "Flow config is not Flow source or has no sources"
+// @Failure 429 {string} string "Project quota exceeded"
+// @Failure 503 {string} string "Server is restarting"
+// @Router /flows/{id}/simulations [post]
+func (s *Server) handleStartSimulation(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function": "handleStartSimulation",
+		"flow_id":  id,
+	}
+
+	var req api.SimulationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid simulation request", http.StatusBadRequest)
+		return
+	}
+	s.startBackfill(w, r, id, api.BackfillRequest{
+		Params:      req.Params,
+		Timeline:    req.Timeline,
+		Breakpoints: req.Breakpoints,
+	}, true, fields)
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"flow-control/internal/types"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// @Summary List flow steps
+// @Description Get the steps of a flow in execution order
+// @Tags steps
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Success 200 {array} types.FlowStep
+// @Failure 404 {string} string "Flow not found"
+// @Router /flows/{id}/steps [get]
+func (s *Server) handleListFlowSteps(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function": "handleListFlowSteps",
+		"flow_id":  id,
+	}
+
+	steps, err := s.store.ListFlowSteps(id)
+	if err != nil {
+		s.handleStoreError(w, r, err, "Failed to list flow steps", fields)
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusOK, steps, fields)
+}
+
+// @Summary Create a flow step
+// @Description Insert a step at its position, or append it when no position is given
+// @Tags steps
+// @Accept json
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Param step body types.FlowStep true "Step"
+// @Success 201 {object} types.FlowStep
+// @Failure 404 {string} string "Flow not found"
+// @Router /flows/{id}/steps [post]
+func (s *Server) handleCreateFlowStep(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function": "handleCreateFlowStep",
+		"flow_id":  id,
+	}
+
+	var step types.FlowStep
+	if err := json.NewDecoder(r.Body).Decode(&step); err != nil {
+		s.requestLog(r).Error("Failed to decode step", err, fields)
+		http.Error(w, "Invalid step data", http.StatusBadRequest)
+		return
+	}
+	if step.ID == "" {
+		http.Error(w, "Step ID is required", http.StatusBadRequest)
+		return
+	}
+
+	step.FlowID = id
+	if err := s.store.CreateFlowStep(&step); err != nil {
+		s.handleStoreError(w, r, err, "Failed to create flow step", fields)
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusCreated, step, fields)
+}
+
+// @Summary Get a flow step
+// @Description Get a single step of a flow
+// @Tags steps
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Param step path string true "Step ID"
+// @Success 200 {object} types.FlowStep
+// @Failure 404 {string} string "Step not found"
+// @Router /flows/{id}/steps/{step} [get]
+func (s *Server) handleGetFlowStep(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	stepID := chi.URLParam(r, "step")
+	fields := types.Fields{
+		"function": "handleGetFlowStep",
+		"flow_id":  id,
+		"step_id":  stepID,
+	}
+
+	step, err := s.store.GetFlowStep(id, stepID)
+	if err != nil {
+		s.handleStoreError(w, r, err, "Failed to get flow step", fields)
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusOK, step, fields)
+}
+
+// @Summary Update a flow step
+// @Description Update the type and config of a step
+// @Tags steps
+// @Accept json
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Param step path string true "Step ID"
+// @Param body body types.FlowStep true "Step"
+// @Success 200 {object} types.FlowStep
+// @Failure 404 {string} string "Step not found"
+// @Router /flows/{id}/steps/{step} [put]
+func (s *Server) handleUpdateFlowStep(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	stepID := chi.URLParam(r, "step")
+	fields := types.Fields{
+		"function": "handleUpdateFlowStep",
+		"flow_id":  id,
+		"step_id":  stepID,
+	}
+
+	var step types.FlowStep
+	if err := json.NewDecoder(r.Body).Decode(&step); err != nil {
+		s.requestLog(r).Error("Failed to decode step", err, fields)
+		http.Error(w, "Invalid step data", http.StatusBadRequest)
+		return
+	}
+
+	step.FlowID = id
+	step.ID = stepID
+	if err := s.store.UpdateFlowStep(&step); err != nil {
+		s.handleStoreError(w, r, err, "Failed to update flow step", fields)
+		return
+	}
+
+	updated, err := s.store.GetFlowStep(id, stepID)
+	if err != nil {
+		s.handleStoreError(w, r, err, "Failed to get flow step", fields)
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusOK, updated, fields)
+}
+
+// @Summary Delete a flow step
+// @Description Remove a step from a flow
+// @Tags steps
+// @Param id path string true "Flow ID"
+// @Param step path string true "Step ID"
+// @Success 204 "No Content"
+// @Failure 404 {string} string "Step not found"
+// @Router /flows/{id}/steps/{step} [delete]
+func (s *Server) handleDeleteFlowStep(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	stepID := chi.URLParam(r, "step")
+	fields := types.Fields{
+		"function": "handleDeleteFlowStep",
+		"flow_id":  id,
+		"step_id":  stepID,
+	}
+
+	if err := s.store.DeleteFlowStep(id, stepID); err != nil {
+		s.handleStoreError(w, r, err, "Failed to delete flow step", fields)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Reorder flow steps
+// @Description Set the order of a flow's steps; the body must list every step ID once
+// @Tags steps
+// @Accept json
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Param order body []string true "Step IDs in execution order"
+// @Success 200 {array} types.FlowStep
+// @Failure 400 {string} string "Invalid step order"
+// @Router /flows/{id}/steps/order [put]
+func (s *Server) handleReorderFlowSteps(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function": "handleReorderFlowSteps",
+		"flow_id":  id,
+	}
+
+	var order []string
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		s.requestLog(r).Error("Failed to decode step order", err, fields)
+		http.Error(w, "Invalid step order", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.ReorderFlowSteps(id, order); err != nil {
+		s.handleStoreError(w, r, err, "Failed to reorder flow steps", fields)
+		return
+	}
+
+	s.handleListFlowSteps(w, r)
+}
+
+// @Summary Sync flow steps
+// @Description Rebuild a flow's steps from the node definitions in its config
+// @Tags steps
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Success 200 {array} types.FlowStep
+// @Failure 404 {string} string "Flow not found"
+// @Router /flows/{id}/steps/sync [post]
+func (s *Server) handleSyncFlowSteps(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function": "handleSyncFlowSteps",
+		"flow_id":  id,
+	}
+
+	steps, err := s.store.SyncFlowSteps(id)
+	if err != nil {
+		s.handleStoreError(w, r, err, "Failed to sync flow steps", fields)
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusOK, steps, fields)
+}
@@ -15,7 +15,7 @@ import (
 func TestSwaggerEndpoint(t *testing.T) {
 	// Create test dependencies
 	log := logger.New()
-	st := &store.Store{} // Empty store is fine for this test
+	st := &store.SQLiteStore{} // Empty store is fine for this test
 
 	// Create a test server
 	srv := server.New(st, log)
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"flow-control/internal/types"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// @Summary Get flow tags
+// @Description Get the tags attached to a flow
+// @Tags flows
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Success 200 {array} string
+// @Failure 404 {string} string "Flow not found"
+// @Router /flows/{id}/tags [get]
+func (s *Server) handleGetFlowTags(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function": "handleGetFlowTags",
+		"flow_id":  id,
+	}
+
+	tags, err := s.store.GetFlowTags(id)
+	if err != nil {
+		s.handleStoreError(w, r, err, "Failed to get flow tags", fields)
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusOK, tags, fields)
+}
+
+// @Summary Replace flow tags
+// @Description Replace all tags attached to a flow
+// @Tags flows
+// @Accept json
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Param tags body []string true "Tags"
+// @Success 200 {array} string
+// @Failure 404 {string} string "Flow not found"
+// @Router /flows/{id}/tags [put]
+func (s *Server) handleSetFlowTags(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function": "handleSetFlowTags",
+		"flow_id":  id,
+	}
+
+	var tags []string
+	if err := json.NewDecoder(r.Body).Decode(&tags); err != nil {
+		s.requestLog(r).Error("Failed to decode tags", err, fields)
+		http.Error(w, "Invalid tag data", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.SetFlowTags(id, tags); err != nil {
+		s.handleStoreError(w, r, err, "Failed to set flow tags", fields)
+		return
+	}
+
+	s.handleGetFlowTags(w, r)
+}
+
+// @Summary Add flow tags
+// @Description Attach tags to a flow, keeping existing tags
+// @Tags flows
+// @Accept json
+// @Produce json
+// @Param id path string true "Flow ID"
+// @Param tags body []string true "Tags"
+// @Success 200 {array} string
+// @Failure 404 {string} string "Flow not found"
+// @Router /flows/{id}/tags [post]
+func (s *Server) handleAddFlowTags(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function": "handleAddFlowTags",
+		"flow_id":  id,
+	}
+
+	var tags []string
+	if err := json.NewDecoder(r.Body).Decode(&tags); err != nil {
+		s.requestLog(r).Error("Failed to decode tags", err, fields)
+		http.Error(w, "Invalid tag data", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.store.AddFlowTags(id, tags...); err != nil {
+		s.handleStoreError(w, r, err, "Failed to add flow tags", fields)
+		return
+	}
+
+	s.handleGetFlowTags(w, r)
+}
+
+// @Summary Remove a flow tag
+// @Description Detach a single tag from a flow
+// @Tags flows
+// @Param id path string true "Flow ID"
+// @Param tag path string true "Tag"
+// @Success 204 "No Content"
+// @Failure 404 {string} string "Flow or tag not found"
+// @Router /flows/{id}/tags/{tag} [delete]
+func (s *Server) handleRemoveFlowTag(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	tag := chi.URLParam(r, "tag")
+	fields := types.Fields{
+		"function": "handleRemoveFlowTag",
+		"flow_id":  id,
+		"tag":      tag,
+	}
+
+	if err := s.store.RemoveFlowTag(id, tag); err != nil {
+		s.handleStoreError(w, r, err, "Failed to remove flow tag", fields)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
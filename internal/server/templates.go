@@ -0,0 +1,158 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"flow-control/internal/events"
+	"flow-control/internal/templates"
+	"flow-control/internal/types"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// InstantiateTemplateRequest holds the parameters a template is
+// instantiated with
+type InstantiateTemplateRequest struct {
+	// Parameters map parameter names to their values; omitted parameters
+	// take their defaults
+	Parameters map[string]string `json:"parameters"`
+	// FlowID, when set, saves the instantiated flow under this ID. The name
+	// parameter then defaults to it, so that the flow is named by its ID.
+	FlowID string `json:"flow_id,omitempty"`
+	// FlowName is the name of the saved flow; the template's name when
+	// empty
+	FlowName string `json:"flow_name,omitempty"`
+}
+
+// InstantiateTemplateResult holds an instantiated template
+type InstantiateTemplateResult struct {
+	Source string `json:"source"`
+	// Flow is the saved flow, when the request asked for one
+	Flow *types.RuntimeFlow `json:"flow,omitempty"`
+}
+
+// @Summary List flow templates
+// @Description List the starter flows of the template library with their parameters
+// @Tags templates
+// @Produce json
+// @Success 200 {array} templates.Template
+// @Router /v1/templates [get]
+func (s *Server) handleListTemplates(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleListTemplates",
+	}
+
+	s.writeJSON(w, r, http.StatusOK, s.templates.List(), fields)
+}
+
+// @Summary Get a flow template
+// @Description Get a starter flow of the template library with its parameters and source
+// @Tags templates
+// @Produce json
+// @Param id path string true "Template ID"
+// @Success 200 {object} templates.Template
+// @Failure 404 {string} string "Template not found"
+// @Router /v1/templates/{id} [get]
+func (s *Server) handleGetTemplate(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function":    "handleGetTemplate",
+		"template_id": id,
+	}
+
+	tmpl, err := s.templates.Get(id)
+	if err != nil {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, tmpl, fields)
+}
+
+// @Summary Instantiate a flow template
+// @Description Replace the placeholders of a template with parameter values, returning the flow source and, with a flow_id, saving it as a new flow
+// @Tags templates
+// @Accept json
+// @Produce json
+// @Param id path string true "Template ID"
+// @Param request body InstantiateTemplateRequest true "Parameter values"
+// @Success 200 {object} InstantiateTemplateResult
+// @Success 201 {object} InstantiateTemplateResult
+// @Failure 400 {string} string "Invalid parameters"
+// @Failure 404 {string} string "Template not found"
+// @Failure 422 {string} string "Flow calls itself"
+// @Router /v1/templates/{id}/instantiate [post]
+func (s *Server) handleInstantiateTemplate(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	fields := types.Fields{
+		"function":    "handleInstantiateTemplate",
+		"template_id": id,
+	}
+
+	tmpl, err := s.templates.Get(id)
+	if err != nil {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+	var req InstantiateTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid instantiate request", http.StatusBadRequest)
+		return
+	}
+
+	values := map[string]string{}
+	for name, value := range req.Parameters {
+		values[name] = value
+	}
+	if _, ok := values["name"]; !ok && req.FlowID != "" {
+		for _, p := range tmpl.Parameters {
+			if p.Name == "name" {
+				values["name"] = req.FlowID
+			}
+		}
+	}
+	src, err := tmpl.Instantiate(values, s.requestLog(r))
+	if errors.Is(err, templates.ErrInvalidParameters) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.requestLog(r).Error("Failed to instantiate template", err, fields)
+		http.Error(w, "Failed to instantiate template", http.StatusInternalServerError)
+		return
+	}
+
+	result := InstantiateTemplateResult{Source: src}
+	if req.FlowID == "" {
+		s.writeJSON(w, r, http.StatusOK, result, fields)
+		return
+	}
+
+	flow := &types.RuntimeFlow{
+		ID:          req.FlowID,
+		Name:        req.FlowName,
+		Description: tmpl.Description,
+		Config:      src,
+	}
+	if flow.Name == "" {
+		flow.Name = tmpl.Name
+	}
+	fields["flow_id"] = flow.ID
+	if s.rejectCallCycle(w, r, flow, fields) {
+		return
+	}
+	if err := s.store.CreateFlowContext(r.Context(), flow); err != nil {
+		s.requestLog(r).Error("Failed to create flow", err, fields)
+		http.Error(w, "Failed to create flow", http.StatusInternalServerError)
+		return
+	}
+	s.publish(r, types.FlowEvent{
+		FlowID:  flow.ID,
+		Type:    events.TypeFlowCreated,
+		Message: "Flow created from template " + tmpl.ID,
+	})
+
+	result.Flow = flow
+	s.writeJSON(w, r, http.StatusCreated, result, fields)
+}
@@ -0,0 +1,57 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"flow-control/internal/server"
+	"flow-control/internal/templates"
+	"flow-control/internal/testsupport"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateAPI(t *testing.T) {
+	h := testsupport.New(t)
+	ctx := context.Background()
+
+	var list []templates.Template
+	require.NoError(t, h.Client.Do(ctx, http.MethodGet, "/api/v1/templates", nil, &list))
+	require.Len(t, list, len(templates.Builtin().List()))
+
+	var tmpl templates.Template
+	require.NoError(t, h.Client.Do(ctx, http.MethodGet, "/api/v1/templates/kafka-filter-forward", nil, &tmpl))
+	require.Equal(t, "kafka-filter-forward", tmpl.ID)
+	require.NotEmpty(t, tmpl.Parameters)
+
+	err := h.Client.Do(ctx, http.MethodGet, "/api/v1/templates/missing", nil, nil)
+	require.Equal(t, http.StatusNotFound, testsupport.StatusCode(err))
+
+	params := map[string]string{"source_topic": "orders", "field": "status", "value": "paid", "target_topic": "billing"}
+
+	// Without a flow ID the source is only returned
+	var result server.InstantiateTemplateResult
+	require.NoError(t, h.Client.Do(ctx, http.MethodPost, "/api/v1/templates/kafka-filter-forward/instantiate",
+		server.InstantiateTemplateRequest{Parameters: params}, &result))
+	require.Contains(t, result.Source, `"orders"`)
+	require.Nil(t, result.Flow)
+	validation, err := h.Client.Validate(ctx, result.Source)
+	require.NoError(t, err)
+	require.True(t, validation.Valid)
+
+	// With one the flow is saved, named by its ID
+	result = server.InstantiateTemplateResult{}
+	require.NoError(t, h.Client.Do(ctx, http.MethodPost, "/api/v1/templates/kafka-filter-forward/instantiate",
+		server.InstantiateTemplateRequest{Parameters: params, FlowID: "paid-orders"}, &result))
+	require.NotNil(t, result.Flow)
+	require.Contains(t, result.Source, `flow "paid-orders"`)
+	flow, err := h.Client.GetFlow(ctx, "paid-orders")
+	require.NoError(t, err)
+	require.Equal(t, result.Source, flow.Config)
+
+	// Bad parameters are rejected
+	err = h.Client.Do(ctx, http.MethodPost, "/api/v1/templates/kafka-filter-forward/instantiate",
+		server.InstantiateTemplateRequest{Parameters: map[string]string{"field": "status"}}, nil)
+	require.Equal(t, http.StatusBadRequest, testsupport.StatusCode(err))
+}
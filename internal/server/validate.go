@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"flow-control/internal/parser"
+	"flow-control/internal/parser/analyzer"
+	"flow-control/internal/parser/ast"
+	"flow-control/internal/parser/token"
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/types"
+	"flow-control/pkg/api"
+)
+
+// @Summary Validate flow source
+// @Description Parse and analyze Flow source, listing its syntax errors or, if it parses, the problems found by the analyzer. With an id, calls that would make stored flows call each other in a loop are errors too.
+// @Tags flows
+// @Accept json
+// @Produce json
+// @Param request body api.ValidateRequest true "Source to validate"
+// @Success 200 {object} api.ValidateResult
+// @Failure 400 {string} string "Invalid request"
+// @Router /flows/validate [post]
+func (s *Server) handleValidateSource(w http.ResponseWriter, r *http.Request) {
+	fields := types.Fields{
+		"function": "handleValidateSource",
+	}
+
+	var req api.ValidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid validate request", http.StatusBadRequest)
+		return
+	}
+
+	result := validateSource(req.Source, s.requestLog(r))
+	if result.Valid && req.ID != "" {
+		cycle, err := s.callCycle(r, req.ID, req.Source)
+		if err != nil {
+			s.requestLog(r).Error("Failed to check flow calls", err, fields)
+			http.Error(w, "Failed to check flow calls", http.StatusInternalServerError)
+			return
+		}
+		if cycle != nil {
+			pos := callPos(req.Source, cycle[1], s.requestLog(r))
+			result.Valid = false
+			result.Diagnostics = append(result.Diagnostics, api.SourceDiagnostic{
+				Line:     pos.Line,
+				Column:   pos.Column,
+				Severity: parser.SeverityError,
+				Message:  callCycleMessage(cycle),
+			})
+		}
+	}
+	s.writeJSON(w, r, http.StatusOK, result, fields)
+}
+
+// validateSource returns the syntax errors of src or, if it parses, the
+// problems found by the analyzer
+func validateSource(src string, log types.Logger) api.ValidateResult {
+	program, diagnostics := parser.Parse(src, log)
+	if len(diagnostics) == 0 {
+		diagnostics = analyzer.Analyze(program)
+	}
+
+	result := api.ValidateResult{
+		Valid:       !analyzer.HasErrors(diagnostics),
+		Diagnostics: make([]api.SourceDiagnostic, 0, len(diagnostics)),
+	}
+	for _, d := range diagnostics {
+		result.Diagnostics = append(result.Diagnostics, api.SourceDiagnostic{
+			Line:     d.Pos.Line,
+			Column:   d.Pos.Column,
+			Severity: d.Severity,
+			Message:  d.Message,
+		})
+	}
+	return result
+}
+
+// callPos returns the position of the first Call node of src that targets
+// the flow with id
+func callPos(src, id string, log types.Logger) token.Position {
+	program, _ := parser.Parse(src, log)
+	for _, stmt := range program.Statements {
+		flow, ok := stmt.(*ast.Flow)
+		if !ok {
+			continue
+		}
+		for _, stmt := range flow.Body.Statements {
+			node, ok := stmt.(*ast.FlowNode)
+			if !ok {
+				continue
+			}
+			settings := map[string]interface{}{}
+			for _, stmt := range node.Body.Statements {
+				if a, ok := stmt.(*ast.Assignment); ok {
+					settings[a.Name.Value] = ast.Value(a.Value)
+				}
+			}
+			if settings["type"] == engine.TypeCall && settings["target"] == id {
+				return node.Token.Pos
+			}
+		}
+	}
+	return token.Position{}
+}
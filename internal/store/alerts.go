@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// SaveAlertRule creates or replaces an alert rule. Rules without an ID are
+// given a random one. It returns ErrFlowNotFound if the rule's flow does not
+// exist.
+func (s *sqlStore) SaveAlertRule(rule *types.AlertRule) error {
+	if err := s.requireFlow(rule.FlowID); err != nil {
+		return err
+	}
+	if rule.ID == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("failed to generate alert rule ID: %w", err)
+		}
+		rule.ID = hex.EncodeToString(b)
+	}
+	rule.UpdatedAt = time.Now()
+
+	err := s.WithTx(context.Background(), func(tx *Tx) error {
+		var createdAt time.Time
+		err := tx.QueryRow(`SELECT created_at FROM alert_rules WHERE id = ?`, rule.ID).Scan(&createdAt)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			rule.CreatedAt = rule.UpdatedAt
+			_, err = tx.Exec(`
+				INSERT INTO alert_rules (id, flow_id, name, condition, threshold, window_seconds, enabled, created_at, updated_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, rule.ID, rule.FlowID, rule.Name, rule.Condition, rule.Threshold, rule.WindowSeconds,
+				rule.Enabled, rule.CreatedAt, rule.UpdatedAt)
+			return err
+		case err != nil:
+			return err
+		}
+
+		rule.CreatedAt = createdAt
+		_, err = tx.Exec(`
+			UPDATE alert_rules
+			SET flow_id = ?, name = ?, condition = ?, threshold = ?, window_seconds = ?, enabled = ?, updated_at = ?
+			WHERE id = ?
+		`, rule.FlowID, rule.Name, rule.Condition, rule.Threshold, rule.WindowSeconds,
+			rule.Enabled, rule.UpdatedAt, rule.ID)
+		return err
+	})
+	if err != nil {
+		s.log.Error("Failed to save alert rule", err, types.Fields{
+			"function": "SaveAlertRule",
+			"rule_id":  rule.ID,
+			"flow_id":  rule.FlowID,
+		})
+		return fmt.Errorf("failed to save alert rule: %w", err)
+	}
+	return nil
+}
+
+// ListAlertRules returns every alert rule, ordered by flow and creation
+func (s *sqlStore) ListAlertRules() ([]*types.AlertRule, error) {
+	rows, err := s.query(`
+		SELECT id, flow_id, name, condition, threshold, window_seconds, enabled, created_at, updated_at
+		FROM alert_rules
+		ORDER BY flow_id, created_at, id
+	`)
+	if err != nil {
+		s.log.Error("Failed to list alert rules", err, types.Fields{
+			"function": "ListAlertRules",
+		})
+		return nil, fmt.Errorf("failed to list alert rules: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.log.Error("Failed to close rows", err, types.Fields{
+				"function": "ListAlertRules",
+			})
+		}
+	}()
+
+	rules := []*types.AlertRule{}
+	for rows.Next() {
+		rule := &types.AlertRule{}
+		var name sql.NullString
+		err := rows.Scan(&rule.ID, &rule.FlowID, &name, &rule.Condition, &rule.Threshold,
+			&rule.WindowSeconds, &rule.Enabled, &rule.CreatedAt, &rule.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan alert rule: %w", err)
+		}
+		rule.Name = name.String
+		rules = append(rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list alert rules: %w", err)
+	}
+	return rules, nil
+}
+
+// DeleteAlertRule removes an alert rule. It returns ErrAlertRuleNotFound if
+// the rule does not exist.
+func (s *sqlStore) DeleteAlertRule(id string) error {
+	result, err := s.exec(`DELETE FROM alert_rules WHERE id = ?`, id)
+	if err != nil {
+		s.log.Error("Failed to delete alert rule", err, types.Fields{
+			"function": "DeleteAlertRule",
+			"rule_id":  id,
+		})
+		return fmt.Errorf("failed to delete alert rule: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: %s", ErrAlertRuleNotFound, id)
+	}
+	return nil
+}
@@ -0,0 +1,466 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"flow-control/internal/compression"
+	"flow-control/internal/types"
+)
+
+// Artifact kinds recorded for flow runs
+const (
+	ArtifactKindSample = "sample"
+	ArtifactKindDLQ    = "dlq"
+	ArtifactKindReplay = "replay"
+)
+
+var (
+	// ErrArtifactNotFound is returned when a run artifact does not exist
+	ErrArtifactNotFound = errors.New("artifact not found")
+	// ErrQuotaExceeded is returned when storing an artifact would exceed a size quota
+	ErrQuotaExceeded = errors.New("artifact quota exceeded")
+)
+
+// artifactColumns lists the run_artifacts metadata columns in scan order
+const artifactColumns = `id, flow_id, run_id, kind, name, content_type, size, sha256, encoding, stored_size, created_at`
+
+// ArtifactOptions configures an ArtifactStore
+type ArtifactOptions struct {
+	// Dir holds payloads too large to keep in the database
+	Dir string
+	// InlineLimit is the largest payload stored in the database itself;
+	// larger payloads spill over to Dir
+	InlineLimit int64
+	// MaxArtifactBytes caps the size of a single artifact; zero means no limit
+	MaxArtifactBytes int64
+	// MaxFlowBytes caps the total size of a flow's artifacts; zero means no limit
+	MaxFlowBytes int64
+	// Compression chooses the codec compressing the payloads of each flow.
+	// Payloads are compressed before InlineLimit applies, and decompressed
+	// transparently when opened; sizes and checksums are those of the
+	// uncompressed payload.
+	Compression compression.Policy
+	// Metrics, when set, records the sizes of the payloads stored and of
+	// their compressed forms
+	Metrics types.MetricsPort
+}
+
+// DefaultArtifactOptions returns the default artifact store options
+func DefaultArtifactOptions() ArtifactOptions {
+	return ArtifactOptions{
+		Dir:              "data/artifacts",
+		InlineLimit:      256 << 10, // Keep small samples in the database
+		MaxArtifactBytes: 64 << 20,  // Bound replay bundles
+		MaxFlowBytes:     1 << 30,   // Bound each flow's footprint
+	}
+}
+
+// ArtifactStore stores run artifacts. Small payloads live in the
+// run_artifacts table; larger ones are written to a directory that the table
+// indexes.
+type ArtifactStore struct {
+	store *sqlStore
+	opts  ArtifactOptions
+}
+
+// NewArtifactStore creates an artifact store on top of a store
+func NewArtifactStore(db Store, opts ArtifactOptions) (*ArtifactStore, error) {
+	backed, ok := db.(sqlBacked)
+	if !ok {
+		return nil, fmt.Errorf("store does not support artifacts")
+	}
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("artifact directory cannot be empty")
+	}
+	if err := opts.Compression.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid artifact compression: %w", err)
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create artifact directory: %w", err)
+	}
+
+	return &ArtifactStore{store: backed.base(), opts: opts}, nil
+}
+
+// Put stores the payload read from r as a new artifact. ID, Size, SHA256 and
+// CreatedAt are filled in. The payload is streamed to a temporary file first,
+// so arbitrarily large readers never have to fit in memory.
+func (a *ArtifactStore) Put(ctx context.Context, artifact *types.RunArtifact, r io.Reader) error {
+	if err := a.store.requireFlow(artifact.FlowID); err != nil {
+		return err
+	}
+	if artifact.Kind == "" {
+		return fmt.Errorf("artifact kind cannot be empty")
+	}
+	if artifact.ID == "" {
+		id, err := newArtifactID()
+		if err != nil {
+			return err
+		}
+		artifact.ID = id
+	}
+
+	tmp, err := os.CreateTemp(a.opts.Dir, ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create artifact file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer func() {
+		if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+			a.store.log.Error("Failed to remove temporary artifact", err, types.Fields{
+				"function": "Put",
+				"path":     tmpPath,
+			})
+		}
+	}()
+
+	// Copy one byte past the limit so oversized payloads are detected
+	// without reading them in full. The checksum is of the payload as
+	// given, the file holds it compressed.
+	src := r
+	if a.opts.MaxArtifactBytes > 0 {
+		src = io.LimitReader(r, a.opts.MaxArtifactBytes+1)
+	}
+	codec := a.opts.Compression.For(artifact.FlowID)
+	hash := sha256.New()
+	var size, stored int64
+	w, err := compression.NewWriter(codec, tmp)
+	if err == nil {
+		size, err = io.Copy(io.MultiWriter(w, hash), src)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		stored, err = tmp.Seek(0, io.SeekCurrent)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read artifact: %w", err)
+	}
+	if a.opts.MaxArtifactBytes > 0 && size > a.opts.MaxArtifactBytes {
+		return fmt.Errorf("%w: artifact exceeds %d bytes", ErrQuotaExceeded, a.opts.MaxArtifactBytes)
+	}
+
+	artifact.Size = size
+	artifact.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	artifact.Encoding = codec
+	artifact.StoredSize = stored
+	artifact.CreatedAt = time.Now()
+
+	// Spilled payloads get a generated file name so artifact IDs never
+	// reach the file system, and are moved into place before the row is
+	// written so a committed row always has its file
+	var data []byte
+	var path sql.NullString
+	if stored <= a.opts.InlineLimit {
+		if data, err = os.ReadFile(tmpPath); err != nil {
+			return fmt.Errorf("failed to read artifact: %w", err)
+		}
+	} else {
+		name, err := newArtifactID()
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(tmpPath, a.spillPath(name)); err != nil {
+			return fmt.Errorf("failed to store artifact payload: %w", err)
+		}
+		path = sql.NullString{String: name, Valid: true}
+	}
+
+	err = a.store.WithTx(ctx, func(tx *Tx) error {
+		if a.opts.MaxFlowBytes > 0 {
+			var used int64
+			query := `SELECT COALESCE(SUM(size), 0) FROM run_artifacts WHERE flow_id = ?`
+			if err := tx.QueryRow(query, artifact.FlowID).Scan(&used); err != nil {
+				return fmt.Errorf("failed to check artifact usage: %w", err)
+			}
+			if used+size > a.opts.MaxFlowBytes {
+				return fmt.Errorf("%w: flow %s would use %d of %d bytes",
+					ErrQuotaExceeded, artifact.FlowID, used+size, a.opts.MaxFlowBytes)
+			}
+		}
+
+		query := `INSERT INTO run_artifacts (` + artifactColumns + `, data, path) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		_, err := tx.Exec(query,
+			artifact.ID,
+			artifact.FlowID,
+			artifact.RunID,
+			artifact.Kind,
+			artifact.Name,
+			artifact.ContentType,
+			artifact.Size,
+			artifact.SHA256,
+			artifact.Encoding,
+			artifact.StoredSize,
+			artifact.CreatedAt,
+			data,
+			path,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to store artifact: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if path.Valid {
+			if rmErr := os.Remove(a.spillPath(path.String)); rmErr != nil && !os.IsNotExist(rmErr) {
+				a.store.log.Error("Failed to remove artifact payload", rmErr, types.Fields{
+					"function":    "Put",
+					"artifact_id": artifact.ID,
+				})
+			}
+		}
+		if !errors.Is(err, ErrQuotaExceeded) {
+			a.store.log.Error("Failed to store artifact", err, types.Fields{
+				"function":    "Put",
+				"flow_id":     artifact.FlowID,
+				"artifact_id": artifact.ID,
+			})
+		}
+		return err
+	}
+
+	compression.Record(a.opts.Metrics, artifact.FlowID, "artifact", codec, size, stored)
+	return nil
+}
+
+// Get returns the metadata of an artifact
+func (a *ArtifactStore) Get(id string) (*types.RunArtifact, error) {
+	query := `SELECT ` + artifactColumns + ` FROM run_artifacts WHERE id = ?`
+	artifact, err := scanArtifact(a.store.queryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: %s", ErrArtifactNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to get artifact: %w", err)
+	}
+	return artifact, nil
+}
+
+// Open returns an artifact's metadata and a reader streaming its payload,
+// decompressed. The caller must close the reader.
+func (a *ArtifactStore) Open(id string) (*types.RunArtifact, io.ReadCloser, error) {
+	artifact, err := a.Get(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var data []byte
+	var path sql.NullString
+	query := `SELECT data, path FROM run_artifacts WHERE id = ?`
+	if err := a.store.queryRow(query, id).Scan(&data, &path); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, fmt.Errorf("%w: %s", ErrArtifactNotFound, id)
+		}
+		return nil, nil, fmt.Errorf("failed to read artifact: %w", err)
+	}
+
+	var stored io.ReadCloser = io.NopCloser(bytes.NewReader(data))
+	if path.Valid {
+		f, err := os.Open(a.spillPath(path.String))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open artifact payload: %w", err)
+		}
+		stored = f
+	}
+	if artifact.Encoding == compression.None {
+		return artifact, stored, nil
+	}
+
+	r, err := compression.NewReader(artifact.Encoding, stored)
+	if err != nil {
+		stored.Close()
+		return nil, nil, fmt.Errorf("failed to open artifact payload: %w", err)
+	}
+	return artifact, &decompressingReader{ReadCloser: r, stored: stored}, nil
+}
+
+// decompressingReader reads a compressed payload, closing its source along
+// with the decompressor
+type decompressingReader struct {
+	io.ReadCloser
+	stored io.Closer
+}
+
+// Close implements io.Closer
+func (r *decompressingReader) Close() error {
+	err := r.ReadCloser.Close()
+	if serr := r.stored.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// List returns a flow's artifacts, newest first. A non-empty runID narrows
+// the list to that run.
+func (a *ArtifactStore) List(flowID, runID string) ([]*types.RunArtifact, error) {
+	if err := a.store.requireFlow(flowID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + artifactColumns + ` FROM run_artifacts WHERE flow_id = ?`
+	args := []interface{}{flowID}
+	if runID != "" {
+		query += ` AND run_id = ?`
+		args = append(args, runID)
+	}
+	query += ` ORDER BY created_at DESC`
+
+	rows, err := a.store.query(query, args...)
+	if err != nil {
+		a.store.log.Error("Failed to list artifacts", err, types.Fields{
+			"function": "List",
+			"flow_id":  flowID,
+		})
+		return nil, fmt.Errorf("failed to list artifacts: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			a.store.log.Error("Failed to close rows", err, types.Fields{
+				"function": "List",
+			})
+		}
+	}()
+
+	artifacts := []*types.RunArtifact{}
+	for rows.Next() {
+		artifact, err := scanArtifact(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan artifact: %w", err)
+		}
+		artifacts = append(artifacts, artifact)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating artifacts: %w", err)
+	}
+
+	return artifacts, nil
+}
+
+// Usage returns the total size in bytes of a flow's artifacts
+func (a *ArtifactStore) Usage(flowID string) (int64, error) {
+	var used int64
+	query := `SELECT COALESCE(SUM(size), 0) FROM run_artifacts WHERE flow_id = ?`
+	if err := a.store.queryRow(query, flowID).Scan(&used); err != nil {
+		return 0, fmt.Errorf("failed to get artifact usage: %w", err)
+	}
+	return used, nil
+}
+
+// Delete removes an artifact and its payload
+func (a *ArtifactStore) Delete(ctx context.Context, id string) error {
+	var path sql.NullString
+	err := a.store.WithTx(ctx, func(tx *Tx) error {
+		err := tx.QueryRow(`SELECT path FROM run_artifacts WHERE id = ?`, id).Scan(&path)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: %s", ErrArtifactNotFound, id)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get artifact: %w", err)
+		}
+
+		if _, err := tx.Exec(`DELETE FROM run_artifacts WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("failed to delete artifact: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if path.Valid {
+		if err := os.Remove(a.spillPath(path.String)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete artifact payload: %w", err)
+		}
+	}
+	return nil
+}
+
+// RemoveOrphans deletes spilled payloads whose rows no longer exist, such as
+// those of deleted flows, and returns how many were removed
+func (a *ArtifactStore) RemoveOrphans() (int, error) {
+	entries, err := os.ReadDir(a.opts.Dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list artifact payloads: %w", err)
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		var count int
+		query := `SELECT COUNT(*) FROM run_artifacts WHERE path = ?`
+		if err := a.store.queryRow(query, name).Scan(&count); err != nil {
+			return removed, fmt.Errorf("failed to check artifact payload: %w", err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		if err := os.Remove(a.spillPath(name)); err != nil {
+			return removed, fmt.Errorf("failed to remove artifact payload: %w", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// spillPath returns the file holding a spilled payload
+func (a *ArtifactStore) spillPath(name string) string {
+	return filepath.Join(a.opts.Dir, name)
+}
+
+// scanArtifact reads artifact metadata from a row selected with
+// artifactColumns
+func scanArtifact(row rowScanner) (*types.RunArtifact, error) {
+	artifact := &types.RunArtifact{}
+	var runID, name, contentType sql.NullString
+	err := row.Scan(
+		&artifact.ID,
+		&artifact.FlowID,
+		&runID,
+		&artifact.Kind,
+		&name,
+		&contentType,
+		&artifact.Size,
+		&artifact.SHA256,
+		&artifact.Encoding,
+		&artifact.StoredSize,
+		&artifact.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	artifact.RunID = runID.String
+	artifact.Name = name.String
+	artifact.ContentType = contentType.String
+	return artifact, nil
+}
+
+// newArtifactID returns a random artifact ID
+func newArtifactID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate artifact ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
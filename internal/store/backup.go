@@ -0,0 +1,381 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"flow-control/internal/types"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrInvalidBackup is returned when a restore source is not a usable backup
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// BackupStore is implemented by stores that support online backup and restore
+type BackupStore interface {
+	Backup(w io.Writer) error
+	Restore(r io.Reader) error
+}
+
+const (
+	// backupFilePrefix and backupFileSuffix frame scheduled backup file names
+	backupFilePrefix = "flows-"
+	backupFileSuffix = ".db"
+	// backupTimeFormat sorts lexically in chronological order
+	backupTimeFormat = "20060102T150405.000Z"
+	// backupStepRetry is how long a backup waits before retrying a busy step
+	backupStepRetry = 10 * time.Millisecond
+)
+
+// BackupSchedule configures periodic backups to a directory
+type BackupSchedule struct {
+	// Dir is the directory backups are written to
+	Dir string
+	// Interval is the time between backups
+	Interval time.Duration
+	// Retain is the number of most recent backups to keep; 0 keeps all
+	Retain int
+}
+
+// Backup writes a consistent snapshot of the database to w using the SQLite
+// online backup API. The store keeps serving reads and writes meanwhile.
+func (s *SQLiteStore) Backup(w io.Writer) error {
+	tmp, err := os.CreateTemp("", "flowcontrol-backup-*.db")
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	path := tmp.Name()
+	defer func() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			s.log.Error("Failed to remove temporary backup", err, types.Fields{
+				"function": "Backup",
+				"path":     path,
+			})
+		}
+	}()
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close backup file: %w", err)
+	}
+
+	if err := s.BackupToFile(path); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			s.log.Error("Failed to close backup file", err, types.Fields{
+				"function": "Backup",
+				"path":     path,
+			})
+		}
+	}()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	return nil
+}
+
+// BackupToFile writes a consistent snapshot of the database to a file,
+// replacing any existing database at that path
+func (s *SQLiteStore) BackupToFile(path string) error {
+	ctx := context.Background()
+
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup database: %w", err)
+	}
+	defer func() {
+		if err := dest.Close(); err != nil {
+			s.log.Error("Failed to close backup database", err, types.Fields{
+				"function": "BackupToFile",
+				"path":     path,
+			})
+		}
+	}()
+
+	if err := s.copyDatabase(ctx, dest, s.db); err != nil {
+		s.log.Error("Failed to back up database", err, types.Fields{
+			"function": "BackupToFile",
+			"path":     path,
+		})
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+
+	s.log.Info("Database backup written", types.Fields{
+		"function": "BackupToFile",
+		"path":     path,
+	})
+	return nil
+}
+
+// Restore replaces the contents of the database with a backup read from r.
+// The backup is checked with PRAGMA integrity_check and must carry a schema
+// this build understands; it is migrated to the current schema once restored.
+func (s *SQLiteStore) Restore(r io.Reader) error {
+	tmp, err := os.CreateTemp("", "flowcontrol-restore-*.db")
+	if err != nil {
+		return fmt.Errorf("failed to create restore file: %w", err)
+	}
+	path := tmp.Name()
+	defer func() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			s.log.Error("Failed to remove temporary restore file", err, types.Fields{
+				"function": "Restore",
+				"path":     path,
+			})
+		}
+	}()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close restore file: %w", err)
+	}
+
+	return s.RestoreFromFile(path)
+}
+
+// RestoreFromFile replaces the contents of the database with the backup
+// stored at path. See Restore.
+func (s *SQLiteStore) RestoreFromFile(path string) error {
+	ctx := context.Background()
+
+	src, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer func() {
+		if err := src.Close(); err != nil {
+			s.log.Error("Failed to close backup", err, types.Fields{
+				"function": "RestoreFromFile",
+				"path":     path,
+			})
+		}
+	}()
+
+	if err := verifyBackup(ctx, src); err != nil {
+		s.log.Error("Rejected backup", err, types.Fields{
+			"function": "RestoreFromFile",
+			"path":     path,
+		})
+		return err
+	}
+
+	if err := s.copyDatabase(ctx, s.db, src); err != nil {
+		s.log.Error("Failed to restore database", err, types.Fields{
+			"function": "RestoreFromFile",
+			"path":     path,
+		})
+		return fmt.Errorf("failed to restore database: %w", err)
+	}
+
+	if err := s.migrate(); err != nil {
+		return fmt.Errorf("failed to migrate restored database: %w", err)
+	}
+	if err := s.ensureSearchIndex(); err != nil {
+		return err
+	}
+
+	s.log.Info("Database restored from backup", types.Fields{
+		"function": "RestoreFromFile",
+		"path":     path,
+	})
+	return nil
+}
+
+// ScheduleBackups writes a backup to schedule.Dir every schedule.Interval
+// until ctx is done, pruning backups beyond schedule.Retain
+func (s *SQLiteStore) ScheduleBackups(ctx context.Context, schedule BackupSchedule) error {
+	if schedule.Interval <= 0 {
+		return fmt.Errorf("backup interval must be positive: %s", schedule.Interval)
+	}
+	if err := os.MkdirAll(schedule.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(schedule.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.BackupToDir(schedule.Dir, schedule.Retain); err != nil {
+					s.log.Error("Scheduled backup failed", err, types.Fields{
+						"function": "ScheduleBackups",
+						"dir":      schedule.Dir,
+					})
+				}
+			}
+		}
+	}()
+
+	s.log.Info("Scheduled database backups", types.Fields{
+		"function": "ScheduleBackups",
+		"dir":      schedule.Dir,
+		"interval": schedule.Interval.String(),
+		"retain":   schedule.Retain,
+	})
+	return nil
+}
+
+// BackupToDir writes a timestamped backup into dir and removes the oldest
+// backups so that at most retain remain. It returns the path of the backup.
+func (s *SQLiteStore) BackupToDir(dir string, retain int) (string, error) {
+	name := backupFilePrefix + time.Now().UTC().Format(backupTimeFormat) + backupFileSuffix
+	path := filepath.Join(dir, name)
+
+	// Write under a temporary name so a partial backup is never mistaken
+	// for a complete one
+	tmpPath := path + ".tmp"
+	if err := s.BackupToFile(tmpPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return "", fmt.Errorf("failed to finalize backup: %w", err)
+	}
+
+	if err := s.pruneBackups(dir, retain); err != nil {
+		return path, err
+	}
+	return path, nil
+}
+
+// pruneBackups deletes all but the newest retain backups in dir
+func (s *SQLiteStore) pruneBackups(dir string, retain int) error {
+	if retain <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, backupFilePrefix) && strings.HasSuffix(name, backupFileSuffix) {
+			backups = append(backups, name)
+		}
+	}
+	if len(backups) <= retain {
+		return nil
+	}
+
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-retain] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("failed to remove old backup: %w", err)
+		}
+		s.log.Debug("Removed old backup", types.Fields{
+			"function": "pruneBackups",
+			"file":     name,
+		})
+	}
+	return nil
+}
+
+// copyDatabase copies the main database of src over that of dest with the
+// SQLite backup API
+func (s *SQLiteStore) copyDatabase(ctx context.Context, dest, src *sql.DB) error {
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get destination connection: %w", err)
+	}
+	defer func() { _ = destConn.Close() }()
+
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get source connection: %w", err)
+	}
+	defer func() { _ = srcConn.Close() }()
+
+	return destConn.Raw(func(destDriver interface{}) error {
+		return srcConn.Raw(func(srcDriver interface{}) error {
+			destSQLite, ok := destDriver.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("destination is not a sqlite connection")
+			}
+			srcSQLite, ok := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("source is not a sqlite connection")
+			}
+
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+
+			// Copy every page in one step so the snapshot is consistent;
+			// busy and locked steps report no progress and are retried
+			for {
+				done, err := backup.Step(-1)
+				if err != nil {
+					_ = backup.Finish()
+					return err
+				}
+				if done {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					_ = backup.Finish()
+					return ctx.Err()
+				case <-time.After(backupStepRetry):
+				}
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// verifyBackup checks that db is an intact Flow Control database whose schema
+// is not newer than this build supports
+func verifyBackup(ctx context.Context, db *sql.DB) error {
+	var result string
+	if err := db.QueryRowContext(ctx, `PRAGMA integrity_check`).Scan(&result); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%w: integrity check failed: %s", ErrInvalidBackup, result)
+	}
+
+	var tables int
+	query := `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('flows', 'schema_migrations')`
+	if err := db.QueryRowContext(ctx, query).Scan(&tables); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if tables != 2 {
+		return fmt.Errorf("%w: not a flow control database", ErrInvalidBackup)
+	}
+
+	var version int
+	if err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if latest := migrations[len(migrations)-1].version; version > latest {
+		return fmt.Errorf("%w: schema version %d is newer than supported version %d", ErrInvalidBackup, version, latest)
+	}
+
+	return nil
+}
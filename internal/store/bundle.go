@@ -0,0 +1,466 @@
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"flow-control/internal/secrets"
+	"flow-control/internal/types"
+)
+
+var (
+	// ErrInvalidBundle is returned when an import source is not a usable bundle
+	ErrInvalidBundle = errors.New("invalid bundle")
+	// ErrBundleSignature is returned when a bundle was not signed with the
+	// expected key or was modified after signing
+	ErrBundleSignature = errors.New("bundle signature mismatch")
+)
+
+const (
+	// bundleFormatVersion is the manifest layout written by ExportBundle
+	bundleFormatVersion = 1
+	// bundleManifestName and bundleSignatureName are the archive entries
+	bundleManifestName  = "bundle.json"
+	bundleSignatureName = "bundle.sig"
+	// maxBundleManifestSize bounds how much of an archive entry is read
+	maxBundleManifestSize = 256 << 20
+)
+
+// Bundle is the manifest of a workspace export
+type Bundle struct {
+	// FormatVersion is the manifest layout version
+	FormatVersion int `json:"format_version"`
+	// SchemaVersion is the database migration version of the exporting store
+	SchemaVersion int `json:"schema_version"`
+	// CreatedAt is the time the bundle was exported
+	CreatedAt time.Time `json:"created_at"`
+	// Flows holds every flow with its tags and version history
+	Flows []*BundleFlow `json:"flows"`
+	// Schemas holds the custom schemas in registration order
+	Schemas []*types.SchemaRecord `json:"schemas"`
+	// Secrets names the secrets referenced by flow configs; values are never
+	// exported
+	Secrets []string `json:"secrets"`
+}
+
+// BundleFlow is a flow together with its saved versions, oldest first
+type BundleFlow struct {
+	Flow     *types.RuntimeFlow   `json:"flow"`
+	Versions []*types.FlowVersion `json:"versions"`
+}
+
+// BundleOptions configures ExportBundle and ImportBundle
+type BundleOptions struct {
+	// Key signs exported bundles and verifies imported ones
+	Key []byte
+	// IDMap assigns explicit target IDs to flows on import, keyed by the ID
+	// in the bundle. Flows not listed keep their ID unless it is taken, in
+	// which case a fresh one is generated.
+	IDMap map[string]string
+}
+
+// ImportResult describes what ImportBundle wrote
+type ImportResult struct {
+	// FlowIDs maps each flow ID in the bundle to its ID in the store
+	FlowIDs map[string]string
+	// Schemas is the number of schemas added
+	Schemas int
+	// SkippedSchemas is the number of schemas already stored with an
+	// identical definition
+	SkippedSchemas int
+	// Secrets names the secrets the imported flows reference, which the
+	// target environment must provide
+	Secrets []string
+}
+
+// ExportBundle writes every flow, flow version and custom schema in the store
+// to w as a gzipped tar archive signed with opts.Key. Bundles move a
+// workspace between environments, e.g. from staging to production.
+func ExportBundle(ctx context.Context, db Store, w io.Writer, opts BundleOptions) error {
+	backed, ok := db.(sqlBacked)
+	if !ok {
+		return fmt.Errorf("store does not support bundles")
+	}
+	s := backed.base()
+	if len(opts.Key) == 0 {
+		return fmt.Errorf("bundle signing key cannot be empty")
+	}
+
+	bundle := &Bundle{
+		FormatVersion: bundleFormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		Flows:         []*BundleFlow{},
+	}
+
+	var err error
+	if bundle.SchemaVersion, err = s.SchemaVersion(); err != nil {
+		return err
+	}
+
+	flows, err := s.ListFlows()
+	if err != nil {
+		return err
+	}
+	secrets := make(map[string]bool)
+	for _, flow := range flows {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		versions, err := s.ListFlowVersions(flow.ID)
+		if err != nil {
+			return err
+		}
+		// Versions are listed newest first; bundles replay them in order
+		for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+			versions[i], versions[j] = versions[j], versions[i]
+		}
+
+		bundle.Flows = append(bundle.Flows, &BundleFlow{Flow: flow, Versions: versions})
+		for _, name := range SecretReferences(flow.Config) {
+			secrets[name] = true
+		}
+	}
+
+	if bundle.Schemas, err = s.ListSchemas(); err != nil {
+		return err
+	}
+
+	bundle.Secrets = make([]string, 0, len(secrets))
+	for name := range secrets {
+		bundle.Secrets = append(bundle.Secrets, name)
+	}
+	sort.Strings(bundle.Secrets)
+
+	manifest, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle: %w", err)
+	}
+	signature := []byte(hex.EncodeToString(signBundle(opts.Key, manifest)))
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{
+		{bundleManifestName, manifest},
+		{bundleSignatureName, signature},
+	} {
+		header := &tar.Header{
+			Name:    entry.name,
+			Mode:    0o644,
+			Size:    int64(len(entry.data)),
+			ModTime: bundle.CreatedAt,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write bundle: %w", err)
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			return fmt.Errorf("failed to write bundle: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	s.log.Info("Exported bundle", types.Fields{
+		"function": "ExportBundle",
+		"flows":    len(bundle.Flows),
+		"schemas":  len(bundle.Schemas),
+		"secrets":  len(bundle.Secrets),
+	})
+	return nil
+}
+
+// ImportBundle verifies a bundle written by ExportBundle and adds its flows
+// and schemas to the store in a single transaction. Flows are created with a
+// fresh revision and keep their version history under their new IDs.
+// Schemas already stored with the same definition are skipped; a differing
+// definition for an existing type and version fails the import.
+func ImportBundle(ctx context.Context, db Store, r io.Reader, opts BundleOptions) (*ImportResult, error) {
+	backed, ok := db.(sqlBacked)
+	if !ok {
+		return nil, fmt.Errorf("store does not support bundles")
+	}
+	s := backed.base()
+	if len(opts.Key) == 0 {
+		return nil, fmt.Errorf("bundle signing key cannot be empty")
+	}
+
+	bundle, err := readBundle(r, opts.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.validateBundle(bundle); err != nil {
+		return nil, err
+	}
+
+	stored, err := s.ListSchemas()
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]string, len(stored))
+	for _, record := range stored {
+		existing[record.Type+"@"+record.Version] = record.Definition
+	}
+
+	result := &ImportResult{
+		FlowIDs: make(map[string]string, len(bundle.Flows)),
+		Secrets: bundle.Secrets,
+	}
+	var schemas []*types.SchemaRecord
+	for _, record := range bundle.Schemas {
+		definition, ok := existing[record.Type+"@"+record.Version]
+		switch {
+		case !ok:
+			schemas = append(schemas, record)
+		case definition == record.Definition:
+			result.SkippedSchemas++
+		default:
+			return nil, fmt.Errorf("%w: schema %s version %s differs from the stored definition", ErrSchemaExists, record.Type, record.Version)
+		}
+	}
+
+	for _, entry := range bundle.Flows {
+		id, err := s.importFlowID(entry.Flow.ID, opts.IDMap, result.FlowIDs)
+		if err != nil {
+			return nil, err
+		}
+		result.FlowIDs[entry.Flow.ID] = id
+	}
+
+	err = s.WithTx(ctx, func(tx *Tx) error {
+		for _, record := range schemas {
+			query := `INSERT INTO schemas (type, version, definition, created_at) VALUES (?, ?, ?, ?)`
+			if _, err := tx.Exec(query, record.Type, record.Version, record.Definition, record.CreatedAt); err != nil {
+				return fmt.Errorf("failed to import schema %s version %s: %w", record.Type, record.Version, err)
+			}
+		}
+		for _, entry := range bundle.Flows {
+			if err := s.importFlow(tx, entry, result.FlowIDs[entry.Flow.ID]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.log.Error("Failed to import bundle", err, types.Fields{
+			"function": "ImportBundle",
+		})
+		return nil, fmt.Errorf("failed to import bundle: %w", err)
+	}
+	result.Schemas = len(schemas)
+
+	s.log.Info("Imported bundle", types.Fields{
+		"function":        "ImportBundle",
+		"flows":           len(result.FlowIDs),
+		"schemas":         result.Schemas,
+		"skipped_schemas": result.SkippedSchemas,
+		"secrets":         len(result.Secrets),
+	})
+	return result, nil
+}
+
+// SecretReferences returns the names of the secrets a flow config refers to,
+// sorted and without duplicates
+func SecretReferences(config string) []string {
+	return secrets.References(config)
+}
+
+// readBundle extracts a bundle's manifest and checks its signature
+func readBundle(r io.Reader, key []byte) (*Bundle, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	defer func() { _ = gz.Close() }()
+
+	var manifest, signature []byte
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+
+		data, err := io.ReadAll(io.LimitReader(tr, maxBundleManifestSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+		if len(data) > maxBundleManifestSize {
+			return nil, fmt.Errorf("%w: %s exceeds %d bytes", ErrInvalidBundle, header.Name, maxBundleManifestSize)
+		}
+
+		switch header.Name {
+		case bundleManifestName:
+			manifest = data
+		case bundleSignatureName:
+			signature = bytes.TrimSpace(data)
+		}
+	}
+	if manifest == nil || signature == nil {
+		return nil, fmt.Errorf("%w: missing %s or %s", ErrInvalidBundle, bundleManifestName, bundleSignatureName)
+	}
+
+	expected, err := hex.DecodeString(string(signature))
+	if err != nil || !hmac.Equal(expected, signBundle(key, manifest)) {
+		return nil, ErrBundleSignature
+	}
+
+	bundle := &Bundle{}
+	if err := json.Unmarshal(manifest, bundle); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	return bundle, nil
+}
+
+// validateBundle checks that a bundle can be imported into this store
+func (s *sqlStore) validateBundle(bundle *Bundle) error {
+	if bundle.FormatVersion != bundleFormatVersion {
+		return fmt.Errorf("%w: unsupported format version %d", ErrInvalidBundle, bundle.FormatVersion)
+	}
+	if latest := migrations[len(migrations)-1].version; bundle.SchemaVersion > latest {
+		return fmt.Errorf("%w: schema version %d is newer than supported version %d", ErrInvalidBundle, bundle.SchemaVersion, latest)
+	}
+
+	schemas := make(map[string]bool, len(bundle.Schemas))
+	for _, record := range bundle.Schemas {
+		if record == nil || record.Type == "" || record.Version == "" || record.Definition == "" {
+			return fmt.Errorf("%w: schema without type, version or definition", ErrInvalidBundle)
+		}
+		key := record.Type + "@" + record.Version
+		if schemas[key] {
+			return fmt.Errorf("%w: duplicate schema %s version %s", ErrInvalidBundle, record.Type, record.Version)
+		}
+		schemas[key] = true
+	}
+
+	flows := make(map[string]bool, len(bundle.Flows))
+	for _, entry := range bundle.Flows {
+		if entry == nil || entry.Flow == nil || entry.Flow.ID == "" {
+			return fmt.Errorf("%w: flow without ID", ErrInvalidBundle)
+		}
+		if flows[entry.Flow.ID] {
+			return fmt.Errorf("%w: duplicate flow %s", ErrInvalidBundle, entry.Flow.ID)
+		}
+		flows[entry.Flow.ID] = true
+	}
+	return nil
+}
+
+// importFlowID picks the ID an imported flow is stored under
+func (s *sqlStore) importFlowID(id string, idMap, assigned map[string]string) (string, error) {
+	taken := func(candidate string) (bool, error) {
+		for _, other := range assigned {
+			if other == candidate {
+				return true, nil
+			}
+		}
+		err := s.requireFlow(candidate)
+		if errors.Is(err, ErrFlowNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	if target, ok := idMap[id]; ok {
+		exists, err := taken(target)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return "", fmt.Errorf("%w: flow %s already exists", ErrInvalidBundle, target)
+		}
+		return target, nil
+	}
+
+	exists, err := taken(id)
+	if err != nil || !exists {
+		return id, err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate flow ID: %w", err)
+	}
+	return id + "-" + hex.EncodeToString(suffix), nil
+}
+
+// importFlow writes one bundled flow under its new ID
+func (s *sqlStore) importFlow(tx *Tx, entry *BundleFlow, id string) error {
+	flow := *entry.Flow
+	sourceID := flow.ID
+	flow.ID = id
+	flow.Revision = 1
+	flow.CreatedAt = time.Now()
+	flow.UpdatedAt = flow.CreatedAt
+
+	query := `
+		INSERT INTO flows (id, name, description, version, config, status, created_at, updated_at, revision)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := tx.Exec(query, flow.ID, flow.Name, flow.Description, flow.Version, flow.Config,
+		flow.Status, flow.CreatedAt, flow.UpdatedAt, flow.Revision)
+	if err != nil {
+		return fmt.Errorf("failed to import flow %s: %w", sourceID, err)
+	}
+
+	for _, tag := range normalizeTags(flow.Tags) {
+		query := `INSERT INTO flow_tags (flow_id, tag) VALUES (?, ?) ON CONFLICT DO NOTHING`
+		if _, err := tx.Exec(query, flow.ID, tag); err != nil {
+			return fmt.Errorf("failed to import tags of flow %s: %w", sourceID, err)
+		}
+	}
+
+	for _, version := range entry.Versions {
+		var metadata sql.NullString
+		if version.Metadata != nil {
+			data, err := json.Marshal(version.Metadata)
+			if err != nil {
+				return fmt.Errorf("failed to encode flow version metadata: %w", err)
+			}
+			metadata = sql.NullString{String: string(data), Valid: true}
+		}
+		query := `INSERT INTO flow_versions (flow_id, version, code, metadata, created_at) VALUES (?, ?, ?, ?, ?)`
+		if _, err := tx.Exec(query, flow.ID, version.Version, version.Config, metadata, version.CreatedAt); err != nil {
+			return fmt.Errorf("failed to import version %d of flow %s: %w", version.Version, sourceID, err)
+		}
+	}
+
+	details := map[string]interface{}{
+		"source_id": sourceID,
+		"versions":  len(entry.Versions),
+	}
+	if steps := s.deriveSteps(&flow); steps != nil {
+		if err := s.replaceSteps(tx, flow.ID, steps); err != nil {
+			return err
+		}
+		details["steps"] = len(steps)
+	}
+	return s.insertAuditRecord(tx, AuditEntityFlow, flow.ID, AuditActionImport, details)
+}
+
+// signBundle computes a manifest's HMAC-SHA256 signature
+func signBundle(key, manifest []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(manifest)
+	return mac.Sum(nil)
+}
@@ -1,8 +1,9 @@
 // Package store provides persistent storage for Flow Control.
 //
-// The store package implements a SQLite-based storage layer for flows and their
-// associated data. It provides CRUD operations for flows and maintains their
-// execution state.
+// The store package defines the Store interface for persisting flows and their
+// associated data, with implementations backed by SQLite (SQLiteStore) and
+// PostgreSQL (PostgresStore). It provides CRUD operations for flows and
+// maintains their execution state.
 //
 // Key features:
 //
@@ -29,6 +30,16 @@
 //	    log.Fatal(err)
 //	}
 //
-// The store uses SQLite for persistence, which provides good performance for
-// most use cases while maintaining simplicity and reliability.
+// SQLite provides good performance for single-instance deployments while
+// maintaining simplicity and reliability. Deployments running several
+// instances should use PostgreSQL instead, selected through configuration:
+//
+//	db, err := store.Open("postgres", "postgres://localhost/flowcontrol", logger)
+//
+// Both backends share a versioned migration framework that records applied
+// migrations in the schema_migrations table.
+//
+// SearchFlows provides full-text search over flows. SQLite builds use an FTS5
+// index when compiled with the sqlite_fts5 build tag and fall back to LIKE
+// matching otherwise; PostgreSQL uses its built-in text search.
 package store
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// leaseClock returns SQL expressions of the database's current time and of
+// the time a number of seconds later, given as a parameter. Lease expiry is
+// always written and checked against the database's clock, so instances
+// whose clocks drift apart still agree on when a lease lapses.
+func (s *sqlStore) leaseClock() (now, later string) {
+	if s.dialect == DialectPostgres {
+		return `CURRENT_TIMESTAMP`, `CURRENT_TIMESTAMP + CAST(? AS DOUBLE PRECISION) * INTERVAL '1 second'`
+	}
+	return `strftime('%Y-%m-%d %H:%M:%f', 'now')`, `strftime('%Y-%m-%d %H:%M:%f', 'now', printf('%.3f seconds', ?))`
+}
+
+// AcquireLease acquires or renews the lease called name for holder until
+// ttl from now, and reports whether holder holds it. A lease held by
+// another holder can only be acquired once it expired. The check and the
+// write are a single statement, so concurrent instances never both win.
+func (s *sqlStore) AcquireLease(name, holder string, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		return false, fmt.Errorf("lease duration must be positive: %s", ttl)
+	}
+	now, later := s.leaseClock()
+	result, err := s.exec(`
+		INSERT INTO leases (name, holder, acquired_at, expires_at)
+		VALUES (?, ?, `+now+`, `+later+`)
+		ON CONFLICT (name) DO UPDATE
+		SET holder = excluded.holder,
+			acquired_at = CASE WHEN leases.holder = excluded.holder THEN leases.acquired_at ELSE excluded.acquired_at END,
+			expires_at = excluded.expires_at
+		WHERE leases.holder = excluded.holder OR leases.expires_at < `+now+`
+	`, name, holder, ttl.Seconds())
+	if err != nil {
+		s.log.Error("Failed to acquire lease", err, types.Fields{
+			"function": "AcquireLease",
+			"lease":    name,
+			"holder":   holder,
+		})
+		return false, fmt.Errorf("failed to acquire lease: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// ReleaseLease gives up the lease called name if holder holds it, so that
+// another instance can acquire it without waiting for it to expire
+func (s *sqlStore) ReleaseLease(name, holder string) error {
+	if _, err := s.exec(`DELETE FROM leases WHERE name = ? AND holder = ?`, name, holder); err != nil {
+		s.log.Error("Failed to release lease", err, types.Fields{
+			"function": "ReleaseLease",
+			"lease":    name,
+			"holder":   holder,
+		})
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+	return nil
+}
+
+// GetLease returns the unexpired lease called name. It returns
+// ErrLeaseNotFound if nobody holds it.
+func (s *sqlStore) GetLease(name string) (*types.Lease, error) {
+	lease := &types.Lease{Name: name}
+	now, _ := s.leaseClock()
+	err := s.queryRow(`
+		SELECT holder, acquired_at, expires_at
+		FROM leases
+		WHERE name = ? AND expires_at >= `+now+`
+	`, name).Scan(&lease.Holder, &lease.AcquiredAt, &lease.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrLeaseNotFound, name)
+	}
+	if err != nil {
+		s.log.Error("Failed to get lease", err, types.Fields{
+			"function": "GetLease",
+			"lease":    name,
+		})
+		return nil, fmt.Errorf("failed to get lease: %w", err)
+	}
+	return lease, nil
+}
+
+// ListLeases returns the unexpired leases whose names start with prefix,
+// ordered by name
+func (s *sqlStore) ListLeases(prefix string) ([]*types.Lease, error) {
+	now, _ := s.leaseClock()
+	rows, err := s.query(`
+		SELECT name, holder, acquired_at, expires_at
+		FROM leases
+		WHERE substr(name, 1, ?) = ? AND expires_at >= `+now+`
+		ORDER BY name
+	`, len(prefix), prefix)
+	if err != nil {
+		s.log.Error("Failed to list leases", err, types.Fields{
+			"function": "ListLeases",
+			"prefix":   prefix,
+		})
+		return nil, fmt.Errorf("failed to list leases: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.log.Error("Failed to close rows", err, types.Fields{
+				"function": "ListLeases",
+			})
+		}
+	}()
+
+	leases := []*types.Lease{}
+	for rows.Next() {
+		lease := &types.Lease{}
+		if err := rows.Scan(&lease.Name, &lease.Holder, &lease.AcquiredAt, &lease.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan lease: %w", err)
+		}
+		leases = append(leases, lease)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list leases: %w", err)
+	}
+	return leases, nil
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"flow-control/internal/types"
+)
+
+// DefaultMessageHopLimit is the most hops ListMessageHops returns when the
+// filter sets no limit
+const DefaultMessageHopLimit = 1000
+
+// MessageHopFilter selects the message hops of a run
+type MessageHopFilter struct {
+	// RunID is the run whose hops are listed
+	RunID string
+	// FlowID, NodeID and MessageID, when set, narrow the list to a flow, a
+	// node or the hops whose input is a message
+	FlowID    string
+	NodeID    string
+	MessageID string
+	// Limit is the most hops listed; DefaultMessageHopLimit when zero
+	Limit int
+}
+
+// SaveMessageHops records the hops of messages through nodes during runs
+func (s *sqlStore) SaveMessageHops(hops []types.MessageHop) error {
+	if len(hops) == 0 {
+		return nil
+	}
+	err := s.WithTx(context.Background(), func(tx *Tx) error {
+		for _, hop := range hops {
+			input, err := json.Marshal(hop.Input)
+			if err != nil {
+				return fmt.Errorf("failed to encode input: %w", err)
+			}
+			var outputs sql.NullString
+			if len(hop.Outputs) > 0 {
+				encoded, err := json.Marshal(hop.Outputs)
+				if err != nil {
+					return fmt.Errorf("failed to encode outputs: %w", err)
+				}
+				outputs = sql.NullString{String: string(encoded), Valid: true}
+			}
+			if _, err := tx.Exec(`
+				INSERT INTO message_hops (run_id, flow_id, node_id, message_id, source, input, outputs, port, event, error, started_at, finished_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, hop.RunID, hop.FlowID, hop.NodeID, hop.Input.ID, hop.Source, string(input), outputs, hop.Port, hop.Event, hop.Error, hop.StartedAt.UTC(), hop.FinishedAt.UTC()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.log.Error("Failed to save message hops", err, types.Fields{
+			"function": "SaveMessageHops",
+			"run_id":   hops[0].RunID,
+		})
+		return fmt.Errorf("failed to save message hops: %w", err)
+	}
+	return nil
+}
+
+// ListMessageHops returns the message hops of a run, in the order the nodes
+// processed the messages
+func (s *sqlStore) ListMessageHops(filter MessageHopFilter) ([]*types.MessageHop, error) {
+	query := `SELECT run_id, flow_id, node_id, source, input, outputs, port, event, error, started_at, finished_at FROM message_hops WHERE run_id = ?`
+	args := []interface{}{filter.RunID}
+	if filter.FlowID != "" {
+		query += ` AND flow_id = ?`
+		args = append(args, filter.FlowID)
+	}
+	if filter.NodeID != "" {
+		query += ` AND node_id = ?`
+		args = append(args, filter.NodeID)
+	}
+	if filter.MessageID != "" {
+		query += ` AND message_id = ?`
+		args = append(args, filter.MessageID)
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultMessageHopLimit
+	}
+	query += ` ORDER BY id LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.query(query, args...)
+	if err != nil {
+		s.log.Error("Failed to list message hops", err, types.Fields{
+			"function": "ListMessageHops",
+			"run_id":   filter.RunID,
+		})
+		return nil, fmt.Errorf("failed to list message hops: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.log.Error("Failed to close rows", err, types.Fields{
+				"function": "ListMessageHops",
+			})
+		}
+	}()
+
+	hops := []*types.MessageHop{}
+	for rows.Next() {
+		hop := &types.MessageHop{}
+		var input string
+		var outputs, hopErr sql.NullString
+		if err := rows.Scan(&hop.RunID, &hop.FlowID, &hop.NodeID, &hop.Source, &input, &outputs, &hop.Port, &hop.Event, &hopErr, &hop.StartedAt, &hop.FinishedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message hop: %w", err)
+		}
+		hop.Error = hopErr.String
+		if err := json.Unmarshal([]byte(input), &hop.Input); err != nil {
+			return nil, fmt.Errorf("failed to decode message hop input: %w", err)
+		}
+		if outputs.Valid {
+			if err := json.Unmarshal([]byte(outputs.String), &hop.Outputs); err != nil {
+				return nil, fmt.Errorf("failed to decode message hop outputs: %w", err)
+			}
+		}
+		hops = append(hops, hop)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list message hops: %w", err)
+	}
+	return hops, nil
+}
@@ -0,0 +1,494 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// migration describes a versioned schema change. Each migration carries the
+// statements for every supported dialect so both backends stay in lockstep.
+type migration struct {
+	version     int
+	description string
+	up          map[Dialect][]string
+}
+
+// migrations lists all schema changes in the order they must be applied
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create flows table",
+		up: map[Dialect][]string{
+			DialectSQLite: {`
+				CREATE TABLE IF NOT EXISTS flows (
+					id TEXT PRIMARY KEY,
+					name TEXT NOT NULL,
+					description TEXT,
+					version TEXT,
+					config TEXT NOT NULL,
+					status TEXT NOT NULL,
+					created_at DATETIME NOT NULL,
+					updated_at DATETIME NOT NULL
+				)
+			`},
+			DialectPostgres: {`
+				CREATE TABLE IF NOT EXISTS flows (
+					id TEXT PRIMARY KEY,
+					name TEXT NOT NULL,
+					description TEXT,
+					version TEXT,
+					config TEXT NOT NULL,
+					status TEXT NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL,
+					updated_at TIMESTAMPTZ NOT NULL
+				)
+			`},
+		},
+	},
+	{
+		version:     2,
+		description: "create flow_tags table",
+		up: map[Dialect][]string{
+			DialectSQLite: {
+				`CREATE TABLE IF NOT EXISTS flow_tags (
+					flow_id TEXT NOT NULL REFERENCES flows(id) ON DELETE CASCADE,
+					tag TEXT NOT NULL,
+					PRIMARY KEY (flow_id, tag)
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_flow_tags_tag ON flow_tags (tag)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE IF NOT EXISTS flow_tags (
+					flow_id TEXT NOT NULL REFERENCES flows(id) ON DELETE CASCADE,
+					tag TEXT NOT NULL,
+					PRIMARY KEY (flow_id, tag)
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_flow_tags_tag ON flow_tags (tag)`,
+			},
+		},
+	},
+	{
+		version:     3,
+		description: "create flow search index",
+		up: map[Dialect][]string{
+			// The SQLite FTS5 index depends on the sqlite_fts5 build tag and
+			// is managed by ensureSearchIndex instead.
+			DialectSQLite: {},
+			DialectPostgres: {
+				`CREATE INDEX IF NOT EXISTS idx_flows_search ON flows
+					USING GIN (to_tsvector('english', ` + postgresSearchDocument + `))`,
+			},
+		},
+	},
+	{
+		version:     4,
+		description: "create flow_steps table",
+		up: map[Dialect][]string{
+			DialectSQLite: {
+				`CREATE TABLE IF NOT EXISTS flow_steps (
+					flow_id TEXT NOT NULL REFERENCES flows(id) ON DELETE CASCADE,
+					id TEXT NOT NULL,
+					position INTEGER NOT NULL,
+					type TEXT NOT NULL,
+					config TEXT,
+					created_at DATETIME NOT NULL,
+					updated_at DATETIME NOT NULL,
+					PRIMARY KEY (flow_id, id)
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_flow_steps_position ON flow_steps (flow_id, position)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE IF NOT EXISTS flow_steps (
+					flow_id TEXT NOT NULL REFERENCES flows(id) ON DELETE CASCADE,
+					id TEXT NOT NULL,
+					position INTEGER NOT NULL,
+					type TEXT NOT NULL,
+					config TEXT,
+					created_at TIMESTAMPTZ NOT NULL,
+					updated_at TIMESTAMPTZ NOT NULL,
+					PRIMARY KEY (flow_id, id)
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_flow_steps_position ON flow_steps (flow_id, position)`,
+			},
+		},
+	},
+	{
+		version:     5,
+		description: "create flow_versions and audit_log tables",
+		up: map[Dialect][]string{
+			DialectSQLite: {
+				`CREATE TABLE IF NOT EXISTS flow_versions (
+					flow_id TEXT NOT NULL REFERENCES flows(id) ON DELETE CASCADE,
+					version INTEGER NOT NULL,
+					code TEXT NOT NULL,
+					metadata TEXT,
+					created_at DATETIME NOT NULL,
+					PRIMARY KEY (flow_id, version)
+				)`,
+				`CREATE TABLE IF NOT EXISTS audit_log (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					entity_type TEXT NOT NULL,
+					entity_id TEXT NOT NULL,
+					action TEXT NOT NULL,
+					details TEXT,
+					created_at DATETIME NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log (entity_type, entity_id)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE IF NOT EXISTS flow_versions (
+					flow_id TEXT NOT NULL REFERENCES flows(id) ON DELETE CASCADE,
+					version INTEGER NOT NULL,
+					code TEXT NOT NULL,
+					metadata TEXT,
+					created_at TIMESTAMPTZ NOT NULL,
+					PRIMARY KEY (flow_id, version)
+				)`,
+				`CREATE TABLE IF NOT EXISTS audit_log (
+					id BIGSERIAL PRIMARY KEY,
+					entity_type TEXT NOT NULL,
+					entity_id TEXT NOT NULL,
+					action TEXT NOT NULL,
+					details TEXT,
+					created_at TIMESTAMPTZ NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log (entity_type, entity_id)`,
+			},
+		},
+	},
+	{
+		version:     6,
+		description: "add flows revision column",
+		up: map[Dialect][]string{
+			DialectSQLite: {
+				`ALTER TABLE flows ADD COLUMN revision INTEGER NOT NULL DEFAULT 1`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE flows ADD COLUMN IF NOT EXISTS revision INTEGER NOT NULL DEFAULT 1`,
+			},
+		},
+	},
+	{
+		version:     7,
+		description: "create run_artifacts table",
+		up: map[Dialect][]string{
+			DialectSQLite: {
+				`CREATE TABLE IF NOT EXISTS run_artifacts (
+					id TEXT PRIMARY KEY,
+					flow_id TEXT NOT NULL REFERENCES flows(id) ON DELETE CASCADE,
+					run_id TEXT,
+					kind TEXT NOT NULL,
+					name TEXT,
+					content_type TEXT,
+					size INTEGER NOT NULL,
+					sha256 TEXT NOT NULL,
+					data BLOB,
+					path TEXT,
+					created_at DATETIME NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_run_artifacts_flow ON run_artifacts (flow_id, run_id)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE IF NOT EXISTS run_artifacts (
+					id TEXT PRIMARY KEY,
+					flow_id TEXT NOT NULL REFERENCES flows(id) ON DELETE CASCADE,
+					run_id TEXT,
+					kind TEXT NOT NULL,
+					name TEXT,
+					content_type TEXT,
+					size BIGINT NOT NULL,
+					sha256 TEXT NOT NULL,
+					data BYTEA,
+					path TEXT,
+					created_at TIMESTAMPTZ NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_run_artifacts_flow ON run_artifacts (flow_id, run_id)`,
+			},
+		},
+	},
+	{
+		version:     8,
+		description: "create schemas table",
+		up: map[Dialect][]string{
+			DialectSQLite: {
+				`CREATE TABLE IF NOT EXISTS schemas (
+					type TEXT NOT NULL,
+					version TEXT NOT NULL,
+					definition TEXT NOT NULL,
+					created_at DATETIME NOT NULL,
+					PRIMARY KEY (type, version)
+				)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE IF NOT EXISTS schemas (
+					type TEXT NOT NULL,
+					version TEXT NOT NULL,
+					definition TEXT NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL,
+					PRIMARY KEY (type, version)
+				)`,
+			},
+		},
+	},
+	{
+		version:     9,
+		description: "create alert_rules table",
+		up: map[Dialect][]string{
+			DialectSQLite: {
+				`CREATE TABLE IF NOT EXISTS alert_rules (
+					id TEXT PRIMARY KEY,
+					flow_id TEXT NOT NULL REFERENCES flows(id) ON DELETE CASCADE,
+					name TEXT,
+					condition TEXT NOT NULL,
+					threshold REAL NOT NULL,
+					window_seconds INTEGER NOT NULL,
+					enabled BOOLEAN NOT NULL,
+					created_at DATETIME NOT NULL,
+					updated_at DATETIME NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_alert_rules_flow ON alert_rules (flow_id)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE IF NOT EXISTS alert_rules (
+					id TEXT PRIMARY KEY,
+					flow_id TEXT NOT NULL REFERENCES flows(id) ON DELETE CASCADE,
+					name TEXT,
+					condition TEXT NOT NULL,
+					threshold DOUBLE PRECISION NOT NULL,
+					window_seconds INTEGER NOT NULL,
+					enabled BOOLEAN NOT NULL,
+					created_at TIMESTAMPTZ NOT NULL,
+					updated_at TIMESTAMPTZ NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_alert_rules_flow ON alert_rules (flow_id)`,
+			},
+		},
+	},
+	{
+		version:     10,
+		description: "add flows params column",
+		up: map[Dialect][]string{
+			DialectSQLite: {
+				`ALTER TABLE flows ADD COLUMN params TEXT`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE flows ADD COLUMN IF NOT EXISTS params TEXT`,
+			},
+		},
+	},
+	{
+		version:     11,
+		description: "create leases table",
+		up: map[Dialect][]string{
+			DialectSQLite: {
+				`CREATE TABLE IF NOT EXISTS leases (
+					name TEXT PRIMARY KEY,
+					holder TEXT NOT NULL,
+					acquired_at DATETIME NOT NULL,
+					expires_at DATETIME NOT NULL
+				)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE IF NOT EXISTS leases (
+					name TEXT PRIMARY KEY,
+					holder TEXT NOT NULL,
+					acquired_at TIMESTAMPTZ NOT NULL,
+					expires_at TIMESTAMPTZ NOT NULL
+				)`,
+			},
+		},
+	},
+	{
+		version:     12,
+		description: "add run_artifacts encoding and stored_size columns",
+		up: map[Dialect][]string{
+			DialectSQLite: {
+				`ALTER TABLE run_artifacts ADD COLUMN encoding TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE run_artifacts ADD COLUMN stored_size INTEGER NOT NULL DEFAULT 0`,
+				`UPDATE run_artifacts SET stored_size = size`,
+			},
+			DialectPostgres: {
+				`ALTER TABLE run_artifacts ADD COLUMN IF NOT EXISTS encoding TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE run_artifacts ADD COLUMN IF NOT EXISTS stored_size BIGINT NOT NULL DEFAULT 0`,
+				`UPDATE run_artifacts SET stored_size = size`,
+			},
+		},
+	},
+	{
+		version:     13,
+		description: "create node_logs table",
+		up: map[Dialect][]string{
+			DialectSQLite: {
+				`CREATE TABLE IF NOT EXISTS node_logs (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					run_id TEXT NOT NULL,
+					flow_id TEXT NOT NULL,
+					node_id TEXT NOT NULL,
+					level TEXT NOT NULL,
+					message TEXT NOT NULL,
+					fields TEXT,
+					error TEXT,
+					logged_at DATETIME NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_node_logs_run ON node_logs (run_id, id)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE IF NOT EXISTS node_logs (
+					id BIGSERIAL PRIMARY KEY,
+					run_id TEXT NOT NULL,
+					flow_id TEXT NOT NULL,
+					node_id TEXT NOT NULL,
+					level TEXT NOT NULL,
+					message TEXT NOT NULL,
+					fields TEXT,
+					error TEXT,
+					logged_at TIMESTAMPTZ NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_node_logs_run ON node_logs (run_id, id)`,
+			},
+		},
+	},
+	{
+		version:     14,
+		description: "create message_hops table",
+		up: map[Dialect][]string{
+			DialectSQLite: {
+				`CREATE TABLE IF NOT EXISTS message_hops (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					run_id TEXT NOT NULL,
+					flow_id TEXT NOT NULL,
+					node_id TEXT NOT NULL,
+					message_id TEXT NOT NULL,
+					source TEXT NOT NULL,
+					input TEXT NOT NULL,
+					outputs TEXT,
+					port TEXT NOT NULL,
+					event TEXT NOT NULL,
+					error TEXT,
+					started_at DATETIME NOT NULL,
+					finished_at DATETIME NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_message_hops_run ON message_hops (run_id, id)`,
+			},
+			DialectPostgres: {
+				`CREATE TABLE IF NOT EXISTS message_hops (
+					id BIGSERIAL PRIMARY KEY,
+					run_id TEXT NOT NULL,
+					flow_id TEXT NOT NULL,
+					node_id TEXT NOT NULL,
+					message_id TEXT NOT NULL,
+					source TEXT NOT NULL,
+					input TEXT NOT NULL,
+					outputs TEXT,
+					port TEXT NOT NULL,
+					event TEXT NOT NULL,
+					error TEXT,
+					started_at TIMESTAMPTZ NOT NULL,
+					finished_at TIMESTAMPTZ NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS idx_message_hops_run ON message_hops (run_id, id)`,
+			},
+		},
+	},
+}
+
+// migrate brings the database schema up to the latest migration
+func (s *sqlStore) migrate() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			description TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)
+	`
+	if _, err := s.db.Exec(query); err != nil {
+		s.log.Error("Failed to create migrations table", err, types.Fields{
+			"function": "migrate",
+		})
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := s.applyMigration(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyMigration runs a single migration inside a transaction
+func (s *sqlStore) applyMigration(m migration) error {
+	statements, ok := m.up[s.dialect]
+	if !ok {
+		return fmt.Errorf("migration %d has no statements for dialect %s", m.version, s.dialect)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %d: %w", m.version, err)
+	}
+
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				s.log.Error("Failed to roll back migration", rbErr, types.Fields{
+					"function": "applyMigration",
+					"version":  m.version,
+				})
+			}
+			s.log.Error("Failed to apply migration", err, types.Fields{
+				"function": "applyMigration",
+				"version":  m.version,
+			})
+			return fmt.Errorf("failed to apply migration %d (%s): %w", m.version, m.description, err)
+		}
+	}
+
+	query := `INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)`
+	if _, err := tx.Exec(s.rebind(query), m.version, m.description, time.Now()); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			s.log.Error("Failed to roll back migration", rbErr, types.Fields{
+				"function": "applyMigration",
+				"version":  m.version,
+			})
+		}
+		return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d: %w", m.version, err)
+	}
+
+	s.log.Info("Applied database migration", types.Fields{
+		"function":    "applyMigration",
+		"version":     m.version,
+		"description": m.description,
+		"dialect":     s.dialect,
+	})
+
+	return nil
+}
+
+// SchemaVersion returns the version of the most recently applied migration
+func (s *sqlStore) SchemaVersion() (int, error) {
+	var version int
+	query := `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`
+	if err := s.db.QueryRow(query).Scan(&version); err != nil {
+		s.log.Error("Failed to read schema version", err, types.Fields{
+			"function": "SchemaVersion",
+		})
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
@@ -6,6 +6,30 @@ import "flow-control/internal/types"
 // It is re-exported from the types package for convenience.
 type RuntimeFlow = types.RuntimeFlow
 
+// FlowStep represents a single node of a flow.
+// It is re-exported from the types package for convenience.
+type FlowStep = types.FlowStep
+
+// FlowVersion is a snapshot of a flow's config.
+// It is re-exported from the types package for convenience.
+type FlowVersion = types.FlowVersion
+
+// AuditRecord describes a change made to a stored entity.
+// It is re-exported from the types package for convenience.
+type AuditRecord = types.AuditRecord
+
+// RunArtifact describes a payload captured while a flow ran.
+// It is re-exported from the types package for convenience.
+type RunArtifact = types.RunArtifact
+
+// SchemaRecord is the stored form of a custom schema.
+// It is re-exported from the types package for convenience.
+type SchemaRecord = types.SchemaRecord
+
+// AlertRule is a condition on a flow's metrics that raises an alert.
+// It is re-exported from the types package for convenience.
+type AlertRule = types.AlertRule
+
 // FlowEvent represents a real-time event from a flow.
 // It is re-exported from the types package for convenience.
 type FlowEvent = types.FlowEvent
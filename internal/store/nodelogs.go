@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"flow-control/internal/types"
+)
+
+// DefaultNodeLogLimit is the most entries ListNodeLogs returns when the
+// filter sets no limit
+const DefaultNodeLogLimit = 1000
+
+// NodeLogFilter selects the node log entries of a run
+type NodeLogFilter struct {
+	// RunID is the run whose entries are listed
+	RunID string
+	// FlowID and NodeID, when set, narrow the list to a flow or node
+	FlowID string
+	NodeID string
+	// Levels, when set, narrow the list to entries at these levels
+	Levels []types.LogLevel
+	// Limit is the most entries listed; DefaultNodeLogLimit when zero
+	Limit int
+}
+
+// SaveNodeLogs records the entries nodes logged during runs
+func (s *sqlStore) SaveNodeLogs(logs []types.NodeLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	err := s.WithTx(context.Background(), func(tx *Tx) error {
+		for _, entry := range logs {
+			var fields sql.NullString
+			if len(entry.Fields) > 0 {
+				encoded, err := json.Marshal(entry.Fields)
+				if err != nil {
+					return fmt.Errorf("failed to encode fields: %w", err)
+				}
+				fields = sql.NullString{String: string(encoded), Valid: true}
+			}
+			if _, err := tx.Exec(`
+				INSERT INTO node_logs (run_id, flow_id, node_id, level, message, fields, error, logged_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			`, entry.RunID, entry.FlowID, entry.NodeID, string(entry.Level), entry.Message, fields, entry.Error, entry.Timestamp.UTC()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.log.Error("Failed to save node logs", err, types.Fields{
+			"function": "SaveNodeLogs",
+			"run_id":   logs[0].RunID,
+		})
+		return fmt.Errorf("failed to save node logs: %w", err)
+	}
+	return nil
+}
+
+// ListNodeLogs returns the node log entries of a run, in the order they
+// were logged
+func (s *sqlStore) ListNodeLogs(filter NodeLogFilter) ([]*types.NodeLog, error) {
+	query := `SELECT run_id, flow_id, node_id, level, message, fields, error, logged_at FROM node_logs WHERE run_id = ?`
+	args := []interface{}{filter.RunID}
+	if filter.FlowID != "" {
+		query += ` AND flow_id = ?`
+		args = append(args, filter.FlowID)
+	}
+	if filter.NodeID != "" {
+		query += ` AND node_id = ?`
+		args = append(args, filter.NodeID)
+	}
+	if len(filter.Levels) > 0 {
+		query += ` AND level IN (?` + strings.Repeat(", ?", len(filter.Levels)-1) + `)`
+		for _, level := range filter.Levels {
+			args = append(args, string(level))
+		}
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultNodeLogLimit
+	}
+	query += ` ORDER BY id LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := s.query(query, args...)
+	if err != nil {
+		s.log.Error("Failed to list node logs", err, types.Fields{
+			"function": "ListNodeLogs",
+			"run_id":   filter.RunID,
+		})
+		return nil, fmt.Errorf("failed to list node logs: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.log.Error("Failed to close rows", err, types.Fields{
+				"function": "ListNodeLogs",
+			})
+		}
+	}()
+
+	logs := []*types.NodeLog{}
+	for rows.Next() {
+		entry := &types.NodeLog{}
+		var level string
+		var fields, logErr sql.NullString
+		if err := rows.Scan(&entry.RunID, &entry.FlowID, &entry.NodeID, &level, &entry.Message, &fields, &logErr, &entry.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan node log: %w", err)
+		}
+		entry.Level = types.LogLevel(level)
+		entry.Error = logErr.String
+		if fields.Valid {
+			if err := json.Unmarshal([]byte(fields.String), &entry.Fields); err != nil {
+				return nil, fmt.Errorf("failed to decode node log fields: %w", err)
+			}
+		}
+		logs = append(logs, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list node logs: %w", err)
+	}
+	return logs, nil
+}
@@ -0,0 +1,378 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// defaultPurgeBatchSize is the number of rows deleted per transaction when
+// JanitorOptions.BatchSize is not set
+const defaultPurgeBatchSize = 500
+
+// purgedRowsMetric is the counter reporting rows removed by the janitor
+const purgedRowsMetric = "store_retention_purged_rows_total"
+
+// prunableTable describes how rows of a table age out
+type prunableTable struct {
+	// timeColumn holds the timestamp compared against the retention cutoff
+	timeColumn string
+	// condition further restricts which expired rows may be deleted
+	condition string
+	// fileColumn, when set, names the column holding the spilled file of
+	// each row, which is removed along with the row
+	fileColumn string
+}
+
+// prunableTables lists the tables a retention policy may name
+var prunableTables = map[string]prunableTable{
+	"audit_log":     {timeColumn: "created_at"},
+	"node_logs":     {timeColumn: "logged_at"},
+	"message_hops":  {timeColumn: "started_at"},
+	"run_artifacts": {timeColumn: "created_at", fileColumn: "path"},
+	// The newest version of every flow is kept regardless of age
+	"flow_versions": {
+		timeColumn: "created_at",
+		condition:  "version < (SELECT MAX(v.version) FROM flow_versions v WHERE v.flow_id = flow_versions.flow_id)",
+	},
+}
+
+// runTables lists the tables recording flow runs. Run artifacts are purged
+// with them unless they have a policy of their own.
+var runTables = []string{"node_logs", "message_hops"}
+
+// RetentionPolicy bounds how long the rows of a table are kept
+type RetentionPolicy struct {
+	// Table is the table to prune, e.g. "audit_log"
+	Table string
+	// MaxAge is how long rows are kept; zero keeps rows forever
+	MaxAge time.Duration
+}
+
+// JanitorOptions configures a Janitor
+type JanitorOptions struct {
+	// Interval is the time between purge runs
+	Interval time.Duration
+	// BatchSize is the number of rows deleted per transaction so that
+	// purges never hold the write lock for long
+	BatchSize int
+	// Policies lists the retention policy of each pruned table
+	Policies []RetentionPolicy
+	// ArtifactDir holds the spilled payloads of run artifacts, which are
+	// removed with their rows; it defaults to the artifact store's directory
+	ArtifactDir string
+}
+
+// Janitor periodically deletes rows that have outlived their retention
+// policy. It implements types.MetricsCollector, reporting purged row counts
+// per table.
+type Janitor struct {
+	store *sqlStore
+
+	mu     sync.Mutex
+	opts   JanitorOptions
+	purged map[string]int64
+}
+
+// sqlBacked is implemented by stores built on sqlStore
+type sqlBacked interface {
+	base() *sqlStore
+}
+
+// base returns the shared SQL implementation of a store
+func (s *sqlStore) base() *sqlStore {
+	return s
+}
+
+// NewJanitor creates a janitor for the given store. Every policy must name a
+// table that supports pruning.
+func NewJanitor(db Store, opts JanitorOptions) (*Janitor, error) {
+	backed, ok := db.(sqlBacked)
+	if !ok {
+		return nil, fmt.Errorf("store does not support retention policies")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultPurgeBatchSize
+	}
+	if opts.ArtifactDir == "" {
+		opts.ArtifactDir = DefaultArtifactOptions().Dir
+	}
+	if err := checkPolicies(opts.Policies); err != nil {
+		return nil, err
+	}
+
+	return &Janitor{
+		store:  backed.base(),
+		opts:   opts,
+		purged: make(map[string]int64),
+	}, nil
+}
+
+// checkPolicies verifies that every policy names a table that supports
+// pruning and has a valid max age
+func checkPolicies(policies []RetentionPolicy) error {
+	for _, policy := range policies {
+		if _, ok := prunableTables[policy.Table]; !ok {
+			return fmt.Errorf("retention policy names unknown table: %s", policy.Table)
+		}
+		if policy.MaxAge < 0 {
+			return fmt.Errorf("retention max age for %s cannot be negative", policy.Table)
+		}
+	}
+	return nil
+}
+
+// SetPolicies replaces the batch size and retention policies of a running
+// janitor, from the next purge on. A batch size of zero selects the default.
+func (j *Janitor) SetPolicies(batchSize int, policies []RetentionPolicy) error {
+	if err := checkPolicies(policies); err != nil {
+		return err
+	}
+	if batchSize <= 0 {
+		batchSize = defaultPurgeBatchSize
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.opts.BatchSize = batchSize
+	j.opts.Policies = append([]RetentionPolicy(nil), policies...)
+	return nil
+}
+
+// Run purges expired rows every Interval until ctx is done
+func (j *Janitor) Run(ctx context.Context) error {
+	if j.opts.Interval <= 0 {
+		return fmt.Errorf("janitor interval must be positive: %s", j.opts.Interval)
+	}
+
+	ticker := time.NewTicker(j.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if _, err := j.Purge(ctx); err != nil && ctx.Err() == nil {
+				j.store.log.Error("Retention purge failed", err, types.Fields{
+					"function": "Run",
+				})
+			}
+		}
+	}
+}
+
+// Purge deletes expired rows from every table with a policy and returns the
+// number of rows removed per table. Run artifacts without a policy of their
+// own are kept as long as the runs, and their spilled payloads are removed
+// with them.
+func (j *Janitor) Purge(ctx context.Context) (map[string]int64, error) {
+	j.mu.Lock()
+	opts := j.opts
+	j.mu.Unlock()
+
+	counts := make(map[string]int64)
+	for _, policy := range withArtifacts(opts.Policies) {
+		if policy.MaxAge == 0 {
+			continue
+		}
+
+		n, err := j.purgeTable(ctx, policy, opts.BatchSize, opts.ArtifactDir)
+		counts[policy.Table] = n
+		j.record(policy.Table, n)
+		if err != nil {
+			return counts, fmt.Errorf("failed to purge %s: %w", policy.Table, err)
+		}
+
+		if n > 0 {
+			j.store.log.Info("Purged expired rows", types.Fields{
+				"function": "Purge",
+				"table":    policy.Table,
+				"rows":     n,
+			})
+		}
+	}
+	return counts, nil
+}
+
+// withArtifacts adds a run_artifacts policy keeping artifacts as long as the
+// longest run table policy, unless run_artifacts has a policy already
+func withArtifacts(policies []RetentionPolicy) []RetentionPolicy {
+	var maxAge time.Duration
+	for _, policy := range policies {
+		if policy.Table == "run_artifacts" {
+			return policies
+		}
+		for _, table := range runTables {
+			if policy.Table == table && policy.MaxAge > maxAge {
+				maxAge = policy.MaxAge
+			}
+		}
+	}
+	if maxAge == 0 {
+		return policies
+	}
+	return append(append([]RetentionPolicy(nil), policies...), RetentionPolicy{Table: "run_artifacts", MaxAge: maxAge})
+}
+
+// purgeTable deletes a table's expired rows in batches, each in its own
+// transaction, until none remain. The files of deleted rows are removed from
+// fileDir once their batch is committed.
+func (j *Janitor) purgeTable(ctx context.Context, policy RetentionPolicy, batchSize int, fileDir string) (int64, error) {
+	table := prunableTables[policy.Table]
+	rowID := "rowid"
+	if j.store.dialect == DialectPostgres {
+		rowID = "ctid"
+	}
+
+	where := table.timeColumn + ` < ?`
+	if table.condition != "" {
+		where += ` AND ` + table.condition
+	}
+	query := `DELETE FROM ` + policy.Table + ` WHERE ` + rowID + ` IN (
+		SELECT ` + rowID + ` FROM ` + policy.Table + ` WHERE ` + where + ` LIMIT ?
+	)`
+	if table.fileColumn != "" {
+		query += ` RETURNING ` + table.fileColumn
+	}
+
+	cutoff := time.Now().Add(-policy.MaxAge)
+	var total int64
+	for {
+		var (
+			deleted int64
+			files   []string
+		)
+		err := j.store.WithTx(ctx, func(tx *Tx) error {
+			if table.fileColumn == "" {
+				result, err := tx.Exec(query, cutoff, batchSize)
+				if err != nil {
+					return err
+				}
+				deleted, err = result.RowsAffected()
+				return err
+			}
+			var err error
+			deleted, files, err = j.deleteReturning(tx, query, cutoff, batchSize)
+			return err
+		})
+		if err != nil {
+			return total, err
+		}
+		j.removeFiles(fileDir, files)
+
+		total += deleted
+		if deleted < int64(batchSize) {
+			return total, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
+
+// deleteReturning runs a delete returning the file column of each deleted
+// row, and returns the number of rows deleted and their files
+func (j *Janitor) deleteReturning(tx *Tx, query string, cutoff time.Time, batchSize int) (int64, []string, error) {
+	rows, err := tx.Query(query, cutoff, batchSize)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			j.store.log.Error("Failed to close rows", err, types.Fields{
+				"function": "deleteReturning",
+			})
+		}
+	}()
+
+	var (
+		deleted int64
+		files   []string
+	)
+	for rows.Next() {
+		var file sql.NullString
+		if err := rows.Scan(&file); err != nil {
+			return 0, nil, err
+		}
+		deleted++
+		if file.Valid {
+			files = append(files, file.String)
+		}
+	}
+	return deleted, files, rows.Err()
+}
+
+// removeFiles removes the files of purged rows. Files that cannot be
+// removed are logged and left for ArtifactStore.RemoveOrphans.
+func (j *Janitor) removeFiles(dir string, files []string) {
+	for _, name := range files {
+		path := filepath.Join(dir, filepath.Base(name))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			j.store.log.Error("Failed to remove purged file", err, types.Fields{
+				"function": "removeFiles",
+				"path":     path,
+			})
+		}
+	}
+}
+
+// record adds purged rows to the table's running total
+func (j *Janitor) record(table string, n int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.purged[table] += n
+}
+
+// Stats returns the total number of rows purged per table since the janitor
+// was created
+func (j *Janitor) Stats() map[string]int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	stats := make(map[string]int64, len(j.purged))
+	for table, n := range j.purged {
+		stats[table] = n
+	}
+	return stats
+}
+
+// Collect reports the purged row counters
+func (j *Janitor) Collect() ([]types.Metric, error) {
+	stats := j.Stats()
+	tables := make([]string, 0, len(stats))
+	for table := range stats {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	now := time.Now()
+	metrics := make([]types.Metric, 0, len(tables))
+	for _, table := range tables {
+		metrics = append(metrics, types.Metric{
+			Name:   purgedRowsMetric,
+			Type:   types.MetricTypeCounter,
+			Value:  float64(stats[table]),
+			Labels: map[string]string{"table": table},
+			Time:   now,
+		})
+	}
+	return metrics, nil
+}
+
+// Describe describes the purged row counters
+func (j *Janitor) Describe() []types.MetricDesc {
+	return []types.MetricDesc{{
+		Name:        purgedRowsMetric,
+		Type:        types.MetricTypeCounter,
+		Description: "Rows deleted by retention policies",
+		Labels:      []string{"table"},
+	}}
+}
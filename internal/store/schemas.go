@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// SaveSchema stores a custom schema definition. It returns ErrSchemaExists
+// if the type and version are already stored.
+func (s *sqlStore) SaveSchema(record *types.SchemaRecord) error {
+	if record.Type == "" || record.Version == "" {
+		return fmt.Errorf("schema type and version cannot be empty")
+	}
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+
+	err := s.WithTx(context.Background(), func(tx *Tx) error {
+		var exists int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM schemas WHERE type = ? AND version = ?`,
+			record.Type, record.Version).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			return fmt.Errorf("%w: %s version %s", ErrSchemaExists, record.Type, record.Version)
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO schemas (type, version, definition, created_at)
+			VALUES (?, ?, ?, ?)
+		`, record.Type, record.Version, record.Definition, record.CreatedAt)
+		return err
+	})
+	if err != nil {
+		s.log.Error("Failed to save schema", err, types.Fields{
+			"function": "SaveSchema",
+			"type":     record.Type,
+			"version":  record.Version,
+		})
+		return fmt.Errorf("failed to save schema: %w", err)
+	}
+	return nil
+}
+
+// ListSchemas returns every stored schema in the order it was saved
+func (s *sqlStore) ListSchemas() ([]*types.SchemaRecord, error) {
+	rows, err := s.query(`
+		SELECT type, version, definition, created_at
+		FROM schemas
+		ORDER BY created_at, type, version
+	`)
+	if err != nil {
+		s.log.Error("Failed to list schemas", err, types.Fields{
+			"function": "ListSchemas",
+		})
+		return nil, fmt.Errorf("failed to list schemas: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.log.Error("Failed to close rows", err, types.Fields{
+				"function": "ListSchemas",
+			})
+		}
+	}()
+
+	records := []*types.SchemaRecord{}
+	for rows.Next() {
+		record := &types.SchemaRecord{}
+		if err := rows.Scan(&record.Type, &record.Version, &record.Definition, &record.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema: %w", err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list schemas: %w", err)
+	}
+	return records, nil
+}
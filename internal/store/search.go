@@ -0,0 +1,259 @@
+package store
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	"flow-control/internal/types"
+)
+
+// FlowSearchResult is a flow matched by SearchFlows
+type FlowSearchResult struct {
+	// Flow is the matching flow
+	Flow *types.RuntimeFlow `json:"flow"`
+
+	// Snippet is an excerpt of the flow config as HTML, with matches wrapped
+	// in <mark> tags and the config text escaped
+	Snippet string `json:"snippet"`
+
+	// Rank orders results by relevance; lower is better
+	Rank float64 `json:"rank"`
+}
+
+const (
+	// defaultSearchLimit caps the number of results when no limit is given
+	defaultSearchLimit = 50
+
+	// snippetOpen and snippetClose wrap matched terms in snippets
+	snippetOpen  = "<mark>"
+	snippetClose = "</mark>"
+
+	// snippetStart and snippetStop delimit matched terms in the snippets
+	// that databases build, which are escaped as HTML before the delimiters
+	// are replaced by snippetOpen and snippetClose
+	snippetStart = "\x02"
+	snippetStop  = "\x03"
+
+	// snippetRadius is the number of characters kept around a match
+	snippetRadius = 40
+)
+
+// postgresSearchDocument is the expression indexed for PostgreSQL search
+const postgresSearchDocument = `name || ' ' || COALESCE(description, '') || ' ' || config`
+
+// SearchFlows performs a full-text search over flow names, descriptions and
+// configs, returning the best matches first
+func (s *sqlStore) SearchFlows(query string, limit int) ([]FlowSearchResult, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return []FlowSearchResult{}, nil
+	}
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+
+	var (
+		results []FlowSearchResult
+		err     error
+	)
+	switch s.dialect {
+	case DialectPostgres:
+		results, err = s.searchPostgres(terms, limit)
+	default:
+		results, err = s.searchSQLite(terms, limit)
+	}
+	if err != nil {
+		s.log.Error("Failed to search flows", err, types.Fields{
+			"function": "SearchFlows",
+			"query":    query,
+		})
+		return nil, fmt.Errorf("failed to search flows: %w", err)
+	}
+
+	flows := make([]*types.RuntimeFlow, len(results))
+	for i := range results {
+		flows[i] = results[i].Flow
+	}
+	if err := s.loadTags(flows); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// searchPostgres searches flows using PostgreSQL text search
+func (s *sqlStore) searchPostgres(terms []string, limit int) ([]FlowSearchResult, error) {
+	query := `
+		SELECT id, name, description, version, config, status, created_at, updated_at, revision,
+			ts_headline('english', config, q, 'StartSel=' || chr(2) || ', StopSel=' || chr(3) || ', MaxFragments=1, MaxWords=16, MinWords=4'),
+			-ts_rank(to_tsvector('english', ` + postgresSearchDocument + `), q)
+		FROM flows, plainto_tsquery('english', ?) q
+		WHERE to_tsvector('english', ` + postgresSearchDocument + `) @@ q
+		ORDER BY 11
+		LIMIT ?
+	`
+	return s.scanSearchResults(query, strings.Join(terms, " "), limit)
+}
+
+// searchLike searches flows with LIKE matching when no full-text index is
+// available. Every term must appear in the name, description or config.
+func (s *sqlStore) searchLike(terms []string, limit int) ([]FlowSearchResult, error) {
+	conditions := make([]string, 0, len(terms))
+	args := make([]interface{}, 0, len(terms)*3+1)
+	for _, term := range terms {
+		conditions = append(conditions, `(name LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\' OR config LIKE ? ESCAPE '\')`)
+		pattern := "%" + likeEscaper.Replace(term) + "%"
+		args = append(args, pattern, pattern, pattern)
+	}
+	args = append(args, limit)
+
+	query := `
+		SELECT id, name, description, version, config, status, created_at, updated_at, revision, '', 0
+		FROM flows
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY updated_at DESC
+		LIMIT ?
+	`
+	results, err := s.scanSearchResults(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		results[i].Snippet = highlight(results[i].Flow.Config, terms)
+	}
+	return results, nil
+}
+
+// likeEscaper escapes LIKE wildcards so that terms match literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// scanSearchResults runs a search query returning flow columns followed by
+// snippet and rank columns
+func (s *sqlStore) scanSearchResults(query string, args ...interface{}) ([]FlowSearchResult, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.log.Error("Failed to close rows", err, types.Fields{
+				"function": "scanSearchResults",
+			})
+		}
+	}()
+
+	results := []FlowSearchResult{}
+	for rows.Next() {
+		flow := &types.RuntimeFlow{}
+		result := FlowSearchResult{Flow: flow}
+		var snippet string
+		err := rows.Scan(
+			&flow.ID,
+			&flow.Name,
+			&flow.Description,
+			&flow.Version,
+			&flow.Config,
+			&flow.Status,
+			&flow.CreatedAt,
+			&flow.UpdatedAt,
+			&flow.Revision,
+			&snippet,
+			&result.Rank,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		result.Snippet = markSnippet(snippet)
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// searchTerms splits a user query into plain search terms, discarding
+// punctuation that would otherwise be interpreted as query syntax
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
+
+// highlight returns an excerpt of text around the first matching term as
+// HTML, with all term occurrences wrapped in snippet markers
+func highlight(text string, terms []string) string {
+	lower := foldASCII(text)
+	folded := make([]string, len(terms))
+	start := -1
+	for i, term := range terms {
+		folded[i] = foldASCII(term)
+		if n := strings.Index(lower, folded[i]); n >= 0 && (start < 0 || n < start) {
+			start = n
+		}
+	}
+	if start < 0 {
+		return ""
+	}
+
+	from := start - snippetRadius
+	if from < 0 {
+		from = 0
+	}
+	to := start + snippetRadius
+	if to > len(text) {
+		to = len(text)
+	}
+
+	// Widen the window to whole words
+	for from > 0 && text[from-1] != ' ' && text[from-1] != '\n' {
+		from--
+	}
+	for to < len(text) && text[to] != ' ' && text[to] != '\n' {
+		to++
+	}
+
+	var out strings.Builder
+	if from > 0 {
+		out.WriteString("...")
+	}
+	for i := from; i < to; {
+		matched := false
+		for _, term := range folded {
+			if strings.HasPrefix(lower[i:to], term) {
+				out.WriteString(snippetOpen + html.EscapeString(text[i:i+len(term)]) + snippetClose)
+				i += len(term)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			out.WriteString(html.EscapeString(text[i : i+1]))
+			i++
+		}
+	}
+	if to < len(text) {
+		out.WriteString("...")
+	}
+	return out.String()
+}
+
+// markSnippet turns a snippet built by the database into HTML, escaping its
+// text and marking the terms between snippetStart and snippetStop
+func markSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, snippetStart, snippetOpen)
+	return strings.ReplaceAll(snippet, snippetStop, snippetClose)
+}
+
+// foldASCII lowercases ASCII letters without changing byte offsets
+func foldASCII(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + ('a' - 'A')
+		}
+	}
+	return string(b)
+}
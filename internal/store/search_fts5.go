@@ -0,0 +1,90 @@
+//go:build sqlite_fts5
+
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"flow-control/internal/types"
+)
+
+// ensureSearchIndex creates the FTS5 index over flows and the triggers that
+// keep it in sync, rebuilding it from existing flows on first creation
+func (s *sqlStore) ensureSearchIndex() error {
+	var exists int
+	query := `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'flows_fts'`
+	if err := s.db.QueryRow(query).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check search index: %w", err)
+	}
+	if exists > 0 {
+		return nil
+	}
+
+	statements := []string{
+		`CREATE VIRTUAL TABLE flows_fts USING fts5(
+			name, description, config,
+			content='flows', content_rowid='rowid'
+		)`,
+		`CREATE TRIGGER flows_fts_insert AFTER INSERT ON flows BEGIN
+			INSERT INTO flows_fts (rowid, name, description, config)
+			VALUES (new.rowid, new.name, new.description, new.config);
+		END`,
+		`CREATE TRIGGER flows_fts_delete AFTER DELETE ON flows BEGIN
+			INSERT INTO flows_fts (flows_fts, rowid, name, description, config)
+			VALUES ('delete', old.rowid, old.name, old.description, old.config);
+		END`,
+		`CREATE TRIGGER flows_fts_update AFTER UPDATE ON flows BEGIN
+			INSERT INTO flows_fts (flows_fts, rowid, name, description, config)
+			VALUES ('delete', old.rowid, old.name, old.description, old.config);
+			INSERT INTO flows_fts (rowid, name, description, config)
+			VALUES (new.rowid, new.name, new.description, new.config);
+		END`,
+		`INSERT INTO flows_fts (flows_fts) VALUES ('rebuild')`,
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin search index creation: %w", err)
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				s.log.Error("Failed to roll back search index creation", rbErr, types.Fields{
+					"function": "ensureSearchIndex",
+				})
+			}
+			return fmt.Errorf("failed to create search index: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit search index: %w", err)
+	}
+
+	s.log.Info("Created flow search index", types.Fields{
+		"function": "ensureSearchIndex",
+	})
+	return nil
+}
+
+// searchSQLite searches flows through the FTS5 index, ranking with bm25
+func (s *sqlStore) searchSQLite(terms []string, limit int) ([]FlowSearchResult, error) {
+	// Quote every term so user input is never parsed as FTS5 syntax, and
+	// allow prefix matches on each term
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"*`
+	}
+
+	query := `
+		SELECT f.id, f.name, f.description, f.version, f.config, f.status, f.created_at, f.updated_at, f.revision,
+			snippet(flows_fts, 2, char(2), char(3), '...', 16),
+			bm25(flows_fts)
+		FROM flows_fts
+		JOIN flows f ON f.rowid = flows_fts.rowid
+		WHERE flows_fts MATCH ?
+		ORDER BY bm25(flows_fts)
+		LIMIT ?
+	`
+	return s.scanSearchResults(query, strings.Join(quoted, " "), limit)
+}
@@ -0,0 +1,14 @@
+//go:build !sqlite_fts5
+
+package store
+
+// ensureSearchIndex is a no-op when SQLite is built without FTS5 support.
+// Build with -tags sqlite_fts5 to enable the full-text index.
+func (s *sqlStore) ensureSearchIndex() error {
+	return nil
+}
+
+// searchSQLite falls back to LIKE matching without FTS5 support
+func (s *sqlStore) searchSQLite(terms []string, limit int) ([]FlowSearchResult, error) {
+	return s.searchLike(terms, limit)
+}
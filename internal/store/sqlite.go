@@ -0,0 +1,66 @@
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"flow-control/internal/types"
+
+	// Import sqlite3 driver for database connectivity
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteStore is a Store backed by an embedded SQLite database
+type SQLiteStore struct {
+	*sqlStore
+}
+
+// New creates a new SQLite-backed store at the given path
+func New(dbPath string, log types.Logger, opts ...Options) (*SQLiteStore, error) {
+	cfg := DefaultOptions()
+	if len(opts) > 0 {
+		cfg = opts[0]
+	}
+
+	base, err := openSQLStore(DialectSQLite, sqliteDSN(dbPath, cfg), log, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := base.ensureSearchIndex(); err != nil {
+		if closeErr := base.db.Close(); closeErr != nil {
+			log.Error("Failed to close database after search index error", closeErr, types.Fields{
+				"function": "New",
+				"path":     dbPath,
+			})
+		}
+		return nil, err
+	}
+
+	return &SQLiteStore{sqlStore: base}, nil
+}
+
+// sqliteDSN appends connection pragmas to the database path. Pragmas are
+// passed through the DSN so that every pooled connection receives them.
+func sqliteDSN(dbPath string, opts Options) string {
+	params := url.Values{}
+	if opts.JournalMode != "" {
+		params.Set("_journal_mode", opts.JournalMode)
+	}
+	if opts.BusyTimeout > 0 {
+		params.Set("_busy_timeout", fmt.Sprintf("%d", opts.BusyTimeout.Milliseconds()))
+	}
+	if opts.ForeignKeys {
+		params.Set("_foreign_keys", "on")
+	}
+	// Take the write lock when a transaction begins so concurrent writers
+	// wait on busy_timeout instead of failing on lock upgrade.
+	params.Set("_txlock", "immediate")
+
+	sep := "?"
+	if strings.Contains(dbPath, "?") {
+		sep = "&"
+	}
+	return dbPath + sep + params.Encode()
+}
@@ -0,0 +1,381 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"flow-control/internal/parser"
+	"flow-control/internal/parser/ast"
+	"flow-control/internal/parser/lexer"
+	"flow-control/internal/types"
+)
+
+// stepColumns lists the flow_steps columns in scan order
+const stepColumns = `flow_id, id, position, type, config, created_at, updated_at`
+
+// ListFlowSteps returns the steps of a flow in execution order
+func (s *sqlStore) ListFlowSteps(flowID string) ([]*types.FlowStep, error) {
+	if err := s.requireFlow(flowID); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + stepColumns + ` FROM flow_steps WHERE flow_id = ? ORDER BY position`
+	rows, err := s.query(query, flowID)
+	if err != nil {
+		s.log.Error("Failed to list flow steps", err, types.Fields{
+			"function": "ListFlowSteps",
+			"flow_id":  flowID,
+		})
+		return nil, fmt.Errorf("failed to list flow steps: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.log.Error("Failed to close rows", err, types.Fields{
+				"function": "ListFlowSteps",
+			})
+		}
+	}()
+
+	steps := []*types.FlowStep{}
+	for rows.Next() {
+		step, err := scanStep(rows)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating flow steps: %w", err)
+	}
+
+	return steps, nil
+}
+
+// GetFlowStep retrieves a single step of a flow
+func (s *sqlStore) GetFlowStep(flowID, stepID string) (*types.FlowStep, error) {
+	query := `SELECT ` + stepColumns + ` FROM flow_steps WHERE flow_id = ? AND id = ?`
+	step, err := scanStep(s.queryRow(query, flowID, stepID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: %s in flow %s", ErrStepNotFound, stepID, flowID)
+		}
+		s.log.Error("Failed to get flow step", err, types.Fields{
+			"function": "GetFlowStep",
+			"flow_id":  flowID,
+			"step_id":  stepID,
+		})
+		return nil, fmt.Errorf("failed to get flow step: %w", err)
+	}
+	return step, nil
+}
+
+// CreateFlowStep inserts a step at its Position, shifting later steps down.
+// A zero or out of range Position appends the step to the end of the flow.
+func (s *sqlStore) CreateFlowStep(step *types.FlowStep) error {
+	if err := s.requireFlow(step.FlowID); err != nil {
+		return err
+	}
+
+	return s.WithTx(context.Background(), func(tx *Tx) error {
+		var count int
+		query := `SELECT COUNT(*) FROM flow_steps WHERE flow_id = ?`
+		if err := tx.QueryRow(query, step.FlowID).Scan(&count); err != nil {
+			return fmt.Errorf("failed to count flow steps: %w", err)
+		}
+
+		if step.Position < 1 || step.Position > count+1 {
+			step.Position = count + 1
+		}
+
+		query = `UPDATE flow_steps SET position = position + 1 WHERE flow_id = ? AND position >= ?`
+		if _, err := tx.Exec(query, step.FlowID, step.Position); err != nil {
+			return fmt.Errorf("failed to shift flow steps: %w", err)
+		}
+
+		return s.insertStep(tx, step)
+	})
+}
+
+// UpdateFlowStep updates the type and config of an existing step. Use
+// ReorderFlowSteps to change its position.
+func (s *sqlStore) UpdateFlowStep(step *types.FlowStep) error {
+	config, err := marshalStepConfig(step.Config)
+	if err != nil {
+		return err
+	}
+	step.UpdatedAt = time.Now()
+
+	query := `UPDATE flow_steps SET type = ?, config = ?, updated_at = ? WHERE flow_id = ? AND id = ?`
+	result, err := s.exec(query, step.Type, config, step.UpdatedAt, step.FlowID, step.ID)
+	if err != nil {
+		s.log.Error("Failed to update flow step", err, types.Fields{
+			"function": "UpdateFlowStep",
+			"flow_id":  step.FlowID,
+			"step_id":  step.ID,
+		})
+		return fmt.Errorf("failed to update flow step: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: %s in flow %s", ErrStepNotFound, step.ID, step.FlowID)
+	}
+
+	return nil
+}
+
+// DeleteFlowStep removes a step and closes the gap in positions
+func (s *sqlStore) DeleteFlowStep(flowID, stepID string) error {
+	return s.WithTx(context.Background(), func(tx *Tx) error {
+		var position int
+		query := `SELECT position FROM flow_steps WHERE flow_id = ? AND id = ?`
+		if err := tx.QueryRow(query, flowID, stepID).Scan(&position); err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("%w: %s in flow %s", ErrStepNotFound, stepID, flowID)
+			}
+			return fmt.Errorf("failed to get flow step: %w", err)
+		}
+
+		query = `DELETE FROM flow_steps WHERE flow_id = ? AND id = ?`
+		if _, err := tx.Exec(query, flowID, stepID); err != nil {
+			return fmt.Errorf("failed to delete flow step: %w", err)
+		}
+
+		query = `UPDATE flow_steps SET position = position - 1 WHERE flow_id = ? AND position > ?`
+		if _, err := tx.Exec(query, flowID, position); err != nil {
+			return fmt.Errorf("failed to shift flow steps: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// ReorderFlowSteps assigns positions to a flow's steps in the given order.
+// The list must name every step of the flow exactly once.
+func (s *sqlStore) ReorderFlowSteps(flowID string, stepIDs []string) error {
+	steps, err := s.ListFlowSteps(flowID)
+	if err != nil {
+		return err
+	}
+
+	if len(stepIDs) != len(steps) {
+		return fmt.Errorf("%w: expected %d steps, got %d", ErrInvalidStepOrder, len(steps), len(stepIDs))
+	}
+	remaining := make(map[string]bool, len(steps))
+	for _, step := range steps {
+		remaining[step.ID] = true
+	}
+	for _, id := range stepIDs {
+		if !remaining[id] {
+			return fmt.Errorf("%w: unknown or repeated step %s", ErrInvalidStepOrder, id)
+		}
+		delete(remaining, id)
+	}
+
+	return s.WithTx(context.Background(), func(tx *Tx) error {
+		query := `UPDATE flow_steps SET position = ?, updated_at = ? WHERE flow_id = ? AND id = ?`
+		now := time.Now()
+		for i, id := range stepIDs {
+			if _, err := tx.Exec(query, i+1, now, flowID, id); err != nil {
+				return fmt.Errorf("failed to reorder flow step %s: %w", id, err)
+			}
+		}
+		return nil
+	})
+}
+
+// SetFlowSteps replaces all steps of a flow, numbering them in slice order
+func (s *sqlStore) SetFlowSteps(flowID string, steps []*types.FlowStep) error {
+	if err := s.requireFlow(flowID); err != nil {
+		return err
+	}
+
+	return s.WithTx(context.Background(), func(tx *Tx) error {
+		return s.replaceSteps(tx, flowID, steps)
+	})
+}
+
+// SyncFlowSteps replaces a flow's steps with those derived from its config
+func (s *sqlStore) SyncFlowSteps(flowID string) ([]*types.FlowStep, error) {
+	flow, err := s.GetFlow(flowID)
+	if err != nil {
+		return nil, err
+	}
+
+	steps, err := DeriveFlowSteps(flow.ID, flow.Config, s.log)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.SetFlowSteps(flow.ID, steps); err != nil {
+		return nil, err
+	}
+
+	return steps, nil
+}
+
+// replaceSteps deletes a flow's steps and inserts the given ones, numbering
+// them in slice order
+func (s *sqlStore) replaceSteps(tx *Tx, flowID string, steps []*types.FlowStep) error {
+	if _, err := tx.Exec(`DELETE FROM flow_steps WHERE flow_id = ?`, flowID); err != nil {
+		return fmt.Errorf("failed to clear flow steps: %w", err)
+	}
+
+	for i, step := range steps {
+		step.FlowID = flowID
+		step.Position = i + 1
+		if err := s.insertStep(tx, step); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertStep writes a step row within a transaction
+func (s *sqlStore) insertStep(tx *Tx, step *types.FlowStep) error {
+	config, err := marshalStepConfig(step.Config)
+	if err != nil {
+		return err
+	}
+
+	step.CreatedAt = time.Now()
+	step.UpdatedAt = step.CreatedAt
+
+	query := `INSERT INTO flow_steps (` + stepColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(query,
+		step.FlowID,
+		step.ID,
+		step.Position,
+		step.Type,
+		config,
+		step.CreatedAt,
+		step.UpdatedAt,
+	)
+	if err != nil {
+		s.log.Error("Failed to create flow step", err, types.Fields{
+			"function": "insertStep",
+			"flow_id":  step.FlowID,
+			"step_id":  step.ID,
+		})
+		return fmt.Errorf("failed to create flow step: %w", err)
+	}
+	return nil
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanStep reads a step from a row selected with stepColumns
+func scanStep(row rowScanner) (*types.FlowStep, error) {
+	step := &types.FlowStep{}
+	var config sql.NullString
+	err := row.Scan(
+		&step.FlowID,
+		&step.ID,
+		&step.Position,
+		&step.Type,
+		&config,
+		&step.CreatedAt,
+		&step.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Valid && config.String != "" {
+		if err := json.Unmarshal([]byte(config.String), &step.Config); err != nil {
+			return nil, fmt.Errorf("failed to decode step config: %w", err)
+		}
+	}
+	return step, nil
+}
+
+// marshalStepConfig encodes step settings for storage
+func marshalStepConfig(config map[string]interface{}) (string, error) {
+	if len(config) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode step config: %w", err)
+	}
+	return string(data), nil
+}
+
+// DeriveFlowSteps parses a flow config written in the Flow language and
+// returns one step per node definition, in declaration order
+func DeriveFlowSteps(flowID, config string, log types.Logger) ([]*types.FlowStep, error) {
+	p := parser.New(lexer.New(config), log)
+	program := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidFlowConfig, strings.Join(errs, "; "))
+	}
+
+	steps := []*types.FlowStep{}
+	var collect func(statements []ast.Statement)
+	collect = func(statements []ast.Statement) {
+		for _, stmt := range statements {
+			switch n := stmt.(type) {
+			case *ast.Flow:
+				if n != nil && n.Body != nil {
+					collect(n.Body.Statements)
+				}
+			case *ast.FlowNode:
+				if n == nil || n.Name == nil {
+					continue
+				}
+				settings := blockToMap(n.Body)
+				step := &types.FlowStep{
+					FlowID:   flowID,
+					ID:       n.Name.Value,
+					Position: len(steps) + 1,
+				}
+				if t, ok := settings["type"].(string); ok {
+					step.Type = t
+					delete(settings, "type")
+				}
+				if len(settings) > 0 {
+					step.Config = settings
+				}
+				steps = append(steps, step)
+			}
+		}
+	}
+	collect(program.Statements)
+
+	return steps, nil
+}
+
+// blockToMap converts the assignments and config blocks of a block into a
+// settings map
+func blockToMap(block *ast.BlockStatement) map[string]interface{} {
+	settings := make(map[string]interface{})
+	if block == nil {
+		return settings
+	}
+
+	for _, stmt := range block.Statements {
+		switch n := stmt.(type) {
+		case *ast.Assignment:
+			if n != nil && n.Name != nil {
+				settings[n.Name.Value] = ast.Value(n.Value)
+			}
+		case *ast.Config:
+			if n != nil {
+				settings["config"] = blockToMap(n.Body)
+			}
+		}
+	}
+	return settings
+}
@@ -1,65 +1,267 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"flow-control/internal/types"
+)
+
+// Store defines the persistence operations required by Flow Control.
+// It is implemented by SQLiteStore and PostgresStore.
+type Store interface {
+	// Flow operations
+	CreateFlow(flow *types.RuntimeFlow) error
+	CreateFlowContext(ctx context.Context, flow *types.RuntimeFlow) error
+	GetFlow(id string) (*types.RuntimeFlow, error)
+	ListFlows(filters ...FlowFilter) ([]*types.RuntimeFlow, error)
+	UpdateFlow(flow *types.RuntimeFlow) error
+	UpdateFlowContext(ctx context.Context, flow *types.RuntimeFlow) error
+	DeleteFlow(id string) error
+	UpdateFlowStatus(id, status string) error
+	StartFlow(id string, params map[string]string) error
+	SearchFlows(query string, limit int) ([]FlowSearchResult, error)
+
+	// Tag operations
+	GetFlowTags(id string) ([]string, error)
+	SetFlowTags(id string, tags []string) error
+	AddFlowTags(id string, tags ...string) error
+	RemoveFlowTag(id, tag string) error
+
+	// Step operations
+	ListFlowSteps(flowID string) ([]*types.FlowStep, error)
+	GetFlowStep(flowID, stepID string) (*types.FlowStep, error)
+	CreateFlowStep(step *types.FlowStep) error
+	UpdateFlowStep(step *types.FlowStep) error
+	DeleteFlowStep(flowID, stepID string) error
+	ReorderFlowSteps(flowID string, stepIDs []string) error
+	SetFlowSteps(flowID string, steps []*types.FlowStep) error
+	SyncFlowSteps(flowID string) ([]*types.FlowStep, error)
+
+	// Version and audit operations
+	ListFlowVersions(flowID string) ([]*types.FlowVersion, error)
+	ListAuditRecords(entityType, entityID string) ([]*types.AuditRecord, error)
+	RecordAudit(entityType, entityID, action string, details map[string]interface{}) error
+
+	// Schema operations
+	SaveSchema(record *types.SchemaRecord) error
+	ListSchemas() ([]*types.SchemaRecord, error)
+
+	// Alert rule operations
+	SaveAlertRule(rule *types.AlertRule) error
+	ListAlertRules() ([]*types.AlertRule, error)
+	DeleteAlertRule(id string) error
+
+	// Lease operations
+	AcquireLease(name, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(name, holder string) error
+	GetLease(name string) (*types.Lease, error)
+	ListLeases(prefix string) ([]*types.Lease, error)
+
+	// Node log operations
+	SaveNodeLogs(logs []types.NodeLog) error
+	ListNodeLogs(filter NodeLogFilter) ([]*types.NodeLog, error)
+
+	// Message hop operations
+	SaveMessageHops(hops []types.MessageHop) error
+	ListMessageHops(filter MessageHopFilter) ([]*types.MessageHop, error)
+
+	// Usage operations
+	RunDataSize(ids []string) (int64, error)
+
+	// Transactions
+	WithTx(ctx context.Context, fn func(tx *Tx) error) error
+
+	// Lifecycle
+	Close() error
+}
+
+var (
+	// ErrFlowNotFound is returned when a flow does not exist
+	ErrFlowNotFound = errors.New("flow not found")
+	// ErrTagNotFound is returned when a flow does not carry a tag
+	ErrTagNotFound = errors.New("tag not found")
+	// ErrStepNotFound is returned when a flow step does not exist
+	ErrStepNotFound = errors.New("step not found")
+	// ErrInvalidStepOrder is returned when a reorder does not list every step once
+	ErrInvalidStepOrder = errors.New("invalid step order")
+	// ErrInvalidFlowConfig is returned when a flow config cannot be parsed
+	ErrInvalidFlowConfig = errors.New("invalid flow config")
+	// ErrConflict is returned when a write is based on a stale revision
+	ErrConflict = errors.New("flow revision conflict")
+	// ErrSchemaExists is returned when a schema type and version is already stored
+	ErrSchemaExists = errors.New("schema already exists")
+	// ErrAlertRuleNotFound is returned when an alert rule does not exist
+	ErrAlertRuleNotFound = errors.New("alert rule not found")
+	// ErrLeaseNotFound is returned when nobody holds a lease
+	ErrLeaseNotFound = errors.New("lease not found")
+)
+
+// ConflictError is returned when an update carries a revision other than the
+// flow's current one. It matches ErrConflict with errors.Is.
+type ConflictError struct {
+	// FlowID identifies the flow that was being updated
+	FlowID string
+	// Expected is the revision the update was based on
+	Expected int
+	// Current is the flow's revision in the store
+	Current int
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s: flow %s is at revision %d, update expected %d", ErrConflict, e.FlowID, e.Current, e.Expected)
+}
+
+// Is reports whether target is ErrConflict
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// Dialect identifies the SQL dialect spoken by a storage backend
+type Dialect string
 
-	// Import sqlite3 driver for database connectivity
-	_ "github.com/mattn/go-sqlite3"
+const (
+	// DialectSQLite is the dialect of the embedded SQLite backend
+	DialectSQLite Dialect = "sqlite"
+	// DialectPostgres is the dialect of the PostgreSQL backend
+	DialectPostgres Dialect = "postgres"
 )
 
-// Store represents a SQLite-based flow store
-type Store struct {
-	db  *sql.DB
-	log types.Logger
+// driverName returns the database/sql driver registered for the dialect
+func (d Dialect) driverName() string {
+	switch d {
+	case DialectPostgres:
+		return "pgx"
+	default:
+		return "sqlite3"
+	}
+}
+
+// Options holds connection tuning for a store
+type Options struct {
+	// MaxOpenConns is the maximum number of open database connections
+	MaxOpenConns int
+	// MaxIdleConns is the maximum number of idle connections kept in the pool
+	MaxIdleConns int
+	// ConnMaxLifetime is the maximum time a connection may be reused
+	ConnMaxLifetime time.Duration
+	// BusyTimeout is how long SQLite waits on a locked database before failing
+	BusyTimeout time.Duration
+	// JournalMode is the SQLite journal mode, e.g. WAL or DELETE
+	JournalMode string
+	// ForeignKeys enables foreign key enforcement in SQLite
+	ForeignKeys bool
+	// SlowQueryThreshold is the duration above which statements are logged;
+	// zero disables slow query logging
+	SlowQueryThreshold time.Duration
+	// Metrics receives query and transaction duration histograms when set
+	Metrics types.MetricsPort
 }
 
-// New creates a new Store instance
-func New(dbPath string, log types.Logger) (*Store, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+// DefaultOptions returns the default store options
+func DefaultOptions() Options {
+	return Options{
+		MaxOpenConns:       10,                     // Allow concurrent readers
+		MaxIdleConns:       5,                      // Keep warm connections around
+		ConnMaxLifetime:    time.Hour,              // Recycle connections hourly
+		BusyTimeout:        5 * time.Second,        // Wait out short write locks
+		JournalMode:        "WAL",                  // Readers don't block writers
+		ForeignKeys:        true,                   // Enforce referential integrity
+		SlowQueryThreshold: 200 * time.Millisecond, // Flag statements worth a look
+	}
+}
+
+// Open creates a store for the given driver. For the sqlite driver the data
+// source is a file path; for the postgres driver it is a connection string.
+func Open(driver, dataSource string, log types.Logger, opts ...Options) (Store, error) {
+	switch Dialect(strings.ToLower(driver)) {
+	case "", DialectSQLite:
+		return New(dataSource, log, opts...)
+	case DialectPostgres:
+		return NewPostgres(dataSource, log, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported database driver: %s", driver)
+	}
+}
+
+// sqlStore implements the Store operations shared by all database/sql backends
+type sqlStore struct {
+	db        *sql.DB
+	log       types.Logger
+	dialect   Dialect
+	metrics   types.MetricsPort
+	slowQuery time.Duration
+}
+
+// openSQLStore opens and verifies a database connection and applies migrations
+func openSQLStore(dialect Dialect, dataSource string, log types.Logger, opts Options) (*sqlStore, error) {
+	db, err := sql.Open(dialect.driverName(), dataSource)
 	if err != nil {
-		if closeErr := db.Close(); closeErr != nil {
-			log.Error("Failed to close database after open error", closeErr, types.Fields{
-				"function": "New",
-				"path":     dbPath,
-			})
-		}
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	db.SetMaxIdleConns(opts.MaxIdleConns)
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+
 	if err := db.Ping(); err != nil {
 		if closeErr := db.Close(); closeErr != nil {
 			log.Error("Failed to close database after ping error", closeErr, types.Fields{
-				"function": "New",
-				"path":     dbPath,
+				"function": "openSQLStore",
+				"dialect":  dialect,
 			})
 		}
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	store := &Store{
-		db:  db,
-		log: log,
+	store := &sqlStore{
+		db:        db,
+		log:       log,
+		dialect:   dialect,
+		metrics:   opts.Metrics,
+		slowQuery: opts.SlowQueryThreshold,
 	}
 
-	if err := store.createTables(); err != nil {
+	if err := store.migrate(); err != nil {
 		if closeErr := db.Close(); closeErr != nil {
-			log.Error("Failed to close database after table creation error", closeErr, types.Fields{
-				"function": "New",
-				"path":     dbPath,
+			log.Error("Failed to close database after migration error", closeErr, types.Fields{
+				"function": "openSQLStore",
+				"dialect":  dialect,
 			})
 		}
-		return nil, fmt.Errorf("failed to create tables: %w", err)
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
 	return store, nil
 }
 
+// rebind rewrites ? placeholders into the form expected by the dialect
+func (s *sqlStore) rebind(query string) string {
+	if s.dialect != DialectPostgres {
+		return query
+	}
+
+	var out strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			out.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
 // Close closes the database connection
-func (s *Store) Close() error {
+func (s *sqlStore) Close() error {
 	if err := s.db.Close(); err != nil {
 		s.log.Error("Failed to close database", err, types.Fields{
 			"function": "Close",
@@ -69,31 +271,51 @@ func (s *Store) Close() error {
 	return nil
 }
 
+// PoolStats returns statistics of the database connection pool
+func (s *sqlStore) PoolStats() sql.DBStats {
+	return s.db.Stats()
+}
+
 // CreateFlow creates a new flow in the store
-func (s *Store) CreateFlow(flow *types.RuntimeFlow) error {
+func (s *sqlStore) CreateFlow(flow *types.RuntimeFlow) error {
+	return s.CreateFlowContext(context.Background(), flow)
+}
+
+// CreateFlowContext creates a new flow together with the steps derived from
+// its config, its first version entry and an audit record, all in a single
+// transaction bound to ctx
+func (s *sqlStore) CreateFlowContext(ctx context.Context, flow *types.RuntimeFlow) error {
 	flow.CreatedAt = time.Now()
 	flow.UpdatedAt = flow.CreatedAt
+	flow.Revision = 1
+	steps := s.deriveSteps(flow)
 
 	query := `
-		INSERT INTO flows (id, name, description, version, config, status, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO flows (id, name, description, version, config, status, created_at, updated_at, revision)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := s.db.Exec(query,
-		flow.ID,
-		flow.Name,
-		flow.Description,
-		flow.Version,
-		flow.Config,
-		flow.Status,
-		flow.CreatedAt,
-
-		flow.UpdatedAt,
-	)
+	err := s.WithTx(ctx, func(tx *Tx) error {
+		_, err := tx.Exec(query,
+			flow.ID,
+			flow.Name,
+			flow.Description,
+			flow.Version,
+			flow.Config,
+			flow.Status,
+			flow.CreatedAt,
+			flow.UpdatedAt,
+			flow.Revision,
+		)
+		if err != nil {
+			return err
+		}
+		return s.saveFlowRecords(tx, flow, steps, AuditActionCreate)
+	})
 
 	if err != nil {
 		s.log.Error("Failed to create flow", err, types.Fields{
-			"function": "CreateFlow",
+			"function": "CreateFlowContext",
 			"flow_id":  flow.ID,
 		})
 		return fmt.Errorf("failed to create flow: %w", err)
@@ -103,15 +325,16 @@ func (s *Store) CreateFlow(flow *types.RuntimeFlow) error {
 }
 
 // GetFlow retrieves a flow by ID
-func (s *Store) GetFlow(id string) (*types.RuntimeFlow, error) {
+func (s *sqlStore) GetFlow(id string) (*types.RuntimeFlow, error) {
 	query := `
-		SELECT id, name, description, version, config, status, created_at, updated_at
+		SELECT id, name, description, version, config, status, created_at, updated_at, revision, params
 		FROM flows
 		WHERE id = ?
 	`
 
 	flow := &types.RuntimeFlow{}
-	err := s.db.QueryRow(query, id).Scan(
+	var params sql.NullString
+	err := s.queryRow(query, id).Scan(
 		&flow.ID,
 		&flow.Name,
 		&flow.Description,
@@ -120,11 +343,13 @@ func (s *Store) GetFlow(id string) (*types.RuntimeFlow, error) {
 		&flow.Status,
 		&flow.CreatedAt,
 		&flow.UpdatedAt,
+		&flow.Revision,
+		&params,
 	)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("flow not found: %s", id)
+			return nil, fmt.Errorf("%w: %s", ErrFlowNotFound, id)
 		}
 		s.log.Error("Failed to get flow", err, types.Fields{
 			"function": "GetFlow",
@@ -132,19 +357,33 @@ func (s *Store) GetFlow(id string) (*types.RuntimeFlow, error) {
 		})
 		return nil, fmt.Errorf("failed to get flow: %w", err)
 	}
+	if err := decodeParams(flow, params); err != nil {
+		return nil, err
+	}
+
+	if err := s.loadTags([]*types.RuntimeFlow{flow}); err != nil {
+		return nil, err
+	}
 
 	return flow, nil
 }
 
-// ListFlows returns all flows in the store
-func (s *Store) ListFlows() ([]*types.RuntimeFlow, error) {
+// ListFlows returns all flows in the store, optionally narrowed by a filter
+func (s *sqlStore) ListFlows(filters ...FlowFilter) ([]*types.RuntimeFlow, error) {
+	var filter FlowFilter
+	if len(filters) > 0 {
+		filter = filters[0]
+	}
+
+	where, args := filter.where()
 	query := `
-		SELECT id, name, description, version, config, status, created_at, updated_at
+		SELECT id, name, description, version, config, status, created_at, updated_at, revision, params
 		FROM flows
+	` + where + `
 		ORDER BY created_at DESC
 	`
 
-	rows, err := s.db.Query(query)
+	rows, err := s.query(query, args...)
 	if err != nil {
 		s.log.Error("Failed to list flows", err, types.Fields{
 			"function": "ListFlows",
@@ -162,6 +401,7 @@ func (s *Store) ListFlows() ([]*types.RuntimeFlow, error) {
 	var flows []*types.RuntimeFlow
 	for rows.Next() {
 		flow := &types.RuntimeFlow{}
+		var params sql.NullString
 		err := rows.Scan(
 			&flow.ID,
 			&flow.Name,
@@ -171,6 +411,8 @@ func (s *Store) ListFlows() ([]*types.RuntimeFlow, error) {
 			&flow.Status,
 			&flow.CreatedAt,
 			&flow.UpdatedAt,
+			&flow.Revision,
+			&params,
 		)
 		if err != nil {
 			s.log.Error("Failed to scan flow", err, types.Fields{
@@ -178,6 +420,9 @@ func (s *Store) ListFlows() ([]*types.RuntimeFlow, error) {
 			})
 			return nil, fmt.Errorf("failed to scan flow: %w", err)
 		}
+		if err := decodeParams(flow, params); err != nil {
+			return nil, err
+		}
 		flows = append(flows, flow)
 	}
 
@@ -188,59 +433,122 @@ func (s *Store) ListFlows() ([]*types.RuntimeFlow, error) {
 		return nil, fmt.Errorf("error iterating flows: %w", err)
 	}
 
+	if err := s.loadTags(flows); err != nil {
+		return nil, err
+	}
+
 	return flows, nil
 }
 
-// UpdateFlow updates an existing flow
-func (s *Store) UpdateFlow(flow *types.RuntimeFlow) error {
-	flow.UpdatedAt = time.Now()
+// UpdateFlow updates an existing flow. See UpdateFlowContext.
+func (s *sqlStore) UpdateFlow(flow *types.RuntimeFlow) error {
+	return s.UpdateFlowContext(context.Background(), flow)
+}
+
+// UpdateFlowContext updates an existing flow, replaces its steps with those
+// derived from its config and records a new version entry and an audit
+// record, all in a single transaction bound to ctx.
+//
+// flow.Revision must hold the flow's current revision; otherwise a
+// *ConflictError is returned and nothing is written. On success the revision
+// is incremented.
+func (s *sqlStore) UpdateFlowContext(ctx context.Context, flow *types.RuntimeFlow) error {
+	updatedAt := time.Now()
+	revision := flow.Revision + 1
+	steps := s.deriveSteps(flow)
 
 	query := `
 		UPDATE flows
-		SET name = ?, description = ?, version = ?, config = ?, status = ?, updated_at = ?
-		WHERE id = ?
+		SET name = ?, description = ?, version = ?, config = ?, status = ?, updated_at = ?, revision = ?
+		WHERE id = ? AND revision = ?
 	`
 
-	result, err := s.db.Exec(query,
-		flow.Name,
-		flow.Description,
-		flow.Version,
-		flow.Config,
-		flow.Status,
-		flow.UpdatedAt,
-		flow.ID,
-	)
+	err := s.WithTx(ctx, func(tx *Tx) error {
+		result, err := tx.Exec(query,
+			flow.Name,
+			flow.Description,
+			flow.Version,
+			flow.Config,
+			flow.Status,
+			updatedAt,
+			revision,
+			flow.ID,
+			flow.Revision,
+		)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+
+		if rowsAffected == 0 {
+			return s.revisionMismatch(tx, flow.ID, flow.Revision)
+		}
+
+		saved := *flow
+		saved.UpdatedAt = updatedAt
+		saved.Revision = revision
+		return s.saveFlowRecords(tx, &saved, steps, AuditActionUpdate)
+	})
 
 	if err != nil {
+		if errors.Is(err, ErrFlowNotFound) || errors.Is(err, ErrConflict) {
+			return err
+		}
 		s.log.Error("Failed to update flow", err, types.Fields{
-			"function": "UpdateFlow",
+			"function": "UpdateFlowContext",
 			"flow_id":  flow.ID,
 		})
 		return fmt.Errorf("failed to update flow: %w", err)
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	flow.UpdatedAt = updatedAt
+	flow.Revision = revision
+	return nil
+}
+
+// revisionMismatch explains why an update guarded by a revision matched no
+// rows: either the flow does not exist or its revision has moved on
+func (s *sqlStore) revisionMismatch(tx *Tx, id string, expected int) error {
+	var current int
+	err := tx.QueryRow(`SELECT revision FROM flows WHERE id = ?`, id).Scan(&current)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", ErrFlowNotFound, id)
+	}
 	if err != nil {
-		s.log.Error("Failed to get rows affected", err, types.Fields{
-			"function": "UpdateFlow",
-			"flow_id":  flow.ID,
-		})
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		return fmt.Errorf("failed to check flow revision: %w", err)
 	}
+	return &ConflictError{FlowID: id, Expected: expected, Current: current}
+}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("flow not found: %s", flow.ID)
-	}
+// DeleteFlow deletes a flow by ID. Its steps, tags, versions and alert rules
+// are removed with it; the audit trail is kept.
+func (s *sqlStore) DeleteFlow(id string) error {
+	err := s.WithTx(context.Background(), func(tx *Tx) error {
+		result, err := tx.Exec(`DELETE FROM flows WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
 
-	return nil
-}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+
+		if rowsAffected == 0 {
+			return fmt.Errorf("%w: %s", ErrFlowNotFound, id)
+		}
 
-// DeleteFlow deletes a flow by ID
-func (s *Store) DeleteFlow(id string) error {
-	query := `DELETE FROM flows WHERE id = ?`
+		return s.insertAuditRecord(tx, AuditEntityFlow, id, AuditActionDelete, nil)
+	})
 
-	result, err := s.db.Exec(query, id)
 	if err != nil {
+		if errors.Is(err, ErrFlowNotFound) {
+			return err
+		}
 		s.log.Error("Failed to delete flow", err, types.Fields{
 			"function": "DeleteFlow",
 			"flow_id":  id,
@@ -248,31 +556,18 @@ func (s *Store) DeleteFlow(id string) error {
 		return fmt.Errorf("failed to delete flow: %w", err)
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		s.log.Error("Failed to get rows affected", err, types.Fields{
-			"function": "DeleteFlow",
-			"flow_id":  id,
-		})
-		return fmt.Errorf("failed to get rows affected: %w", err)
-	}
-
-	if rowsAffected == 0 {
-		return fmt.Errorf("flow not found: %s", id)
-	}
-
 	return nil
 }
 
 // UpdateFlowStatus updates the status of a flow
-func (s *Store) UpdateFlowStatus(id, status string) error {
+func (s *sqlStore) UpdateFlowStatus(id, status string) error {
 	query := `
 		UPDATE flows
-		SET status = ?, updated_at = ?
+		SET status = ?, updated_at = ?, revision = revision + 1
 		WHERE id = ?
 	`
 
-	result, err := s.db.Exec(query, status, time.Now(), id)
+	result, err := s.exec(query, status, time.Now(), id)
 	if err != nil {
 		s.log.Error("Failed to update flow status", err, types.Fields{
 			"function": "UpdateFlowStatus",
@@ -292,33 +587,62 @@ func (s *Store) UpdateFlowStatus(id, status string) error {
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("flow not found: %s", id)
+		return fmt.Errorf("%w: %s", ErrFlowNotFound, id)
 	}
 
 	return nil
 }
 
-func (s *Store) createTables() error {
+// StartFlow marks a flow as running with the values of its parameters,
+// replacing the values it was started with before
+func (s *sqlStore) StartFlow(id string, params map[string]string) error {
+	var encoded sql.NullString
+	if len(params) > 0 {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("failed to encode flow params: %w", err)
+		}
+		encoded = sql.NullString{String: string(data), Valid: true}
+	}
+
 	query := `
-		CREATE TABLE IF NOT EXISTS flows (
-			id TEXT PRIMARY KEY,
-			name TEXT NOT NULL,
-			description TEXT,
-			version TEXT,
-			config TEXT NOT NULL,
-			status TEXT NOT NULL,
-			created_at DATETIME NOT NULL,
-			updated_at DATETIME NOT NULL
-		)
+		UPDATE flows
+		SET status = ?, params = ?, updated_at = ?, revision = revision + 1
+		WHERE id = ?
 	`
 
-	_, err := s.db.Exec(query)
+	result, err := s.exec(query, types.FlowStatusRunning, encoded, time.Now(), id)
+	if err != nil {
+		s.log.Error("Failed to start flow", err, types.Fields{
+			"function": "StartFlow",
+			"flow_id":  id,
+		})
+		return fmt.Errorf("failed to start flow: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		s.log.Error("Failed to create tables", err, types.Fields{
-			"function": "createTables",
+		s.log.Error("Failed to get rows affected", err, types.Fields{
+			"function": "StartFlow",
+			"flow_id":  id,
 		})
-		return fmt.Errorf("failed to create tables: %w", err)
+		return fmt.Errorf("failed to get rows affected: %w", err)
 	}
 
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: %s", ErrFlowNotFound, id)
+	}
+
+	return nil
+}
+
+// decodeParams sets the params of flow from their stored JSON encoding
+func decodeParams(flow *types.RuntimeFlow, params sql.NullString) error {
+	if !params.Valid || params.String == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(params.String), &flow.Params); err != nil {
+		return fmt.Errorf("failed to decode params of flow %s: %w", flow.ID, err)
+	}
 	return nil
 }
@@ -1,10 +1,24 @@
 package store_test
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"flow-control/internal/compression"
 	"flow-control/internal/logger"
+	"flow-control/internal/metrics"
+	"flow-control/internal/runtime/schema"
 	"flow-control/internal/store"
 	"flow-control/internal/types"
 
@@ -103,9 +117,1262 @@ func TestStore(t *testing.T) {
 		got, err := db.GetFlow(flow.ID)
 		require.NoError(t, err)
 		require.Equal(t, "running", got.Status)
+		require.Nil(t, got.Params)
+
+		// Starting records the parameter values, replacing earlier ones
+		require.NoError(t, db.StartFlow(flow.ID, map[string]string{"region": "eu"}))
+		got, err = db.GetFlow(flow.ID)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"region": "eu"}, got.Params)
+		require.NoError(t, db.UpdateFlowStatus(flow.ID, "stopped"))
+		require.NoError(t, db.StartFlow(flow.ID, nil))
+		flows, err := db.ListFlows()
+		require.NoError(t, err)
+		require.Equal(t, "running", flows[0].Status)
+		require.Nil(t, flows[0].Params)
+		require.ErrorIs(t, db.StartFlow("missing", nil), store.ErrFlowNotFound)
 
 		// Clean up
 		err = db.DeleteFlow(flow.ID)
 		require.NoError(t, err)
 	})
+
+	// Test flow tags
+	t.Run("flow tags", func(t *testing.T) {
+		for _, id := range []string{"etl-prod", "etl-dev", "report"} {
+			err := db.CreateFlow(&types.RuntimeFlow{
+				ID:     id,
+				Name:   id,
+				Config: "flow test {}",
+				Status: "stopped",
+			})
+			require.NoError(t, err)
+		}
+		defer func() {
+			for _, id := range []string{"etl-prod", "etl-dev", "report"} {
+				if err := db.DeleteFlow(id); err != nil {
+					t.Errorf("Failed to delete flow: %v", err)
+				}
+			}
+		}()
+
+		require.NoError(t, db.SetFlowTags("etl-prod", []string{"etl", "prod", " prod "}))
+		require.NoError(t, db.AddFlowTags("etl-dev", "etl", "dev"))
+		require.NoError(t, db.AddFlowTags("report", "prod"))
+
+		tags, err := db.GetFlowTags("etl-prod")
+		require.NoError(t, err)
+		require.Equal(t, []string{"etl", "prod"}, tags)
+
+		// Every tag in the filter must match
+		flows, err := db.ListFlows(store.FlowFilter{Tags: []string{"prod", "etl"}})
+		require.NoError(t, err)
+		require.Len(t, flows, 1)
+		require.Equal(t, "etl-prod", flows[0].ID)
+		require.Equal(t, []string{"etl", "prod"}, flows[0].Tags)
+
+		flows, err = db.ListFlows(store.FlowFilter{Tags: []string{"etl"}})
+		require.NoError(t, err)
+		require.Len(t, flows, 2)
+
+		require.NoError(t, db.RemoveFlowTag("etl-dev", "dev"))
+		err = db.RemoveFlowTag("etl-dev", "dev")
+		require.ErrorIs(t, err, store.ErrTagNotFound)
+
+		err = db.AddFlowTags("missing", "prod")
+		require.ErrorIs(t, err, store.ErrFlowNotFound)
+
+		got, err := db.GetFlow("report")
+		require.NoError(t, err)
+		require.Equal(t, []string{"prod"}, got.Tags)
+	})
+
+	// Test flow search
+	t.Run("flow search", func(t *testing.T) {
+		flows := []*types.RuntimeFlow{
+			{ID: "orders", Name: "Order Pipeline", Description: "Ingests orders", Config: `flow "orders" { node "kafka" { type: "KafkaSource" } }`, Status: "stopped"},
+			{ID: "billing", Name: "Billing", Description: "Monthly invoices", Config: `flow "billing" { node "pdf" { type: "Render" } }`, Status: "stopped"},
+		}
+		for _, flow := range flows {
+			require.NoError(t, db.CreateFlow(flow))
+		}
+		defer func() {
+			for _, flow := range flows {
+				if err := db.DeleteFlow(flow.ID); err != nil {
+					t.Errorf("Failed to delete flow: %v", err)
+				}
+			}
+		}()
+
+		results, err := db.SearchFlows("KafkaSource", 10)
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		require.Equal(t, "orders", results[0].Flow.ID)
+		require.Contains(t, results[0].Snippet, "<mark>KafkaSource</mark>")
+
+		// Matches in names and descriptions are found too
+		results, err = db.SearchFlows("invoices", 10)
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		require.Equal(t, "billing", results[0].Flow.ID)
+
+		// Updates are reflected in search results
+		flows[1].Config = `flow "billing" { node "kafka" { type: "KafkaSource" } }`
+		require.NoError(t, db.UpdateFlow(flows[1]))
+		results, err = db.SearchFlows("kafkasource", 10)
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+
+		// Query syntax characters are treated as plain text
+		results, err = db.SearchFlows(`"order*-(`, 10)
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+
+		// Underscores in terms are not LIKE wildcards standing for the S of
+		// KafkaSource
+		results, err = db.SearchFlows("kafka_ource", 10)
+		require.NoError(t, err)
+		require.Empty(t, results)
+
+		results, err = db.SearchFlows("  ", 10)
+		require.NoError(t, err)
+		require.Empty(t, results)
+
+		// Snippets escape the config they quote as HTML
+		hostile := &types.RuntimeFlow{ID: "hostile", Name: "Hostile", Config: `flow "hostile" { node "<img src=x onerror=alert(1)>" { type: "Widget" } }`, Status: "stopped"}
+		require.NoError(t, db.CreateFlow(hostile))
+		defer func() { require.NoError(t, db.DeleteFlow(hostile.ID)) }()
+		results, err = db.SearchFlows("widget", 10)
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		require.Contains(t, results[0].Snippet, "<mark>Widget</mark>")
+		require.Contains(t, results[0].Snippet, "&lt;img src=x onerror=alert(1)&gt;")
+		require.NotContains(t, results[0].Snippet, "<img")
+	})
+
+	// Test flow steps
+	t.Run("flow steps", func(t *testing.T) {
+		flow := &types.RuntimeFlow{
+			ID:   "steps-flow",
+			Name: "Steps Flow",
+			Config: `flow "steps" {
+				node "reader" {
+					type: "FileReader"
+					path: "/tmp/in.txt"
+				}
+				node "writer" {
+					type: "FileWriter"
+					config {
+						retries: 3
+					}
+				}
+			}`,
+			Status: "stopped",
+		}
+		require.NoError(t, db.CreateFlow(flow))
+
+		// Steps are derived from node definitions
+		steps, err := db.SyncFlowSteps(flow.ID)
+		require.NoError(t, err)
+		require.Len(t, steps, 2)
+		require.Equal(t, "reader", steps[0].ID)
+		require.Equal(t, "FileReader", steps[0].Type)
+		require.Equal(t, "/tmp/in.txt", steps[0].Config["path"])
+
+		step, err := db.GetFlowStep(flow.ID, "writer")
+		require.NoError(t, err)
+		require.Equal(t, 2, step.Position)
+		require.Equal(t, map[string]interface{}{"retries": float64(3)}, step.Config["config"])
+
+		// Inserting at a position shifts later steps
+		require.NoError(t, db.CreateFlowStep(&types.FlowStep{
+			FlowID:   flow.ID,
+			ID:       "transform",
+			Position: 2,
+			Type:     "Transform",
+		}))
+		steps, err = db.ListFlowSteps(flow.ID)
+		require.NoError(t, err)
+		require.Equal(t, []string{"reader", "transform", "writer"}, stepIDs(steps))
+
+		// Update keeps the position
+		step.Type = "S3Writer"
+		require.NoError(t, db.UpdateFlowStep(step))
+		step, err = db.GetFlowStep(flow.ID, "writer")
+		require.NoError(t, err)
+		require.Equal(t, "S3Writer", step.Type)
+		require.Equal(t, 3, step.Position)
+
+		// Reorder requires every step exactly once
+		require.ErrorIs(t, db.ReorderFlowSteps(flow.ID, []string{"writer", "reader"}), store.ErrInvalidStepOrder)
+		require.ErrorIs(t, db.ReorderFlowSteps(flow.ID, []string{"writer", "writer", "reader"}), store.ErrInvalidStepOrder)
+		require.NoError(t, db.ReorderFlowSteps(flow.ID, []string{"writer", "reader", "transform"}))
+		steps, err = db.ListFlowSteps(flow.ID)
+		require.NoError(t, err)
+		require.Equal(t, []string{"writer", "reader", "transform"}, stepIDs(steps))
+
+		// Deleting closes the gap
+		require.NoError(t, db.DeleteFlowStep(flow.ID, "reader"))
+		steps, err = db.ListFlowSteps(flow.ID)
+		require.NoError(t, err)
+		require.Equal(t, []string{"writer", "transform"}, stepIDs(steps))
+		require.Equal(t, 2, steps[1].Position)
+		require.ErrorIs(t, db.DeleteFlowStep(flow.ID, "reader"), store.ErrStepNotFound)
+
+		// Deleting the flow cascades to its steps
+		require.NoError(t, db.DeleteFlow(flow.ID))
+		_, err = db.GetFlowStep(flow.ID, "writer")
+		require.ErrorIs(t, err, store.ErrStepNotFound)
+	})
+
+	// Test atomic flow saves
+	t.Run("flow saves", func(t *testing.T) {
+		flow := &types.RuntimeFlow{
+			ID:   "save-flow",
+			Name: "Save Flow",
+			Config: `flow "save" {
+				node "reader" {
+					type: "FileReader"
+				}
+			}`,
+			Status: "stopped",
+		}
+		require.NoError(t, db.CreateFlowContext(context.Background(), flow))
+
+		// Creating a flow stores its steps, a version and an audit record
+		steps, err := db.ListFlowSteps(flow.ID)
+		require.NoError(t, err)
+		require.Equal(t, []string{"reader"}, stepIDs(steps))
+
+		flow.Config = `flow "save" {
+			node "reader" {
+				type: "FileReader"
+			}
+			node "writer" {
+				type: "FileWriter"
+			}
+		}`
+		require.NoError(t, db.UpdateFlowContext(context.Background(), flow))
+
+		steps, err = db.ListFlowSteps(flow.ID)
+		require.NoError(t, err)
+		require.Equal(t, []string{"reader", "writer"}, stepIDs(steps))
+
+		versions, err := db.ListFlowVersions(flow.ID)
+		require.NoError(t, err)
+		require.Len(t, versions, 2)
+		require.Equal(t, 2, versions[0].Version)
+		require.Equal(t, flow.Config, versions[0].Config)
+		require.Equal(t, "Save Flow", versions[0].Metadata["name"])
+
+		records, err := db.ListAuditRecords(store.AuditEntityFlow, flow.ID)
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		require.Equal(t, store.AuditActionCreate, records[0].Action)
+		require.Equal(t, store.AuditActionUpdate, records[1].Action)
+		require.Equal(t, float64(2), records[1].Details["version"])
+
+		// A failed save leaves nothing behind
+		missing := &types.RuntimeFlow{ID: "missing-flow", Name: "Missing", Config: "{}", Status: "stopped"}
+		require.ErrorIs(t, db.UpdateFlowContext(context.Background(), missing), store.ErrFlowNotFound)
+		records, err = db.ListAuditRecords(store.AuditEntityFlow, missing.ID)
+		require.NoError(t, err)
+		require.Empty(t, records)
+
+		// Cancelled contexts abort the save
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		flow.Config = `flow "save" {}`
+		require.ErrorIs(t, db.UpdateFlowContext(ctx, flow), context.Canceled)
+		versions, err = db.ListFlowVersions(flow.ID)
+		require.NoError(t, err)
+		require.Len(t, versions, 2)
+
+		// Deleting the flow drops its versions but keeps the audit trail
+		require.NoError(t, db.DeleteFlow(flow.ID))
+		records, err = db.ListAuditRecords(store.AuditEntityFlow, flow.ID)
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+		require.Equal(t, store.AuditActionDelete, records[2].Action)
+	})
+
+	// Test optimistic locking
+	t.Run("flow revisions", func(t *testing.T) {
+		flow := &types.RuntimeFlow{ID: "rev-flow", Name: "Rev Flow", Config: "{}", Status: "stopped"}
+		require.NoError(t, db.CreateFlow(flow))
+		require.Equal(t, 1, flow.Revision)
+
+		// Two clients read the same revision
+		first, err := db.GetFlow(flow.ID)
+		require.NoError(t, err)
+		second, err := db.GetFlow(flow.ID)
+		require.NoError(t, err)
+
+		first.Name = "First Edit"
+		require.NoError(t, db.UpdateFlow(first))
+		require.Equal(t, 2, first.Revision)
+
+		// The second update is based on a stale revision
+		second.Name = "Second Edit"
+		err = db.UpdateFlow(second)
+		require.ErrorIs(t, err, store.ErrConflict)
+		var conflict *store.ConflictError
+		require.ErrorAs(t, err, &conflict)
+		require.Equal(t, 1, conflict.Expected)
+		require.Equal(t, 2, conflict.Current)
+		require.Equal(t, 1, second.Revision)
+
+		got, err := db.GetFlow(flow.ID)
+		require.NoError(t, err)
+		require.Equal(t, "First Edit", got.Name)
+		require.Equal(t, 2, got.Revision)
+
+		// Status changes are writes too
+		require.NoError(t, db.UpdateFlowStatus(flow.ID, "running"))
+		got, err = db.GetFlow(flow.ID)
+		require.NoError(t, err)
+		require.Equal(t, 3, got.Revision)
+		require.ErrorIs(t, db.UpdateFlow(first), store.ErrConflict)
+
+		// Missing flows are still reported as not found
+		missing := &types.RuntimeFlow{ID: "no-such-flow", Revision: 1}
+		require.ErrorIs(t, db.UpdateFlow(missing), store.ErrFlowNotFound)
+	})
+
+	// Test transactions
+	t.Run("transactions", func(t *testing.T) {
+		flow := &types.RuntimeFlow{ID: "tx-flow", Name: "Tx Flow", Config: "{}", Status: "stopped"}
+		require.NoError(t, db.CreateFlow(flow))
+
+		// Errors roll back every write made in the transaction
+		errAbort := errors.New("abort")
+		err := db.WithTx(context.Background(), func(tx *store.Tx) error {
+			if _, err := tx.Exec(`UPDATE flows SET name = ? WHERE id = ?`, "Renamed", flow.ID); err != nil {
+				return err
+			}
+			return errAbort
+		})
+		require.ErrorIs(t, err, errAbort)
+
+		got, err := db.GetFlow(flow.ID)
+		require.NoError(t, err)
+		require.Equal(t, "Tx Flow", got.Name)
+
+		// Successful transactions commit
+		err = db.WithTx(context.Background(), func(tx *store.Tx) error {
+			_, err := tx.Exec(`UPDATE flows SET name = ? WHERE id = ?`, "Renamed", flow.ID)
+			return err
+		})
+		require.NoError(t, err)
+
+		got, err = db.GetFlow(flow.ID)
+		require.NoError(t, err)
+		require.Equal(t, "Renamed", got.Name)
+	})
+
+	// Test schema persistence
+	t.Run("schemas", func(t *testing.T) {
+		registry, err := schema.NewPersistentRegistry(db)
+		require.NoError(t, err)
+
+		_, err = registry.Define("order", "1.0", schema.Definition{
+			Type: "object",
+			Properties: map[string]*schema.Definition{
+				"id":    {Type: "string"},
+				"total": {Type: "float"},
+			},
+			Required: []string{"id"},
+		})
+		require.NoError(t, err)
+
+		records, err := db.ListSchemas()
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		require.Equal(t, "order", records[0].Type)
+		require.Equal(t, "1.0", records[0].Version)
+
+		// Duplicates are rejected by the store as well as the registry
+		err = db.SaveSchema(&types.SchemaRecord{Type: "order", Version: "1.0", Definition: `{"type":"string"}`})
+		require.ErrorIs(t, err, store.ErrSchemaExists)
+
+		// Schemas survive reopening the database
+		reopened, err := store.New(dbPath, log)
+		require.NoError(t, err)
+		defer func() {
+			if err := reopened.Close(); err != nil {
+				t.Errorf("Failed to close store: %v", err)
+			}
+		}()
+
+		loaded, err := schema.NewPersistentRegistry(reopened)
+		require.NoError(t, err)
+		order, err := loaded.Get("order", "1.0")
+		require.NoError(t, err)
+		require.NoError(t, order.Validate(map[string]interface{}{"id": "o-1", "total": 9.5}))
+		require.Error(t, order.Validate(map[string]interface{}{"total": 9.5}))
+	})
+
+	// Test alert rule persistence
+	t.Run("alert rules", func(t *testing.T) {
+		flow := &types.RuntimeFlow{ID: "alert-flow", Name: "Alert Flow", Config: "{}"}
+		require.NoError(t, db.CreateFlow(flow))
+
+		rule := &types.AlertRule{
+			FlowID:        flow.ID,
+			Condition:     types.AlertErrorRate,
+			Threshold:     0.05,
+			WindowSeconds: 300,
+			Enabled:       true,
+		}
+		require.NoError(t, db.SaveAlertRule(rule))
+		require.NotEmpty(t, rule.ID)
+		created := rule.CreatedAt
+
+		// Saving again replaces the rule but keeps its creation time
+		rule.Threshold = 0.1
+		require.NoError(t, db.SaveAlertRule(rule))
+		rules, err := db.ListAlertRules()
+		require.NoError(t, err)
+		require.Len(t, rules, 1)
+		require.Equal(t, 0.1, rules[0].Threshold)
+		require.True(t, rules[0].CreatedAt.Equal(created))
+
+		// Rules require an existing flow
+		err = db.SaveAlertRule(&types.AlertRule{FlowID: "missing", Condition: types.AlertNoMessages})
+		require.ErrorIs(t, err, store.ErrFlowNotFound)
+
+		require.NoError(t, db.DeleteAlertRule(rule.ID))
+		require.ErrorIs(t, db.DeleteAlertRule(rule.ID), store.ErrAlertRuleNotFound)
+
+		// Deleting a flow removes its rules
+		require.NoError(t, db.SaveAlertRule(&types.AlertRule{FlowID: flow.ID, Condition: types.AlertNoMessages, WindowSeconds: 60}))
+		require.NoError(t, db.DeleteFlow(flow.ID))
+		rules, err = db.ListAlertRules()
+		require.NoError(t, err)
+		require.Empty(t, rules)
+	})
+
+	// Test leases
+	t.Run("leases", func(t *testing.T) {
+		_, err := db.GetLease("scheduler")
+		require.ErrorIs(t, err, store.ErrLeaseNotFound)
+
+		acquired, err := db.AcquireLease("scheduler", "node-a", time.Minute)
+		require.NoError(t, err)
+		require.True(t, acquired)
+		lease, err := db.GetLease("scheduler")
+		require.NoError(t, err)
+		require.Equal(t, "node-a", lease.Holder)
+		since := lease.AcquiredAt
+
+		// Expiry is computed by the database from its own clock
+		require.WithinDuration(t, since.Add(time.Minute), lease.ExpiresAt, 10*time.Millisecond)
+		require.WithinDuration(t, time.Now(), since, 5*time.Second)
+
+		// Other holders cannot take an unexpired lease
+		acquired, err = db.AcquireLease("scheduler", "node-b", time.Minute)
+		require.NoError(t, err)
+		require.False(t, acquired)
+
+		// Renewing extends the lease but keeps when it was acquired
+		acquired, err = db.AcquireLease("scheduler", "node-a", 2*time.Minute)
+		require.NoError(t, err)
+		require.True(t, acquired)
+		lease, err = db.GetLease("scheduler")
+		require.NoError(t, err)
+		require.True(t, lease.AcquiredAt.Equal(since))
+		require.True(t, lease.ExpiresAt.After(time.Now().Add(time.Minute)))
+
+		// Expired leases can be taken over
+		acquired, err = db.AcquireLease("janitor", "node-a", time.Millisecond)
+		require.NoError(t, err)
+		require.True(t, acquired)
+		time.Sleep(10 * time.Millisecond)
+		_, err = db.GetLease("janitor")
+		require.ErrorIs(t, err, store.ErrLeaseNotFound)
+		acquired, err = db.AcquireLease("janitor", "node-b", time.Minute)
+		require.NoError(t, err)
+		require.True(t, acquired)
+
+		// Leases are listed by prefix
+		acquired, err = db.AcquireLease("run:orders", "node-a", time.Minute)
+		require.NoError(t, err)
+		require.True(t, acquired)
+		leases, err := db.ListLeases("run:")
+		require.NoError(t, err)
+		require.Len(t, leases, 1)
+		require.Equal(t, "run:orders", leases[0].Name)
+		require.Equal(t, "node-a", leases[0].Holder)
+
+		// Only the holder can release a lease
+		require.NoError(t, db.ReleaseLease("scheduler", "node-b"))
+		_, err = db.GetLease("scheduler")
+		require.NoError(t, err)
+		require.NoError(t, db.ReleaseLease("scheduler", "node-a"))
+		acquired, err = db.AcquireLease("scheduler", "node-b", time.Minute)
+		require.NoError(t, err)
+		require.True(t, acquired)
+	})
+
+	// Test audit records of entities that are not stored
+	t.Run("record audit", func(t *testing.T) {
+		require.NoError(t, db.RecordAudit(store.AuditEntityApproval, "a1", store.AuditActionRequest, nil))
+		require.NoError(t, db.RecordAudit(store.AuditEntityApproval, "a1", store.AuditActionApprove, map[string]interface{}{"by": "alice"}))
+
+		records, err := db.ListAuditRecords(store.AuditEntityApproval, "a1")
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		require.Equal(t, store.AuditActionRequest, records[0].Action)
+		require.Nil(t, records[0].Details)
+		require.Equal(t, store.AuditActionApprove, records[1].Action)
+		require.Equal(t, "alice", records[1].Details["by"])
+	})
+
+	// Test node logs
+	t.Run("node logs", func(t *testing.T) {
+		now := time.Now().UTC().Truncate(time.Second)
+		require.NoError(t, db.SaveNodeLogs([]types.NodeLog{
+			{RunID: "r1", FlowID: "orders", NodeID: "price", Level: types.LogLevelInfo, Message: "priced", Fields: types.Fields{"total": 12.0}, Timestamp: now},
+			{RunID: "r1", FlowID: "orders", NodeID: "ship", Level: types.LogLevelError, Message: "no carrier", Error: "timeout", Timestamp: now},
+			{RunID: "r2", FlowID: "orders", NodeID: "price", Level: types.LogLevelDebug, Message: "other run", Timestamp: now},
+		}))
+
+		// Entries are listed per run in the order they were logged
+		logs, err := db.ListNodeLogs(store.NodeLogFilter{RunID: "r1"})
+		require.NoError(t, err)
+		require.Len(t, logs, 2)
+		require.Equal(t, "priced", logs[0].Message)
+		require.Equal(t, types.Fields{"total": 12.0}, logs[0].Fields)
+		require.True(t, logs[0].Timestamp.Equal(now))
+		require.Equal(t, "timeout", logs[1].Error)
+
+		logs, err = db.ListNodeLogs(store.NodeLogFilter{RunID: "r1", NodeID: "ship"})
+		require.NoError(t, err)
+		require.Len(t, logs, 1)
+		logs, err = db.ListNodeLogs(store.NodeLogFilter{RunID: "r1", Levels: []types.LogLevel{types.LogLevelWarn, types.LogLevelError}})
+		require.NoError(t, err)
+		require.Len(t, logs, 1)
+		require.Equal(t, "ship", logs[0].NodeID)
+		logs, err = db.ListNodeLogs(store.NodeLogFilter{RunID: "r1", Limit: 1})
+		require.NoError(t, err)
+		require.Len(t, logs, 1)
+		logs, err = db.ListNodeLogs(store.NodeLogFilter{RunID: "missing"})
+		require.NoError(t, err)
+		require.Empty(t, logs)
+	})
+
+	// Test message hops
+	t.Run("message hops", func(t *testing.T) {
+		now := time.Now().UTC().Truncate(time.Second)
+		require.NoError(t, db.SaveMessageHops([]types.MessageHop{
+			{
+				RunID: "r1", FlowID: "orders", NodeID: "price", Source: "in",
+				Input:   types.MessageSnapshot{ID: "m1", Data: json.RawMessage(`{"qty":2}`), Size: 9},
+				Outputs: []types.MessageSnapshot{{ID: "m2", Headers: map[string]string{"priced": "true"}, Data: json.RawMessage(`{"total":12}`), Size: 12}},
+				Event:   "node.completed", StartedAt: now, FinishedAt: now,
+			},
+			{
+				RunID: "r1", FlowID: "orders", NodeID: "ship", Source: "price",
+				Input: types.MessageSnapshot{ID: "m2", Size: 12},
+				Event: "node.failed", Error: "no carrier", StartedAt: now, FinishedAt: now,
+			},
+			{RunID: "r2", FlowID: "orders", NodeID: "price", Input: types.MessageSnapshot{ID: "m3"}, StartedAt: now, FinishedAt: now},
+		}))
+
+		// Hops are listed per run in the order the nodes processed them
+		hops, err := db.ListMessageHops(store.MessageHopFilter{RunID: "r1"})
+		require.NoError(t, err)
+		require.Len(t, hops, 2)
+		require.Equal(t, "price", hops[0].NodeID)
+		require.JSONEq(t, `{"qty":2}`, string(hops[0].Input.Data))
+		require.Len(t, hops[0].Outputs, 1)
+		require.Equal(t, "true", hops[0].Outputs[0].Headers["priced"])
+		require.True(t, hops[0].StartedAt.Equal(now))
+		require.Equal(t, "no carrier", hops[1].Error)
+		require.Empty(t, hops[1].Outputs)
+
+		hops, err = db.ListMessageHops(store.MessageHopFilter{RunID: "r1", NodeID: "ship"})
+		require.NoError(t, err)
+		require.Len(t, hops, 1)
+		hops, err = db.ListMessageHops(store.MessageHopFilter{RunID: "r1", MessageID: "m2"})
+		require.NoError(t, err)
+		require.Len(t, hops, 1)
+		require.Equal(t, "ship", hops[0].NodeID)
+		hops, err = db.ListMessageHops(store.MessageHopFilter{RunID: "r1", Limit: 1})
+		require.NoError(t, err)
+		require.Len(t, hops, 1)
+		hops, err = db.ListMessageHops(store.MessageHopFilter{RunID: "missing"})
+		require.NoError(t, err)
+		require.Empty(t, hops)
+	})
+
+	// Test run data size
+	t.Run("run data size", func(t *testing.T) {
+		// The node logs and message hops saved above count for their flow
+		size, err := db.RunDataSize([]string{"orders"})
+		require.NoError(t, err)
+		require.Greater(t, size, int64(0))
+		require.NoError(t, db.SaveNodeLogs([]types.NodeLog{
+			{RunID: "r3", FlowID: "orders", NodeID: "price", Level: types.LogLevelInfo, Message: "0123456789", Timestamp: time.Now()},
+		}))
+		grown, err := db.RunDataSize([]string{"orders", "other"})
+		require.NoError(t, err)
+		require.Equal(t, size+10, grown)
+
+		size, err = db.RunDataSize([]string{"other"})
+		require.NoError(t, err)
+		require.Zero(t, size)
+		size, err = db.RunDataSize(nil)
+		require.NoError(t, err)
+		require.Zero(t, size)
+	})
+
+	// Test schema migrations
+	t.Run("schema migrations", func(t *testing.T) {
+		version, err := db.SchemaVersion()
+		require.NoError(t, err)
+		require.Greater(t, version, 0)
+
+		// Reopening an existing database must not reapply migrations
+		reopened, err := store.New(dbPath, log)
+		require.NoError(t, err)
+		defer func() {
+			if err := reopened.Close(); err != nil {
+				t.Errorf("Failed to close store: %v", err)
+			}
+		}()
+
+		got, err := reopened.SchemaVersion()
+		require.NoError(t, err)
+		require.Equal(t, version, got)
+	})
+}
+
+func TestSQLiteTuning(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "tuning.db")
+	log := logger.New()
+
+	db, err := store.New(dbPath, log)
+	require.NoError(t, err)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Failed to close store: %v", err)
+		}
+	}()
+
+	// WAL mode is persisted in the database file
+	raw, err := sql.Open("sqlite3", dbPath)
+	require.NoError(t, err)
+	defer func() {
+		if err := raw.Close(); err != nil {
+			t.Errorf("Failed to close database: %v", err)
+		}
+	}()
+
+	var mode string
+	require.NoError(t, raw.QueryRow("PRAGMA journal_mode").Scan(&mode))
+	require.Equal(t, "wal", mode)
+
+	// Concurrent writers must not fail with "database is locked"
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			flow := &types.RuntimeFlow{
+				ID:     fmt.Sprintf("flow-%d", i),
+				Name:   "Concurrent Flow",
+				Config: "flow test {}",
+				Status: "stopped",
+			}
+			if err := db.CreateFlow(flow); err != nil {
+				errs <- err
+				return
+			}
+			errs <- db.UpdateFlowStatus(flow.ID, "running")
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	flows, err := db.ListFlows()
+	require.NoError(t, err)
+	require.Len(t, flows, 20)
+}
+
+func TestBackupRestore(t *testing.T) {
+	dir := t.TempDir()
+	log := logger.New()
+
+	db, err := store.New(filepath.Join(dir, "live.db"), log)
+	require.NoError(t, err)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Failed to close store: %v", err)
+		}
+	}()
+
+	flow := &types.RuntimeFlow{ID: "backup-flow", Name: "Backup Flow", Config: "{}", Status: "stopped"}
+	require.NoError(t, db.CreateFlow(flow))
+	require.NoError(t, db.SetFlowTags(flow.ID, []string{"nightly"}))
+
+	// Take a backup, then change the live database
+	var backup bytes.Buffer
+	require.NoError(t, db.Backup(&backup))
+	require.NotZero(t, backup.Len())
+
+	require.NoError(t, db.DeleteFlow(flow.ID))
+	require.NoError(t, db.CreateFlow(&types.RuntimeFlow{ID: "later-flow", Name: "Later", Config: "{}", Status: "stopped"}))
+
+	// Restoring brings back the snapshot exactly
+	require.NoError(t, db.Restore(bytes.NewReader(backup.Bytes())))
+	restored, err := db.GetFlow(flow.ID)
+	require.NoError(t, err)
+	require.Equal(t, "Backup Flow", restored.Name)
+	require.Equal(t, []string{"nightly"}, restored.Tags)
+	_, err = db.GetFlow("later-flow")
+	require.ErrorIs(t, err, store.ErrFlowNotFound)
+
+	// The restored database accepts writes
+	require.NoError(t, db.CreateFlow(&types.RuntimeFlow{ID: "after-restore", Name: "After", Config: "{}", Status: "stopped"}))
+
+	// Corrupt or foreign files are rejected and leave the database untouched
+	err = db.Restore(strings.NewReader("not a database"))
+	require.ErrorIs(t, err, store.ErrInvalidBackup)
+
+	truncated := backup.Bytes()[:backup.Len()/2]
+	err = db.Restore(bytes.NewReader(truncated))
+	require.ErrorIs(t, err, store.ErrInvalidBackup)
+
+	foreignPath := filepath.Join(dir, "foreign.db")
+	foreign, err := sql.Open("sqlite3", foreignPath)
+	require.NoError(t, err)
+	_, err = foreign.Exec(`CREATE TABLE other (id INTEGER)`)
+	require.NoError(t, err)
+	require.NoError(t, foreign.Close())
+	require.ErrorIs(t, db.RestoreFromFile(foreignPath), store.ErrInvalidBackup)
+
+	_, err = db.GetFlow("after-restore")
+	require.NoError(t, err)
+
+	// Backups written to a directory are pruned to the retention count
+	backupDir := filepath.Join(dir, "backups")
+	require.NoError(t, os.MkdirAll(backupDir, 0o755))
+	var paths []string
+	for i := 0; i < 3; i++ {
+		path, err := db.BackupToDir(backupDir, 2)
+		require.NoError(t, err)
+		paths = append(paths, path)
+		time.Sleep(2 * time.Millisecond)
+	}
+	entries, err := os.ReadDir(backupDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	_, err = os.Stat(paths[0])
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, db.RestoreFromFile(paths[2]))
+}
+
+func TestBundle(t *testing.T) {
+	dir := t.TempDir()
+	log := logger.New()
+	key := []byte("promotion-key")
+
+	source, err := store.New(filepath.Join(dir, "source.db"), log)
+	require.NoError(t, err)
+	defer func() {
+		if err := source.Close(); err != nil {
+			t.Errorf("Failed to close store: %v", err)
+		}
+	}()
+	target, err := store.New(filepath.Join(dir, "target.db"), log)
+	require.NoError(t, err)
+	defer func() {
+		if err := target.Close(); err != nil {
+			t.Errorf("Failed to close store: %v", err)
+		}
+	}()
+
+	// Build a source workspace with history, tags, a schema and secret references
+	flow := &types.RuntimeFlow{
+		ID:     "orders",
+		Name:   "Orders",
+		Config: `{"token":"${secret.API_TOKEN}","db":"${secret.DB_PASSWORD}"}`,
+		Status: "stopped",
+	}
+	require.NoError(t, source.CreateFlow(flow))
+	flow.Name = "Orders v2"
+	require.NoError(t, source.UpdateFlow(flow))
+	require.NoError(t, source.SetFlowTags(flow.ID, []string{"prod"}))
+	require.NoError(t, source.CreateFlow(&types.RuntimeFlow{ID: "billing", Name: "Billing", Config: "{}", Status: "stopped"}))
+	schemaRecord := &types.SchemaRecord{Type: "order", Version: "1.0", Definition: `{"type":"string"}`}
+	require.NoError(t, source.SaveSchema(schemaRecord))
+
+	var bundle bytes.Buffer
+	require.NoError(t, store.ExportBundle(context.Background(), source, &bundle, store.BundleOptions{Key: key}))
+
+	// Bundles signed with another key, or tampered with, are rejected
+	_, err = store.ImportBundle(context.Background(), target, bytes.NewReader(bundle.Bytes()), store.BundleOptions{Key: []byte("other")})
+	require.ErrorIs(t, err, store.ErrBundleSignature)
+	_, err = store.ImportBundle(context.Background(), target, strings.NewReader("not a bundle"), store.BundleOptions{Key: key})
+	require.ErrorIs(t, err, store.ErrInvalidBundle)
+
+	// Colliding IDs are remapped; explicit mappings win
+	require.NoError(t, target.CreateFlow(&types.RuntimeFlow{ID: "orders", Name: "Existing", Config: "{}", Status: "stopped"}))
+	result, err := store.ImportBundle(context.Background(), target, bytes.NewReader(bundle.Bytes()), store.BundleOptions{
+		Key:   key,
+		IDMap: map[string]string{"billing": "billing-prod"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "billing-prod", result.FlowIDs["billing"])
+	require.NotEqual(t, "orders", result.FlowIDs["orders"])
+	require.True(t, strings.HasPrefix(result.FlowIDs["orders"], "orders-"))
+	require.Equal(t, 1, result.Schemas)
+	require.Equal(t, []string{"API_TOKEN", "DB_PASSWORD"}, result.Secrets)
+
+	imported, err := target.GetFlow(result.FlowIDs["orders"])
+	require.NoError(t, err)
+	require.Equal(t, "Orders v2", imported.Name)
+	require.Equal(t, []string{"prod"}, imported.Tags)
+	require.Equal(t, 1, imported.Revision)
+
+	versions, err := target.ListFlowVersions(imported.ID)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, 2, versions[0].Version)
+
+	existing, err := target.GetFlow("orders")
+	require.NoError(t, err)
+	require.Equal(t, "Existing", existing.Name)
+
+	// Importing again skips identical schemas
+	result, err = store.ImportBundle(context.Background(), target, bytes.NewReader(bundle.Bytes()), store.BundleOptions{Key: key})
+	require.NoError(t, err)
+	require.Equal(t, 0, result.Schemas)
+	require.Equal(t, 1, result.SkippedSchemas)
+
+	// A differing definition for a stored schema version fails the whole import
+	conflicting, err := store.New(filepath.Join(dir, "conflicting.db"), log)
+	require.NoError(t, err)
+	defer func() {
+		if err := conflicting.Close(); err != nil {
+			t.Errorf("Failed to close store: %v", err)
+		}
+	}()
+	require.NoError(t, conflicting.SaveSchema(&types.SchemaRecord{Type: "order", Version: "1.0", Definition: `{"type":"int"}`}))
+	_, err = store.ImportBundle(context.Background(), conflicting, bytes.NewReader(bundle.Bytes()), store.BundleOptions{Key: key})
+	require.ErrorIs(t, err, store.ErrSchemaExists)
+	flows, err := conflicting.ListFlows()
+	require.NoError(t, err)
+	require.Empty(t, flows)
+}
+
+func TestRetentionJanitor(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "retention.db")
+	log := logger.New()
+
+	db, err := store.New(dbPath, log)
+	require.NoError(t, err)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Failed to close store: %v", err)
+		}
+	}()
+
+	// Unknown tables are rejected
+	_, err = store.NewJanitor(db, store.JanitorOptions{
+		Policies: []store.RetentionPolicy{{Table: "flows", MaxAge: time.Hour}},
+	})
+	require.Error(t, err)
+
+	// Three saves give the flow three versions and three audit records
+	flow := &types.RuntimeFlow{ID: "retained-flow", Name: "Retained", Config: "{}", Status: "stopped"}
+	require.NoError(t, db.CreateFlow(flow))
+	require.NoError(t, db.UpdateFlow(flow))
+	require.NoError(t, db.UpdateFlow(flow))
+
+	// Age every row past the retention window
+	raw, err := sql.Open("sqlite3", dbPath)
+	require.NoError(t, err)
+	defer func() {
+		if err := raw.Close(); err != nil {
+			t.Errorf("Failed to close database: %v", err)
+		}
+	}()
+	old := time.Now().Add(-48 * time.Hour)
+	_, err = raw.Exec(`UPDATE audit_log SET created_at = ?`, old)
+	require.NoError(t, err)
+	_, err = raw.Exec(`UPDATE flow_versions SET created_at = ?`, old)
+	require.NoError(t, err)
+
+	// A fresh record stays within the window
+	_, err = raw.Exec(`INSERT INTO audit_log (entity_type, entity_id, action, created_at) VALUES ('flow', ?, 'update', ?)`,
+		flow.ID, time.Now())
+	require.NoError(t, err)
+
+	janitor, err := store.NewJanitor(db, store.JanitorOptions{
+		BatchSize: 2,
+		Policies: []store.RetentionPolicy{
+			{Table: "audit_log", MaxAge: 24 * time.Hour},
+			{Table: "flow_versions", MaxAge: 24 * time.Hour},
+		},
+	})
+	require.NoError(t, err)
+
+	counts, err := janitor.Purge(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(3), counts["audit_log"])
+	require.Equal(t, int64(2), counts["flow_versions"])
+
+	records, err := db.ListAuditRecords(store.AuditEntityFlow, flow.ID)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	// The newest version survives however old it is
+	versions, err := db.ListFlowVersions(flow.ID)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	require.Equal(t, 3, versions[0].Version)
+
+	// Purging again finds nothing and the totals are reported as metrics
+	counts, err = janitor.Purge(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, counts["audit_log"])
+	require.Equal(t, map[string]int64{"audit_log": 3, "flow_versions": 2}, janitor.Stats())
+
+	metrics, err := janitor.Collect()
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	require.Equal(t, "audit_log", metrics[0].Labels["table"])
+	require.Equal(t, float64(3), metrics[0].Value)
+	require.Equal(t, types.MetricTypeCounter, metrics[0].Type)
+
+	// Policies can be replaced while the janitor runs
+	require.Error(t, janitor.SetPolicies(0, []store.RetentionPolicy{{Table: "flows", MaxAge: time.Hour}}))
+	_, err = raw.Exec(`UPDATE audit_log SET created_at = ?`, time.Now().Add(-2*time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, janitor.SetPolicies(0, []store.RetentionPolicy{{Table: "audit_log", MaxAge: time.Hour}}))
+	counts, err = janitor.Purge(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"audit_log": 1}, counts)
+
+	// Run artifacts are purged with the runs, spilled payloads included
+	artifactDir := filepath.Join(t.TempDir(), "artifacts")
+	artifacts, err := store.NewArtifactStore(db, store.ArtifactOptions{Dir: artifactDir, InlineLimit: 16})
+	require.NoError(t, err)
+	for _, payload := range []string{`{"n":1}`, strings.Repeat("x", 40)} {
+		artifact := &types.RunArtifact{FlowID: flow.ID, RunID: "run-1", Kind: store.ArtifactKindDLQ}
+		require.NoError(t, artifacts.Put(context.Background(), artifact, strings.NewReader(payload)))
+	}
+	fresh := &types.RunArtifact{FlowID: flow.ID, RunID: "run-2", Kind: store.ArtifactKindSample}
+	_, err = raw.Exec(`UPDATE run_artifacts SET created_at = ?`, old)
+	require.NoError(t, err)
+	require.NoError(t, artifacts.Put(context.Background(), fresh, strings.NewReader(`{"n":2}`)))
+	entries, err := os.ReadDir(artifactDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	janitor, err = store.NewJanitor(db, store.JanitorOptions{
+		BatchSize:   1,
+		Policies:    []store.RetentionPolicy{{Table: "node_logs", MaxAge: 24 * time.Hour}},
+		ArtifactDir: artifactDir,
+	})
+	require.NoError(t, err)
+	counts, err = janitor.Purge(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"node_logs": 0, "run_artifacts": 2}, counts)
+	entries, err = os.ReadDir(artifactDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	remaining, err := artifacts.List(flow.ID, "")
+	require.NoError(t, err)
+	require.Len(t, remaining, 1)
+	require.Equal(t, fresh.ID, remaining[0].ID)
+}
+
+func TestArtifactStore(t *testing.T) {
+	dir := t.TempDir()
+	log := logger.New()
+
+	db, err := store.New(filepath.Join(dir, "artifacts.db"), log)
+	require.NoError(t, err)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Failed to close store: %v", err)
+		}
+	}()
+
+	artifactDir := filepath.Join(dir, "artifacts")
+	artifacts, err := store.NewArtifactStore(db, store.ArtifactOptions{
+		Dir:              artifactDir,
+		InlineLimit:      16,
+		MaxArtifactBytes: 64,
+		MaxFlowBytes:     100,
+	})
+	require.NoError(t, err)
+
+	flow := &types.RuntimeFlow{ID: "artifact-flow", Name: "Artifacts", Config: "{}", Status: "stopped"}
+	require.NoError(t, db.CreateFlow(flow))
+
+	// Small payloads are kept in the database
+	sample := &types.RunArtifact{FlowID: flow.ID, RunID: "run-1", Kind: store.ArtifactKindSample, ContentType: "application/json"}
+	require.NoError(t, artifacts.Put(context.Background(), sample, strings.NewReader(`{"n":1}`)))
+	require.NotEmpty(t, sample.ID)
+	require.Equal(t, int64(7), sample.Size)
+	require.Len(t, sample.SHA256, 64)
+
+	// Larger payloads spill over to the artifact directory
+	payload := strings.Repeat("x", 40)
+	dlq := &types.RunArtifact{FlowID: flow.ID, RunID: "run-2", Kind: store.ArtifactKindDLQ}
+	require.NoError(t, artifacts.Put(context.Background(), dlq, strings.NewReader(payload)))
+	entries, err := os.ReadDir(artifactDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	// Both stream back unchanged
+	for id, want := range map[string]string{sample.ID: `{"n":1}`, dlq.ID: payload} {
+		meta, r, err := artifacts.Open(id)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, want, string(data))
+		require.Equal(t, int64(len(want)), meta.Size)
+	}
+
+	list, err := artifacts.List(flow.ID, "run-2")
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, dlq.ID, list[0].ID)
+
+	used, err := artifacts.Usage(flow.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(47), used)
+
+	// Quotas cap single artifacts and each flow's total
+	err = artifacts.Put(context.Background(), &types.RunArtifact{FlowID: flow.ID, Kind: store.ArtifactKindReplay},
+		strings.NewReader(strings.Repeat("y", 65)))
+	require.ErrorIs(t, err, store.ErrQuotaExceeded)
+	err = artifacts.Put(context.Background(), &types.RunArtifact{FlowID: flow.ID, Kind: store.ArtifactKindReplay},
+		strings.NewReader(strings.Repeat("y", 60)))
+	require.ErrorIs(t, err, store.ErrQuotaExceeded)
+	entries, err = os.ReadDir(artifactDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	// Deleting an artifact removes its payload
+	require.NoError(t, artifacts.Delete(context.Background(), sample.ID))
+	_, _, err = artifacts.Open(sample.ID)
+	require.ErrorIs(t, err, store.ErrArtifactNotFound)
+
+	// Payloads of deleted flows are cleaned up as orphans
+	require.NoError(t, db.DeleteFlow(flow.ID))
+	removed, err := artifacts.RemoveOrphans()
+	require.NoError(t, err)
+	require.Equal(t, 1, removed)
+	entries, err = os.ReadDir(artifactDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestArtifactCompression(t *testing.T) {
+	dir := t.TempDir()
+	db, err := store.New(filepath.Join(dir, "artifacts.db"), logger.New())
+	require.NoError(t, err)
+	defer db.Close()
+
+	registry := metrics.NewRegistry()
+	artifacts, err := store.NewArtifactStore(db, store.ArtifactOptions{
+		Dir:         filepath.Join(dir, "artifacts"),
+		InlineLimit: 256,
+		Compression: compression.Policy{Default: compression.Gzip, Flows: map[string]string{"plain": "none", "verbose": compression.Zstd}},
+		Metrics:     registry,
+	})
+	require.NoError(t, err)
+
+	// Verbose payloads are stored compressed with the codec of their flow,
+	// small enough to stay in the database, and read back as they were
+	payload := `[` + strings.Repeat(`{"status":"paid","currency":"EUR"},`, 50) + `{}]`
+	for id, codec := range map[string]string{"verbose": compression.Zstd, "other": compression.Gzip, "plain": compression.None} {
+		require.NoError(t, db.CreateFlow(&types.RuntimeFlow{ID: id, Name: id, Config: "{}", Status: "stopped"}))
+		dlq := &types.RunArtifact{FlowID: id, Kind: store.ArtifactKindDLQ}
+		require.NoError(t, artifacts.Put(context.Background(), dlq, strings.NewReader(payload)))
+		require.Equal(t, codec, dlq.Encoding, id)
+		require.Equal(t, int64(len(payload)), dlq.Size)
+
+		meta, r, err := artifacts.Open(dlq.ID)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, payload, string(data))
+		require.Equal(t, codec, meta.Encoding)
+		require.Equal(t, dlq.StoredSize, meta.StoredSize)
+		if codec == compression.None {
+			require.Equal(t, dlq.Size, meta.StoredSize)
+		} else {
+			require.Less(t, meta.StoredSize, int64(256))
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "artifacts"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	var out strings.Builder
+	require.NoError(t, registry.WritePrometheus(&out))
+	require.Contains(t, out.String(), fmt.Sprintf(`payload_bytes_total{boundary="artifact",codec="zstd",flow_id="verbose"} %d`, len(payload)))
+	require.Contains(t, out.String(), `payload_stored_bytes_total{boundary="artifact",codec="zstd",flow_id="verbose"}`)
+
+	_, err = store.NewArtifactStore(db, store.ArtifactOptions{Dir: dir, Compression: compression.Policy{Default: "lz4"}})
+	require.Error(t, err)
+}
+
+func TestStoreTelemetry(t *testing.T) {
+	metrics := &recordingMetrics{}
+	log := &recordingLogger{Logger: logger.New()}
+
+	opts := store.DefaultOptions()
+	opts.Metrics = metrics
+	opts.SlowQueryThreshold = time.Nanosecond // Every statement counts as slow
+
+	db, err := store.New(filepath.Join(t.TempDir(), "telemetry.db"), log, opts)
+	require.NoError(t, err)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Failed to close store: %v", err)
+		}
+	}()
+
+	flow := &types.RuntimeFlow{ID: "timed-flow", Name: "Timed", Config: "token=hunter2", Status: "stopped"}
+	require.NoError(t, db.CreateFlow(flow))
+	_, err = db.GetFlow(flow.ID)
+	require.NoError(t, err)
+
+	// Statements are timed with operation and table labels
+	require.True(t, metrics.observed("store_query_duration_seconds", "insert", "flows"))
+	require.True(t, metrics.observed("store_query_duration_seconds", "select", "flows"))
+	require.True(t, metrics.observed("store_transaction_duration_seconds", "", ""))
+
+	// Slow queries are logged without revealing parameter values
+	require.NotEmpty(t, log.warnings)
+	for _, fields := range log.warnings {
+		require.NotContains(t, fmt.Sprint(fields["args"]), "hunter2")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	log := logger.New()
+
+	_, err := store.Open("mysql", "flows", log)
+	require.Error(t, err)
+}
+
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("FLOWCONTROL_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("FLOWCONTROL_TEST_POSTGRES_DSN not set")
+	}
+
+	log := logger.New()
+	db, err := store.Open("postgres", dsn, log)
+	require.NoError(t, err)
+	defer func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Failed to close store: %v", err)
+		}
+	}()
+
+	flow := &types.RuntimeFlow{
+		ID:     "pg-test-flow",
+		Name:   "Postgres Flow",
+		Config: "flow test {}",
+		Status: "stopped",
+	}
+	require.NoError(t, db.CreateFlow(flow))
+	defer func() {
+		if err := db.DeleteFlow(flow.ID); err != nil {
+			t.Errorf("Failed to delete flow: %v", err)
+		}
+	}()
+
+	got, err := db.GetFlow(flow.ID)
+	require.NoError(t, err)
+	require.Equal(t, flow.Name, got.Name)
+
+	require.NoError(t, db.UpdateFlowStatus(flow.ID, "running"))
+	got, err = db.GetFlow(flow.ID)
+	require.NoError(t, err)
+	require.Equal(t, "running", got.Status)
+
+	acquired, err := db.AcquireLease("pg-test-lease", "node-a", time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+	acquired, err = db.AcquireLease("pg-test-lease", "node-b", time.Minute)
+	require.NoError(t, err)
+	require.False(t, acquired)
+	require.NoError(t, db.ReleaseLease("pg-test-lease", "node-a"))
+}
+
+// stepIDs returns the IDs of the given steps in order
+func stepIDs(steps []*types.FlowStep) []string {
+	ids := make([]string, len(steps))
+	for i, step := range steps {
+		ids[i] = step.ID
+	}
+	return ids
+}
+
+// recordingMetrics is a types.MetricsPort that records histogram observations
+type recordingMetrics struct {
+	mu           sync.Mutex
+	observations []map[string]string
+}
+
+func (m *recordingMetrics) Inc(string, float64, map[string]string) {}
+func (m *recordingMetrics) Dec(string, float64, map[string]string) {}
+func (m *recordingMetrics) Set(string, float64, map[string]string) {}
+
+func (m *recordingMetrics) Observe(name string, _ float64, labels map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := map[string]string{"name": name}
+	for k, v := range labels {
+		entry[k] = v
+	}
+	m.observations = append(m.observations, entry)
+}
+
+func (m *recordingMetrics) Register(types.MetricsCollector) error   { return nil }
+func (m *recordingMetrics) Unregister(types.MetricsCollector) error { return nil }
+
+// observed reports whether a matching observation was recorded; empty
+// operation and table match any value
+func (m *recordingMetrics) observed(name, operation, table string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, o := range m.observations {
+		if o["name"] == name && (operation == "" || o["operation"] == operation) && (table == "" || o["table"] == table) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordingLogger captures the fields of warnings
+type recordingLogger struct {
+	types.Logger
+	mu       sync.Mutex
+	warnings []types.Fields
+}
+
+func (l *recordingLogger) Warn(msg string, fields types.Fields) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warnings = append(l.warnings, fields)
+	l.Logger.Warn(msg, fields)
 }
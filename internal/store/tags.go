@@ -0,0 +1,232 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"flow-control/internal/types"
+)
+
+// FlowFilter narrows the flows returned by ListFlows
+type FlowFilter struct {
+	// Tags restricts results to flows carrying every listed tag
+	Tags []string
+}
+
+// where builds the WHERE clause and arguments for the filter
+func (f FlowFilter) where() (string, []interface{}) {
+	tags := normalizeTags(f.Tags)
+	if len(tags) == 0 {
+		return "", nil
+	}
+
+	args := make([]interface{}, 0, len(tags)+1)
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+	args = append(args, len(tags))
+
+	clause := `
+		WHERE id IN (
+			SELECT flow_id FROM flow_tags
+			WHERE tag IN (` + placeholders(len(tags)) + `)
+			GROUP BY flow_id
+			HAVING COUNT(DISTINCT tag) = ?
+		)
+	`
+	return clause, args
+}
+
+// normalizeTags trims, deduplicates and sorts tags, dropping empty ones
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// placeholders returns n comma-separated ? placeholders
+func placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// GetFlowTags returns the tags attached to a flow
+func (s *sqlStore) GetFlowTags(id string) ([]string, error) {
+	if err := s.requireFlow(id); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.query(`SELECT tag FROM flow_tags WHERE flow_id = ? ORDER BY tag`, id)
+	if err != nil {
+		s.log.Error("Failed to get flow tags", err, types.Fields{
+			"function": "GetFlowTags",
+			"flow_id":  id,
+		})
+		return nil, fmt.Errorf("failed to get flow tags: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.log.Error("Failed to close rows", err, types.Fields{
+				"function": "GetFlowTags",
+			})
+		}
+	}()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, fmt.Errorf("failed to scan tag: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tags: %w", err)
+	}
+
+	return tags, nil
+}
+
+// SetFlowTags replaces all tags on a flow
+func (s *sqlStore) SetFlowTags(id string, tags []string) error {
+	if err := s.requireFlow(id); err != nil {
+		return err
+	}
+
+	return s.WithTx(context.Background(), func(tx *Tx) error {
+		if _, err := tx.Exec(`DELETE FROM flow_tags WHERE flow_id = ?`, id); err != nil {
+			s.log.Error("Failed to clear flow tags", err, types.Fields{
+				"function": "SetFlowTags",
+				"flow_id":  id,
+			})
+			return fmt.Errorf("failed to clear flow tags: %w", err)
+		}
+
+		query := `INSERT INTO flow_tags (flow_id, tag) VALUES (?, ?) ON CONFLICT DO NOTHING`
+		for _, tag := range normalizeTags(tags) {
+			if _, err := tx.Exec(query, id, tag); err != nil {
+				s.log.Error("Failed to add flow tag", err, types.Fields{
+					"function": "SetFlowTags",
+					"flow_id":  id,
+					"tag":      tag,
+				})
+				return fmt.Errorf("failed to add flow tag: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// AddFlowTags attaches tags to a flow, ignoring tags it already carries
+func (s *sqlStore) AddFlowTags(id string, tags ...string) error {
+	if err := s.requireFlow(id); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO flow_tags (flow_id, tag) VALUES (?, ?) ON CONFLICT DO NOTHING`
+	for _, tag := range normalizeTags(tags) {
+		if _, err := s.exec(query, id, tag); err != nil {
+			s.log.Error("Failed to add flow tag", err, types.Fields{
+				"function": "AddFlowTags",
+				"flow_id":  id,
+				"tag":      tag,
+			})
+			return fmt.Errorf("failed to add flow tag: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// RemoveFlowTag detaches a tag from a flow
+func (s *sqlStore) RemoveFlowTag(id, tag string) error {
+	result, err := s.exec(`DELETE FROM flow_tags WHERE flow_id = ? AND tag = ?`, id, tag)
+	if err != nil {
+		s.log.Error("Failed to remove flow tag", err, types.Fields{
+			"function": "RemoveFlowTag",
+			"flow_id":  id,
+			"tag":      tag,
+		})
+		return fmt.Errorf("failed to remove flow tag: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("%w: %s on flow %s", ErrTagNotFound, tag, id)
+	}
+
+	return nil
+}
+
+// requireFlow returns an error if the flow does not exist
+func (s *sqlStore) requireFlow(id string) error {
+	var exists int
+	err := s.queryRow(`SELECT COUNT(*) FROM flows WHERE id = ?`, id).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check flow: %w", err)
+	}
+	if exists == 0 {
+		return fmt.Errorf("%w: %s", ErrFlowNotFound, id)
+	}
+	return nil
+}
+
+// loadTags populates the Tags field of the given flows
+func (s *sqlStore) loadTags(flows []*types.RuntimeFlow) error {
+	if len(flows) == 0 {
+		return nil
+	}
+
+	byID := make(map[string]*types.RuntimeFlow, len(flows))
+	args := make([]interface{}, 0, len(flows))
+	for _, flow := range flows {
+		byID[flow.ID] = flow
+		args = append(args, flow.ID)
+	}
+
+	query := `SELECT flow_id, tag FROM flow_tags WHERE flow_id IN (` + placeholders(len(flows)) + `) ORDER BY tag`
+	rows, err := s.query(query, args...)
+	if err != nil {
+		s.log.Error("Failed to load flow tags", err, types.Fields{
+			"function": "loadTags",
+		})
+		return fmt.Errorf("failed to load flow tags: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.log.Error("Failed to close rows", err, types.Fields{
+				"function": "loadTags",
+			})
+		}
+	}()
+
+	for rows.Next() {
+		var flowID, tag string
+		if err := rows.Scan(&flowID, &tag); err != nil {
+			return fmt.Errorf("failed to scan tag: %w", err)
+		}
+		if flow, ok := byID[flowID]; ok {
+			flow.Tags = append(flow.Tags, tag)
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,160 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+const (
+	// queryDurationMetric is the histogram of statement durations in seconds
+	queryDurationMetric = "store_query_duration_seconds"
+	// txDurationMetric is the histogram of transaction durations in seconds
+	txDurationMetric = "store_transaction_duration_seconds"
+	// maxLoggedQueryLength caps the statement text in slow query logs
+	maxLoggedQueryLength = 500
+)
+
+// exec runs a statement outside a transaction and records its timing
+func (s *sqlStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := s.db.Exec(s.rebind(query), args...)
+	s.observeQuery(query, args, start, err)
+	return result, err
+}
+
+// query runs a query outside a transaction and records its timing
+func (s *sqlStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := s.db.Query(s.rebind(query), args...)
+	s.observeQuery(query, args, start, err)
+	return rows, err
+}
+
+// queryRow runs a single-row query outside a transaction and records its
+// timing
+func (s *sqlStore) queryRow(query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := s.db.QueryRow(s.rebind(query), args...)
+	s.observeQuery(query, args, start, row.Err())
+	return row
+}
+
+// observeQuery records a statement's duration and logs it when it exceeds
+// the slow query threshold
+func (s *sqlStore) observeQuery(query string, args []interface{}, start time.Time, err error) {
+	elapsed := time.Since(start)
+	operation, table := queryLabels(query)
+
+	if s.metrics != nil {
+		s.metrics.Observe(queryDurationMetric, elapsed.Seconds(), map[string]string{
+			"operation": operation,
+			"table":     table,
+			"status":    statusLabel(err),
+		})
+	}
+
+	if s.slowQuery > 0 && elapsed >= s.slowQuery {
+		s.log.Warn("Slow query", types.Fields{
+			"function":    "observeQuery",
+			"operation":   operation,
+			"table":       table,
+			"duration_ms": elapsed.Milliseconds(),
+			"query":       compactQuery(query),
+			"args":        sanitizeArgs(args),
+		})
+	}
+}
+
+// observeTx records a transaction's duration
+func (s *sqlStore) observeTx(start time.Time, attempts int, err error) {
+	if s.metrics == nil {
+		return
+	}
+	s.metrics.Observe(txDurationMetric, time.Since(start).Seconds(), map[string]string{
+		"status":   statusLabel(err),
+		"attempts": fmt.Sprintf("%d", attempts),
+	})
+}
+
+// statusLabel returns the metric status label for an error
+func statusLabel(err error) string {
+	if err != nil && err != sql.ErrNoRows {
+		return "error"
+	}
+	return "ok"
+}
+
+// queryLabels returns the statement's verb and the first table it names, for
+// use as low-cardinality metric labels
+func queryLabels(query string) (operation, table string) {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return "unknown", ""
+	}
+	operation = strings.ToLower(words[0])
+
+	marker := ""
+	switch operation {
+	case "select", "delete":
+		marker = "from"
+	case "insert":
+		marker = "into"
+	case "update":
+		if len(words) > 1 {
+			return operation, strings.ToLower(words[1])
+		}
+	}
+	if marker == "" {
+		return operation, ""
+	}
+
+	for i, word := range words[:len(words)-1] {
+		if strings.EqualFold(word, marker) {
+			return operation, strings.ToLower(strings.Trim(words[i+1], "(),;"))
+		}
+	}
+	return operation, ""
+}
+
+// compactQuery collapses whitespace in a statement and truncates it for
+// logging
+func compactQuery(query string) string {
+	compact := strings.Join(strings.Fields(query), " ")
+	if len(compact) > maxLoggedQueryLength {
+		compact = compact[:maxLoggedQueryLength] + "..."
+	}
+	return compact
+}
+
+// sanitizeArgs describes query parameters without revealing text or binary
+// values, which may hold flow configs or other sensitive data
+func sanitizeArgs(args []interface{}) []string {
+	sanitized := make([]string, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case nil:
+			sanitized[i] = "NULL"
+		case string:
+			sanitized[i] = fmt.Sprintf("<string len=%d>", len(v))
+		case []byte:
+			sanitized[i] = fmt.Sprintf("<bytes len=%d>", len(v))
+		case sql.NullString:
+			if !v.Valid {
+				sanitized[i] = "NULL"
+			} else {
+				sanitized[i] = fmt.Sprintf("<string len=%d>", len(v.String))
+			}
+		case time.Time:
+			sanitized[i] = v.Format(time.RFC3339Nano)
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, bool:
+			sanitized[i] = fmt.Sprintf("%v", v)
+		default:
+			sanitized[i] = fmt.Sprintf("<%T>", v)
+		}
+	}
+	return sanitized
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"flow-control/internal/types"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	// maxTxAttempts is how many times WithTx runs a transaction that keeps
+	// failing with a transient locking error
+	maxTxAttempts = 5
+	// txRetryBackoff is the delay before the first retry, doubled on each
+	// subsequent attempt
+	txRetryBackoff = 10 * time.Millisecond
+)
+
+// Tx is a database transaction bound to a context. Queries use ? placeholders
+// and are rewritten for the store's dialect.
+type Tx struct {
+	ctx   context.Context
+	tx    *sql.Tx
+	store *sqlStore
+}
+
+// Context returns the context the transaction is bound to
+func (t *Tx) Context() context.Context {
+	return t.ctx
+}
+
+// Exec executes a statement within the transaction
+func (t *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	result, err := t.tx.ExecContext(t.ctx, t.store.rebind(query), args...)
+	t.store.observeQuery(query, args, start, err)
+	return result, err
+}
+
+// Query runs a query within the transaction
+func (t *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := t.tx.QueryContext(t.ctx, t.store.rebind(query), args...)
+	t.store.observeQuery(query, args, start, err)
+	return rows, err
+}
+
+// QueryRow runs a query expected to return at most one row
+func (t *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := t.tx.QueryRowContext(t.ctx, t.store.rebind(query), args...)
+	t.store.observeQuery(query, args, start, row.Err())
+	return row
+}
+
+// WithTx runs fn inside a transaction, committing on success and rolling back
+// on error. Transactions that fail because of lock contention, a deadlock or a
+// serialization conflict are retried with backoff, so fn may run more than
+// once and must not have side effects outside the transaction.
+func (s *sqlStore) WithTx(ctx context.Context, fn func(tx *Tx) error) error {
+	start := time.Now()
+	backoff := txRetryBackoff
+	var err error
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		err = s.runTx(ctx, fn)
+		if err == nil || !isRetryable(err) {
+			s.observeTx(start, attempt, err)
+			return err
+		}
+
+		s.log.Warn("Retrying transaction after transient error", types.Fields{
+			"function": "WithTx",
+			"attempt":  attempt,
+			"error":    err.Error(),
+		})
+
+		select {
+		case <-ctx.Done():
+			s.observeTx(start, attempt, ctx.Err())
+			return fmt.Errorf("transaction aborted: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	s.observeTx(start, maxTxAttempts, err)
+	return fmt.Errorf("transaction failed after %d attempts: %w", maxTxAttempts, err)
+}
+
+// runTx makes a single attempt at running fn inside a transaction
+func (s *sqlStore) runTx(ctx context.Context, fn func(tx *Tx) error) error {
+	sqlTx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(&Tx{ctx: ctx, tx: sqlTx, store: s}); err != nil {
+		if rbErr := sqlTx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			s.log.Error("Failed to roll back transaction", rbErr, types.Fields{
+				"function": "runTx",
+			})
+		}
+		return err
+	}
+
+	if err := sqlTx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// isRetryable reports whether err is a transient locking error after which
+// the whole transaction can safely be run again
+func isRetryable(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "40001", // serialization_failure
+			"40P01": // deadlock_detected
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package store
+
+import (
+	"fmt"
+
+	"flow-control/internal/types"
+)
+
+// RunDataSize returns the size, in characters, of the run data the store
+// keeps for the flows with ids: the node logs and timelines of their runs
+func (s *sqlStore) RunDataSize(ids []string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	args := make([]interface{}, 0, 2*len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	args = append(args, args...)
+	query := `
+		SELECT CAST(COALESCE(SUM(size), 0) AS BIGINT) FROM (
+			SELECT LENGTH(message) + COALESCE(LENGTH(fields), 0) + COALESCE(LENGTH(error), 0) AS size
+			FROM node_logs WHERE flow_id IN (` + placeholders(len(ids)) + `)
+			UNION ALL
+			SELECT LENGTH(input) + COALESCE(LENGTH(outputs), 0) + COALESCE(LENGTH(error), 0) AS size
+			FROM message_hops WHERE flow_id IN (` + placeholders(len(ids)) + `)
+		) AS run_data
+	`
+	var size int64
+	if err := s.queryRow(query, args...).Scan(&size); err != nil {
+		s.log.Error("Failed to measure run data", err, types.Fields{
+			"function": "RunDataSize",
+			"flows":    len(ids),
+		})
+		return 0, fmt.Errorf("failed to measure run data: %w", err)
+	}
+	return size, nil
+}
@@ -0,0 +1,240 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"flow-control/internal/types"
+)
+
+// Audit actions recorded for flows
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+	AuditActionImport = "import"
+)
+
+// Audit actions recorded for the approvals of Approval nodes
+const (
+	AuditActionRequest  = "request"
+	AuditActionEscalate = "escalate"
+	AuditActionApprove  = "approve"
+	AuditActionReject   = "reject"
+	AuditActionCancel   = "cancel"
+)
+
+// Audit entity types
+const (
+	AuditEntityFlow     = "flow"
+	AuditEntityApproval = "approval"
+)
+
+// ListFlowVersions returns the saved versions of a flow, newest first
+func (s *sqlStore) ListFlowVersions(flowID string) ([]*types.FlowVersion, error) {
+	if err := s.requireFlow(flowID); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT flow_id, version, code, metadata, created_at
+		FROM flow_versions
+		WHERE flow_id = ?
+		ORDER BY version DESC
+	`
+	rows, err := s.query(query, flowID)
+	if err != nil {
+		s.log.Error("Failed to list flow versions", err, types.Fields{
+			"function": "ListFlowVersions",
+			"flow_id":  flowID,
+		})
+		return nil, fmt.Errorf("failed to list flow versions: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.log.Error("Failed to close rows", err, types.Fields{
+				"function": "ListFlowVersions",
+			})
+		}
+	}()
+
+	versions := []*types.FlowVersion{}
+	for rows.Next() {
+		version := &types.FlowVersion{}
+		var metadata sql.NullString
+		if err := rows.Scan(&version.FlowID, &version.Version, &version.Config, &metadata, &version.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan flow version: %w", err)
+		}
+		if version.Metadata, err = decodeJSONMap(metadata); err != nil {
+			return nil, fmt.Errorf("failed to decode flow version metadata: %w", err)
+		}
+		versions = append(versions, version)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating flow versions: %w", err)
+	}
+
+	return versions, nil
+}
+
+// ListAuditRecords returns the audit trail of an entity, oldest first
+func (s *sqlStore) ListAuditRecords(entityType, entityID string) ([]*types.AuditRecord, error) {
+	query := `
+		SELECT id, entity_type, entity_id, action, details, created_at
+		FROM audit_log
+		WHERE entity_type = ? AND entity_id = ?
+		ORDER BY id
+	`
+	rows, err := s.query(query, entityType, entityID)
+	if err != nil {
+		s.log.Error("Failed to list audit records", err, types.Fields{
+			"function":    "ListAuditRecords",
+			"entity_type": entityType,
+			"entity_id":   entityID,
+		})
+		return nil, fmt.Errorf("failed to list audit records: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.log.Error("Failed to close rows", err, types.Fields{
+				"function": "ListAuditRecords",
+			})
+		}
+	}()
+
+	records := []*types.AuditRecord{}
+	for rows.Next() {
+		record := &types.AuditRecord{}
+		var details sql.NullString
+		if err := rows.Scan(&record.ID, &record.EntityType, &record.EntityID, &record.Action, &details, &record.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit record: %w", err)
+		}
+		if record.Details, err = decodeJSONMap(details); err != nil {
+			return nil, fmt.Errorf("failed to decode audit details: %w", err)
+		}
+		records = append(records, record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit records: %w", err)
+	}
+
+	return records, nil
+}
+
+// RecordAudit appends an entry to the audit trail of an entity that is
+// not stored, such as an approval
+func (s *sqlStore) RecordAudit(entityType, entityID, action string, details map[string]interface{}) error {
+	return s.WithTx(context.Background(), func(tx *Tx) error {
+		return s.insertAuditRecord(tx, entityType, entityID, action, details)
+	})
+}
+
+// saveFlowRecords writes the records that accompany every flow save: the
+// derived steps, a version entry and an audit record. Steps are left
+// untouched when nil.
+func (s *sqlStore) saveFlowRecords(tx *Tx, flow *types.RuntimeFlow, steps []*types.FlowStep, action string) error {
+	details := map[string]interface{}{}
+	if steps != nil {
+		if err := s.replaceSteps(tx, flow.ID, steps); err != nil {
+			return err
+		}
+		details["steps"] = len(steps)
+	}
+
+	version, err := s.insertFlowVersion(tx, flow)
+	if err != nil {
+		return err
+	}
+	details["version"] = version
+
+	return s.insertAuditRecord(tx, AuditEntityFlow, flow.ID, action, details)
+}
+
+// deriveSteps returns the steps described by a flow's config. Configs that are
+// not written in the Flow language yield nil so existing steps are kept.
+func (s *sqlStore) deriveSteps(flow *types.RuntimeFlow) []*types.FlowStep {
+	steps, err := DeriveFlowSteps(flow.ID, flow.Config, s.log)
+	if err != nil {
+		s.log.Warn("Skipping step sync for flow config", types.Fields{
+			"function": "deriveSteps",
+			"flow_id":  flow.ID,
+			"error":    err.Error(),
+		})
+		return nil
+	}
+	return steps
+}
+
+// insertFlowVersion snapshots a flow's config as its next version and
+// returns the version number
+func (s *sqlStore) insertFlowVersion(tx *Tx, flow *types.RuntimeFlow) (int, error) {
+	var version int
+	query := `SELECT COALESCE(MAX(version), 0) + 1 FROM flow_versions WHERE flow_id = ?`
+	if err := tx.QueryRow(query, flow.ID).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to get next flow version: %w", err)
+	}
+
+	metadata, err := json.Marshal(map[string]interface{}{
+		"name":        flow.Name,
+		"description": flow.Description,
+		"version":     flow.Version,
+		"status":      flow.Status,
+		"revision":    flow.Revision,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode flow version metadata: %w", err)
+	}
+
+	query = `INSERT INTO flow_versions (flow_id, version, code, metadata, created_at) VALUES (?, ?, ?, ?, ?)`
+	if _, err := tx.Exec(query, flow.ID, version, flow.Config, string(metadata), flow.UpdatedAt); err != nil {
+		s.log.Error("Failed to create flow version", err, types.Fields{
+			"function": "insertFlowVersion",
+			"flow_id":  flow.ID,
+			"version":  version,
+		})
+		return 0, fmt.Errorf("failed to create flow version: %w", err)
+	}
+
+	return version, nil
+}
+
+// insertAuditRecord appends an entry to the audit log within a transaction
+func (s *sqlStore) insertAuditRecord(tx *Tx, entityType, entityID, action string, details map[string]interface{}) error {
+	var encoded sql.NullString
+	if len(details) > 0 {
+		data, err := json.Marshal(details)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit details: %w", err)
+		}
+		encoded = sql.NullString{String: string(data), Valid: true}
+	}
+
+	query := `INSERT INTO audit_log (entity_type, entity_id, action, details, created_at) VALUES (?, ?, ?, ?, ?)`
+	if _, err := tx.Exec(query, entityType, entityID, action, encoded, time.Now()); err != nil {
+		s.log.Error("Failed to create audit record", err, types.Fields{
+			"function":    "insertAuditRecord",
+			"entity_type": entityType,
+			"entity_id":   entityID,
+			"action":      action,
+		})
+		return fmt.Errorf("failed to create audit record: %w", err)
+	}
+	return nil
+}
+
+// decodeJSONMap decodes an optional JSON object column
+func decodeJSONMap(value sql.NullString) (map[string]interface{}, error) {
+	if !value.Valid || value.String == "" {
+		return nil, nil
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal([]byte(value.String), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,218 @@
+/*
+Package templates holds the library of starter flows shipped with Flow
+Control. A template is Flow source with {{parameter}} placeholders, which
+Instantiate replaces with the values given for the template's parameters.
+
+The templates are embedded in the binary from library/*.yaml, one file per
+template named by its ID. Source and sink node types in them, such as
+HTTPPoll or SQLWriter, run on servers providing them and can be stubbed in
+dry runs.
+*/
+package templates
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"flow-control/internal/parser"
+	"flow-control/internal/parser/analyzer"
+	"flow-control/internal/types"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed library/*.yaml
+var library embed.FS
+
+var (
+	// ErrNotFound is returned for template IDs missing from a library
+	ErrNotFound = errors.New("template not found")
+
+	// ErrInvalidParameters is returned when the values given to Instantiate
+	// do not match the template's parameters
+	ErrInvalidParameters = errors.New("invalid template parameters")
+)
+
+// placeholder matches the {{parameter}} placeholders of template source
+var placeholder = regexp.MustCompile(`\{\{\s*([a-z][a-z0-9_]*)\s*\}\}`)
+
+// Parameter is a value a template is instantiated with
+type Parameter struct {
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description" json:"description"`
+	// Default is used when no value is given; required parameters have none
+	Default  string `yaml:"default" json:"default,omitempty"`
+	Required bool   `yaml:"required" json:"required"`
+}
+
+// Template is a starter flow
+type Template struct {
+	ID          string      `yaml:"-" json:"id"`
+	Name        string      `yaml:"name" json:"name"`
+	Description string      `yaml:"description" json:"description"`
+	Tags        []string    `yaml:"tags" json:"tags"`
+	Parameters  []Parameter `yaml:"parameters" json:"parameters"`
+	// Source is Flow source with a {{name}} placeholder for every parameter
+	Source string `yaml:"source" json:"source"`
+}
+
+// Library is a set of templates
+type Library struct {
+	templates map[string]*Template
+}
+
+var (
+	builtinOnce sync.Once
+	builtin     *Library
+)
+
+// Builtin returns the templates embedded in the binary. It panics if they
+// are malformed, which the package's tests rule out.
+func Builtin() *Library {
+	builtinOnce.Do(func() {
+		fsys, err := fs.Sub(library, "library")
+		if err == nil {
+			builtin, err = Load(fsys)
+		}
+		if err != nil {
+			panic(fmt.Sprintf("templates: invalid built-in library: %v", err))
+		}
+	})
+	return builtin
+}
+
+// Load reads the templates of the .yaml files at the root of fsys, each
+// named by its file name without the extension
+func Load(fsys fs.FS) (*Library, error) {
+	names, err := fs.Glob(fsys, "*.yaml")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	l := &Library{templates: make(map[string]*Template, len(names))}
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template %s: %w", name, err)
+		}
+		var t Template
+		if err := yaml.Unmarshal(data, &t); err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+		}
+		t.ID = strings.TrimSuffix(path.Base(name), ".yaml")
+		if err := t.check(); err != nil {
+			return nil, fmt.Errorf("invalid template %s: %w", t.ID, err)
+		}
+		l.templates[t.ID] = &t
+	}
+	return l, nil
+}
+
+// check reports templates without a name or source, with parameters
+// declared twice or placeholders for undeclared parameters
+func (t *Template) check() error {
+	if t.Name == "" {
+		return fmt.Errorf("name must be set")
+	}
+	if strings.TrimSpace(t.Source) == "" {
+		return fmt.Errorf("source must be set")
+	}
+	declared := map[string]bool{}
+	for _, p := range t.Parameters {
+		if !placeholder.MatchString("{{" + p.Name + "}}") {
+			return fmt.Errorf("invalid parameter name %q", p.Name)
+		}
+		if declared[p.Name] {
+			return fmt.Errorf("parameter %s is declared twice", p.Name)
+		}
+		declared[p.Name] = true
+	}
+	for _, match := range placeholder.FindAllStringSubmatch(t.Source, -1) {
+		if !declared[match[1]] {
+			return fmt.Errorf("source uses undeclared parameter %s", match[1])
+		}
+	}
+	if t.Tags == nil {
+		t.Tags = []string{}
+	}
+	if t.Parameters == nil {
+		t.Parameters = []Parameter{}
+	}
+	return nil
+}
+
+// List returns the templates of the library ordered by ID
+func (l *Library) List() []*Template {
+	list := make([]*Template, 0, len(l.templates))
+	for _, t := range l.templates {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Get returns the template with id
+func (l *Library) Get(id string) (*Template, error) {
+	t, ok := l.templates[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return t, nil
+}
+
+// Instantiate returns the source of the template with its placeholders
+// replaced by values, or by the defaults of the parameters without one. It
+// fails with ErrInvalidParameters for unknown parameters, missing required
+// ones and values that leave the source invalid.
+//
+// The placeholders of templates stand inside Flow strings, which cannot
+// escape line breaks and keep escaped quotes as written, so values holding
+// quotes or line breaks, or ending with a backslash, are rejected rather
+// than let out of their strings.
+func (t *Template) Instantiate(values map[string]string, log types.Logger) (string, error) {
+	resolved := make(map[string]string, len(t.Parameters))
+	for _, p := range t.Parameters {
+		value, ok := values[p.Name]
+		if !ok || value == "" {
+			if p.Required {
+				return "", fmt.Errorf("%w: %s is required", ErrInvalidParameters, p.Name)
+			}
+			value = p.Default
+		}
+		if strings.ContainsAny(value, "\"\r\n") || strings.HasSuffix(value, `\`) {
+			return "", fmt.Errorf("%w: %s cannot hold quotes or line breaks, or end with a backslash", ErrInvalidParameters, p.Name)
+		}
+		resolved[p.Name] = value
+	}
+	var unknown []string
+	for name := range values {
+		if _, ok := resolved[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return "", fmt.Errorf("%w: unknown parameters %s", ErrInvalidParameters, strings.Join(unknown, ", "))
+	}
+
+	src := placeholder.ReplaceAllStringFunc(t.Source, func(match string) string {
+		return resolved[placeholder.FindStringSubmatch(match)[1]]
+	})
+
+	program, diagnostics := parser.Parse(src, log)
+	if len(diagnostics) == 0 {
+		diagnostics = analyzer.Analyze(program)
+	}
+	for _, d := range diagnostics {
+		if d.Severity == parser.SeverityError {
+			return "", fmt.Errorf("%w: %s", ErrInvalidParameters, d)
+		}
+	}
+	return src, nil
+}
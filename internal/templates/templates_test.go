@@ -0,0 +1,91 @@
+package templates_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"flow-control/internal/logger"
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/templates"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuiltin(t *testing.T) {
+	log := logger.New()
+	list := templates.Builtin().List()
+	require.NotEmpty(t, list)
+
+	// Every template builds a valid flow from its required parameters
+	for _, tmpl := range list {
+		values := map[string]string{}
+		for _, p := range tmpl.Parameters {
+			if p.Required {
+				values[p.Name] = "sample-" + p.Name
+			}
+		}
+		src, err := tmpl.Instantiate(values, log)
+		require.NoError(t, err, tmpl.ID)
+		graph, err := engine.LoadSource(src, "", log)
+		require.NoError(t, err, tmpl.ID)
+		require.NotEmpty(t, graph.Nodes, tmpl.ID)
+	}
+}
+
+func TestInstantiate(t *testing.T) {
+	log := logger.New()
+	tmpl, err := templates.Builtin().Get("kafka-filter-forward")
+	require.NoError(t, err)
+	values := map[string]string{
+		"name":         "paid-orders",
+		"source_topic": "orders",
+		"field":        "status",
+		"value":        "paid",
+		"target_topic": "billing",
+	}
+
+	// Values replace placeholders and defaults fill the gaps
+	src, err := tmpl.Instantiate(values, log)
+	require.NoError(t, err)
+	graph, err := engine.LoadSource(src, "", log)
+	require.NoError(t, err)
+	require.Equal(t, "paid-orders", graph.FlowID)
+	require.Equal(t, "orders", graph.Nodes[0].Config.Settings["topic"])
+	require.Equal(t, "localhost:9092", graph.Nodes[0].Config.Settings["brokers"])
+
+	// Missing, unknown and unsafe values are rejected
+	for name, change := range map[string]func(map[string]string){
+		"missing": func(v map[string]string) { delete(v, "source_topic") },
+		"unknown": func(v map[string]string) { v["partitions"] = "3" },
+		"quote":   func(v map[string]string) { v["value"] = `paid" } node "x` },
+		"newline": func(v map[string]string) { v["value"] = "paid\n" },
+	} {
+		invalid := map[string]string{}
+		for k, v := range values {
+			invalid[k] = v
+		}
+		change(invalid)
+		_, err := tmpl.Instantiate(invalid, log)
+		require.ErrorIs(t, err, templates.ErrInvalidParameters, name)
+	}
+
+	_, err = templates.Builtin().Get("missing")
+	require.ErrorIs(t, err, templates.ErrNotFound)
+}
+
+func TestLoad(t *testing.T) {
+	library, err := templates.Load(fstest.MapFS{
+		"echo.yaml": {Data: []byte("name: Echo\nparameters:\n  - name: name\n    default: echo\nsource: |\n  flow \"{{ name }}\" { node \"n\" { type: \"Passthrough\" } }\n")},
+	})
+	require.NoError(t, err)
+	tmpl, err := library.Get("echo")
+	require.NoError(t, err)
+	require.Equal(t, "Echo", tmpl.Name)
+	require.Empty(t, tmpl.Tags)
+
+	// Placeholders must name declared parameters
+	_, err = templates.Load(fstest.MapFS{
+		"bad.yaml": {Data: []byte("name: Bad\nsource: 'flow \"{{name}}\" {}'\n")},
+	})
+	require.ErrorContains(t, err, "undeclared parameter name")
+}
@@ -0,0 +1,257 @@
+package testsupport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"flow-control/internal/types"
+	"flow-control/pkg/api"
+)
+
+// APIError is returned for responses with an error status
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("%s (%d)", e.Message, e.StatusCode)
+}
+
+// StatusCode returns the status of the response that caused err, or 0 if
+// err is not an *APIError
+func StatusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// Client calls the HTTP API of a server
+type Client struct {
+	// BaseURL is the URL of the server, without the /api prefix
+	BaseURL string
+	// APIKey is sent as a bearer token when it is not empty
+	APIKey string
+	// AdminToken is sent to the endpoints below /api/admin/ instead of the
+	// API key
+	AdminToken string
+	HTTP       *http.Client
+}
+
+// NewClient creates a client for the server at baseURL
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Do sends a request to path, such as /api/flows/, encoding in as the JSON
+// body when it is not nil and decoding the JSON response into out when it
+// is not nil. Error statuses are returned as an *APIError.
+func (c *Client) Do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	resp, err := c.Send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// Send sends a request to path with the credentials of the client and
+// returns the response whatever its status. The caller closes the body.
+func (c *Client) Send(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// The admin endpoints are not guarded by the API keys, and take their
+	// own token as the bearer token
+	token := c.APIKey
+	if strings.HasPrefix(path, "/api/admin/") {
+		token = c.AdminToken
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %s %s: %w", method, path, err)
+	}
+	return resp, nil
+}
+
+// ListFlows returns all flows
+func (c *Client) ListFlows(ctx context.Context) ([]*types.RuntimeFlow, error) {
+	var flows []*types.RuntimeFlow
+	err := c.Do(ctx, http.MethodGet, "/api/flows/", nil, &flows)
+	return flows, err
+}
+
+// GetFlow returns the flow with id
+func (c *Client) GetFlow(ctx context.Context, id string) (*types.RuntimeFlow, error) {
+	var flow types.RuntimeFlow
+	if err := c.Do(ctx, http.MethodGet, flowPath(id), nil, &flow); err != nil {
+		return nil, err
+	}
+	return &flow, nil
+}
+
+// CreateFlow creates flow and returns it as saved
+func (c *Client) CreateFlow(ctx context.Context, flow *types.RuntimeFlow) (*types.RuntimeFlow, error) {
+	var created types.RuntimeFlow
+	if err := c.Do(ctx, http.MethodPost, "/api/flows/", flow, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdateFlow replaces the flow with the ID of flow, which must carry the
+// revision it is based on, and returns it as saved
+func (c *Client) UpdateFlow(ctx context.Context, flow *types.RuntimeFlow) (*types.RuntimeFlow, error) {
+	var updated types.RuntimeFlow
+	if err := c.Do(ctx, http.MethodPut, flowPath(flow.ID), flow, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// DeleteFlow deletes the flow with id
+func (c *Client) DeleteFlow(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodDelete, flowPath(id), nil, nil)
+}
+
+// StartFlow starts the flow with id with the values of its parameters, if
+// any, and returns it with its new status
+func (c *Client) StartFlow(ctx context.Context, id string, params map[string]string) (*types.RuntimeFlow, error) {
+	var flow types.RuntimeFlow
+	if err := c.Do(ctx, http.MethodPost, flowPath(id, "start"), api.StartFlowRequest{Params: params}, &flow); err != nil {
+		return nil, err
+	}
+	return &flow, nil
+}
+
+// StopFlow stops the flow with id and returns it with its new status
+func (c *Client) StopFlow(ctx context.Context, id string) (*types.RuntimeFlow, error) {
+	var flow types.RuntimeFlow
+	if err := c.Do(ctx, http.MethodPost, flowPath(id, "stop"), nil, &flow); err != nil {
+		return nil, err
+	}
+	return &flow, nil
+}
+
+// Validate checks flow source without saving it
+func (c *Client) Validate(ctx context.Context, source string) (*api.ValidateResult, error) {
+	var result api.ValidateResult
+	err := c.Do(ctx, http.MethodPost, "/api/flows/validate", api.ValidateRequest{Source: source}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// DryRun runs flow source in the server's engine on sample messages
+func (c *Client) DryRun(ctx context.Context, req api.DryRunRequest) (*api.DryRunResult, error) {
+	var result api.DryRunResult
+	if err := c.Do(ctx, http.MethodPost, "/api/flows/run", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RunLogs lists the node logs of a run, narrowed by query, such as
+// "level=warn"
+func (c *Client) RunLogs(ctx context.Context, runID, query string) ([]*types.NodeLog, error) {
+	path := "/api/runs/" + url.PathEscape(runID) + "/logs"
+	if query != "" {
+		path += "?" + query
+	}
+	var logs []*types.NodeLog
+	if err := c.Do(ctx, http.MethodGet, path, nil, &logs); err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// RunTimeline lists the message hops of a run, narrowed by query, such as
+// "node=price"
+func (c *Client) RunTimeline(ctx context.Context, runID, query string) ([]*types.MessageHop, error) {
+	path := "/api/runs/" + url.PathEscape(runID) + "/timeline"
+	if query != "" {
+		path += "?" + query
+	}
+	var hops []*types.MessageHop
+	if err := c.Do(ctx, http.MethodGet, path, nil, &hops); err != nil {
+		return nil, err
+	}
+	return hops, nil
+}
+
+// StartBackfill starts a backfill of the flow with id
+func (c *Client) StartBackfill(ctx context.Context, id string, req api.BackfillRequest) (*api.Backfill, error) {
+	var run api.Backfill
+	if err := c.Do(ctx, http.MethodPost, flowPath(id, "backfills"), req, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// StartSimulation starts a simulation of the flow with id
+func (c *Client) StartSimulation(ctx context.Context, id string, req api.SimulationRequest) (*api.Backfill, error) {
+	var run api.Backfill
+	if err := c.Do(ctx, http.MethodPost, flowPath(id, "simulations"), req, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Backfill returns the backfill runID of the flow with id
+func (c *Client) Backfill(ctx context.Context, id, runID string) (*api.Backfill, error) {
+	var run api.Backfill
+	if err := c.Do(ctx, http.MethodGet, flowPath(id, "backfills", url.PathEscape(runID)), nil, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// flowPath returns the API path of a flow, escaping its ID
+func flowPath(id string, parts ...string) string {
+	return "/api/flows/" + strings.Join(append([]string{url.PathEscape(id)}, parts...), "/")
+}
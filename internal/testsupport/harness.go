@@ -0,0 +1,190 @@
+/*
+Package testsupport runs a complete Flow Control server for integration
+tests. New wires the store, schema registry, metrics, event bus, alerting
+and documentation server the way flowcontrol does, serves them on a random
+local port with a temporary database, and tears everything down when the
+test ends:
+
+	h := testsupport.New(t, testsupport.WithAPIKeys("key"))
+	flow, err := h.Client.CreateFlow(ctx, &types.RuntimeFlow{ID: "orders", Name: "Orders", Config: "{}"})
+*/
+package testsupport
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"flow-control/internal/alerting"
+	"flow-control/internal/docserver"
+	"flow-control/internal/events"
+	"flow-control/internal/logger"
+	"flow-control/internal/metrics"
+	"flow-control/internal/runtime/schema"
+	"flow-control/internal/server"
+	"flow-control/internal/store"
+	"flow-control/internal/types"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Harness is a running server and the components behind it, which tests
+// can use to set up state or check what requests did
+type Harness struct {
+	// URL is the base URL of the server, such as http://127.0.0.1:41234
+	URL string
+	// Dir is a temporary directory for the test, holding the database and
+	// the log file
+	Dir string
+	// DataDir is the data directory of the server, below Dir, holding the
+	// files backfills and Replay nodes read
+	DataDir string
+	// Client calls the server with the API key and admin token of the
+	// harness
+	Client *Client
+
+	Log     *logger.Logger
+	Store   *store.SQLiteStore
+	Bus     events.Bus
+	Schemas *schema.SchemaRegistry
+	Metrics *metrics.Registry
+	Alerts  *alerting.Evaluator
+	Server  *server.Server
+	Docs    *docserver.Server
+
+	http *httptest.Server
+}
+
+// options configures a Harness
+type options struct {
+	apiKeys    []string
+	adminToken string
+	flows      []*types.RuntimeFlow
+	docs       []docserver.Option
+	server     []server.Option
+}
+
+// Option configures a Harness
+type Option func(*options)
+
+// WithAPIKeys requires one of keys on API requests. The client sends the
+// first one.
+func WithAPIKeys(keys ...string) Option {
+	return func(o *options) {
+		o.apiKeys = append(o.apiKeys, keys...)
+	}
+}
+
+// WithAdminToken guards the admin diagnostics endpoints with token, which
+// the client sends
+func WithAdminToken(token string) Option {
+	return func(o *options) {
+		o.adminToken = token
+	}
+}
+
+// WithFlows saves flows in the store before the server starts
+func WithFlows(flows ...*types.RuntimeFlow) Option {
+	return func(o *options) {
+		o.flows = append(o.flows, flows...)
+	}
+}
+
+// WithDocs configures the documentation server, such as its root and
+// template directories. The root directory defaults to the temporary
+// directory of the harness.
+func WithDocs(opts ...docserver.Option) Option {
+	return func(o *options) {
+		o.docs = append(o.docs, opts...)
+	}
+}
+
+// WithServerOptions applies opts to the API server after the options of
+// the harness, such as to set limits or replace the event bus
+func WithServerOptions(opts ...server.Option) Option {
+	return func(o *options) {
+		o.server = append(o.server, opts...)
+	}
+}
+
+// New starts a server for t and stops it, removing its files, when t ends.
+// It fails t if the server cannot start.
+func New(t testing.TB, opts ...Option) *Harness {
+	t.Helper()
+
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	dir := t.TempDir()
+	h := &Harness{
+		Dir:     dir,
+		DataDir: filepath.Join(dir, "data"),
+		Log:     logger.New(logger.WithFile(filepath.Join(dir, "flowcontrol.log"))),
+		Bus:     events.NewMemoryBus(0),
+		Metrics: metrics.NewRegistry(),
+	}
+
+	require.NoError(t, os.Mkdir(h.DataDir, 0o755))
+
+	storeOpts := store.DefaultOptions()
+	storeOpts.Metrics = h.Metrics
+	var err error
+	h.Store, err = store.New(filepath.Join(dir, "flows.db"), h.Log, storeOpts)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = h.Store.Close() })
+	for _, flow := range o.flows {
+		require.NoError(t, h.Store.CreateFlow(flow))
+	}
+
+	h.Schemas, err = schema.NewPersistentRegistry(h.Store)
+	require.NoError(t, err)
+	process := metrics.NewProcessCollector(h.Store)
+	require.NoError(t, h.Metrics.Register(process))
+	events.RecordMetrics(h.Bus, h.Metrics)
+	h.Alerts = alerting.New(h.Store, h.Metrics, h.Log, alerting.Options{
+		Notifiers: []alerting.Notifier{alerting.NewBusNotifier(h.Bus)},
+	})
+
+	serverOpts := []server.Option{
+		server.WithSchemaRegistry(h.Schemas),
+		server.WithMetrics(h.Metrics, process),
+		server.WithEventBus(h.Bus),
+		server.WithAlerting(h.Alerts),
+		server.WithAdminToken(o.adminToken),
+		server.WithAPIKeys(o.apiKeys...),
+		server.WithDataDir(h.DataDir),
+	}
+	h.Server = server.New(h.Store, h.Log, append(serverOpts, o.server...)...)
+
+	h.Docs, err = docserver.New(h.Log, append([]docserver.Option{docserver.WithRootDir(dir)}, o.docs...)...)
+	require.NoError(t, err)
+	h.Server.Mount("/", h.Docs.Routes())
+
+	h.http = httptest.NewServer(h.Server)
+	h.URL = h.http.URL
+	// Closing the bus and the log streams ends open event and log streams,
+	// which would otherwise hold up closing the server
+	t.Cleanup(func() {
+		_ = h.Bus.Close()
+		h.Log.CloseStreams()
+		h.http.Close()
+	})
+
+	h.Client = NewClient(h.URL)
+	if len(o.apiKeys) > 0 {
+		h.Client.APIKey = o.apiKeys[0]
+	}
+	h.Client.AdminToken = o.adminToken
+	return h
+}
+
+// Logs returns the entries the server logged that contain text
+func (h *Harness) Logs(t testing.TB, text string) []logger.LogEntry {
+	t.Helper()
+	entries, err := h.Log.ReadLogs(&logger.LogQuery{Contains: text})
+	require.NoError(t, err)
+	return entries
+}
@@ -0,0 +1,71 @@
+package testsupport_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"flow-control/internal/testsupport"
+	"flow-control/internal/types"
+	"flow-control/pkg/api"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHarness(t *testing.T) {
+	ctx := context.Background()
+	h := testsupport.New(t,
+		testsupport.WithAPIKeys("key"),
+		testsupport.WithAdminToken("admin"),
+		testsupport.WithFlows(&types.RuntimeFlow{ID: "seeded", Name: "Seeded", Config: "{}"}),
+	)
+
+	// Flows saved before the server started are served
+	flows, err := h.Client.ListFlows(ctx)
+	require.NoError(t, err)
+	require.Len(t, flows, 1)
+	require.Equal(t, "seeded", flows[0].ID)
+
+	created, err := h.Client.CreateFlow(ctx, &types.RuntimeFlow{ID: "orders", Name: "Orders", Config: "{}"})
+	require.NoError(t, err)
+	require.Equal(t, "orders", created.ID)
+	started, err := h.Client.StartFlow(ctx, "orders", nil)
+	require.NoError(t, err)
+	require.Equal(t, "running", started.Status)
+	stored, err := h.Store.GetFlow("orders")
+	require.NoError(t, err)
+	require.Equal(t, "running", stored.Status)
+
+	_, err = h.Client.GetFlow(ctx, "missing")
+	require.Equal(t, http.StatusNotFound, testsupport.StatusCode(err))
+
+	// Requests without the API key are rejected
+	anonymous := testsupport.NewClient(h.URL)
+	_, err = anonymous.ListFlows(ctx)
+	require.Equal(t, http.StatusUnauthorized, testsupport.StatusCode(err))
+
+	// Flows run in the server's engine
+	result, err := h.Client.DryRun(ctx, api.DryRunRequest{
+		Source:   `flow "orders" { node "paid" { type: "Filter" field: "status" equals: "paid" } }`,
+		Messages: []json.RawMessage{json.RawMessage(`{"status":"paid"}`), json.RawMessage(`{"status":"open"}`)},
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Outputs, 1)
+	validation, err := h.Client.Validate(ctx, `flow "orders" {`)
+	require.NoError(t, err)
+	require.False(t, validation.Valid)
+
+	// The admin endpoints take the admin token, and the documentation
+	// server is mounted
+	for _, path := range []string{"/api/admin/goroutines", "/docs/language"} {
+		resp, err := h.Client.Send(ctx, http.MethodGet, path, nil)
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode, path)
+	}
+
+	require.NotEmpty(t, h.Logs(t, "Rejected"))
+}
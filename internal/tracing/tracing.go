@@ -0,0 +1,380 @@
+/*
+Package tracing implements types.TracePort on OpenTelemetry. Spans are
+exported over OTLP/HTTP, so flow execution shows up in Jaeger, Tempo or any
+other OTLP backend, and trace context travels between nodes in HTTP headers
+and message headers using the W3C traceparent and baggage formats.
+*/
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"flow-control/internal/logger"
+	"flow-control/internal/types"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// instrumentationName names the tracer that creates Flow Control spans
+const instrumentationName = "flow-control"
+
+// Attribute keys of node spans
+const (
+	AttrFlowID   = "flow.id"
+	AttrNodeID   = "node.id"
+	AttrNodeType = "node.type"
+)
+
+// Config configures the OTLP exporter
+type Config struct {
+	// Enabled turns on span export. Disabled tracers hand out non-recording
+	// spans but still propagate context.
+	Enabled bool
+	// ServiceName is reported as the service.name resource attribute
+	ServiceName string
+	// Endpoint is the host:port of the OTLP/HTTP collector
+	Endpoint string
+	// Insecure sends spans over plain HTTP instead of HTTPS
+	Insecure bool
+	// SampleRatio is the fraction of new traces recorded; child spans follow
+	// their parent's decision
+	SampleRatio float64
+	// Headers are added to every export request, e.g. for authentication
+	Headers map[string]string
+}
+
+// Tracer implements types.TracePort
+type Tracer struct {
+	provider   *sdktrace.TracerProvider
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// New creates a tracer exporting spans as configured. Call Shutdown to
+// flush buffered spans before exiting.
+func New(ctx context.Context, cfg Config) (*Tracer, error) {
+	if !cfg.Enabled {
+		return NewWithProvider(noop.NewTracerProvider()), nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	tracer := NewWithProvider(provider)
+	tracer.provider = provider
+	return tracer, nil
+}
+
+// NewWithProvider creates a tracer on an existing provider, such as one
+// with an in-memory exporter in tests. The caller owns the provider's
+// lifecycle.
+func NewWithProvider(provider trace.TracerProvider) *Tracer {
+	return &Tracer{
+		tracer: provider.Tracer(instrumentationName),
+		propagator: propagation.NewCompositeTextMapPropagator(
+			propagation.TraceContext{},
+			propagation.Baggage{},
+		),
+	}
+}
+
+// Shutdown flushes buffered spans and stops the exporter. It is a no-op for
+// disabled tracers and tracers created with NewWithProvider.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.provider == nil {
+		return nil
+	}
+	if err := t.provider.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to shut down tracer provider: %w", err)
+	}
+	return nil
+}
+
+// StartSpan implements TracePort.StartSpan. The span is a child of the span
+// in the WithParent context, if any.
+func (t *Tracer) StartSpan(name string, opts ...types.SpanOption) (types.Span, context.Context) {
+	var config types.SpanConfig
+	for _, opt := range opts {
+		opt.Apply(&config)
+	}
+
+	parent := config.Parent
+	if parent == nil {
+		parent = context.Background()
+	}
+	startOpts := []trace.SpanStartOption{trace.WithAttributes(toAttributes(config.Attributes)...)}
+	if !config.StartTime.IsZero() {
+		startOpts = append(startOpts, trace.WithTimestamp(config.StartTime))
+	}
+
+	ctx, span := t.tracer.Start(parent, name, startOpts...)
+	return &otelSpan{span: span, ctx: ctx}, ctx
+}
+
+// StartNodeSpan starts a span for one execution of a flow node. The
+// returned context also carries the flow and node IDs as log correlation
+// fields, so entries logged while the node runs can be traced back to it.
+func (t *Tracer) StartNodeSpan(ctx context.Context, flowID, nodeID, nodeType string) (types.Span, context.Context) {
+	ctx = logger.ContextWithFields(ctx, types.Fields{
+		logger.FieldFlowID: flowID,
+		logger.FieldNodeID: nodeID,
+	})
+	return t.StartSpan("node "+nodeID, WithParent(ctx), WithAttributes(map[string]interface{}{
+		AttrFlowID:   flowID,
+		AttrNodeID:   nodeID,
+		AttrNodeType: nodeType,
+	}))
+}
+
+// InjectSpan implements TracePort.InjectSpan. The carrier is an
+// http.Header, a *types.Message, a map[string]string or a
+// propagation.TextMapCarrier.
+func (t *Tracer) InjectSpan(ctx context.Context, carrier interface{}) error {
+	c, err := textMapCarrier(carrier)
+	if err != nil {
+		return err
+	}
+	t.propagator.Inject(ctx, c)
+	return nil
+}
+
+// ExtractSpan implements TracePort.ExtractSpan, returning ctx with the
+// remote span context and baggage found in the carrier
+func (t *Tracer) ExtractSpan(ctx context.Context, carrier interface{}) (context.Context, error) {
+	c, err := textMapCarrier(carrier)
+	if err != nil {
+		return ctx, err
+	}
+	return t.propagator.Extract(ctx, c), nil
+}
+
+// GetBaggage implements TracePort.GetBaggage
+func (t *Tracer) GetBaggage(ctx context.Context) map[string]string {
+	members := baggage.FromContext(ctx).Members()
+	result := make(map[string]string, len(members))
+	for _, member := range members {
+		result[member.Key()] = member.Value()
+	}
+	return result
+}
+
+// SetBaggage implements TracePort.SetBaggage. Keys that are not valid
+// baggage keys leave ctx unchanged.
+func (t *Tracer) SetBaggage(ctx context.Context, key, value string) context.Context {
+	member, err := baggage.NewMemberRaw(key, value)
+	if err != nil {
+		return ctx
+	}
+	bag, err := baggage.FromContext(ctx).SetMember(member)
+	if err != nil {
+		return ctx
+	}
+	return baggage.ContextWithBaggage(ctx, bag)
+}
+
+// Middleware starts a server span for each HTTP request, continuing any
+// trace propagated in the request headers
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := t.tracer.Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code before writing it
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush supports streaming responses such as server-sent events
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// otelSpan implements types.Span on an OpenTelemetry span
+type otelSpan struct {
+	span trace.Span
+	ctx  context.Context
+}
+
+// Context implements Span.Context
+func (s *otelSpan) Context() context.Context {
+	return s.ctx
+}
+
+// SetName implements Span.SetName
+func (s *otelSpan) SetName(name string) {
+	s.span.SetName(name)
+}
+
+// SetAttributes implements Span.SetAttributes
+func (s *otelSpan) SetAttributes(attrs map[string]interface{}) {
+	s.span.SetAttributes(toAttributes(attrs)...)
+}
+
+// AddEvent implements Span.AddEvent
+func (s *otelSpan) AddEvent(name string, attrs map[string]interface{}) {
+	s.span.AddEvent(name, trace.WithAttributes(toAttributes(attrs)...))
+}
+
+// RecordError implements Span.RecordError, also marking the span failed
+func (s *otelSpan) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End implements Span.End
+func (s *otelSpan) End() {
+	s.span.End()
+}
+
+// IsRecording implements Span.IsRecording
+func (s *otelSpan) IsRecording() bool {
+	return s.span.IsRecording()
+}
+
+// spanOption implements types.SpanOption with a function
+type spanOption func(*types.SpanConfig)
+
+// Apply implements SpanOption.Apply
+func (o spanOption) Apply(config *types.SpanConfig) {
+	o(config)
+}
+
+// WithParent starts the span as a child of the span in ctx
+func WithParent(ctx context.Context) types.SpanOption {
+	return spanOption(func(config *types.SpanConfig) {
+		config.Parent = ctx
+	})
+}
+
+// WithAttributes sets attributes on the span when it starts
+func WithAttributes(attrs map[string]interface{}) types.SpanOption {
+	return spanOption(func(config *types.SpanConfig) {
+		if config.Attributes == nil {
+			config.Attributes = make(map[string]interface{}, len(attrs))
+		}
+		for k, v := range attrs {
+			config.Attributes[k] = v
+		}
+	})
+}
+
+// WithStartTime backdates the start of the span
+func WithStartTime(start time.Time) types.SpanOption {
+	return spanOption(func(config *types.SpanConfig) {
+		config.StartTime = start
+	})
+}
+
+// textMapCarrier adapts a supported carrier for the propagator
+func textMapCarrier(carrier interface{}) (propagation.TextMapCarrier, error) {
+	switch c := carrier.(type) {
+	case http.Header:
+		return propagation.HeaderCarrier(c), nil
+	case *types.Message:
+		if c == nil {
+			return nil, fmt.Errorf("nil message carrier")
+		}
+		if c.Metadata.Headers == nil {
+			c.Metadata.Headers = make(map[string]string)
+		}
+		return propagation.MapCarrier(c.Metadata.Headers), nil
+	case map[string]string:
+		if c == nil {
+			return nil, fmt.Errorf("nil map carrier")
+		}
+		return propagation.MapCarrier(c), nil
+	case propagation.TextMapCarrier:
+		return c, nil
+	default:
+		return nil, fmt.Errorf("unsupported trace carrier %T", carrier)
+	}
+}
+
+// toAttributes converts attribute values to OpenTelemetry attributes. Types
+// without a native attribute type are formatted as strings.
+func toAttributes(attrs map[string]interface{}) []attribute.KeyValue {
+	result := make([]attribute.KeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		switch value := v.(type) {
+		case string:
+			result = append(result, attribute.String(k, value))
+		case bool:
+			result = append(result, attribute.Bool(k, value))
+		case int:
+			result = append(result, attribute.Int(k, value))
+		case int64:
+			result = append(result, attribute.Int64(k, value))
+		case float64:
+			result = append(result, attribute.Float64(k, value))
+		case []string:
+			result = append(result, attribute.StringSlice(k, value))
+		case fmt.Stringer:
+			result = append(result, attribute.String(k, value.String()))
+		default:
+			result = append(result, attribute.String(k, fmt.Sprint(value)))
+		}
+	}
+	return result
+}
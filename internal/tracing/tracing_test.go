@@ -0,0 +1,97 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"flow-control/internal/logger"
+	"flow-control/internal/tracing"
+	"flow-control/internal/types"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := tracing.NewWithProvider(provider)
+	var _ types.TracePort = tracer
+
+	t.Run("node spans", func(t *testing.T) {
+		flowSpan, ctx := tracer.StartSpan("flow orders")
+		nodeSpan, nodeCtx := tracer.StartNodeSpan(ctx, "orders", "validate", "transform")
+		require.Equal(t, types.Fields{
+			logger.FieldFlowID: "orders",
+			logger.FieldNodeID: "validate",
+		}, logger.FieldsFromContext(nodeCtx))
+		require.True(t, nodeSpan.IsRecording())
+		nodeSpan.AddEvent("message", map[string]interface{}{"bytes": 42})
+		nodeSpan.RecordError(errors.New("bad order"))
+		nodeSpan.End()
+		flowSpan.End()
+
+		spans := recorder.Ended()
+		node := spans[len(spans)-2]
+		flow := spans[len(spans)-1]
+		require.Equal(t, "node validate", node.Name())
+		require.Equal(t, flow.SpanContext().SpanID(), node.Parent().SpanID())
+		require.Contains(t, node.Attributes(), attribute.String(tracing.AttrFlowID, "orders"))
+		require.Contains(t, node.Attributes(), attribute.String(tracing.AttrNodeType, "transform"))
+		require.Equal(t, codes.Error, node.Status().Code)
+		require.Len(t, node.Events(), 2)
+	})
+
+	t.Run("message propagation", func(t *testing.T) {
+		span, ctx := tracer.StartSpan("producer")
+		ctx = tracer.SetBaggage(ctx, "tenant", "acme")
+		msg := &types.Message{ID: "m1"}
+		require.NoError(t, tracer.InjectSpan(ctx, msg))
+		span.End()
+		require.Contains(t, msg.Metadata.Headers, "traceparent")
+
+		remote, err := tracer.ExtractSpan(context.Background(), msg)
+		require.NoError(t, err)
+		require.Equal(t, trace.SpanContextFromContext(ctx).TraceID(), trace.SpanContextFromContext(remote).TraceID())
+		require.Equal(t, map[string]string{"tenant": "acme"}, tracer.GetBaggage(remote))
+	})
+
+	t.Run("unsupported carrier", func(t *testing.T) {
+		require.Error(t, tracer.InjectSpan(context.Background(), 42))
+	})
+
+	t.Run("http middleware", func(t *testing.T) {
+		parent, ctx := tracer.StartSpan("client")
+		req := httptest.NewRequest(http.MethodGet, "/api/flows/", nil)
+		require.NoError(t, tracer.InjectSpan(ctx, req.Header))
+		parent.End()
+
+		handler := tracer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, trace.SpanContextFromContext(ctx).TraceID(), trace.SpanContextFromContext(r.Context()).TraceID())
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		spans := recorder.Ended()
+		server := spans[len(spans)-1]
+		require.Equal(t, "GET /api/flows/", server.Name())
+		require.Equal(t, trace.SpanKindServer, server.SpanKind())
+		require.Equal(t, codes.Error, server.Status().Code)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		disabled, err := tracing.New(context.Background(), tracing.Config{})
+		require.NoError(t, err)
+		span, _ := disabled.StartSpan("ignored")
+		require.False(t, span.IsRecording())
+		span.End()
+		require.NoError(t, disabled.Shutdown(context.Background()))
+	})
+}
@@ -20,3 +20,15 @@ type MessageMetadata struct {
 	Target    string            `json:"target"`
 	Headers   map[string]string `json:"headers,omitempty"`
 }
+
+// MessageSnapshot is a copy of a message at one hop of its way through a
+// flow
+type MessageSnapshot struct {
+	ID      string            `json:"id"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Data is the payload, left out when it is larger than the snapshots
+	// keep
+	Data json.RawMessage `json:"data,omitempty"`
+	// Size is the size of the payload in bytes
+	Size int `json:"size"`
+}
@@ -151,10 +151,11 @@ type ObservabilityConfig struct {
 	MetricsEnabled bool              `json:"metrics_enabled"`
 	MetricsTags    map[string]string `json:"metrics_tags"`
 
-	// Logging configuration
-	LogLevel  LogLevel          `json:"log_level"`
-	LogFormat string            `json:"log_format"`
-	LogFields map[string]string `json:"log_fields"`
+	// Logging configuration. LogFields are added to every entry the node
+	// logs.
+	LogLevel  LogLevel `json:"log_level"`
+	LogFormat string   `json:"log_format"`
+	LogFields Fields   `json:"log_fields"`
 
 	// Tracing configuration
 	TracingEnabled bool              `json:"tracing_enabled"`
@@ -44,6 +44,10 @@ type PortConfig struct {
 	DataType   Schema           `json:"data_type"`
 	BufferSize int              `json:"buffer_size"`
 	QoS        QualityOfService `json:"qos"`
+
+	// Codec is the wire format messages are serialized to at sinks and
+	// deserialized from at sources: json (the default), avro or protobuf
+	Codec string `json:"codec,omitempty"`
 }
 
 // PortDirection represents the direction of a port
@@ -53,26 +53,31 @@ type NetworkConfig struct {
 // ResourceMetrics provides resource usage metrics
 type ResourceMetrics struct {
 	// CPU usage
-	CPUUsage float64 // Percentage
+	CPUUsage float64 `json:"cpu_usage"` // Percentage
 
 	// Memory usage
-	MemoryUsage   int64 // Bytes
-	MemoryRSS     int64 // Resident Set Size
-	MemoryHeap    int64 // Heap size
-	MemoryGCPause time.Duration
+	MemoryUsage   int64         `json:"memory_usage"` // Bytes
+	MemoryRSS     int64         `json:"memory_rss"`   // Resident Set Size
+	MemoryHeap    int64         `json:"memory_heap"`  // Heap size
+	MemoryGCPause time.Duration `json:"memory_gc_pause"`
 
 	// Storage usage
-	StorageUsage int64 // Bytes
-	IORead       int64 // Bytes per second
-	IOWrite      int64 // Bytes per second
+	StorageUsage int64 `json:"storage_usage"` // Bytes
+	IORead       int64 `json:"io_read"`       // Bytes per second
+	IOWrite      int64 `json:"io_write"`      // Bytes per second
 
 	// Network usage
-	NetworkIngress int64 // Bytes per second
-	NetworkEgress  int64 // Bytes per second
+	NetworkIngress int64 `json:"network_ingress"` // Bytes per second
+	NetworkEgress  int64 `json:"network_egress"`  // Bytes per second
+
+	// Concurrency, reported for the server process
+	Goroutines    int `json:"goroutines,omitempty"`
+	DBConnections int `json:"db_connections,omitempty"` // Open connections
+	DBInUse       int `json:"db_in_use,omitempty"`      // Connections in use
 
 	// Time metrics
-	Uptime        time.Duration
-	LastHeartbeat time.Time
+	Uptime        time.Duration `json:"uptime"`
+	LastHeartbeat time.Time     `json:"last_heartbeat"`
 }
 
 // ResourceStatus represents the current state of node resources
@@ -45,9 +45,21 @@ type RuntimeFlow struct {
 	// Config contains the flow's configuration in JSON format
 	Config string `json:"config"`
 
-	// Status represents the current state of the flow
+	// Status represents the current state of the flow, one of the
+	// FlowStatus constants
 	Status string `json:"status"`
 
+	// Revision is incremented on every write to the flow. Updates must carry
+	// the revision they were based on so concurrent edits are detected.
+	Revision int `json:"revision"`
+
+	// Tags are labels used to organize and filter flows
+	Tags []string `json:"tags,omitempty"`
+
+	// Params are the values of the flow's parameters it was last started
+	// with, as given; parameters left out take their defaults
+	Params map[string]string `json:"params,omitempty"`
+
 	// CreatedAt is the timestamp when the flow was created
 	CreatedAt time.Time `json:"created_at"`
 
@@ -55,6 +67,198 @@ type RuntimeFlow struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// Statuses of a RuntimeFlow
+const (
+	// FlowStatusRunning indicates the flow was started, or is running a
+	// backfill
+	FlowStatusRunning = "running"
+	// FlowStatusStopped indicates the flow was stopped
+	FlowStatusStopped = "stopped"
+	// FlowStatusCompleted indicates the flow's last backfill went through
+	// all of its input
+	FlowStatusCompleted = "completed"
+	// FlowStatusFailed indicates the flow's last backfill could not read
+	// all of its input, or that its engine could not start
+	FlowStatusFailed = "failed"
+)
+
+// FlowStep represents a single node of a flow, stored independently of the
+// flow's config so nodes can be queried directly
+type FlowStep struct {
+	// FlowID identifies the flow the step belongs to
+	FlowID string `json:"flow_id"`
+
+	// ID is the step's name, unique within its flow
+	ID string `json:"id"`
+
+	// Position is the 1-based order of the step within its flow
+	Position int `json:"position"`
+
+	// Type is the node type executed by the step
+	Type string `json:"type"`
+
+	// Config contains the node's settings
+	Config map[string]interface{} `json:"config,omitempty"`
+
+	// CreatedAt is the timestamp when the step was created
+	CreatedAt time.Time `json:"created_at"`
+
+	// UpdatedAt is the timestamp when the step was last updated
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FlowVersion is a snapshot of a flow's config taken each time the flow is saved
+type FlowVersion struct {
+	// FlowID identifies the flow the snapshot belongs to
+	FlowID string `json:"flow_id"`
+
+	// Version is the snapshot number, starting at 1 for each flow
+	Version int `json:"version"`
+
+	// Config is the flow's config at the time of the snapshot
+	Config string `json:"config"`
+
+	// Metadata records the flow's name, description, version and status
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+
+	// CreatedAt is the timestamp when the snapshot was taken
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditRecord describes a change made to a stored entity
+type AuditRecord struct {
+	// ID uniquely identifies the record
+	ID int64 `json:"id"`
+
+	// EntityType is the kind of entity that changed, e.g. "flow"
+	EntityType string `json:"entity_type"`
+
+	// EntityID identifies the entity that changed
+	EntityID string `json:"entity_id"`
+
+	// Action is what happened to the entity, e.g. "create"
+	Action string `json:"action"`
+
+	// Details contains action-specific data
+	Details map[string]interface{} `json:"details,omitempty"`
+
+	// CreatedAt is the timestamp when the change was made
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RunArtifact describes a payload captured while a flow ran, such as an
+// input/output sample, a dead-lettered message or a replay bundle
+type RunArtifact struct {
+	// ID uniquely identifies the artifact
+	ID string `json:"id"`
+
+	// FlowID identifies the flow that produced the artifact
+	FlowID string `json:"flow_id"`
+
+	// RunID identifies the run that produced the artifact
+	RunID string `json:"run_id,omitempty"`
+
+	// Kind categorizes the artifact, e.g. "sample", "dlq" or "replay"
+	Kind string `json:"kind"`
+
+	// Name is a human-readable label for the artifact
+	Name string `json:"name,omitempty"`
+
+	// ContentType is the MIME type of the payload
+	ContentType string `json:"content_type,omitempty"`
+
+	// Size is the payload size in bytes
+	Size int64 `json:"size"`
+
+	// SHA256 is the hex-encoded checksum of the payload
+	SHA256 string `json:"sha256"`
+
+	// Encoding is the codec the payload is stored compressed with, empty
+	// when it is stored as it is
+	Encoding string `json:"encoding,omitempty"`
+
+	// StoredSize is the size in bytes the payload takes up in storage
+	StoredSize int64 `json:"stored_size"`
+
+	// CreatedAt is the timestamp when the artifact was stored
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SchemaRecord is the stored form of a custom schema registered at runtime
+type SchemaRecord struct {
+	// Type is the schema's type name
+	Type string `json:"type"`
+
+	// Version is the schema's version
+	Version string `json:"version"`
+
+	// Definition is the schema's JSON definition
+	Definition string `json:"definition"`
+
+	// CreatedAt is the timestamp when the schema was registered
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Alert rule conditions
+const (
+	// AlertErrorRate fires when the fraction of failed messages in the
+	// window exceeds the threshold
+	AlertErrorRate = "error_rate"
+
+	// AlertNoMessages fires when a flow processes no messages for the window
+	AlertNoMessages = "no_messages"
+
+	// AlertLatencyP99 fires when the 99th percentile latency in the window
+	// exceeds the threshold, in seconds
+	AlertLatencyP99 = "latency_p99"
+)
+
+// AlertRule is a condition on a flow's metrics that raises an alert
+type AlertRule struct {
+	// ID uniquely identifies the rule
+	ID string `json:"id"`
+
+	// FlowID identifies the flow the rule watches
+	FlowID string `json:"flow_id"`
+
+	// Name is a human-readable label for the rule
+	Name string `json:"name,omitempty"`
+
+	// Condition is one of the Alert* conditions
+	Condition string `json:"condition"`
+
+	// Threshold is the value the condition compares against
+	Threshold float64 `json:"threshold"`
+
+	// WindowSeconds is the period the condition is evaluated over
+	WindowSeconds int `json:"window_seconds"`
+
+	// Enabled controls whether the rule is evaluated
+	Enabled bool `json:"enabled"`
+
+	// CreatedAt is the timestamp when the rule was created
+	CreatedAt time.Time `json:"created_at"`
+
+	// UpdatedAt is the timestamp when the rule was last changed
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Lease grants one server instance a role, such as running the schedulers
+// of a cluster, until it expires
+type Lease struct {
+	// Name identifies the role the lease grants
+	Name string `json:"name"`
+
+	// Holder identifies the instance holding the lease
+	Holder string `json:"holder"`
+
+	// AcquiredAt is the timestamp when the holder acquired the lease
+	AcquiredAt time.Time `json:"acquired_at"`
+
+	// ExpiresAt is the timestamp when the lease lapses unless renewed
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
 // FlowEvent represents a real-time event from a flow
 type FlowEvent struct {
 	// FlowID identifies the flow that generated the event
@@ -76,6 +280,65 @@ type FlowEvent struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// NodeLog is an entry a node logged, captured for the run it logged during
+type NodeLog struct {
+	// FlowID and NodeID identify the node that logged the entry
+	FlowID string `json:"flow_id"`
+	NodeID string `json:"node_id"`
+
+	// RunID identifies the run the node logged during
+	RunID string `json:"run_id"`
+
+	// Level is the level of the entry
+	Level LogLevel `json:"level"`
+
+	// Message is the logged message
+	Message string `json:"message"`
+
+	// Fields are the fields logged with the message
+	Fields Fields `json:"fields,omitempty"`
+
+	// Error is the error logged with error entries
+	Error string `json:"error,omitempty"`
+
+	// Timestamp indicates when the entry was logged
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// MessageHop is a message going through a node, recorded for the timeline
+// of the run it went through during
+type MessageHop struct {
+	// FlowID and NodeID identify the node the message went through
+	FlowID string `json:"flow_id"`
+	NodeID string `json:"node_id"`
+
+	// RunID identifies the run the message went through during
+	RunID string `json:"run_id"`
+
+	// Source is the node the message came from, or the source of the
+	// message for entry nodes
+	Source string `json:"source,omitempty"`
+
+	// Input is what the node saw
+	Input MessageSnapshot `json:"input"`
+
+	// Outputs are what the node produced, sent on Port, or on its default
+	// output when Port is empty
+	Outputs []MessageSnapshot `json:"outputs,omitempty"`
+	Port    string            `json:"port,omitempty"`
+
+	// Event is the type of the event reported for the hop, such as
+	// node.processed or node.failed
+	Event string `json:"event"`
+
+	// Error is the error of the node when it failed
+	Error string `json:"error,omitempty"`
+
+	// StartedAt and FinishedAt indicate when the node processed the message
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
 // FlowMetrics represents metrics collected during flow execution
 type FlowMetrics struct {
 	// FlowID identifies the flow being measured
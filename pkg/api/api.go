@@ -0,0 +1,88 @@
+/*
+Package api holds the request and response bodies of the Flow Control HTTP
+API. The server and the Go SDK in pkg/client share them, so that clients
+get the wire types without pulling in the server and its dependencies.
+*/
+package api
+
+import (
+	"encoding/json"
+
+	"flow-control/internal/types"
+)
+
+// StartFlowRequest holds the parameter values a flow is started with
+type StartFlowRequest struct {
+	// Params map the parameters the flow declares to their values;
+	// parameters left out take their defaults
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// ValidateRequest holds the flow source checked by the validate endpoint
+type ValidateRequest struct {
+	Source string `json:"source"`
+	// ID, when set, is the ID the source is saved as, to check that its
+	// Call nodes do not make stored flows call each other in a loop
+	ID string `json:"id,omitempty"`
+}
+
+// ValidateResult lists the problems found in flow source
+type ValidateResult struct {
+	// Valid is false when any problem is an error
+	Valid       bool               `json:"valid"`
+	Diagnostics []SourceDiagnostic `json:"diagnostics"`
+}
+
+// SourceDiagnostic is a problem found in flow source
+type SourceDiagnostic struct {
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// DryRunRequest holds the flow source and sample messages of a dry run
+type DryRunRequest struct {
+	Source string `json:"source"`
+	// Flow selects the flow to run when the source defines several
+	Flow string `json:"flow,omitempty"`
+	// Messages are the message payloads fed to the flow, in order
+	Messages []json.RawMessage `json:"messages"`
+	// Stub replaces nodes of unknown types with passthrough nodes
+	Stub bool `json:"stub,omitempty"`
+	// Params map the parameters the flow declares to their values;
+	// parameters left out take their defaults
+	Params map[string]string `json:"params,omitempty"`
+	// Faults, when set, are injected into the run instead of the faults
+	// configured for the flow. Only servers with fault injection enabled
+	// accept them.
+	Faults *Faults `json:"faults,omitempty"`
+	// Timeline records every message going through a node, served by
+	// /runs/{run}/timeline
+	Timeline bool `json:"timeline,omitempty"`
+	// Simulate runs the Generate and Replay nodes of the flow in place of
+	// the messages, until every one of them sent its messages
+	Simulate bool `json:"simulate,omitempty"`
+}
+
+// DryRunResult holds what a dry run produced
+type DryRunResult struct {
+	// RunID identifies the run, whose node logs are also served by
+	// /runs/{run}/logs, and its timeline, when recorded, by
+	// /runs/{run}/timeline
+	RunID string `json:"run_id"`
+	// Outputs are the messages that left the flow
+	Outputs []DryRunOutput    `json:"outputs"`
+	Events  []types.FlowEvent `json:"events"`
+	// Logs are the entries the nodes logged
+	Logs []types.NodeLog `json:"logs"`
+	// Failed is set when a node failed on any message
+	Failed bool `json:"failed"`
+}
+
+// DryRunOutput is a message leaving the flow during a dry run
+type DryRunOutput struct {
+	Node string          `json:"node"`
+	ID   string          `json:"id"`
+	Data json.RawMessage `json:"data"`
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"errors"
+	"path/filepath"
+	"time"
+)
+
+// BackfillQueued is the status of backfills waiting for the runs of their
+// flow to end, as their flow's concurrency setting says
+const BackfillQueued = "queued"
+
+// BackfillRequest holds the input and parameter values of a backfill
+type BackfillRequest struct {
+	Input BackfillInput `json:"input"`
+	// Params map the parameters the flow declares to their values;
+	// parameters left out take their defaults
+	Params map[string]string `json:"params,omitempty"`
+	// Timeline records every message going through a node, served by
+	// /runs/{run}/timeline
+	Timeline bool `json:"timeline,omitempty"`
+	// Breakpoints are the nodes messages stop before, starting the
+	// backfill in debug mode
+	Breakpoints []string `json:"breakpoints,omitempty"`
+}
+
+// Backfill is a run of a stored flow over bounded input
+type Backfill struct {
+	// ID identifies the run, whose node logs are also served by
+	// /runs/{run}/logs
+	ID     string `json:"id"`
+	FlowID string `json:"flow_id"`
+	// Project is the project of the flow, whose quota bounds the run
+	Project string `json:"project,omitempty"`
+	// Simulation marks runs feeding the flow generated data instead of
+	// production input; they leave the status of the flow alone and do
+	// not count toward its concurrency
+	Simulation bool `json:"simulation,omitempty"`
+	// Status is running until the input went through, then completed. It
+	// is failed when the input could not be read, and stopped when the
+	// flow was stopped or deleted first. Backfills started while their
+	// flow runs as many backfills as it may are queued until one ends.
+	Status   string            `json:"status"`
+	Input    BackfillInput     `json:"input"`
+	Params   map[string]string `json:"params,omitempty"`
+	Progress BackfillProgress  `json:"progress"`
+	// NodeFailures counts the times a node failed on a message
+	NodeFailures int `json:"node_failures"`
+	// Coalesced counts the backfills merged into this queued one, by the
+	// coalesce overflow policy
+	Coalesced int `json:"coalesced,omitempty"`
+	// Error tells why a failed run failed
+	Error string `json:"error,omitempty"`
+	// StartedAt is when the run started, or was queued while it waits
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// SimulationRequest holds the parameter values of a simulation
+type SimulationRequest struct {
+	// Params map the parameters the flow declares to their values;
+	// parameters left out take their defaults
+	Params map[string]string `json:"params,omitempty"`
+	// Timeline records every message going through a node, served by
+	// /runs/{run}/timeline
+	Timeline bool `json:"timeline,omitempty"`
+	// Breakpoints are the nodes messages stop before, starting the
+	// simulation in debug mode
+	Breakpoints []string `json:"breakpoints,omitempty"`
+}
+
+// BackfillInput is the bounded input of a backfill. Either Files is set, or one or
+// both of Query and Range.
+type BackfillInput struct {
+	// Files are the paths of files holding one message per line, read in
+	// order, relative to the data directory of the server. Lines that are
+	// not JSON are sent as JSON strings.
+	Files []string `json:"files,omitempty"`
+	// Query sends each row of a query as an object of its columns
+	Query *BackfillQuery `json:"query,omitempty"`
+	// Range splits a time range into windows. Alone, it sends each window
+	// as an object of its from and to bounds; with Query, the query runs
+	// once per window, with the bounds as its two arguments.
+	Range *BackfillRange `json:"range,omitempty"`
+}
+
+// BackfillQuery is a SQL query of a database the server was configured
+// with
+type BackfillQuery struct {
+	// Database names the database, one of the lookup databases of the
+	// server
+	Database string `json:"database"`
+	SQL      string `json:"sql"`
+}
+
+// BackfillRange is the time range from From up to To, split into windows
+// of StepSeconds, or of a day when zero
+type BackfillRange struct {
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	StepSeconds int       `json:"step_seconds,omitempty"`
+}
+
+// Validate reports the first problem of the input, if any
+func (in BackfillInput) Validate() error {
+	if len(in.Files) > 0 {
+		if in.Query != nil || in.Range != nil {
+			return errors.New("files cannot be combined with a query or a range")
+		}
+		for _, path := range in.Files {
+			if path == "" {
+				return errors.New("file paths must not be empty")
+			}
+			if !filepath.IsLocal(path) {
+				return errors.New("file paths must be relative and stay in the data directory")
+			}
+		}
+		return nil
+	}
+	if in.Query == nil && in.Range == nil {
+		return errors.New("one of files, query and range must be set")
+	}
+	if in.Query != nil {
+		if in.Query.Database == "" || in.Query.SQL == "" {
+			return errors.New("query database and sql must be set")
+		}
+	}
+	if in.Range != nil {
+		if !in.Range.From.Before(in.Range.To) {
+			return errors.New("range from must be before to")
+		}
+		if in.Range.StepSeconds < 0 {
+			return errors.New("range step_seconds must not be negative")
+		}
+	}
+	return nil
+}
+
+// BackfillProgress measures how far a backfill went
+type BackfillProgress struct {
+	// Done and Total are the input read and all of it, in bytes of files,
+	// in rows of queries or in windows of ranges
+	Done  int64 `json:"done"`
+	Total int64 `json:"total"`
+	// Percent is Done as a percentage of Total
+	Percent float64 `json:"percent"`
+	// Messages is the number of messages sent to the flow
+	Messages int64 `json:"messages"`
+}
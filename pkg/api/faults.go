@@ -0,0 +1,51 @@
+package api
+
+import "fmt"
+
+// Faults configures the failures injected into a flow, to check how it and
+// the systems around it cope. Rates are the probability, from 0 to 1, of
+// each fault hitting a message at a node.
+type Faults struct {
+	// Nodes limits the faults to these nodes; all nodes when empty
+	Nodes []string `json:"nodes,omitempty"`
+	// ErrorRate fails nodes with an injected error
+	ErrorRate float64 `json:"error_rate,omitempty"`
+	// DropRate drops messages as if nodes had filtered them out
+	DropRate float64 `json:"drop_rate,omitempty"`
+	// CorruptRate truncates the data nodes pass on, so that the nodes
+	// receiving it get malformed JSON
+	CorruptRate float64 `json:"corrupt_rate,omitempty"`
+	// LatencyRate delays nodes by LatencyMs before they process messages
+	LatencyRate float64 `json:"latency_rate,omitempty"`
+	LatencyMs   int     `json:"latency_ms,omitempty"`
+	// Seed makes the faults repeatable; zero seeds from the clock
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// Validate checks that the rates are probabilities and the latency is not
+// negative
+func (f Faults) Validate() error {
+	rates := []struct {
+		name string
+		rate float64
+	}{
+		{"error_rate", f.ErrorRate},
+		{"drop_rate", f.DropRate},
+		{"corrupt_rate", f.CorruptRate},
+		{"latency_rate", f.LatencyRate},
+	}
+	for _, r := range rates {
+		if r.rate < 0 || r.rate > 1 {
+			return fmt.Errorf("invalid %s %v: must be between 0 and 1", r.name, r.rate)
+		}
+	}
+	if f.LatencyMs < 0 {
+		return fmt.Errorf("invalid latency_ms %d: cannot be negative", f.LatencyMs)
+	}
+	return nil
+}
+
+// Enabled reports whether f injects any fault
+func (f Faults) Enabled() bool {
+	return f.ErrorRate > 0 || f.DropRate > 0 || f.CorruptRate > 0 || (f.LatencyRate > 0 && f.LatencyMs > 0)
+}
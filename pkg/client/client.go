@@ -0,0 +1,287 @@
+/*
+Package client is the Go SDK for the Flow Control HTTP API, so that other
+services can manage flows and follow their events without hand-rolled HTTP
+requests:
+
+	c := client.New("http://localhost:8080", client.WithAPIKey(key))
+	flow, err := c.CreateFlow(ctx, &client.Flow{ID: "orders", Name: "Orders", Config: source})
+	...
+	_, err = c.StartFlow(ctx, "orders", nil)
+	...
+	err = c.StreamEvents(ctx, client.EventFilter{FlowID: "orders"}, func(e client.Event) error {
+		log.Println(e.Type, e.Message)
+		return nil
+	})
+
+Requests the server refused for the time being, with 429 or 503, are
+retried with exponential backoff, waiting as long as its Retry-After header
+asks. Requests that failed on the way or at a gateway, which the server may
+have acted on, are retried only when repeating them is harmless: GET, PUT
+and DELETE requests. Event streams reconnect whenever they break.
+
+Error statuses are returned as an *APIError.
+*/
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"flow-control/internal/types"
+	"flow-control/pkg/api"
+)
+
+type (
+	// Flow is a flow stored on the server
+	Flow = types.RuntimeFlow
+	// Event is an event published by the server, such as a flow starting
+	Event = types.FlowEvent
+	// ValidateResult is the outcome of checking flow source
+	ValidateResult = api.ValidateResult
+	// DryRunRequest is flow source and the messages to run it on
+	DryRunRequest = api.DryRunRequest
+	// DryRunResult is the outcome of a dry run
+	DryRunResult = api.DryRunResult
+	// BackfillRequest is the input and parameters of a backfill
+	BackfillRequest = api.BackfillRequest
+	// SimulationRequest is the parameters of a simulation
+	SimulationRequest = api.SimulationRequest
+	// Backfill is a backfill or simulation and its progress
+	Backfill = api.Backfill
+)
+
+// Retry defaults
+const (
+	// DefaultRetries is the number of times a failed request is retried
+	DefaultRetries = 3
+	// DefaultBackoff is the wait before the first retry, doubling with
+	// each retry
+	DefaultBackoff = 200 * time.Millisecond
+	// maxBackoff caps the wait between retries and reconnections
+	maxBackoff = 30 * time.Second
+)
+
+// APIError is returned for responses with an error status
+type APIError struct {
+	StatusCode int
+	Message    string
+	// RetryAfter is the wait the server asked for before trying again, zero
+	// when it did not
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("%s (%d)", e.Message, e.StatusCode)
+}
+
+// StatusCode returns the status of the response that caused err, or 0 if
+// err is not an *APIError
+func StatusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// Client calls the HTTP API of a Flow Control server. It is safe for
+// concurrent use.
+type Client struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+	// stream is http without its timeout, for event streams
+	stream  *http.Client
+	retries int
+	backoff time.Duration
+}
+
+// options configures a Client
+type options struct {
+	apiKey  string
+	http    *http.Client
+	retries int
+	backoff time.Duration
+}
+
+// Option configures a Client
+type Option func(*options)
+
+// WithAPIKey sends key as a bearer token, for servers requiring API keys
+func WithAPIKey(key string) Option {
+	return func(o *options) {
+		o.apiKey = key
+	}
+}
+
+// WithHTTPClient sends requests with hc instead of a client with a 30
+// second timeout. Event streams use a copy of hc without its timeout.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(o *options) {
+		o.http = hc
+	}
+}
+
+// WithRetries retries failed requests up to retries times, waiting backoff
+// before the first retry and twice as long before each next one. Zero
+// retries turns retrying off; a zero backoff keeps DefaultBackoff.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retries = retries
+		if backoff > 0 {
+			o.backoff = backoff
+		}
+	}
+}
+
+// New creates a client for the server at baseURL, such as
+// http://localhost:8080
+func New(baseURL string, opts ...Option) *Client {
+	o := &options{
+		http:    &http.Client{Timeout: 30 * time.Second},
+		retries: DefaultRetries,
+		backoff: DefaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	stream := *o.http
+	stream.Timeout = 0
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  o.apiKey,
+		http:    o.http,
+		stream:  &stream,
+		retries: o.retries,
+		backoff: o.backoff,
+	}
+}
+
+// do sends a request to path below /api, encoding in as the JSON body when
+// it is not nil and decoding the JSON response into out when it is not nil
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	resp, err := c.send(ctx, c.http, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// send performs a request, retrying it as the package documentation
+// describes. Error statuses are returned as an *APIError.
+func (c *Client) send(ctx context.Context, hc *http.Client, method, path string, body []byte) (*http.Response, error) {
+	wait := c.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.attempt(ctx, hc, method, path, body)
+		if err == nil || attempt >= c.retries || ctx.Err() != nil || !retryable(method, err) {
+			return resp, err
+		}
+
+		delay := wait
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			delay = min(apiErr.RetryAfter, maxBackoff)
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+		wait = min(wait*2, maxBackoff)
+	}
+}
+
+// attempt performs a request once
+func (c *Client) attempt(ctx context.Context, hc *http.Client, method, path string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+"/api"+path, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %s %s: %w", method, path, err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		apiErr := &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// retryable reports whether a request with method that failed with err may
+// be sent again
+func retryable(method string, err error) bool {
+	idempotent := method == http.MethodGet || method == http.MethodPut || method == http.MethodDelete
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		// The request may have reached the server before the connection
+		// failed
+		return idempotent
+	}
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// sleep waits for d, or returns the error of ctx once it is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// flowPath returns the API path of a flow, escaping its ID
+func flowPath(id string, parts ...string) string {
+	return "/flows/" + strings.Join(append([]string{url.PathEscape(id)}, parts...), "/")
+}
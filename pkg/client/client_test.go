@@ -0,0 +1,142 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"flow-control/internal/testsupport"
+	"flow-control/pkg/client"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	h := testsupport.New(t, testsupport.WithAPIKeys("key"))
+	c := client.New(h.URL, client.WithAPIKey("key"))
+
+	created, err := c.CreateFlow(ctx, &client.Flow{ID: "orders", Name: "Orders", Config: "{}"})
+	require.NoError(t, err)
+	require.Equal(t, "orders", created.ID)
+	flows, err := c.ListFlows(ctx)
+	require.NoError(t, err)
+	require.Len(t, flows, 1)
+
+	// Events published once the stream is open reach the handler, whose
+	// error ends the stream
+	received := make(chan client.Event, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.StreamEvents(ctx, client.EventFilter{FlowID: "orders", Types: []string{"flow.started"}}, func(e client.Event) error {
+			received <- e
+			return errors.New("enough")
+		})
+	}()
+	require.Eventually(t, func() bool {
+		// The stream may not be open yet, so the flow is restarted until
+		// its event arrives
+		_, _ = c.StartFlow(ctx, "orders", nil)
+		select {
+		case e := <-received:
+			require.Equal(t, "orders", e.FlowID)
+			return true
+		default:
+			_, _ = c.StopFlow(ctx, "orders")
+			return false
+		}
+	}, 5*time.Second, 50*time.Millisecond)
+	require.EqualError(t, <-done, "enough")
+
+	_, err = c.GetFlow(ctx, "missing")
+	require.Equal(t, http.StatusNotFound, client.StatusCode(err))
+
+	// Requests without the API key are rejected and not retried
+	_, err = client.New(h.URL).ListFlows(ctx)
+	require.Equal(t, http.StatusUnauthorized, client.StatusCode(err))
+	err = client.New(h.URL).StreamEvents(ctx, client.EventFilter{}, func(client.Event) error { return nil })
+	require.Equal(t, http.StatusUnauthorized, client.StatusCode(err))
+}
+
+func TestRetries(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var calls, status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			http.Error(w, "busy", int(status.Load()))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(client.Flow{ID: "orders"})
+	}))
+	defer srv.Close()
+	c := client.New(srv.URL, client.WithRetries(2, time.Millisecond))
+
+	// Refused requests are retried whatever their method
+	flow, err := c.StartFlow(ctx, "orders", nil)
+	require.NoError(t, err)
+	require.Equal(t, "orders", flow.ID)
+	require.Equal(t, int32(3), calls.Load())
+
+	// Requests failing at a gateway are only retried when harmless
+	status.Store(http.StatusBadGateway)
+	calls.Store(0)
+	_, err = c.StartFlow(ctx, "orders", nil)
+	require.Equal(t, http.StatusBadGateway, client.StatusCode(err))
+	require.Equal(t, int32(1), calls.Load())
+	calls.Store(0)
+	_, err = c.GetFlow(ctx, "orders")
+	require.NoError(t, err)
+	require.Equal(t, int32(3), calls.Load())
+
+	// Retries run out
+	calls.Store(0)
+	_, err = client.New(srv.URL, client.WithRetries(1, time.Millisecond)).GetFlow(ctx, "orders")
+	require.Equal(t, http.StatusBadGateway, client.StatusCode(err))
+	require.Equal(t, int32(2), calls.Load())
+}
+
+func TestStreamReconnects(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Each stream sends one event and ends
+	var streams atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := streams.Add(1)
+		if r.URL.Path != "/api/events" || n == 2 {
+			http.Error(w, "restarting", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, ": keep-alive\n\ndata: {\"flow_id\":\"f%d\",\"type\":\"flow.started\"}\n\n", n)
+	}))
+	defer srv.Close()
+
+	c := client.New(srv.URL, client.WithRetries(0, time.Millisecond))
+	var flows []string
+	err := c.StreamEvents(ctx, client.EventFilter{}, func(e client.Event) error {
+		flows = append(flows, e.FlowID)
+		if len(flows) == 2 {
+			return errors.New("enough")
+		}
+		return nil
+	})
+	require.EqualError(t, err, "enough")
+	require.Equal(t, []string{"f1", "f3"}, flows)
+
+	// Streams stop with their context
+	ctx, cancel = context.WithCancel(ctx)
+	cancel()
+	err = c.StreamEvents(ctx, client.EventFilter{}, func(client.Event) error { return nil })
+	require.ErrorIs(t, err, context.Canceled)
+}
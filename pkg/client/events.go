@@ -0,0 +1,96 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// EventFilter narrows the events streamed to those of one flow or of some
+// types; the zero filter streams every event
+type EventFilter struct {
+	FlowID string
+	Types  []string
+}
+
+// path returns the API path of the events matching f
+func (f EventFilter) path() string {
+	path := "/events"
+	if f.FlowID != "" {
+		path = flowPath(f.FlowID, "events")
+	}
+	if len(f.Types) > 0 {
+		path += "?" + url.Values{"type": f.Types}.Encode()
+	}
+	return path
+}
+
+// stopStream carries the errors that end an event stream instead of
+// reconnecting it
+type stopStream struct {
+	err error
+}
+
+func (e *stopStream) Error() string { return e.err.Error() }
+
+// StreamEvents passes the events matching filter to fn as the server
+// publishes them, until ctx is done or fn fails, and returns the error of
+// either. Streams that break are opened again, waiting as between retries;
+// events published while the stream was down are missed. Error statuses
+// other than those worth retrying end the stream as an *APIError.
+func (c *Client) StreamEvents(ctx context.Context, filter EventFilter, fn func(Event) error) error {
+	path := filter.path()
+	wait := c.backoff
+	for {
+		resp, err := c.attempt(ctx, c.stream, http.MethodGet, path, nil)
+		if err == nil {
+			// The wait starts over once the server took the stream
+			wait = c.backoff
+			err = readEvents(resp.Body, fn)
+			resp.Body.Close()
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var stop *stopStream
+		if errors.As(err, &stop) {
+			return stop.err
+		}
+		if err != nil && !retryable(http.MethodGet, err) {
+			return fmt.Errorf("failed to stream events: %w", err)
+		}
+
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+		wait = min(wait*2, maxBackoff)
+	}
+}
+
+// readEvents decodes the data of each server-sent event in r and passes it
+// to fn, until r ends or fn fails. Errors of fn and undecodable events are
+// returned as a *stopStream.
+func readEvents(r io.Reader, fn func(Event) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return &stopStream{fmt.Errorf("failed to decode event: %w", err)}
+		}
+		if err := fn(event); err != nil {
+			return &stopStream{err}
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"flow-control/pkg/api"
+)
+
+// ListFlows returns the flows carrying every one of tags, or all flows
+func (c *Client) ListFlows(ctx context.Context, tags ...string) ([]*Flow, error) {
+	path := "/flows/"
+	if len(tags) > 0 {
+		path += "?" + url.Values{"tag": tags}.Encode()
+	}
+	var flows []*Flow
+	if err := c.do(ctx, http.MethodGet, path, nil, &flows); err != nil {
+		return nil, err
+	}
+	return flows, nil
+}
+
+// GetFlow returns the flow with id
+func (c *Client) GetFlow(ctx context.Context, id string) (*Flow, error) {
+	var flow Flow
+	if err := c.do(ctx, http.MethodGet, flowPath(id), nil, &flow); err != nil {
+		return nil, err
+	}
+	return &flow, nil
+}
+
+// CreateFlow creates flow and returns it as saved
+func (c *Client) CreateFlow(ctx context.Context, flow *Flow) (*Flow, error) {
+	var created Flow
+	if err := c.do(ctx, http.MethodPost, "/flows/", flow, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdateFlow replaces the flow with the ID of flow, which must carry the
+// revision it is based on, and returns it as saved
+func (c *Client) UpdateFlow(ctx context.Context, flow *Flow) (*Flow, error) {
+	var updated Flow
+	if err := c.do(ctx, http.MethodPut, flowPath(flow.ID), flow, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// DeleteFlow deletes the flow with id
+func (c *Client) DeleteFlow(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, flowPath(id), nil, nil)
+}
+
+// StartFlow starts the flow with id with the values of its parameters, if
+// any, and returns it with its new status
+func (c *Client) StartFlow(ctx context.Context, id string, params map[string]string) (*Flow, error) {
+	var flow Flow
+	if err := c.do(ctx, http.MethodPost, flowPath(id, "start"), api.StartFlowRequest{Params: params}, &flow); err != nil {
+		return nil, err
+	}
+	return &flow, nil
+}
+
+// StopFlow stops the flow with id and returns it with its new status
+func (c *Client) StopFlow(ctx context.Context, id string) (*Flow, error) {
+	var flow Flow
+	if err := c.do(ctx, http.MethodPost, flowPath(id, "stop"), nil, &flow); err != nil {
+		return nil, err
+	}
+	return &flow, nil
+}
+
+// Validate checks flow source without saving it
+func (c *Client) Validate(ctx context.Context, source string) (*ValidateResult, error) {
+	var result ValidateResult
+	if err := c.do(ctx, http.MethodPost, "/flows/validate", api.ValidateRequest{Source: source}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// DryRun runs flow source in the server's engine on sample messages
+func (c *Client) DryRun(ctx context.Context, req DryRunRequest) (*DryRunResult, error) {
+	var result DryRunResult
+	if err := c.do(ctx, http.MethodPost, "/flows/run", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// StartBackfill starts a backfill of the flow with id
+func (c *Client) StartBackfill(ctx context.Context, id string, req BackfillRequest) (*Backfill, error) {
+	var run Backfill
+	if err := c.do(ctx, http.MethodPost, flowPath(id, "backfills"), req, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// StartSimulation starts a simulation of the flow with id
+func (c *Client) StartSimulation(ctx context.Context, id string, req SimulationRequest) (*Backfill, error) {
+	var run Backfill
+	if err := c.do(ctx, http.MethodPost, flowPath(id, "simulations"), req, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Backfill returns the backfill runID of the flow with id
+func (c *Client) Backfill(ctx context.Context, id, runID string) (*Backfill, error) {
+	var run Backfill
+	if err := c.do(ctx, http.MethodGet, flowPath(id, "backfills", url.PathEscape(runID)), nil, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// Backfills lists the backfills of the flow with id run since the server
+// started
+func (c *Client) Backfills(ctx context.Context, id string) ([]*Backfill, error) {
+	var runs []*Backfill
+	if err := c.do(ctx, http.MethodGet, flowPath(id, "backfills"), nil, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
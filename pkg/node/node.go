@@ -0,0 +1,85 @@
+/*
+Package node is the SDK for writing custom node types outside this module.
+It exposes the node contract and the engine's registry under stable names,
+so that a custom node only needs to embed Base and implement Process:
+
+	type Upper struct {
+		node.Base
+	}
+
+	func (n *Upper) Process(ctx context.Context, msg node.Message) (node.Message, error) {
+		...
+	}
+
+	registry := node.NewRegistry()
+	registry.Register("Upper", func(cfg node.Config) (node.Node, error) {
+		return &Upper{Base: node.Base{Config: cfg}}, nil
+	})
+
+Nodes log through GetLogs. Entries are tagged with the flow, node and run
+and captured for the run, so that they can be listed per run.
+
+Describing the settings of a type publishes them in the node catalog, as a
+JSON Schema editors render config forms from:
+
+	registry.Describe("Upper", "Upper-cases a field",
+		node.Setting{Name: "field", Type: "string", Required: true},
+	)
+*/
+package node
+
+import (
+	"io"
+
+	"flow-control/internal/runtime/engine"
+	"flow-control/internal/types"
+)
+
+type (
+	// Node is the interface every node type implements
+	Node = types.Node
+	// Config is a node's ID, type and settings from the flow definition
+	Config = types.NodeConfig
+	// Message is a message passed between nodes
+	Message = types.Message
+	// Base implements every method of Node except Process
+	Base = engine.BaseNode
+	// Factory creates a node from its configuration
+	Factory = engine.Factory
+	// Registry maps node types to the factories creating them
+	Registry = engine.Registry
+	// Setting describes a setting of a node type for the node catalog
+	Setting = engine.Setting
+	// Log is the log of a node, returned by its GetLogs method
+	Log = types.LogPort
+	// LogLevel is the level of a log entry
+	LogLevel = types.LogLevel
+	// Fields are the fields of a log entry
+	Fields = types.Fields
+)
+
+// Log levels
+const (
+	LogLevelDebug = types.LogLevelDebug
+	LogLevelInfo  = types.LogLevelInfo
+	LogLevelWarn  = types.LogLevelWarn
+	LogLevelError = types.LogLevelError
+)
+
+// ErrDrop is returned by Process to discard a message without failing
+var ErrDrop = engine.ErrDrop
+
+// LogWriter returns a writer logging each line written to it in log, such
+// as the node's own log from GetLogs. It captures the stdout and stderr of
+// the processes a node runs in the logs of the run:
+//
+//	cmd.Stdout = node.LogWriter(n.GetLogs(), node.LogLevelInfo, nil)
+//	cmd.Stderr = node.LogWriter(n.GetLogs(), node.LogLevelWarn, nil)
+func LogWriter(log Log, level LogLevel, fields Fields) io.WriteCloser {
+	return engine.LogWriter(log, level, fields)
+}
+
+// NewRegistry creates a registry holding the built-in node types
+func NewRegistry() *Registry {
+	return engine.NewRegistry()
+}
@@ -31,7 +31,7 @@ build_app() {
     go mod tidy
     
     # Build with CGO enabled for SQLite support
-    CGO_ENABLED=1 go build -o flow-control ./cmd/flowcontrol
+    CGO_ENABLED=1 go build -tags sqlite_fts5 -o flow-control ./cmd/flowcontrol
     
     log_info "Build complete!"
 }